@@ -0,0 +1,475 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+var (
+	// regionIDPattern matches the ID of a region, which prefixes the IDs of its jobs
+	regionIDPattern = regexp.MustCompile(`^[a-z][a-z0-9]{1,15}$`)
+
+	// cloudTasksRegionPattern matches a Google Cloud region such as europe-west1
+	cloudTasksRegionPattern = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+
+	// bucketNamePattern matches the name of a Cloud Storage bucket
+	bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,61}[a-z0-9]$`)
+
+	// firestoreDatabasePattern matches the ID of a named Firestore database
+	firestoreDatabasePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{2,61}[a-z0-9]$`)
+)
+
+// multiRegions are the Cloud Storage multi-regions and predefined dual-regions
+// by the prefix or the regions of the Google Cloud regions they contain
+var multiRegions = map[string][]string{
+	"US":    {"us-"},
+	"EU":    {"europe-"},
+	"ASIA":  {"asia-"},
+	"NAM4":  {"us-central1", "us-east1"},
+	"EUR4":  {"europe-north1", "europe-west4"},
+	"ASIA1": {"asia-northeast1", "asia-northeast2"},
+}
+
+// Region is where the jobs of the workspaces tagged with it are stored and
+// processed, for customers whose data has to stay in a jurisdiction
+type Region struct {
+	ID                  string
+	CloudTasksRegion    string // Region of the Cloud Tasks queue, such as europe-west1
+	BucketName          string // Bucket the uploads and results are stored in
+	BucketLocation      string // Location the bucket has to be in, checked at startup, empty to not check it
+	FirestoreDatabaseID string // Database the jobs, results and decks are stored in
+	KMSKey              string // Cloud KMS key uploads are encrypted with, in the location of the bucket, empty for Google-managed keys
+	SlidesServiceURL    string // Slides service deployed with the bucket and database of the region
+}
+
+// LocationContains reports whether a Cloud Storage location, such as EU,
+// EUR4 or europe-west1, contains a Google Cloud region
+func LocationContains(location, region string) bool {
+	if strings.EqualFold(location, region) {
+		return true
+	}
+	for _, contained := range multiRegions[strings.ToUpper(location)] {
+		if region == contained || (strings.HasSuffix(contained, "-") && strings.HasPrefix(region, contained)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Config holds the API configuration read from the environment
+type Config struct {
+	ProjectID                string                        // GOOGLE_CLOUD_PROJECT
+	FirebaseProjectID        string                        // FIREBASE_PROJECT_ID, project whose ID tokens are accepted, defaults to GOOGLE_CLOUD_PROJECT
+	CloudTasksRegion         string                        // CLOUD_TASKS_REGION
+	CloudTasksQueue          string                        // CLOUD_TASKS_QUEUE_ID
+	SlidesServiceURL         string                        // SLIDES_SERVICE_URL
+	BucketName               string                        // GCS_BUCKET_NAME
+	BucketLocation           string                        // GCS_BUCKET_LOCATION, location such as US or us-central1 GCS_BUCKET_NAME is checked to be in at startup, empty to not check it
+	FirestoreDatabaseID      string                        // FIRESTORE_DATABASE_ID, database of the default region, which also keeps API keys, workspaces and settings
+	DefaultRegion            string                        // DEFAULT_REGION, ID of the region of the settings above, used by workspaces without a region
+	Regions                  []Region                      // REGIONS, comma-separated IDs of more regions such as eu, each set with REGION_<ID>_* variables, after the default region
+	Port                     string                        // PORT
+	FrontendOrigins          []string                      // FRONTEND_URL, comma-separated origins that may use wildcard subdomains like https://*.example.com
+	PublicAPIURL             string                        // PUBLIC_API_URL, empty to build share links from the request host
+	AnonymousDailyJobLimit   int                           // ANONYMOUS_DAILY_JOB_LIMIT, jobs per day for requests without an API key, 0 for no limit
+	AbuseStrikeLimit         int                           // ABUSE_STRIKE_LIMIT, strikes per hour that block an IP address or API key, 0 to never block
+	RecipientDailyEmailLimit int                           // RECIPIENT_DAILY_EMAIL_LIMIT, notification emails per day sent to one address, 0 for no limit
+	ClientIPSecret           string                        // CLIENT_IP_SECRET, key of at least 32 characters the IP addresses of clients and notified addresses are fingerprinted with, required with any of the limits above
+	TrustedProxies           []string                      // TRUSTED_PROXIES, comma-separated addresses or CIDR ranges of the proxies X-Forwarded-For is read from, the Cloud Run front end and Google Cloud load balancers by default
+	StripeSecretKey          string                        // STRIPE_SECRET_KEY, empty to disable billing and plan limits
+	StripeWebhookSecret      string                        // STRIPE_WEBHOOK_SECRET, required with STRIPE_SECRET_KEY
+	StripePriceIDs           map[string]string             // STRIPE_PRICE_PRO and STRIPE_PRICE_TEAM, by plan ID
+	BillingReturnURL         string                        // BILLING_RETURN_URL, page Stripe Checkout returns to
+	SchedulerServiceAccount  string                        // SCHEDULER_SERVICE_ACCOUNT, account Cloud Scheduler runs schedules and file cleanup as, empty to disable them
+	GoogleOAuthClientID      string                        // GOOGLE_OAUTH_CLIENT_ID, OAuth client used to connect Google Drive, empty to disable Drive input
+	GoogleOAuthClientSecret  string                        // GOOGLE_OAUTH_CLIENT_SECRET, required with GOOGLE_OAUTH_CLIENT_ID
+	DriveReturnURL           string                        // DRIVE_RETURN_URL, page users return to after connecting Drive
+	GCSKMSKey                string                        // GCS_KMS_KEY, Cloud KMS key uploaded files are encrypted with, empty for Google-managed keys
+	TaskSigningSecret        string                        // TASK_SIGNING_SECRET, shared with the slides service to sign tasks, empty to rely on OIDC alone
+	DownloadURLSecret        string                        // DOWNLOAD_URL_SECRET, key of at least 32 characters download URLs of results are signed with, empty to disable them
+	SSEHeartbeatInterval     time.Duration                 // SSE_HEARTBEAT_INTERVAL, idle time before a status stream sends a keepalive comment, such as 15s
+	TokenPricePerMillion     float64                       // TOKEN_PRICE_PER_MILLION, USD charged per million Gemini tokens, quoted by cost estimates when billing is enabled
+	AdminUIDs                []string                      // ADMIN_UIDS, comma-separated Firebase UIDs of the users who may use the admin endpoints, such as theme uploads and job captures
+	PlanTokenLimits          map[string]models.TokenLimits // PLAN_TOKEN_LIMITS, comma-separated plan=input:output token limits overriding the slides service's, such as pro=65536:8192, an empty limit keeps the service's
+	CaptchaProvider          string                        // CAPTCHA_PROVIDER, turnstile or hcaptcha to require a solved CAPTCHA for anonymous jobs, empty to disable
+	CaptchaSecretKey         string                        // CAPTCHA_SECRET_KEY, required with CAPTCHA_PROVIDER
+	FeatureFlags             map[string]bool               // FEATURE_FLAGS, comma-separated flags to turn on for every workspace, or off with a name=off entry, such as new_themes,chunked_mode=off
+}
+
+// Load reads the configuration from the environment and validates it. The
+// error lists every missing or invalid variable so they can be fixed at once.
+func Load() (*Config, error) {
+	l := &loader{}
+
+	cfg := &Config{
+		ProjectID:                l.required("GOOGLE_CLOUD_PROJECT"),
+		CloudTasksRegion:         l.optional("CLOUD_TASKS_REGION", "us-central1"),
+		CloudTasksQueue:          l.optional("CLOUD_TASKS_QUEUE_ID", "slides-generation-queue"),
+		SlidesServiceURL:         l.url(l.required("SLIDES_SERVICE_URL"), "SLIDES_SERVICE_URL"),
+		BucketName:               l.optional("GCS_BUCKET_NAME", "slideitin-files"),
+		Port:                     l.port(l.optional("PORT", "8080")),
+		FrontendOrigins:          l.origins(l.optional("FRONTEND_URL", "http://localhost:3000"), "FRONTEND_URL"),
+		PublicAPIURL:             strings.TrimSuffix(l.url(os.Getenv("PUBLIC_API_URL"), "PUBLIC_API_URL"), "/"),
+		AnonymousDailyJobLimit:   l.count(l.optional("ANONYMOUS_DAILY_JOB_LIMIT", "10"), "ANONYMOUS_DAILY_JOB_LIMIT"),
+		AbuseStrikeLimit:         l.count(l.optional("ABUSE_STRIKE_LIMIT", "50"), "ABUSE_STRIKE_LIMIT"),
+		RecipientDailyEmailLimit: l.count(l.optional("RECIPIENT_DAILY_EMAIL_LIMIT", "10"), "RECIPIENT_DAILY_EMAIL_LIMIT"),
+		SSEHeartbeatInterval:     l.duration(l.optional("SSE_HEARTBEAT_INTERVAL", "30s"), "SSE_HEARTBEAT_INTERVAL"),
+	}
+
+	// Clients are told apart by an IP address only proxies in front of the API can vouch for
+	cfg.TrustedProxies = l.proxies(l.optional("TRUSTED_PROXIES", "169.254.0.0/16,35.191.0.0/16,130.211.0.0/22"), "TRUSTED_PROXIES")
+	if cfg.AnonymousDailyJobLimit > 0 || cfg.AbuseStrikeLimit > 0 || cfg.RecipientDailyEmailLimit > 0 {
+		cfg.ClientIPSecret = l.secret(l.required("CLIENT_IP_SECRET"), "CLIENT_IP_SECRET")
+	}
+
+	cfg.FirebaseProjectID = strings.TrimSpace(os.Getenv("FIREBASE_PROJECT_ID"))
+	if cfg.FirebaseProjectID == "" {
+		cfg.FirebaseProjectID = cfg.ProjectID
+	}
+
+	// Billing is optional, but needs every Stripe setting once enabled
+	cfg.StripeSecretKey = os.Getenv("STRIPE_SECRET_KEY")
+	if cfg.StripeSecretKey != "" {
+		cfg.StripeWebhookSecret = l.required("STRIPE_WEBHOOK_SECRET")
+		cfg.StripePriceIDs = map[string]string{
+			"pro":  l.required("STRIPE_PRICE_PRO"),
+			"team": l.required("STRIPE_PRICE_TEAM"),
+		}
+		cfg.BillingReturnURL = l.url(l.required("BILLING_RETURN_URL"), "BILLING_RETURN_URL")
+	}
+
+	// Scheduled runs are authenticated with an OIDC token for the public URL
+	cfg.SchedulerServiceAccount = strings.TrimSpace(os.Getenv("SCHEDULER_SERVICE_ACCOUNT"))
+	if cfg.SchedulerServiceAccount != "" && cfg.PublicAPIURL == "" {
+		l.missing = append(l.missing, "PUBLIC_API_URL")
+	}
+
+	// Drive input needs the OAuth client and a public URL for its callback
+	cfg.GoogleOAuthClientID = strings.TrimSpace(os.Getenv("GOOGLE_OAUTH_CLIENT_ID"))
+	if cfg.GoogleOAuthClientID != "" {
+		cfg.GoogleOAuthClientSecret = l.required("GOOGLE_OAUTH_CLIENT_SECRET")
+		cfg.DriveReturnURL = l.url(l.required("DRIVE_RETURN_URL"), "DRIVE_RETURN_URL")
+		if cfg.PublicAPIURL == "" {
+			l.missing = append(l.missing, "PUBLIC_API_URL")
+		}
+	}
+
+	// Anonymous jobs need a solved CAPTCHA once a provider is set
+	cfg.CaptchaProvider = strings.ToLower(strings.TrimSpace(os.Getenv("CAPTCHA_PROVIDER")))
+	if cfg.CaptchaProvider != "" {
+		if cfg.CaptchaProvider != "turnstile" && cfg.CaptchaProvider != "hcaptcha" {
+			l.invalid = append(l.invalid, fmt.Sprintf("CAPTCHA_PROVIDER must be turnstile or hcaptcha, got %q", cfg.CaptchaProvider))
+		}
+		cfg.CaptchaSecretKey = l.required("CAPTCHA_SECRET_KEY")
+	}
+
+	// Tasks are signed for slides services that check signatures, such as self-hosted ones without Cloud Run
+	cfg.TaskSigningSecret = os.Getenv("TASK_SIGNING_SECRET")
+
+	// Download URLs are signed with a key every instance of the API shares
+	cfg.DownloadURLSecret = l.secret(os.Getenv("DOWNLOAD_URL_SECRET"), "DOWNLOAD_URL_SECRET")
+
+	// Cost estimates quote a price on billed deployments that set one
+	cfg.TokenPricePerMillion = l.price(strings.TrimSpace(os.Getenv("TOKEN_PRICE_PER_MILLION")), "TOKEN_PRICE_PER_MILLION")
+
+	// Plans can send more to Gemini than the slides service's limits, e.g. on paid Gemini tiers
+	cfg.PlanTokenLimits = l.tokenLimits(os.Getenv("PLAN_TOKEN_LIMITS"), "PLAN_TOKEN_LIMITS")
+
+	// Admin endpoints are only open to the listed users
+	for _, uid := range strings.Split(os.Getenv("ADMIN_UIDS"), ",") {
+		if uid = strings.TrimSpace(uid); uid != "" {
+			cfg.AdminUIDs = append(cfg.AdminUIDs, uid)
+		}
+	}
+
+	// Feature flags set here apply to every workspace ahead of their rollout in Firestore
+	cfg.FeatureFlags = l.flags(os.Getenv("FEATURE_FLAGS"), "FEATURE_FLAGS")
+
+	// Uploads are encrypted with a customer-managed key for deployments with compliance requirements
+	cfg.GCSKMSKey = l.kmsKey(strings.TrimSpace(os.Getenv("GCS_KMS_KEY")), "GCS_KMS_KEY")
+
+	// Workspaces tagged with a region keep their jobs in its bucket, queue and database
+	cfg.DefaultRegion = l.optional("DEFAULT_REGION", "us")
+	cfg.BucketLocation = strings.TrimSpace(os.Getenv("GCS_BUCKET_LOCATION"))
+	cfg.FirestoreDatabaseID = l.optional("FIRESTORE_DATABASE_ID", "(default)")
+	cfg.Regions = l.regions(os.Getenv("REGIONS"), Region{
+		ID:                  cfg.DefaultRegion,
+		CloudTasksRegion:    cfg.CloudTasksRegion,
+		BucketName:          cfg.BucketName,
+		BucketLocation:      cfg.BucketLocation,
+		FirestoreDatabaseID: cfg.FirestoreDatabaseID,
+		KMSKey:              cfg.GCSKMSKey,
+		SlidesServiceURL:    cfg.SlidesServiceURL,
+	})
+
+	if err := l.err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loader reads environment variables and collects the problems it finds
+type loader struct {
+	missing []string
+	invalid []string
+}
+
+// required returns the value of a variable that must be set
+func (l *loader) required(key string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		l.missing = append(l.missing, key)
+	}
+	return value
+}
+
+// optional returns the value of a variable, or the default if it isn't set
+func (l *loader) optional(key, defaultValue string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		log.Printf("Warning: %s not set, using default: %s", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// url checks that a non-empty value is an absolute http or https URL
+func (l *loader) url(value, key string) string {
+	if value == "" {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be an http or https URL, got %q", key, value))
+	}
+	return value
+}
+
+// kmsKey checks that a non-empty value is the resource name of a Cloud KMS key
+func (l *loader) kmsKey(value, key string) string {
+	if value == "" {
+		return value
+	}
+	parts := strings.Split(value, "/")
+	if len(parts) != 8 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "keyRings" || parts[6] != "cryptoKeys" {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be a key name like projects/p/locations/l/keyRings/r/cryptoKeys/k, got %q", key, value))
+	}
+	return value
+}
+
+// origins splits a comma-separated list of origins and checks that each one is
+// an http or https URL, with at most a leading *. wildcard in the host
+func (l *loader) origins(value, key string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Contains(u.Host, "*") || u.Path != "" {
+			l.invalid = append(l.invalid, fmt.Sprintf("%s must list http or https origins, got %q", key, origin))
+			continue
+		}
+		origins = append(origins, origin)
+	}
+	return origins
+}
+
+// proxies splits a comma-separated list of proxies and checks that each one is
+// an IP address or CIDR range
+func (l *loader) proxies(value, key string) []string {
+	var proxies []string
+	for _, proxy := range strings.Split(value, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			l.invalid = append(l.invalid, fmt.Sprintf("%s must list IP addresses or CIDR ranges, got %q", key, proxy))
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies
+}
+
+// secret checks that a non-empty value is long enough to sign with, without
+// repeating it in the error
+func (l *loader) secret(value, key string) string {
+	if value != "" && len(value) < 32 {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be at least 32 characters", key))
+	}
+	return value
+}
+
+// port checks that a value is a valid port number
+func (l *loader) port(value string) string {
+	if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+		l.invalid = append(l.invalid, fmt.Sprintf("PORT must be a port number, got %q", value))
+	}
+	return value
+}
+
+// count checks that a value is a non-negative integer
+func (l *loader) count(value, key string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be a non-negative integer, got %q", key, value))
+	}
+	return n
+}
+
+// duration checks that a value is a positive duration such as 30s
+func (l *loader) duration(value, key string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be a positive duration such as 30s, got %q", key, value))
+	}
+	return d
+}
+
+// price checks that a non-empty value is a non-negative amount such as 2.50
+func (l *loader) price(value, key string) float64 {
+	if value == "" {
+		return 0
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount < 0 || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be a non-negative amount such as 2.50, got %q", key, value))
+	}
+	return amount
+}
+
+// tokenLimits parses a comma-separated list of plan=input:output token
+// limits, where an empty limit keeps the slides service's
+func (l *loader) tokenLimits(value, key string) map[string]models.TokenLimits {
+	limits := make(map[string]models.TokenLimits)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		plan, pair, _ := strings.Cut(entry, "=")
+		input, output, hasOutput := strings.Cut(pair, ":")
+		var planLimits models.TokenLimits
+		var inputErr, outputErr error
+		if input != "" {
+			planLimits.Input, inputErr = strconv.Atoi(input)
+		}
+		if output != "" {
+			planLimits.Output, outputErr = strconv.Atoi(output)
+		}
+		if plan == "" || !hasOutput || inputErr != nil || outputErr != nil || planLimits.Input < 0 || planLimits.Output < 0 {
+			l.invalid = append(l.invalid, fmt.Sprintf("%s must list plan=input:output token limits, got %q", key, entry))
+			continue
+		}
+		limits[plan] = planLimits
+	}
+	return limits
+}
+
+// flags parses a comma-separated list of flag names, each optionally followed
+// by =on or =off
+func (l *loader) flags(value, key string) map[string]bool {
+	flags := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, state, hasState := strings.Cut(entry, "=")
+		switch {
+		case name == "":
+			l.invalid = append(l.invalid, fmt.Sprintf("%s must list flag names, got %q", key, entry))
+		case !hasState || state == "on":
+			flags[name] = true
+		case state == "off":
+			flags[name] = false
+		default:
+			l.invalid = append(l.invalid, fmt.Sprintf("%s must turn flags on or off, got %q", key, entry))
+		}
+	}
+	return flags
+}
+
+// regions reads the regions listed in a comma-separated value from their
+// REGION_<ID>_* variables, and checks them along with the default region.
+// Regions can't share a bucket or database, which would mix their data.
+func (l *loader) regions(value string, defaultRegion Region) []Region {
+	regions := []Region{defaultRegion}
+	seen := map[string]bool{defaultRegion.ID: true}
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		prefix := "REGION_" + strings.ToUpper(id) + "_"
+		regions = append(regions, Region{
+			ID:                  id,
+			CloudTasksRegion:    l.required(prefix + "CLOUD_TASKS_REGION"),
+			BucketName:          l.required(prefix + "GCS_BUCKET_NAME"),
+			BucketLocation:      l.required(prefix + "GCS_BUCKET_LOCATION"),
+			FirestoreDatabaseID: l.required(prefix + "FIRESTORE_DATABASE_ID"),
+			KMSKey:              l.kmsKey(strings.TrimSpace(os.Getenv(prefix+"GCS_KMS_KEY")), prefix+"GCS_KMS_KEY"),
+			SlidesServiceURL:    l.url(l.required(prefix+"SLIDES_SERVICE_URL"), prefix+"SLIDES_SERVICE_URL"),
+		})
+	}
+
+	buckets := make(map[string]string)
+	databases := make(map[string]string)
+	for i, region := range regions {
+		prefix := "REGION_" + strings.ToUpper(region.ID) + "_"
+		if i == 0 {
+			prefix = ""
+		}
+		if !regionIDPattern.MatchString(region.ID) {
+			l.invalid = append(l.invalid, fmt.Sprintf("region IDs must be 2 to 16 lowercase letters and digits, got %q", region.ID))
+			continue
+		}
+		if region.CloudTasksRegion != "" && !cloudTasksRegionPattern.MatchString(region.CloudTasksRegion) {
+			l.invalid = append(l.invalid, fmt.Sprintf("%sCLOUD_TASKS_REGION must be a region such as europe-west1, got %q", prefix, region.CloudTasksRegion))
+		}
+		if region.BucketName != "" && !bucketNamePattern.MatchString(region.BucketName) {
+			l.invalid = append(l.invalid, fmt.Sprintf("%sGCS_BUCKET_NAME must be a bucket name, got %q", prefix, region.BucketName))
+		}
+		if region.BucketLocation != "" && region.CloudTasksRegion != "" && !LocationContains(region.BucketLocation, region.CloudTasksRegion) {
+			l.invalid = append(l.invalid, fmt.Sprintf("%sGCS_BUCKET_LOCATION %s doesn't contain the Cloud Tasks region %s", prefix, region.BucketLocation, region.CloudTasksRegion))
+		}
+		if region.FirestoreDatabaseID != "" && region.FirestoreDatabaseID != "(default)" && !firestoreDatabasePattern.MatchString(region.FirestoreDatabaseID) {
+			l.invalid = append(l.invalid, fmt.Sprintf("%sFIRESTORE_DATABASE_ID must be (default) or a database ID, got %q", prefix, region.FirestoreDatabaseID))
+		}
+		if other, ok := buckets[region.BucketName]; ok && region.BucketName != "" {
+			l.invalid = append(l.invalid, fmt.Sprintf("regions %s and %s can't share the bucket %s", other, region.ID, region.BucketName))
+		}
+		if other, ok := databases[region.FirestoreDatabaseID]; ok && region.FirestoreDatabaseID != "" {
+			l.invalid = append(l.invalid, fmt.Sprintf("regions %s and %s can't share the Firestore database %s", other, region.ID, region.FirestoreDatabaseID))
+		}
+		buckets[region.BucketName] = region.ID
+		databases[region.FirestoreDatabaseID] = region.ID
+	}
+	return regions
+}
+
+// err returns an error listing every missing and invalid variable
+func (l *loader) err() error {
+	problems := make([]string, 0, len(l.invalid)+1)
+	if len(l.missing) > 0 {
+		problems = append(problems, "missing required environment variables: "+strings.Join(l.missing, ", "))
+	}
+	problems = append(problems, l.invalid...)
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+}
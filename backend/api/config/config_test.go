@@ -0,0 +1,346 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+// testClientIPSecret is the CLIENT_IP_SECRET the tests load with, which the
+// default quota and abuse limits require
+const testClientIPSecret = "test-client-ip-secret-of-32-chars"
+
+func TestMain(m *testing.M) {
+	os.Setenv("CLIENT_IP_SECRET", testClientIPSecret)
+	os.Exit(m.Run())
+}
+
+func TestLoadListsAllMissingVariables(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+	t.Setenv("SLIDES_SERVICE_URL", "")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected an error for missing variables")
+	}
+	if !strings.Contains(err.Error(), "GOOGLE_CLOUD_PROJECT, SLIDES_SERVICE_URL") {
+		t.Fatalf("expected both missing variables in the error, got %v", err)
+	}
+}
+
+func TestLoadAppliesDefaults(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("CLOUD_TASKS_REGION", "")
+	t.Setenv("CLOUD_TASKS_QUEUE_ID", "")
+	t.Setenv("GCS_BUCKET_NAME", "")
+	t.Setenv("PORT", "")
+	t.Setenv("FRONTEND_URL", "")
+	t.Setenv("PUBLIC_API_URL", "")
+	t.Setenv("ANONYMOUS_DAILY_JOB_LIMIT", "")
+	t.Setenv("ABUSE_STRIKE_LIMIT", "")
+	t.Setenv("RECIPIENT_DAILY_EMAIL_LIMIT", "")
+	t.Setenv("SSE_HEARTBEAT_INTERVAL", "")
+	t.Setenv("TOKEN_PRICE_PER_MILLION", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.CloudTasksRegion != "us-central1" || cfg.CloudTasksQueue != "slides-generation-queue" || cfg.BucketName != "slideitin-files" {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+	if cfg.AnonymousDailyJobLimit != 10 {
+		t.Fatalf("unexpected anonymous job limit: %d", cfg.AnonymousDailyJobLimit)
+	}
+	if cfg.AbuseStrikeLimit != 50 {
+		t.Fatalf("unexpected abuse strike limit: %d", cfg.AbuseStrikeLimit)
+	}
+	if cfg.RecipientDailyEmailLimit != 10 {
+		t.Fatalf("unexpected recipient email limit: %d", cfg.RecipientDailyEmailLimit)
+	}
+	if cfg.SSEHeartbeatInterval != 30*time.Second {
+		t.Fatalf("unexpected heartbeat interval: %v", cfg.SSEHeartbeatInterval)
+	}
+	if cfg.TokenPricePerMillion != 0 {
+		t.Fatalf("unexpected token price: %v", cfg.TokenPricePerMillion)
+	}
+	if cfg.Port != "8080" || len(cfg.FrontendOrigins) != 1 || cfg.FrontendOrigins[0] != "http://localhost:3000" || cfg.PublicAPIURL != "" {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "slides-service:8080")
+	t.Setenv("PORT", "70000")
+	t.Setenv("GCS_KMS_KEY", "slideitin-uploads")
+	t.Setenv("SSE_HEARTBEAT_INTERVAL", "30")
+	t.Setenv("TOKEN_PRICE_PER_MILLION", "-1")
+	t.Setenv("DOWNLOAD_URL_SECRET", "short-secret")
+	t.Setenv("TRUSTED_PROXIES", "169.254.0.0/16,front-end")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected an error for invalid values")
+	}
+	for _, key := range []string{"SLIDES_SERVICE_URL", "PORT", "GCS_KMS_KEY", "SSE_HEARTBEAT_INTERVAL", "TOKEN_PRICE_PER_MILLION", "DOWNLOAD_URL_SECRET", "TRUSTED_PROXIES"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("expected %s in the error, got %v", key, err)
+		}
+	}
+	if strings.Contains(err.Error(), "short-secret") {
+		t.Fatalf("expected the secret left out of the error, got %v", err)
+	}
+}
+
+func TestLoadSplitsFrontendOrigins(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("FRONTEND_URL", "https://justslideitin.com, https://*.staging.justslideitin.com/,http://localhost:3000")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	expected := []string{"https://justslideitin.com", "https://*.staging.justslideitin.com", "http://localhost:3000"}
+	if strings.Join(cfg.FrontendOrigins, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected origins %v, got %v", expected, cfg.FrontendOrigins)
+	}
+
+	t.Setenv("FRONTEND_URL", "https://justslideitin.com,https://app.*.example.com")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "FRONTEND_URL") {
+		t.Fatalf("expected an error for the misplaced wildcard, got %v", err)
+	}
+}
+
+func TestLoadParsesFeatureFlags(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("FEATURE_FLAGS", "new_themes, chunked_mode=off")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.FeatureFlags) != 2 || !cfg.FeatureFlags["new_themes"] || cfg.FeatureFlags["chunked_mode"] {
+		t.Fatalf("unexpected feature flags: %v", cfg.FeatureFlags)
+	}
+
+	t.Setenv("FEATURE_FLAGS", "new_themes=maybe")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "FEATURE_FLAGS") {
+		t.Fatalf("expected an error for the invalid state, got %v", err)
+	}
+}
+
+func TestLoadParsesPlanTokenLimits(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("PLAN_TOKEN_LIMITS", "pro=65536:8192, unlimited=1000000:")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.PlanTokenLimits["pro"] != (models.TokenLimits{Input: 65536, Output: 8192}) || cfg.PlanTokenLimits["unlimited"] != (models.TokenLimits{Input: 1000000}) {
+		t.Fatalf("unexpected token limits: %v", cfg.PlanTokenLimits)
+	}
+
+	t.Setenv("PLAN_TOKEN_LIMITS", "pro=65536")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "PLAN_TOKEN_LIMITS") {
+		t.Fatalf("expected an error for the limits without an output, got %v", err)
+	}
+}
+
+func TestLoadRequiresStripeSettingsWhenBillingIsEnabled(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "")
+	t.Setenv("STRIPE_PRICE_PRO", "price_pro")
+	t.Setenv("STRIPE_PRICE_TEAM", "")
+	t.Setenv("BILLING_RETURN_URL", "https://justslideitin.com/billing")
+
+	_, err := Load()
+	if err == nil || !strings.Contains(err.Error(), "STRIPE_WEBHOOK_SECRET, STRIPE_PRICE_TEAM") {
+		t.Fatalf("expected the missing Stripe settings in the error, got %v", err)
+	}
+}
+
+func TestLoadRequiresPublicURLForSchedules(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("SCHEDULER_SERVICE_ACCOUNT", "scheduler@slideitin.iam.gserviceaccount.com")
+	t.Setenv("PUBLIC_API_URL", "")
+
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "PUBLIC_API_URL") {
+		t.Fatalf("expected PUBLIC_API_URL to be required, got %v", err)
+	}
+
+	t.Setenv("PUBLIC_API_URL", "https://api.example.com/")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.SchedulerServiceAccount != "scheduler@slideitin.iam.gserviceaccount.com" {
+		t.Fatalf("unexpected scheduler service account: %q", cfg.SchedulerServiceAccount)
+	}
+}
+
+func TestLoadRequiresOAuthSettingsWhenDriveIsEnabled(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", "client.apps.googleusercontent.com")
+	t.Setenv("GOOGLE_OAUTH_CLIENT_SECRET", "")
+	t.Setenv("DRIVE_RETURN_URL", "")
+	t.Setenv("PUBLIC_API_URL", "")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected an error for missing OAuth settings")
+	}
+	for _, key := range []string{"GOOGLE_OAUTH_CLIENT_SECRET", "DRIVE_RETURN_URL", "PUBLIC_API_URL"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected %s in the error, got %v", key, err)
+		}
+	}
+}
+
+func TestLoadValidatesCaptchaSettings(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("CAPTCHA_PROVIDER", "recaptcha")
+	t.Setenv("CAPTCHA_SECRET_KEY", "")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected an error for invalid CAPTCHA settings")
+	}
+	for _, key := range []string{"CAPTCHA_PROVIDER", "CAPTCHA_SECRET_KEY"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected %s in the error, got %v", key, err)
+		}
+	}
+
+	t.Setenv("CAPTCHA_PROVIDER", "Turnstile")
+	t.Setenv("CAPTCHA_SECRET_KEY", "0x4AAA")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.CaptchaProvider != "turnstile" || cfg.CaptchaSecretKey != "0x4AAA" {
+		t.Fatalf("unexpected CAPTCHA settings: %q %q", cfg.CaptchaProvider, cfg.CaptchaSecretKey)
+	}
+}
+
+func TestLoadReadsRegions(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("CLOUD_TASKS_REGION", "us-central1")
+	t.Setenv("GCS_BUCKET_NAME", "slideitin-files")
+	t.Setenv("GCS_BUCKET_LOCATION", "US")
+	t.Setenv("FIRESTORE_DATABASE_ID", "")
+	t.Setenv("DEFAULT_REGION", "")
+	t.Setenv("REGIONS", "eu")
+	t.Setenv("REGION_EU_CLOUD_TASKS_REGION", "europe-west1")
+	t.Setenv("REGION_EU_GCS_BUCKET_NAME", "slideitin-files-eu")
+	t.Setenv("REGION_EU_GCS_BUCKET_LOCATION", "EU")
+	t.Setenv("REGION_EU_FIRESTORE_DATABASE_ID", "slideitin-eu")
+	t.Setenv("REGION_EU_SLIDES_SERVICE_URL", "https://slides-eu.example.com")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := []Region{
+		{ID: "us", CloudTasksRegion: "us-central1", BucketName: "slideitin-files", BucketLocation: "US", FirestoreDatabaseID: "(default)", SlidesServiceURL: "https://slides.example.com"},
+		{ID: "eu", CloudTasksRegion: "europe-west1", BucketName: "slideitin-files-eu", BucketLocation: "EU", FirestoreDatabaseID: "slideitin-eu", SlidesServiceURL: "https://slides-eu.example.com"},
+	}
+	if len(cfg.Regions) != len(want) {
+		t.Fatalf("expected %d regions, got %+v", len(want), cfg.Regions)
+	}
+	for i := range want {
+		if cfg.Regions[i] != want[i] {
+			t.Fatalf("unexpected region %d: %+v", i, cfg.Regions[i])
+		}
+	}
+}
+
+func TestLoadValidatesRegions(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("CLOUD_TASKS_REGION", "us-central1")
+	t.Setenv("GCS_BUCKET_NAME", "slideitin-files")
+	t.Setenv("GCS_BUCKET_LOCATION", "")
+	t.Setenv("FIRESTORE_DATABASE_ID", "")
+	t.Setenv("DEFAULT_REGION", "")
+	t.Setenv("REGIONS", "eu,apac")
+	t.Setenv("REGION_EU_CLOUD_TASKS_REGION", "europe-west1")
+	t.Setenv("REGION_EU_GCS_BUCKET_NAME", "slideitin-files")
+	t.Setenv("REGION_EU_GCS_BUCKET_LOCATION", "US")
+	t.Setenv("REGION_EU_FIRESTORE_DATABASE_ID", "(default)")
+	t.Setenv("REGION_EU_SLIDES_SERVICE_URL", "https://slides-eu.example.com")
+	t.Setenv("REGION_APAC_CLOUD_TASKS_REGION", "")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected an error for invalid regions")
+	}
+	for _, problem := range []string{
+		"REGION_APAC_CLOUD_TASKS_REGION",
+		"REGION_EU_GCS_BUCKET_LOCATION US doesn't contain the Cloud Tasks region europe-west1",
+		"regions us and eu can't share the bucket slideitin-files",
+		"regions us and eu can't share the Firestore database (default)",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("expected %q in the error, got %v", problem, err)
+		}
+	}
+}
+
+func TestLocationContains(t *testing.T) {
+	tests := []struct {
+		location, region string
+		want             bool
+	}{
+		{"europe-west1", "europe-west1", true},
+		{"EUROPE-WEST1", "europe-west1", true},
+		{"EU", "europe-west1", true},
+		{"eu", "europe-north1", true},
+		{"EUR4", "europe-west4", true},
+		{"EUR4", "europe-west1", false},
+		{"US", "europe-west1", false},
+		{"US", "us-central1", true},
+		{"europe-west4", "europe-west1", false},
+	}
+	for _, tt := range tests {
+		if got := LocationContains(tt.location, tt.region); got != tt.want {
+			t.Errorf("LocationContains(%q, %q) = %t, want %t", tt.location, tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestLoadRequiresClientIPSecretForLimits(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("SLIDES_SERVICE_URL", "https://slides.example.com")
+	t.Setenv("CLIENT_IP_SECRET", "")
+	t.Setenv("TRUSTED_PROXIES", "")
+
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "CLIENT_IP_SECRET") {
+		t.Fatalf("expected CLIENT_IP_SECRET to be required, got %v", err)
+	}
+
+	t.Setenv("ANONYMOUS_DAILY_JOB_LIMIT", "0")
+	t.Setenv("ABUSE_STRIKE_LIMIT", "0")
+	t.Setenv("RECIPIENT_DAILY_EMAIL_LIMIT", "0")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected no secret to be needed without limits, got %v", err)
+	}
+	if len(cfg.TrustedProxies) != 3 || cfg.TrustedProxies[0] != "169.254.0.0/16" {
+		t.Fatalf("expected the Google Cloud proxies by default, got %v", cfg.TrustedProxies)
+	}
+}
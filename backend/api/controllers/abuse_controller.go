@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+)
+
+// AbuseController handles the admin endpoints of blocked clients
+type AbuseController struct {
+	abuseService *abuse.Service
+}
+
+// NewAbuseController creates a new abuse controller
+func NewAbuseController(abuseService *abuse.Service) *AbuseController {
+	return &AbuseController{
+		abuseService: abuseService,
+	}
+}
+
+// ListBlocks lists the IP addresses and API keys blocked now
+func (c *AbuseController) ListBlocks(ctx *gin.Context) {
+	blocks, err := c.abuseService.ListBlocks(ctx)
+	if err != nil {
+		log.Printf("Failed to list blocked clients: %v", err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to list blocked clients",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"enabled": c.abuseService.Enabled(),
+		"blocks":  blocks,
+	})
+}
+
+// Unblock lifts the block of an IP address or API key, optionally exempting
+// it from blocks for the duration in the exempt query parameter, such as 72h
+func (c *AbuseController) Unblock(ctx *gin.Context) {
+	subject, ok := c.abuseService.ParseSubject(ctx.Param("subject"))
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "The client must be ip: followed by an IP address or its fingerprint, or key: followed by an API key ID",
+		})
+		return
+	}
+	var exemptFor time.Duration
+	if value := ctx.Query("exempt"); value != "" {
+		var err error
+		exemptFor, err = time.ParseDuration(value)
+		if err != nil || exemptFor < 0 || exemptFor > abuse.MaxExemption {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("exempt must be a duration such as 72h of at most %d days", int(abuse.MaxExemption.Hours()/24)),
+			})
+			return
+		}
+	}
+
+	err := c.abuseService.Unblock(ctx, subject, exemptFor)
+	if errors.Is(err, abuse.ErrUnknownSubject) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "This client has no strikes or blocks",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to unblock %s: %v", subject, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to unblock client",
+		})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+)
+
+// requireAPIKey returns the API key of the request, or responds with an error and returns nil
+func requireAPIKey(ctx *gin.Context, apiKeyService *apikeys.Service) *apikeys.APIKey {
+	key := ctx.GetHeader("X-API-Key")
+	if key == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Missing X-API-Key header",
+		})
+		return nil
+	}
+	apiKey, err := apiKeyService.Lookup(ctx, key)
+	if err != nil {
+		respondInvalidAPIKey(ctx, err)
+		return nil
+	}
+	return apiKey
+}
+
+// respondInvalidAPIKey responds to a request whose API key couldn't be looked
+// up, counting unknown and disabled keys as an offense of the client
+func respondInvalidAPIKey(ctx *gin.Context, err error) {
+	if errors.Is(err, apikeys.ErrInvalidAPIKey) {
+		middleware.ReportOffense(ctx, abuse.OffenseInvalidCredentials)
+	}
+	ctx.JSON(http.StatusUnauthorized, gin.H{
+		"error": err.Error(),
+	})
+}
+
+// requireAccount returns the owner ID of the API key or signed-in user of the
+// request, or responds with an error and returns false
+func requireAccount(ctx *gin.Context, apiKeyService *apikeys.Service) (string, bool) {
+	if ctx.GetHeader("X-API-Key") == "" {
+		if user := middleware.CurrentUser(ctx); user != nil {
+			return user.OwnerID(), true
+		}
+	}
+	apiKey := requireAPIKey(ctx, apiKeyService)
+	if apiKey == nil {
+		return "", false
+	}
+	return apiKey.ID, true
+}
+
+// apiKeyWebhooks returns the chat webhooks configured for an API key
+func apiKeyWebhooks(apiKey *apikeys.APIKey) []queue.Webhook {
+	var webhooks []queue.Webhook
+	if apiKey.SlackWebhookURL != "" {
+		webhooks = append(webhooks, queue.Webhook{Type: "slack", URL: apiKey.SlackWebhookURL})
+	}
+	if apiKey.DiscordWebhookURL != "" {
+		webhooks = append(webhooks, queue.Webhook{Type: "discord", URL: apiKey.DiscordWebhookURL})
+	}
+	return webhooks
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/billing"
+)
+
+// maxWebhookBytes is the largest Stripe webhook payload accepted
+const maxWebhookBytes = 1 << 20
+
+// CheckoutRequest represents the plan to subscribe an API key to
+type CheckoutRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+// BillingController handles the billing API endpoints
+type BillingController struct {
+	billingService *billing.Service
+	apiKeyService  *apikeys.Service
+}
+
+// NewBillingController creates a new billing controller
+func NewBillingController(billingService *billing.Service, apiKeyService *apikeys.Service) *BillingController {
+	return &BillingController{
+		billingService: billingService,
+		apiKeyService:  apiKeyService,
+	}
+}
+
+// CreateCheckout starts a Stripe Checkout that subscribes the API key or signed-in user to a plan
+func (c *BillingController) CreateCheckout(ctx *gin.Context) {
+	owner, ok := requireAccount(ctx, c.apiKeyService)
+	if !ok {
+		return
+	}
+	if !c.billingService.Enabled() {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Billing is not enabled on this instance",
+		})
+		return
+	}
+
+	var req CheckoutRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request format: %v", err),
+		})
+		return
+	}
+
+	url, err := c.billingService.CreateCheckout(ctx, owner, req.Plan)
+	if errors.Is(err, billing.ErrUnknownPlan) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Unknown plan: %s", req.Plan),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create checkout for %s: %v", owner, err)
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to start checkout",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"url": url,
+	})
+}
+
+// GetUsage returns the plan of the API key or signed-in user and its usage this month
+func (c *BillingController) GetUsage(ctx *gin.Context) {
+	owner, ok := requireAccount(ctx, c.apiKeyService)
+	if !ok {
+		return
+	}
+
+	usage, err := c.billingService.GetUsage(ctx, owner)
+	if err != nil {
+		log.Printf("Failed to get usage of %s: %v", owner, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get usage",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, usage)
+}
+
+// HandleWebhook applies the subscription changes sent by Stripe
+func (c *BillingController) HandleWebhook(ctx *gin.Context) {
+	if !c.billingService.Enabled() {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Billing is not enabled on this instance",
+		})
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxWebhookBytes))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to read payload",
+		})
+		return
+	}
+
+	err = c.billingService.HandleWebhook(ctx, payload, ctx.GetHeader("Stripe-Signature"))
+	if errors.Is(err, billing.ErrInvalidSignature) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		// Stripe retries the event when the response isn't successful
+		log.Printf("Failed to handle Stripe webhook: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to handle webhook",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"received": true,
+	})
+}
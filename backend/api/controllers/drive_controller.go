@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/drive"
+)
+
+// DriveController handles connecting Google Drive with OAuth
+type DriveController struct {
+	driveService  *drive.Service
+	apiKeyService *apikeys.Service
+}
+
+// NewDriveController creates a new Drive controller
+func NewDriveController(driveService *drive.Service, apiKeyService *apikeys.Service) *DriveController {
+	return &DriveController{
+		driveService:  driveService,
+		apiKeyService: apiKeyService,
+	}
+}
+
+// GetConnection reports whether the API key or signed-in user has connected Drive
+func (c *DriveController) GetConnection(ctx *gin.Context) {
+	owner, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+
+	connection, err := c.driveService.Status(ctx, owner)
+	if err != nil {
+		log.Printf("Failed to get Drive connection: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get Drive connection",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"drive": connection,
+	})
+}
+
+// Connect returns the Google consent page the user opens to connect Drive
+func (c *DriveController) Connect(ctx *gin.Context) {
+	owner, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"url": c.driveService.AuthURL(owner),
+	})
+}
+
+// Callback receives the authorization code from Google and sends the user
+// back to the frontend with the outcome
+func (c *DriveController) Callback(ctx *gin.Context) {
+	if !c.driveService.Enabled() {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Google Drive input is not enabled on this instance",
+		})
+		return
+	}
+
+	// The user declined access on the consent page
+	if reason := ctx.Query("error"); reason != "" {
+		log.Printf("Drive connection declined: %s", reason)
+		ctx.Redirect(http.StatusFound, c.driveService.ReturnURL("denied"))
+		return
+	}
+
+	owner, err := c.driveService.Connect(ctx, ctx.Query("state"), ctx.Query("code"))
+	if err != nil {
+		if !errors.Is(err, drive.ErrInvalidState) {
+			log.Printf("Failed to connect Drive: %v", err)
+		}
+		ctx.Redirect(http.StatusFound, c.driveService.ReturnURL("error"))
+		return
+	}
+
+	log.Printf("Drive connected for %s", owner)
+	ctx.Redirect(http.StatusFound, c.driveService.ReturnURL("connected"))
+}
+
+// Disconnect revokes Drive access and deletes the stored token
+func (c *DriveController) Disconnect(ctx *gin.Context) {
+	owner, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.driveService.Disconnect(ctx, owner); err != nil {
+		if errors.Is(err, drive.ErrNotConnected) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		log.Printf("Failed to disconnect Drive: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to disconnect Drive",
+		})
+		return
+	}
+
+	log.Printf("Drive disconnected for %s", owner)
+	ctx.Status(http.StatusNoContent)
+}
+
+// requireOwner returns the owner whose Drive the request is about, or responds
+// with an error and returns false
+func (c *DriveController) requireOwner(ctx *gin.Context) (string, bool) {
+	if !c.driveService.Enabled() {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Google Drive input is not enabled on this instance",
+		})
+		return "", false
+	}
+	return requireAccount(ctx, c.apiKeyService)
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/billing"
+	"github.com/martin226/slideitin/backend/api/services/estimates"
+	"github.com/martin226/slideitin/backend/api/services/preflight"
+)
+
+// EstimateController handles the cost estimation API endpoint
+type EstimateController struct {
+	estimateClient       *estimates.Client // Nil when the slides service can't be called directly
+	billingService       *billing.Service
+	apiKeyService        *apikeys.Service
+	tokenPricePerMillion float64
+}
+
+// NewEstimateController creates a new estimate controller, which quotes prices
+// at the token price when billing is enabled and one is set
+func NewEstimateController(estimateClient *estimates.Client, billingService *billing.Service, apiKeyService *apikeys.Service, tokenPricePerMillion float64) *EstimateController {
+	return &EstimateController{
+		estimateClient:       estimateClient,
+		billingService:       billingService,
+		apiKeyService:        apiKeyService,
+		tokenPricePerMillion: tokenPricePerMillion,
+	}
+}
+
+// EstimateCost projects the Gemini tokens, and on billed deployments the
+// price, of generating a deck from the files or prompt without creating a job
+func (c *EstimateController) EstimateCost(ctx *gin.Context) {
+	req := ctx.MustGet(middleware.RequestKey).(*models.EstimateRequest)
+	if c.estimateClient == nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Cost estimation is not available on this instance",
+		})
+		return
+	}
+
+	var owner string
+	if key := ctx.GetHeader("X-API-Key"); key != "" {
+		apiKey, err := c.apiKeyService.Lookup(ctx, key)
+		if err != nil {
+			respondInvalidAPIKey(ctx, err)
+			return
+		}
+		owner = apiKey.ID
+	} else if user := middleware.CurrentUser(ctx); user != nil {
+		owner = user.OwnerID()
+	}
+
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to get files",
+		})
+		return
+	}
+	headers := form.File["files"]
+
+	prompt := strings.TrimSpace(req.Prompt)
+	if prompt != "" && len(headers) > 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Prompt can't be combined with files",
+		})
+		return
+	}
+	if prompt == "" && len(headers) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "No files uploaded",
+		})
+		return
+	}
+
+	files := make([]models.File, 0, len(headers))
+	for _, header := range headers {
+		src, err := header.Open()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to open file %s: %v", header.Filename, err),
+			})
+			return
+		}
+		data, err := io.ReadAll(src)
+		src.Close()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to read file %s: %v", header.Filename, err),
+			})
+			return
+		}
+		mimeType, ok := preflight.DetectType(header.Filename, data)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Unsupported file type: %s. Only PDF, Markdown, TXT, VTT and SRT files are allowed", header.Filename),
+			})
+			return
+		}
+		files = append(files, models.File{Filename: header.Filename, Data: data, Type: mimeType})
+	}
+
+	// The plan fills in the slide detail the job would get and bounds the files sent on
+	plan, err := c.billingService.PlanFor(ctx, owner)
+	if err != nil {
+		log.Printf("Failed to get plan: %v", err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to check plan",
+		})
+		return
+	}
+	if err := plan.Check(&req.Settings, files); err != nil {
+		respondLimitExceeded(ctx, err)
+		return
+	}
+
+	theme := req.Theme
+	if theme == "" {
+		theme = "default"
+	}
+	estimate, err := c.estimateClient.Estimate(ctx, theme, prompt, files, req.Settings, plan.TokenLimits)
+	if err != nil {
+		log.Printf("Failed to estimate cost: %v", err)
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to estimate cost",
+		})
+		return
+	}
+	if c.billingService.Enabled() && c.tokenPricePerMillion > 0 {
+		estimate.SetPrice(c.tokenPricePerMillion)
+	}
+
+	ctx.JSON(http.StatusOK, estimate)
+}
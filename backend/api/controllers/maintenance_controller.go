@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/services/maintenance"
+)
+
+// maxMaintenanceMessage is the longest message shown to clients during maintenance
+const maxMaintenanceMessage = 500
+
+// MaintenanceController handles the maintenance mode endpoints
+type MaintenanceController struct {
+	maintenanceService *maintenance.Service
+}
+
+// NewMaintenanceController creates a new maintenance controller
+func NewMaintenanceController(maintenanceService *maintenance.Service) *MaintenanceController {
+	return &MaintenanceController{
+		maintenanceService: maintenanceService,
+	}
+}
+
+// GetMaintenance returns whether new jobs are accepted, so frontends can show
+// the maintenance message before a job is refused
+func (c *MaintenanceController) GetMaintenance(ctx *gin.Context) {
+	state := c.maintenanceService.Current(ctx)
+	response := gin.H{
+		"enabled": state.Enabled,
+	}
+	if state.Enabled {
+		response["message"] = state.ClientMessage()
+		response["eta"] = state.ETA
+		response["queuePaused"] = state.PauseQueue
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// SetMaintenance turns maintenance mode on or off, optionally pausing the
+// Cloud Tasks queue so queued jobs wait too
+func (c *MaintenanceController) SetMaintenance(ctx *gin.Context) {
+	var req maintenance.State
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request format: %v", err),
+		})
+		return
+	}
+	if len(req.Message) > maxMaintenanceMessage {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("message must be at most %d characters", maxMaintenanceMessage),
+		})
+		return
+	}
+	if req.ETA != 0 && req.ETA <= time.Now().Unix() {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "eta must be a Unix time in the future",
+		})
+		return
+	}
+
+	state, err := c.maintenanceService.Set(ctx, req, middleware.CurrentUser(ctx).UID)
+	if err != nil {
+		log.Printf("Failed to set maintenance mode: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to set maintenance mode",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, state)
+}
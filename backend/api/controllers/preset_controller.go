@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/presets"
+	"github.com/martin226/slideitin/backend/api/services/workspaces"
+)
+
+// PresetController handles the saved preset API endpoints
+type PresetController struct {
+	presetService *presets.Service
+	apiKeyService *apikeys.Service
+}
+
+// NewPresetController creates a new preset controller
+func NewPresetController(presetService *presets.Service, apiKeyService *apikeys.Service) *PresetController {
+	return &PresetController{
+		presetService: presetService,
+		apiKeyService: apiKeyService,
+	}
+}
+
+// ListPresets lists the presets of the API key, workspace or signed-in user
+func (c *PresetController) ListPresets(ctx *gin.Context) {
+	scope, ok := c.requireScope(ctx, workspaces.PermissionView)
+	if !ok {
+		return
+	}
+
+	list, err := c.presetService.List(ctx, scope)
+	if err != nil {
+		respondPresetError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"presets": list,
+	})
+}
+
+// GetPreset returns a single preset
+func (c *PresetController) GetPreset(ctx *gin.Context) {
+	scope, ok := c.requireScope(ctx, workspaces.PermissionView)
+	if !ok {
+		return
+	}
+
+	preset, err := c.presetService.Get(ctx, scope, ctx.Param("id"))
+	if err != nil {
+		respondPresetError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"preset": preset,
+	})
+}
+
+// CreatePreset saves a new preset
+func (c *PresetController) CreatePreset(ctx *gin.Context) {
+	scope, ok := c.requireScope(ctx, workspaces.PermissionGenerate)
+	if !ok {
+		return
+	}
+	req, ok := bindPresetRequest(ctx)
+	if !ok {
+		return
+	}
+
+	preset, err := c.presetService.Create(ctx, scope, *req)
+	if err != nil {
+		respondPresetError(ctx, err)
+		return
+	}
+
+	log.Printf("Preset %s created for %s", preset.ID, scope)
+	ctx.JSON(http.StatusCreated, gin.H{
+		"preset": preset,
+	})
+}
+
+// UpdatePreset replaces the name, theme and settings of a preset
+func (c *PresetController) UpdatePreset(ctx *gin.Context) {
+	scope, ok := c.requireScope(ctx, workspaces.PermissionGenerate)
+	if !ok {
+		return
+	}
+	req, ok := bindPresetRequest(ctx)
+	if !ok {
+		return
+	}
+
+	preset, err := c.presetService.Update(ctx, scope, ctx.Param("id"), *req)
+	if err != nil {
+		respondPresetError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"preset": preset,
+	})
+}
+
+// DeletePreset deletes a preset
+func (c *PresetController) DeletePreset(ctx *gin.Context) {
+	scope, ok := c.requireScope(ctx, workspaces.PermissionGenerate)
+	if !ok {
+		return
+	}
+
+	if err := c.presetService.Delete(ctx, scope, ctx.Param("id")); err != nil {
+		respondPresetError(ctx, err)
+		return
+	}
+
+	log.Printf("Preset %s deleted for %s", ctx.Param("id"), scope)
+	ctx.Status(http.StatusNoContent)
+}
+
+// requireScope returns the preset scope of the request: the workspace of the
+// API key if its role has the permission, else the API key or signed-in user.
+// It responds with an error and returns false when there is none.
+func (c *PresetController) requireScope(ctx *gin.Context, permission workspaces.Permission) (string, bool) {
+	if ctx.GetHeader("X-API-Key") == "" {
+		if user := middleware.CurrentUser(ctx); user != nil {
+			return presets.Scope(user.OwnerID(), ""), true
+		}
+	}
+	apiKey := requireAPIKey(ctx, c.apiKeyService)
+	if apiKey == nil {
+		return "", false
+	}
+	if apiKey.WorkspaceID != "" && !workspaces.Allows(apiKey.Role, permission) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("The %s role doesn't allow this action", apiKey.Role),
+		})
+		return "", false
+	}
+	return presets.Scope(apiKey.ID, apiKey.WorkspaceID), true
+}
+
+// bindPresetRequest parses and validates the JSON body of a preset request
+func bindPresetRequest(ctx *gin.Context) (*models.PresetRequest, bool) {
+	var req models.PresetRequest
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request format: %v", err),
+		})
+		return nil, false
+	}
+	if violations := middleware.Validate(&req); len(violations) > 0 {
+		middleware.AbortWithViolations(ctx, violations)
+		return nil, false
+	}
+	return &req, true
+}
+
+// respondPresetError responds to a failed preset lookup or change
+func respondPresetError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, presets.ErrPresetNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, presets.ErrTooManyPresets):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		log.Printf("Preset error: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to access presets",
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/auth"
+	"github.com/martin226/slideitin/backend/api/services/billing"
+	"github.com/martin226/slideitin/backend/api/services/features"
+	"github.com/martin226/slideitin/backend/api/services/presets"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"github.com/martin226/slideitin/backend/api/services/quota"
+	"github.com/martin226/slideitin/backend/api/services/schedules"
+	"github.com/martin226/slideitin/backend/api/services/workspaces"
+)
+
+// maxSchedulesPerRun is the largest number of due schedules run by one call,
+// the rest are picked up by the next call
+const maxSchedulesPerRun = 50
+
+// ScheduleController handles the scheduled generation API endpoints
+type ScheduleController struct {
+	scheduleService  *schedules.Service
+	fetcher          *schedules.Fetcher
+	queueService     *queue.Service
+	apiKeyService    *apikeys.Service
+	billingService   *billing.Service
+	workspaceService *workspaces.Service
+	presetService    *presets.Service
+	featureService   *features.Service
+	quotaService     *quota.Service
+}
+
+// NewScheduleController creates a new schedule controller. A nil fetcher
+// disables schedules, as when no Cloud Scheduler job is configured.
+func NewScheduleController(scheduleService *schedules.Service, fetcher *schedules.Fetcher, queueService *queue.Service, apiKeyService *apikeys.Service, billingService *billing.Service, workspaceService *workspaces.Service, presetService *presets.Service, featureService *features.Service, quotaService *quota.Service) *ScheduleController {
+	return &ScheduleController{
+		scheduleService:  scheduleService,
+		fetcher:          fetcher,
+		queueService:     queueService,
+		apiKeyService:    apiKeyService,
+		billingService:   billingService,
+		workspaceService: workspaceService,
+		presetService:    presetService,
+		featureService:   featureService,
+		quotaService:     quotaService,
+	}
+}
+
+// ListSchedules lists the schedules of the API key or signed-in user
+func (c *ScheduleController) ListSchedules(ctx *gin.Context) {
+	owner, _, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+
+	list, err := c.scheduleService.List(ctx, owner)
+	if err != nil {
+		respondScheduleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"schedules": list,
+	})
+}
+
+// GetSchedule returns a single schedule with the outcome of its last run
+func (c *ScheduleController) GetSchedule(ctx *gin.Context) {
+	owner, _, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+
+	schedule, err := c.scheduleService.Get(ctx, owner, ctx.Param("id"))
+	if err != nil {
+		respondScheduleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"schedule": schedule,
+	})
+}
+
+// CreateSchedule registers a source to generate a deck from on a cron schedule
+func (c *ScheduleController) CreateSchedule(ctx *gin.Context) {
+	owner, workspaceID, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+	req, ok := bindScheduleRequest(ctx)
+	if !ok {
+		return
+	}
+
+	schedule, err := c.scheduleService.Create(ctx, owner, workspaceID, *req)
+	if err != nil {
+		respondScheduleError(ctx, err)
+		return
+	}
+
+	log.Printf("Schedule %s created for %s, next run at %d", schedule.ID, owner, schedule.NextRunAt)
+	ctx.JSON(http.StatusCreated, gin.H{
+		"schedule": schedule,
+	})
+}
+
+// UpdateSchedule replaces the settings of a schedule, also used to pause and resume it
+func (c *ScheduleController) UpdateSchedule(ctx *gin.Context) {
+	owner, _, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+	req, ok := bindScheduleRequest(ctx)
+	if !ok {
+		return
+	}
+
+	schedule, err := c.scheduleService.Update(ctx, owner, ctx.Param("id"), *req)
+	if err != nil {
+		respondScheduleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"schedule": schedule,
+	})
+}
+
+// DeleteSchedule deletes a schedule
+func (c *ScheduleController) DeleteSchedule(ctx *gin.Context) {
+	owner, _, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.scheduleService.Delete(ctx, owner, ctx.Param("id")); err != nil {
+		respondScheduleError(ctx, err)
+		return
+	}
+
+	log.Printf("Schedule %s deleted for %s", ctx.Param("id"), owner)
+	ctx.Status(http.StatusNoContent)
+}
+
+// RunDueSchedules generates the decks of every due schedule. Cloud Scheduler
+// calls it every few minutes with an OIDC token checked by RequireServiceAccount.
+func (c *ScheduleController) RunDueSchedules(ctx *gin.Context) {
+	due, claimErr := c.scheduleService.ClaimDue(ctx, time.Now(), maxSchedulesPerRun)
+	if claimErr != nil {
+		log.Printf("Failed to claim due schedules: %v", claimErr)
+	}
+
+	ran, failed := 0, 0
+	for i := range due {
+		schedule := &due[i]
+		jobID, err := c.runSchedule(ctx, schedule)
+		if err != nil {
+			log.Printf("Schedule %s failed: %v", schedule.ID, err)
+			failed++
+		} else {
+			log.Printf("Schedule %s queued job %s", schedule.ID, jobID)
+			ran++
+		}
+		if err := c.scheduleService.RecordRun(ctx, schedule.ID, jobID, err); err != nil {
+			log.Printf("Failed to record run of schedule %s: %v", schedule.ID, err)
+		}
+	}
+
+	// Let Cloud Scheduler retry when due schedules couldn't be listed
+	status := http.StatusOK
+	if claimErr != nil {
+		status = http.StatusInternalServerError
+	}
+	ctx.JSON(status, gin.H{
+		"ran":    ran,
+		"failed": failed,
+	})
+}
+
+// runSchedule fetches the source of a schedule and queues a job for it with the
+// same checks as a generation request, returning the ID of the job
+func (c *ScheduleController) runSchedule(ctx *gin.Context, schedule *schedules.Schedule) (string, error) {
+	options := queue.JobOptions{
+		Owner:       schedule.Owner,
+		WorkspaceID: schedule.WorkspaceID,
+		Labels:      schedule.Labels,
+		// A retried run returns the job of the first attempt
+		IdempotencyKey: fmt.Sprintf("schedule:%s:%d", schedule.ID, schedule.LastRunAt),
+	}
+	if address, err := mail.ParseAddress(schedule.NotifyEmail); err == nil {
+		// Runs go ahead without the email once its recipient got enough for the day
+		if _, err := c.quotaService.ConsumeRecipient(ctx, address.Address); err != nil {
+			log.Printf("Not emailing the deck of schedule %s: %v", schedule.ID, err)
+		} else {
+			options.NotifyEmail = address.Address
+		}
+	}
+
+	// API keys can be disabled or change role after the schedule was created
+	if !auth.IsUserOwner(schedule.Owner) {
+		apiKey, err := c.apiKeyService.Get(ctx, schedule.Owner)
+		if err != nil {
+			return "", err
+		}
+		if apiKey.WorkspaceID != schedule.WorkspaceID || (apiKey.WorkspaceID != "" && !workspaces.Allows(apiKey.Role, workspaces.PermissionGenerate)) {
+			return "", fmt.Errorf("the API key is no longer allowed to generate into the workspace")
+		}
+		options.Webhooks = apiKeyWebhooks(apiKey)
+	}
+
+	req := &models.SlideRequest{
+		Theme:    schedule.Theme,
+		PresetID: schedule.PresetID,
+		Settings: schedule.Settings,
+	}
+	if req.PresetID != "" {
+		preset, err := c.presetService.Get(ctx, presets.Scope(schedule.Owner, schedule.WorkspaceID), req.PresetID)
+		if err != nil {
+			return "", err
+		}
+		preset.ApplyTo(req)
+	}
+	if schedule.WorkspaceID != "" {
+		workspace, err := c.workspaceService.Get(ctx, schedule.WorkspaceID)
+		if err != nil {
+			return "", err
+		}
+		if err := workspace.Apply(req); err != nil {
+			return "", err
+		}
+		options.Fonts = workspace.FontFiles(req.Settings)
+		options.Region = workspace.Region
+	}
+	options.Features = c.featureService.Evaluate(ctx, schedule.WorkspaceID)
+	if !features.Flags(options.Features).AllowsTheme(req.Theme) {
+		return "", fmt.Errorf("the %s theme isn't available yet", req.Theme)
+	}
+
+	plan, err := c.billingService.PlanFor(ctx, schedule.Owner)
+	if err != nil {
+		return "", err
+	}
+
+	// Feeds are digested and Drive files downloaded with the owner's
+	// connection by the slides service, URLs are fetched here
+	var files []models.File
+	switch schedule.Source.Type {
+	case schedules.SourceRSS:
+		options.Sources = []queue.SourceReference{{Type: schedule.Source.Type, Location: schedule.Source.Location, Options: schedule.Source.Options}}
+		options.MaxSourceBytes = plan.MaxFileBytes
+	case schedules.SourceDrive:
+		options.Drive = &queue.DriveFiles{
+			Owner:        schedule.Owner,
+			FileIDs:      []string{schedule.Source.Location},
+			MaxFileBytes: plan.MaxFileBytes,
+		}
+	default:
+		file, err := c.fetcher.Fetch(ctx, schedule.Source)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, file)
+	}
+	options.TokenLimits = plan.TokenLimits
+	if err := plan.Check(&req.Settings, files); err != nil {
+		return "", err
+	}
+	tokens := billing.EstimateTokens(files)
+	if err := c.billingService.Consume(ctx, schedule.Owner, plan, tokens); err != nil {
+		return "", err
+	}
+
+	job, err := c.queueService.AddJob(ctx, c.queueService.NewJobID(options.Region), req.Theme, files, req.Settings, options)
+	if err != nil {
+		c.billingService.Refund(ctx, schedule.Owner, plan, 1, tokens)
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// requireOwner returns the owner and workspace schedules of the request are
+// created for, or responds with an error and returns false. Workspace members
+// need permission to generate, as schedules generate into the workspace.
+func (c *ScheduleController) requireOwner(ctx *gin.Context) (string, string, bool) {
+	if c.fetcher == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Schedules are not enabled on this instance",
+		})
+		return "", "", false
+	}
+	if ctx.GetHeader("X-API-Key") == "" {
+		if user := middleware.CurrentUser(ctx); user != nil {
+			return user.OwnerID(), "", true
+		}
+	}
+	apiKey := requireAPIKey(ctx, c.apiKeyService)
+	if apiKey == nil {
+		return "", "", false
+	}
+	if apiKey.WorkspaceID != "" && !workspaces.Allows(apiKey.Role, workspaces.PermissionGenerate) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("The %s role doesn't allow scheduling slides", apiKey.Role),
+		})
+		return "", "", false
+	}
+	return apiKey.ID, apiKey.WorkspaceID, true
+}
+
+// bindScheduleRequest parses and validates the JSON body of a schedule request
+func bindScheduleRequest(ctx *gin.Context) (*models.ScheduleRequest, bool) {
+	var req models.ScheduleRequest
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request format: %v", err),
+		})
+		return nil, false
+	}
+	if violations := middleware.Validate(&req); len(violations) > 0 {
+		middleware.AbortWithViolations(ctx, violations)
+		return nil, false
+	}
+	return &req, true
+}
+
+// respondScheduleError responds to a failed schedule lookup or change
+func respondScheduleError(ctx *gin.Context, err error) {
+	var invalid *schedules.InvalidError
+	switch {
+	case errors.As(err, &invalid):
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": invalid.Message,
+		})
+	case errors.Is(err, schedules.ErrScheduleNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, schedules.ErrTooManySchedules):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		log.Printf("Schedule error: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to access schedules",
+		})
+	}
+}
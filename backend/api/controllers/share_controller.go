@@ -0,0 +1,419 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"github.com/martin226/slideitin/backend/api/services/sharing"
+	"github.com/martin226/slideitin/backend/api/services/workspaces"
+)
+
+// maxBeaconBytes bounds the body of a viewer beacon
+const maxBeaconBytes = 16 << 10
+
+// ShareRequest represents the optional settings for a new share link
+type ShareRequest struct {
+	Password       string `json:"password"`
+	ExpiresInHours int    `json:"expiresInHours"`
+}
+
+// DownloadURLRequest represents the optional settings for a new download URL
+type DownloadURLRequest struct {
+	Format           string `json:"format"` // Document linked to, pdf by default
+	ExpiresInMinutes int    `json:"expiresInMinutes"`
+}
+
+// ShareController handles the result sharing API endpoints
+type ShareController struct {
+	shareService  *sharing.Service
+	apiKeyService *apikeys.Service
+	publicURL     string
+}
+
+// NewShareController creates a new share controller. Share links are built
+// from the request host when publicURL is empty.
+func NewShareController(shareService *sharing.Service, apiKeyService *apikeys.Service, publicURL string) *ShareController {
+	return &ShareController{
+		shareService:  shareService,
+		apiKeyService: apiKeyService,
+		publicURL:     publicURL,
+	}
+}
+
+// CreateShare creates a revocable share link for a result
+func (c *ShareController) CreateShare(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing result ID",
+		})
+		return
+	}
+
+	// The body is optional, an empty body creates a public link with the default expiry
+	var req ShareRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid request format: %v", err),
+			})
+			return
+		}
+	}
+
+	if req.ExpiresInHours < 0 || time.Duration(req.ExpiresInHours)*time.Hour > sharing.MaxExpiry {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("expiresInHours must be between 1 and %d, or 0 for the default of %d", int(sharing.MaxExpiry.Hours()), int(sharing.DefaultExpiry.Hours())),
+		})
+		return
+	}
+
+	access, _, ok := requestAccess(ctx, c.apiKeyService, workspaces.PermissionGenerate)
+	if !ok {
+		return
+	}
+	share, err := c.shareService.CreateShare(ctx, id, access, req.Password, time.Duration(req.ExpiresInHours)*time.Hour)
+	switch {
+	case errors.Is(err, queue.ErrClaimRequired), errors.Is(err, queue.ErrNotOwner):
+		respondAccessDenied(ctx, err, "result")
+		return
+	case errors.Is(err, queue.ErrNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Failed to share result: %v", err),
+		})
+		return
+	case errors.Is(err, queue.ErrEphemeralResult):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("Failed to share result: %v", err),
+		})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to share result: %v", err),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"token":         share.Token,
+		"managementKey": share.ManagementKey,
+		"url":           c.viewerURL(ctx, share.Token),
+		"protected":     share.Protected,
+		"createdAt":     share.CreatedAt,
+		"expiresAt":     share.ExpiresAt,
+	})
+}
+
+// GetSharedResult serves a shared presentation to anyone holding the share token
+func (c *ShareController) GetSharedResult(ctx *gin.Context) {
+	c.serveShared(ctx, resultFormat(ctx))
+}
+
+// GetSharedThumbnail serves a PNG of the first slide of a shared presentation,
+// for link previews
+func (c *ShareController) GetSharedThumbnail(ctx *gin.Context) {
+	c.serveShared(ctx, queue.ResultThumbnail)
+}
+
+// serveShared serves a document of the result a share token links to
+func (c *ShareController) serveShared(ctx *gin.Context, format queue.ResultFormat) {
+	token := ctx.Param("token")
+	if token == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing share token",
+		})
+		return
+	}
+
+	// The password is only accepted as a header so it doesn't end up in access logs
+	password := ctx.GetHeader("X-Share-Password")
+
+	result, err := c.shareService.GetSharedResult(ctx, token, password)
+	if err != nil {
+		if errors.Is(err, sharing.ErrPasswordRequired) {
+			// Opening a protected link without a password is how viewers
+			// are asked for one, only wrong guesses count
+			if password != "" {
+				middleware.ReportOffense(ctx, abuse.OffenseWrongSharePassword)
+			}
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Shared presentation not found: %v", err),
+		})
+		return
+	}
+
+	file, err := c.shareService.OpenResultFile(ctx, result, format)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Shared presentation not found: %v", err),
+		})
+		return
+	}
+
+	if format != queue.ResultThumbnail && isDownload(ctx) {
+		c.shareService.RecordDownload(ctx, result)
+	}
+
+	// Presentations viewed through the link report which slides were read
+	if format == queue.ResultHTML || format == queue.ResultViewer {
+		file, err = sharing.WithViewerAnalytics(file, c.viewerURL(ctx, token)+"/analytics")
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to load shared presentation: %v", err),
+			})
+			return
+		}
+		if format == queue.ResultViewer {
+			ctx.Header("Content-Security-Policy", viewerPolicy+"; connect-src "+c.baseURL(ctx))
+		}
+	}
+
+	// Shared links must not be cached by intermediaries since they can be revoked
+	ctx.Header("Cache-Control", "private, no-store")
+	serveResultFile(ctx, file, result.ID, format)
+}
+
+// RecordViewerAnalytics records a beacon the viewer of a share link sends
+// with the time spent on each slide. Beacons are sent as text/plain, so they
+// don't need a CORS preflight from the sandboxed viewer.
+func (c *ShareController) RecordViewerAnalytics(ctx *gin.Context) {
+	token := ctx.Param("token")
+	if token == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing share token",
+		})
+		return
+	}
+
+	var beacon sharing.ViewerBeacon
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBeaconBytes)
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&beacon); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid beacon: %v", err),
+		})
+		return
+	}
+
+	if err := c.shareService.RecordViewerBeacon(ctx, token, beacon); err != nil {
+		switch {
+		case errors.Is(err, sharing.ErrShareNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, sharing.ErrInvalidBeacon):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// GetDeckAnalytics returns how long the viewers of the share links of a
+// result spent on each slide, and how many of them reached the end
+func (c *ShareController) GetDeckAnalytics(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing result ID",
+		})
+		return
+	}
+
+	access, _, ok := requestAccess(ctx, c.apiKeyService, workspaces.PermissionView)
+	if !ok {
+		return
+	}
+	analytics, err := c.shareService.GetDeckAnalytics(ctx, id, access)
+	if errors.Is(err, queue.ErrClaimRequired) || errors.Is(err, queue.ErrNotOwner) {
+		respondAccessDenied(ctx, err, "result")
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Analytics not found: %v", err),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, analytics)
+}
+
+// RevokeShare revokes a share link using the management key returned when it was created
+func (c *ShareController) RevokeShare(ctx *gin.Context) {
+	token := ctx.Param("token")
+	managementKey := ctx.GetHeader("X-Management-Key")
+	if token == "" || managementKey == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing share token or management key",
+		})
+		return
+	}
+
+	if err := c.shareService.RevokeShare(ctx, token, managementKey); err != nil {
+		switch {
+		case errors.Is(err, sharing.ErrInvalidManagementKey):
+			ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, sharing.ErrShareNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// CreateDownloadURL signs a short-lived URL for a document of a result, which
+// downloads it without an API key or claim token until it expires
+func (c *ShareController) CreateDownloadURL(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing result ID",
+		})
+		return
+	}
+
+	// The body is optional, an empty body links to the PDF with the default expiry
+	var req DownloadURLRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid request format: %v", err),
+			})
+			return
+		}
+	}
+
+	format := queue.ResultPDF
+	if req.Format != "" {
+		format = queue.ResultFormat(req.Format)
+	}
+	if !sharing.ValidDownloadFormat(format) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Unsupported format %q, use pdf, html, viewer, flashcards, one-pager, one-pager-md, handout, handout-md, alignment, chunk-summaries or grounding", req.Format),
+		})
+		return
+	}
+	if req.ExpiresInMinutes < 0 || time.Duration(req.ExpiresInMinutes)*time.Minute > sharing.MaxDownloadExpiry {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("expiresInMinutes must be between 1 and %d", int(sharing.MaxDownloadExpiry.Minutes())),
+		})
+		return
+	}
+
+	access, _, ok := requestAccess(ctx, c.apiKeyService, workspaces.PermissionGenerate)
+	if !ok {
+		return
+	}
+	download, err := c.shareService.CreateDownloadURL(ctx, id, access, format, time.Duration(req.ExpiresInMinutes)*time.Minute)
+	switch {
+	case errors.Is(err, sharing.ErrDownloadsDisabled):
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Download URLs are not available on this instance",
+		})
+		return
+	case errors.Is(err, queue.ErrClaimRequired), errors.Is(err, queue.ErrNotOwner):
+		respondAccessDenied(ctx, err, "result")
+		return
+	case err != nil:
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Failed to sign download URL: %v", err),
+		})
+		return
+	}
+
+	query := url.Values{}
+	query.Set("format", string(download.Format))
+	query.Set("expires", strconv.FormatInt(download.ExpiresAt, 10))
+	query.Set("signature", download.Signature)
+	ctx.JSON(http.StatusCreated, gin.H{
+		"url":       fmt.Sprintf("%s/v1/downloads/%s?%s", c.baseURL(ctx), url.PathEscape(id), query.Encode()),
+		"format":    download.Format,
+		"expiresAt": download.ExpiresAt,
+	})
+}
+
+// GetDownload serves the document a signed download URL links to
+func (c *ShareController) GetDownload(ctx *gin.Context) {
+	id := ctx.Param("id")
+	expiresAt, err := strconv.ParseInt(ctx.Query("expires"), 10, 64)
+	if id == "" || err != nil || ctx.Query("signature") == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing result ID, expiry or signature",
+		})
+		return
+	}
+
+	format := queue.ResultFormat(ctx.Query("format"))
+	result, err := c.shareService.GetDownload(ctx, id, format, expiresAt, ctx.Query("signature"))
+	switch {
+	case errors.Is(err, sharing.ErrInvalidSignature):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, sharing.ErrDownloadExpired):
+		ctx.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, sharing.ErrDownloadsDisabled):
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Result not found: %v", err),
+		})
+		return
+	}
+
+	file, err := c.shareService.OpenResultFile(ctx, result, format)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Result not found: %v", err),
+		})
+		return
+	}
+
+	if isDownload(ctx) {
+		c.shareService.RecordDownload(ctx, result)
+	}
+
+	// The signature is in the URL, so it isn't passed on to other sites
+	ctx.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", max(expiresAt-time.Now().Unix(), 0)))
+	ctx.Header("Referrer-Policy", "no-referrer")
+	serveResultFile(ctx, file, id, format)
+}
+
+// viewerURL builds the public URL of a shared presentation
+func (c *ShareController) viewerURL(ctx *gin.Context, token string) string {
+	return fmt.Sprintf("%s/v1/shared/%s", c.baseURL(ctx), token)
+}
+
+// baseURL returns the public URL of the API, links are built from it
+func (c *ShareController) baseURL(ctx *gin.Context) string {
+	if c.publicURL != "" {
+		return c.publicURL
+	}
+
+	// Fall back to the host the request came in on
+	scheme := "https"
+	if ctx.Request.TLS == nil && ctx.GetHeader("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, ctx.Request.Host)
+}
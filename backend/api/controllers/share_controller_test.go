@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"github.com/martin226/slideitin/backend/api/services/sharing"
+)
+
+// memoryShareStore is a sharing.ShareStore kept in memory
+type memoryShareStore struct {
+	shares map[string]sharing.FirestoreShare
+}
+
+func (s *memoryShareStore) GetShare(ctx context.Context, token string) (*sharing.FirestoreShare, error) {
+	share, ok := s.shares[token]
+	if !ok {
+		return nil, sharing.ErrShareNotFound
+	}
+	return &share, nil
+}
+
+func (s *memoryShareStore) CreateShare(ctx context.Context, share sharing.FirestoreShare) error {
+	s.shares[share.Token] = share
+	return nil
+}
+
+func (s *memoryShareStore) RevokeShare(ctx context.Context, token string) error {
+	share := s.shares[token]
+	share.Revoked = true
+	s.shares[token] = share
+	return nil
+}
+
+func (s *memoryShareStore) DeleteShare(ctx context.Context, token string) error {
+	delete(s.shares, token)
+	return nil
+}
+
+// memoryResults serves PDFs of results kept in memory, failing every lookup
+// when err is set
+type memoryResults struct {
+	results map[string]queue.FirestoreResult
+	err     error
+}
+
+func (r *memoryResults) GetResult(ctx context.Context, jobID string) (*queue.FirestoreResult, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	result, ok := r.results[jobID]
+	if !ok {
+		return nil, fmt.Errorf("result %w", queue.ErrNotFound)
+	}
+	return &result, nil
+}
+
+func (r *memoryResults) ExtendResult(ctx context.Context, jobID string, expiresAt int64) error {
+	return nil
+}
+
+func (r *memoryResults) RecordDownload(ctx context.Context, result *queue.FirestoreResult, now time.Time) {
+}
+
+func (r *memoryResults) OpenResultFile(ctx context.Context, result *queue.FirestoreResult, format queue.ResultFormat) (*queue.ResultFile, error) {
+	return &queue.ResultFile{
+		ReadSeekCloser: nopSeekCloser{strings.NewReader("%PDF-1.7")},
+		ContentType:    "application/pdf",
+	}, nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+// recordingTracker records the offenses reported by handlers and blocks no one
+type recordingTracker struct {
+	offenses []abuse.Offense
+}
+
+func (t *recordingTracker) IPSubject(ip string) string { return "ip:" + ip }
+
+func (t *recordingTracker) Blocked(ctx context.Context, subjects []string) time.Time {
+	return time.Time{}
+}
+
+func (t *recordingTracker) Record(ctx context.Context, subjects []string, offense abuse.Offense) {
+	t.offenses = append(t.offenses, offense)
+}
+
+// newShareRouter serves the share endpoints on top of stores kept in memory
+func newShareRouter(results *memoryResults) (*gin.Engine, *sharing.Service, *recordingTracker) {
+	gin.SetMode(gin.TestMode)
+	service := sharing.NewServiceWithStores(&memoryShareStore{shares: make(map[string]sharing.FirestoreShare)}, results, "")
+	controller := NewShareController(service, nil, "https://api.example.com")
+	tracker := &recordingTracker{}
+
+	router := gin.New()
+	router.Use(middleware.TrackAbuse(tracker))
+	router.POST("/v1/results/:id/share", controller.CreateShare)
+	router.GET("/v1/shared/:token", controller.GetSharedResult)
+	router.DELETE("/v1/shared/:token", controller.RevokeShare)
+	return router, service, tracker
+}
+
+func serve(router *gin.Engine, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateShareStatus(t *testing.T) {
+	results := &memoryResults{results: map[string]queue.FirestoreResult{"job-1": {ID: "job-1"}, "owned": {ID: "owned", Owner: "key-1"}}}
+	router, _, _ := newShareRouter(results)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		err    error
+		status int
+	}{
+		{"created", "/v1/results/job-1/share", `{"expiresInHours": 24}`, nil, http.StatusCreated},
+		{"default expiry", "/v1/results/job-1/share", `{"expiresInHours": 0}`, nil, http.StatusCreated},
+		{"negative expiry", "/v1/results/job-1/share", `{"expiresInHours": -1}`, nil, http.StatusBadRequest},
+		{"missing result", "/v1/results/job-2/share", "", nil, http.StatusNotFound},
+		{"not the owner", "/v1/results/owned/share", "", nil, http.StatusForbidden},
+		{"store failure", "/v1/results/job-1/share", "", errors.New("unavailable"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		results.err = test.err
+		w := serve(router, http.MethodPost, test.path, test.body, http.Header{"Content-Type": {"application/json"}})
+		if w.Code != test.status {
+			t.Errorf("%s: expected %d, got %d: %s", test.name, test.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGetSharedResultPassword(t *testing.T) {
+	router, service, tracker := newShareRouter(&memoryResults{results: map[string]queue.FirestoreResult{"job-1": {ID: "job-1"}}})
+	share, err := service.CreateShare(context.Background(), "job-1", queue.Access{}, "hunter2", 0)
+	if err != nil {
+		t.Fatalf("CreateShare failed: %v", err)
+	}
+	path := "/v1/shared/" + share.Token + "?download=true"
+
+	if w := serve(router, http.MethodGet, path, "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a password, got %d", w.Code)
+	}
+	if len(tracker.offenses) != 0 {
+		t.Fatalf("expected no offense without a password, got %v", tracker.offenses)
+	}
+
+	if w := serve(router, http.MethodGet, path+"&password=hunter2", "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the password to be ignored in the query, got %d", w.Code)
+	}
+
+	if w := serve(router, http.MethodGet, path, "", http.Header{"X-Share-Password": {"wrong"}}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong password, got %d", w.Code)
+	}
+	if len(tracker.offenses) != 1 || tracker.offenses[0] != abuse.OffenseWrongSharePassword {
+		t.Fatalf("expected the wrong password to be reported, got %v", tracker.offenses)
+	}
+
+	w := serve(router, http.MethodGet, path, "", http.Header{"X-Share-Password": {"hunter2"}})
+	if w.Code != http.StatusOK || w.Body.String() != "%PDF-1.7" {
+		t.Fatalf("expected the PDF, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRevokeShareStatus(t *testing.T) {
+	router, service, _ := newShareRouter(&memoryResults{results: map[string]queue.FirestoreResult{"job-1": {ID: "job-1"}}})
+	share, err := service.CreateShare(context.Background(), "job-1", queue.Access{}, "", 0)
+	if err != nil {
+		t.Fatalf("CreateShare failed: %v", err)
+	}
+	path := "/v1/shared/" + share.Token
+
+	if w := serve(router, http.MethodDelete, path, "", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a management key, got %d", w.Code)
+	}
+	if w := serve(router, http.MethodDelete, path, "", http.Header{"X-Management-Key": {"wrong"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a wrong management key, got %d", w.Code)
+	}
+	if w := serve(router, http.MethodDelete, path, "", http.Header{"X-Management-Key": {share.ManagementKey}}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if w := serve(router, http.MethodGet, path+"?download=true", "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected the revoked link not to be found, got %d", w.Code)
+	}
+	if w := serve(router, http.MethodDelete, "/v1/shared/unknown", "", http.Header{"X-Management-Key": {"key"}}); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown link, got %d", w.Code)
+	}
+}
@@ -3,106 +3,336 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"net/mail"
+	"strconv"
 	"strings"
 	"time"
-	"path/filepath"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/martin226/slideitin/backend/api/middleware"
 	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/batches"
+	"github.com/martin226/slideitin/backend/api/services/billing"
+	"github.com/martin226/slideitin/backend/api/services/captcha"
+	"github.com/martin226/slideitin/backend/api/services/drive"
+	"github.com/martin226/slideitin/backend/api/services/estimates"
+	"github.com/martin226/slideitin/backend/api/services/features"
+	"github.com/martin226/slideitin/backend/api/services/i18n"
+	"github.com/martin226/slideitin/backend/api/services/preflight"
+	"github.com/martin226/slideitin/backend/api/services/presets"
 	"github.com/martin226/slideitin/backend/api/services/queue"
+	"github.com/martin226/slideitin/backend/api/services/quota"
+	"github.com/martin226/slideitin/backend/api/services/revisions"
+	"github.com/martin226/slideitin/backend/api/services/workspaces"
+)
+
+const (
+	// defaultJobHistoryLimit is the number of jobs listed when no limit is given
+	defaultJobHistoryLimit = 50
+
+	// maxJobHistoryLimit is the largest number of jobs listed at once
+	maxJobHistoryLimit = 200
+
+	// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted
+	maxIdempotencyKeyLength = 255
+
+	// minPromptLength is the shortest prompt a deck is written from
+	minPromptLength = 3
+
+	// maxStreamJobs is the most jobs one multiplexed stream watches
+	maxStreamJobs = 50
+
+	// maxLongPollWait is the longest a status request waits for a change
+	maxLongPollWait = 60 * time.Second
 )
 
 // SlideController handles the slide generation API endpoints
 type SlideController struct {
-	queueService  *queue.Service
+	queueService     *queue.Service
+	apiKeyService    *apikeys.Service
+	quotaService     *quota.Service
+	billingService   *billing.Service
+	workspaceService *workspaces.Service
+	presetService    *presets.Service
+	driveService     *drive.Service
+	featureService   *features.Service
+	batchService     *batches.Service
+	estimateClient   *estimates.Client // Nil when the slides service can't be called directly, which disables dry runs and chapter splitting
+	captchaVerifier  *captcha.Verifier // Nil when anonymous jobs don't need a solved CAPTCHA
+	adminUIDs        []string          // Firebase UIDs of the users who may debug jobs
+	origins          *middleware.OriginMatcher
+	heartbeat        time.Duration // Idle time before a status stream sends a keepalive
 }
 
 // NewSlideController creates a new slide controller
-func NewSlideController(queueService *queue.Service) *SlideController {
+func NewSlideController(queueService *queue.Service, apiKeyService *apikeys.Service, quotaService *quota.Service, billingService *billing.Service, workspaceService *workspaces.Service, presetService *presets.Service, driveService *drive.Service, featureService *features.Service, batchService *batches.Service, estimateClient *estimates.Client, captchaVerifier *captcha.Verifier, adminUIDs []string, origins *middleware.OriginMatcher, heartbeat time.Duration) *SlideController {
 	return &SlideController{
-		queueService:  queueService,
+		queueService:     queueService,
+		apiKeyService:    apiKeyService,
+		quotaService:     quotaService,
+		billingService:   billingService,
+		workspaceService: workspaceService,
+		presetService:    presetService,
+		driveService:     driveService,
+		featureService:   featureService,
+		batchService:     batchService,
+		estimateClient:   estimateClient,
+		captchaVerifier:  captchaVerifier,
+		adminUIDs:        adminUIDs,
+		origins:          origins,
+		heartbeat:        heartbeat,
 	}
 }
 
-// GenerateSlides handles the slide generation request
+// GenerateSlides handles the slide generation request. The request in the data
+// form field is parsed and validated by the BindFormJSON middleware.
 func (c *SlideController) GenerateSlides(ctx *gin.Context) {
-	// Parse form data first
-	if err := ctx.Request.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "Failed to parse form data",
-		})
+	req := ctx.MustGet(middleware.RequestKey).(*models.SlideRequest)
+
+	// Look up the webhooks configured for the API key, if one was sent
+	options := queue.JobOptions{}
+	var workspace *workspaces.Workspace
+	if key := ctx.GetHeader("X-API-Key"); key != "" {
+		apiKey, err := c.apiKeyService.Lookup(ctx, key)
+		if err != nil {
+			respondInvalidAPIKey(ctx, err)
+			return
+		}
+		options.Webhooks = apiKeyWebhooks(apiKey)
+		options.Owner = apiKey.ID
+
+		// Members of a workspace generate into its library with its branding
+		if apiKey.WorkspaceID != "" {
+			if !workspaces.Allows(apiKey.Role, workspaces.PermissionGenerate) {
+				ctx.JSON(http.StatusForbidden, gin.H{
+					"error": fmt.Sprintf("The %s role doesn't allow generating slides", apiKey.Role),
+				})
+				return
+			}
+			workspace, err = c.workspaceService.Get(ctx, apiKey.WorkspaceID)
+			if err != nil {
+				respondWorkspaceError(ctx, err)
+				return
+			}
+			options.WorkspaceID = workspace.ID
+			options.Region = workspace.Region
+		}
+	} else if user := middleware.CurrentUser(ctx); user != nil {
+		// Signed-in users own their jobs like API keys do
+		options.Owner = user.OwnerID()
+	}
+
+	// Anonymous jobs need a CAPTCHA solved in the browser, to keep bots off the free tier
+	if options.Owner == "" && c.captchaVerifier != nil && !c.verifyCaptcha(ctx) {
 		return
 	}
 
-	// Parse JSON data from form
-	var req models.SlideRequest
-	jsonData := ctx.PostForm("data")
-	if jsonData == "" {
+	// Fill in the theme and settings left empty from the saved preset
+	if req.PresetID != "" {
+		if options.Owner == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Presets require an X-API-Key header or a signed-in user",
+			})
+			return
+		}
+		preset, err := c.presetService.Get(ctx, presets.Scope(options.Owner, options.WorkspaceID), req.PresetID)
+		if err != nil {
+			respondPresetError(ctx, err)
+			return
+		}
+		preset.ApplyTo(req)
+	}
+
+	// Apply the themes and branding of the workspace after the preset
+	if workspace != nil {
+		if err := workspace.Apply(req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		options.Fonts = workspace.FontFiles(req.Settings)
+	}
+
+	// Library documents are stored and indexed by the workspace, so they are
+	// referenced instead of uploaded
+	var documents []workspaces.Document
+	if len(req.DocumentIDs) > 0 {
+		if workspace == nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Library documents require the X-API-Key header of a workspace member",
+			})
+			return
+		}
+		var err error
+		documents, err = c.workspaceService.References(ctx, workspace.ID, req.DocumentIDs)
+		if errors.Is(err, workspaces.ErrDocumentNotFound) || errors.Is(err, workspaces.ErrInvalidDocument) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			respondWorkspaceError(ctx, err)
+			return
+		}
+		if req.DryRun {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Dry runs don't support library documents, upload the files instead",
+			})
+			return
+		}
+		for i := range documents {
+			options.Documents = append(options.Documents, documents[i].Reference())
+		}
+	}
+
+	// Themes still rolling out are only available to the workspaces they reached
+	options.Features = c.featureService.Evaluate(ctx, options.WorkspaceID)
+	if !features.Flags(options.Features).AllowsTheme(req.Theme) {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing data field in form",
+			"error": fmt.Sprintf("The %s theme isn't available yet", req.Theme),
 		})
 		return
 	}
 
-	if err := json.Unmarshal([]byte(jsonData), &req); err != nil {
+	// Decks from a repository or paper default to the audience it is written for
+	if req.Settings.Audience == "" {
+		for _, source := range req.Sources {
+			if audience := models.SourceAudiences[source.Type]; audience != "" {
+				req.Settings.Audience = audience
+				break
+			}
+		}
+	}
+
+	// Decks are only emailed for API keys and users, so anonymous callers
+	// can't have PDFs of their content sent to any address
+	if req.NotifyEmail != "" {
+		if options.Owner == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "notifyEmail requires an X-API-Key header or a signed-in user",
+			})
+			return
+		}
+		address, err := mail.ParseAddress(req.NotifyEmail)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "notifyEmail must be an email address",
+			})
+			return
+		}
+		// Keep only the address part
+		options.NotifyEmail = address.Address
+	}
+
+	// Labels are only listed in the job history of an API key or user
+	if len(req.Labels) > 0 {
+		if options.Owner == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Labels require an X-API-Key header or a signed-in user",
+			})
+			return
+		}
+		options.Labels = req.Labels
+	}
+
+	// Validate the idempotency key, which makes retries return the same job
+	if key := ctx.GetHeader("Idempotency-Key"); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength),
+			})
+			return
+		}
+		options.IdempotencyKey = key
+	}
+
+	// A retried anonymous request couldn't return the claim token again
+	if options.IdempotencyKey != "" && options.Owner == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Invalid request format: %v", err),
+			"error": "Idempotency-Key requires an X-API-Key header or a signed-in user",
 		})
 		return
 	}
 
-	// Validate theme
-	isValidTheme := false
-	for _, theme := range models.ValidThemes {
-		if req.Theme == theme {
-			isValidTheme = true
-			break
+	// Ephemeral jobs keep nothing once they end, so the deck can't be emailed
+	// and a retried request can't return the result token again
+	if req.Ephemeral {
+		if options.NotifyEmail != "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Ephemeral jobs can't be emailed, fetch the result with the result token instead",
+			})
+			return
+		}
+		if options.IdempotencyKey != "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Ephemeral jobs can't use an Idempotency-Key",
+			})
+			return
+		}
+		options.Ephemeral = true
+	}
+
+	// Captures keep the prompts and responses of a job, which only the admins
+	// of the instance may read
+	if req.Debug {
+		if !middleware.IsAdmin(ctx, c.adminUIDs) {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"error": "Only admins of this instance can debug jobs",
+			})
+			return
+		}
+		if options.Ephemeral {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Ephemeral jobs keep nothing, so they can't be debugged",
+			})
+			return
 		}
+		options.Debug = true
 	}
-	if !isValidTheme {
+
+	// Content sources are read with the credentials of the instance, so only accounts may import them
+	if len(req.Sources) > 0 && options.Owner == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Invalid theme: %s. Supported themes are: %s", req.Theme, strings.Join(models.ValidThemes, ", ")),
+			"error": "Content sources require an X-API-Key header or a signed-in user",
 		})
 		return
 	}
 
-	// Validate slideDetail setting
-	isValidSlideDetail := false
-	if req.Settings.SlideDetail != "" {
-		for _, detail := range models.ValidSlideDetails {
-			if req.Settings.SlideDetail == detail {
-				isValidSlideDetail = true
-				break
-			}
+	// Drive files are read by the slides service with the owner's Drive connection
+	if len(req.DriveFileIDs) > 0 {
+		if !c.driveService.Enabled() {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Google Drive input is not enabled on this instance",
+			})
+			return
 		}
-		if !isValidSlideDetail {
+		if options.Owner == "" {
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Invalid slideDetail: %s. Supported values are: %s", 
-					req.Settings.SlideDetail, strings.Join(models.ValidSlideDetails, ", ")),
+				"error": "Drive files require an X-API-Key header or a signed-in user",
 			})
 			return
 		}
-	}
-
-	// Validate audience setting
-	isValidAudience := false
-	if req.Settings.Audience != "" {
-		for _, audience := range models.ValidAudiences {
-			if req.Settings.Audience == audience {
-				isValidAudience = true
-				break
-			}
+		connection, err := c.driveService.Status(ctx, options.Owner)
+		if err != nil {
+			log.Printf("Failed to check Drive connection: %v", err)
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Failed to check Drive connection",
+			})
+			return
 		}
-		if !isValidAudience {
+		if !connection.Connected {
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Invalid audience: %s. Supported values are: %s", 
-					req.Settings.Audience, strings.Join(models.ValidAudiences, ", ")),
+				"error": "Connect Google Drive before generating from Drive files",
 			})
 			return
 		}
@@ -118,7 +348,24 @@ func (c *SlideController) GenerateSlides(ctx *gin.Context) {
 	}
 
 	files := form.File["files"]
-	if len(files) == 0 {
+
+	// A prompt replaces the documents, the deck is written from the topic alone
+	if req.Prompt != "" {
+		prompt := strings.TrimSpace(req.Prompt)
+		if len(prompt) < minPromptLength {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Prompt must describe the topic of the deck",
+			})
+			return
+		}
+		if len(files) > 0 || len(req.DriveFileIDs) > 0 || len(req.Sources) > 0 || len(documents) > 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Prompt can't be combined with files, Drive files, sources or library documents",
+			})
+			return
+		}
+		options.Prompt = prompt
+	} else if len(files) == 0 && len(req.DriveFileIDs) == 0 && len(req.Sources) == 0 && len(documents) == 0 {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": "No files uploaded",
 		})
@@ -127,7 +374,7 @@ func (c *SlideController) GenerateSlides(ctx *gin.Context) {
 
 	// Read file data into memory to prevent it from being released
 	fileData := make([]models.File, 0, len(files))
-	
+
 	for _, file := range files {
 		// Open the file
 		src, err := file.Open()
@@ -137,56 +384,28 @@ func (c *SlideController) GenerateSlides(ctx *gin.Context) {
 			})
 			return
 		}
-		
+
 		// Read the file data
 		data, err := io.ReadAll(src)
 		src.Close() // Close the file after reading
-		
+
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
 				"error": fmt.Sprintf("Failed to read file %s: %v", file.Filename, err),
 			})
 			return
 		}
-		
-		// Detect MIME type from file content instead of using header
-		// DetectContentType only needs the first 512 bytes
-		mimeType := http.DetectContentType(data)
-		
-		// Remove charset information if present
-		if semicolonIndex := strings.Index(mimeType, ";"); semicolonIndex != -1 {
-			mimeType = strings.TrimSpace(mimeType[:semicolonIndex])
-		}
-		
-		// Validate file type - only allow PDF, Markdown and TXT
-		isAllowed := false
-
-		// Check by file extension first
-		fileExt := strings.ToLower(filepath.Ext(file.Filename))
-		if fileExt == ".pdf" || fileExt == ".md" || fileExt == ".txt" {
-			// Now check MIME type
-			if mimeType == "application/pdf" {
-				// PDF is valid
-				isAllowed = true
-			} else if mimeType == "text/plain" {
-				// Plain text (could be TXT or MD)
-				isAllowed = true
-			} else if strings.Contains(mimeType, "markdown") || strings.Contains(mimeType, "text/") {
-				// Some systems detect markdown as text/markdown, text/x-markdown, or just text/plain
-				// For text files, we'll trust the extension more than the mime type
-				if fileExt == ".md" || fileExt == ".txt" {
-					isAllowed = true
-				}
-			}
-		}
 
+		// Validate file type - only allow PDF, Markdown, TXT and WebVTT or SRT transcripts
+		mimeType, isAllowed := preflight.DetectType(file.Filename, data)
 		if !isAllowed {
+			middleware.ReportOffense(ctx, abuse.OffenseUnsupportedFile)
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Unsupported file type: %s. Only PDF, Markdown, and TXT files are allowed", file.Filename),
+				"error": fmt.Sprintf("Unsupported file type: %s. Only PDF, Markdown, TXT, VTT and SRT files are allowed", file.Filename),
 			})
 			return
 		}
-		
+
 		// Store the file data
 		fileData = append(fileData, models.File{
 			Filename: file.Filename,
@@ -195,161 +414,1557 @@ func (c *SlideController) GenerateSlides(ctx *gin.Context) {
 		})
 	}
 
-	// Log the request
-	log.Printf("Received slide generation request: Theme: %s, Files count: %d, Settings: %+v", 
-		req.Theme, len(fileData), req.Settings)
-
-	// Generate a unique job ID
-	jobID := uuid.New().String()
-
-	// Add job to queue instead of processing immediately
-	job, err := c.queueService.AddJob(ctx, jobID, req.Theme, fileData, req.Settings)
+	// Check the request against the plan of the API key, anonymous requests are on the free plan
+	plan, err := c.billingService.PlanFor(ctx, options.Owner)
 	if err != nil {
+		log.Printf("Failed to get plan: %v", err)
 		ctx.JSON(http.StatusServiceUnavailable, gin.H{
-			"error": err.Error(),
+			"error": "Failed to check plan",
 		})
 		return
 	}
-
-	// Return response immediately with job ID
-	ctx.JSON(http.StatusAccepted, models.SlideResponse{
-		ID:        jobID,
-		Status:    string(job.Status),
-		Message:   job.Message,
-		CreatedAt: job.CreatedAt,
-		UpdatedAt: job.UpdatedAt,
-	})
-}
-
-// StreamSlideStatus handles both regular status checks and SSE streaming of job status updates
-func (c *SlideController) StreamSlideStatus(ctx *gin.Context) {
-	id := ctx.Param("id")
-	if id == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing job ID",
-		})
+	options.TokenLimits = plan.TokenLimits
+	if err := plan.Check(&req.Settings, fileData); err != nil {
+		var limitErr *billing.LimitError
+		if errors.As(err, &limitErr) && limitErr.FileTooLarge {
+			middleware.ReportOffense(ctx, abuse.OffenseOversizedUpload)
+		}
+		respondLimitExceeded(ctx, err)
 		return
 	}
+	for _, document := range documents {
+		if err := plan.CheckSize(document.Filename, document.Size); err != nil {
+			respondLimitExceeded(ctx, err)
+			return
+		}
+	}
 
-	// Get job status from queue
-	job := c.queueService.GetJob(id)
-	if job == nil {
-		ctx.JSON(http.StatusNotFound, gin.H{
-			"error": "Job not found",
-		})
+	// A dry run stops before the job is counted or created
+	if req.DryRun {
+		c.dryRun(ctx, req, options.Prompt, fileData, plan.TokenLimits)
 		return
 	}
 
-	// Check if client accepts SSE
-	acceptHeader := ctx.GetHeader("Accept")
-	wantsSSE := acceptHeader == "text/event-stream"
-
-	// If client doesn't want SSE, return a regular JSON response
-	if !wantsSSE {
-		ctx.JSON(http.StatusOK, gin.H{
-			"id":        job.ID,
-			"status":    job.Status,
-			"message":   job.Message,
-			"resultUrl": job.ResultURL,
-			"updatedAt": job.UpdatedAt,
-		})
+	// A course is split into chapters before any job is counted or created
+	if req.SplitChapters {
+		c.generateBatch(ctx, req, fileData, options, plan)
 		return
 	}
 
-	// For SSE clients, set headers for streaming
-	ctx.Writer.Header().Set("Content-Type", "text/event-stream")
-	ctx.Writer.Header().Set("Cache-Control", "no-cache")
-	ctx.Writer.Header().Set("Connection", "keep-alive")
-	ctx.Writer.Header().Set("Transfer-Encoding", "chunked")
-	ctx.Writer.Header().Set("Access-Control-Allow-Origin", os.Getenv("FRONTEND_URL"))
-	ctx.Writer.Header().Set("X-Accel-Buffering", "no") // Disable buffering in Nginx if used
-	ctx.Writer.Flush()
-
-	// Create channel for job updates and set up a cancellation context
-	updates := make(chan queue.JobUpdate, 10)
-	streamCtx, cancelStream := context.WithCancel(ctx.Request.Context())
-	defer cancelStream()
-
-	// Watch for job updates from Firestore
-	go func() {
-		defer close(updates)
-		err := c.queueService.WatchJob(streamCtx, id, updates)
-		if err != nil && err != context.Canceled {
-			log.Printf("Error watching job %s: %v", id, err)
+	// Content sources are imported by the slides service, which also applies the size limit
+	if len(req.Sources) > 0 {
+		for _, source := range req.Sources {
+			options.Sources = append(options.Sources, queue.SourceReference{Type: source.Type, Location: source.Location, Options: source.Options})
 		}
-	}()
+		options.MaxSourceBytes = plan.MaxFileBytes
+	}
 
-	// Stream events to client
-	ctx.Stream(func(w io.Writer) bool {
-		// Check if client closed connection
-		if ctx.Request.Context().Err() != nil {
-			cancelStream()
-			return false
+	// Drive files are only downloaded by the slides service, which applies the size limit of the plan
+	if len(req.DriveFileIDs) > 0 {
+		options.Drive = &queue.DriveFiles{
+			Owner:        options.Owner,
+			FileIDs:      req.DriveFileIDs,
+			MaxFileBytes: plan.MaxFileBytes,
 		}
+	}
 
-		select {
-		case update, ok := <-updates:
-			if !ok {
-				return false // Channel closed
-			}
-
-			// Send SSE event with job update
-			ctx.SSEvent("update", update)
-			
-			// If job is completed or failed, end the stream
-			if update.Status == queue.StatusCompleted || update.Status == queue.StatusFailed {
-				// Send a final event indicating the stream will close
-				ctx.SSEvent("close", gin.H{
-					"id":      update.ID,
-					"status":  update.Status,
-					"message": "Stream closing normally",
-				})
-				ctx.Writer.Flush()
-				
-				// Wait a moment before closing to ensure the message is sent
-				time.Sleep(100 * time.Millisecond)
-				
-				cancelStream()
-				return false
-			}
-			
-			return true
+	// Log the request
+	log.Printf("Received slide generation request: Theme: %s, Files count: %d, Library documents: %d, Settings: %+v",
+		req.Theme, len(fileData), len(documents), req.Settings)
 
-		case <-time.After(30 * time.Second):
-			// Send heartbeat to keep connection alive
-			ctx.SSEvent("ping", nil)
-			return true
+	// Count the job against the daily quota of anonymous clients, API keys and users aren't limited by IP
+	if options.Owner == "" {
+		usage, err := c.quotaService.Consume(ctx, ctx.ClientIP())
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			middleware.ReportOffense(ctx, abuse.OffenseQuotaExceeded)
+			ctx.Header("Retry-After", strconv.Itoa(int(time.Until(exceeded.ResetsAt).Seconds())+1))
+			ctx.JSON(http.StatusTooManyRequests, gin.H{
+				"error":    fmt.Sprintf("Quota exceeded: anonymous use is limited to %d jobs per day, resets at %s. Use an API key for more.", exceeded.Limit, exceeded.ResetsAt.Format(time.RFC3339)),
+				"limit":    exceeded.Limit,
+				"resetsAt": exceeded.ResetsAt.Unix(),
+			})
+			return
 		}
-	})
-}
+		if err != nil {
+			log.Printf("Failed to check quota: %v", err)
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Failed to check quota",
+			})
+			return
+		}
+		if usage != nil {
+			ctx.Header("X-RateLimit-Limit", strconv.Itoa(usage.Limit))
+			ctx.Header("X-RateLimit-Remaining", strconv.Itoa(usage.Remaining))
+			ctx.Header("X-RateLimit-Reset", strconv.FormatInt(usage.ResetsAt.Unix(), 10))
+		}
+	}
 
-// GetSlideResult handles retrieving and serving the presentation result
-func (c *SlideController) GetSlideResult(ctx *gin.Context) {
-	id := ctx.Param("id")
-	if id == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing result ID",
-		})
+	// Count the email against the daily limit of its recipient
+	if options.NotifyEmail != "" && !c.consumeRecipient(ctx, options.NotifyEmail) {
 		return
 	}
 
-	// Retrieve the result from Firestore
-	result, err := c.queueService.GetResult(ctx, id)
+	// Count the job against the monthly allowance of the plan
+	tokens := billing.EstimateTokens(fileData)
+	for _, document := range documents {
+		tokens += billing.EstimateFileTokens(document.Type, document.Size)
+	}
+	if err := c.billingService.Consume(ctx, options.Owner, plan, tokens); err != nil {
+		respondLimitExceeded(ctx, err)
+		return
+	}
+
+	// Generate a unique job ID in the region of the workspace
+	jobID := c.queueService.NewJobID(options.Region)
+
+	// Add job to queue instead of processing immediately
+	job, err := c.queueService.AddJob(ctx, jobID, req.Theme, fileData, req.Settings, options)
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{
-			"error": fmt.Sprintf("Result not found: %v", err),
+		c.billingService.Refund(ctx, options.Owner, plan, 1, tokens)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
 
-	download := ctx.Query("download")
+	// A retried request returns the job created by the first attempt, which was already counted
+	status := http.StatusAccepted
+	if job.ID != jobID {
+		c.billingService.Refund(ctx, options.Owner, plan, 1, tokens)
+		ctx.Header("Idempotent-Replayed", "true")
+		status = http.StatusOK
+	}
 
-	if download == "true" {
-		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=presentation-%s.pdf", id))
-		ctx.Data(http.StatusOK, "application/pdf", result.PDFData)
-	} else {
-		ctx.Header("Content-Type", "text/html")
-		ctx.Data(http.StatusOK, "text/html", result.HTMLData)
+	// Return response immediately with job ID
+	ctx.JSON(status, jobResponse(ctx, job))
+}
+
+// jobResponse returns the response to the creation of a job
+func jobResponse(ctx *gin.Context, job *queue.Job) models.SlideResponse {
+	return models.SlideResponse{
+		ID:            job.ID,
+		Status:        string(job.Status),
+		Message:       i18n.Localize(messageLanguage(ctx), job.MessageCode, job.MessageParams, job.Message),
+		MessageCode:   job.MessageCode,
+		MessageParams: job.MessageParams,
+		CreatedAt:     job.CreatedAt,
+		UpdatedAt:     job.UpdatedAt,
+		ResultToken:   job.ResultToken,
+		ClaimToken:    job.ClaimToken,
+		Warnings:      job.Warnings,
+	}
+}
+
+// generateBatch splits the uploaded documents of a validated request into
+// chapters and creates a job for the deck of each chapter, plus one for an
+// overview deck of the whole documents, under a batch whose progress is
+// followed on the batch endpoint
+func (c *SlideController) generateBatch(ctx *gin.Context, req *models.SlideRequest, files []models.File, options queue.JobOptions, plan billing.Plan) {
+	if c.estimateClient == nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Splitting into chapters is not available on this instance",
+		})
+		return
 	}
-	return
-} 
\ No newline at end of file
+	// The batch is looked up by its owner, and the chapters are split from
+	// uploads before the jobs exist
+	if options.Owner == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Splitting into chapters requires an X-API-Key header or a signed-in user",
+		})
+		return
+	}
+	if options.Prompt != "" || len(req.DriveFileIDs) > 0 || len(req.Sources) > 0 || len(options.Documents) > 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Splitting into chapters only supports uploaded files",
+		})
+		return
+	}
+	if options.Ephemeral || options.IdempotencyKey != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Splitting into chapters can't be combined with ephemeral jobs or an Idempotency-Key",
+		})
+		return
+	}
+
+	chapters, err := c.estimateClient.SplitChapters(ctx, files)
+	if err != nil {
+		log.Printf("Failed to split chapters: %v", err)
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to split the documents into chapters",
+		})
+		return
+	}
+	if len(chapters) < 2 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "No chapters were found in the documents, generate a single deck instead",
+		})
+		return
+	}
+
+	// The overview reads the whole documents and each chapter its own text
+	tokens := billing.EstimateTokens(files) + chapterTokens(chapters)
+	titles := make([]string, len(chapters))
+	for i, chapter := range chapters {
+		titles[i] = chapter.Title
+	}
+	if err := c.billingService.ConsumeJobs(ctx, options.Owner, plan, len(chapters)+1, tokens); err != nil {
+		respondLimitExceeded(ctx, err)
+		return
+	}
+
+	log.Printf("Received chapter split request: Theme: %s, Files count: %d, Chapters: %d, Settings: %+v",
+		req.Theme, len(files), len(chapters), req.Settings)
+
+	batch := &batches.Batch{
+		ID:          uuid.New().String(),
+		Owner:       options.Owner,
+		WorkspaceID: options.WorkspaceID,
+		CreatedAt:   time.Now().Unix(),
+	}
+	labels := map[string]string{}
+	for key, value := range req.Labels {
+		labels[key] = value
+	}
+	labels[batches.LabelKey] = batch.ID
+	options.Labels = labels
+
+	overviewSettings := req.Settings
+	overviewSettings.Chapters = titles
+	overview, err := c.queueService.AddJob(ctx, c.queueService.NewJobID(options.Region), req.Theme, files, overviewSettings, options)
+	if err != nil {
+		c.billingService.Refund(ctx, options.Owner, plan, len(chapters)+1, tokens)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	batch.OverviewJobID = overview.ID
+	response := models.BatchResponse{ID: batch.ID, Overview: jobResponse(ctx, overview)}
+
+	chapterSettings := req.Settings
+	chapterSettings.Chapters = nil
+	for i, chapter := range chapters {
+		chapterFiles := []models.File{{
+			Filename: fmt.Sprintf("chapter-%d.md", i+1),
+			Data:     []byte(chapter.Markdown),
+			Type:     "text/markdown",
+		}}
+		job, err := c.queueService.AddJob(ctx, c.queueService.NewJobID(options.Region), req.Theme, chapterFiles, chapterSettings, options)
+		if err != nil {
+			log.Printf("Failed to add job for chapter %d of batch %s: %v", i+1, batch.ID, err)
+			c.billingService.Refund(ctx, options.Owner, plan, len(chapters)-i, chapterTokens(chapters[i:]))
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		batch.Chapters = append(batch.Chapters, batches.Chapter{Title: chapter.Title, JobID: job.ID})
+		response.Chapters = append(response.Chapters, models.ChapterResponse{Title: chapter.Title, Job: jobResponse(ctx, job)})
+	}
+
+	if err := c.batchService.Create(ctx, batch); err != nil {
+		log.Printf("Failed to save batch %s: %v", batch.ID, err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to save batch",
+		})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, response)
+}
+
+// chapterTokens estimates the Gemini input tokens of the decks of chapters
+func chapterTokens(chapters []estimates.Chapter) int {
+	tokens := 0
+	for _, chapter := range chapters {
+		tokens += billing.EstimateFileTokens("text/markdown", len(chapter.Markdown))
+	}
+	return tokens
+}
+
+// dryRun responds with the final prompt, token projections and estimated
+// slide count of a validated request, which the slides service builds
+// without calling Gemini or rendering the deck
+func (c *SlideController) dryRun(ctx *gin.Context, req *models.SlideRequest, prompt string, files []models.File, limits models.TokenLimits) {
+	if c.estimateClient == nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Dry runs are not available on this instance",
+		})
+		return
+	}
+	// Drive files and sources are only read by the job
+	if len(req.DriveFileIDs) > 0 || len(req.Sources) > 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Dry runs only support uploaded files or a prompt",
+		})
+		return
+	}
+
+	log.Printf("Received dry run request: Theme: %s, Files count: %d, Settings: %+v", req.Theme, len(files), req.Settings)
+	dryRun, err := c.estimateClient.DryRun(ctx, req.Theme, prompt, files, req.Settings, limits)
+	if err != nil {
+		log.Printf("Failed to dry run job: %v", err)
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to dry run job",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, dryRun)
+}
+
+// ValidateFiles checks uploaded files before a job is created from them: their
+// type, size, pages and text, and the tokens, processing time and allowance a
+// job would take. The report is returned whether or not the files would be
+// accepted, nothing is stored.
+func (c *SlideController) ValidateFiles(ctx *gin.Context) {
+	var owner string
+	if key := ctx.GetHeader("X-API-Key"); key != "" {
+		apiKey, err := c.apiKeyService.Lookup(ctx, key)
+		if err != nil {
+			respondInvalidAPIKey(ctx, err)
+			return
+		}
+		owner = apiKey.ID
+	} else if user := middleware.CurrentUser(ctx); user != nil {
+		owner = user.OwnerID()
+	}
+
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to get files",
+		})
+		return
+	}
+	headers := form.File["files"]
+	if len(headers) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "No files uploaded",
+		})
+		return
+	}
+
+	// Unsupported files are kept with an empty type so they are reported
+	files := make([]models.File, 0, len(headers))
+	for _, header := range headers {
+		src, err := header.Open()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to open file %s: %v", header.Filename, err),
+			})
+			return
+		}
+		data, err := io.ReadAll(src)
+		src.Close()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to read file %s: %v", header.Filename, err),
+			})
+			return
+		}
+		mimeType, ok := preflight.DetectType(header.Filename, data)
+		if !ok {
+			mimeType = ""
+		}
+		files = append(files, models.File{Filename: header.Filename, Data: data, Type: mimeType})
+	}
+
+	plan, err := c.billingService.PlanFor(ctx, owner)
+	if err != nil {
+		log.Printf("Failed to get plan: %v", err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to check plan",
+		})
+		return
+	}
+	report := preflight.Check(files, plan)
+
+	// Only accounts have a monthly allowance, anonymous jobs count against the daily quota
+	if owner != "" {
+		usage, err := c.billingService.GetUsage(ctx, owner)
+		if err != nil {
+			log.Printf("Failed to get usage: %v", err)
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Failed to check usage",
+			})
+			return
+		}
+		report.ApplyUsage(usage)
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// ListJobs lists the jobs created with an API key or by the signed-in user,
+// optionally filtered by labels given as label=key:value query parameters
+func (c *SlideController) ListJobs(ctx *gin.Context) {
+	owner, ok := requireAccount(ctx, c.apiKeyService)
+	if !ok {
+		return
+	}
+	listJobs(ctx, c.queueService, queue.JobQuery{Owner: owner})
+}
+
+// GetBatch returns the chapters of a batch with the status of their jobs,
+// which are also listed with the batch label in the job history
+func (c *SlideController) GetBatch(ctx *gin.Context) {
+	owner, ok := requireAccount(ctx, c.apiKeyService)
+	if !ok {
+		return
+	}
+	batch, err := c.batchService.Get(ctx, owner, ctx.Param("id"))
+	if errors.Is(err, batches.ErrBatchNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Batch not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get batch: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get batch",
+		})
+		return
+	}
+
+	jobs, err := c.queueService.ListJobs(ctx, queue.JobQuery{Owner: owner, Labels: map[string]string{batches.LabelKey: batch.ID}}, maxJobHistoryLimit)
+	if err != nil {
+		log.Printf("Failed to list jobs of batch %s: %v", batch.ID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list jobs",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"batch": batch,
+		"jobs":  jobs,
+	})
+}
+
+// listJobs responds with the jobs matching a query, filtered by the label and
+// limit query parameters
+func listJobs(ctx *gin.Context, queueService *queue.Service, query queue.JobQuery) {
+	// Parse the label filters
+	filter := models.JobFilter{Labels: make(map[string]string)}
+	for _, label := range ctx.QueryArray("label") {
+		parts := strings.SplitN(label, ":", 2)
+		if len(parts) != 2 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid label filter: %s. Use label=key:value", label),
+			})
+			return
+		}
+		filter.Labels[parts[0]] = parts[1]
+	}
+	if violations := middleware.Validate(&filter); len(violations) > 0 {
+		middleware.AbortWithViolations(ctx, violations)
+		return
+	}
+	query.Labels = filter.Labels
+
+	// Parse the limit
+	limit := defaultJobHistoryLimit
+	if value := ctx.Query("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxJobHistoryLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid limit: %s. Use a number between 1 and %d", value, maxJobHistoryLimit),
+			})
+			return
+		}
+	}
+
+	jobs, err := queueService.ListJobs(ctx, query, limit)
+	if err != nil {
+		log.Printf("Failed to list jobs: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list jobs",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"jobs": jobs,
+	})
+}
+
+// consumeRecipient counts a notification email against the daily limit of
+// its recipient, or responds with an error and returns false
+func (c *SlideController) consumeRecipient(ctx *gin.Context, address string) bool {
+	_, err := c.quotaService.ConsumeRecipient(ctx, address)
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		ctx.Header("Retry-After", strconv.Itoa(int(time.Until(exceeded.ResetsAt).Seconds())+1))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{
+			"error":    fmt.Sprintf("notifyEmail has already been sent %d decks today, resets at %s", exceeded.Limit, exceeded.ResetsAt.Format(time.RFC3339)),
+			"limit":    exceeded.Limit,
+			"resetsAt": exceeded.ResetsAt.Unix(),
+		})
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to check the email limit: %v", err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to check the email limit",
+		})
+		return false
+	}
+	return true
+}
+
+// verifyCaptcha checks the CAPTCHA token of an anonymous request, or responds
+// with an error and returns false
+func (c *SlideController) verifyCaptcha(ctx *gin.Context) bool {
+	err := c.captchaVerifier.Verify(ctx, ctx.GetHeader("X-Captcha-Token"), ctx.ClientIP())
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, captcha.ErrMissingToken) || errors.Is(err, captcha.ErrInvalidToken) {
+		if errors.Is(err, captcha.ErrInvalidToken) {
+			middleware.ReportOffense(ctx, abuse.OffenseInvalidCaptcha)
+		}
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error":   fmt.Sprintf("Solve the CAPTCHA and send its token in the X-Captcha-Token header, or use an API key: %v", err),
+			"captcha": c.captchaVerifier.Provider(),
+		})
+		return false
+	}
+	log.Printf("Failed to verify CAPTCHA: %v", err)
+	ctx.JSON(http.StatusServiceUnavailable, gin.H{
+		"error": "Failed to verify CAPTCHA",
+	})
+	return false
+}
+
+// respondLimitExceeded responds to a request that the plan of the caller doesn't allow
+func respondLimitExceeded(ctx *gin.Context, err error) {
+	var limitErr *billing.LimitError
+	if !errors.As(err, &limitErr) {
+		log.Printf("Failed to check plan allowance: %v", err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to check plan allowance",
+		})
+		return
+	}
+	ctx.JSON(http.StatusPaymentRequired, gin.H{
+		"error": limitErr.Message,
+		"plan":  limitErr.Plan,
+	})
+}
+
+// RefineSlides queues a chat-style instruction such as "shorten section 2" to
+// be applied to a generated deck, producing a new revision. With a slide
+// number it is a thumbs-down on that slide, which is regenerated alone with
+// the instruction as the reason. Progress is followed on the status endpoint
+// of the deck like a generation.
+func (c *SlideController) RefineSlides(ctx *gin.Context) {
+	var req models.RefineRequest
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request format: %v", err),
+		})
+		return
+	}
+	if violations := middleware.Validate(&req); len(violations) > 0 {
+		middleware.AbortWithViolations(ctx, violations)
+		return
+	}
+	instruction := strings.TrimSpace(req.Instruction)
+	if instruction == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Instruction must describe the changes to make or why the slide was rejected",
+		})
+		return
+	}
+
+	deck, apiKey, ok := c.requireDeck(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+	options := queue.JobOptions{}
+	if apiKey != nil {
+		options.Webhooks = apiKeyWebhooks(apiKey)
+	}
+
+	// Check the revision and job before counting the refinement
+	if req.Revision > deck.Revision {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Revision %d not found, the latest revision is %d", req.Revision, deck.Revision),
+		})
+		return
+	}
+	if job := c.queueService.GetJob(deck.ID); job != nil && !job.Status.Terminal() {
+		respondJobInProgress(ctx)
+		return
+	}
+
+	// A refinement counts as a job, against the IP quota of anonymous decks
+	// and the monthly allowance of the deck owner
+	plan, err := c.billingService.PlanFor(ctx, deck.Owner)
+	if err != nil {
+		log.Printf("Failed to get plan: %v", err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to check plan",
+		})
+		return
+	}
+	if deck.Owner == "" {
+		_, err := c.quotaService.Consume(ctx, ctx.ClientIP())
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			middleware.ReportOffense(ctx, abuse.OffenseQuotaExceeded)
+			ctx.Header("Retry-After", strconv.Itoa(int(time.Until(exceeded.ResetsAt).Seconds())+1))
+			ctx.JSON(http.StatusTooManyRequests, gin.H{
+				"error":    fmt.Sprintf("Quota exceeded: anonymous use is limited to %d jobs per day, resets at %s. Use an API key for more.", exceeded.Limit, exceeded.ResetsAt.Format(time.RFC3339)),
+				"limit":    exceeded.Limit,
+				"resetsAt": exceeded.ResetsAt.Unix(),
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to check quota: %v", err)
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Failed to check quota",
+			})
+			return
+		}
+	}
+	if err := c.billingService.Consume(ctx, deck.Owner, plan, 0); err != nil {
+		respondLimitExceeded(ctx, err)
+		return
+	}
+	options.TokenLimits = plan.TokenLimits
+
+	job, err := c.queueService.RefineDeck(ctx, deck, req.Revision, req.Slide, instruction, options)
+	if err != nil {
+		c.billingService.Refund(ctx, deck.Owner, plan, 1, 0)
+	}
+	switch {
+	case errors.Is(err, queue.ErrRevisionNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Revision %d not found, the latest revision is %d", req.Revision, deck.Revision),
+		})
+		return
+	case errors.Is(err, queue.ErrJobInProgress):
+		respondJobInProgress(ctx)
+		return
+	case err != nil:
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, models.SlideResponse{
+		ID:            job.ID,
+		Status:        string(job.Status),
+		Message:       i18n.Localize(messageLanguage(ctx), job.MessageCode, job.MessageParams, job.Message),
+		MessageCode:   job.MessageCode,
+		MessageParams: job.MessageParams,
+		CreatedAt:     job.CreatedAt,
+		UpdatedAt:     job.UpdatedAt,
+	})
+}
+
+// GetRevisionDiff returns the slides added, removed and modified by a revision
+// of a deck, compared with the revision it was refined from or the revision
+// given by the against query parameter
+func (c *SlideController) GetRevisionDiff(ctx *gin.Context) {
+	deck, _, ok := c.requireDeck(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+
+	number, err := strconv.Atoi(ctx.Param("rev"))
+	if err != nil || number < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid revision: %s", ctx.Param("rev")),
+		})
+		return
+	}
+	revision, ok := c.requireRevision(ctx, deck, number)
+	if !ok {
+		return
+	}
+
+	against := revision.BaseRevision
+	if against == 0 {
+		against = number - 1
+	}
+	if value := ctx.Query("against"); value != "" {
+		against, err = strconv.Atoi(value)
+		if err != nil || against < 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid revision to compare against: %s", value),
+			})
+			return
+		}
+	}
+	if against == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Revision 1 is the generated deck and has no earlier revision to compare with",
+		})
+		return
+	}
+	previous, ok := c.requireRevision(ctx, deck, against)
+	if !ok {
+		return
+	}
+
+	diff := revisions.Compare(previous.Markdown, revision.Markdown)
+	ctx.JSON(http.StatusOK, gin.H{
+		"id":          deck.ID,
+		"revision":    number,
+		"against":     against,
+		"instruction": revision.Instruction,
+		"slide":       revision.Slide,
+		"summary":     diff.Summary,
+		"slides":      diff.Slides,
+	})
+}
+
+// CompareDecks compares the structure of the latest revisions of two decks,
+// such as decks of the same document generated with different settings: their
+// slide counts and media, and the topics one covers and the other omits
+func (c *SlideController) CompareDecks(ctx *gin.Context) {
+	deck, _, ok := c.requireDeck(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+	other, _, ok := c.requireDeck(ctx, ctx.Param("otherId"))
+	if !ok {
+		return
+	}
+	revision, ok := c.requireRevision(ctx, deck, deck.Revision)
+	if !ok {
+		return
+	}
+	otherRevision, ok := c.requireRevision(ctx, other, other.Revision)
+	if !ok {
+		return
+	}
+
+	comparison := revisions.CompareDecks(revision.Markdown, otherRevision.Markdown)
+	ctx.JSON(http.StatusOK, gin.H{
+		"id":            deck.ID,
+		"revision":      deck.Revision,
+		"otherId":       other.ID,
+		"otherRevision": other.Revision,
+		"deck":          comparison.Deck,
+		"other":         comparison.Other,
+		"sharedTopics":  comparison.Shared,
+		"onlyInDeck":    comparison.OnlyInDeck,
+		"onlyInOther":   comparison.OnlyInOther,
+	})
+}
+
+// GetCapture returns the prompts and raw Gemini responses captured for a job
+// an admin debugged, so a bad output can be reproduced. Captures are kept for
+// a day after the job calls Gemini.
+func (c *SlideController) GetCapture(ctx *gin.Context) {
+	capture, err := c.queueService.GetCapture(ctx, ctx.Param("id"))
+	if errors.Is(err, queue.ErrCaptureNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "No capture for this job, it wasn't debugged or the capture expired",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get capture: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get capture",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, capture)
+}
+
+// ReplayJob runs the captured inputs of a debugged job again with the current
+// prompts and model, as a new debugged job owned by the admin, which
+// CompareReplay then compares with the original
+func (c *SlideController) ReplayJob(ctx *gin.Context) {
+	var owner string
+	if user := middleware.CurrentUser(ctx); user != nil {
+		owner = user.OwnerID()
+	}
+	job, err := c.queueService.ReplayJob(ctx, ctx.Param("id"), c.queueService.NewJobID(c.queueService.Region(ctx.Param("id"))), owner)
+	switch {
+	case errors.Is(err, queue.ErrCaptureNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "No capture for this job, it wasn't debugged or the capture expired",
+		})
+		return
+	case errors.Is(err, queue.ErrNotReplayable):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "The capture of this job doesn't keep its inputs, debug the job again to replay it",
+		})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, models.SlideResponse{
+		ID:            job.ID,
+		Status:        string(job.Status),
+		Message:       i18n.Localize(messageLanguage(ctx), job.MessageCode, job.MessageParams, job.Message),
+		MessageCode:   job.MessageCode,
+		MessageParams: job.MessageParams,
+		CreatedAt:     job.CreatedAt,
+		UpdatedAt:     job.UpdatedAt,
+	})
+}
+
+// CompareReplay returns the generated decks, prompts and token usage of a job
+// and its replay side by side, with the diff between the decks
+func (c *SlideController) CompareReplay(ctx *gin.Context) {
+	comparison, err := c.queueService.CompareReplay(ctx, ctx.Param("id"), ctx.Param("replayId"))
+	switch {
+	case errors.Is(err, queue.ErrNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Job or replay not found",
+		})
+		return
+	case errors.Is(err, queue.ErrJobInProgress):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "The job or its replay is still running",
+		})
+		return
+	case err != nil:
+		log.Printf("Failed to compare replay: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to compare replay",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, comparison)
+}
+
+// requireRevision returns a revision of a deck, or responds with an error and returns false
+func (c *SlideController) requireRevision(ctx *gin.Context, deck *queue.FirestoreDeck, number int) (*queue.FirestoreRevision, bool) {
+	revision, err := c.queueService.GetRevision(ctx, deck.ID, number)
+	if errors.Is(err, queue.ErrRevisionNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Revision %d not found, the latest revision is %d", number, deck.Revision),
+		})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get revision: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get revision",
+		})
+		return nil, false
+	}
+	return revision, true
+}
+
+// requireDeck returns the deck with an ID and the API key of the request, if
+// one was sent, or responds with an error and returns false.
+// Decks of an account are only available to it and its workspace, anonymous
+// decks to the holder of the claim token of their job like their results.
+func (c *SlideController) requireDeck(ctx *gin.Context, id string) (*queue.FirestoreDeck, *apikeys.APIKey, bool) {
+	deck, err := c.queueService.GetDeck(ctx, id)
+	if errors.Is(err, queue.ErrNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Deck not found",
+		})
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get deck: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get deck",
+		})
+		return nil, nil, false
+	}
+
+	access, apiKey, ok := requestAccess(ctx, c.apiKeyService, workspaces.PermissionGenerate)
+	if !ok {
+		return nil, nil, false
+	}
+	if err := access.Check(deck.Owner, deck.WorkspaceID, deck.ClaimTokenHash); err != nil {
+		respondAccessDenied(ctx, err, "deck")
+		return nil, nil, false
+	}
+	return deck, apiKey, true
+}
+
+// requestAccess returns who a request comes from and its API key, if one was
+// sent, or responds with an error and returns false when the key is invalid.
+// Members of a workspace only access its jobs when their role has permission.
+func requestAccess(ctx *gin.Context, apiKeyService *apikeys.Service, permission workspaces.Permission) (queue.Access, *apikeys.APIKey, bool) {
+	access := queue.Access{Claims: claimTokens(ctx)}
+	if key := ctx.GetHeader("X-API-Key"); key != "" {
+		apiKey, err := apiKeyService.Lookup(ctx, key)
+		if err != nil {
+			respondInvalidAPIKey(ctx, err)
+			return queue.Access{}, nil, false
+		}
+		access.Owner = apiKey.ID
+		if workspaces.Allows(apiKey.Role, permission) {
+			access.WorkspaceID = apiKey.WorkspaceID
+		}
+		return access, apiKey, true
+	}
+	if user := middleware.CurrentUser(ctx); user != nil {
+		access.Owner = user.OwnerID()
+	}
+	return access, nil, true
+}
+
+// respondAccessDenied responds to a request for a job, result or deck that
+// queue.Access.Check refused
+func respondAccessDenied(ctx *gin.Context, err error, what string) {
+	if errors.Is(err, queue.ErrClaimRequired) {
+		respondClaimRequired(ctx)
+		return
+	}
+	ctx.JSON(http.StatusForbidden, gin.H{
+		"error": fmt.Sprintf("Only the owner of the %s can access it", what),
+	})
+}
+
+// requireResultAccess reports whether a request may read a result, which is
+// available to the owner of its job and its workspace, or for anonymous jobs
+// to the holder of the claim token, or responds with an error
+func (c *SlideController) requireResultAccess(ctx *gin.Context, result *queue.FirestoreResult) bool {
+	access, _, ok := requestAccess(ctx, c.apiKeyService, workspaces.PermissionView)
+	if !ok {
+		return false
+	}
+	if err := access.Check(result.Owner, result.WorkspaceID, result.ClaimTokenHash); err != nil {
+		respondAccessDenied(ctx, err, "result")
+		return false
+	}
+	return true
+}
+
+// claimTokens returns the claim tokens sent with a request, in the
+// X-Claim-Token header or the claim query parameter, which EventSource
+// clients can't send headers instead of. Requests for several jobs send their
+// tokens comma-separated.
+func claimTokens(ctx *gin.Context) []string {
+	var tokens []string
+	for _, value := range append(ctx.Request.Header.Values("X-Claim-Token"), ctx.QueryArray("claim")...) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// respondClaimRequired responds to a request for an anonymous job, result or
+// deck without the claim token of the job
+func respondClaimRequired(ctx *gin.Context) {
+	middleware.ReportOffense(ctx, abuse.OffenseMissingClaim)
+	ctx.JSON(http.StatusForbidden, gin.H{
+		"error": "Anonymous jobs can only be accessed with the claimToken returned when they were created, as the X-Claim-Token header or claim query parameter",
+	})
+}
+
+// respondJobInProgress responds to a refinement of a deck whose job is still running
+func respondJobInProgress(ctx *gin.Context) {
+	ctx.JSON(http.StatusConflict, gin.H{
+		"error": "The deck is still being generated or refined, try again once it completes",
+	})
+}
+
+// StreamSlideStatus handles both regular status checks and SSE streaming of job status updates
+func (c *SlideController) StreamSlideStatus(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing job ID",
+		})
+		return
+	}
+
+	// Get job status from queue
+	job := c.queueService.GetJob(id)
+	if job == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Job not found",
+		})
+		return
+	}
+	if !queue.ValidClaim(job.ClaimTokenHash, claimTokens(ctx)) {
+		respondClaimRequired(ctx)
+		return
+	}
+
+	// Check if client accepts SSE
+	acceptHeader := ctx.GetHeader("Accept")
+	wantsSSE := acceptHeader == "text/event-stream"
+	language := messageLanguage(ctx)
+
+	// If client doesn't want SSE, return a regular JSON response
+	if !wantsSSE {
+		status := queue.JobUpdate{
+			ID:            job.ID,
+			Status:        job.Status,
+			Message:       job.Message,
+			ResultURL:     job.ResultURL,
+			UpdatedAt:     job.UpdatedAt,
+			Warnings:      job.Warnings,
+			MessageCode:   job.MessageCode,
+			MessageParams: job.MessageParams,
+		}
+
+		// With wait, long-poll for clients whose proxies break streaming
+		if waitParam := ctx.Query("wait"); waitParam != "" {
+			wait, err := parseWait(waitParam)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("Invalid wait: %v", err),
+				})
+				return
+			}
+			var since int64
+			if sinceParam := ctx.Query("since"); sinceParam != "" {
+				if since, err = strconv.ParseInt(sinceParam, 10, 64); err != nil || since < 0 {
+					ctx.JSON(http.StatusBadRequest, gin.H{
+						"error": "Invalid since, use the updatedAt of the last status",
+					})
+					return
+				}
+			}
+			update, err := c.queueService.WaitForJobUpdate(ctx.Request.Context(), id, since, wait)
+			if ctx.Request.Context().Err() != nil {
+				return // Client went away
+			}
+			if err != nil {
+				log.Printf("Error waiting for job %s: %v", id, err)
+			} else {
+				status = *update
+			}
+			ctx.Header("Cache-Control", "no-store")
+		}
+
+		ctx.JSON(http.StatusOK, statusJSON(localizeUpdate(language, status)))
+		return
+	}
+
+	// For SSE clients, set headers for streaming
+	c.startEventStream(ctx)
+
+	// Create channel for job updates and set up a cancellation context
+	updates := make(chan queue.JobUpdate, 10)
+	streamCtx, cancelStream := context.WithCancel(ctx.Request.Context())
+	defer cancelStream()
+
+	// Watch for job updates from Firestore
+	go func() {
+		defer close(updates)
+		err := c.queueService.WatchJob(streamCtx, id, updates)
+		if err != nil && err != context.Canceled {
+			log.Printf("Error watching job %s: %v", id, err)
+		}
+	}()
+
+	// Stream events to client
+	ctx.Stream(func(w io.Writer) bool {
+		// Check if client closed connection
+		if ctx.Request.Context().Err() != nil {
+			cancelStream()
+			return false
+		}
+
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return false // Channel closed
+			}
+
+			// Send SSE event with job update
+			ctx.SSEvent("update", localizeUpdate(language, update))
+
+			// If job is completed or failed, end the stream
+			if update.Status.Terminal() {
+				// Send a final event indicating the stream will close
+				ctx.SSEvent("close", gin.H{
+					"id":      update.ID,
+					"status":  update.Status,
+					"message": "Stream closing normally",
+				})
+				ctx.Writer.Flush()
+
+				// Wait a moment before closing to ensure the message is sent
+				time.Sleep(100 * time.Millisecond)
+
+				cancelStream()
+				return false
+			}
+
+			return true
+
+		case <-time.After(c.heartbeat):
+			// Send heartbeat to keep connection alive
+			sendKeepalive(ctx)
+			return true
+		}
+	})
+}
+
+// StreamSlideStatuses streams the status updates of several jobs over one SSE
+// connection, for clients generating decks in batches. Each update names its
+// job, and the stream closes once every job has completed or failed.
+func (c *SlideController) StreamSlideStatuses(ctx *gin.Context) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(ctx.Query("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing job IDs",
+		})
+		return
+	}
+	if len(ids) > maxStreamJobs {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Too many jobs, at most %d can be streamed at once", maxStreamJobs),
+		})
+		return
+	}
+
+	// Every job must exist and be claimed before the stream starts
+	claims := claimTokens(ctx)
+	language := messageLanguage(ctx)
+	statuses := make([]gin.H, 0, len(ids))
+	for _, id := range ids {
+		job := c.queueService.GetJob(id)
+		if job == nil {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": fmt.Sprintf("Job not found: %s", id),
+			})
+			return
+		}
+		if !queue.ValidClaim(job.ClaimTokenHash, claims) {
+			respondClaimRequired(ctx)
+			return
+		}
+		statuses = append(statuses, statusJSON(localizeUpdate(language, queue.JobUpdate{
+			ID:            job.ID,
+			Status:        job.Status,
+			Message:       job.Message,
+			ResultURL:     job.ResultURL,
+			UpdatedAt:     job.UpdatedAt,
+			Warnings:      job.Warnings,
+			MessageCode:   job.MessageCode,
+			MessageParams: job.MessageParams,
+		})))
+	}
+
+	// Without SSE, return the current status of each job
+	if ctx.GetHeader("Accept") != "text/event-stream" {
+		ctx.JSON(http.StatusOK, gin.H{"jobs": statuses})
+		return
+	}
+
+	c.startEventStream(ctx)
+
+	// Watch every job with its own listener, the updates share one channel
+	updates := make(chan queue.JobUpdate, len(ids))
+	streamCtx, cancelStream := context.WithCancel(ctx.Request.Context())
+	defer cancelStream()
+	go func() {
+		defer close(updates)
+		c.queueService.WatchJobs(streamCtx, ids, updates)
+	}()
+
+	pending := len(ids)
+	done := make(map[string]bool)
+	ctx.Stream(func(w io.Writer) bool {
+		if ctx.Request.Context().Err() != nil {
+			cancelStream()
+			return false
+		}
+
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return false // Every watch ended
+			}
+			ctx.SSEvent("update", localizeUpdate(language, update))
+
+			if update.Status.Terminal() && !done[update.ID] {
+				done[update.ID] = true
+				pending--
+			}
+			if pending == 0 {
+				ctx.SSEvent("close", gin.H{
+					"ids":     ids,
+					"message": "Stream closing normally",
+				})
+				ctx.Writer.Flush()
+
+				// Wait a moment before closing to ensure the message is sent
+				time.Sleep(100 * time.Millisecond)
+
+				cancelStream()
+				return false
+			}
+			return true
+
+		case <-time.After(c.heartbeat):
+			// Send heartbeat to keep connection alive
+			sendKeepalive(ctx)
+			return true
+		}
+	})
+}
+
+// messageLanguage returns the language the status messages of a response are
+// shown in, from the lang query parameter, which EventSource clients can set,
+// or the Accept-Language header
+func messageLanguage(ctx *gin.Context) string {
+	language := i18n.Negotiate(ctx.Query("lang"), ctx.GetHeader("Accept-Language"))
+	ctx.Header("Content-Language", language)
+	ctx.Header("Vary", "Accept-Language")
+	return language
+}
+
+// localizeUpdate returns a job update with its message in a language
+func localizeUpdate(language string, update queue.JobUpdate) queue.JobUpdate {
+	update.Message = i18n.Localize(language, update.MessageCode, update.MessageParams, update.Message)
+	return update
+}
+
+// statusJSON is the status of a job as returned without SSE, which always
+// has the result URL and warnings
+func statusJSON(update queue.JobUpdate) gin.H {
+	status := gin.H{
+		"id":        update.ID,
+		"status":    update.Status,
+		"message":   update.Message,
+		"resultUrl": update.ResultURL,
+		"updatedAt": update.UpdatedAt,
+		"warnings":  update.Warnings,
+	}
+	if update.MessageCode != "" {
+		status["messageCode"] = update.MessageCode
+		status["messageParams"] = update.MessageParams
+	}
+	return status
+}
+
+// parseWait parses how long a status request waits for a change, as a
+// duration such as 30s or a number of seconds, capped at maxLongPollWait
+func parseWait(value string) (time.Duration, error) {
+	wait, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("use a duration such as 30s")
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return min(wait, maxLongPollWait), nil
+}
+
+// sendKeepalive sends an SSE comment frame, which keeps proxies from closing
+// an idle stream and which SSE parsers ignore
+func sendKeepalive(ctx *gin.Context) {
+	ctx.Writer.WriteString(": keepalive\n\n")
+	ctx.Writer.Flush()
+}
+
+// startEventStream sets the headers of an SSE response and sends them
+func (c *SlideController) startEventStream(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Content-Type", "text/event-stream")
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	ctx.Writer.Header().Set("Connection", "keep-alive")
+	ctx.Writer.Header().Set("Transfer-Encoding", "chunked")
+	if origin := ctx.GetHeader("Origin"); origin != "" && c.origins.Allowed(origin) {
+		// Reflect the caller's origin, since only one origin can be allowed per response
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		ctx.Writer.Header().Add("Vary", "Origin")
+	}
+	ctx.Writer.Header().Set("X-Accel-Buffering", "no") // Disable buffering in Nginx if used
+	ctx.Writer.Flush()
+}
+
+// GetSlideResult handles retrieving and serving the presentation result
+func (c *SlideController) GetSlideResult(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing result ID",
+		})
+		return
+	}
+
+	// Only decks keep their markdown, checked before an ephemeral result is
+	// taken so it isn't lost
+	bundle := ctx.Query("format") == "zip"
+	token := ctx.Query("token")
+	if bundle && token != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "ZIP bundles aren't available for ephemeral results",
+		})
+		return
+	}
+
+	// Retrieve the result from Firestore, the result of an ephemeral job is
+	// deleted as it is fetched with its token
+	var result *queue.FirestoreResult
+	var err error
+	if token != "" {
+		result, err = c.queueService.TakeEphemeralResult(ctx, id, token)
+		if errors.Is(err, queue.ErrInvalidResultToken) {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"error": "Invalid result token",
+			})
+			return
+		}
+		if errors.Is(err, queue.ErrNotFound) {
+			err = errors.New("the result isn't ready or was already fetched")
+		}
+	} else {
+		result, err = c.queueService.GetResult(ctx, id)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Result not found: %v", err),
+		})
+		return
+	}
+
+	// The result token of an ephemeral result stands in for the owner or claim token
+	if token == "" && !c.requireResultAccess(ctx, result) {
+		return
+	}
+
+	if bundle {
+		if isDownload(ctx) {
+			c.queueService.RecordDownload(ctx, result, time.Now())
+		}
+		c.serveBundle(ctx, result)
+		return
+	}
+
+	format := resultFormat(ctx)
+	file, err := c.queueService.OpenResultFile(ctx, result, format)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Result not found: %v", err),
+		})
+		return
+	}
+	if isDownload(ctx) {
+		c.queueService.RecordDownload(ctx, result, time.Now())
+	}
+
+	// Results can be cached by the browser until they expire
+	if result.Ephemeral {
+		ctx.Header("Cache-Control", "no-store")
+	} else {
+		maxAge := max(result.ExpiresAt-time.Now().Unix(), 0)
+		ctx.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	}
+	serveResultFile(ctx, file, id, format)
+}
+
+// viewerPolicy is the Content-Security-Policy of the sandboxed viewer. The
+// page may run its own inline scripts to navigate the slides, but loads
+// nothing from outside and runs in an opaque origin, so it can't reach the
+// cookies or storage of the API.
+const viewerPolicy = "default-src 'none'; img-src data:; media-src data:; font-src data:; " +
+	"style-src 'unsafe-inline'; script-src 'unsafe-inline'; base-uri 'none'; form-action 'none'; sandbox allow-scripts"
+
+// resultFormat returns the document of a result a request asks for: the PDF
+// with download=true, the sandboxed viewer with view=sandbox, the flashcards,
+// the executive summary, the student handout, the transcript alignment, the
+// chunk summaries and the grounding report with format=flashcards, one-pager,
+// one-pager-md, handout, handout-md, alignment, chunk-summaries or grounding,
+// and the HTML otherwise
+func resultFormat(ctx *gin.Context) queue.ResultFormat {
+	switch format := queue.ResultFormat(ctx.Query("format")); {
+	case format == queue.ResultFlashcards || format == queue.ResultOnePager || format == queue.ResultOnePagerMarkdown,
+		format == queue.ResultHandout || format == queue.ResultHandoutMarkdown,
+		format == queue.ResultAlignment || format == queue.ResultChunkSummaries || format == queue.ResultGrounding:
+		return format
+	case ctx.Query("download") == "true":
+		return queue.ResultPDF
+	case ctx.Query("view") == "sandbox":
+		return queue.ResultViewer
+	default:
+		return queue.ResultHTML
+	}
+}
+
+// isDownload reports whether a request for a document of a result starts a
+// download, rather than resuming one or checking that a cached copy is current
+func isDownload(ctx *gin.Context) bool {
+	if ctx.Request.Method != http.MethodGet || ctx.GetHeader("If-None-Match") != "" || ctx.GetHeader("If-Modified-Since") != "" {
+		return false
+	}
+	rangeHeader := ctx.GetHeader("Range")
+	return rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
+// serveResultFile streams a document of a result, answering range and
+// conditional requests so large decks can be downloaded resumably
+func serveResultFile(ctx *gin.Context, file *queue.ResultFile, id string, format queue.ResultFormat) {
+	defer file.Close()
+
+	name := fmt.Sprintf("presentation-%s.html", id)
+	switch format {
+	case queue.ResultPDF:
+		name = fmt.Sprintf("presentation-%s.pdf", id)
+		ctx.Header("Content-Disposition", "attachment; filename="+name)
+	case queue.ResultThumbnail:
+		name = fmt.Sprintf("presentation-%s.png", id)
+	case queue.ResultFlashcards:
+		name = fmt.Sprintf("flashcards-%s.csv", id)
+		ctx.Header("Content-Disposition", "attachment; filename="+name)
+	case queue.ResultOnePager:
+		name = fmt.Sprintf("one-pager-%s.pdf", id)
+		ctx.Header("Content-Disposition", "attachment; filename="+name)
+	case queue.ResultOnePagerMarkdown:
+		name = fmt.Sprintf("one-pager-%s.md", id)
+		ctx.Header("Content-Disposition", "attachment; filename="+name)
+	case queue.ResultHandout:
+		name = fmt.Sprintf("handout-%s.pdf", id)
+		ctx.Header("Content-Disposition", "attachment; filename="+name)
+	case queue.ResultHandoutMarkdown:
+		name = fmt.Sprintf("handout-%s.md", id)
+		ctx.Header("Content-Disposition", "attachment; filename="+name)
+	case queue.ResultAlignment:
+		name = fmt.Sprintf("alignment-%s.json", id)
+		ctx.Header("Content-Disposition", "attachment; filename="+name)
+	case queue.ResultChunkSummaries:
+		name = fmt.Sprintf("chunk-summaries-%s.json", id)
+		ctx.Header("Content-Disposition", "attachment; filename="+name)
+	case queue.ResultGrounding:
+		name = fmt.Sprintf("grounding-%s.json", id)
+		ctx.Header("Content-Disposition", "attachment; filename="+name)
+	case queue.ResultViewer:
+		// Share links set a policy of their own, which lets the viewer send its analytics
+		if ctx.Writer.Header().Get("Content-Security-Policy") == "" {
+			ctx.Header("Content-Security-Policy", viewerPolicy)
+		}
+		ctx.Header("X-Content-Type-Options", "nosniff")
+		ctx.Header("Referrer-Policy", "no-referrer")
+	}
+	ctx.Header("Content-Type", file.ContentType)
+	ctx.Header("ETag", file.ETag)
+	http.ServeContent(ctx.Writer, ctx.Request, name, file.ModTime, file)
+}
+
+// serveBundle streams the ZIP bundle of a result, with the markdown, images,
+// speaker notes, PDF and HTML of the deck for offline editing
+func (c *SlideController) serveBundle(ctx *gin.Context, result *queue.FirestoreResult) {
+	bundle, err := c.queueService.OpenBundle(ctx, result)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Bundle not available: %v", err),
+		})
+		return
+	}
+	defer bundle.Close()
+
+	// The bundle is built as it is sent, so it can't be resumed or cached
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=presentation-%s.zip", result.ID))
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Status(http.StatusOK)
+	if err := bundle.Write(ctx, ctx.Writer); err != nil {
+		log.Printf("Failed to send the bundle of result %s: %v", result.ID, err)
+	}
+}
+
+// GetResultThumbnail serves a PNG of the first slide of a result, for previews
+// in job history lists
+func (c *SlideController) GetResultThumbnail(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing result ID",
+		})
+		return
+	}
+
+	result, err := c.queueService.GetResult(ctx, id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Result not found: %v", err),
+		})
+		return
+	}
+	if !c.requireResultAccess(ctx, result) {
+		return
+	}
+	file, err := c.queueService.OpenResultFile(ctx, result, queue.ResultThumbnail)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Thumbnail not found: %v", err),
+		})
+		return
+	}
+
+	maxAge := max(result.ExpiresAt-time.Now().Unix(), 0)
+	ctx.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	serveResultFile(ctx, file, id, queue.ResultThumbnail)
+}
+
+// GetAccessibilityReport serves the accessibility report generated alongside a result
+func (c *SlideController) GetAccessibilityReport(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing result ID",
+		})
+		return
+	}
+
+	result, err := c.queueService.GetResult(ctx, id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Result not found: %v", err),
+		})
+		return
+	}
+	if !c.requireResultAccess(ctx, result) {
+		return
+	}
+
+	if len(result.AccessibilityReport) == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "No accessibility report for this result. Enable accessibility mode in the settings to generate one.",
+		})
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/json", result.AccessibilityReport)
+}
+
+// CleanupFiles deletes the uploaded files of finished, expired and abandoned jobs
+// and reports the bytes reclaimed. Cloud Scheduler calls it with an OIDC token
+// checked by RequireServiceAccount.
+func (c *SlideController) CleanupFiles(ctx *gin.Context) {
+	report, err := c.queueService.CleanupFiles(ctx, time.Now())
+	if err != nil {
+		log.Printf("Failed to clean up files: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to clean up files",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
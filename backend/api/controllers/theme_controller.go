@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/services/themes"
+)
+
+// ThemeController handles the theme registry API endpoints
+type ThemeController struct {
+	themeService *themes.Service
+}
+
+// NewThemeController creates a new theme controller
+func NewThemeController(themeService *themes.Service) *ThemeController {
+	return &ThemeController{
+		themeService: themeService,
+	}
+}
+
+// ListThemes lists the themes decks can be generated with
+func (c *ThemeController) ListThemes(ctx *gin.Context) {
+	list, err := c.themeService.List(ctx)
+	if err != nil {
+		log.Printf("Failed to list themes: %v", err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to list themes",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"themes": list,
+	})
+}
+
+// UploadTheme registers the stylesheet in the css form field as a theme, or
+// replaces the stylesheet of a contributed theme with the same name
+func (c *ThemeController) UploadTheme(ctx *gin.Context) {
+	header, err := ctx.FormFile("css")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing css file in form",
+		})
+		return
+	}
+	if header.Size > themes.MaxCSSBytes {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("The stylesheet must be at most %d KB", themes.MaxCSSBytes>>10),
+		})
+		return
+	}
+	src, err := header.Open()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to open file %s: %v", header.Filename, err),
+		})
+		return
+	}
+	css, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to read file %s: %v", header.Filename, err),
+		})
+		return
+	}
+
+	name := strings.TrimSpace(ctx.PostForm("name"))
+	author := strings.TrimSpace(ctx.PostForm("author"))
+	if len(author) > 100 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "author must be at most 100 characters",
+		})
+		return
+	}
+
+	theme, err := c.themeService.Upload(ctx, name, author, css, middleware.CurrentUser(ctx).UID)
+	if errors.Is(err, themes.ErrInvalidTheme) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to upload theme %s: %v", name, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to upload theme",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, theme)
+}
+
+// DeleteTheme removes a contributed theme
+func (c *ThemeController) DeleteTheme(ctx *gin.Context) {
+	err := c.themeService.Delete(ctx, ctx.Param("name"))
+	if errors.Is(err, themes.ErrThemeNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Theme not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete theme %s: %v", ctx.Param("name"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete theme",
+		})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
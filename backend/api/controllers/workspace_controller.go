@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/preflight"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"github.com/martin226/slideitin/backend/api/services/workspaces"
+)
+
+// WorkspaceController handles the workspace API endpoints
+type WorkspaceController struct {
+	workspaceService *workspaces.Service
+	apiKeyService    *apikeys.Service
+	queueService     *queue.Service
+}
+
+// NewWorkspaceController creates a new workspace controller
+func NewWorkspaceController(workspaceService *workspaces.Service, apiKeyService *apikeys.Service, queueService *queue.Service) *WorkspaceController {
+	return &WorkspaceController{
+		workspaceService: workspaceService,
+		apiKeyService:    apiKeyService,
+		queueService:     queueService,
+	}
+}
+
+// GetWorkspace returns the workspace of the API key
+func (c *WorkspaceController) GetWorkspace(ctx *gin.Context) {
+	apiKey := c.requireMember(ctx, workspaces.PermissionView)
+	if apiKey == nil {
+		return
+	}
+
+	workspace, err := c.workspaceService.Get(ctx, apiKey.WorkspaceID)
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"workspace": workspace,
+		"role":      apiKey.Role,
+	})
+}
+
+// UpdateWorkspace replaces the themes and branding of the workspace of the API key
+func (c *WorkspaceController) UpdateWorkspace(ctx *gin.Context) {
+	apiKey := c.requireMember(ctx, workspaces.PermissionManage)
+	if apiKey == nil {
+		return
+	}
+
+	var settings workspaces.Settings
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&settings); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request format: %v", err),
+		})
+		return
+	}
+	if violations := middleware.Validate(&settings); len(violations) > 0 {
+		middleware.AbortWithViolations(ctx, violations)
+		return
+	}
+
+	workspace, err := c.workspaceService.Update(ctx, apiKey.WorkspaceID, settings)
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	log.Printf("Workspace %s updated by API key %s", apiKey.WorkspaceID, apiKey.ID)
+	ctx.JSON(http.StatusOK, gin.H{
+		"workspace": workspace,
+	})
+}
+
+// SetRegion tags a workspace with the region its new jobs, fonts and library
+// documents are stored in, for customers whose data has to stay in it
+func (c *WorkspaceController) SetRegion(ctx *gin.Context) {
+	var req struct {
+		Region string `json:"region"` // Empty for the default region
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request format: %v", err),
+		})
+		return
+	}
+
+	workspace, err := c.workspaceService.SetRegion(ctx, ctx.Param("id"), req.Region)
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	log.Printf("Workspace %s moved to region %q by %s", workspace.ID, workspace.Region, middleware.CurrentUser(ctx).UID)
+	ctx.JSON(http.StatusOK, gin.H{
+		"workspace": workspace,
+	})
+}
+
+// UploadFont adds the font file in the file form field to the workspace of the
+// API key, used by decks whose font or heading font names its family
+func (c *WorkspaceController) UploadFont(ctx *gin.Context) {
+	apiKey := c.requireMember(ctx, workspaces.PermissionManage)
+	if apiKey == nil {
+		return
+	}
+
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing file in form",
+		})
+		return
+	}
+	if header.Size > workspaces.MaxFontBytes {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("The font file must be at most %d MB", workspaces.MaxFontBytes>>20),
+		})
+		return
+	}
+	upload := workspaces.FontUpload{
+		Family: strings.TrimSpace(ctx.PostForm("family")),
+		Style:  ctx.PostForm("style"),
+	}
+	if weight := ctx.PostForm("weight"); weight != "" {
+		upload.Weight, err = strconv.Atoi(weight)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "weight must be a number from 100 to 900",
+			})
+			return
+		}
+	}
+	if violations := middleware.Validate(&upload); len(violations) > 0 {
+		middleware.AbortWithViolations(ctx, violations)
+		return
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to open file %s: %v", header.Filename, err),
+		})
+		return
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to read file %s: %v", header.Filename, err),
+		})
+		return
+	}
+
+	font, err := c.workspaceService.AddFont(ctx, apiKey.WorkspaceID, upload, data)
+	if errors.Is(err, workspaces.ErrInvalidFont) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	log.Printf("Font %s added to workspace %s by API key %s", font.ID, apiKey.WorkspaceID, apiKey.ID)
+	ctx.JSON(http.StatusCreated, font)
+}
+
+// DeleteFont removes a font file from the workspace of the API key
+func (c *WorkspaceController) DeleteFont(ctx *gin.Context) {
+	apiKey := c.requireMember(ctx, workspaces.PermissionManage)
+	if apiKey == nil {
+		return
+	}
+
+	err := c.workspaceService.DeleteFont(ctx, apiKey.WorkspaceID, ctx.Param("id"))
+	if errors.Is(err, workspaces.ErrFontNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Font not found",
+		})
+		return
+	}
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// UploadDocument adds the document in the file form field to the library of
+// the workspace of the API key, where it is indexed once for the decks its
+// members generate from it with documentIds
+func (c *WorkspaceController) UploadDocument(ctx *gin.Context) {
+	apiKey := c.requireMember(ctx, workspaces.PermissionGenerate)
+	if apiKey == nil {
+		return
+	}
+
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing file in form",
+		})
+		return
+	}
+	if header.Size > workspaces.MaxDocumentBytes {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("The document must be at most %d MB", workspaces.MaxDocumentBytes>>20),
+		})
+		return
+	}
+	src, err := header.Open()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to open file %s: %v", header.Filename, err),
+		})
+		return
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to read file %s: %v", header.Filename, err),
+		})
+		return
+	}
+	mimeType, isAllowed := preflight.DetectType(header.Filename, data)
+	if !isAllowed {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Unsupported file type: %s. Only PDF, Markdown, TXT, VTT and SRT files are allowed", header.Filename),
+		})
+		return
+	}
+
+	document, err := c.workspaceService.AddDocument(ctx, apiKey.WorkspaceID, apiKey.ID, header.Filename, mimeType, data)
+	if errors.Is(err, workspaces.ErrInvalidDocument) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	log.Printf("Document %s added to the library of workspace %s by API key %s", document.ID, apiKey.WorkspaceID, apiKey.ID)
+	ctx.JSON(http.StatusAccepted, document)
+}
+
+// ListDocuments lists the documents of the library of the workspace of the API key
+func (c *WorkspaceController) ListDocuments(ctx *gin.Context) {
+	apiKey := c.requireMember(ctx, workspaces.PermissionView)
+	if apiKey == nil {
+		return
+	}
+
+	documents, err := c.workspaceService.Documents(ctx, apiKey.WorkspaceID)
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"documents": documents,
+	})
+}
+
+// GetDocument returns a library document, whose status tells when it is indexed
+func (c *WorkspaceController) GetDocument(ctx *gin.Context) {
+	apiKey := c.requireMember(ctx, workspaces.PermissionView)
+	if apiKey == nil {
+		return
+	}
+
+	document, err := c.workspaceService.Document(ctx, apiKey.WorkspaceID, ctx.Param("id"))
+	if errors.Is(err, workspaces.ErrDocumentNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Document not found",
+		})
+		return
+	}
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, document)
+}
+
+// DeleteDocument removes a document from the library of the workspace of the API key
+func (c *WorkspaceController) DeleteDocument(ctx *gin.Context) {
+	apiKey := c.requireMember(ctx, workspaces.PermissionManage)
+	if apiKey == nil {
+		return
+	}
+
+	err := c.workspaceService.DeleteDocument(ctx, apiKey.WorkspaceID, ctx.Param("id"))
+	if errors.Is(err, workspaces.ErrDocumentNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Document not found",
+		})
+		return
+	}
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	log.Printf("Document %s deleted from the library of workspace %s by API key %s", ctx.Param("id"), apiKey.WorkspaceID, apiKey.ID)
+	ctx.Status(http.StatusNoContent)
+}
+
+// ListLibrary lists the decks generated by every member of the workspace,
+// optionally filtered by labels given as label=key:value query parameters
+func (c *WorkspaceController) ListLibrary(ctx *gin.Context) {
+	apiKey := c.requireMember(ctx, workspaces.PermissionView)
+	if apiKey == nil {
+		return
+	}
+	listJobs(ctx, c.queueService, queue.JobQuery{WorkspaceID: apiKey.WorkspaceID})
+}
+
+// requireMember returns the API key of the request if it belongs to a workspace
+// and its role has the permission, or responds with an error and returns nil
+func (c *WorkspaceController) requireMember(ctx *gin.Context, permission workspaces.Permission) *apikeys.APIKey {
+	apiKey := requireAPIKey(ctx, c.apiKeyService)
+	if apiKey == nil {
+		return nil
+	}
+	if apiKey.WorkspaceID == "" {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "This API key is not a member of a workspace",
+		})
+		return nil
+	}
+	if !workspaces.Allows(apiKey.Role, permission) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("The %s role doesn't allow this action", apiKey.Role),
+		})
+		return nil
+	}
+	return apiKey
+}
+
+// respondWorkspaceError responds to a failed workspace lookup or update
+func respondWorkspaceError(ctx *gin.Context, err error) {
+	if errors.Is(err, workspaces.ErrWorkspaceNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, workspaces.ErrUnknownRegion) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, workspaces.ErrRegionInUse) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	log.Printf("Workspace error: %v", err)
+	ctx.JSON(http.StatusInternalServerError, gin.H{
+		"error": "Failed to access workspace",
+	})
+}
@@ -3,15 +3,34 @@ package main
 import (
 	"context"
 	"log"
-	"os"
-	"time"
 
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	"cloud.google.com/go/firestore"
-	"github.com/gin-contrib/cors"
+	"cloud.google.com/go/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/martin226/slideitin/backend/api/config"
 	"github.com/martin226/slideitin/backend/api/controllers"
+	"github.com/martin226/slideitin/backend/api/middleware"
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/auth"
+	"github.com/martin226/slideitin/backend/api/services/batches"
+	"github.com/martin226/slideitin/backend/api/services/billing"
+	"github.com/martin226/slideitin/backend/api/services/breaker"
+	"github.com/martin226/slideitin/backend/api/services/captcha"
+	"github.com/martin226/slideitin/backend/api/services/drive"
+	"github.com/martin226/slideitin/backend/api/services/estimates"
+	"github.com/martin226/slideitin/backend/api/services/features"
+	"github.com/martin226/slideitin/backend/api/services/maintenance"
+	"github.com/martin226/slideitin/backend/api/services/presets"
 	"github.com/martin226/slideitin/backend/api/services/queue"
+	"github.com/martin226/slideitin/backend/api/services/quota"
+	"github.com/martin226/slideitin/backend/api/services/schedules"
+	"github.com/martin226/slideitin/backend/api/services/sharing"
+	"github.com/martin226/slideitin/backend/api/services/themes"
+	"github.com/martin226/slideitin/backend/api/services/workspaces"
 )
 
 func main() {
@@ -20,35 +39,25 @@ func main() {
 		log.Println("Warning: .env file not found, using system environment variables")
 	}
 
+	// Load and validate the configuration before starting anything
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
 	// Initialize the router
 	router := gin.Default()
-
-	// Get frontend URL from environment variable
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:3000" // Fallback for local development
-		log.Println("Warning: FRONTEND_URL not set, using default:", frontendURL)
+	if err := middleware.TrustProxies(router, cfg.TrustedProxies); err != nil {
+		log.Fatalf("Failed to set the trusted proxies: %v", err)
 	}
 
-	// Configure CORS
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{frontendURL}, // Use environment variable
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Cache-Control", "Connection", "Access-Control-Allow-Origin"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Type", "Cache-Control", "Content-Encoding", "Transfer-Encoding"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
+	// Configure CORS for every configured frontend origin
+	origins := middleware.NewOriginMatcher(cfg.FrontendOrigins)
+	router.Use(middleware.CORS(origins))
 
 	// Initialize Firestore client
 	ctx := context.Background()
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID == "" {
-		log.Println("Warning: GOOGLE_CLOUD_PROJECT not set, using default")
-		projectID = "slideitin"
-	}
-
-	firestoreClient, err := firestore.NewClient(ctx, projectID)
+	firestoreClient, err := firestore.NewClientWithDatabase(ctx, cfg.ProjectID, cfg.FirestoreDatabaseID)
 
 	if err != nil {
 		log.Fatalf("Failed to initialize Firestore: %v", err)
@@ -56,35 +65,253 @@ func main() {
 	defer firestoreClient.Close()
 
 	// Initialize queue service with Firestore
-	queueService, err := queue.NewService(firestoreClient)
+	queueService, err := queue.NewService(firestoreClient, cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize queue service: %v", err)
 	}
 
+	// Contributed themes are stored next to the uploads of the default region,
+	// where the slides service reads them
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("Failed to initialize Cloud Storage: %v", err)
+	}
+	defer storageClient.Close()
+	blobStore := queue.NewGCSBlobStore(storageClient, cfg.ProjectID, cfg.BucketName, cfg.GCSKMSKey)
+
+	// Maintenance mode stops new jobs on every instance and can pause the task
+	// queues of every region
+	taskClient, err := cloudtasks.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("Failed to initialize Cloud Tasks: %v", err)
+	}
+	defer taskClient.Close()
+	var taskQueues maintenance.Queues
+	for _, region := range cfg.Regions {
+		taskQueues = append(taskQueues, maintenance.NewCloudTasksQueue(taskClient, cfg.ProjectID, region.CloudTasksRegion, cfg.CloudTasksQueue))
+	}
+	maintenanceService := maintenance.NewService(firestoreClient, taskQueues)
+
+	// The slides service shares the state of its Gemini circuit breaker through Firestore
+	geminiState := breaker.NewRemote(firestoreClient, "gemini", "Gemini")
+
+	// Initialize sharing service for public result links
+	shareService := sharing.NewService(firestoreClient, queueService, cfg.DownloadURLSecret)
+
+	// Initialize API key service for per-key integrations
+	apiKeyService := apikeys.NewService(firestoreClient)
+	fingerprints := quota.NewFingerprinter(cfg.ClientIPSecret)
+	quotaService := quota.NewService(firestoreClient, cfg.AnonymousDailyJobLimit, cfg.RecipientDailyEmailLimit, fingerprints)
+	abuseService := abuse.NewService(firestoreClient, cfg.AbuseStrikeLimit, fingerprints)
+	workspaceService := workspaces.NewService(firestoreClient, queueService)
+	presetService := presets.NewService(firestoreClient)
+	batchService := batches.NewService(firestoreClient)
+	driveService := drive.NewService(firestoreClient, drive.Config{
+		ClientID:     cfg.GoogleOAuthClientID,
+		ClientSecret: cfg.GoogleOAuthClientSecret,
+		RedirectURL:  cfg.PublicAPIURL + "/v1/drive/callback",
+		ReturnURL:    cfg.DriveReturnURL,
+	})
+	scheduleService := schedules.NewService(firestoreClient)
+
+	// Schedules fetch their URL sources here, and are only enabled when Cloud
+	// Scheduler is set up to run them
+	var scheduleFetcher *schedules.Fetcher
+	if cfg.SchedulerServiceAccount != "" {
+		scheduleFetcher = schedules.NewFetcher()
+	}
+	billingService := billing.NewService(firestoreClient, billing.Config{
+		SecretKey:     cfg.StripeSecretKey,
+		WebhookSecret: cfg.StripeWebhookSecret,
+		PriceIDs:      cfg.StripePriceIDs,
+		ReturnURL:     cfg.BillingReturnURL,
+		TokenLimits:   cfg.PlanTokenLimits,
+	})
+
+	// Contributed themes are loaded by the slides service at runtime
+	themeService := themes.NewService(firestoreClient, blobStore)
+
+	// Register the validation tags used by the request models
+	if err := middleware.RegisterValidators(themeService.Contributed); err != nil {
+		log.Fatalf("Failed to register validators: %v", err)
+	}
+
+	// Feature flags roll out experimental capabilities by workspace
+	featureService, err := features.NewService(firestoreClient, cfg.FeatureFlags)
+	if err != nil {
+		log.Fatalf("Failed to initialize feature flags: %v", err)
+	}
+
+	// Cost estimates and dry runs call the slides service with the credentials
+	// of the API instead of through Cloud Tasks
+	estimateClient, err := estimates.NewClient(ctx, cfg.SlidesServiceURL, cfg.TaskSigningSecret)
+	if err != nil {
+		log.Printf("Warning: Cost estimation and dry runs are disabled: %v", err)
+	}
+
+	// Anonymous jobs need a solved CAPTCHA on deployments that set a provider
+	var captchaVerifier *captcha.Verifier
+	if cfg.CaptchaProvider != "" {
+		captchaVerifier, err = captcha.NewVerifier(cfg.CaptchaProvider, cfg.CaptchaSecretKey)
+		if err != nil {
+			log.Fatalf("Failed to initialize CAPTCHA verification: %v", err)
+		}
+	}
+
 	// Initialize controllers
-	slideController := controllers.NewSlideController(queueService)
+	slideController := controllers.NewSlideController(queueService, apiKeyService, quotaService, billingService, workspaceService, presetService, driveService, featureService, batchService, estimateClient, captchaVerifier, cfg.AdminUIDs, origins, cfg.SSEHeartbeatInterval)
+	shareController := controllers.NewShareController(shareService, apiKeyService, cfg.PublicAPIURL)
+	billingController := controllers.NewBillingController(billingService, apiKeyService)
+	workspaceController := controllers.NewWorkspaceController(workspaceService, apiKeyService, queueService)
+	presetController := controllers.NewPresetController(presetService, apiKeyService)
+	driveController := controllers.NewDriveController(driveService, apiKeyService)
+	estimateController := controllers.NewEstimateController(estimateClient, billingService, apiKeyService, cfg.TokenPricePerMillion)
+	themeController := controllers.NewThemeController(themeService)
+	abuseController := controllers.NewAbuseController(abuseService)
+	maintenanceController := controllers.NewMaintenanceController(maintenanceService)
+	scheduleController := controllers.NewScheduleController(scheduleService, scheduleFetcher, queueService, apiKeyService, billingService, workspaceService, presetService, featureService, quotaService)
 
-	// API routes
+	// API routes, signed-in users send their Firebase ID token as a bearer token.
+	// Clients whose requests keep getting rejected are blocked for a while.
 	v1 := router.Group("/v1")
+	v1.Use(
+		middleware.TrackAbuse(abuseService),
+		middleware.Authenticate(auth.NewTokenVerifier(cfg.FirebaseProjectID)),
+		middleware.BlockAbuse(abuseService, cfg.AdminUIDs),
+	)
 	{
+		// New jobs are refused with a 503 while the instance is in maintenance
+		acceptJobs := middleware.AcceptJobs(maintenanceService, cfg.AdminUIDs)
+		// and while Firestore, Cloud Storage or Gemini is down
+		failFast := middleware.FailFast(queueService, geminiState)
+		v1.GET("/maintenance", maintenanceController.GetMaintenance)
+
 		// Slide generation endpoint - adds job to queue and returns immediately
-		v1.POST("/generate", slideController.GenerateSlides)
-		
+		v1.POST("/generate", acceptJobs, failFast, middleware.BindFormJSON[models.SlideRequest]("data", 10<<20), slideController.GenerateSlides) // 10 MB max
+
+		// Pre-flight endpoint - checks files and estimates a job without creating it
+		v1.POST("/validate", slideController.ValidateFiles)
+
+		// Cost estimation endpoint - counts the Gemini tokens of a job without creating it
+		v1.POST("/estimate", middleware.BindFormJSON[models.EstimateRequest]("data", 10<<20), estimateController.EstimateCost) // 10 MB max
+
+		// Multiplexed streaming endpoint - streams the status of several jobs over one connection
+		v1.GET("/slides/stream", slideController.StreamSlideStatuses)
+
 		// Streaming status endpoint - combines status checking and streaming
 		v1.GET("/slides/:id", slideController.StreamSlideStatus)
-        
+
+		// Refinement endpoint - applies a chat-style instruction to a deck as a new revision
+		v1.POST("/slides/:id/refine", acceptJobs, failFast, slideController.RefineSlides)
+
+		// Revision diff endpoint - lists the slides a refinement added, removed or changed
+		v1.GET("/slides/:id/revisions/:rev/diff", slideController.GetRevisionDiff)
+
+		// Deck comparison endpoint - compares the structure and topics of two decks, to help pick settings
+		v1.GET("/slides/:id/compare/:otherId", slideController.CompareDecks)
+
+		// Job history endpoint - lists the jobs of an API key, filtered by label
+		v1.GET("/jobs", slideController.ListJobs)
+
+		// Batch endpoint - lists the chapter decks of a split document and the status of their jobs
+		v1.GET("/batches/:id", slideController.GetBatch)
+
 		// Result retrieval endpoint - serves the generated presentation
 		v1.GET("/results/:id", slideController.GetSlideResult)
+
+		// Accessibility report endpoint - serves the report generated in accessibility mode
+		v1.GET("/results/:id/accessibility", slideController.GetAccessibilityReport)
+
+		// Thumbnail endpoint - serves a PNG of the first slide for previews
+		v1.GET("/results/:id/thumbnail", slideController.GetResultThumbnail)
+
+		// Sharing endpoints - create, view and revoke public links to a result
+		v1.POST("/results/:id/share", shareController.CreateShare)
+		v1.GET("/shared/:token", shareController.GetSharedResult)
+		v1.GET("/shared/:token/thumbnail", shareController.GetSharedThumbnail)
+		v1.DELETE("/shared/:token", shareController.RevokeShare)
+
+		// Analytics endpoints - collect the slide views of share links and report them per result
+		v1.POST("/shared/:token/analytics", shareController.RecordViewerAnalytics)
+		v1.GET("/results/:id/analytics", shareController.GetDeckAnalytics)
+
+		// Download URL endpoints - sign a short-lived link to a result and download with it
+		v1.POST("/results/:id/download-url", shareController.CreateDownloadURL)
+		v1.GET("/downloads/:id", shareController.GetDownload)
+
+		// Billing endpoints - subscribe to a plan, check usage and receive Stripe webhooks
+		v1.POST("/billing/checkout", billingController.CreateCheckout)
+		v1.GET("/billing/usage", billingController.GetUsage)
+		v1.POST("/billing/webhook", billingController.HandleWebhook)
+
+		// Workspace endpoints - shared settings, deck library and document library of the API key's workspace
+		v1.GET("/workspace", workspaceController.GetWorkspace)
+		v1.PUT("/workspace", workspaceController.UpdateWorkspace)
+		v1.GET("/workspace/library", workspaceController.ListLibrary)
+		v1.POST("/workspace/fonts", workspaceController.UploadFont)
+		v1.DELETE("/workspace/fonts/:id", workspaceController.DeleteFont)
+		v1.POST("/workspace/documents", workspaceController.UploadDocument)
+		v1.GET("/workspace/documents", workspaceController.ListDocuments)
+		v1.GET("/workspace/documents/:id", workspaceController.GetDocument)
+		v1.DELETE("/workspace/documents/:id", workspaceController.DeleteDocument)
+
+		// Preset endpoints - named settings saved per API key, user or workspace
+		v1.GET("/presets", presetController.ListPresets)
+		v1.POST("/presets", presetController.CreatePreset)
+		v1.GET("/presets/:id", presetController.GetPreset)
+		v1.PUT("/presets/:id", presetController.UpdatePreset)
+		v1.DELETE("/presets/:id", presetController.DeletePreset)
+
+		// Schedule endpoints - decks regenerated from a source on a cron schedule
+		v1.GET("/schedules", scheduleController.ListSchedules)
+		v1.POST("/schedules", scheduleController.CreateSchedule)
+		v1.GET("/schedules/:id", scheduleController.GetSchedule)
+		v1.PUT("/schedules/:id", scheduleController.UpdateSchedule)
+		v1.DELETE("/schedules/:id", scheduleController.DeleteSchedule)
+
+		// Drive endpoints - connect Google Drive with OAuth to generate from Drive file IDs
+		v1.GET("/drive", driveController.GetConnection)
+		v1.POST("/drive/connect", driveController.Connect)
+		v1.GET("/drive/callback", driveController.Callback)
+		v1.DELETE("/drive", driveController.Disconnect)
+
+		// Theme registry endpoints, contributed themes are uploaded by the admins of the instance
+		v1.GET("/themes", themeController.ListThemes)
+		admin := v1.Group("/admin", middleware.RequireAdmin(cfg.AdminUIDs))
+		admin.POST("/themes", themeController.UploadTheme)
+		admin.DELETE("/themes/:name", themeController.DeleteTheme)
+
+		// Prompts and raw responses of the jobs admins debugged
+		admin.GET("/jobs/:id/capture", slideController.GetCapture)
+
+		// Replays of debugged jobs with the current prompts and model, compared with the original
+		admin.POST("/jobs/:id/replay", slideController.ReplayJob)
+		admin.GET("/jobs/:id/replay/:replayId", slideController.CompareReplay)
+
+		// Clients blocked for repeated rejected requests, and lifting their blocks
+		admin.GET("/blocks", abuseController.ListBlocks)
+		admin.DELETE("/blocks/:subject", abuseController.Unblock)
+
+		// Maintenance mode, for Gemini outages and deployments
+		admin.PUT("/maintenance", maintenanceController.SetMaintenance)
+
+		// Data residency endpoint - tags a workspace with the region its jobs and files are stored in
+		admin.PUT("/workspaces/:id/region", workspaceController.SetRegion)
 	}
 
-	// Start the server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Called by a Cloud Scheduler job every few minutes to run the due schedules
+	if cfg.SchedulerServiceAccount != "" {
+		runPath := "/internal/schedules/run"
+		router.POST(runPath, middleware.RequireServiceAccount(cfg.PublicAPIURL+runPath, cfg.SchedulerServiceAccount), middleware.AcceptJobs(maintenanceService, nil), middleware.FailFast(queueService, geminiState), scheduleController.RunDueSchedules)
+
+		// Called daily to delete the files that failed and crashed jobs left behind
+		cleanupPath := "/internal/files/cleanup"
+		router.POST(cleanupPath, middleware.RequireServiceAccount(cfg.PublicAPIURL+cleanupPath, cfg.SchedulerServiceAccount), slideController.CleanupFiles)
 	}
-	
-	log.Printf("Starting server on port %s\n", port)
-	if err := router.Run(":" + port); err != nil {
+
+	// Start the server
+	log.Printf("Starting server on port %s\n", cfg.Port)
+	if err := router.Run(":" + cfg.Port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
-} 
\ No newline at end of file
+}
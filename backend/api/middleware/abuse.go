@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+)
+
+// offensesKey is the context key under which handlers report the offenses of
+// a request
+const offensesKey = "abuseOffenses"
+
+// AbuseTracker counts the offenses of clients and reports which are blocked
+type AbuseTracker interface {
+	IPSubject(ip string) string
+	Blocked(ctx context.Context, subjects []string) time.Time
+	Record(ctx context.Context, subjects []string, offense abuse.Offense)
+}
+
+// ReportOffense marks the request as an offense of its client, counted once
+// the request has been handled
+func ReportOffense(ctx *gin.Context, offense abuse.Offense) {
+	offenses, _ := ctx.Get(offensesKey)
+	list, _ := offenses.([]abuse.Offense)
+	ctx.Set(offensesKey, append(list, offense))
+}
+
+// TrackAbuse counts the offenses reported while handling a request against
+// the IP address and API key of its client. It runs before Authenticate so
+// invalid ID tokens count too.
+func TrackAbuse(tracker AbuseTracker) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		offenses, _ := ctx.Get(offensesKey)
+		list, _ := offenses.([]abuse.Offense)
+		if len(list) == 0 {
+			return
+		}
+		subjects := abuseSubjects(ctx, tracker)
+		for _, offense := range list {
+			tracker.Record(context.WithoutCancel(ctx.Request.Context()), subjects, offense)
+		}
+	}
+}
+
+// BlockAbuse rejects requests from blocked clients. It runs after
+// Authenticate so admins are never locked out of lifting blocks.
+func BlockAbuse(tracker AbuseTracker, adminUIDs []string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if IsAdmin(ctx, adminUIDs) {
+			ctx.Next()
+			return
+		}
+		until := tracker.Blocked(ctx, abuseSubjects(ctx, tracker))
+		if until.IsZero() {
+			ctx.Next()
+			return
+		}
+
+		retryAfter := max(int(time.Until(until).Seconds()), 1)
+		ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":        fmt.Sprintf("Too many rejected requests from this client, try again after %s", until.UTC().Format(time.RFC3339)),
+			"blockedUntil": until.Unix(),
+		})
+	}
+}
+
+// abuseSubjects returns the subjects the offenses of a request count against
+func abuseSubjects(ctx *gin.Context, tracker AbuseTracker) []string {
+	subjects := []string{tracker.IPSubject(ctx.ClientIP())}
+	if key := ctx.GetHeader("X-API-Key"); key != "" {
+		subjects = append(subjects, abuse.APIKeySubject(key))
+	}
+	return subjects
+}
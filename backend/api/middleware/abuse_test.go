@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+	"github.com/martin226/slideitin/backend/api/services/auth"
+)
+
+// fakeTracker blocks the subjects in blocked and keeps the offenses recorded
+type fakeTracker struct {
+	blocked  map[string]time.Time
+	recorded map[string][]abuse.Offense
+}
+
+func (f *fakeTracker) IPSubject(ip string) string {
+	return "ip:" + ip
+}
+
+func (f *fakeTracker) Blocked(ctx context.Context, subjects []string) time.Time {
+	for _, subject := range subjects {
+		if until, ok := f.blocked[subject]; ok {
+			return until
+		}
+	}
+	return time.Time{}
+}
+
+func (f *fakeTracker) Record(ctx context.Context, subjects []string, offense abuse.Offense) {
+	for _, subject := range subjects {
+		f.recorded[subject] = append(f.recorded[subject], offense)
+	}
+}
+
+func TestTrackAbuseRecordsReportedOffenses(t *testing.T) {
+	tracker := &fakeTracker{recorded: map[string][]abuse.Offense{}}
+	router := gin.New()
+	router.Use(TrackAbuse(tracker))
+	router.POST("/generate", func(ctx *gin.Context) {
+		ReportOffense(ctx, abuse.OffenseUnsupportedFile)
+		ctx.Status(http.StatusBadRequest)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("X-API-Key", "sk_test")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := []abuse.Offense{abuse.OffenseUnsupportedFile}
+	if !reflect.DeepEqual(tracker.recorded["ip:203.0.113.7"], want) || !reflect.DeepEqual(tracker.recorded[abuse.APIKeySubject("sk_test")], want) {
+		t.Fatalf("expected the offense against the IP address and API key, got %v", tracker.recorded)
+	}
+}
+
+func TestBlockAbuseRejectsBlockedClientsButNotAdmins(t *testing.T) {
+	until := time.Now().Add(30 * time.Minute)
+	tracker := &fakeTracker{blocked: map[string]time.Time{"ip:203.0.113.7": until}}
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		if uid := ctx.GetHeader("X-Test-User"); uid != "" {
+			ctx.Set(UserKey, &auth.User{UID: uid})
+		}
+	}, BlockAbuse(tracker, []string{"admin"}))
+	router.GET("/themes", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+
+	serve := func(ip, uid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/themes", nil)
+		req.RemoteAddr = ip + ":1234"
+		if uid != "" {
+			req.Header.Set("X-Test-User", uid)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("203.0.113.7", ""); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected a blocked client to get a 429 with Retry-After, got %d", w.Code)
+	}
+	if w := serve("203.0.113.7", "user"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected a signed-in user at a blocked address to be blocked, got %d", w.Code)
+	}
+	if w := serve("203.0.113.7", "admin"); w.Code != http.StatusOK {
+		t.Fatalf("expected an admin to pass, got %d", w.Code)
+	}
+	if w := serve("203.0.113.8", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected other clients to pass, got %d", w.Code)
+	}
+}
+
+func TestAbuseIgnoresSpoofedForwardedFor(t *testing.T) {
+	until := time.Now().Add(30 * time.Minute)
+	tracker := &fakeTracker{blocked: map[string]time.Time{"ip:203.0.113.7": until}, recorded: map[string][]abuse.Offense{}}
+	router := gin.New()
+	if err := TrustProxies(router, []string{"169.254.0.0/16"}); err != nil {
+		t.Fatalf("TrustProxies failed: %v", err)
+	}
+	router.Use(TrackAbuse(tracker), BlockAbuse(tracker, nil))
+	router.POST("/generate", func(ctx *gin.Context) {
+		ReportOffense(ctx, abuse.OffenseUnsupportedFile)
+		ctx.Status(http.StatusBadRequest)
+	})
+
+	serve := func(clientIP, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/generate", nil)
+		req.RemoteAddr = "169.254.1.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor+", "+clientIP)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("203.0.113.7", "198.51.100.1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected a blocked client claiming another address to stay blocked, got %d", w.Code)
+	}
+	serve("198.51.100.9", "203.0.113.50")
+	if len(tracker.recorded["ip:203.0.113.50"]) != 0 || len(tracker.recorded["ip:198.51.100.9"]) != 1 {
+		t.Fatalf("expected the offense against the address the proxy saw, got %v", tracker.recorded)
+	}
+}
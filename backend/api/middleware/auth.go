@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+	"github.com/martin226/slideitin/backend/api/services/auth"
+)
+
+// UserKey is the context key under which Authenticate stores the signed-in user
+const UserKey = "user"
+
+// TokenVerifier returns the user of a Firebase ID token, or an error wrapping
+// auth.ErrInvalidToken when the token is invalid
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*auth.User, error)
+}
+
+// Authenticate verifies the Firebase ID token sent as a bearer token, if any,
+// and stores its user in the context. Requests without a token pass through
+// so API keys and anonymous use keep working.
+func Authenticate(verifier TokenVerifier) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header := ctx.GetHeader("Authorization")
+		if header == "" {
+			ctx.Next()
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Authorization header must be a Bearer token",
+			})
+			return
+		}
+		user, err := verifier.Verify(ctx, strings.TrimSpace(token))
+		if errors.Is(err, auth.ErrInvalidToken) {
+			ReportOffense(ctx, abuse.OffenseInvalidCredentials)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			// The token may well be valid, it just can't be checked right now
+			log.Printf("Failed to verify ID token: %v", err)
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Sign-in can't be verified right now, try again later",
+			})
+			return
+		}
+
+		ctx.Set(UserKey, user)
+		ctx.Next()
+	}
+}
+
+// CurrentUser returns the signed-in user of the request, or nil
+func CurrentUser(ctx *gin.Context) *auth.User {
+	if value, ok := ctx.Get(UserKey); ok {
+		return value.(*auth.User)
+	}
+	return nil
+}
+
+// RequireAdmin only lets through signed-in users whose Firebase UID is one of
+// the admins of the instance
+func RequireAdmin(uids []string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user := CurrentUser(ctx)
+		if user == nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Sign in as an admin of this instance",
+			})
+			return
+		}
+		if !IsAdmin(ctx, uids) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Only admins of this instance can do this",
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// IsAdmin reports whether the signed-in user of the request is one of the
+// admins of the instance
+func IsAdmin(ctx *gin.Context, uids []string) bool {
+	user := CurrentUser(ctx)
+	return user != nil && slices.Contains(uids, user.UID)
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/abuse"
+	"github.com/martin226/slideitin/backend/api/services/auth"
+)
+
+// fakeVerifier returns its user, or its error
+type fakeVerifier struct {
+	user *auth.User
+	err  error
+}
+
+func (f *fakeVerifier) Verify(ctx context.Context, token string) (*auth.User, error) {
+	return f.user, f.err
+}
+
+func serveAuthenticated(verifier TokenVerifier, tracker *fakeTracker) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Use(TrackAbuse(tracker), Authenticate(verifier))
+	router.GET("/me", func(ctx *gin.Context) { ctx.String(http.StatusOK, CurrentUser(ctx).UID) })
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthenticateRejectsInvalidTokensAsOffenses(t *testing.T) {
+	tracker := &fakeTracker{recorded: map[string][]abuse.Offense{}}
+	w := serveAuthenticated(&fakeVerifier{err: fmt.Errorf("%w: expired", auth.ErrInvalidToken)}, tracker)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a 401 for an invalid token, got %d", w.Code)
+	}
+	if len(tracker.recorded["ip:203.0.113.7"]) != 1 {
+		t.Fatalf("expected the invalid token to count as an offense, got %v", tracker.recorded)
+	}
+}
+
+func TestAuthenticateAnswersVerifierFailuresWith503(t *testing.T) {
+	tracker := &fakeTracker{recorded: map[string][]abuse.Offense{}}
+	w := serveAuthenticated(&fakeVerifier{err: errors.New("failed to fetch signing keys: connection refused")}, tracker)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 when the token can't be verified, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "connection refused") {
+		t.Fatalf("expected the internal error left out of the response, got %s", w.Body.String())
+	}
+	if len(tracker.recorded) != 0 {
+		t.Fatalf("expected no offense when the verifier fails, got %v", tracker.recorded)
+	}
+}
+
+func TestAuthenticateStoresTheUser(t *testing.T) {
+	w := serveAuthenticated(&fakeVerifier{user: &auth.User{UID: "user-1"}}, &fakeTracker{recorded: map[string][]abuse.Offense{}})
+	if w.Code != http.StatusOK || w.Body.String() != "user-1" {
+		t.Fatalf("expected the user to be stored, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// OriginMatcher decides which browser origins may call the API
+type OriginMatcher struct {
+	exact     map[string]bool
+	wildcards []string // scheme://.suffix of the https://*.example.com patterns
+}
+
+// NewOriginMatcher creates a matcher for exact origins and wildcard subdomain
+// patterns such as https://*.example.com
+func NewOriginMatcher(origins []string) *OriginMatcher {
+	m := &OriginMatcher{exact: make(map[string]bool)}
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		if strings.Contains(origin, "://*.") {
+			m.wildcards = append(m.wildcards, strings.Replace(origin, "://*.", "://.", 1))
+			continue
+		}
+		m.exact[origin] = true
+	}
+	return m
+}
+
+// Allowed reports whether an origin is allowed
+func (m *OriginMatcher) Allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if m.exact[origin] {
+		return true
+	}
+	for _, wildcard := range m.wildcards {
+		scheme, suffix, _ := strings.Cut(wildcard, "://")
+		host, ok := strings.CutPrefix(origin, scheme+"://")
+		// The wildcard matches one or more subdomain labels, but not the bare domain
+		if ok && strings.HasSuffix(host, suffix) && len(host) > len(suffix) && !strings.ContainsAny(host, "/@") {
+			return true
+		}
+	}
+	return false
+}
+
+// beaconPath is the route share link viewers send their analytics to. The
+// sandboxed viewer has an opaque origin, so beacons may come from any origin.
+const beaconPath = "/v1/shared/:token/analytics"
+
+// CORS allows the origins of the matcher to call the API with credentials
+func CORS(origins *OriginMatcher) gin.HandlerFunc {
+	return cors.New(cors.Config{
+		AllowOriginFunc: origins.Allowed,
+		AllowOriginWithContextFunc: func(ctx *gin.Context, origin string) bool {
+			return ctx.Request.Method == "POST" && ctx.FullPath() == beaconPath
+		},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Cache-Control", "Connection", "Access-Control-Allow-Origin", "X-Share-Password", "X-Management-Key", "X-API-Key", "Idempotency-Key", "Authorization", "X-Claim-Token", "X-Captcha-Token"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Type", "Cache-Control", "Content-Encoding", "Transfer-Encoding", "Idempotent-Replayed", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	})
+}
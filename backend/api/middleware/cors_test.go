@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOriginMatcher(t *testing.T) {
+	origins := NewOriginMatcher([]string{"https://justslideitin.com", "https://*.staging.justslideitin.com", "http://localhost:3000"})
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://justslideitin.com", true},
+		{"https://JustSlideItIn.com", true},
+		{"http://localhost:3000", true},
+		{"https://pr-42.staging.justslideitin.com", true},
+		{"https://a.b.staging.justslideitin.com", true},
+		{"https://staging.justslideitin.com", false},
+		{"http://pr-42.staging.justslideitin.com", false},
+		{"https://evilstaging.justslideitin.com", false},
+		{"https://justslideitin.com.evil.com", false},
+		{"http://localhost:3001", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if allowed := origins.Allowed(test.origin); allowed != test.allowed {
+			t.Errorf("Allowed(%q) = %v, want %v", test.origin, allowed, test.allowed)
+		}
+	}
+}
+
+func TestCORSAcceptsViewerBeaconsFromAnyOrigin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CORS(NewOriginMatcher([]string{"https://justslideitin.com"})))
+	router.POST(beaconPath, func(ctx *gin.Context) { ctx.Status(http.StatusNoContent) })
+	router.POST("/v1/results/:id/share", func(ctx *gin.Context) { ctx.Status(http.StatusCreated) })
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/v1/shared/token-1/analytics", http.StatusNoContent},
+		{"/v1/results/job-1/share", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader("{}"))
+		req.Header.Set("Origin", "null")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("POST %s from an opaque origin: expected %d, got %d", test.path, test.status, rec.Code)
+		}
+	}
+}
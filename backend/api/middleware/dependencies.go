@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/breaker"
+)
+
+// Dependency returns a *breaker.OpenError while a dependency is down
+type Dependency interface {
+	Available(ctx context.Context) error
+}
+
+// FailFast refuses requests that create jobs while a dependency they need is
+// down, with a 503 naming it, instead of enqueueing jobs doomed to time out
+func FailFast(dependencies ...Dependency) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		for _, dependency := range dependencies {
+			err := dependency.Available(ctx)
+			var openErr *breaker.OpenError
+			if !errors.As(err, &openErr) {
+				continue
+			}
+
+			log.Printf("Refusing job while %s is down", openErr.Dependency)
+			ctx.Header("Retry-After", strconv.Itoa(int(openErr.RetryAfter().Seconds())))
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":      openErr.Error(),
+				"dependency": openErr.Dependency,
+				"retryAt":    openErr.RetryAt.Unix(),
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/breaker"
+)
+
+// fixedDependency is a dependency that is always up, or down when err is set
+type fixedDependency struct {
+	err error
+}
+
+func (d fixedDependency) Available(ctx context.Context) error {
+	return d.err
+}
+
+func TestFailFastRefusesJobsWhileADependencyIsDown(t *testing.T) {
+	down := &breaker.OpenError{Dependency: "Gemini", RetryAt: time.Now().Add(20 * time.Second)}
+	router := gin.New()
+	router.POST("/generate", FailFast(fixedDependency{}, fixedDependency{err: down}), func(ctx *gin.Context) {
+		ctx.Status(http.StatusAccepted)
+	})
+	router.POST("/up", FailFast(fixedDependency{}), func(ctx *gin.Context) {
+		ctx.Status(http.StatusAccepted)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/generate", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected a 503 with Retry-After while Gemini is down, got %d", w.Code)
+	}
+	var body struct {
+		Error      string `json:"error"`
+		Dependency string `json:"dependency"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Dependency != "Gemini" || body.Error != "Gemini is temporarily unavailable" {
+		t.Fatalf("expected the response to name Gemini, got %+v", body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/up", nil))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected jobs to be accepted while dependencies are up, got %d", w.Code)
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/maintenance"
+)
+
+// MaintenanceState returns whether the instance is in maintenance
+type MaintenanceState interface {
+	Current(ctx context.Context) maintenance.State
+}
+
+// AcceptJobs refuses requests that create jobs while the instance is in
+// maintenance, with a 503 and the ETA set by the admin. Admins still get
+// through, so they can check a deployment before reopening it.
+func AcceptJobs(state MaintenanceState, adminUIDs []string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		current := state.Current(ctx)
+		if !current.Enabled || IsAdmin(ctx, adminUIDs) {
+			ctx.Next()
+			return
+		}
+
+		if current.ETA > time.Now().Unix() {
+			ctx.Header("Retry-After", strconv.FormatInt(current.ETA-time.Now().Unix(), 10))
+		}
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":       current.ClientMessage(),
+			"maintenance": true,
+			"eta":         current.ETA,
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/services/auth"
+	"github.com/martin226/slideitin/backend/api/services/maintenance"
+)
+
+// fixedState is a maintenance state that never changes
+type fixedState maintenance.State
+
+func (s fixedState) Current(ctx context.Context) maintenance.State {
+	return maintenance.State(s)
+}
+
+func TestAcceptJobsRefusesJobsDuringMaintenance(t *testing.T) {
+	eta := time.Now().Add(10 * time.Minute).Unix()
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		if uid := ctx.GetHeader("X-Test-User"); uid != "" {
+			ctx.Set(UserKey, &auth.User{UID: uid})
+		}
+	})
+	router.POST("/generate", AcceptJobs(fixedState{Enabled: true, ETA: eta}, []string{"admin"}), func(ctx *gin.Context) {
+		ctx.Status(http.StatusAccepted)
+	})
+	router.POST("/open", AcceptJobs(fixedState{}, nil), func(ctx *gin.Context) {
+		ctx.Status(http.StatusAccepted)
+	})
+
+	serve := func(path, uid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if uid != "" {
+			req.Header.Set("X-Test-User", uid)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/generate", "")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected a 503 with Retry-After during maintenance, got %d", w.Code)
+	}
+	if w := serve("/generate", "admin"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected an admin to get through, got %d", w.Code)
+	}
+	if w := serve("/open", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("expected jobs to be accepted outside maintenance, got %d", w.Code)
+	}
+}
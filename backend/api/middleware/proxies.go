@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// TrustProxies makes ClientIP read the client address from X-Forwarded-For
+// only on requests from the given proxies, such as the Cloud Run front end.
+// The proxies append the address they received the request from, so the
+// entries a client sent itself are skipped and can't be used to get a fresh
+// quota or to evade a block.
+func TrustProxies(router *gin.Engine, proxies []string) error {
+	router.ForwardedByClientIP = true
+	router.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	return router.SetTrustedProxies(proxies)
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTrustProxiesIgnoresSpoofedForwardedFor(t *testing.T) {
+	router := gin.New()
+	if err := TrustProxies(router, []string{"169.254.0.0/16"}); err != nil {
+		t.Fatalf("TrustProxies failed: %v", err)
+	}
+	router.GET("/ip", func(ctx *gin.Context) { ctx.String(http.StatusOK, ctx.ClientIP()) })
+
+	serve := func(remoteAddr, forwardedFor string) string {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.Header.Set("X-Real-IP", "198.51.100.2")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if ip := serve("169.254.1.1:1234", "198.51.100.1, 203.0.113.7"); ip != "203.0.113.7" {
+		t.Fatalf("expected the address the proxy appended, got %s", ip)
+	}
+	if ip := serve("203.0.113.7:1234", "198.51.100.1"); ip != "203.0.113.7" {
+		t.Fatalf("expected the headers of a client that isn't a proxy to be ignored, got %s", ip)
+	}
+}
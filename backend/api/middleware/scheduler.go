@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/idtoken"
+)
+
+// RequireServiceAccount only lets through requests carrying a Google-signed
+// OIDC token for the audience issued to the service account, as sent by
+// Cloud Scheduler and Cloud Tasks
+func RequireServiceAccount(audience, email string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Missing bearer token",
+			})
+			return
+		}
+
+		payload, err := idtoken.Validate(ctx, strings.TrimSpace(token), audience)
+		if err != nil {
+			log.Printf("Rejected OIDC token: %v", err)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid bearer token",
+			})
+			return
+		}
+		if payload.Claims["email"] != email || payload.Claims["email_verified"] != true {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Token was not issued to the expected service account",
+			})
+			return
+		}
+
+		ctx.Next()
+	}
+}
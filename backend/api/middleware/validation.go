@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+// RequestKey is the context key under which BindFormJSON stores the parsed request
+const RequestKey = "request"
+
+var (
+	// languageTagPattern matches BCP 47 language tags such as en, fr or pt-BR
+	languageTagPattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+	// labelKeyPattern matches job label keys such as course or client_id
+	labelKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+
+	// fontFamilyPattern matches font family names such as Inter or Source Sans 3
+	fontFamilyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ]{0,59}$`)
+)
+
+// Violation is a single failed validation rule of a request
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// RegisterValidators adds the custom validation tags used by the models to
+// gin's validator and makes it report fields by their JSON names. Themes are
+// valid when they are built in or contributed reports them.
+func RegisterValidators(contributed func(theme string) bool) error {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return fmt.Errorf("unexpected validator engine %T", binding.Validator.Engine())
+	}
+
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	validators := map[string]validator.Func{
+		"enum": func(fl validator.FieldLevel) bool {
+			for _, value := range models.Enums[fl.Param()] {
+				if fl.Field().String() == value {
+					return true
+				}
+			}
+			return false
+		},
+		"theme": func(fl validator.FieldLevel) bool {
+			theme := fl.Field().String()
+			return slices.Contains(models.ValidThemes, theme) || contributed(theme)
+		},
+		"language": func(fl validator.FieldLevel) bool {
+			return languageTagPattern.MatchString(fl.Field().String())
+		},
+		"labelkey": func(fl validator.FieldLevel) bool {
+			return labelKeyPattern.MatchString(fl.Field().String())
+		},
+		"fontfamily": func(fl validator.FieldLevel) bool {
+			return fontFamilyPattern.MatchString(fl.Field().String())
+		},
+		"mailaddress": func(fl validator.FieldLevel) bool {
+			_, err := mail.ParseAddress(fl.Field().String())
+			return err == nil
+		},
+	}
+	for tag, fn := range validators {
+		if err := validate.RegisterValidation(tag, fn); err != nil {
+			return fmt.Errorf("failed to register %s validator: %v", tag, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks a struct against its binding tags and returns every violation
+func Validate(obj interface{}) []Violation {
+	err := binding.Validator.ValidateStruct(obj)
+	if err == nil {
+		return nil
+	}
+
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []Violation{{Message: err.Error()}}
+	}
+	violations := make([]Violation, 0, len(errs))
+	for _, fieldErr := range errs {
+		violations = append(violations, Violation{
+			Field:   fieldPath(fieldErr),
+			Message: violationMessage(fieldErr),
+		})
+	}
+	return violations
+}
+
+// AbortWithViolations responds with the structured validation error format:
+// a summary in error, as for every other error, and the violations in details
+func AbortWithViolations(ctx *gin.Context, violations []Violation) {
+	messages := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		messages = append(messages, violation.Message)
+	}
+	ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+		"error":   strings.Join(messages, "; "),
+		"details": violations,
+	})
+}
+
+// BindFormJSON parses the JSON in a multipart form field into a new T,
+// validates it, and stores it in the context under RequestKey
+func BindFormJSON[T any](field string, maxMemory int64) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := ctx.Request.ParseMultipartForm(maxMemory); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Failed to parse form data",
+			})
+			return
+		}
+
+		data := ctx.PostForm(field)
+		if data == "" {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Missing %s field in form", field),
+			})
+			return
+		}
+
+		req := new(T)
+		if err := json.Unmarshal([]byte(data), req); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid request format: %v", err),
+			})
+			return
+		}
+
+		if violations := Validate(req); len(violations) > 0 {
+			AbortWithViolations(ctx, violations)
+			return
+		}
+
+		ctx.Set(RequestKey, req)
+		ctx.Next()
+	}
+}
+
+// fieldPath returns the JSON path of a failed field without the struct name
+func fieldPath(fieldErr validator.FieldError) string {
+	namespace := fieldErr.Namespace()
+	if i := strings.Index(namespace, "."); i != -1 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+// violationMessage describes a failed validation rule
+func violationMessage(fieldErr validator.FieldError) string {
+	field := fieldPath(fieldErr)
+	switch fieldErr.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", field)
+	case "required_without":
+		return fmt.Sprintf("%s is required without %s", field, fieldErr.Param())
+	case "enum":
+		return fmt.Sprintf("Invalid %s: %v. Supported values are: %s",
+			fieldErr.Field(), fieldErr.Value(), strings.Join(models.Enums[fieldErr.Param()], ", "))
+	case "theme":
+		return fmt.Sprintf("Invalid %s: %v. Supported values are: %s, or a contributed theme listed by GET /v1/themes",
+			fieldErr.Field(), fieldErr.Value(), strings.Join(models.ValidThemes, ", "))
+	case "language":
+		return fmt.Sprintf("Invalid %s: %v. Use a language tag such as en or pt-BR", fieldErr.Field(), fieldErr.Value())
+	case "labelkey":
+		return fmt.Sprintf("Invalid label key: %v. Use lowercase letters, digits, _ and -, starting with a letter", fieldErr.Value())
+	case "fontfamily":
+		return fmt.Sprintf("Invalid %s: %v. Use the name of a Google Fonts family or of a font uploaded to the workspace, such as Inter", fieldErr.Field(), fieldErr.Value())
+	case "mailaddress":
+		return fmt.Sprintf("Invalid %s: %v", fieldErr.Field(), fieldErr.Value())
+	case "min":
+		if fieldErr.Kind() == reflect.String {
+			return fmt.Sprintf("%s must be at least %s characters", field, fieldErr.Param())
+		}
+		return fmt.Sprintf("%s must have at least %s entries", field, fieldErr.Param())
+	case "max":
+		if fieldErr.Kind() == reflect.String {
+			return fmt.Sprintf("%s must be at most %s characters", field, fieldErr.Param())
+		}
+		return fmt.Sprintf("%s must have at most %s entries", field, fieldErr.Param())
+	default:
+		return fmt.Sprintf("%s failed the %s check", field, fieldErr.Tag())
+	}
+}
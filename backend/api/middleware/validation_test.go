@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+func TestMain(m *testing.M) {
+	contributed := func(theme string) bool { return theme == "solarized" }
+	if err := RegisterValidators(contributed); err != nil {
+		panic(err)
+	}
+	gin.SetMode(gin.TestMode)
+	m.Run()
+}
+
+func TestValidateReturnsAllViolations(t *testing.T) {
+	req := models.SlideRequest{
+		Theme: "neon",
+		Settings: models.SlideSettings{
+			Audience: "aliens",
+			Language: "english!",
+			Footer:   strings.Repeat("a", 101),
+			Font:     "Inter'; }",
+		},
+		NotifyEmail: "not-an-email",
+		Labels:      map[string]string{"Course": "CS101", "term": ""},
+	}
+
+	violations := Validate(&req)
+	fields := make(map[string]string)
+	for _, violation := range violations {
+		fields[violation.Field] = violation.Message
+	}
+
+	for _, field := range []string{"theme", "settings.audience", "settings.language", "settings.footer", "settings.font", "notifyEmail", "labels[Course]", "labels[term]"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("expected a violation for %s, got %+v", field, violations)
+		}
+	}
+	if message := fields["theme"]; !strings.Contains(message, "Supported values are: default, beam") {
+		t.Errorf("expected the theme message to list the supported themes, got %q", message)
+	}
+}
+
+func TestValidateAcceptsValidRequest(t *testing.T) {
+	req := models.SlideRequest{
+		Theme: "beam",
+		Settings: models.SlideSettings{
+			SlideDetail: "medium",
+			Language:    "pt-BR",
+			Font:        "Source Sans 3",
+		},
+		NotifyEmail: "Ada <ada@example.com>",
+		Labels:      map[string]string{"course": "CS101"},
+	}
+
+	if violations := Validate(&req); len(violations) != 0 {
+		t.Fatalf("expected no violations, got %+v", violations)
+	}
+}
+
+func TestValidateAcceptsContributedThemes(t *testing.T) {
+	if violations := Validate(&models.SlideRequest{Theme: "solarized"}); len(violations) != 0 {
+		t.Fatalf("expected no violations for a contributed theme, got %+v", violations)
+	}
+	violations := Validate(&models.SlideRequest{Theme: "dracula"})
+	if len(violations) != 1 || violations[0].Field != "theme" {
+		t.Fatalf("expected a theme violation, got %+v", violations)
+	}
+}
+
+func TestValidateAllowsPresetWithoutTheme(t *testing.T) {
+	if violations := Validate(&models.SlideRequest{PresetID: "preset-1"}); len(violations) != 0 {
+		t.Fatalf("expected no violations, got %+v", violations)
+	}
+	violations := Validate(&models.SlideRequest{})
+	if len(violations) != 1 || violations[0].Field != "theme" {
+		t.Fatalf("expected a missing theme violation, got %+v", violations)
+	}
+}
+
+func TestBindFormJSONRespondsWithViolations(t *testing.T) {
+	router := gin.New()
+	router.POST("/generate", BindFormJSON[models.SlideRequest]("data", 1<<20), func(ctx *gin.Context) {
+		ctx.Status(http.StatusAccepted)
+	})
+
+	send := func(data string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("data", data)
+		writer.Close()
+		request := httptest.NewRequest(http.MethodPost, "/generate", &body)
+		request.Header.Set("Content-Type", writer.FormDataContentType())
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := send(`{"theme": "neon", "settings": {"slideDetail": "huge"}}`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", recorder.Code)
+	}
+	var response struct {
+		Error   string      `json:"error"`
+		Details []Violation `json:"details"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(response.Details) != 2 || response.Error == "" {
+		t.Fatalf("expected 2 violations and a summary, got %+v", response)
+	}
+
+	if recorder := send(`{"theme": "beam", "settings": {}}`); recorder.Code != http.StatusAccepted {
+		t.Fatalf("expected the valid request to reach the handler, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -4,38 +4,196 @@ package models
 var (
 	// Valid themes
 	ValidThemes = []string{"default", "beam", "rose_pine", "gaia", "uncover", "graph_paper"}
-	
+
+	// Themes of ValidThemes still rolling out behind the new_themes feature flag
+	ExperimentalThemes []string
+
 	// Valid slide detail levels
 	ValidSlideDetails = []string{"minimal", "medium", "detailed"}
-	
+
 	// Valid audience types
 	ValidAudiences = []string{"general", "academic", "technical", "professional", "executive"}
+
+	// Valid deck templates
+	ValidDeckTemplates = []string{"pitch_deck", "lecture", "standup", "research_talk"}
+
+	// Valid visual styles, playful decks use emoji and icons as visual bullets
+	ValidVisualStyles = []string{"standard", "playful"}
+
+	// Valid layout styles, which pick the slide classes and transitions instead of the model
+	ValidLayoutStyles = []string{"minimal", "balanced", "bold"}
+
+	// Valid renderers, marp renders the generated markdown as it is and the
+	// others render it converted to their own markdown
+	ValidRenderers = []string{"marp", "slidev", "beamer", "native"}
+
+	// Valid schedule source types
+	ValidSourceTypes = []string{"url", "drive", "rss"}
+
+	// Valid content sources documents can be imported from
+	ValidContentSources = []string{"confluence", "sharepoint", "github", "paper", "rss"}
+
+	// SourceAudiences is the audience a deck from a content source defaults to
+	SourceAudiences = map[string]string{"github": "technical", "paper": "academic"}
+
+	// Enums maps the names used by the enum validation tag to their values
+	Enums = map[string][]string{
+		"slideDetails":   ValidSlideDetails,
+		"audiences":      ValidAudiences,
+		"deckTemplates":  ValidDeckTemplates,
+		"visualStyles":   ValidVisualStyles,
+		"layoutStyles":   ValidLayoutStyles,
+		"renderers":      ValidRenderers,
+		"sourceTypes":    ValidSourceTypes,
+		"contentSources": ValidContentSources,
+	}
 )
 
 // SlideSettings represents the settings for slide generation
 type SlideSettings struct {
-	SlideDetail string `json:"slideDetail"` // Values: minimal, medium, detailed
-	Audience    string `json:"audience"`    // Values: general, academic, technical, professional, executive
+	SlideDetail      string   `json:"slideDetail" binding:"omitempty,enum=slideDetails"`             // Values: minimal, medium, detailed
+	Audience         string   `json:"audience" binding:"omitempty,enum=audiences"`                   // Values: general, academic, technical, professional, executive
+	IncludeAgenda    bool     `json:"includeAgenda,omitempty"`                                       // Adds an agenda slide after the title slide
+	IncludeSummary   bool     `json:"includeSummary,omitempty"`                                      // Appends a key-takeaways summary slide
+	IncludeCitations bool     `json:"includeCitations,omitempty"`                                    // Annotates bullets with PDF page numbers and adds a references slide
+	Accessibility    bool     `json:"accessibility,omitempty"`                                       // Enforces alt text, contrast and font size checks and emits a report
+	Flashcards       bool     `json:"flashcards,omitempty"`                                          // Extracts term and definition flashcards from the sources, exported as CSV
+	OnePager         bool     `json:"onePager,omitempty"`                                            // Also writes a one-page executive summary of the sources, as PDF and markdown
+	InstructorMode   bool     `json:"instructorMode,omitempty"`                                      // Adds presenter notes for the instructor and writes a student handout with blanks and questions, as PDF and markdown
+	Language         string   `json:"language,omitempty" binding:"omitempty,language"`               // BCP 47 language tag of the deck, defaults to en
+	Footer           string   `json:"footer,omitempty" binding:"max=100"`                            // Footer stamped on every slide, {date} is replaced with the current date
+	Watermark        string   `json:"watermark,omitempty" binding:"max=100"`                         // Watermark drawn across every slide, e.g. "Confidential — Draft"
+	DeckTemplate     string   `json:"deckTemplate,omitempty" binding:"omitempty,enum=deckTemplates"` // Values: pitch_deck, lecture, standup, research_talk
+	Font             string   `json:"font,omitempty" binding:"omitempty,fontfamily"`                 // Google Fonts family or font uploaded to the workspace for the text, e.g. "Inter"
+	HeadingFont      string   `json:"headingFont,omitempty" binding:"omitempty,fontfamily"`          // Font of the headings, defaults to the text font
+	VisualStyle      string   `json:"visualStyle,omitempty" binding:"omitempty,enum=visualStyles"`   // Values: standard, playful
+	LayoutStyle      string   `json:"layoutStyle,omitempty" binding:"omitempty,enum=layoutStyles"`   // Values: minimal, balanced, bold
+	Renderer         string   `json:"renderer,omitempty" binding:"omitempty,enum=renderers"`         // Values: marp, slidev, beamer, native, defaults to marp
+	FocusTopics      []string `json:"focusTopics,omitempty" binding:"max=5,dive,min=1,max=100"`      // Topics the sections of long documents kept or summarized are picked for, e.g. "pricing"
+	ChunkSummaries   bool     `json:"chunkSummaries,omitempty"`                                      // Keeps the summaries of the sections of long documents as a JSON document of the result
+	FactCheck        bool     `json:"factCheck,omitempty"`                                           // Checks the bullet points against the sources and reports the unsupported ones as a JSON document of the result
+	Chapters         []string `json:"chapters,omitempty" binding:"max=20,dive,max=200"`              // Titles of the chapter decks of a batch, set on its overview deck so it introduces them
+}
+
+// TokenLimits overrides the Gemini token limits of the slides service for a
+// job. Zero keeps the limit the slides service is configured with.
+type TokenLimits struct {
+	Input  int `json:"input,omitempty"`  // Most tokens sent to Gemini for a deck
+	Output int `json:"output,omitempty"` // Most tokens Gemini writes for a deck
+}
+
+// FontFile is a font file uploaded to a workspace and stored in Cloud Storage
+type FontFile struct {
+	ID     string `json:"id" firestore:"id"` // SHA-256 of the file
+	Family string `json:"family" firestore:"family"`
+	Path   string `json:"path" firestore:"path"`
+	Weight int    `json:"weight" firestore:"weight"` // From 100 to 900
+	Style  string `json:"style" firestore:"style"`   // normal or italic
 }
 
 type File struct {
 	Filename string `json:"filename"`
-	Data []byte `json:"data"`
-	Type string `json:"type"`
+	Data     []byte `json:"data"`
+	Type     string `json:"type"`
 }
 
 // SlideRequest represents the incoming request for slide generation
 type SlideRequest struct {
-	Theme    string       `json:"theme" binding:"required"`
-	Settings SlideSettings `json:"settings" binding:"required"`
+	Theme         string             `json:"theme" binding:"required_without=PresetID,omitempty,theme"`
+	PresetID      string             `json:"presetId,omitempty"` // Optional saved preset whose theme and settings fill in the ones left empty
+	Settings      SlideSettings      `json:"settings" binding:"required"`
+	NotifyEmail   string             `json:"notifyEmail,omitempty" binding:"omitempty,mailaddress"`                       // Optional address the finished deck is emailed to
+	Labels        map[string]string  `json:"labels,omitempty" binding:"max=10,dive,keys,labelkey,endkeys,min=1,max=63"`   // Optional labels such as course=CS101 used to filter the job history
+	DriveFileIDs  []string           `json:"driveFileIds,omitempty" binding:"max=10,dive,min=10,max=200,excludesall=/?#"` // Optional Google Drive files to generate from, read with the connected Drive
+	Sources       []ContentSourceRef `json:"sources,omitempty" binding:"max=5,dive"`                                      // Optional wiki pages, documents and repositories to import, e.g. from Confluence, SharePoint or GitHub
+	DocumentIDs   []string           `json:"documentIds,omitempty" binding:"max=10,dive,len=64,hexadecimal"`              // Optional documents of the workspace library to generate from, uploaded and indexed once
+	Prompt        string             `json:"prompt,omitempty" binding:"max=2000"`                                         // Topic or outline to write the deck from when there are no files, e.g. "Intro to Kubernetes for beginners, 12 slides"
+	Ephemeral     bool               `json:"ephemeral,omitempty"`                                                         // Keep nothing once the job ends, the result is fetched once with the result token of the response
+	DryRun        bool               `json:"dryRun,omitempty"`                                                            // Validate the request and return the final prompt and projections instead of creating a job
+	SplitChapters bool               `json:"splitChapters,omitempty"`                                                     // Split a course or long document into a deck per chapter plus an overview deck, created as a batch
+	Debug         bool               `json:"debug,omitempty"`                                                             // Capture the prompts and raw Gemini responses of the job, only for the admins of the instance
+	// Files will be handled separately through multipart form
+}
+
+// EstimateRequest represents a prospective job whose cost is estimated
+type EstimateRequest struct {
+	Theme    string        `json:"theme" binding:"omitempty,theme"` // Defaults to the default theme
+	Settings SlideSettings `json:"settings"`
+	Prompt   string        `json:"prompt,omitempty" binding:"max=2000"` // Topic to write the deck from when there are no files
 	// Files will be handled separately through multipart form
 }
 
+// ContentSourceRef references a document in a content source such as Confluence
+type ContentSourceRef struct {
+	Type     string            `json:"type" binding:"required,enum=contentSources"`                          // Values: confluence, sharepoint, github, paper, rss
+	Location string            `json:"location" binding:"required,max=2048"`                                 // URL of the page, space, document or repository, the arXiv ID or DOI of a paper, or a feed URL
+	Options  map[string]string `json:"options,omitempty" binding:"max=5,dive,keys,labelkey,endkeys,max=200"` // Source specific options, e.g. structure=true for GitHub or since and until dates for rss
+}
+
+// PresetRequest represents a named set of settings to save as a preset
+type PresetRequest struct {
+	Name     string        `json:"name" binding:"required,max=100"`
+	Theme    string        `json:"theme" binding:"required,theme"`
+	Settings SlideSettings `json:"settings"`
+}
+
+// ScheduleSource is the document a schedule generates its deck from
+type ScheduleSource struct {
+	Type     string            `json:"type" binding:"required,enum=sourceTypes"`                             // Values: url, drive, rss
+	Location string            `json:"location" binding:"required,max=2048"`                                 // http(s) URL, Drive file ID or feed URL
+	Options  map[string]string `json:"options,omitempty" binding:"max=5,dive,keys,labelkey,endkeys,max=200"` // Feed options, e.g. days=7 for a weekly digest
+}
+
+// ScheduleRequest represents a recurring generation to create or replace
+type ScheduleRequest struct {
+	Name        string            `json:"name" binding:"required,max=100"`
+	Cron        string            `json:"cron" binding:"required"` // Five-field cron expression, at most one run an hour
+	TimeZone    string            `json:"timeZone,omitempty"`      // IANA time zone of the cron expression, defaults to UTC
+	Source      ScheduleSource    `json:"source"`
+	Theme       string            `json:"theme" binding:"required_without=PresetID,omitempty,theme"`
+	PresetID    string            `json:"presetId,omitempty"` // Saved preset applied on every run
+	Settings    SlideSettings     `json:"settings"`
+	NotifyEmail string            `json:"notifyEmail,omitempty" binding:"omitempty,mailaddress"`
+	Labels      map[string]string `json:"labels,omitempty" binding:"max=10,dive,keys,labelkey,endkeys,min=1,max=63"`
+	Paused      bool              `json:"paused,omitempty"` // Keeps the schedule without running it
+}
+
+// JobFilter represents the label filters of a job history request
+type JobFilter struct {
+	Labels map[string]string `json:"labels" binding:"max=10,dive,keys,labelkey,endkeys,min=1,max=63"`
+}
+
+// RefineRequest represents a chat-style instruction applied to a generated deck,
+// or feedback on one of its slides
+type RefineRequest struct {
+	Instruction string `json:"instruction" binding:"required,max=2000"` // e.g. "shorten section 2" or "add a slide about pricing", or why a slide was rejected
+	Revision    int    `json:"revision,omitempty" binding:"min=0"`      // Revision to refine, defaults to the latest
+	Slide       int    `json:"slide,omitempty" binding:"min=0,max=500"` // Slide given a thumbs-down, regenerated alone with the instruction as the reason
+}
+
 // SlideResponse represents the response for a slide generation request
 type SlideResponse struct {
-	ID         string `json:"id"`
-	Status     string `json:"status"`
-	Message    string `json:"message"`
-	CreatedAt  int64  `json:"createdAt"`
-	UpdatedAt  int64  `json:"updatedAt"`
-} 
\ No newline at end of file
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	Message       string            `json:"message"`
+	MessageCode   string            `json:"messageCode,omitempty"` // Code of the message, for frontends that localize it themselves
+	MessageParams map[string]string `json:"messageParams,omitempty"`
+	CreatedAt     int64             `json:"createdAt"`
+	UpdatedAt     int64             `json:"updatedAt"`
+	ResultToken   string            `json:"resultToken,omitempty"` // Fetches the result of an ephemeral job once, as the token query parameter
+	ClaimToken    string            `json:"claimToken,omitempty"`  // Gives access to an anonymous job, as the X-Claim-Token header or claim query parameter
+	Warnings      []string          `json:"warnings,omitempty"`    // Files left out of the job, which still goes ahead with the rest
+}
+
+// BatchResponse represents the response to a request split into a deck per chapter
+type BatchResponse struct {
+	ID       string            `json:"id"`
+	Overview SlideResponse     `json:"overview"` // Deck of the whole document introducing the chapters
+	Chapters []ChapterResponse `json:"chapters"`
+}
+
+// ChapterResponse represents the job writing the deck of a chapter
+type ChapterResponse struct {
+	Title string        `json:"title"`
+	Job   SlideResponse `json:"job"`
+}
@@ -0,0 +1,348 @@
+package abuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/services/apikeys"
+	"github.com/martin226/slideitin/backend/api/services/quota"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Offense is a rejected request that counts toward blocking its client
+type Offense string
+
+// Offenses counted against clients
+const (
+	OffenseInvalidCredentials Offense = "invalid_credentials"  // Unknown or disabled API key, or an invalid ID token
+	OffenseMissingClaim       Offense = "missing_claim"        // Anonymous job accessed without its claim token
+	OffenseOversizedUpload    Offense = "oversized_upload"     // File over the size limit of the plan
+	OffenseUnsupportedFile    Offense = "unsupported_file"     // File of a type that can't be generated from
+	OffenseQuotaExceeded      Offense = "quota_exceeded"       // Job over the daily quota of anonymous clients
+	OffenseInvalidCaptcha     Offense = "invalid_captcha"      // Anonymous job with an expired, reused or forged CAPTCHA token
+	OffenseWrongSharePassword Offense = "wrong_share_password" // Wrong password for a protected share link
+)
+
+// weights are the strikes each offense counts, higher for the offenses that
+// cost the most to handle or that probe for other clients' data
+var weights = map[Offense]int{
+	OffenseInvalidCredentials: 2,
+	OffenseMissingClaim:       2,
+	OffenseOversizedUpload:    5,
+	OffenseUnsupportedFile:    2,
+	OffenseQuotaExceeded:      1,
+	OffenseInvalidCaptcha:     2,
+	OffenseWrongSharePassword: 2,
+}
+
+const (
+	// strikeWindow is how long strikes count toward a block
+	strikeWindow = time.Hour
+
+	// blockDuration is how long the first block of a client lasts, each
+	// further block lasts twice as long up to maxBlockDuration
+	blockDuration    = time.Hour
+	maxBlockDuration = 24 * time.Hour
+
+	// recordTTL is how long a record is kept after its last strike or block,
+	// so repeated blocks within it get longer
+	recordTTL = 7 * 24 * time.Hour
+
+	// blocksTTL is how long the active blocks read from Firestore are used
+	// before they are read again, so blocks set by other instances and lifted
+	// by admins apply within a minute
+	blocksTTL = time.Minute
+
+	// MaxExemption is the longest an admin can exempt a client from blocks
+	MaxExemption = 30 * 24 * time.Hour
+)
+
+// ErrUnknownSubject is returned when lifting the block of a client with no record
+var ErrUnknownSubject = errors.New("no abuse record for this client")
+
+// FirestoreRecord is the Firestore representation of the strikes and blocks
+// of a client, keyed by its subject
+type FirestoreRecord struct {
+	Strikes      int            `firestore:"strikes"`
+	Offenses     map[string]int `firestore:"offenses,omitempty"` // Offenses of the current window, by kind
+	WindowStart  int64          `firestore:"windowStart"`
+	BlockedUntil int64          `firestore:"blockedUntil,omitempty"`
+	Blocks       int            `firestore:"blocks,omitempty"`      // Blocks since the record was created
+	ExemptUntil  int64          `firestore:"exemptUntil,omitempty"` // Set by an admin, the client isn't blocked until then
+	UpdatedAt    int64          `firestore:"updatedAt"`
+	DeleteAt     time.Time      `firestore:"deleteAt"` // For the Firestore TTL policy
+}
+
+// Block is a client blocked from the API
+type Block struct {
+	Subject      string         `json:"subject"`
+	BlockedUntil int64          `json:"blockedUntil"`
+	Blocks       int            `json:"blocks"`   // Times the client was blocked, including this one
+	Offenses     map[string]int `json:"offenses"` // Offenses that led to the block, by kind
+}
+
+// Service counts the offenses of clients and blocks the clients that reach
+// the strike limit within an hour
+type Service struct {
+	client       *firestore.Client
+	strikeLimit  int
+	fingerprints *quota.Fingerprinter
+
+	mu        sync.Mutex
+	blocks    map[string]int64 // Blocked subjects and when their block ends
+	fetchedAt time.Time
+}
+
+// NewService creates a new abuse service. A strike limit of 0 disables blocking.
+func NewService(client *firestore.Client, strikeLimit int, fingerprints *quota.Fingerprinter) *Service {
+	return &Service{
+		client:       client,
+		strikeLimit:  strikeLimit,
+		fingerprints: fingerprints,
+	}
+}
+
+// Collection returns the Firestore collection reference for abuse records
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("abuse")
+}
+
+// Enabled reports whether clients are blocked on this instance
+func (s *Service) Enabled() bool {
+	return s.strikeLimit > 0
+}
+
+// IPSubject is the subject of the client at an IP address, which isn't stored
+func (s *Service) IPSubject(ip string) string {
+	return "ip:" + s.fingerprints.Fingerprint(ip)
+}
+
+// APIKeySubject is the subject of the client sending an API key, named by the
+// ID of the key
+func APIKeySubject(key string) string {
+	return "key:" + apikeys.HashKey(key)
+}
+
+// ParseSubject returns the subject an admin named, accepting an IP address
+// such as ip:203.0.113.7 in place of its fingerprint
+func (s *Service) ParseSubject(subject string) (string, bool) {
+	kind, id, ok := strings.Cut(subject, ":")
+	if !ok || id == "" || (kind != "ip" && kind != "key") {
+		return "", false
+	}
+	if kind == "ip" && net.ParseIP(id) != nil {
+		return s.IPSubject(id), true
+	}
+	return subject, true
+}
+
+// Blocked returns the latest end of the blocks of the subjects, or zero if
+// none of them is blocked. Blocks are read from a cache, and the last blocks
+// read are used when Firestore can't be read, so requests never fail on them.
+func (s *Service) Blocked(ctx context.Context, subjects []string) time.Time {
+	if !s.Enabled() {
+		return time.Time{}
+	}
+	blocks := s.loadBlocks(ctx)
+	now := time.Now().Unix()
+	var until int64
+	for _, subject := range subjects {
+		if end := blocks[subject]; end > now && end > until {
+			until = end
+		}
+	}
+	if until == 0 {
+		return time.Time{}
+	}
+	return time.Unix(until, 0)
+}
+
+// loadBlocks returns the active blocks, reading them again once they are
+// older than blocksTTL
+func (s *Service) loadBlocks(ctx context.Context) map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blocks != nil && time.Since(s.fetchedAt) < blocksTTL {
+		return s.blocks
+	}
+
+	blocks := make(map[string]int64)
+	iter := s.Collection().Where("blockedUntil", ">", time.Now().Unix()).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to read blocked clients, using the last ones read: %v", err)
+			s.fetchedAt = time.Now()
+			if s.blocks == nil {
+				s.blocks = map[string]int64{}
+			}
+			return s.blocks
+		}
+		var record FirestoreRecord
+		if err := doc.DataTo(&record); err != nil {
+			continue
+		}
+		blocks[doc.Ref.ID] = record.BlockedUntil
+	}
+	s.blocks = blocks
+	s.fetchedAt = time.Now()
+	return blocks
+}
+
+// Record counts an offense against each subject, blocking the ones that reach
+// the strike limit. Failures are only logged, the offense is already rejected.
+func (s *Service) Record(ctx context.Context, subjects []string, offense Offense) {
+	if !s.Enabled() {
+		return
+	}
+	for _, subject := range subjects {
+		if err := s.record(ctx, subject, offense); err != nil {
+			log.Printf("Warning: Failed to record %s offense: %v", offense, err)
+		}
+	}
+}
+
+// record counts an offense against a subject in a transaction
+func (s *Service) record(ctx context.Context, subject string, offense Offense) error {
+	ref := s.Collection().Doc(subject)
+	var blockedUntil int64
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		blockedUntil = 0
+		var record FirestoreRecord
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&record); err != nil {
+				return err
+			}
+		}
+		if record.strike(offense, time.Now(), s.strikeLimit) {
+			blockedUntil = record.BlockedUntil
+		}
+		return tx.Set(ref, record)
+	})
+	if err != nil {
+		return err
+	}
+
+	if blockedUntil > 0 {
+		log.Printf("Blocked %s until %s", subject, time.Unix(blockedUntil, 0).Format(time.RFC3339))
+		s.mu.Lock()
+		if s.blocks != nil {
+			s.blocks[subject] = blockedUntil
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// strike counts an offense in the record and blocks the client when it
+// reaches the strike limit within the window. It reports whether the client
+// was blocked by this offense.
+func (r *FirestoreRecord) strike(offense Offense, now time.Time, limit int) bool {
+	r.UpdatedAt = now.Unix()
+	r.DeleteAt = now.Add(recordTTL)
+	if r.ExemptUntil > now.Unix() || r.BlockedUntil > now.Unix() {
+		return false
+	}
+	if now.Unix()-r.WindowStart >= int64(strikeWindow.Seconds()) {
+		r.WindowStart = now.Unix()
+		r.Strikes = 0
+		r.Offenses = nil
+	}
+	if r.Offenses == nil {
+		r.Offenses = make(map[string]int)
+	}
+	r.Offenses[string(offense)]++
+	r.Strikes += weights[offense]
+	if r.Strikes < limit {
+		return false
+	}
+
+	duration := blockDuration << min(r.Blocks, 5)
+	r.Blocks++
+	r.BlockedUntil = now.Add(min(duration, maxBlockDuration)).Unix()
+	r.DeleteAt = time.Unix(r.BlockedUntil, 0).Add(recordTTL)
+	return true
+}
+
+// ListBlocks returns the clients blocked now, the longest blocked first
+func (s *Service) ListBlocks(ctx context.Context) ([]Block, error) {
+	docs, err := s.Collection().Where("blockedUntil", ">", time.Now().Unix()).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing blocked clients: %v", err)
+	}
+	blocks := make([]Block, 0, len(docs))
+	for _, doc := range docs {
+		var record FirestoreRecord
+		if err := doc.DataTo(&record); err != nil {
+			return nil, fmt.Errorf("error parsing abuse record: %v", err)
+		}
+		blocks = append(blocks, Block{
+			Subject:      doc.Ref.ID,
+			BlockedUntil: record.BlockedUntil,
+			Blocks:       record.Blocks,
+			Offenses:     record.Offenses,
+		})
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].BlockedUntil > blocks[j].BlockedUntil })
+	return blocks, nil
+}
+
+// Unblock lifts the block of a subject and clears its strikes, exempting it
+// from further blocks for the given time, such as for a shared office network
+func (s *Service) Unblock(ctx context.Context, subject string, exemptFor time.Duration) error {
+	ref := s.Collection().Doc(subject)
+	now := time.Now()
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound && exemptFor == 0 {
+			return ErrUnknownSubject
+		}
+		var record FirestoreRecord
+		if err == nil {
+			if err := doc.DataTo(&record); err != nil {
+				return err
+			}
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+		record.BlockedUntil = 0
+		record.Strikes = 0
+		record.Offenses = nil
+		record.ExemptUntil = 0
+		if exemptFor > 0 {
+			record.ExemptUntil = now.Add(exemptFor).Unix()
+		}
+		record.UpdatedAt = now.Unix()
+		record.DeleteAt = now.Add(exemptFor + recordTTL)
+		return tx.Set(ref, record)
+	})
+	if errors.Is(err, ErrUnknownSubject) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error lifting block: %v", err)
+	}
+
+	s.mu.Lock()
+	delete(s.blocks, subject)
+	s.mu.Unlock()
+	return nil
+}
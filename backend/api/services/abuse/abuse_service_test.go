@@ -0,0 +1,74 @@
+package abuse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/services/quota"
+)
+
+func TestStrikeBlocksAtLimitAndEscalates(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	var record FirestoreRecord
+
+	for _, offense := range []Offense{OffenseOversizedUpload, OffenseOversizedUpload, OffenseOversizedUpload, OffenseInvalidCredentials, OffenseInvalidCredentials} {
+		if record.strike(offense, now, 20) {
+			t.Fatalf("expected no block at %d strikes", record.Strikes)
+		}
+	}
+	if !record.strike(OffenseMissingClaim, now, 20) {
+		t.Fatal("expected the client to be blocked at the strike limit")
+	}
+	if record.BlockedUntil != now.Add(time.Hour).Unix() || record.Offenses["oversized_upload"] != 3 {
+		t.Fatalf("unexpected first block: %+v", record)
+	}
+
+	// Offenses while blocked don't extend the block
+	if record.strike(OffenseQuotaExceeded, now.Add(time.Minute), 20) || record.BlockedUntil != now.Add(time.Hour).Unix() {
+		t.Fatalf("expected offenses during a block to be ignored: %+v", record)
+	}
+
+	// The window restarts after the block and the next block lasts twice as long
+	later := now.Add(2 * time.Hour)
+	for i := 0; i < 3; i++ {
+		record.strike(OffenseOversizedUpload, later, 20)
+	}
+	if record.Strikes != 15 || record.Offenses["invalid_credentials"] != 0 {
+		t.Fatalf("expected a new window of strikes: %+v", record)
+	}
+	record.strike(OffenseOversizedUpload, later, 20)
+	if record.Blocks != 2 || record.BlockedUntil != later.Add(2*time.Hour).Unix() {
+		t.Fatalf("unexpected second block: %+v", record)
+	}
+}
+
+func TestStrikeCapsBlockDuration(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	record := FirestoreRecord{Blocks: 9, WindowStart: now.Unix()}
+	if !record.strike(OffenseMissingClaim, now, 1) || record.BlockedUntil != now.Add(maxBlockDuration).Unix() {
+		t.Fatalf("expected the block to last %s: %+v", maxBlockDuration, record)
+	}
+}
+
+func TestStrikeSkipsExemptClients(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	record := FirestoreRecord{ExemptUntil: now.Add(time.Hour).Unix()}
+	if record.strike(OffenseOversizedUpload, now, 1) || record.Strikes != 0 {
+		t.Fatalf("expected an exempt client not to be blocked: %+v", record)
+	}
+}
+
+func TestParseSubject(t *testing.T) {
+	s := NewService(nil, 50, quota.NewFingerprinter("test-secret"))
+	if subject, ok := s.ParseSubject("ip:203.0.113.7"); !ok || subject != s.IPSubject("203.0.113.7") {
+		t.Fatalf("expected an IP address to be fingerprinted, got %q", subject)
+	}
+	if subject, ok := s.ParseSubject("key:abc123"); !ok || subject != "key:abc123" {
+		t.Fatalf("expected a key ID to be kept, got %q", subject)
+	}
+	for _, subject := range []string{"", "ip:", "user:abc", "203.0.113.7"} {
+		if _, ok := s.ParseSubject(subject); ok {
+			t.Fatalf("expected %q to be rejected", subject)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrInvalidAPIKey is returned when an API key doesn't exist or is disabled
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// FirestoreAPIKey is the Firestore representation of an API key.
+// Documents are keyed by the SHA-256 hash of the key so keys are never stored in plain text.
+type FirestoreAPIKey struct {
+	Name              string `firestore:"name"`
+	SlackWebhookURL   string `firestore:"slackWebhookUrl,omitempty"`
+	DiscordWebhookURL string `firestore:"discordWebhookUrl,omitempty"`
+	Disabled          bool   `firestore:"disabled"`
+	CreatedAt         int64  `firestore:"createdAt"`
+	WorkspaceID       string `firestore:"workspaceId,omitempty"` // Workspace the key is a member of
+	Role              string `firestore:"role,omitempty"`        // Role in the workspace: admin, editor or viewer
+}
+
+// APIKey holds the configuration attached to an API key
+type APIKey struct {
+	ID                string
+	Name              string
+	SlackWebhookURL   string
+	DiscordWebhookURL string
+	WorkspaceID       string
+	Role              string
+}
+
+// Service looks up API keys stored in Firestore
+type Service struct {
+	client *firestore.Client
+}
+
+// NewService creates a new API key service
+func NewService(client *firestore.Client) *Service {
+	return &Service{
+		client: client,
+	}
+}
+
+// Collection returns the Firestore collection reference for API keys
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("apiKeys")
+}
+
+// Lookup returns the configuration of an API key
+func (s *Service) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	return s.Get(ctx, HashKey(key))
+}
+
+// Get returns the configuration of an API key by its ID, the hash of the key
+func (s *Service) Get(ctx context.Context, id string) (*APIKey, error) {
+	doc, err := s.Collection().Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, fmt.Errorf("error retrieving API key: %v", err)
+	}
+
+	var apiKey FirestoreAPIKey
+	if err := doc.DataTo(&apiKey); err != nil {
+		return nil, fmt.Errorf("error parsing API key data: %v", err)
+	}
+
+	if apiKey.Disabled {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return &APIKey{
+		ID:                id,
+		Name:              apiKey.Name,
+		SlackWebhookURL:   apiKey.SlackWebhookURL,
+		DiscordWebhookURL: apiKey.DiscordWebhookURL,
+		WorkspaceID:       apiKey.WorkspaceID,
+		Role:              apiKey.Role,
+	}, nil
+}
+
+// HashKey returns the document ID for an API key
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// firebaseCertsURL serves the public keys that sign Firebase ID tokens
+	firebaseCertsURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
+
+	// clockSkew is the clock difference tolerated when checking token times
+	clockSkew = 5 * time.Minute
+
+	// ownerPrefix marks job owners that are Firebase users rather than API keys
+	ownerPrefix = "firebase:"
+)
+
+// ErrInvalidToken is returned when an ID token is malformed, expired or not signed by Firebase
+var ErrInvalidToken = errors.New("invalid ID token")
+
+var maxAgePattern = regexp.MustCompile(`max-age=(\d+)`)
+
+// User is a signed-in Firebase user
+type User struct {
+	UID   string `json:"uid"`
+	Email string `json:"email,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+// OwnerID returns the job owner ID of the user
+func (u *User) OwnerID() string {
+	return ownerPrefix + u.UID
+}
+
+// IsUserOwner reports whether a job owner ID is a user rather than an API key
+func IsUserOwner(owner string) bool {
+	return strings.HasPrefix(owner, ownerPrefix)
+}
+
+// claims are the Firebase ID token claims the verifier reads
+type claims struct {
+	Issuer   string `json:"iss"`
+	Audience string `json:"aud"`
+	Subject  string `json:"sub"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+	AuthTime int64  `json:"auth_time"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+}
+
+// TokenVerifier verifies Firebase ID tokens, such as those issued by Google sign-in
+type TokenVerifier struct {
+	projectID  string
+	certsURL   string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+}
+
+// NewTokenVerifier creates a verifier for the ID tokens of a Firebase project
+func NewTokenVerifier(projectID string) *TokenVerifier {
+	return &TokenVerifier{
+		projectID:  projectID,
+		certsURL:   firebaseCertsURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Verify checks the signature and claims of an ID token and returns its user
+func (v *TokenVerifier) Verify(ctx context.Context, token string) (*User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Algorithm != "RS256" {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := v.publicKey(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := v.checkClaims(c, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &User{UID: c.Subject, Email: c.Email, Name: c.Name}, nil
+}
+
+// checkClaims checks that the token was issued for the project and is current
+func (v *TokenVerifier) checkClaims(c claims, now time.Time) error {
+	switch {
+	case c.Audience != v.projectID:
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	case c.Issuer != "https://securetoken.google.com/"+v.projectID:
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	case c.Subject == "" || len(c.Subject) > 128:
+		return fmt.Errorf("%w: invalid subject", ErrInvalidToken)
+	case now.After(time.Unix(c.Expires, 0).Add(clockSkew)):
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	case now.Add(clockSkew).Before(time.Unix(c.IssuedAt, 0)) || now.Add(clockSkew).Before(time.Unix(c.AuthTime, 0)):
+		return fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+	return nil
+}
+
+// publicKey returns the signing key with the given ID, refreshing the cached
+// keys when they expire
+func (v *TokenVerifier) publicKey(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keys == nil || time.Now().After(v.expiresAt) {
+		keys, expiresAt, err := v.fetchKeys(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch Firebase signing keys: %v", err)
+		}
+		v.keys, v.expiresAt = keys, expiresAt
+	}
+
+	key, ok := v.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key", ErrInvalidToken)
+	}
+	return key, nil
+}
+
+// fetchKeys downloads the signing certificates and how long they may be cached
+func (v *TokenVerifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.certsURL, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	certs := make(map[string]string)
+	if err := json.Unmarshal(body, &certs); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(certs))
+	for keyID, certPEM := range certs {
+		block, _ := pem.Decode([]byte(certPEM))
+		if block == nil {
+			return nil, time.Time{}, fmt.Errorf("invalid certificate %s", keyID)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid certificate %s: %v", keyID, err)
+		}
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, time.Time{}, fmt.Errorf("certificate %s has no RSA key", keyID)
+		}
+		keys[keyID] = key
+	}
+
+	maxAge := time.Hour
+	if match := maxAgePattern.FindStringSubmatch(resp.Header.Get("Cache-Control")); match != nil {
+		if seconds, err := strconv.Atoi(match[1]); err == nil {
+			maxAge = time.Duration(seconds) * time.Second
+		}
+	}
+	return keys, time.Now().Add(maxAge), nil
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
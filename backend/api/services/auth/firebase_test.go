@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestVerifier returns a verifier for project slideitin whose signing key
+// with ID key-1 is served by a test server
+func newTestVerifier(t *testing.T) (*TokenVerifier, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "securetoken"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		json.NewEncoder(w).Encode(map[string]string{"key-1": string(certPEM)})
+	}))
+	t.Cleanup(server.Close)
+
+	verifier := NewTokenVerifier("slideitin")
+	verifier.certsURL = server.URL
+	return verifier, key
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, keyID string, c claims) string {
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := encode(map[string]string{"alg": "RS256", "kid": keyID}) + "." + encode(c)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() claims {
+	now := time.Now().Unix()
+	return claims{
+		Issuer:   "https://securetoken.google.com/slideitin",
+		Audience: "slideitin",
+		Subject:  "user-1",
+		IssuedAt: now,
+		AuthTime: now,
+		Expires:  now + 3600,
+		Email:    "ada@example.com",
+	}
+}
+
+func TestVerifyAcceptsValidToken(t *testing.T) {
+	verifier, key := newTestVerifier(t)
+
+	user, err := verifier.Verify(context.Background(), signToken(t, key, "key-1", validClaims()))
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if user.UID != "user-1" || user.Email != "ada@example.com" || user.OwnerID() != "firebase:user-1" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	verifier, key := newTestVerifier(t)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	expired := validClaims()
+	expired.Expires = time.Now().Add(-time.Hour).Unix()
+	wrongAudience := validClaims()
+	wrongAudience.Audience = "other-project"
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "https://accounts.google.com"
+	noSubject := validClaims()
+	noSubject.Subject = ""
+
+	tests := map[string]string{
+		"expired":        signToken(t, key, "key-1", expired),
+		"wrong audience": signToken(t, key, "key-1", wrongAudience),
+		"wrong issuer":   signToken(t, key, "key-1", wrongIssuer),
+		"no subject":     signToken(t, key, "key-1", noSubject),
+		"unknown key":    signToken(t, key, "key-2", validClaims()),
+		"wrong key":      signToken(t, otherKey, "key-1", validClaims()),
+		"malformed":      "not-a-token",
+	}
+	for name, token := range tests {
+		if _, err := verifier.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
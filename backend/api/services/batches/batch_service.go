@@ -0,0 +1,82 @@
+package batches
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LabelKey is the label of the jobs of a batch, set to its ID so they can be
+// listed together
+const LabelKey = "batch"
+
+// ErrBatchNotFound is returned when a batch doesn't exist or belongs to
+// another owner
+var ErrBatchNotFound = errors.New("batch not found")
+
+// Batch is a long document split into a deck per chapter, with an overview
+// deck of the whole document introducing them
+type Batch struct {
+	ID            string    `json:"id" firestore:"-"`
+	Owner         string    `json:"-" firestore:"owner"`
+	WorkspaceID   string    `json:"workspaceId,omitempty" firestore:"workspaceId,omitempty"`
+	OverviewJobID string    `json:"overviewJobId" firestore:"overviewJobId"`
+	Chapters      []Chapter `json:"chapters" firestore:"chapters"`
+	CreatedAt     int64     `json:"createdAt" firestore:"createdAt"`
+}
+
+// Chapter is a chapter of a batch and the job writing its deck
+type Chapter struct {
+	Title string `json:"title" firestore:"title"`
+	JobID string `json:"jobId" firestore:"jobId"`
+}
+
+// Service stores batches in Firestore
+type Service struct {
+	client *firestore.Client
+}
+
+// NewService creates a new batch service
+func NewService(client *firestore.Client) *Service {
+	return &Service{
+		client: client,
+	}
+}
+
+// Collection returns the Firestore collection reference for batches
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("batches")
+}
+
+// Create stores a batch whose jobs have been created
+func (s *Service) Create(ctx context.Context, batch *Batch) error {
+	if _, err := s.Collection().Doc(batch.ID).Set(ctx, batch); err != nil {
+		return fmt.Errorf("error saving batch: %v", err)
+	}
+	return nil
+}
+
+// Get returns a batch of an owner
+func (s *Service) Get(ctx context.Context, owner, id string) (*Batch, error) {
+	doc, err := s.Collection().Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrBatchNotFound
+		}
+		return nil, fmt.Errorf("error retrieving batch: %v", err)
+	}
+
+	var batch Batch
+	if err := doc.DataTo(&batch); err != nil {
+		return nil, fmt.Errorf("error parsing batch data: %v", err)
+	}
+	if batch.Owner != owner {
+		return nil, ErrBatchNotFound
+	}
+	batch.ID = doc.Ref.ID
+	return &batch, nil
+}
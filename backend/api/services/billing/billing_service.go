@@ -0,0 +1,365 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrUnknownPlan is returned when checking out a plan that doesn't exist or has no price
+var ErrUnknownPlan = errors.New("unknown plan")
+
+// paidStatuses are the Stripe subscription statuses that grant the paid plan.
+// Past due subscriptions keep their plan while Stripe retries the payment.
+var paidStatuses = map[string]bool{
+	"active":   true,
+	"trialing": true,
+	"past_due": true,
+}
+
+// FirestoreSubscription is the Firestore representation of the subscription of an API key.
+// Documents are keyed by the API key ID.
+type FirestoreSubscription struct {
+	PlanID               string `firestore:"planId"`
+	Status               string `firestore:"status"`
+	StripeCustomerID     string `firestore:"stripeCustomerId"`
+	StripeSubscriptionID string `firestore:"stripeSubscriptionId"`
+	EventCreatedAt       int64  `firestore:"eventCreatedAt"` // Creation time of the last applied webhook event
+	UpdatedAt            int64  `firestore:"updatedAt"`
+}
+
+// FirestoreUsage is the Firestore representation of the usage of an API key in one month.
+// Documents are keyed by the API key ID and the month.
+type FirestoreUsage struct {
+	Period string `firestore:"period"`
+	Jobs   int    `firestore:"jobs"`
+	Tokens int    `firestore:"tokens"`
+}
+
+// Usage is the usage of an API key in the current month
+type Usage struct {
+	Plan     Plan   `json:"plan"`
+	Period   string `json:"period"`
+	Jobs     int    `json:"jobs"`
+	Tokens   int    `json:"tokens"`
+	ResetsAt int64  `json:"resetsAt"`
+}
+
+// Config holds the Stripe settings of the billing service
+type Config struct {
+	SecretKey     string                        // Stripe secret API key, empty to disable billing
+	WebhookSecret string                        // Signing secret of the Stripe webhook endpoint
+	PriceIDs      map[string]string             // Stripe price ID of each paid plan, by plan ID
+	ReturnURL     string                        // Page Stripe Checkout returns to
+	TokenLimits   map[string]models.TokenLimits // Overrides of the slides service's token limits, by plan ID
+}
+
+// Service assigns plans to API keys from Stripe subscriptions and enforces their allowances
+type Service struct {
+	client *firestore.Client
+	config Config
+	stripe *stripeClient
+}
+
+// NewService creates a new billing service
+func NewService(client *firestore.Client, config Config) *Service {
+	return &Service{
+		client: client,
+		config: config,
+		stripe: &stripeClient{
+			secretKey:  config.SecretKey,
+			baseURL:    stripeAPIURL,
+			httpClient: &http.Client{Timeout: 30 * time.Second},
+		},
+	}
+}
+
+// Enabled reports whether billing is configured
+func (s *Service) Enabled() bool {
+	return s.config.SecretKey != ""
+}
+
+// SubscriptionsCollection returns the Firestore collection reference for subscriptions
+func (s *Service) SubscriptionsCollection() *firestore.CollectionRef {
+	return s.client.Collection("subscriptions")
+}
+
+// UsageCollection returns the Firestore collection reference for monthly usage
+func (s *Service) UsageCollection() *firestore.CollectionRef {
+	return s.client.Collection("billingUsage")
+}
+
+// PlanFor returns the plan of an API key with the token limits the deployment
+// sets for it. Anonymous requests, with an empty API key ID, and API keys
+// without a paid subscription are on the free plan.
+func (s *Service) PlanFor(ctx context.Context, apiKeyID string) (Plan, error) {
+	plan, err := s.subscribedPlan(ctx, apiKeyID)
+	if err != nil {
+		return Plan{}, err
+	}
+	if limits, ok := s.config.TokenLimits[plan.ID]; ok {
+		plan.TokenLimits = limits
+	}
+	return plan, nil
+}
+
+// subscribedPlan returns the plan of an API key from its subscription
+func (s *Service) subscribedPlan(ctx context.Context, apiKeyID string) (Plan, error) {
+	if !s.Enabled() {
+		return UnlimitedPlan, nil
+	}
+	if apiKeyID == "" {
+		return FreePlan, nil
+	}
+
+	doc, err := s.SubscriptionsCollection().Doc(apiKeyID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return FreePlan, nil
+		}
+		return Plan{}, fmt.Errorf("error retrieving subscription: %v", err)
+	}
+
+	var subscription FirestoreSubscription
+	if err := doc.DataTo(&subscription); err != nil {
+		return Plan{}, fmt.Errorf("error parsing subscription data: %v", err)
+	}
+	if plan, ok := paidPlans[subscription.PlanID]; ok && paidStatuses[subscription.Status] {
+		return plan, nil
+	}
+	return FreePlan, nil
+}
+
+// Consume counts a job and its estimated tokens against the monthly allowance
+// of an API key. It returns a *LimitError when the allowance is used up.
+func (s *Service) Consume(ctx context.Context, apiKeyID string, plan Plan, tokens int) error {
+	return s.ConsumeJobs(ctx, apiKeyID, plan, 1, tokens)
+}
+
+// ConsumeJobs counts jobs created together and their estimated tokens against
+// the monthly allowance of an API key, all of them or none
+func (s *Service) ConsumeJobs(ctx context.Context, apiKeyID string, plan Plan, jobs, tokens int) error {
+	if !s.Enabled() || apiKeyID == "" || plan.MonthlyJobs == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	period := now.Format("2006-01")
+	ref := s.UsageCollection().Doc(apiKeyID + "-" + period)
+
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		usage := FirestoreUsage{Period: period}
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&usage); err != nil {
+				return err
+			}
+		}
+		if limitErr := checkAllowance(plan, usage, jobs, tokens, nextPeriod(now)); limitErr != nil {
+			return limitErr
+		}
+		usage.Jobs += jobs
+		usage.Tokens += tokens
+		return tx.Set(ref, usage)
+	})
+	if err != nil {
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			return limitErr
+		}
+		return fmt.Errorf("failed to update usage: %v", err)
+	}
+	return nil
+}
+
+// Refund gives back jobs and their estimated tokens counted by ConsumeJobs
+// for jobs that weren't created, such as when enqueueing failed or a retried
+// request got the job of its first attempt. Failures are only logged.
+func (s *Service) Refund(ctx context.Context, apiKeyID string, plan Plan, jobs, tokens int) {
+	if !s.Enabled() || apiKeyID == "" || plan.MonthlyJobs == 0 {
+		return
+	}
+
+	period := time.Now().UTC().Format("2006-01")
+	ref := s.UsageCollection().Doc(apiKeyID + "-" + period)
+
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var usage FirestoreUsage
+		if err := doc.DataTo(&usage); err != nil {
+			return err
+		}
+		usage.Jobs = max(usage.Jobs-jobs, 0)
+		usage.Tokens = max(usage.Tokens-tokens, 0)
+		return tx.Set(ref, usage)
+	})
+	if err != nil {
+		log.Printf("Failed to refund usage of API key %s: %v", apiKeyID, err)
+	}
+}
+
+// GetUsage returns the plan and usage of an API key in the current month
+func (s *Service) GetUsage(ctx context.Context, apiKeyID string) (*Usage, error) {
+	plan, err := s.PlanFor(ctx, apiKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	usage := &Usage{
+		Plan:     plan,
+		Period:   now.Format("2006-01"),
+		ResetsAt: nextPeriod(now).Unix(),
+	}
+	if !s.Enabled() {
+		return usage, nil
+	}
+
+	doc, err := s.UsageCollection().Doc(apiKeyID + "-" + usage.Period).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return usage, nil
+		}
+		return nil, fmt.Errorf("error retrieving usage: %v", err)
+	}
+	var stored FirestoreUsage
+	if err := doc.DataTo(&stored); err != nil {
+		return nil, fmt.Errorf("error parsing usage data: %v", err)
+	}
+	usage.Jobs = stored.Jobs
+	usage.Tokens = stored.Tokens
+	return usage, nil
+}
+
+// CreateCheckout starts a Stripe Checkout for a paid plan and returns its URL
+func (s *Service) CreateCheckout(ctx context.Context, apiKeyID, planID string) (string, error) {
+	priceID := s.config.PriceIDs[planID]
+	if _, ok := paidPlans[planID]; !ok || priceID == "" {
+		return "", ErrUnknownPlan
+	}
+	return s.stripe.createCheckoutSession(ctx, apiKeyID, priceID,
+		s.config.ReturnURL+"?checkout=success", s.config.ReturnURL+"?checkout=canceled")
+}
+
+// HandleWebhook verifies a Stripe webhook and applies subscription changes
+// to the API key stored in the subscription metadata
+func (s *Service) HandleWebhook(ctx context.Context, payload []byte, signature string) error {
+	if err := verifySignature(payload, signature, s.config.WebhookSecret, time.Now()); err != nil {
+		return err
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("failed to parse event: %v", err)
+	}
+
+	switch event.Type {
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+	default:
+		log.Printf("Ignoring Stripe event %s of type %s", event.ID, event.Type)
+		return nil
+	}
+
+	var subscription stripeSubscription
+	if err := json.Unmarshal(event.Data.Object, &subscription); err != nil {
+		return fmt.Errorf("failed to parse subscription: %v", err)
+	}
+	apiKeyID := subscription.Metadata["apiKeyId"]
+	if apiKeyID == "" {
+		log.Printf("Ignoring Stripe subscription %s without an API key", subscription.ID)
+		return nil
+	}
+
+	update := FirestoreSubscription{
+		PlanID:               s.planForSubscription(subscription),
+		Status:               subscription.Status,
+		StripeCustomerID:     subscription.Customer,
+		StripeSubscriptionID: subscription.ID,
+		EventCreatedAt:       event.Created,
+		UpdatedAt:            time.Now().Unix(),
+	}
+	if event.Type == "customer.subscription.deleted" {
+		update.Status = "canceled"
+	}
+
+	// Stripe doesn't guarantee the order of events, so older events are skipped
+	ref := s.SubscriptionsCollection().Doc(apiKeyID)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var current FirestoreSubscription
+			if err := doc.DataTo(&current); err != nil {
+				return err
+			}
+			if current.EventCreatedAt > update.EventCreatedAt {
+				log.Printf("Skipping outdated Stripe event %s for API key %s", event.ID, apiKeyID)
+				return nil
+			}
+		}
+		log.Printf("Subscription of API key %s is now %s on plan %s", apiKeyID, update.Status, update.PlanID)
+		return tx.Set(ref, update)
+	})
+}
+
+// planForSubscription returns the ID of the plan whose price is on a subscription
+func (s *Service) planForSubscription(subscription stripeSubscription) string {
+	for _, item := range subscription.Items.Data {
+		for planID, priceID := range s.config.PriceIDs {
+			if item.Price.ID == priceID {
+				return planID
+			}
+		}
+	}
+	return FreePlan.ID
+}
+
+// checkAllowance returns a *LimitError when jobs of the given size would
+// exceed the monthly allowance of a plan
+func checkAllowance(plan Plan, usage FirestoreUsage, jobs, tokens int, resetsAt time.Time) *LimitError {
+	if usage.Jobs >= plan.MonthlyJobs {
+		return &LimitError{
+			Plan:    plan.ID,
+			Message: fmt.Sprintf("The %s plan allows %d jobs per month, resets at %s. Upgrade your plan for more.", plan.Name, plan.MonthlyJobs, resetsAt.Format(time.RFC3339)),
+		}
+	}
+	if usage.Jobs+jobs > plan.MonthlyJobs {
+		return &LimitError{
+			Plan:    plan.ID,
+			Message: fmt.Sprintf("These %d jobs would exceed the %d jobs per month allowed by the %s plan, resets at %s. Upgrade your plan for more.", jobs, plan.MonthlyJobs, plan.Name, resetsAt.Format(time.RFC3339)),
+		}
+	}
+	if usage.Tokens+tokens > plan.MonthlyTokens {
+		return &LimitError{
+			Plan:    plan.ID,
+			Message: fmt.Sprintf("This job would exceed the %d tokens per month allowed by the %s plan, resets at %s. Upgrade your plan for more.", plan.MonthlyTokens, plan.Name, resetsAt.Format(time.RFC3339)),
+		}
+	}
+	return nil
+}
+
+// nextPeriod returns the start of the next month in UTC, when usage resets
+func nextPeriod(now time.Time) time.Time {
+	year, month, _ := now.UTC().Date()
+	return time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
+}
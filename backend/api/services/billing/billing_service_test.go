@@ -0,0 +1,134 @@
+package billing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+func sign(payload []byte, secret string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"customer.subscription.updated"}`)
+	now := time.Unix(1700000000, 0)
+
+	if err := verifySignature(payload, sign(payload, "whsec_test", now.Unix()), "whsec_test", now); err != nil {
+		t.Fatalf("expected a valid signature, got %v", err)
+	}
+
+	tests := map[string]string{
+		"wrong secret":     sign(payload, "whsec_other", now.Unix()),
+		"replayed event":   sign(payload, "whsec_test", now.Add(-10*time.Minute).Unix()),
+		"missing header":   "",
+		"missing v1":       fmt.Sprintf("t=%d", now.Unix()),
+		"tampered payload": sign([]byte(`{"id":"evt_2"}`), "whsec_test", now.Unix()),
+	}
+	for name, header := range tests {
+		if err := verifySignature(payload, header, "whsec_test", now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", name, err)
+		}
+	}
+}
+
+func TestFreePlanCheck(t *testing.T) {
+	settings := models.SlideSettings{}
+	if err := FreePlan.Check(&settings, nil); err != nil {
+		t.Fatalf("expected the default settings to be allowed, got %v", err)
+	}
+	if settings.SlideDetail != "minimal" {
+		t.Fatalf("expected the slide detail to default to minimal, got %q", settings.SlideDetail)
+	}
+
+	settings = models.SlideSettings{SlideDetail: "detailed"}
+	if err := FreePlan.Check(&settings, nil); err == nil {
+		t.Fatal("expected detailed slides to be rejected on the free plan")
+	}
+	if err := ProPlan.Check(&settings, nil); err != nil {
+		t.Fatalf("expected detailed slides on the pro plan, got %v", err)
+	}
+
+	files := []models.File{{Filename: "big.pdf", Data: make([]byte, FreePlan.MaxFileBytes+1)}}
+	settings = models.SlideSettings{}
+	var limitErr *LimitError
+	if err := FreePlan.Check(&settings, files); !errors.As(err, &limitErr) || !strings.Contains(limitErr.Message, "big.pdf") {
+		t.Fatalf("expected a limit error for the large file, got %v", err)
+	}
+}
+
+func TestCheckAllowance(t *testing.T) {
+	resetsAt := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	if err := checkAllowance(FreePlan, FirestoreUsage{Jobs: 19}, 1, 1000, resetsAt); err != nil {
+		t.Fatalf("expected the last job of the month to be allowed, got %v", err)
+	}
+	if err := checkAllowance(FreePlan, FirestoreUsage{Jobs: 20}, 1, 1000, resetsAt); err == nil {
+		t.Fatal("expected the job allowance to be enforced")
+	}
+	if err := checkAllowance(FreePlan, FirestoreUsage{Jobs: 17}, 4, 1000, resetsAt); err == nil || !strings.Contains(err.Message, "These 4 jobs") {
+		t.Fatalf("expected the job allowance to be enforced for all the jobs of a batch, got %v", err)
+	}
+	if err := checkAllowance(FreePlan, FirestoreUsage{Tokens: FreePlan.MonthlyTokens - 10}, 1, 11, resetsAt); err == nil {
+		t.Fatal("expected the token allowance to be enforced")
+	}
+}
+
+func TestPlanForAppliesTokenLimits(t *testing.T) {
+	s := NewService(nil, Config{TokenLimits: map[string]models.TokenLimits{"unlimited": {Input: 1000000}}})
+	plan, err := s.PlanFor(context.Background(), "")
+	if err != nil {
+		t.Fatalf("PlanFor failed: %v", err)
+	}
+	if plan.ID != UnlimitedPlan.ID || plan.TokenLimits.Input != 1000000 || plan.TokenLimits.Output != 0 {
+		t.Fatalf("expected the unlimited plan with its token limits, got %+v", plan)
+	}
+	if UnlimitedPlan.TokenLimits.Input != 0 {
+		t.Fatal("expected the plan defaults to be left unchanged")
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	files := []models.File{
+		{Type: "text/plain", Data: make([]byte, 400)},
+		{Type: "application/pdf", Data: make([]byte, 1600)},
+	}
+	if tokens := EstimateTokens(files); tokens != 200 {
+		t.Fatalf("expected 200 tokens, got %d", tokens)
+	}
+}
+
+func TestCreateCheckoutSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "sk_test" {
+			t.Errorf("expected the secret key as basic auth user, got %q", user)
+		}
+		r.ParseForm()
+		if r.Form.Get("subscription_data[metadata][apiKeyId]") != "key-1" || r.Form.Get("line_items[0][price]") != "price_pro" {
+			t.Errorf("unexpected checkout parameters: %v", r.Form)
+		}
+		w.Write([]byte(`{"url": "https://checkout.stripe.com/c/pay/cs_test"}`))
+	}))
+	defer server.Close()
+
+	client := &stripeClient{secretKey: "sk_test", baseURL: server.URL, httpClient: server.Client()}
+	url, err := client.createCheckoutSession(context.Background(), "key-1", "price_pro", "https://example.com/ok", "https://example.com/cancel")
+	if err != nil {
+		t.Fatalf("createCheckoutSession failed: %v", err)
+	}
+	if url != "https://checkout.stripe.com/c/pay/cs_test" {
+		t.Fatalf("unexpected checkout URL %q", url)
+	}
+}
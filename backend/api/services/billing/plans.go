@@ -0,0 +1,145 @@
+package billing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+const (
+	// textBytesPerToken is the rough number of bytes of plain text per Gemini token
+	textBytesPerToken = 4
+
+	// pdfBytesPerToken is higher since most of a PDF is layout and compressed streams
+	pdfBytesPerToken = 16
+)
+
+// Plan is a billing tier with its monthly allowances and limits
+type Plan struct {
+	ID            string             `json:"id"`
+	Name          string             `json:"name"`
+	MonthlyJobs   int                `json:"monthlyJobs"`
+	MonthlyTokens int                `json:"monthlyTokens"`
+	MaxFileBytes  int                `json:"maxFileBytes"`
+	SlideDetails  []string           `json:"slideDetails"`
+	TokenLimits   models.TokenLimits `json:"tokenLimits"` // Set from the deployment's overrides, zero keeps the slides service's limits
+}
+
+var (
+	// FreePlan applies to anonymous requests and API keys without a subscription
+	FreePlan = Plan{
+		ID:            "free",
+		Name:          "Free",
+		MonthlyJobs:   20,
+		MonthlyTokens: 200_000,
+		MaxFileBytes:  2 << 20,
+		SlideDetails:  []string{"minimal"},
+	}
+
+	// ProPlan is the individual subscription
+	ProPlan = Plan{
+		ID:            "pro",
+		Name:          "Pro",
+		MonthlyJobs:   300,
+		MonthlyTokens: 5_000_000,
+		MaxFileBytes:  10 << 20,
+		SlideDetails:  models.ValidSlideDetails,
+	}
+
+	// TeamPlan is the subscription for teams sharing an API key
+	TeamPlan = Plan{
+		ID:            "team",
+		Name:          "Team",
+		MonthlyJobs:   2000,
+		MonthlyTokens: 40_000_000,
+		MaxFileBytes:  10 << 20,
+		SlideDetails:  models.ValidSlideDetails,
+	}
+
+	// UnlimitedPlan applies when billing is disabled, e.g. on self-hosted instances
+	UnlimitedPlan = Plan{
+		ID:           "unlimited",
+		Name:         "Unlimited",
+		MaxFileBytes: 10 << 20,
+		SlideDetails: models.ValidSlideDetails,
+	}
+
+	// paidPlans are the plans that can be bought, by ID
+	paidPlans = map[string]Plan{
+		ProPlan.ID:  ProPlan,
+		TeamPlan.ID: TeamPlan,
+	}
+)
+
+// LimitError is returned when a request is not allowed by the plan of the caller
+type LimitError struct {
+	Plan         string
+	Message      string
+	FileTooLarge bool // A file is over the size limit of the plan
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// Check verifies that the settings and files of a request are allowed by the
+// plan. An empty slide detail is set to the most detailed level of the plan.
+func (p Plan) Check(settings *models.SlideSettings, files []models.File) error {
+	if settings.SlideDetail == "" && len(p.SlideDetails) < len(models.ValidSlideDetails) {
+		settings.SlideDetail = p.SlideDetails[len(p.SlideDetails)-1]
+	}
+	if settings.SlideDetail != "" && !contains(p.SlideDetails, settings.SlideDetail) {
+		return &LimitError{
+			Plan:    p.ID,
+			Message: fmt.Sprintf("The %s plan only allows slideDetail %s. Upgrade your plan for more detailed slides.", p.Name, strings.Join(p.SlideDetails, ", ")),
+		}
+	}
+	for _, file := range files {
+		if err := p.CheckSize(file.Filename, len(file.Data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckSize verifies that a file isn't larger than the plan allows, for files
+// stored before the request such as library documents
+func (p Plan) CheckSize(filename string, size int) error {
+	if size > p.MaxFileBytes {
+		return &LimitError{
+			Plan:         p.ID,
+			Message:      fmt.Sprintf("File %s is larger than the %d MB allowed by the %s plan", filename, p.MaxFileBytes>>20, p.Name),
+			FileTooLarge: true,
+		}
+	}
+	return nil
+}
+
+// EstimateTokens roughly estimates the Gemini input tokens of the source files
+func EstimateTokens(files []models.File) int {
+	tokens := 0
+	for _, file := range files {
+		tokens += EstimateFileTokens(file.Type, len(file.Data))
+	}
+	return tokens
+}
+
+// EstimateFileTokens roughly estimates the Gemini input tokens of a file of a
+// type and size
+func EstimateFileTokens(fileType string, size int) int {
+	if fileType == "application/pdf" {
+		return size / pdfBytesPerToken
+	}
+	return size / textBytesPerToken
+}
+
+// contains reports whether a slice contains a value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package billing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// stripeAPIURL is the base URL of the Stripe API
+	stripeAPIURL = "https://api.stripe.com/v1"
+
+	// webhookTolerance is how old a signed webhook may be before it is rejected as a replay
+	webhookTolerance = 5 * time.Minute
+)
+
+// ErrInvalidSignature is returned when a webhook isn't signed with the webhook secret
+var ErrInvalidSignature = errors.New("invalid Stripe signature")
+
+// stripeEvent is the part of a Stripe webhook event the billing service reads
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// stripeSubscription is the part of a Stripe subscription the billing service reads
+type stripeSubscription struct {
+	ID       string            `json:"id"`
+	Customer string            `json:"customer"`
+	Status   string            `json:"status"`
+	Metadata map[string]string `json:"metadata"`
+	Items    struct {
+		Data []struct {
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// verifySignature checks the Stripe-Signature header of a webhook payload,
+// which holds a timestamp and one or more HMAC-SHA256 signatures
+func verifySignature(payload []byte, header, secret string, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		decoded, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// stripeClient calls the Stripe REST API
+type stripeClient struct {
+	secretKey  string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// createCheckoutSession creates a subscription checkout session for a price
+// and returns its URL. The API key ID is stored on the subscription so
+// webhooks can be matched to the API key.
+func (c *stripeClient) createCheckoutSession(ctx context.Context, apiKeyID, priceID, successURL, cancelURL string) (string, error) {
+	form := url.Values{
+		"mode":                                  {"subscription"},
+		"line_items[0][price]":                  {priceID},
+		"line_items[0][quantity]":               {"1"},
+		"client_reference_id":                   {apiKeyID},
+		"subscription_data[metadata][apiKeyId]": {apiKeyID},
+		"success_url":                           {successURL},
+		"cancel_url":                            {cancelURL},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.secretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call Stripe: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read Stripe response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Stripe returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var session struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(body, &session); err != nil {
+		return "", fmt.Errorf("failed to parse Stripe response: %v", err)
+	}
+	return session.URL, nil
+}
@@ -0,0 +1,133 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultThreshold is how many calls in a row have to fail with an outage
+	// error before a breaker opens
+	DefaultThreshold = 5
+
+	// DefaultCooldown is how long a breaker stays open before one call is let
+	// through to probe whether the dependency recovered
+	DefaultCooldown = 30 * time.Second
+)
+
+// OpenError is returned instead of calling a dependency while its breaker is open
+type OpenError struct {
+	Dependency string
+	RetryAt    time.Time // When the dependency is tried again
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%s is temporarily unavailable", e.Dependency)
+}
+
+// RetryAfter returns how long clients should wait before trying again, at
+// least a second
+func (e *OpenError) RetryAfter() time.Duration {
+	wait := time.Until(e.RetryAt).Round(time.Second)
+	if wait < time.Second {
+		return time.Second
+	}
+	return wait
+}
+
+// Breaker is a circuit breaker around a dependency. It opens after threshold
+// outage errors in a row, fails calls fast while open, and lets one call
+// through once the cooldown ends: the breaker closes if it succeeds and opens
+// again if it fails.
+type Breaker struct {
+	dependency string
+	threshold  int
+	cooldown   time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool // A call is probing the dependency after the cooldown
+}
+
+// New creates a new breaker for the named dependency
+func New(dependency string, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		dependency: dependency,
+		threshold:  threshold,
+		cooldown:   cooldown,
+	}
+}
+
+// Allow returns an *OpenError while the breaker is open, or while another call
+// is probing the dependency. Every call allowed has to be followed by Record.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return &OpenError{Dependency: b.dependency, RetryAt: b.openUntil}
+	}
+	b.probing = true
+	return nil
+}
+
+// Record counts the outcome of a call let through by Allow. A call cancelled
+// or timed out by its own request says nothing about the dependency.
+func (b *Breaker) Record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ctx.Err() != nil {
+		return
+	}
+	if !IsOutage(err) {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.failures++
+	if !b.openUntil.IsZero() || b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// Available returns an *OpenError while the breaker is open, without using up
+// the call that probes whether the dependency recovered
+func (b *Breaker) Available(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.openUntil.IsZero() && time.Now().Before(b.openUntil) {
+		return &OpenError{Dependency: b.dependency, RetryAt: b.openUntil}
+	}
+	return nil
+}
+
+// IsOutage reports whether an error means a Google Cloud dependency is down or
+// overloaded, rather than a problem with the call such as a missing document
+func IsOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= 500 || apiErr.Code == 429
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBreakerOpensAfterOutagesAndProbes(t *testing.T) {
+	b := New("Firestore", 3, time.Hour)
+	ctx := context.Background()
+	outage := status.Error(codes.Unavailable, "unavailable")
+
+	// Errors of the call itself don't count toward opening the breaker
+	b.Record(ctx, status.Error(codes.NotFound, "no such job"))
+	for i := 0; i < 2; i++ {
+		b.Record(ctx, outage)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected the breaker to stay closed below the threshold, got %v", err)
+	}
+	b.Record(ctx, outage)
+	var openErr *OpenError
+	if err := b.Allow(); !errors.As(err, &openErr) || openErr.Dependency != "Firestore" {
+		t.Fatalf("expected the breaker to open at the threshold, got %v", err)
+	}
+	if err := b.Available(ctx); err == nil {
+		t.Fatal("expected the breaker to report the dependency unavailable")
+	}
+
+	// Once the cooldown ends one call probes the dependency while the others still fail fast
+	b.mu.Lock()
+	b.openUntil = time.Now().Add(-time.Second)
+	b.mu.Unlock()
+	if err := b.Available(ctx); err != nil {
+		t.Fatalf("expected the dependency to be tried again after the cooldown, got %v", err)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a probe after the cooldown, got %v", err)
+	}
+	if err := b.Allow(); err == nil {
+		t.Fatal("expected other calls to fail during the probe")
+	}
+
+	// A probe cancelled by its request lets the next call probe instead
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	b.Record(cancelled, context.Canceled)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a new probe after a cancelled one, got %v", err)
+	}
+	b.Record(ctx, nil)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a successful probe to close the breaker, got %v", err)
+	}
+}
+
+func TestIsOutage(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("invalid job"), false},
+		{status.Error(codes.NotFound, "not found"), false},
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{fmt.Errorf("error creating job: %w", status.Error(codes.DeadlineExceeded, "deadline")), true},
+		{&googleapi.Error{Code: 404}, false},
+		{&googleapi.Error{Code: 503}, true},
+		{&googleapi.Error{Code: 429}, true},
+		{fmt.Errorf("upload: %w", context.DeadlineExceeded), true},
+	}
+	for _, tt := range tests {
+		if got := IsOutage(tt.err); got != tt.want {
+			t.Errorf("IsOutage(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package breaker
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// remoteTTL is how long the state read from Firestore is used before it is
+// read again
+const remoteTTL = 15 * time.Second
+
+// FirestoreDependency is the Firestore representation of the breaker of a
+// dependency the slides service calls, shared with the API
+type FirestoreDependency struct {
+	OpenUntil int64 `firestore:"openUntil"` // End of the cooldown of the open breaker, 0 when the dependency is up
+	UpdatedAt int64 `firestore:"updatedAt"`
+}
+
+// Remote reads the breaker of a dependency only the slides service calls, such
+// as Gemini, from the state it stores in Firestore
+type Remote struct {
+	client     *firestore.Client
+	name       string // ID of the document in the dependencies collection
+	dependency string // Name shown to clients
+
+	mu        sync.Mutex
+	openUntil time.Time
+	fetchedAt time.Time
+}
+
+// NewRemote creates a new reader of the breaker stored as dependencies/<name>
+func NewRemote(client *firestore.Client, name, dependency string) *Remote {
+	return &Remote{
+		client:     client,
+		name:       name,
+		dependency: dependency,
+	}
+}
+
+// Available returns an *OpenError while the breaker of the dependency is open.
+// The last state read is used when Firestore can't be read.
+func (r *Remote) Available(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fetchedAt.IsZero() || time.Since(r.fetchedAt) >= remoteTTL {
+		// A failed read is not retried before the TTL either, so requests
+		// don't all wait on Firestore while it is down
+		openUntil, err := r.read(ctx)
+		if err != nil {
+			log.Printf("Warning: Failed to read the %s breaker, using the last state read: %v", r.dependency, err)
+		} else {
+			r.openUntil = openUntil
+		}
+		r.fetchedAt = time.Now()
+	}
+
+	if time.Now().Before(r.openUntil) {
+		return &OpenError{Dependency: r.dependency, RetryAt: r.openUntil}
+	}
+	return nil
+}
+
+// read reads the end of the cooldown of the breaker from Firestore
+func (r *Remote) read(ctx context.Context) (time.Time, error) {
+	doc, err := r.client.Collection("dependencies").Doc(r.name).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	var state FirestoreDependency
+	if err := doc.DataTo(&state); err != nil {
+		return time.Time{}, err
+	}
+	if state.OpenUntil == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(state.OpenUntil, 0), nil
+}
@@ -0,0 +1,114 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Providers whose tokens can be verified
+const (
+	ProviderTurnstile = "turnstile" // Cloudflare Turnstile
+	ProviderHCaptcha  = "hcaptcha"
+)
+
+// verifyURLs are the siteverify endpoints of the providers, which take the
+// same form and answer alike
+var verifyURLs = map[string]string{
+	ProviderTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	ProviderHCaptcha:  "https://api.hcaptcha.com/siteverify",
+}
+
+// maxTokenLength is the longest token sent to the provider, Turnstile tokens
+// are at most 2048 characters
+const maxTokenLength = 4096
+
+var (
+	// ErrMissingToken is returned when a request has no CAPTCHA token
+	ErrMissingToken = errors.New("missing CAPTCHA token")
+
+	// ErrInvalidToken is returned when the provider rejects a token, such as
+	// an expired or already used one
+	ErrInvalidToken = errors.New("invalid CAPTCHA token")
+)
+
+// Verifier checks CAPTCHA tokens solved in the browser with the provider
+type Verifier struct {
+	provider   string
+	verifyURL  string
+	secret     string
+	httpClient *http.Client
+}
+
+// NewVerifier creates a verifier for the tokens of a provider, turnstile or
+// hcaptcha, with the secret key of the site
+func NewVerifier(provider, secret string) (*Verifier, error) {
+	verifyURL, ok := verifyURLs[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown CAPTCHA provider %q, expected turnstile or hcaptcha", provider)
+	}
+	return &Verifier{
+		provider:   provider,
+		verifyURL:  verifyURL,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Provider returns the provider of the verifier
+func (v *Verifier) Provider() string {
+	return v.provider
+}
+
+// Verify checks a token with the provider, passing the IP address of the
+// client so tokens solved elsewhere are rejected
+func (v *Verifier) Verify(ctx context.Context, token, remoteIP string) error {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrMissingToken
+	}
+	if len(token) > maxTokenLength {
+		return ErrInvalidToken
+	}
+
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create CAPTCHA request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to verify CAPTCHA token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to verify CAPTCHA token: %s returned %d", v.provider, resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to parse CAPTCHA response: %v", err)
+	}
+	if result.Success {
+		return nil
+	}
+	// A wrong secret is a misconfiguration of the instance, not of the client
+	for _, code := range result.ErrorCodes {
+		if code == "invalid-input-secret" || code == "missing-input-secret" || code == "sitekey-secret-mismatch" {
+			return fmt.Errorf("failed to verify CAPTCHA token: %s rejected the secret key", v.provider)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidToken, strings.Join(result.ErrorCodes, ", "))
+}
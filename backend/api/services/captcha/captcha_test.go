@@ -0,0 +1,63 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testVerifier returns a verifier against a siteverify endpoint that accepts
+// the token "solved" and answers other tokens with the given error codes
+func testVerifier(t *testing.T, errorCodes string) *Verifier {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "secret" || r.FormValue("remoteip") != "203.0.113.7" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("response") == "solved" {
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		w.Write([]byte(`{"success":false,"error-codes":[` + errorCodes + `]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	verifier, err := NewVerifier(ProviderTurnstile, "secret")
+	if err != nil {
+		t.Fatalf("NewVerifier failed: %v", err)
+	}
+	verifier.verifyURL = server.URL
+	verifier.httpClient = server.Client()
+	return verifier
+}
+
+func TestVerify(t *testing.T) {
+	verifier := testVerifier(t, `"timeout-or-duplicate"`)
+	if err := verifier.Verify(context.Background(), "solved", "203.0.113.7"); err != nil {
+		t.Fatalf("expected a solved token to pass, got %v", err)
+	}
+	err := verifier.Verify(context.Background(), "reused", "203.0.113.7")
+	if !errors.Is(err, ErrInvalidToken) || !strings.Contains(err.Error(), "timeout-or-duplicate") {
+		t.Fatalf("expected an invalid token error with its code, got %v", err)
+	}
+	if err := verifier.Verify(context.Background(), " ", "203.0.113.7"); !errors.Is(err, ErrMissingToken) {
+		t.Fatalf("expected a missing token error, got %v", err)
+	}
+}
+
+func TestVerifyReportsWrongSecretAsFailure(t *testing.T) {
+	verifier := testVerifier(t, `"invalid-input-secret"`)
+	err := verifier.Verify(context.Background(), "token", "203.0.113.7")
+	if err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected a wrong secret not to blame the token, got %v", err)
+	}
+}
+
+func TestNewVerifierRejectsUnknownProviders(t *testing.T) {
+	if _, err := NewVerifier("recaptcha", "secret"); err == nil {
+		t.Fatal("expected an unknown provider to be rejected")
+	}
+}
@@ -0,0 +1,247 @@
+package drive
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Scope lets the slides service read the Drive files a user passes by ID
+	Scope = "https://www.googleapis.com/auth/drive.readonly"
+
+	// stateTTL is how long a user has to finish connecting Drive
+	stateTTL = 10 * time.Minute
+
+	// revokeURL revokes a Google OAuth token
+	revokeURL = "https://oauth2.googleapis.com/revoke"
+)
+
+var (
+	// ErrNotConnected is returned when an owner hasn't connected Google Drive
+	ErrNotConnected = errors.New("Google Drive is not connected")
+
+	// ErrInvalidState is returned when an OAuth callback has a forged or expired state
+	ErrInvalidState = errors.New("invalid or expired OAuth state")
+)
+
+// Config holds the Google OAuth client used to connect Drive
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // Callback of the API that receives the authorization code
+	ReturnURL    string // Page the user returns to once Drive is connected
+}
+
+// FirestoreConnection is the Firestore representation of a Drive connection.
+// The slides service reads the refresh token to download files.
+type FirestoreConnection struct {
+	RefreshToken string `firestore:"refreshToken"`
+	Scope        string `firestore:"scope"`
+	ConnectedAt  int64  `firestore:"connectedAt"`
+}
+
+// Connection is the Drive connection of an API key or user
+type Connection struct {
+	Connected   bool  `json:"connected"`
+	ConnectedAt int64 `json:"connectedAt,omitempty"`
+}
+
+// Service connects the Google Drive of API key owners and users with OAuth
+type Service struct {
+	client     *firestore.Client
+	oauth      *oauth2.Config
+	stateKey   []byte
+	returnURL  string
+	httpClient *http.Client
+}
+
+// NewService creates a new Drive service. Drive is disabled without a client ID.
+func NewService(client *firestore.Client, cfg Config) *Service {
+	s := &Service{
+		client:     client,
+		stateKey:   []byte(cfg.ClientSecret),
+		returnURL:  cfg.ReturnURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	if cfg.ClientID != "" {
+		s.oauth = &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{Scope},
+		}
+	}
+	return s
+}
+
+// Enabled reports whether an OAuth client is configured
+func (s *Service) Enabled() bool {
+	return s.oauth != nil
+}
+
+// Collection returns the Firestore collection reference for Drive connections
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("driveConnections")
+}
+
+// AuthURL returns the Google consent page that connects the Drive of an owner
+func (s *Service) AuthURL(owner string) string {
+	state := signState(s.stateKey, owner, time.Now().Add(stateTTL))
+	// Force the consent prompt so Google always returns a refresh token
+	return s.oauth.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+}
+
+// ReturnURL returns the page a user is sent back to after connecting, with the outcome
+func (s *Service) ReturnURL(outcome string) string {
+	separator := "?"
+	if strings.Contains(s.returnURL, "?") {
+		separator = "&"
+	}
+	return s.returnURL + separator + "drive=" + url.QueryEscape(outcome)
+}
+
+// Connect exchanges the authorization code of an OAuth callback and stores the
+// refresh token for the owner in its state, which it returns
+func (s *Service) Connect(ctx context.Context, state, code string) (string, error) {
+	owner, err := verifyState(s.stateKey, state, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token, err := s.oauth.Exchange(ctx, code)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange authorization code: %v", err)
+	}
+	if token.RefreshToken == "" {
+		return "", fmt.Errorf("Google didn't return a refresh token")
+	}
+
+	connection := FirestoreConnection{
+		RefreshToken: token.RefreshToken,
+		Scope:        Scope,
+		ConnectedAt:  time.Now().Unix(),
+	}
+	if _, err := s.Collection().Doc(owner).Set(ctx, connection); err != nil {
+		return "", fmt.Errorf("error saving Drive connection: %v", err)
+	}
+	return owner, nil
+}
+
+// Status returns the Drive connection of an owner
+func (s *Service) Status(ctx context.Context, owner string) (*Connection, error) {
+	connection, err := s.get(ctx, owner)
+	if errors.Is(err, ErrNotConnected) {
+		return &Connection{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Connection{Connected: true, ConnectedAt: connection.ConnectedAt}, nil
+}
+
+// Disconnect revokes the Drive access of an owner and deletes its refresh token
+func (s *Service) Disconnect(ctx context.Context, owner string) error {
+	connection, err := s.get(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	// The token is deleted even if Google can't be reached, the user can still
+	// remove the access from their Google account
+	if err := s.revoke(ctx, connection.RefreshToken); err != nil {
+		log.Printf("Warning: Failed to revoke Drive token of %s: %v", owner, err)
+	}
+	if _, err := s.Collection().Doc(owner).Delete(ctx); err != nil {
+		return fmt.Errorf("error deleting Drive connection: %v", err)
+	}
+	return nil
+}
+
+// get returns the stored connection of an owner, or ErrNotConnected
+func (s *Service) get(ctx context.Context, owner string) (*FirestoreConnection, error) {
+	doc, err := s.Collection().Doc(owner).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotConnected
+		}
+		return nil, fmt.Errorf("error retrieving Drive connection: %v", err)
+	}
+
+	var connection FirestoreConnection
+	if err := doc.DataTo(&connection); err != nil {
+		return nil, fmt.Errorf("error parsing Drive connection data: %v", err)
+	}
+	return &connection, nil
+}
+
+// revoke revokes a refresh token and the access tokens issued for it
+func (s *Service) revoke(ctx context.Context, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signState encodes the owner and expiry of an OAuth flow with an HMAC so the
+// callback can trust which owner to connect
+func signState(key []byte, owner string, expiresAt time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(owner)) + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyState checks the signature and expiry of a state and returns its owner
+func verifyState(key []byte, state string, now time.Time) (string, error) {
+	i := strings.LastIndex(state, ".")
+	if i == -1 {
+		return "", ErrInvalidState
+	}
+	payload, signature := state[:i], state[i+1:]
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", ErrInvalidState
+	}
+
+	encodedOwner, expiry, ok := strings.Cut(payload, ".")
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if !ok || err != nil || now.Unix() > expiresAt {
+		return "", ErrInvalidState
+	}
+	owner, err := base64.RawURLEncoding.DecodeString(encodedOwner)
+	if err != nil || len(owner) == 0 {
+		return "", ErrInvalidState
+	}
+	return string(owner), nil
+}
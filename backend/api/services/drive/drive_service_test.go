@@ -0,0 +1,45 @@
+package drive
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	key := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	state := signState(key, "firebase:user-1", now.Add(stateTTL))
+
+	owner, err := verifyState(key, state, now)
+	if err != nil {
+		t.Fatalf("verifyState failed: %v", err)
+	}
+	if owner != "firebase:user-1" {
+		t.Fatalf("expected the signed owner, got %q", owner)
+	}
+
+	if _, err := verifyState(key, state, now.Add(stateTTL+time.Second)); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected an expired state to be rejected, got %v", err)
+	}
+	if _, err := verifyState([]byte("other"), state, now); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected a state signed with another key to be rejected, got %v", err)
+	}
+	forged := signState([]byte("other"), "key-2", now.Add(stateTTL))
+	parts := strings.Split(state, ".")
+	if _, err := verifyState(key, strings.Split(forged, ".")[0]+"."+parts[1]+"."+parts[2], now); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected a state with a swapped owner to be rejected, got %v", err)
+	}
+}
+
+func TestReturnURL(t *testing.T) {
+	s := &Service{returnURL: "https://example.com/settings"}
+	if got := s.ReturnURL("connected"); got != "https://example.com/settings?drive=connected" {
+		t.Errorf("unexpected return URL: %s", got)
+	}
+	s.returnURL = "https://example.com/settings?tab=integrations"
+	if got := s.ReturnURL("error"); got != "https://example.com/settings?tab=integrations&drive=error" {
+		t.Errorf("unexpected return URL: %s", got)
+	}
+}
@@ -0,0 +1,140 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// dataKeySize is the size of the AES-256 data keys
+const dataKeySize = 32
+
+// KeyWrapper wraps and unwraps data keys with a key encryption key, such as a
+// Cloud KMS key, so the data keys can be stored next to the data they encrypt
+type KeyWrapper interface {
+	// Wrap encrypts a data key with the named key
+	Wrap(ctx context.Context, keyName string, key []byte) ([]byte, error)
+	// Unwrap decrypts a data key wrapped with the named key
+	Unwrap(ctx context.Context, keyName string, wrapped []byte) ([]byte, error)
+}
+
+// KMS is a KeyWrapper backed by Cloud KMS symmetric keys
+type KMS struct {
+	service *cloudkms.Service
+}
+
+// NewKMS creates a Cloud KMS key wrapper using the default credentials
+func NewKMS(ctx context.Context) (*KMS, error) {
+	service, err := cloudkms.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud KMS client: %v", err)
+	}
+	return &KMS{service: service}, nil
+}
+
+// Wrap encrypts a data key with a Cloud KMS key, named like
+// projects/p/locations/l/keyRings/r/cryptoKeys/k
+func (k *KMS) Wrap(ctx context.Context, keyName string, key []byte) ([]byte, error) {
+	resp, err := k.service.Projects.Locations.KeyRings.CryptoKeys.Encrypt(keyName, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(key),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %v", err)
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+// Unwrap decrypts a data key wrapped with a Cloud KMS key
+func (k *KMS) Unwrap(ctx context.Context, keyName string, wrapped []byte) ([]byte, error) {
+	resp, err := k.service.Projects.Locations.KeyRings.CryptoKeys.Decrypt(keyName, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(wrapped),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+// Envelope generates data keys wrapped by one key encryption key
+type Envelope struct {
+	wrapper KeyWrapper
+	keyName string
+}
+
+// NewEnvelope creates an envelope that wraps data keys with the named key
+func NewEnvelope(wrapper KeyWrapper, keyName string) *Envelope {
+	return &Envelope{
+		wrapper: wrapper,
+		keyName: keyName,
+	}
+}
+
+// KeyName returns the name of the key encryption key
+func (e *Envelope) KeyName() string {
+	return e.keyName
+}
+
+// NewDataKey generates a data key and returns it with its wrapped form, which
+// is stored with the data so it can be decrypted later
+func (e *Envelope) NewDataKey(ctx context.Context) ([]byte, []byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	wrapped, err := e.wrapper.Wrap(ctx, e.keyName, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, wrapped, nil
+}
+
+// Seal encrypts data with AES-256-GCM under a data key, prefixing the nonce.
+// The additional data, such as the field the data is stored in, must be
+// passed again to Open. The slides service seals results with a copy of this
+// in backend/slides-service/services/encryption, kept in step with it.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts data encrypted by Seal
+func Open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data: %v", err)
+	}
+	return plaintext, nil
+}
+
+// newAEAD returns AES-GCM for a data key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("data key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// staticWrapper wraps data keys with a fixed key instead of Cloud KMS
+type staticWrapper struct {
+	key []byte
+}
+
+func (w *staticWrapper) Wrap(ctx context.Context, keyName string, key []byte) ([]byte, error) {
+	return Seal(w.key, key, []byte(keyName))
+}
+
+func (w *staticWrapper) Unwrap(ctx context.Context, keyName string, wrapped []byte) ([]byte, error) {
+	return Open(w.key, wrapped, []byte(keyName))
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	wrapper := &staticWrapper{key: bytes.Repeat([]byte{7}, dataKeySize)}
+	envelope := NewEnvelope(wrapper, "projects/p/locations/global/keyRings/r/cryptoKeys/results")
+
+	key, wrapped, err := envelope.NewDataKey(context.Background())
+	if err != nil {
+		t.Fatalf("NewDataKey failed: %v", err)
+	}
+	sealed, err := Seal(key, []byte("%PDF-1.4"), []byte("pdfData"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("%PDF")) {
+		t.Fatal("expected the data to be encrypted")
+	}
+
+	unwrapped, err := wrapper.Unwrap(context.Background(), envelope.KeyName(), wrapped)
+	if err != nil {
+		t.Fatalf("Unwrap failed: %v", err)
+	}
+	plaintext, err := Open(unwrapped, sealed, []byte("pdfData"))
+	if err != nil || string(plaintext) != "%PDF-1.4" {
+		t.Fatalf("expected the data back, got %q, %v", plaintext, err)
+	}
+
+	// Data moved to another field or tampered with doesn't decrypt
+	if _, err := Open(unwrapped, sealed, []byte("htmlData")); err == nil {
+		t.Fatal("expected other additional data to fail")
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := Open(unwrapped, sealed, []byte("pdfData")); err == nil {
+		t.Fatal("expected tampered data to fail")
+	}
+}
@@ -0,0 +1,161 @@
+package estimates
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"google.golang.org/api/idtoken"
+)
+
+// requestTimeout bounds counting the tokens of a job on the slides service,
+// which sends the documents to Gemini
+const requestTimeout = 2 * time.Minute
+
+// maxErrorBytes is the most of an error response kept in the error
+const maxErrorBytes = 1 << 10
+
+// Estimate is the projected Gemini usage of a job
+type Estimate struct {
+	InputTokens  int    `json:"inputTokens"`
+	OutputTokens int    `json:"outputTokens"`
+	TotalTokens  int    `json:"totalTokens"`
+	Trimmed      bool   `json:"trimmed"`         // The documents exceed the input budget and would be cut down to fit
+	Price        *Price `json:"price,omitempty"` // Set on billed deployments with a token price
+}
+
+// DryRun is the final prompt and projections of a job, made without
+// generating it
+type DryRun struct {
+	Prompt    string     `json:"prompt"`    // Prompt sent to Gemini after the documents
+	Documents []Document `json:"documents"` // How each readable document would be sent
+	Estimate
+	EstimatedSlides int      `json:"estimatedSlides"`
+	Warnings        []string `json:"warnings,omitempty"`
+}
+
+// Document is a document as a dry run would send it to Gemini
+type Document struct {
+	Filename string `json:"filename"`
+	Inlined  bool   `json:"inlined"` // Sent as extracted text rather than as it was uploaded
+	Tokens   int    `json:"tokens"`  // Approximate, from the length of the text
+}
+
+// Chapter is a chapter of a long document, generated as a deck of its own
+type Chapter struct {
+	Title    string `json:"title"`
+	Markdown string `json:"markdown"` // Text of the chapter, starting with its heading
+}
+
+// Price is what a job is projected to cost
+type Price struct {
+	Amount   float64 `json:"amount"` // Rounded up to the cent
+	Currency string  `json:"currency"`
+}
+
+// SetPrice prices the tokens of the estimate at a price per million tokens in USD
+func (e *Estimate) SetPrice(perMillion float64) {
+	amount := math.Ceil(float64(e.TotalTokens)*perMillion/1e6*100) / 100
+	e.Price = &Price{Amount: amount, Currency: "usd"}
+}
+
+// request is the estimate or dry run task of the slides service
+type request struct {
+	Theme       string               `json:"theme"`
+	Prompt      string               `json:"prompt,omitempty"`
+	Files       []models.File        `json:"files"`
+	Settings    models.SlideSettings `json:"settings"`
+	TokenLimits models.TokenLimits   `json:"tokenLimits,omitempty"`
+}
+
+// Client asks the slides service to count the tokens of prospective jobs, as
+// only it talks to Gemini
+type Client struct {
+	httpClient *http.Client
+	serviceURL string
+	secret     string // Optional, signs the requests like the tasks
+}
+
+// NewClient creates a client that calls the slides service with an OIDC token
+// of the default credentials, and signs the requests when a secret is given
+func NewClient(ctx context.Context, serviceURL, secret string) (*Client, error) {
+	httpClient, err := idtoken.NewClient(ctx, serviceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OIDC client: %v", err)
+	}
+	httpClient.Timeout = requestTimeout
+	return &Client{httpClient: httpClient, serviceURL: serviceURL, secret: secret}, nil
+}
+
+// Estimate counts the tokens a job would use, written from the files or from
+// the prompt when there are none, within the token limits of the owner's plan
+func (c *Client) Estimate(ctx context.Context, theme, prompt string, files []models.File, settings models.SlideSettings, limits models.TokenLimits) (*Estimate, error) {
+	var estimate Estimate
+	if err := c.post(ctx, "/tasks/estimate-tokens", request{Theme: theme, Prompt: prompt, Files: files, Settings: settings, TokenLimits: limits}, &estimate); err != nil {
+		return nil, err
+	}
+	estimate.TotalTokens = estimate.InputTokens + estimate.OutputTokens
+	return &estimate, nil
+}
+
+// DryRun builds the final prompt of a job and projects its tokens and slides,
+// without the slides service calling Gemini or rendering the deck
+func (c *Client) DryRun(ctx context.Context, theme, prompt string, files []models.File, settings models.SlideSettings, limits models.TokenLimits) (*DryRun, error) {
+	var dryRun DryRun
+	if err := c.post(ctx, "/tasks/dry-run", request{Theme: theme, Prompt: prompt, Files: files, Settings: settings, TokenLimits: limits}, &dryRun); err != nil {
+		return nil, err
+	}
+	dryRun.TotalTokens = dryRun.InputTokens + dryRun.OutputTokens
+	return &dryRun, nil
+}
+
+// SplitChapters detects the chapters of the files, in order. A file without
+// chapters is returned as one chapter.
+func (c *Client) SplitChapters(ctx context.Context, files []models.File) ([]Chapter, error) {
+	var response struct {
+		Chapters []Chapter `json:"chapters"`
+	}
+	if err := c.post(ctx, "/tasks/split-chapters", request{Files: files}, &response); err != nil {
+		return nil, err
+	}
+	return response.Chapters, nil
+}
+
+// post sends a request to a task of the slides service and decodes its response into v
+func (c *Client) post(ctx context.Context, path string, payload request, v any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.serviceURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.secret != "" {
+		for name, value := range queue.SignTask(c.secret, body, time.Now()) {
+			req.Header.Set(name, value)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach slides service: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBytes))
+		return fmt.Errorf("slides service returned %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
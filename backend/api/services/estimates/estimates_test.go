@@ -0,0 +1,123 @@
+package estimates
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+)
+
+func TestEstimate(t *testing.T) {
+	var received request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tasks/estimate-tokens" || r.Header.Get(queue.SignatureHeader) == "" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{"inputTokens":1200,"outputTokens":2500,"trimmed":false}`))
+	}))
+	defer server.Close()
+
+	client := &Client{httpClient: server.Client(), serviceURL: server.URL, secret: "secret"}
+	files := []models.File{{Filename: "notes.md", Data: []byte("# Notes"), Type: "text/plain"}}
+	estimate, err := client.Estimate(context.Background(), "default", "", files, models.SlideSettings{SlideDetail: "medium"}, models.TokenLimits{Input: 65536})
+	if err != nil {
+		t.Fatalf("Estimate failed: %v", err)
+	}
+	if estimate.InputTokens != 1200 || estimate.OutputTokens != 2500 || estimate.TotalTokens != 3700 {
+		t.Errorf("unexpected estimate: %+v", estimate)
+	}
+	if len(received.Files) != 1 || string(received.Files[0].Data) != "# Notes" || received.Settings.SlideDetail != "medium" || received.TokenLimits.Input != 65536 {
+		t.Errorf("unexpected request: %+v", received)
+	}
+}
+
+func TestEstimateReportsServiceErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"Failed to count tokens"}`, http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := &Client{httpClient: server.Client(), serviceURL: server.URL}
+	_, err := client.Estimate(context.Background(), "default", "Intro to Kubernetes", nil, models.SlideSettings{}, models.TokenLimits{})
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("expected the status in the error, got %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var received request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tasks/dry-run" || r.Header.Get(queue.SignatureHeader) == "" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{"prompt":"Create a Marp markdown presentation","documents":[],"inputTokens":900,"outputTokens":1500,"trimmed":false,"estimatedSlides":12}`))
+	}))
+	defer server.Close()
+
+	client := &Client{httpClient: server.Client(), serviceURL: server.URL, secret: "secret"}
+	dryRun, err := client.DryRun(context.Background(), "default", "Intro to Kubernetes, 12 slides", nil, models.SlideSettings{SlideDetail: "minimal"}, models.TokenLimits{})
+	if err != nil {
+		t.Fatalf("DryRun failed: %v", err)
+	}
+	if dryRun.Prompt != "Create a Marp markdown presentation" || dryRun.EstimatedSlides != 12 || dryRun.TotalTokens != 2400 {
+		t.Errorf("unexpected dry run: %+v", dryRun)
+	}
+	if received.Prompt != "Intro to Kubernetes, 12 slides" || received.Settings.SlideDetail != "minimal" {
+		t.Errorf("unexpected request: %+v", received)
+	}
+}
+
+func TestSplitChapters(t *testing.T) {
+	var received request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tasks/split-chapters" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{"chapters":[{"title":"Week 1","markdown":"Week 1\nBasics"},{"title":"Week 2","markdown":"Week 2\nSorting"}]}`))
+	}))
+	defer server.Close()
+
+	client := &Client{httpClient: server.Client(), serviceURL: server.URL}
+	files := []models.File{{Filename: "course.pdf", Data: []byte("%PDF"), Type: "application/pdf"}}
+	chapters, err := client.SplitChapters(context.Background(), files)
+	if err != nil {
+		t.Fatalf("SplitChapters failed: %v", err)
+	}
+	if len(chapters) != 2 || chapters[1].Title != "Week 2" || chapters[1].Markdown != "Week 2\nSorting" {
+		t.Errorf("unexpected chapters: %+v", chapters)
+	}
+	if len(received.Files) != 1 || received.Files[0].Filename != "course.pdf" {
+		t.Errorf("unexpected request: %+v", received)
+	}
+}
+
+func TestSetPrice(t *testing.T) {
+	tests := []struct {
+		tokens     int
+		perMillion float64
+		amount     float64
+	}{
+		{1_000_000, 2.5, 2.5},
+		{3700, 2.5, 0.01}, // Rounded up to the cent
+		{0, 2.5, 0},
+	}
+
+	for _, test := range tests {
+		estimate := &Estimate{TotalTokens: test.tokens}
+		estimate.SetPrice(test.perMillion)
+		if estimate.Price.Amount != test.amount || estimate.Price.Currency != "usd" {
+			t.Errorf("%d tokens at %v: expected %v usd, got %+v", test.tokens, test.perMillion, test.amount, estimate.Price)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package features
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+// Feature flags gating capabilities that roll out progressively
+const (
+	// ChunkedMode summarizes the sections of long documents that don't fit the
+	// token budget, instead of only leaving them out
+	ChunkedMode = "chunked_mode"
+	// NewThemes allows the themes in models.ExperimentalThemes
+	NewThemes = "new_themes"
+	// InjectionDetection removes text that reads as instructions to the AI
+	// from documents before they are sent to Gemini
+	InjectionDetection = "injection_detection"
+)
+
+// defaults is whether each flag is on when neither the environment nor
+// Firestore sets it. Capabilities that shipped before their flag stay on.
+var defaults = map[string]bool{
+	ChunkedMode:        true,
+	NewThemes:          false,
+	InjectionDetection: false,
+}
+
+// rulesTTL is how long the rules read from Firestore are used before they
+// are read again, so flipping a flag takes effect within a minute
+const rulesTTL = time.Minute
+
+// FirestoreRule is the Firestore representation of the rollout of a flag,
+// stored under the name of the flag. A rule replaces the default of its flag.
+type FirestoreRule struct {
+	Enabled        bool     `firestore:"enabled"`                  // On for every workspace
+	Workspaces     []string `firestore:"workspaces,omitempty"`     // Workspaces the flag is on for
+	RolloutPercent int      `firestore:"rolloutPercent,omitempty"` // Share of the other workspaces the flag is on for, from 0 to 100
+}
+
+// allows reports whether the rule turns a flag on for a workspace. Workspaces
+// are picked for a rollout by a hash of the flag and their ID, so raising the
+// percentage keeps the workspaces already picked.
+func (r FirestoreRule) allows(flag, workspaceID string) bool {
+	if r.Enabled {
+		return true
+	}
+	if workspaceID == "" {
+		return false
+	}
+	if slices.Contains(r.Workspaces, workspaceID) {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(flag + ":" + workspaceID))
+	return int(h.Sum32()%100) < r.RolloutPercent
+}
+
+// Flags are the flags evaluated for a job, by name
+type Flags map[string]bool
+
+// AllowsTheme reports whether a theme can be used with the flags
+func (f Flags) AllowsTheme(theme string) bool {
+	return f[NewThemes] || !slices.Contains(models.ExperimentalThemes, theme)
+}
+
+// Service evaluates the feature flags of workspaces
+type Service struct {
+	client    *firestore.Client
+	overrides map[string]bool // Set for the whole deployment from the environment
+
+	mu        sync.Mutex
+	rules     map[string]FirestoreRule
+	fetchedAt time.Time
+}
+
+// NewService creates a feature flag service. Overrides turn flags on or off
+// for the whole deployment ahead of the rules in Firestore, and a nil client
+// uses only the defaults and the overrides.
+func NewService(client *firestore.Client, overrides map[string]bool) (*Service, error) {
+	for flag := range overrides {
+		if _, ok := defaults[flag]; !ok {
+			return nil, fmt.Errorf("unknown feature flag: %s", flag)
+		}
+	}
+	return &Service{
+		client:    client,
+		overrides: overrides,
+	}, nil
+}
+
+// Collection returns the Firestore collection reference for flag rules
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("featureFlags")
+}
+
+// Evaluate returns every flag for a workspace, or for jobs outside a
+// workspace when the ID is empty. Flags fall back to the last rules read, or
+// to their defaults, when Firestore can't be read, so jobs never fail on them.
+func (s *Service) Evaluate(ctx context.Context, workspaceID string) Flags {
+	rules := s.loadRules(ctx)
+	flags := make(Flags, len(defaults))
+	for flag, enabled := range defaults {
+		if override, ok := s.overrides[flag]; ok {
+			enabled = override
+		} else if rule, ok := rules[flag]; ok {
+			enabled = rule.allows(flag, workspaceID)
+		}
+		flags[flag] = enabled
+	}
+	return flags
+}
+
+// loadRules returns the rules in Firestore, reading them again once they expire
+func (s *Service) loadRules(ctx context.Context) map[string]FirestoreRule {
+	if s.client == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rules != nil && time.Since(s.fetchedAt) < rulesTTL {
+		return s.rules
+	}
+
+	docs, err := s.Collection().Documents(ctx).GetAll()
+	if err != nil {
+		log.Printf("Warning: Failed to read feature flags, using the last ones read: %v", err)
+		return s.rules
+	}
+	rules := make(map[string]FirestoreRule, len(docs))
+	for _, doc := range docs {
+		var rule FirestoreRule
+		if err := doc.DataTo(&rule); err != nil {
+			log.Printf("Warning: Ignoring invalid feature flag %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		rules[doc.Ref.ID] = rule
+	}
+	s.rules, s.fetchedAt = rules, time.Now()
+	return rules
+}
@@ -0,0 +1,88 @@
+package features
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+func TestRuleAllows(t *testing.T) {
+	tests := []struct {
+		name        string
+		rule        FirestoreRule
+		workspaceID string
+		allowed     bool
+	}{
+		{"enabled", FirestoreRule{Enabled: true}, "", true},
+		{"listed workspace", FirestoreRule{Workspaces: []string{"ws-1"}}, "ws-1", true},
+		{"other workspace", FirestoreRule{Workspaces: []string{"ws-1"}}, "ws-2", false},
+		{"full rollout", FirestoreRule{RolloutPercent: 100}, "ws-2", true},
+		{"full rollout without workspace", FirestoreRule{RolloutPercent: 100}, "", false},
+		{"no rollout", FirestoreRule{}, "ws-2", false},
+	}
+
+	for _, test := range tests {
+		if allowed := test.rule.allows(NewThemes, test.workspaceID); allowed != test.allowed {
+			t.Errorf("%s: expected allowed=%t, got %t", test.name, test.allowed, allowed)
+		}
+	}
+}
+
+func TestRolloutKeepsPickedWorkspaces(t *testing.T) {
+	picked := 0
+	for i := 0; i < 1000; i++ {
+		workspaceID := fmt.Sprintf("ws-%d", i)
+		if !(FirestoreRule{RolloutPercent: 20}).allows(NewThemes, workspaceID) {
+			continue
+		}
+		picked++
+		if !(FirestoreRule{RolloutPercent: 50}).allows(NewThemes, workspaceID) {
+			t.Fatalf("expected %s to stay picked when the rollout grows", workspaceID)
+		}
+	}
+	if picked < 150 || picked > 250 {
+		t.Fatalf("expected about 200 of 1000 workspaces in a 20%% rollout, got %d", picked)
+	}
+}
+
+func TestEvaluateAppliesOverrides(t *testing.T) {
+	service, err := NewService(nil, nil)
+	if err != nil {
+		t.Fatalf("NewService failed: %v", err)
+	}
+	flags := service.Evaluate(context.Background(), "ws-1")
+	if !flags[ChunkedMode] || flags[NewThemes] {
+		t.Fatalf("expected the defaults, got %v", flags)
+	}
+
+	service, err = NewService(nil, map[string]bool{ChunkedMode: false, NewThemes: true})
+	if err != nil {
+		t.Fatalf("NewService failed: %v", err)
+	}
+	flags = service.Evaluate(context.Background(), "")
+	if flags[ChunkedMode] || !flags[NewThemes] {
+		t.Fatalf("expected the overrides, got %v", flags)
+	}
+
+	if _, err := NewService(nil, map[string]bool{"teleport": true}); err == nil {
+		t.Fatal("expected an error for an unknown flag")
+	}
+}
+
+func TestAllowsTheme(t *testing.T) {
+	experimental := models.ExperimentalThemes
+	models.ExperimentalThemes = []string{"graph_paper"}
+	defer func() { models.ExperimentalThemes = experimental }()
+
+	if !(Flags{}).AllowsTheme("default") {
+		t.Error("expected stable themes to be allowed without flags")
+	}
+	if (Flags{}).AllowsTheme("graph_paper") {
+		t.Error("expected experimental themes to need the new_themes flag")
+	}
+	if !(Flags{NewThemes: true}).AllowsTheme("graph_paper") {
+		t.Error("expected experimental themes to be allowed with the new_themes flag")
+	}
+}
@@ -0,0 +1,228 @@
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is the language of the messages stored with jobs, used
+// when a client accepts none of the supported languages
+const DefaultLanguage = "en"
+
+// catalogs are the texts of the status message codes in each supported
+// language, {name} stands for the parameter name. The codes are set by the
+// slides service and the queue.
+var catalogs = map[string]map[string]string{
+	"en": {
+		"queued":                  "Job added to queue",
+		"refinement_queued":       "Refinement of revision {revision} queued",
+		"regeneration_queued":     "Regeneration of slide {slide} queued",
+		"processing_slides":       "Processing slides",
+		"processing_refinement":   "Processing refinement",
+		"waiting_for_worker":      "Waiting for a free worker",
+		"waiting_for_gemini":      "Waiting for Gemini to become available again",
+		"analyzing_files":         "Analyzing uploaded files",
+		"planning":                "Planning presentation",
+		"planning_sections":       "Planning a long presentation in {parts} parts",
+		"writing_section":         "Writing part {part} of {parts}: {title}",
+		"summarizing":             "Summarizing {section}",
+		"generating_content":      "Generating content for slides",
+		"creating_presentation":   "Creating presentation with AI",
+		"waiting_for_generation":  "Waiting for a free generation slot",
+		"extracting_flashcards":   "Extracting flashcards",
+		"writing_summary":         "Writing the executive summary",
+		"writing_handout":         "Writing the student handout",
+		"checking_facts":          "Checking the slides against the documents",
+		"indexing_documents":      "Indexing {passages} passages of the documents",
+		"applying_changes":        "Applying your changes",
+		"regenerating_slide":      "Regenerating slide {slide}",
+		"waiting_for_renderer":    "Waiting for a free renderer",
+		"finalizing":              "Finalizing presentation",
+		"completed":               "Slides generated successfully",
+		"completed_with_warnings": "Slides generated successfully. {warnings}",
+		"revision_created":        "Revision {revision} created",
+	},
+	"es": {
+		"queued":                  "Trabajo añadido a la cola",
+		"refinement_queued":       "Revisión de la versión {revision} en cola",
+		"regeneration_queued":     "Regeneración de la diapositiva {slide} en cola",
+		"processing_slides":       "Procesando las diapositivas",
+		"processing_refinement":   "Procesando la revisión",
+		"waiting_for_worker":      "Esperando un trabajador libre",
+		"waiting_for_gemini":      "Esperando a que Gemini vuelva a estar disponible",
+		"analyzing_files":         "Analizando los archivos subidos",
+		"planning":                "Planificando la presentación",
+		"planning_sections":       "Planificando una presentación larga en {parts} partes",
+		"writing_section":         "Escribiendo la parte {part} de {parts}: {title}",
+		"summarizing":             "Resumiendo {section}",
+		"generating_content":      "Generando el contenido de las diapositivas",
+		"creating_presentation":   "Creando la presentación con IA",
+		"waiting_for_generation":  "Esperando un turno de generación libre",
+		"extracting_flashcards":   "Extrayendo las tarjetas de estudio",
+		"writing_summary":         "Escribiendo el resumen ejecutivo",
+		"writing_handout":         "Escribiendo el material para los estudiantes",
+		"checking_facts":          "Comprobando las diapositivas con los documentos",
+		"indexing_documents":      "Indexando {passages} pasajes de los documentos",
+		"applying_changes":        "Aplicando tus cambios",
+		"regenerating_slide":      "Regenerando la diapositiva {slide}",
+		"waiting_for_renderer":    "Esperando un renderizador libre",
+		"finalizing":              "Finalizando la presentación",
+		"completed":               "Diapositivas generadas correctamente",
+		"completed_with_warnings": "Diapositivas generadas correctamente. {warnings}",
+		"revision_created":        "Versión {revision} creada",
+	},
+	"fr": {
+		"queued":                  "Tâche ajoutée à la file d'attente",
+		"refinement_queued":       "Révision de la version {revision} en attente",
+		"regeneration_queued":     "Régénération de la diapositive {slide} en attente",
+		"processing_slides":       "Traitement des diapositives",
+		"processing_refinement":   "Traitement de la révision",
+		"waiting_for_worker":      "En attente d'un serveur disponible",
+		"waiting_for_gemini":      "En attente du retour de Gemini",
+		"analyzing_files":         "Analyse des fichiers envoyés",
+		"planning":                "Préparation de la présentation",
+		"planning_sections":       "Préparation d'une longue présentation en {parts} parties",
+		"writing_section":         "Rédaction de la partie {part} sur {parts} : {title}",
+		"summarizing":             "Résumé de {section}",
+		"generating_content":      "Génération du contenu des diapositives",
+		"creating_presentation":   "Création de la présentation avec l'IA",
+		"waiting_for_generation":  "En attente d'un créneau de génération",
+		"extracting_flashcards":   "Extraction des fiches de révision",
+		"writing_summary":         "Rédaction de la synthèse",
+		"writing_handout":         "Rédaction du support pour les étudiants",
+		"checking_facts":          "Vérification des diapositives avec les documents",
+		"indexing_documents":      "Indexation de {passages} passages des documents",
+		"applying_changes":        "Application de vos modifications",
+		"regenerating_slide":      "Régénération de la diapositive {slide}",
+		"waiting_for_renderer":    "En attente d'un moteur de rendu disponible",
+		"finalizing":              "Finalisation de la présentation",
+		"completed":               "Diapositives générées avec succès",
+		"completed_with_warnings": "Diapositives générées avec succès. {warnings}",
+		"revision_created":        "Version {revision} créée",
+	},
+	"de": {
+		"queued":                  "Auftrag zur Warteschlange hinzugefügt",
+		"refinement_queued":       "Überarbeitung von Version {revision} in der Warteschlange",
+		"regeneration_queued":     "Neuerstellung von Folie {slide} in der Warteschlange",
+		"processing_slides":       "Folien werden verarbeitet",
+		"processing_refinement":   "Überarbeitung wird verarbeitet",
+		"waiting_for_worker":      "Warten auf einen freien Worker",
+		"waiting_for_gemini":      "Warten, bis Gemini wieder verfügbar ist",
+		"analyzing_files":         "Hochgeladene Dateien werden analysiert",
+		"planning":                "Präsentation wird geplant",
+		"planning_sections":       "Lange Präsentation in {parts} Teilen wird geplant",
+		"writing_section":         "Teil {part} von {parts} wird geschrieben: {title}",
+		"summarizing":             "{section} wird zusammengefasst",
+		"generating_content":      "Inhalte für die Folien werden erstellt",
+		"creating_presentation":   "Präsentation wird mit KI erstellt",
+		"waiting_for_generation":  "Warten auf einen freien Generierungsplatz",
+		"extracting_flashcards":   "Lernkarten werden extrahiert",
+		"writing_summary":         "Zusammenfassung wird geschrieben",
+		"writing_handout":         "Handout für die Studierenden wird geschrieben",
+		"checking_facts":          "Folien werden mit den Dokumenten abgeglichen",
+		"indexing_documents":      "{passages} Abschnitte der Dokumente werden indexiert",
+		"applying_changes":        "Deine Änderungen werden übernommen",
+		"regenerating_slide":      "Folie {slide} wird neu erstellt",
+		"waiting_for_renderer":    "Warten auf einen freien Renderer",
+		"finalizing":              "Präsentation wird fertiggestellt",
+		"completed":               "Folien erfolgreich erstellt",
+		"completed_with_warnings": "Folien erfolgreich erstellt. {warnings}",
+		"revision_created":        "Version {revision} erstellt",
+	},
+	"pt": {
+		"queued":                  "Tarefa adicionada à fila",
+		"refinement_queued":       "Revisão da versão {revision} na fila",
+		"regeneration_queued":     "Regeneração do slide {slide} na fila",
+		"processing_slides":       "Processando os slides",
+		"processing_refinement":   "Processando a revisão",
+		"waiting_for_worker":      "Aguardando um processador livre",
+		"waiting_for_gemini":      "Aguardando o Gemini voltar a ficar disponível",
+		"analyzing_files":         "Analisando os arquivos enviados",
+		"planning":                "Planejando a apresentação",
+		"planning_sections":       "Planejando uma apresentação longa em {parts} partes",
+		"writing_section":         "Escrevendo a parte {part} de {parts}: {title}",
+		"summarizing":             "Resumindo {section}",
+		"generating_content":      "Gerando o conteúdo dos slides",
+		"creating_presentation":   "Criando a apresentação com IA",
+		"waiting_for_generation":  "Aguardando uma vaga de geração",
+		"extracting_flashcards":   "Extraindo os flashcards",
+		"writing_summary":         "Escrevendo o resumo executivo",
+		"writing_handout":         "Escrevendo o material dos alunos",
+		"checking_facts":          "Conferindo os slides com os documentos",
+		"indexing_documents":      "Indexando {passages} trechos dos documentos",
+		"applying_changes":        "Aplicando suas alterações",
+		"regenerating_slide":      "Regenerando o slide {slide}",
+		"waiting_for_renderer":    "Aguardando um renderizador livre",
+		"finalizing":              "Finalizando a apresentação",
+		"completed":               "Slides gerados com sucesso",
+		"completed_with_warnings": "Slides gerados com sucesso. {warnings}",
+		"revision_created":        "Versão {revision} criada",
+	},
+}
+
+// Supported reports whether status messages can be shown in a language
+func Supported(language string) bool {
+	_, ok := catalogs[language]
+	return ok
+}
+
+// Negotiate returns the supported language a client prefers, from a lang
+// override such as "fr" or an Accept-Language header such as
+// "fr-CH, fr;q=0.9, en;q=0.8". Regional variants fall back to their base
+// language, and DefaultLanguage is returned when none is supported.
+func Negotiate(override, acceptLanguage string) string {
+	if language := baseLanguage(override); Supported(language) {
+		return language
+	}
+
+	type preference struct {
+		language string
+		quality  float64
+	}
+	var preferences []preference
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if language := baseLanguage(tag); Supported(language) && quality > 0 {
+			preferences = append(preferences, preference{language, quality})
+		}
+	}
+
+	// Equal qualities keep the order of the header
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].quality > preferences[j].quality
+	})
+	if len(preferences) == 0 {
+		return DefaultLanguage
+	}
+	return preferences[0].language
+}
+
+// baseLanguage returns the lowercase primary subtag of a language tag, fr for fr-CH
+func baseLanguage(tag string) string {
+	language, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	language, _, _ = strings.Cut(language, "_")
+	return strings.ToLower(language)
+}
+
+// Localize returns a status message in a language. Messages without a code,
+// or with a code the catalogs don't know, are returned as they are.
+func Localize(language, code string, params map[string]string, message string) string {
+	text, ok := catalogs[language][code]
+	if !ok || code == "" {
+		return message
+	}
+	replacements := make([]string, 0, 2*len(params))
+	for name, value := range params {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(replacements...).Replace(text)
+}
@@ -0,0 +1,73 @@
+package i18n
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		override       string
+		acceptLanguage string
+		language       string
+	}{
+		{"", "", "en"},
+		{"", "fr-CH, fr;q=0.9, en;q=0.8", "fr"},
+		{"", "ja, de;q=0.5, es;q=0.7", "es"},
+		{"", "pt-BR;q=0.8, de;q=0.8", "pt"},
+		{"", "ja, zh;q=0.9", "en"},
+		{"", "de;q=0, es;q=0.1", "es"},
+		{"", "de;q=abc, fr;q=0.2", "fr"},
+		{"DE", "fr", "de"},
+		{"ja", "es", "es"},
+	}
+	for _, test := range tests {
+		if language := Negotiate(test.override, test.acceptLanguage); language != test.language {
+			t.Errorf("Negotiate(%q, %q) = %q, want %q", test.override, test.acceptLanguage, language, test.language)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	tests := []struct {
+		language string
+		code     string
+		params   map[string]string
+		message  string
+		text     string
+	}{
+		{"es", "regenerating_slide", map[string]string{"slide": "4"}, "Regenerating slide 4", "Regenerando la diapositiva 4"},
+		{"de", "writing_section", map[string]string{"part": "1", "parts": "3", "title": "{parts}"}, "", "Teil 1 von 3 wird geschrieben: {parts}"},
+		{"fr", "", nil, "Failed to store result: timeout", "Failed to store result: timeout"},
+		{"fr", "unknown_code", nil, "Something new", "Something new"},
+		{"ja", "finalizing", nil, "Finalizing presentation", "Finalizing presentation"},
+	}
+	for _, test := range tests {
+		if text := Localize(test.language, test.code, test.params, test.message); text != test.text {
+			t.Errorf("Localize(%q, %q) = %q, want %q", test.language, test.code, text, test.text)
+		}
+	}
+}
+
+func TestCatalogsTranslateEveryCode(t *testing.T) {
+	placeholder := regexp.MustCompile(`\{\w+\}`)
+	for language, catalog := range catalogs {
+		for code, english := range catalogs[DefaultLanguage] {
+			text, ok := catalog[code]
+			if !ok {
+				t.Errorf("%s: missing %s", language, code)
+				continue
+			}
+			want, got := placeholder.FindAllString(english, -1), placeholder.FindAllString(text, -1)
+			slices.Sort(want)
+			slices.Sort(got)
+			if !slices.Equal(want, got) {
+				t.Errorf("%s: %s has the parameters %v, want %v", language, code, got, want)
+			}
+		}
+		if len(catalog) != len(catalogs[DefaultLanguage]) {
+			t.Errorf("%s: has %d messages, want %d", language, len(catalog), len(catalogs[DefaultLanguage]))
+		}
+	}
+}
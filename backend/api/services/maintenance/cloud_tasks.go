@@ -0,0 +1,76 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+)
+
+// CloudTasksQueue pauses the Cloud Tasks queue of the slides service. A
+// paused queue keeps its tasks and accepts new ones, but dispatches none
+// until it is resumed.
+type CloudTasksQueue struct {
+	client *cloudtasks.Client
+	name   string
+}
+
+// NewCloudTasksQueue creates a switch for a Cloud Tasks queue
+func NewCloudTasksQueue(client *cloudtasks.Client, projectID, region, queueID string) *CloudTasksQueue {
+	return &CloudTasksQueue{
+		client: client,
+		name:   fmt.Sprintf("projects/%s/locations/%s/queues/%s", projectID, region, queueID),
+	}
+}
+
+// Pause stops the queue from dispatching tasks
+func (q *CloudTasksQueue) Pause(ctx context.Context) error {
+	if _, err := q.client.PauseQueue(ctx, &taskspb.PauseQueueRequest{Name: q.name}); err != nil {
+		return fmt.Errorf("failed to pause Cloud Tasks queue: %v", err)
+	}
+	return nil
+}
+
+// Resume dispatches the tasks of the queue again, including those held while
+// it was paused
+func (q *CloudTasksQueue) Resume(ctx context.Context) error {
+	if _, err := q.client.ResumeQueue(ctx, &taskspb.ResumeQueueRequest{Name: q.name}); err != nil {
+		return fmt.Errorf("failed to resume Cloud Tasks queue: %v", err)
+	}
+	return nil
+}
+
+// Queues switches the task queues of every region together. Queues paused
+// before one fails to pause are resumed again, so the queues are left as
+// they were.
+type Queues []Queue
+
+// Pause stops every queue from dispatching tasks
+func (qs Queues) Pause(ctx context.Context) error {
+	for i, q := range qs {
+		if err := q.Pause(ctx); err != nil {
+			for _, paused := range qs[:i] {
+				if err := paused.Resume(ctx); err != nil {
+					log.Printf("Warning: Failed to resume queue after a failed pause: %v", err)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Resume dispatches the tasks of every queue again. Every queue is resumed
+// even when one fails to.
+func (qs Queues) Resume(ctx context.Context) error {
+	var errs []error
+	for _, q := range qs {
+		if err := q.Resume(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
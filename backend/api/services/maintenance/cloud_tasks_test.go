@@ -0,0 +1,67 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeQueue records whether it is paused, failing to switch when err is set
+type fakeQueue struct {
+	paused bool
+	err    error
+}
+
+func (q *fakeQueue) Pause(ctx context.Context) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.paused = true
+	return nil
+}
+
+func (q *fakeQueue) Resume(ctx context.Context) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.paused = false
+	return nil
+}
+
+func TestQueuesSwitchEveryRegion(t *testing.T) {
+	us, eu := &fakeQueue{}, &fakeQueue{}
+	queues := Queues{us, eu}
+
+	if err := queues.Pause(context.Background()); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	if !us.paused || !eu.paused {
+		t.Fatal("expected every queue to be paused")
+	}
+	if err := queues.Resume(context.Background()); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if us.paused || eu.paused {
+		t.Fatal("expected every queue to be resumed")
+	}
+}
+
+func TestQueuesPauseFailureResumesPausedQueues(t *testing.T) {
+	us, eu := &fakeQueue{}, &fakeQueue{err: errors.New("unavailable")}
+	if err := (Queues{us, eu}).Pause(context.Background()); err == nil {
+		t.Fatal("expected the failed pause to be returned")
+	}
+	if us.paused {
+		t.Fatal("expected the queue paused before the failure to be resumed")
+	}
+}
+
+func TestQueuesResumeEveryQueueDespiteFailures(t *testing.T) {
+	us, eu := &fakeQueue{err: errors.New("unavailable")}, &fakeQueue{paused: true}
+	if err := (Queues{us, eu}).Resume(context.Background()); err == nil {
+		t.Fatal("expected the failed resume to be returned")
+	}
+	if eu.paused {
+		t.Fatal("expected the other queue to be resumed")
+	}
+}
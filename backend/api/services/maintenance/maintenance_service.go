@@ -0,0 +1,147 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stateTTL is how long the state read from Firestore is used before it is
+// read again, so every instance stops accepting jobs within seconds
+const stateTTL = 15 * time.Second
+
+// defaultMessage is shown to clients when the admin left the message empty
+const defaultMessage = "New jobs are paused for maintenance"
+
+// State is whether the instance accepts new jobs, as set by an admin
+type State struct {
+	Enabled    bool   `json:"enabled" firestore:"enabled"`
+	Message    string `json:"message,omitempty" firestore:"message,omitempty"` // Shown to clients, a default message when empty
+	ETA        int64  `json:"eta,omitempty" firestore:"eta,omitempty"`         // When new jobs are expected to be accepted again, 0 when unknown
+	PauseQueue bool   `json:"pauseQueue" firestore:"pauseQueue"`               // The Cloud Tasks queue stops dispatching queued jobs too
+	UpdatedBy  string `json:"updatedBy,omitempty" firestore:"updatedBy,omitempty"`
+	UpdatedAt  int64  `json:"updatedAt,omitempty" firestore:"updatedAt,omitempty"`
+}
+
+// ClientMessage returns the message shown to clients whose jobs are refused,
+// with the ETA when there is one
+func (s State) ClientMessage() string {
+	message := s.Message
+	if message == "" {
+		message = defaultMessage
+	}
+	if s.ETA > time.Now().Unix() {
+		message += fmt.Sprintf(". Try again after %s", time.Unix(s.ETA, 0).UTC().Format(time.RFC3339))
+	}
+	return message
+}
+
+// Queue pauses and resumes the dispatch of queued tasks
+type Queue interface {
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+}
+
+// Service stores the maintenance state shared by every instance and pauses
+// the task queue with it
+type Service struct {
+	client *firestore.Client
+	queue  Queue
+
+	mu        sync.Mutex
+	state     *State
+	fetchedAt time.Time
+}
+
+// NewService creates a new maintenance service
+func NewService(client *firestore.Client, queue Queue) *Service {
+	return &Service{
+		client: client,
+		queue:  queue,
+	}
+}
+
+// doc returns the Firestore document of the maintenance state
+func (s *Service) doc() *firestore.DocumentRef {
+	return s.client.Collection("settings").Doc("maintenance")
+}
+
+// Current returns the maintenance state. The last state read is used when
+// Firestore can't be read, and jobs are accepted if none was ever read, so an
+// outage of Firestore alone doesn't stop the instance.
+func (s *Service) Current(ctx context.Context) State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != nil && time.Since(s.fetchedAt) < stateTTL {
+		return *s.state
+	}
+
+	state, err := s.read(ctx)
+	if err != nil {
+		log.Printf("Warning: Failed to read maintenance state, using the last one read: %v", err)
+		if s.state == nil {
+			return State{}
+		}
+		return *s.state
+	}
+	s.state, s.fetchedAt = state, time.Now()
+	return *state
+}
+
+// read reads the maintenance state from Firestore
+func (s *Service) read(ctx context.Context) (*State, error) {
+	doc, err := s.doc().Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := doc.DataTo(&state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Set turns maintenance on or off, pausing or resuming the task queue when
+// PauseQueue changes. Turning maintenance off always resumes the queue.
+func (s *Service) Set(ctx context.Context, state State, updatedBy string) (*State, error) {
+	if !state.Enabled {
+		state = State{}
+	}
+	previous, err := s.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading maintenance state: %v", err)
+	}
+
+	// The queue is switched first, so a failure leaves the stored state as it was
+	if state.PauseQueue && !previous.PauseQueue {
+		if err := s.queue.Pause(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if !state.PauseQueue && previous.PauseQueue {
+		if err := s.queue.Resume(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	state.UpdatedBy = updatedBy
+	state.UpdatedAt = time.Now().Unix()
+	if _, err := s.doc().Set(ctx, state); err != nil {
+		return nil, fmt.Errorf("error saving maintenance state: %v", err)
+	}
+	log.Printf("Maintenance set by %s: enabled %t, queue paused %t", updatedBy, state.Enabled, state.PauseQueue)
+
+	s.mu.Lock()
+	s.state, s.fetchedAt = &state, time.Now()
+	s.mu.Unlock()
+	return &state, nil
+}
@@ -0,0 +1,24 @@
+package maintenance
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientMessage(t *testing.T) {
+	if message := (State{Enabled: true}).ClientMessage(); message != defaultMessage {
+		t.Fatalf("expected the default message, got %q", message)
+	}
+
+	eta := time.Now().Add(time.Hour).Truncate(time.Second)
+	message := State{Enabled: true, Message: "Gemini is down", ETA: eta.Unix()}.ClientMessage()
+	if !strings.HasPrefix(message, "Gemini is down") || !strings.Contains(message, eta.UTC().Format(time.RFC3339)) {
+		t.Fatalf("expected the message with its ETA, got %q", message)
+	}
+
+	past := State{Enabled: true, Message: "Deploying", ETA: time.Now().Add(-time.Minute).Unix()}
+	if message := past.ClientMessage(); message != "Deploying" {
+		t.Fatalf("expected a past ETA to be left out, got %q", message)
+	}
+}
@@ -0,0 +1,209 @@
+package preflight
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/billing"
+)
+
+const (
+	// baseSeconds is the time every job takes regardless of its files, for
+	// planning the deck and rendering it with Marp
+	baseSeconds = 30
+
+	// tokensPerSecond is roughly how fast Gemini works through the documents
+	tokensPerSecond = 1500
+
+	// maxInflatedBytes bounds the decompressed streams scanned in a PDF
+	maxInflatedBytes = 32 << 20
+)
+
+var (
+	// pageObjectPattern matches the dictionary of a page in a PDF, but not
+	// the page tree nodes of type Pages
+	pageObjectPattern = regexp.MustCompile(`/Type\s*/Page(?:[^s]|$)`)
+
+	// streamPattern matches the start of a stream in a PDF
+	streamPattern = regexp.MustCompile(`stream\r?\n`)
+)
+
+// FileReport is the outcome of the checks on one file
+type FileReport struct {
+	Filename        string   `json:"filename"`
+	Type            string   `json:"type,omitempty"` // Detected MIME type, empty for unsupported files
+	Size            int      `json:"size"`
+	Pages           int      `json:"pages,omitempty"` // Pages of a PDF, when they can be counted
+	Extractable     bool     `json:"extractable"`     // Whether the text of the file can be read
+	EstimatedTokens int      `json:"estimatedTokens"`
+	Problems        []string `json:"problems,omitempty"` // Reasons the file would fail or be left out of a job
+	Warnings        []string `json:"warnings,omitempty"` // Reasons the deck may come out worse than expected
+}
+
+// Report is the outcome of the checks on the files of a prospective job
+type Report struct {
+	Valid            bool         `json:"valid"`              // Whether a job with these files would be accepted
+	Problems         []string     `json:"problems,omitempty"` // Reasons the job would be refused, besides those of its files
+	Files            []FileReport `json:"files"`
+	EstimatedTokens  int          `json:"estimatedTokens"`
+	EstimatedSeconds int          `json:"estimatedSeconds"` // Rough processing time once the job starts
+	Cost             Cost         `json:"cost"`
+}
+
+// Cost is what a job counts against the monthly allowance of the plan
+type Cost struct {
+	Plan            string `json:"plan"`
+	Jobs            int    `json:"jobs"`
+	Tokens          int    `json:"tokens"`
+	RemainingJobs   *int   `json:"remainingJobs,omitempty"`   // Left this month after the job, unset on plans without an allowance
+	RemainingTokens *int   `json:"remainingTokens,omitempty"` // Left this month after the job, unset on plans without an allowance
+}
+
+// DetectType returns the MIME type of an uploaded file from its content and
+// extension, and whether the file is of a supported type: PDF, Markdown, TXT,
+// and WebVTT or SRT transcripts
+func DetectType(filename string, data []byte) (string, bool) {
+	// Detect MIME type from file content instead of using header
+	// DetectContentType only needs the first 512 bytes
+	mimeType := http.DetectContentType(data)
+
+	// Remove charset information if present
+	if semicolonIndex := strings.Index(mimeType, ";"); semicolonIndex != -1 {
+		mimeType = strings.TrimSpace(mimeType[:semicolonIndex])
+	}
+
+	// Check by file extension first
+	fileExt := strings.ToLower(filepath.Ext(filename))
+	isTranscript := fileExt == ".vtt" || fileExt == ".srt"
+	if isTranscript && (strings.HasPrefix(mimeType, "text/") || mimeType == "application/x-subrip" || mimeType == "application/octet-stream") {
+		// Subtitle types vary between systems, trust the extension
+		return mimeType, true
+	}
+	if fileExt != ".pdf" && fileExt != ".md" && fileExt != ".txt" {
+		return mimeType, false
+	}
+	if mimeType == "application/pdf" {
+		return mimeType, true
+	}
+	// Some systems detect markdown as text/markdown, text/x-markdown, or just
+	// text/plain, for text files the extension is trusted more than the type
+	if strings.HasPrefix(mimeType, "text/") || strings.Contains(mimeType, "markdown") {
+		return mimeType, fileExt == ".md" || fileExt == ".txt"
+	}
+	return mimeType, false
+}
+
+// Check inspects files before a job is created from them. Files with an empty
+// type are reported as unsupported.
+func Check(files []models.File, plan billing.Plan) *Report {
+	report := &Report{Valid: len(files) > 0, Files: make([]FileReport, 0, len(files))}
+	for _, file := range files {
+		fileReport := inspect(file, plan)
+		if len(fileReport.Problems) > 0 {
+			report.Valid = false
+		}
+		report.EstimatedTokens += fileReport.EstimatedTokens
+		report.Files = append(report.Files, fileReport)
+	}
+	report.EstimatedSeconds = baseSeconds + report.EstimatedTokens/tokensPerSecond
+	report.Cost = Cost{Plan: plan.ID, Jobs: 1, Tokens: report.EstimatedTokens}
+	return report
+}
+
+// ApplyUsage sets the allowance of the plan left after the job from the usage
+// of the current month, and refuses the job if it would exceed the allowance
+func (r *Report) ApplyUsage(usage *billing.Usage) {
+	if usage.Plan.MonthlyJobs == 0 {
+		return
+	}
+	jobs := usage.Plan.MonthlyJobs - usage.Jobs - r.Cost.Jobs
+	tokens := usage.Plan.MonthlyTokens - usage.Tokens - r.Cost.Tokens
+	r.Cost.RemainingJobs, r.Cost.RemainingTokens = &jobs, &tokens
+	if jobs < 0 || tokens < 0 {
+		r.Valid = false
+		r.Problems = append(r.Problems, fmt.Sprintf("The job would exceed the monthly allowance of the %s plan", usage.Plan.Name))
+	}
+}
+
+// inspect checks the type, size and text of a file
+func inspect(file models.File, plan billing.Plan) FileReport {
+	report := FileReport{Filename: file.Filename, Type: file.Type, Size: len(file.Data)}
+	if file.Type == "" {
+		report.Problems = append(report.Problems, "Unsupported file type, only PDF, Markdown, TXT, VTT and SRT files are allowed")
+		return report
+	}
+	report.EstimatedTokens = billing.EstimateTokens([]models.File{file})
+	if plan.MaxFileBytes > 0 && len(file.Data) > plan.MaxFileBytes {
+		report.Problems = append(report.Problems, fmt.Sprintf("Larger than the %d MB allowed by the %s plan", plan.MaxFileBytes>>20, plan.Name))
+	}
+
+	if file.Type != "application/pdf" {
+		report.Extractable = utf8.Valid(file.Data) && len(bytes.TrimSpace(file.Data)) > 0
+		if !report.Extractable {
+			report.Problems = append(report.Problems, "The file is empty or isn't UTF-8 text")
+		}
+		return report
+	}
+
+	pdf := scanPDF(file.Data)
+	report.Pages = pdf.pages
+	report.Extractable = pdf.fonts && !pdf.encrypted
+	switch {
+	case pdf.encrypted:
+		report.Problems = append(report.Problems, "The PDF is encrypted, remove its password before uploading it")
+	case !pdf.fonts:
+		// Gemini still reads the pages as images, but the text can't be
+		// extracted when the upload to Gemini fails or the file is too long
+		report.Warnings = append(report.Warnings, "The PDF has no text layer and looks scanned, its text can only be read from the page images")
+	}
+	return report
+}
+
+// pdfScan is what a PDF reveals without being parsed fully
+type pdfScan struct {
+	pages     int
+	fonts     bool // Whether the PDF uses fonts, which scanned documents don't
+	encrypted bool
+}
+
+// scanPDF counts the pages of a PDF and looks for its fonts, in the file and
+// in its compressed object streams
+func scanPDF(data []byte) pdfScan {
+	var scan pdfScan
+	inflated := 0
+	sections := [][]byte{data}
+	for _, loc := range streamPattern.FindAllIndex(data, -1) {
+		if inflated >= maxInflatedBytes {
+			break
+		}
+		end := bytes.Index(data[loc[1]:], []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		r, err := zlib.NewReader(bytes.NewReader(data[loc[1] : loc[1]+end]))
+		if err != nil {
+			continue
+		}
+		// Streams cut short by the limit still hold the objects before it
+		content, _ := io.ReadAll(io.LimitReader(r, int64(maxInflatedBytes-inflated)))
+		r.Close()
+		inflated += len(content)
+		sections = append(sections, content)
+	}
+
+	for _, section := range sections {
+		scan.pages += len(pageObjectPattern.FindAll(section, -1))
+		scan.fonts = scan.fonts || bytes.Contains(section, []byte("/Font"))
+	}
+	// The encryption dictionary is named by the trailer, which is never compressed
+	scan.encrypted = bytes.Contains(data, []byte("/Encrypt"))
+	return scan
+}
@@ -0,0 +1,129 @@
+package preflight
+
+import (
+	"bytes"
+	"compress/zlib"
+	"strings"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/billing"
+)
+
+// testPDF builds a PDF with the given objects, compressing them into an
+// object stream when compressed is set
+func testPDF(objects string, compressed bool) []byte {
+	if !compressed {
+		return []byte("%PDF-1.4\n" + objects + "\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	}
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(objects))
+	w.Close()
+	return []byte("%PDF-1.5\n5 0 obj\n<< /Type /ObjStm /Filter /FlateDecode >>\nstream\n" + buf.String() + "\nendstream\nendobj\n%%EOF\n")
+}
+
+func TestDetectType(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     string
+		mimeType string
+		allowed  bool
+	}{
+		{"paper.pdf", "%PDF-1.4\n", "application/pdf", true},
+		{"notes.md", "# Notes", "text/plain", true},
+		{"notes.txt", "Meeting notes", "text/plain", true},
+		{"talk.vtt", "WEBVTT\n\n00:00.000 --> 00:01.000\nHi", "text/plain", true},
+		{"notes.md", "%PDF-1.4\n", "application/pdf", true},
+		{"paper.pdf", "<html><body>Hi</body></html>", "text/html", false},
+		{"photo.png", "\x89PNG\r\n\x1a\n", "image/png", false},
+		{"slides.pptx", "PK\x03\x04", "application/zip", false},
+	}
+
+	for _, test := range tests {
+		mimeType, allowed := DetectType(test.filename, []byte(test.data))
+		if mimeType != test.mimeType || allowed != test.allowed {
+			t.Errorf("DetectType(%s): expected %s allowed=%t, got %s allowed=%t", test.filename, test.mimeType, test.allowed, mimeType, allowed)
+		}
+	}
+}
+
+func TestCheckInspectsPDFs(t *testing.T) {
+	pages := "1 0 obj\n<< /Type /Pages /Count 2 >>\nendobj\n" +
+		"2 0 obj\n<< /Type /Page /Resources << /Font << /F1 4 0 R >> >> >>\nendobj\n" +
+		"3 0 obj\n<< /Type/Page /Resources << /Font << /F1 4 0 R >> >> >>\nendobj\n"
+	scanned := "1 0 obj\n<< /Type /Pages /Count 1 >>\nendobj\n2 0 obj\n<< /Type /Page /Resources << /XObject << /Im1 4 0 R >> >> >>\nendobj\n"
+
+	tests := []struct {
+		name        string
+		data        []byte
+		pages       int
+		extractable bool
+		problems    int
+		warnings    int
+	}{
+		{"text.pdf", testPDF(pages, false), 2, true, 0, 0},
+		{"compressed.pdf", testPDF(pages, true), 2, true, 0, 0},
+		{"scanned.pdf", testPDF(scanned, false), 1, false, 0, 1},
+		{"encrypted.pdf", []byte("%PDF-1.4\ntrailer\n<< /Root 1 0 R /Encrypt 9 0 R >>\n%%EOF\n"), 0, false, 1, 0},
+	}
+
+	for _, test := range tests {
+		report := Check([]models.File{{Filename: test.name, Data: test.data, Type: "application/pdf"}}, billing.ProPlan)
+		file := report.Files[0]
+		if file.Pages != test.pages || file.Extractable != test.extractable || len(file.Problems) != test.problems || len(file.Warnings) != test.warnings {
+			t.Errorf("%s: expected %d pages, extractable=%t, %d problems and %d warnings, got %+v", test.name, test.pages, test.extractable, test.problems, test.warnings, file)
+		}
+	}
+}
+
+func TestCheckReportsProblems(t *testing.T) {
+	files := []models.File{
+		{Filename: "notes.md", Data: []byte(strings.Repeat("a", 4000)), Type: "text/plain"},
+		{Filename: "big.txt", Data: make([]byte, billing.FreePlan.MaxFileBytes+1), Type: "text/plain"},
+		{Filename: "empty.txt", Data: []byte("  \n"), Type: "text/plain"},
+		{Filename: "slides.pptx", Data: []byte("PK\x03\x04")},
+	}
+
+	report := Check(files, billing.FreePlan)
+	if report.Valid {
+		t.Fatal("expected the report to be invalid")
+	}
+	expected := []int{0, 1, 1, 1}
+	for i, file := range report.Files {
+		if len(file.Problems) != expected[i] {
+			t.Errorf("%s: expected %d problems, got %v", file.Filename, expected[i], file.Problems)
+		}
+	}
+	if report.Files[0].EstimatedTokens != 1000 {
+		t.Errorf("expected 1000 tokens for notes.md, got %d", report.Files[0].EstimatedTokens)
+	}
+	if report.Cost.Plan != "free" || report.Cost.Jobs != 1 || report.Cost.Tokens != report.EstimatedTokens {
+		t.Errorf("unexpected cost: %+v", report.Cost)
+	}
+
+	report = Check(files[:1], billing.FreePlan)
+	if !report.Valid || report.EstimatedSeconds != baseSeconds {
+		t.Errorf("expected a valid report taking %ds, got %+v", baseSeconds, report)
+	}
+}
+
+func TestApplyUsage(t *testing.T) {
+	report := Check([]models.File{{Filename: "notes.md", Data: []byte(strings.Repeat("a", 4000)), Type: "text/plain"}}, billing.FreePlan)
+
+	report.ApplyUsage(&billing.Usage{Plan: billing.FreePlan, Jobs: 5, Tokens: 1000})
+	if !report.Valid || *report.Cost.RemainingJobs != 14 || *report.Cost.RemainingTokens != 198_000 {
+		t.Fatalf("expected 14 jobs and 198000 tokens left, got %+v", report.Cost)
+	}
+
+	report.ApplyUsage(&billing.Usage{Plan: billing.FreePlan, Jobs: 20})
+	if report.Valid || len(report.Problems) != 1 {
+		t.Fatalf("expected the job to exceed the allowance, got %+v", report)
+	}
+
+	unlimited := Check(nil, billing.UnlimitedPlan)
+	unlimited.ApplyUsage(&billing.Usage{Plan: billing.UnlimitedPlan})
+	if unlimited.Cost.RemainingJobs != nil {
+		t.Fatalf("expected no allowance on the unlimited plan, got %+v", unlimited.Cost)
+	}
+}
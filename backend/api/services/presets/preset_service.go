@@ -0,0 +1,209 @@
+package presets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"github.com/martin226/slideitin/backend/api/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxPresets is the largest number of presets a user or workspace can save
+const maxPresets = 100
+
+var (
+	// ErrPresetNotFound is returned when a preset doesn't exist in the scope
+	ErrPresetNotFound = errors.New("preset not found")
+
+	// ErrTooManyPresets is returned when saving more presets than allowed
+	ErrTooManyPresets = fmt.Errorf("at most %d presets can be saved", maxPresets)
+)
+
+// FirestorePreset is the Firestore representation of a preset
+type FirestorePreset struct {
+	Scope     string               `firestore:"scope"`
+	Name      string               `firestore:"name"`
+	Theme     string               `firestore:"theme"`
+	Settings  models.SlideSettings `firestore:"settings"`
+	CreatedAt int64                `firestore:"createdAt"`
+	UpdatedAt int64                `firestore:"updatedAt"`
+}
+
+// Preset is a named set of slide settings saved for reuse
+type Preset struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	Theme     string               `json:"theme"`
+	Settings  models.SlideSettings `json:"settings"`
+	CreatedAt int64                `json:"createdAt"`
+	UpdatedAt int64                `json:"updatedAt"`
+}
+
+// Scope returns the scope presets are saved in: the workspace when there is
+// one, so its members share presets, and the owner otherwise
+func Scope(owner, workspaceID string) string {
+	if workspaceID != "" {
+		return "workspace:" + workspaceID
+	}
+	return owner
+}
+
+// ApplyTo fills in the theme and settings a request leaves empty with the
+// values of the preset, so settings sent with the request take precedence
+func (p *Preset) ApplyTo(req *models.SlideRequest) {
+	if req.Theme == "" {
+		req.Theme = p.Theme
+	}
+	settings := reflect.ValueOf(&req.Settings).Elem()
+	saved := reflect.ValueOf(p.Settings)
+	for i := 0; i < settings.NumField(); i++ {
+		if settings.Field(i).IsZero() {
+			settings.Field(i).Set(saved.Field(i))
+		}
+	}
+}
+
+// Service manages presets stored in Firestore
+type Service struct {
+	client *firestore.Client
+}
+
+// NewService creates a new preset service
+func NewService(client *firestore.Client) *Service {
+	return &Service{
+		client: client,
+	}
+}
+
+// Collection returns the Firestore collection reference for presets
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("presets")
+}
+
+// List returns the presets of a scope sorted by name
+func (s *Service) List(ctx context.Context, scope string) ([]Preset, error) {
+	docs, err := s.Collection().Where("scope", "==", scope).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing presets: %v", err)
+	}
+
+	presets := make([]Preset, 0, len(docs))
+	for _, doc := range docs {
+		var preset FirestorePreset
+		if err := doc.DataTo(&preset); err != nil {
+			return nil, fmt.Errorf("error parsing preset data: %v", err)
+		}
+		presets = append(presets, toPreset(doc.Ref.ID, preset))
+	}
+	sort.Slice(presets, func(i, j int) bool {
+		return presets[i].Name < presets[j].Name
+	})
+	return presets, nil
+}
+
+// Get returns a preset of a scope
+func (s *Service) Get(ctx context.Context, scope, id string) (*Preset, error) {
+	preset, err := s.get(ctx, scope, id)
+	if err != nil {
+		return nil, err
+	}
+	result := toPreset(id, *preset)
+	return &result, nil
+}
+
+// Create saves a new preset in a scope
+func (s *Service) Create(ctx context.Context, scope string, req models.PresetRequest) (*Preset, error) {
+	existing, err := s.List(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxPresets {
+		return nil, ErrTooManyPresets
+	}
+
+	now := time.Now().Unix()
+	id := uuid.New().String()
+	preset := FirestorePreset{
+		Scope:     scope,
+		Name:      req.Name,
+		Theme:     req.Theme,
+		Settings:  req.Settings,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := s.Collection().Doc(id).Set(ctx, preset); err != nil {
+		return nil, fmt.Errorf("error saving preset: %v", err)
+	}
+
+	result := toPreset(id, preset)
+	return &result, nil
+}
+
+// Update replaces the name, theme and settings of a preset
+func (s *Service) Update(ctx context.Context, scope, id string, req models.PresetRequest) (*Preset, error) {
+	preset, err := s.get(ctx, scope, id)
+	if err != nil {
+		return nil, err
+	}
+
+	preset.Name = req.Name
+	preset.Theme = req.Theme
+	preset.Settings = req.Settings
+	preset.UpdatedAt = time.Now().Unix()
+	if _, err := s.Collection().Doc(id).Set(ctx, preset); err != nil {
+		return nil, fmt.Errorf("error saving preset: %v", err)
+	}
+
+	result := toPreset(id, *preset)
+	return &result, nil
+}
+
+// Delete deletes a preset of a scope
+func (s *Service) Delete(ctx context.Context, scope, id string) error {
+	if _, err := s.get(ctx, scope, id); err != nil {
+		return err
+	}
+	if _, err := s.Collection().Doc(id).Delete(ctx); err != nil {
+		return fmt.Errorf("error deleting preset: %v", err)
+	}
+	return nil
+}
+
+// get returns the stored preset, or ErrPresetNotFound when it belongs to another scope
+func (s *Service) get(ctx context.Context, scope, id string) (*FirestorePreset, error) {
+	doc, err := s.Collection().Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrPresetNotFound
+		}
+		return nil, fmt.Errorf("error retrieving preset: %v", err)
+	}
+
+	var preset FirestorePreset
+	if err := doc.DataTo(&preset); err != nil {
+		return nil, fmt.Errorf("error parsing preset data: %v", err)
+	}
+	if preset.Scope != scope {
+		return nil, ErrPresetNotFound
+	}
+	return &preset, nil
+}
+
+// toPreset converts a stored preset to its API representation
+func toPreset(id string, preset FirestorePreset) Preset {
+	return Preset{
+		ID:        id,
+		Name:      preset.Name,
+		Theme:     preset.Theme,
+		Settings:  preset.Settings,
+		CreatedAt: preset.CreatedAt,
+		UpdatedAt: preset.UpdatedAt,
+	}
+}
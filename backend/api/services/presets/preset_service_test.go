@@ -0,0 +1,55 @@
+package presets
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+func TestScope(t *testing.T) {
+	if scope := Scope("key-1", ""); scope != "key-1" {
+		t.Errorf("expected the owner scope, got %q", scope)
+	}
+	if scope := Scope("key-1", "acme"); scope != "workspace:acme" {
+		t.Errorf("expected the workspace scope, got %q", scope)
+	}
+}
+
+func TestApplyToKeepsRequestSettings(t *testing.T) {
+	preset := &Preset{
+		Theme: "gaia",
+		Settings: models.SlideSettings{
+			SlideDetail:   "detailed",
+			Audience:      "academic",
+			IncludeAgenda: true,
+			Footer:        "CS101",
+		},
+	}
+
+	req := &models.SlideRequest{
+		PresetID: "preset-1",
+		Settings: models.SlideSettings{Audience: "executive", Watermark: "Draft"},
+	}
+	preset.ApplyTo(req)
+
+	if req.Theme != "gaia" {
+		t.Errorf("expected the preset theme, got %q", req.Theme)
+	}
+	want := models.SlideSettings{
+		SlideDetail:   "detailed",
+		Audience:      "executive",
+		IncludeAgenda: true,
+		Footer:        "CS101",
+		Watermark:     "Draft",
+	}
+	if !reflect.DeepEqual(req.Settings, want) {
+		t.Fatalf("expected %+v, got %+v", want, req.Settings)
+	}
+
+	req = &models.SlideRequest{Theme: "beam", PresetID: "preset-1"}
+	preset.ApplyTo(req)
+	if req.Theme != "beam" {
+		t.Errorf("expected the request theme to take precedence, got %q", req.Theme)
+	}
+}
@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/services/breaker"
+)
+
+// breakerJobStore fails the calls jobs are created and processed with fast
+// while Firestore is down, instead of letting each of them time out
+type breakerJobStore struct {
+	JobStore
+	breaker *breaker.Breaker
+}
+
+// newBreakerJobStore wraps a job store in the given breaker
+func newBreakerJobStore(store JobStore, b *breaker.Breaker) *breakerJobStore {
+	return &breakerJobStore{JobStore: store, breaker: b}
+}
+
+func (s *breakerJobStore) CreateJob(ctx context.Context, job FirestoreJob) error {
+	if err := s.breaker.Allow(); err != nil {
+		return err
+	}
+	err := s.JobStore.CreateJob(ctx, job)
+	s.breaker.Record(ctx, err)
+	return err
+}
+
+func (s *breakerJobStore) GetJob(ctx context.Context, id string) (*FirestoreJob, error) {
+	if err := s.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	job, err := s.JobStore.GetJob(ctx, id)
+	s.breaker.Record(ctx, err)
+	return job, err
+}
+
+func (s *breakerJobStore) UpdateJob(ctx context.Context, id string, fields map[string]interface{}) error {
+	if err := s.breaker.Allow(); err != nil {
+		return err
+	}
+	err := s.JobStore.UpdateJob(ctx, id, fields)
+	s.breaker.Record(ctx, err)
+	return err
+}
+
+func (s *breakerJobStore) TransitionJob(ctx context.Context, id string, status JobStatus, fields map[string]interface{}) error {
+	if err := s.breaker.Allow(); err != nil {
+		return err
+	}
+	err := s.JobStore.TransitionJob(ctx, id, status, fields)
+	s.breaker.Record(ctx, err)
+	return err
+}
+
+func (s *breakerJobStore) ListJobs(ctx context.Context, query JobQuery) ([]FirestoreJob, error) {
+	if err := s.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	jobs, err := s.JobStore.ListJobs(ctx, query)
+	s.breaker.Record(ctx, err)
+	return jobs, err
+}
+
+func (s *breakerJobStore) ClaimIdempotencyKey(ctx context.Context, key, jobID string, expiresAt int64) (string, error) {
+	if err := s.breaker.Allow(); err != nil {
+		return "", err
+	}
+	holder, err := s.JobStore.ClaimIdempotencyKey(ctx, key, jobID, expiresAt)
+	s.breaker.Record(ctx, err)
+	return holder, err
+}
+
+// breakerBlobStore fails the calls to the blob store fast while Cloud Storage
+// is down
+type breakerBlobStore struct {
+	store   BlobStore
+	breaker *breaker.Breaker
+}
+
+// newBreakerBlobStore wraps a blob store in the given breaker
+func newBreakerBlobStore(store BlobStore, b *breaker.Breaker) *breakerBlobStore {
+	return &breakerBlobStore{store: store, breaker: b}
+}
+
+func (s *breakerBlobStore) Upload(ctx context.Context, path, contentType string, data []byte) error {
+	if err := s.breaker.Allow(); err != nil {
+		return err
+	}
+	err := s.store.Upload(ctx, path, contentType, data)
+	s.breaker.Record(ctx, err)
+	return err
+}
+
+func (s *breakerBlobStore) UploadedAt(ctx context.Context, path string) (time.Time, error) {
+	if err := s.breaker.Allow(); err != nil {
+		return time.Time{}, err
+	}
+	uploadedAt, err := s.store.UploadedAt(ctx, path)
+	s.breaker.Record(ctx, err)
+	return uploadedAt, err
+}
+
+func (s *breakerBlobStore) List(ctx context.Context, prefix string) ([]BlobObject, error) {
+	if err := s.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	objects, err := s.store.List(ctx, prefix)
+	s.breaker.Record(ctx, err)
+	return objects, err
+}
+
+func (s *breakerBlobStore) Delete(ctx context.Context, path string) error {
+	if err := s.breaker.Allow(); err != nil {
+		return err
+	}
+	err := s.store.Delete(ctx, path)
+	s.breaker.Record(ctx, err)
+	return err
+}
+
+// Open only counts opening the file, reading it is left to the caller
+func (s *breakerBlobStore) Open(ctx context.Context, path string) (io.ReadSeekCloser, *BlobObject, error) {
+	if err := s.breaker.Allow(); err != nil {
+		return nil, nil, err
+	}
+	reader, object, err := s.store.Open(ctx, path)
+	s.breaker.Record(ctx, err)
+	return reader, object, err
+}
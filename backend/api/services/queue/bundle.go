@@ -0,0 +1,285 @@
+package queue
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/services/revisions"
+)
+
+const (
+	// maxBundleImages bounds the images fetched into a bundle
+	maxBundleImages = 50
+
+	// maxBundleImageBytes bounds each image fetched into a bundle
+	maxBundleImageBytes = 5 << 20
+
+	// bundleImageTimeout bounds fetching each image of a bundle
+	bundleImageTimeout = 15 * time.Second
+)
+
+var (
+	// markdownImagePattern matches the URL of an external markdown image,
+	// including Marp backgrounds such as ![bg right](https://...)
+	markdownImagePattern = regexp.MustCompile(`(!\[[^\]]*\]\(\s*)((?:https?:)?//[^)\s]+)`)
+
+	// htmlImagePattern matches the URL of an external image in inline HTML
+	htmlImagePattern = regexp.MustCompile(`(<img\s[^>]*src=["'])((?:https?:)?//[^"']+)`)
+
+	// imageExtensions are the file extensions of the images saved in a bundle
+	imageExtensions = map[string]string{
+		"image/png":     ".png",
+		"image/jpeg":    ".jpg",
+		"image/gif":     ".gif",
+		"image/webp":    ".webp",
+		"image/svg+xml": ".svg",
+		"image/avif":    ".avif",
+	}
+)
+
+// assetFetcher returns the contents and content type of an external resource
+type assetFetcher func(ctx context.Context, url string) ([]byte, string, error)
+
+// Bundle is the ZIP archive of a result for offline editing: the markdown of
+// the latest revision of the deck with its images, the PDF, the HTML, the
+// speaker notes, and the flashcards, executive summary, transcript alignment,
+// chunk summaries, grounding report and generation warnings when the deck has
+// them. It must be closed.
+type Bundle struct {
+	markdown  string
+	created   time.Time
+	warnings  []string
+	documents []bundleDocument
+	fetch     assetFetcher
+}
+
+// bundleDocument is a document of a result added to a bundle as is
+type bundleDocument struct {
+	name string
+	*ResultFile
+}
+
+// OpenBundle opens the documents of a result to bundle them. Only results of
+// decks have their markdown kept, so ephemeral results can't be bundled.
+func (s *Service) OpenBundle(ctx context.Context, result *FirestoreResult) (*Bundle, error) {
+	deck, err := s.GetDeck(ctx, result.ID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("no markdown for this result")
+	}
+	if err != nil {
+		return nil, err
+	}
+	revision, err := s.GetRevision(ctx, deck.ID, deck.Revision)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{
+		markdown: revision.Markdown,
+		created:  time.Unix(revision.CreatedAt, 0),
+		warnings: result.Warnings,
+		fetch:    fetchAsset,
+	}
+	documents := []struct {
+		name   string
+		format ResultFormat
+		stored bool
+	}{
+		{"presentation.pdf", ResultPDF, true},
+		{"presentation.html", ResultHTML, true},
+		{"flashcards.csv", ResultFlashcards, result.FlashcardsPath != ""},
+		{"one-pager.pdf", ResultOnePager, result.OnePagerPath != ""},
+		{"one-pager.md", ResultOnePagerMarkdown, result.OnePagerMarkdownPath != ""},
+		{"handout.pdf", ResultHandout, result.HandoutPath != ""},
+		{"handout.md", ResultHandoutMarkdown, result.HandoutMarkdownPath != ""},
+		{"alignment.json", ResultAlignment, result.AlignmentPath != ""},
+		{"chunk-summaries.json", ResultChunkSummaries, result.ChunkSummariesPath != ""},
+		{"grounding.json", ResultGrounding, result.GroundingPath != ""},
+	}
+	for _, document := range documents {
+		if !document.stored {
+			continue
+		}
+		file, err := s.OpenResultFile(ctx, result, document.format)
+		if err != nil {
+			bundle.Close()
+			return nil, err
+		}
+		bundle.documents = append(bundle.documents, bundleDocument{name: document.name, ResultFile: file})
+	}
+	return bundle, nil
+}
+
+// Write writes the bundle to w as a ZIP archive. External images are saved
+// under images/ and the markdown links to them there, images that can't be
+// fetched keep their URL.
+func (b *Bundle) Write(ctx context.Context, w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	markdown, err := b.writeImages(ctx, archive)
+	if err != nil {
+		return err
+	}
+	if err := b.writeFile(archive, "presentation.md", b.created, strings.NewReader(markdown)); err != nil {
+		return err
+	}
+	if notes := revisions.SpeakerNotes(markdown); len(notes) > 0 {
+		if err := b.writeFile(archive, "notes.md", b.created, strings.NewReader(formatNotes(notes))); err != nil {
+			return err
+		}
+	}
+	if len(b.warnings) > 0 {
+		if err := b.writeFile(archive, "warnings.txt", b.created, strings.NewReader(strings.Join(b.warnings, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	for _, document := range b.documents {
+		if err := b.writeFile(archive, document.name, document.ModTime, document); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// Close closes the documents of the bundle
+func (b *Bundle) Close() error {
+	for _, document := range b.documents {
+		document.Close()
+	}
+	return nil
+}
+
+// writeImages saves the external images of the markdown in the archive and
+// returns the markdown linking to them
+func (b *Bundle) writeImages(ctx context.Context, archive *zip.Writer) (string, error) {
+	saved := make(map[string]string)
+	images := 0
+	var writeErr error
+	save := func(url string) string {
+		if name, ok := saved[url]; ok {
+			return name
+		}
+		saved[url] = url
+		if writeErr != nil || images == maxBundleImages {
+			return url
+		}
+
+		data, contentType, err := b.fetch(ctx, absoluteURL(url))
+		if err == nil && !strings.HasPrefix(contentType, "image/") {
+			err = fmt.Errorf("unexpected content type %q", contentType)
+		}
+		if err != nil {
+			log.Printf("Leaving %s out of the bundle: %v", url, err)
+			return url
+		}
+		images++
+		name := fmt.Sprintf("images/%02d%s", images, imageExtension(url, contentType))
+		if err := b.writeFile(archive, name, b.created, bytes.NewReader(data)); err != nil {
+			writeErr = err
+			return url
+		}
+		saved[url] = name
+		return name
+	}
+
+	markdown := b.markdown
+	for _, pattern := range []*regexp.Regexp{markdownImagePattern, htmlImagePattern} {
+		markdown = pattern.ReplaceAllStringFunc(markdown, func(match string) string {
+			groups := pattern.FindStringSubmatch(match)
+			return groups[1] + save(groups[2])
+		})
+	}
+	return markdown, writeErr
+}
+
+// writeFile adds a file to the archive
+func (b *Bundle) writeFile(archive *zip.Writer, name string, modified time.Time, r io.Reader) error {
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return fmt.Errorf("error adding %s to the bundle: %v", name, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("error adding %s to the bundle: %v", name, err)
+	}
+	return nil
+}
+
+// formatNotes formats the speaker notes of a deck as markdown
+func formatNotes(notes []revisions.SlideNotes) string {
+	var sb strings.Builder
+	sb.WriteString("# Speaker notes\n")
+	for _, slide := range notes {
+		if slide.Title != "" {
+			fmt.Fprintf(&sb, "\n## Slide %d: %s\n\n%s\n", slide.Slide, slide.Title, slide.Notes)
+		} else {
+			fmt.Fprintf(&sb, "\n## Slide %d\n\n%s\n", slide.Slide, slide.Notes)
+		}
+	}
+	return sb.String()
+}
+
+// imageExtension returns the file extension of an image, from its content
+// type or else its URL
+func imageExtension(url, contentType string) string {
+	if ext, ok := imageExtensions[contentType]; ok {
+		return ext
+	}
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	if ext := path.Ext(url); len(ext) > 1 && len(ext) <= 5 {
+		return strings.ToLower(ext)
+	}
+	return ""
+}
+
+// absoluteURL gives protocol-relative URLs the https scheme
+func absoluteURL(url string) string {
+	if strings.HasPrefix(url, "//") {
+		return "https:" + url
+	}
+	return url
+}
+
+// bundleHTTPClient fetches the images saved in bundles
+var bundleHTTPClient = &http.Client{Timeout: bundleImageTimeout}
+
+// fetchAsset downloads an image to save in a bundle
+func fetchAsset(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := bundleHTTPClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleImageBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxBundleImageBytes {
+		return nil, "", fmt.Errorf("larger than %d bytes", maxBundleImageBytes)
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return data, contentType, nil
+}
@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// contentRetention is how long files stored by content are kept, long
+	// enough for every job reusing one within the reuse window to finish
+	contentRetention = 2 * contentReuseWindow
+
+	// abandonedJobAge is how long a queued or processing job can go without an
+	// update before its files are treated as leaked by a crashed attempt. Failed
+	// jobs keep their files as long, as Cloud Tasks retries them meanwhile.
+	abandonedJobAge = 24 * time.Hour
+)
+
+// CleanupReport summarizes a run of CleanupFiles
+type CleanupReport struct {
+	Scanned        int   `json:"scanned"`
+	Deleted        int   `json:"deleted"`
+	Failed         int   `json:"failed"`
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+}
+
+// CleanupFiles deletes the uploaded files no job needs anymore: files of jobs
+// that finished, expired or were abandoned, and files stored by content past
+// their retention. Files are otherwise only deleted when a job succeeds, so
+// failures and crashes leave them behind. It also deletes the documents of
+// results that expired or were purged without being read, and the inputs
+// kept for replaying debugged jobs once their capture expired. The buckets of
+// every region are cleaned up.
+func (s *Service) CleanupFiles(ctx context.Context, now time.Time) (*CleanupReport, error) {
+	report := &CleanupReport{}
+	if err := s.cleanupFiles(ctx, s.blobs, now, report); err != nil {
+		return nil, err
+	}
+	for id, regional := range s.regions {
+		if err := s.cleanupFiles(ctx, regional.blobs, now, report); err != nil {
+			return nil, fmt.Errorf("region %s: %v", id, err)
+		}
+	}
+
+	log.Printf("Cleaned up %d of %d files, reclaiming %d bytes", report.Deleted, report.Scanned, report.ReclaimedBytes)
+	return report, nil
+}
+
+// cleanupFiles deletes the files no job needs anymore from the blob store of
+// a region, and adds them to the report. The jobs of the files are looked up
+// in the region their ID is prefixed with.
+func (s *Service) cleanupFiles(ctx context.Context, blobs BlobStore, now time.Time, report *CleanupReport) error {
+	objects, err := blobs.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list files: %v", err)
+	}
+
+	unused := make(map[string]bool)
+	for _, object := range objects {
+		report.Scanned++
+
+		var remove bool
+		if strings.HasPrefix(object.Path, "themes/") || strings.HasPrefix(object.Path, "fonts/") || strings.HasPrefix(object.Path, "library/") {
+			// Stylesheets of contributed themes, and fonts and documents
+			// uploaded to workspaces, are kept until they are deleted
+			continue
+		} else if strings.HasPrefix(object.Path, "content/") {
+			remove = now.Sub(object.UpdatedAt) >= contentRetention
+		} else if rest, ok := strings.CutPrefix(object.Path, "results/"); ok {
+			jobID, _, _ := strings.Cut(rest, "/")
+			remove, err = s.resultDone(ctx, jobID, object, now)
+			if err != nil {
+				log.Printf("Failed to check result %s for cleanup: %v", jobID, err)
+				report.Failed++
+				continue
+			}
+		} else if rest, ok := strings.CutPrefix(object.Path, "captures/"); ok {
+			jobID, _, _ := strings.Cut(rest, "/")
+			remove, err = s.captureDone(ctx, jobID, object, now)
+			if err != nil {
+				log.Printf("Failed to check capture %s for cleanup: %v", jobID, err)
+				report.Failed++
+				continue
+			}
+		} else {
+			jobID, _, _ := strings.Cut(object.Path, "/")
+			done, checked := unused[jobID]
+			if !checked {
+				done, err = s.jobDone(ctx, jobID, now)
+				if err != nil {
+					log.Printf("Failed to check job %s for cleanup: %v", jobID, err)
+					report.Failed++
+					continue
+				}
+				unused[jobID] = done
+			}
+			remove = done
+		}
+		if !remove {
+			continue
+		}
+
+		if err := blobs.Delete(ctx, object.Path); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Failed to delete file %s: %v", object.Path, err)
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+		report.ReclaimedBytes += object.Size
+	}
+	return nil
+}
+
+// jobDone reports whether a job no longer needs its uploaded files
+func (s *Service) jobDone(ctx context.Context, id string, now time.Time) (bool, error) {
+	job, err := s.in(id).jobs.GetJob(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case job.ExpiresAt > 0 && now.Unix() > job.ExpiresAt:
+		return true, nil
+	case JobStatus(job.Status) == StatusFailed:
+		// A retry of the task can still pick the job up again, so its files
+		// are only reclaimed once it stopped being retried
+		return now.Sub(time.Unix(job.UpdatedAt, 0)) >= abandonedJobAge, nil
+	case JobStatus(job.Status).Terminal():
+		return true, nil
+	default:
+		return now.Sub(time.Unix(job.UpdatedAt, 0)) >= abandonedJobAge, nil
+	}
+}
+
+// resultDone reports whether a result no longer needs a document stored for
+// it. A document without a result is kept for a while, as the slides service
+// stores the documents before the result.
+func (s *Service) resultDone(ctx context.Context, id string, object BlobObject, now time.Time) (bool, error) {
+	result, err := s.in(id).jobs.GetResult(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return now.Sub(object.UpdatedAt) >= abandonedJobAge, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.ExpiresAt > 0 && now.Unix() > result.ExpiresAt, nil
+}
+
+// captureDone reports whether the inputs kept for replaying a debugged job are
+// no longer needed. Inputs without a capture are kept for a while, as the
+// slides service stores them before the capture.
+func (s *Service) captureDone(ctx context.Context, id string, object BlobObject, now time.Time) (bool, error) {
+	capture, err := s.in(id).jobs.GetCapture(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return now.Sub(object.UpdatedAt) >= abandonedJobAge, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return capture.ExpiresAt > 0 && now.Unix() > capture.ExpiresAt, nil
+}
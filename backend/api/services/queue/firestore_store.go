@@ -0,0 +1,394 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/services/encryption"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreJobStore is a JobStore backed by Firestore
+type FirestoreJobStore struct {
+	client *firestore.Client
+	keys   encryption.KeyWrapper // Unwraps the data keys of encrypted results
+}
+
+// NewFirestoreJobStore creates a new Firestore job store, whose encrypted
+// results are decrypted with data keys unwrapped by keys
+func NewFirestoreJobStore(client *firestore.Client, keys encryption.KeyWrapper) *FirestoreJobStore {
+	return &FirestoreJobStore{
+		client: client,
+		keys:   keys,
+	}
+}
+
+// Collection returns the Firestore collection reference for jobs
+func (s *FirestoreJobStore) Collection() *firestore.CollectionRef {
+	return s.client.Collection("jobs")
+}
+
+// ResultsCollection returns the Firestore collection reference for results
+func (s *FirestoreJobStore) ResultsCollection() *firestore.CollectionRef {
+	return s.client.Collection("results")
+}
+
+// CreateJob stores a new job
+func (s *FirestoreJobStore) CreateJob(ctx context.Context, job FirestoreJob) error {
+	if job.ExpiresAt > 0 {
+		job.DeleteAt = time.Unix(job.ExpiresAt, 0)
+	}
+	_, err := s.Collection().Doc(job.ID).Set(ctx, job)
+	return err
+}
+
+// GetJob returns a job, or ErrNotFound
+func (s *FirestoreJobStore) GetJob(ctx context.Context, id string) (*FirestoreJob, error) {
+	doc, err := s.Collection().Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var job FirestoreJob
+	if err := doc.DataTo(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// UpdateJob sets the given fields on a job
+func (s *FirestoreJobStore) UpdateJob(ctx context.Context, id string, fields map[string]interface{}) error {
+	_, err := s.Collection().Doc(id).Update(ctx, toUpdates(fields))
+	return err
+}
+
+// TransitionJob atomically moves a job to a status along with the given fields
+func (s *FirestoreJobStore) TransitionJob(ctx context.Context, id string, to JobStatus, fields map[string]interface{}) error {
+	ref := s.Collection().Doc(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrNotFound
+			}
+			return err
+		}
+		var job FirestoreJob
+		if err := doc.DataTo(&job); err != nil {
+			return err
+		}
+		if from := JobStatus(job.Status); !from.CanTransition(to) {
+			return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, from, to)
+		}
+		return tx.Update(ref, append(toUpdates(fields), firestore.Update{Path: "status", Value: string(to)}))
+	})
+}
+
+// DeleteJob deletes a job
+func (s *FirestoreJobStore) DeleteJob(ctx context.Context, id string) error {
+	_, err := s.Collection().Doc(id).Delete(ctx)
+	return err
+}
+
+// ListJobs returns the jobs matching a query
+func (s *FirestoreJobStore) ListJobs(ctx context.Context, jobQuery JobQuery) ([]FirestoreJob, error) {
+	// Equality filters only, so no composite index is needed
+	query := s.Collection().Query
+	if jobQuery.WorkspaceID != "" {
+		query = query.Where("workspaceId", "==", jobQuery.WorkspaceID)
+	} else {
+		query = query.Where("owner", "==", jobQuery.Owner)
+	}
+	for key, value := range jobQuery.Labels {
+		query = query.WherePath(firestore.FieldPath{"labels", key}, "==", value)
+	}
+
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]FirestoreJob, 0, len(docs))
+	for _, doc := range docs {
+		var job FirestoreJob
+		if err := doc.DataTo(&job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// WatchJob returns a watcher backed by a Firestore snapshot listener
+func (s *FirestoreJobStore) WatchJob(ctx context.Context, id string) JobWatcher {
+	return &firestoreJobWatcher{
+		snapshots: s.Collection().Doc(id).Snapshots(ctx),
+	}
+}
+
+// IdempotencyKeysCollection returns the Firestore collection reference for idempotency keys
+func (s *FirestoreJobStore) IdempotencyKeysCollection() *firestore.CollectionRef {
+	return s.client.Collection("idempotencyKeys")
+}
+
+// firestoreIdempotencyKey is the Firestore representation of a claimed idempotency key
+type firestoreIdempotencyKey struct {
+	JobID     string    `firestore:"jobId"`
+	ExpiresAt int64     `firestore:"expiresAt"`
+	DeleteAt  time.Time `firestore:"deleteAt"` // Same as ExpiresAt, for the Firestore TTL policy
+}
+
+// ClaimIdempotencyKey atomically assigns an idempotency key to a job when it
+// is unclaimed, expired or held by a deleted job, and returns the ID of the
+// job holding the key
+func (s *FirestoreJobStore) ClaimIdempotencyKey(ctx context.Context, key, jobID string, expiresAt int64) (string, error) {
+	ref := s.IdempotencyKeysCollection().Doc(key)
+	holder := jobID
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		holder = jobID
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var claimed firestoreIdempotencyKey
+			if err := doc.DataTo(&claimed); err != nil {
+				return err
+			}
+			if claimed.ExpiresAt > time.Now().Unix() {
+				_, err := tx.Get(s.Collection().Doc(claimed.JobID))
+				if err == nil {
+					holder = claimed.JobID
+					return nil
+				}
+				if status.Code(err) != codes.NotFound {
+					return err
+				}
+			}
+		}
+		return tx.Set(ref, firestoreIdempotencyKey{JobID: jobID, ExpiresAt: expiresAt, DeleteAt: time.Unix(expiresAt, 0)})
+	})
+	if err != nil {
+		return "", err
+	}
+	return holder, nil
+}
+
+// ReleaseIdempotencyKey frees an idempotency key
+func (s *FirestoreJobStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := s.IdempotencyKeysCollection().Doc(key).Delete(ctx)
+	return err
+}
+
+// GetResult returns the result of a job, or ErrNotFound
+func (s *FirestoreJobStore) GetResult(ctx context.Context, id string) (*FirestoreResult, error) {
+	doc, err := s.ResultsCollection().Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var result FirestoreResult
+	if err := doc.DataTo(&result); err != nil {
+		return nil, err
+	}
+	if err := s.decryptResult(ctx, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UpdateResult sets the given fields on a result
+func (s *FirestoreJobStore) UpdateResult(ctx context.Context, id string, fields map[string]interface{}) error {
+	_, err := s.ResultsCollection().Doc(id).Update(ctx, toUpdates(fields))
+	return err
+}
+
+// RecordResultAccess atomically counts a download of a result at
+// accessedAt, and pushes back its expiry to expiresAt unless it is 0
+func (s *FirestoreJobStore) RecordResultAccess(ctx context.Context, id string, accessedAt, expiresAt int64) error {
+	fields := map[string]interface{}{
+		"downloads":      firestore.Increment(1),
+		"lastAccessedAt": accessedAt,
+	}
+	if expiresAt > 0 {
+		fields["expiresAt"] = expiresAt
+	}
+	_, err := s.ResultsCollection().Doc(id).Update(ctx, toUpdates(fields))
+	return err
+}
+
+// DeleteResult deletes the result of a job
+func (s *FirestoreJobStore) DeleteResult(ctx context.Context, id string) error {
+	_, err := s.ResultsCollection().Doc(id).Delete(ctx)
+	return err
+}
+
+// TakeResult atomically returns and deletes the result of a job, or ErrNotFound
+func (s *FirestoreJobStore) TakeResult(ctx context.Context, id string) (*FirestoreResult, error) {
+	ref := s.ResultsCollection().Doc(id)
+	var result FirestoreResult
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrNotFound
+			}
+			return err
+		}
+		if err := doc.DataTo(&result); err != nil {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.decryptResult(ctx, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// decryptResult decrypts the documents of a result the slides service
+// encrypted, leaving unencrypted results as they are
+func (s *FirestoreJobStore) decryptResult(ctx context.Context, result *FirestoreResult) error {
+	if len(result.WrappedKey) == 0 {
+		return nil
+	}
+	if s.keys == nil {
+		return fmt.Errorf("result %s is encrypted but no key service is configured", result.ID)
+	}
+	key, err := s.keys.Unwrap(ctx, result.KeyName, result.WrappedKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt result: %v", err)
+	}
+	fields := map[string]*[]byte{
+		"pdfData":             &result.PDFData,
+		"htmlData":            &result.HTMLData,
+		"accessibilityReport": &result.AccessibilityReport,
+	}
+	for name, data := range fields {
+		if len(*data) == 0 {
+			continue
+		}
+		plaintext, err := encryption.Open(key, *data, []byte(name))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt result: %v", err)
+		}
+		*data = plaintext
+	}
+	return nil
+}
+
+// GetDeck returns the deck generated by a job, or ErrNotFound
+func (s *FirestoreJobStore) GetDeck(ctx context.Context, id string) (*FirestoreDeck, error) {
+	doc, err := s.client.Collection("decks").Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var deck FirestoreDeck
+	if err := doc.DataTo(&deck); err != nil {
+		return nil, err
+	}
+	return &deck, nil
+}
+
+// GetRevision returns a revision of a deck, or ErrNotFound
+func (s *FirestoreJobStore) GetRevision(ctx context.Context, id string, number int) (*FirestoreRevision, error) {
+	doc, err := s.client.Collection("decks").Doc(id).Collection("revisions").Doc(strconv.Itoa(number)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var revision FirestoreRevision
+	if err := doc.DataTo(&revision); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// GetCapture returns the capture of a debugged job, or ErrNotFound
+func (s *FirestoreJobStore) GetCapture(ctx context.Context, id string) (*FirestoreCapture, error) {
+	doc, err := s.client.Collection("captures").Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var capture FirestoreCapture
+	if err := doc.DataTo(&capture); err != nil {
+		return nil, err
+	}
+	return &capture, nil
+}
+
+// firestoreJobWatcher adapts a Firestore snapshot iterator to a JobWatcher
+type firestoreJobWatcher struct {
+	snapshots *firestore.DocumentSnapshotIterator
+}
+
+// Next returns the next state of the job
+func (w *firestoreJobWatcher) Next() (*FirestoreJob, error) {
+	snapshot, err := w.snapshots.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	if !snapshot.Exists() {
+		return nil, ErrJobDeleted
+	}
+
+	var job FirestoreJob
+	if err := snapshot.DataTo(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Stop stops the snapshot listener
+func (w *firestoreJobWatcher) Stop() {
+	w.snapshots.Stop()
+}
+
+// toUpdates converts a field map to Firestore updates, keeping deleteAt in step
+// with expiresAt
+func toUpdates(fields map[string]interface{}) []firestore.Update {
+	updates := make([]firestore.Update, 0, len(fields)+1)
+	for path, value := range fields {
+		updates = append(updates, firestore.Update{Path: path, Value: value})
+		if expiresAt, ok := value.(int64); ok && path == "expiresAt" {
+			updates = append(updates, firestore.Update{Path: "deleteAt", Value: deleteAt(expiresAt)})
+		}
+	}
+	return updates
+}
+
+// deleteAt returns the deleteAt field for an expiry. A Firestore TTL policy on
+// the field purges expired documents, which are otherwise only deleted when
+// they are read after expiring, and it needs a timestamp rather than the Unix
+// time of expiresAt.
+func deleteAt(expiresAt int64) interface{} {
+	if expiresAt <= 0 {
+		return firestore.Delete
+	}
+	return time.Unix(expiresAt, 0)
+}
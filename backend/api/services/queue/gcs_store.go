@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCSBlobStore is a BlobStore backed by a Cloud Storage bucket
+type GCSBlobStore struct {
+	client     *storage.Client
+	projectID  string
+	bucketName string
+	kmsKeyName string // Cloud KMS key the files are encrypted with, empty for Google-managed keys
+}
+
+// NewGCSBlobStore creates a new Cloud Storage blob store, which encrypts the
+// files it writes with the Cloud KMS key when one is given
+func NewGCSBlobStore(client *storage.Client, projectID, bucketName, kmsKeyName string) *GCSBlobStore {
+	return &GCSBlobStore{
+		client:     client,
+		projectID:  projectID,
+		bucketName: bucketName,
+		kmsKeyName: kmsKeyName,
+	}
+}
+
+// Upload writes a file to the bucket, creating the bucket if it doesn't exist
+func (s *GCSBlobStore) Upload(ctx context.Context, path, contentType string, data []byte) error {
+	// Get a handle to the bucket
+	bucket := s.client.Bucket(s.bucketName)
+
+	// Check if the bucket exists, if not create it
+	if _, err := bucket.Attrs(ctx); err != nil {
+		if err == storage.ErrBucketNotExist {
+			if err := bucket.Create(ctx, s.projectID, s.bucketAttrs()); err != nil {
+				return fmt.Errorf("failed to create bucket: %v", err)
+			}
+		} else {
+			return fmt.Errorf("failed to check bucket: %v", err)
+		}
+	}
+
+	// Create a writer for the object
+	w := bucket.Object(path).NewWriter(ctx)
+	w.ContentType = contentType
+	w.KMSKeyName = s.kmsKeyName
+
+	// Write the file data to GCS
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write file to GCS: %v", err)
+	}
+
+	// Close the writer
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to close GCS writer: %v", err)
+	}
+
+	return nil
+}
+
+// UploadedAt returns when the object at a path was last written
+func (s *GCSBlobStore) UploadedAt(ctx context.Context, path string) (time.Time, error) {
+	attrs, err := s.client.Bucket(s.bucketName).Object(path).Attrs(ctx)
+	if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get object attributes: %v", err)
+	}
+	return attrs.Updated, nil
+}
+
+// List returns the objects whose path starts with the given prefix
+func (s *GCSBlobStore) List(ctx context.Context, prefix string) ([]BlobObject, error) {
+	var objects []BlobObject
+	it := s.client.Bucket(s.bucketName).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err == storage.ErrBucketNotExist {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %v", err)
+		}
+		objects = append(objects, BlobObject{Path: attrs.Name, Size: attrs.Size, UpdatedAt: attrs.Updated})
+	}
+	return objects, nil
+}
+
+// Delete removes the object at a path
+func (s *GCSBlobStore) Delete(ctx context.Context, path string) error {
+	err := s.client.Bucket(s.bucketName).Object(path).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Open returns a reader for the object at a path, pinned to its current
+// generation so a rewrite doesn't change the data mid-download
+func (s *GCSBlobStore) Open(ctx context.Context, path string) (io.ReadSeekCloser, *BlobObject, error) {
+	obj := s.client.Bucket(s.bucketName).Object(path)
+	attrs, err := obj.Attrs(ctx)
+	if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get object attributes: %v", err)
+	}
+	object := &BlobObject{Path: attrs.Name, Size: attrs.Size, UpdatedAt: attrs.Updated, ETag: attrs.Etag}
+	return &gcsObjectReader{ctx: ctx, obj: obj.Generation(attrs.Generation), size: attrs.Size}, object, nil
+}
+
+// gcsObjectReader reads an object from the offset it was last seeked to,
+// opening a range read only when it is read from
+type gcsObjectReader struct {
+	ctx    context.Context
+	obj    *storage.ObjectHandle
+	size   int64
+	offset int64
+	r      *storage.Reader
+}
+
+// Read reads from the current offset
+func (g *gcsObjectReader) Read(p []byte) (int, error) {
+	if g.offset >= g.size {
+		return 0, io.EOF
+	}
+	if g.r == nil {
+		r, err := g.obj.NewRangeReader(g.ctx, g.offset, -1)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read object: %v", err)
+		}
+		g.r = r
+	}
+	n, err := g.r.Read(p)
+	g.offset += int64(n)
+	return n, err
+}
+
+// Seek moves the offset, dropping the open range read if it moves
+func (g *gcsObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += g.offset
+	case io.SeekEnd:
+		offset += g.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	if offset != g.offset && g.r != nil {
+		g.r.Close()
+		g.r = nil
+	}
+	g.offset = offset
+	return offset, nil
+}
+
+// Close closes the open range read
+func (g *gcsObjectReader) Close() error {
+	if g.r == nil {
+		return nil
+	}
+	return g.r.Close()
+}
+
+// bucketAttrs returns the attributes of a new bucket, which deletes the files
+// stored by content once no job can still be reusing them
+func (s *GCSBlobStore) bucketAttrs() *storage.BucketAttrs {
+	attrs := &storage.BucketAttrs{
+		Lifecycle: storage.Lifecycle{
+			Rules: []storage.LifecycleRule{{
+				Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+				Condition: storage.LifecycleCondition{AgeInDays: 2, MatchesPrefix: []string{"content/"}},
+			}},
+		},
+	}
+	if s.kmsKeyName != "" {
+		attrs.Encryption = &storage.BucketEncryption{DefaultKMSKeyName: s.kmsKeyName}
+	}
+	return attrs
+}
+
+// URI returns the gs:// URI of a path in the bucket
+func (s *GCSBlobStore) URI(path string) string {
+	return fmt.Sprintf("gs://%s/%s", s.bucketName, path)
+}
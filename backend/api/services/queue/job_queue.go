@@ -2,67 +2,156 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
-	"bytes"
-	"path/filepath"
 
-	"cloud.google.com/go/firestore"
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
-	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
+	"github.com/martin226/slideitin/backend/api/config"
 	"github.com/martin226/slideitin/backend/api/models"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/types/known/timestamppb"
-	"os"
-)
-
-// JobStatus represents the current status of a job
-type JobStatus string
-
-const (
-	StatusQueued     JobStatus = "queued"
-	StatusProcessing JobStatus = "processing"
-	StatusCompleted  JobStatus = "completed"
-	StatusFailed     JobStatus = "failed"
+	"github.com/martin226/slideitin/backend/api/services/breaker"
+	"github.com/martin226/slideitin/backend/api/services/encryption"
 )
 
 // FirestoreJob is the Firestore representation of a job
 // Simplified to contain only essential fields
 type FirestoreJob struct {
-	ID        string `firestore:"id"`
-	Status    string `firestore:"status"`
-	Message   string `firestore:"message"`
-	CreatedAt int64  `firestore:"createdAt"`
-	UpdatedAt int64  `firestore:"updatedAt"`
-	ExpiresAt int64  `firestore:"expiresAt,omitempty"`
+	ID              string            `firestore:"id"`
+	Status          string            `firestore:"status"`
+	Message         string            `firestore:"message"`
+	MessageCode     string            `firestore:"messageCode,omitempty"`   // Code of the message, for localizing it
+	MessageParams   map[string]string `firestore:"messageParams,omitempty"` // Parameters of the message code
+	CreatedAt       int64             `firestore:"createdAt"`
+	UpdatedAt       int64             `firestore:"updatedAt"`
+	ExpiresAt       int64             `firestore:"expiresAt,omitempty"`
+	Owner           string            `firestore:"owner,omitempty"`       // ID of the API key that created the job
+	WorkspaceID     string            `firestore:"workspaceId,omitempty"` // Workspace whose library lists the job
+	Labels          map[string]string `firestore:"labels,omitempty"`
+	DeleteAt        time.Time         `firestore:"deleteAt,omitempty"`        // Set from ExpiresAt, for the Firestore TTL policy
+	Ephemeral       bool              `firestore:"ephemeral,omitempty"`       // The result is fetched once with its token and not kept
+	ResultTokenHash string            `firestore:"resultTokenHash,omitempty"` // SHA-256 of the token that fetches the result of an ephemeral job
+	ClaimTokenHash  string            `firestore:"claimTokenHash,omitempty"`  // SHA-256 of the token that gives access to an anonymous job
+	Warnings        []string          `firestore:"warnings,omitempty"`        // Files left out of the job and other problems that didn't fail it
 }
 
 // FirestoreResult is the Firestore representation of a job result
 type FirestoreResult struct {
-	ID          string `firestore:"id"`
-	ResultURL   string `firestore:"resultUrl"`
-	PDFData     []byte `firestore:"pdfData"`
-	HTMLData    []byte `firestore:"htmlData"`
-	CreatedAt   int64  `firestore:"createdAt"`
-	ExpiresAt   int64  `firestore:"expiresAt"`
+	ID                  string    `firestore:"id"`
+	ResultURL           string    `firestore:"resultUrl"`
+	PDFData             []byte    `firestore:"pdfData"`
+	HTMLData            []byte    `firestore:"htmlData"`
+	AccessibilityReport []byte    `firestore:"accessibilityReport,omitempty"`
+	CreatedAt           int64     `firestore:"createdAt"`
+	ExpiresAt           int64     `firestore:"expiresAt"`
+	DeleteAt            time.Time `firestore:"deleteAt,omitempty"`       // Set from ExpiresAt, for the Firestore TTL policy
+	Ephemeral           bool      `firestore:"ephemeral,omitempty"`      // Only fetched once, with the result token of the job
+	ClaimTokenHash      string    `firestore:"claimTokenHash,omitempty"` // Claim token hash of the anonymous job of the result
+	Owner               string    `firestore:"owner,omitempty"`          // Owner of the job of the result
+	WorkspaceID         string    `firestore:"workspaceId,omitempty"`    // Workspace of the job of the result
+	Downloads           int64     `firestore:"downloads,omitempty"`      // Times a document of the result was downloaded, counted by the API
+	LastAccessedAt      int64     `firestore:"lastAccessedAt,omitempty"` // When a document of the result was last downloaded
+
+	// The documents are stored in Cloud Storage instead of inline when the
+	// paths are set, so they can be streamed
+	PDFPath              string `firestore:"pdfPath,omitempty"`
+	HTMLPath             string `firestore:"htmlPath,omitempty"`
+	ViewerPath           string `firestore:"viewerPath,omitempty"`           // Self-contained HTML for the sandboxed viewer
+	ThumbnailPath        string `firestore:"thumbnailPath,omitempty"`        // PNG of the first slide
+	FlashcardsPath       string `firestore:"flashcardsPath,omitempty"`       // CSV of the flashcards, importable into Anki
+	OnePagerPath         string `firestore:"onePagerPath,omitempty"`         // PDF of the executive summary
+	OnePagerMarkdownPath string `firestore:"onePagerMarkdownPath,omitempty"` // Markdown of the executive summary
+	HandoutPath          string `firestore:"handoutPath,omitempty"`          // PDF of the student handout of an instructor deck
+	HandoutMarkdownPath  string `firestore:"handoutMarkdownPath,omitempty"`  // Markdown of the student handout
+	AlignmentPath        string `firestore:"alignmentPath,omitempty"`        // JSON of the times in the source recordings the slides cover
+	ChunkSummariesPath   string `firestore:"chunkSummariesPath,omitempty"`   // JSON of the summaries of the sections of long documents
+	GroundingPath        string `firestore:"groundingPath,omitempty"`        // JSON of the bullet points checked against the sources
+
+	Warnings []string `firestore:"warnings,omitempty"` // Problems that didn't stop generation, such as files left out
+
+	// The documents are encrypted when a data key is set, with the data key
+	// wrapped by the named Cloud KMS key
+	KeyName    string `firestore:"keyName,omitempty"`
+	WrappedKey []byte `firestore:"wrappedKey,omitempty"`
+}
+
+// FirestoreDeck is the Firestore representation of a generated deck, whose
+// revisions are stored by the slides service
+type FirestoreDeck struct {
+	ID             string `firestore:"id"`
+	Owner          string `firestore:"owner,omitempty"`
+	WorkspaceID    string `firestore:"workspaceId,omitempty"`
+	ClaimTokenHash string `firestore:"claimTokenHash,omitempty"` // Claim token hash of the anonymous job that generated the deck
+	Revision       int    `firestore:"revision"`                 // Number of the latest revision
+	UpdatedAt      int64  `firestore:"updatedAt"`
+}
+
+// FirestoreRevision is the Firestore representation of a revision of a deck
+type FirestoreRevision struct {
+	Number       int    `firestore:"number"`
+	Markdown     string `firestore:"markdown"`
+	Instruction  string `firestore:"instruction,omitempty"`  // Refinement that produced the revision, empty for the generated deck
+	BaseRevision int    `firestore:"baseRevision,omitempty"` // Revision the refinement was applied to
+	Slide        int    `firestore:"slide,omitempty"`        // Slide regenerated from feedback
+	CreatedAt    int64  `firestore:"createdAt"`
+}
+
+// FirestoreCapture is the Firestore representation of the prompts and raw
+// Gemini responses of a job an admin debugged, stored by the slides service
+type FirestoreCapture struct {
+	ID        string     `firestore:"id" json:"id"`
+	Exchanges []Exchange `firestore:"exchanges" json:"exchanges"`
+	Truncated bool       `firestore:"truncated,omitempty" json:"truncated"` // Some prompts or responses were cut short or left out
+	Task      string     `firestore:"task,omitempty" json:"-"`              // JSON task of the job with its captured inputs, which ReplayJob dispatches again
+	CreatedAt int64      `firestore:"createdAt" json:"createdAt"`
+	ExpiresAt int64      `firestore:"expiresAt" json:"expiresAt"`
+}
+
+// Exchange is a request of a job to Gemini and its raw response, with
+// personal data and credentials redacted
+type Exchange struct {
+	Stage        string   `firestore:"stage" json:"stage"`                             // Values: slides, outline, summary, flashcards, onePager
+	Documents    []string `firestore:"documents,omitempty" json:"documents,omitempty"` // Parts sent before the prompt, described rather than copied
+	Prompt       string   `firestore:"prompt" json:"prompt"`
+	Response     string   `firestore:"response,omitempty" json:"response,omitempty"`
+	FinishReason string   `firestore:"finishReason,omitempty" json:"finishReason,omitempty"`
+	InputTokens  int      `firestore:"inputTokens,omitempty" json:"inputTokens,omitempty"`
+	OutputTokens int      `firestore:"outputTokens,omitempty" json:"outputTokens,omitempty"`
+	Error        string   `firestore:"error,omitempty" json:"error,omitempty"`
+	CreatedAt    int64    `firestore:"createdAt" json:"createdAt"`
 }
 
 // Job represents a single slide generation job with runtime features
 type Job struct {
-	ID        string
-	Theme     string
-	Files     []models.File
-	Settings  models.SlideSettings
-	Status    JobStatus
-	Message   string
-	ResultURL string
-	CreatedAt int64
-	UpdatedAt int64
+	ID             string
+	Theme          string
+	Files          []models.File
+	Settings       models.SlideSettings
+	Options        JobOptions
+	Status         JobStatus
+	Message        string
+	MessageCode    string
+	MessageParams  map[string]string
+	ResultURL      string
+	CreatedAt      int64
+	UpdatedAt      int64
+	ResultToken    string   // Fetches the result of an ephemeral job once, only set when the job is added
+	ClaimToken     string   // Gives access to an anonymous job, its result and deck, only set when the job is added
+	ClaimTokenHash string   // SHA-256 of the claim token of an anonymous job
+	Warnings       []string // Files left out of the job and other problems that didn't fail it
 }
 
 // JobUpdate represents an update to a job that can be sent to SSE clients
@@ -72,284 +161,780 @@ type JobUpdate struct {
 	Message   string    `json:"message"`
 	ResultURL string    `json:"resultUrl,omitempty"`
 	UpdatedAt int64     `json:"updatedAt"`
+	Warnings  []string  `json:"warnings,omitempty"`
+
+	// Code and parameters of the message, for frontends that localize it
+	// themselves. Messages without a code, such as errors, are in English.
+	MessageCode   string            `json:"messageCode,omitempty"`
+	MessageParams map[string]string `json:"messageParams,omitempty"`
+}
+
+// JobSummary is a job as listed in the job history
+type JobSummary struct {
+	ID           string            `json:"id"`
+	Status       JobStatus         `json:"status"`
+	Message      string            `json:"message"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	CreatedAt    int64             `json:"createdAt"`
+	UpdatedAt    int64             `json:"updatedAt"`
+	ThumbnailURL string            `json:"thumbnailUrl,omitempty"` // Preview of the first slide, set once the job completes
+
+	// Access statistics of the result, set once the job completes
+	Downloads       int64 `json:"downloads"`
+	LastAccessedAt  int64 `json:"lastAccessedAt,omitempty"`
+	ResultExpiresAt int64 `json:"resultExpiresAt,omitempty"` // Pushed back while the result is downloaded
+}
+
+// Webhook is a chat webhook notified when a job completes
+type Webhook struct {
+	Type string `json:"type"` // Values: slack, discord
+	URL  string `json:"url"`
+}
+
+// JobOptions holds the optional delivery settings of a job
+type JobOptions struct {
+	NotifyEmail    string
+	Webhooks       []Webhook
+	Owner          string             // ID of the API key that created the job
+	WorkspaceID    string             // Workspace whose library lists the job
+	Labels         map[string]string  // Labels used to filter the job history
+	IdempotencyKey string             // Client key that makes retried requests return the same job
+	Drive          *DriveFiles        // Google Drive files the slides service downloads with the owner's connection
+	Sources        []SourceReference  // Documents the slides service imports from content sources
+	MaxSourceBytes int                // Largest document allowed from a content source
+	Prompt         string             // Topic or outline the deck is written from when there are no files
+	Ephemeral      bool               // Keep nothing past the job, the result is fetched once with the result token
+	Features       map[string]bool    // Feature flags evaluated for the workspace of the job
+	Fonts          []models.FontFile  // Font files of the workspace the settings use
+	Documents      []FileReference    // Documents of the workspace library to generate from, already stored and indexed
+	TokenLimits    models.TokenLimits // Token limits of the owner's plan
+	Debug          bool               // Capture the prompts and raw responses of the job for the admins
+	Region         string             // Region the job is kept in, the one of its workspace, empty for the default region
+}
+
+// SourceReference references a document in a content source such as Confluence
+type SourceReference struct {
+	Type     string            `json:"type"`
+	Location string            `json:"location"`
+	Options  map[string]string `json:"options,omitempty"`
+}
+
+// DriveFiles references Google Drive files to generate from alongside the uploads
+type DriveFiles struct {
+	Owner        string   `json:"owner"` // Owner whose Drive connection is used
+	FileIDs      []string `json:"fileIds"`
+	MaxFileBytes int      `json:"maxFileBytes"` // Largest file allowed by the owner's plan
 }
 
 // FileReference represents a reference to a file stored in GCS
 type FileReference struct {
-	Filename string `json:"filename"`
-	Type     string `json:"type"`
-	GCSPath  string `json:"gcsPath"`
+	Filename  string `json:"filename"`
+	Type      string `json:"type"`
+	GCSPath   string `json:"gcsPath"`
+	Hash      string `json:"hash,omitempty"`      // SHA-256 of the content, set for files shared by the jobs that upload the same content
+	IndexPath string `json:"indexPath,omitempty"` // Passages embedded when the file was added to a workspace library
+}
+
+// IndexPayload asks the slides service to index a document added to a
+// workspace library
+type IndexPayload struct {
+	WorkspaceID string        `json:"workspaceId"`
+	DocumentID  string        `json:"documentId"`
+	File        FileReference `json:"file"`
+	IndexPath   string        `json:"indexPath"` // Where the index is stored next to the document
 }
 
 // TaskPayload represents the data structure to be sent in a Cloud Task
 type TaskPayload struct {
-	JobID     string            `json:"jobID"`
-	Theme     string            `json:"theme"`
-	Files     []FileReference   `json:"files"`
-	Settings  models.SlideSettings `json:"settings"`
+	JobID          string               `json:"jobID"`
+	Theme          string               `json:"theme"`
+	Files          []FileReference      `json:"files"`
+	Settings       models.SlideSettings `json:"settings"`
+	NotifyEmail    string               `json:"notifyEmail,omitempty"`
+	Webhooks       []Webhook            `json:"webhooks,omitempty"`
+	Drive          *DriveFiles          `json:"drive,omitempty"`
+	Sources        []SourceReference    `json:"sources,omitempty"`
+	MaxSourceBytes int                  `json:"maxSourceBytes,omitempty"`
+	Prompt         string               `json:"prompt,omitempty"`
+	Owner          string               `json:"owner,omitempty"`
+	WorkspaceID    string               `json:"workspaceId,omitempty"`
+	Ephemeral      bool                 `json:"ephemeral,omitempty"`
+	Warnings       []string             `json:"warnings,omitempty"`       // Files that couldn't be uploaded, carried into the result
+	Features       map[string]bool      `json:"features,omitempty"`       // Feature flags of the job, flags left out keep their default
+	Fonts          []models.FontFile    `json:"fonts,omitempty"`          // Uploaded font files of the families in the settings
+	TokenLimits    models.TokenLimits   `json:"tokenLimits,omitempty"`    // Token limits of the owner's plan
+	ClaimTokenHash string               `json:"claimTokenHash,omitempty"` // Kept with the result and deck of an anonymous job
+	Debug          bool                 `json:"debug,omitempty"`          // Capture the prompts and raw responses of the job for the admins
 }
 
-// Service manages jobs using Firestore, Cloud Tasks, and Cloud Storage
+// RefinePayload represents a refinement to be sent in a Cloud Task
+type RefinePayload struct {
+	JobID       string             `json:"jobID"`
+	Revision    int                `json:"revision"` // Revision the instruction is applied to
+	Instruction string             `json:"instruction"`
+	Slide       int                `json:"slide,omitempty"` // Slide to regenerate, with the instruction as the reason it was rejected
+	NotifyEmail string             `json:"notifyEmail,omitempty"`
+	Webhooks    []Webhook          `json:"webhooks,omitempty"`
+	TokenLimits models.TokenLimits `json:"tokenLimits,omitempty"` // Token limits of the owner's plan
+}
+
+// contentReuseWindow is how long an uploaded file is reused by jobs that
+// upload the same content. It is kept well inside the retention of shared
+// files so that a reused file outlives the job processing it.
+const contentReuseWindow = 12 * time.Hour
+
+// Codes of the status messages set by the API, the slides service sets the
+// codes of the messages of the jobs it processes
+const (
+	messageQueued             = "queued"
+	messageRefinementQueued   = "refinement_queued"   // revision
+	messageRegenerationQueued = "regeneration_queued" // slide
+)
+
+// resultAccessTTL is how long a result is kept after it was last downloaded,
+// so results in active use outlive their default expiry
+const resultAccessTTL = time.Hour
+
+// maxAccessedResultLifetime bounds how long downloads keep a result after it
+// was created
+const maxAccessedResultLifetime = 7 * 24 * time.Hour
+
+// ephemeralJobTTL bounds the lifetime of an ephemeral job, so that it and its
+// checkpoint are purged even when it never finishes
+const ephemeralJobTTL = 2 * time.Hour
+
+// idempotencyKeyTTL is how long an idempotency key returns the same job
+const idempotencyKeyTTL = 24 * time.Hour
+
+var (
+	// ErrRevisionNotFound is returned when refining a revision a deck doesn't have
+	ErrRevisionNotFound = errors.New("revision not found")
+
+	// ErrJobInProgress is returned when refining a deck whose job is still running
+	ErrJobInProgress = errors.New("the deck is still being generated or refined")
+
+	// ErrInvalidResultToken is returned when the result of an ephemeral job is
+	// fetched with a token that isn't its result token
+	ErrInvalidResultToken = errors.New("invalid result token")
+
+	// ErrEphemeralResult is returned when the result of an ephemeral job is
+	// fetched without its result token
+	ErrEphemeralResult = errors.New("the result of an ephemeral job can only be fetched once with its result token")
+
+	// ErrClaimRequired is returned when an anonymous job, its result or deck
+	// is accessed without the claim token of the job
+	ErrClaimRequired = errors.New("the claim token of the job is required")
+
+	// ErrNotOwner is returned when a job, its result or deck is accessed by
+	// someone who isn't its owner or a member of its workspace
+	ErrNotOwner = errors.New("not the owner of the job")
+
+	// ErrCaptureNotFound is returned when a job has no capture, because it
+	// wasn't debugged, hasn't called Gemini yet or its capture expired
+	ErrCaptureNotFound = errors.New("no capture for this job")
+
+	// ErrUnknownRegion is returned when a job is added to a region that isn't configured
+	ErrUnknownRegion = errors.New("unknown region")
+)
+
+// Service manages jobs using a job store, a blob store for uploaded files, and
+// a task dispatcher. Workspaces tagged with another region than the default
+// keep their jobs in the stores of that region, and the IDs of these jobs are
+// prefixed with the region so they are found again.
 type Service struct {
-	client     *firestore.Client
-	taskClient *cloudtasks.Client
-	storageClient *storage.Client
-	projectID  string
-	region     string
-	queueID    string
-	serviceURL string
-	bucketName string
+	stores        // Stores of the default region
+	defaultRegion string
+	regions       map[string]stores  // Stores of the other regions, by ID
+	breakers      []*breaker.Breaker // Breakers around the stores of the default region, checked before taking new jobs
+}
+
+// stores keep the jobs of a region and dispatch their tasks
+type stores struct {
+	jobs  JobStore
+	blobs BlobStore
+	tasks TaskDispatcher
 }
 
 // NewService creates a new queue service using Firestore, Cloud Tasks, and Cloud Storage
-func NewService(client *firestore.Client) (*Service, error) {
-	// Get environment variables
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID == "" {
-		return nil, fmt.Errorf("GOOGLE_CLOUD_PROJECT environment variable is required")
-	}
-	
-	region := os.Getenv("CLOUD_TASKS_REGION")
-	if region == "" {
-		region = "us-central1" // Default region
-	}
-	
-	queueID := os.Getenv("CLOUD_TASKS_QUEUE_ID")
-	if queueID == "" {
-		queueID = "slides-generation-queue" // Default queue ID
-	}
-	
-	serviceURL := os.Getenv("SLIDES_SERVICE_URL")
-	if serviceURL == "" {
-		return nil, fmt.Errorf("SLIDES_SERVICE_URL environment variable is required")
-	}
-	
-	bucketName := os.Getenv("GCS_BUCKET_NAME")
-	if bucketName == "" {
-		bucketName = "slideitin-files" // Default bucket name
-	}
-	
+func NewService(client *firestore.Client, cfg *config.Config) (*Service, error) {
 	// Create Cloud Tasks client
 	ctx := context.Background()
 	taskClient, err := cloudtasks.NewClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Cloud Tasks client: %v", err)
 	}
-	
+
 	// Create Cloud Storage client
 	storageClient, err := storage.NewClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Cloud Storage client: %v", err)
 	}
-	
-	return &Service{
-		client:        client,
-		taskClient:    taskClient,
-		storageClient: storageClient,
-		projectID:     projectID,
-		region:        region,
-		queueID:       queueID,
-		serviceURL:    serviceURL,
-		bucketName:    bucketName,
-	}, nil
-}
-
-// Collection returns the Firestore collection reference for jobs
-func (s *Service) Collection() *firestore.CollectionRef {
-	return s.client.Collection("jobs")
-}
-
-// ResultsCollection returns the Firestore collection reference for results
-func (s *Service) ResultsCollection() *firestore.CollectionRef {
-	return s.client.Collection("results")
-}
-
-// uploadFileToGCS uploads a file to Google Cloud Storage and returns its GCS path
-func (s *Service) uploadFileToGCS(ctx context.Context, jobID string, file models.File) (string, error) {
-	// Create a GCS object path: jobID/filename
-	objectPath := filepath.Join(jobID, file.Filename)
-	
-	// Get a handle to the bucket
-	bucket := s.storageClient.Bucket(s.bucketName)
-	
-	// Check if the bucket exists, if not create it
-	if _, err := bucket.Attrs(ctx); err != nil {
-		if err == storage.ErrBucketNotExist {
-			if err := bucket.Create(ctx, s.projectID, nil); err != nil {
-				return "", fmt.Errorf("failed to create bucket: %v", err)
+
+	// Results encrypted by the slides service name the Cloud KMS key that decrypts them
+	kms, err := encryption.NewKMS(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The default region comes first, its database is the one of the client
+	service := &Service{defaultRegion: cfg.DefaultRegion, regions: make(map[string]stores)}
+	for i, region := range cfg.Regions {
+		if err := checkBucketLocation(ctx, storageClient, region); err != nil {
+			return nil, err
+		}
+		regionClient, name := client, ""
+		if i > 0 {
+			regionClient, err = firestore.NewClientWithDatabase(ctx, cfg.ProjectID, region.FirestoreDatabaseID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Firestore client for region %s: %v", region.ID, err)
 			}
-		} else {
-			return "", fmt.Errorf("failed to check bucket: %v", err)
+			name = " in " + region.ID
+		}
+
+		// Jobs fail fast while Firestore or Cloud Storage is down rather than timing out
+		firestoreBreaker := breaker.New("Firestore"+name, breaker.DefaultThreshold, breaker.DefaultCooldown)
+		storageBreaker := breaker.New("Cloud Storage"+name, breaker.DefaultThreshold, breaker.DefaultCooldown)
+		regionStores := stores{
+			jobs:  newBreakerJobStore(NewFirestoreJobStore(regionClient, kms), firestoreBreaker),
+			blobs: newBreakerBlobStore(NewGCSBlobStore(storageClient, cfg.ProjectID, region.BucketName, region.KMSKey), storageBreaker),
+			tasks: NewCloudTasksDispatcher(taskClient, cfg.ProjectID, region.CloudTasksRegion, cfg.CloudTasksQueue, region.SlidesServiceURL, cfg.TaskSigningSecret),
+		}
+		if i > 0 {
+			service.regions[region.ID] = regionStores
+			continue
+		}
+		service.stores = regionStores
+		service.breakers = []*breaker.Breaker{firestoreBreaker, storageBreaker}
+	}
+	return service, nil
+}
+
+// checkBucketLocation checks that the bucket of a region is in the location
+// set for it, so data can't end up outside the region by mistake. The check is
+// skipped with a warning when the API may not read the bucket's metadata.
+func checkBucketLocation(ctx context.Context, client *storage.Client, region config.Region) error {
+	if region.BucketLocation == "" {
+		return nil
+	}
+	attrs, err := client.Bucket(region.BucketName).Attrs(ctx)
+	if err != nil {
+		log.Printf("Warning: Failed to check the location of bucket %s of region %s: %v", region.BucketName, region.ID, err)
+		return nil
+	}
+	if !config.LocationContains(region.BucketLocation, strings.ToLower(attrs.Location)) {
+		return fmt.Errorf("bucket %s of region %s is in %s, not in %s", region.BucketName, region.ID, attrs.Location, region.BucketLocation)
+	}
+	return nil
+}
+
+// NewServiceWithStores creates a new queue service on top of the given stores,
+// which lets tests and alternative backends replace the cloud clients
+func NewServiceWithStores(jobs JobStore, blobs BlobStore, tasks TaskDispatcher) *Service {
+	return &Service{
+		stores:  stores{jobs: jobs, blobs: blobs, tasks: tasks},
+		regions: make(map[string]stores),
+	}
+}
+
+// AddRegion adds the stores of a region other than the default one
+func (s *Service) AddRegion(id string, jobs JobStore, blobs BlobStore, tasks TaskDispatcher) {
+	s.regions[id] = stores{jobs: jobs, blobs: blobs, tasks: tasks}
+}
+
+// HasRegion reports whether jobs can be kept in a region, empty for the default one
+func (s *Service) HasRegion(region string) bool {
+	_, ok := s.regions[region]
+	return ok || region == "" || region == s.defaultRegion
+}
+
+// NewJobID returns the ID of a new job kept in a region, empty for the default one
+func (s *Service) NewJobID(region string) string {
+	id := uuid.New().String()
+	if region == "" || region == s.defaultRegion {
+		return id
+	}
+	return region + "-" + id
+}
+
+// Region returns the region a job is kept in
+func (s *Service) Region(id string) string {
+	if prefix, _, ok := strings.Cut(id, "-"); ok {
+		if _, ok := s.regions[prefix]; ok {
+			return prefix
+		}
+	}
+	return s.defaultRegion
+}
+
+// in returns the stores of the region a job is kept in
+func (s *Service) in(id string) stores {
+	return s.of(s.Region(id))
+}
+
+// of returns the stores of a region, empty for the default one
+func (s *Service) of(region string) stores {
+	if regional, ok := s.regions[region]; ok {
+		return regional
+	}
+	return s.stores
+}
+
+// Blobs returns the blob store of a region, empty for the default one
+func (s *Service) Blobs(region string) BlobStore {
+	return s.of(region).blobs
+}
+
+// Available returns a *breaker.OpenError while a store of the default region
+// is down. The stores of other regions fail fast when jobs are added to them.
+func (s *Service) Available(ctx context.Context) error {
+	for _, b := range s.breakers {
+		if err := b.Available(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadFile uploads a file to the blob store and returns its path and content
+// hash. Files are stored by content, so a file uploaded again within the reuse
+// window is shared with the earlier job instead of being stored twice. Files of
+// ephemeral jobs are never shared, they are stored under the job and deleted
+// with it.
+func (s *Service) uploadFile(ctx context.Context, jobID string, file models.File, ephemeral bool) (string, string, error) {
+	if ephemeral {
+		objectPath := path.Join(jobID, file.Filename)
+		if err := s.in(jobID).blobs.Upload(ctx, objectPath, file.Type, file.Data); err != nil {
+			return "", "", err
+		}
+		log.Printf("Uploaded file %s to %s", file.Filename, objectPath)
+		return objectPath, "", nil
+	}
+
+	sum := sha256.Sum256(file.Data)
+	hash := hex.EncodeToString(sum[:])
+
+	// Create an object path: content/hash
+	objectPath := path.Join("content", hash)
+
+	uploadedAt, err := s.in(jobID).blobs.UploadedAt(ctx, objectPath)
+	if err == nil && time.Since(uploadedAt) < contentReuseWindow {
+		log.Printf("Reusing %s for file %s", objectPath, file.Filename)
+		return objectPath, hash, nil
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Failed to check for an earlier upload of %s: %v", file.Filename, err)
+	}
+
+	// Writing the file again also restarts its retention
+	if err := s.in(jobID).blobs.Upload(ctx, objectPath, file.Type, file.Data); err != nil {
+		return "", "", err
+	}
+
+	log.Printf("Uploaded file %s to %s", file.Filename, objectPath)
+
+	return objectPath, hash, nil
+}
+
+// newToken generates a random URL-safe result or claim token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// tokenHash returns the hash a result or claim token is stored as
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Access is who a request comes from, checked against the owner, workspace
+// and claim token hash of a job, result or deck
+type Access struct {
+	Owner       string   // API key ID or account of the request, if any
+	WorkspaceID string   // Workspace of the API key, if its role has the permission needed
+	Claims      []string // Claim tokens sent with the request
+}
+
+// Check returns ErrClaimRequired when an anonymous job is accessed without its
+// claim token, and ErrNotOwner when the job of an owner is accessed by someone
+// who is neither that owner nor a member of its workspace
+func (a Access) Check(owner, workspaceID, claimTokenHash string) error {
+	if owner == "" {
+		if !ValidClaim(claimTokenHash, a.Claims) {
+			return ErrClaimRequired
 		}
+		return nil
 	}
-	
-	// Create a writer for the object
-	obj := bucket.Object(objectPath)
-	w := obj.NewWriter(ctx)
-	w.ContentType = file.Type
-	
-	// Write the file data to GCS
-	if _, err := io.Copy(w, bytes.NewReader(file.Data)); err != nil {
-		w.Close()
-		return "", fmt.Errorf("failed to write file to GCS: %v", err)
+	if a.Owner == owner || (workspaceID != "" && a.WorkspaceID == workspaceID) {
+		return nil
 	}
-	
-	// Close the writer
-	if err := w.Close(); err != nil {
-		return "", fmt.Errorf("failed to close GCS writer: %v", err)
+	return ErrNotOwner
+}
+
+// ValidClaim reports whether one of the claim tokens of a request is the one
+// hashed as hash. Jobs of accounts, and the anonymous jobs created before
+// claim tokens, have no hash and are claimed by any request.
+func ValidClaim(hash string, tokens []string) bool {
+	if hash == "" {
+		return true
+	}
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(tokenHash(token)), []byte(hash)) == 1 {
+			return true
+		}
 	}
-	
-	log.Printf("Uploaded file %s to GCS: gs://%s/%s", file.Filename, s.bucketName, objectPath)
-	
-	return objectPath, nil
+	return false
 }
 
-// AddJob adds a new job to Firestore, uploads files to GCS, and creates a Cloud Task for processing
-func (s *Service) AddJob(ctx context.Context, id, theme string, fileData []models.File, settings models.SlideSettings) (*Job, error) {
+// idempotencyKeyID scopes an idempotency key to the API key that sent it
+func idempotencyKeyID(owner, key string) string {
+	sum := sha256.Sum256([]byte(owner + ":" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// AddJob adds a new job to the job store, uploads files, and dispatches a task for processing.
+// When the idempotency key of the options is held by another job, that job is returned instead.
+func (s *Service) AddJob(ctx context.Context, id, theme string, fileData []models.File, settings models.SlideSettings, options JobOptions) (*Job, error) {
+	// A job is never kept outside the region of its workspace
+	if !s.HasRegion(options.Region) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRegion, options.Region)
+	}
+	if options.Region != "" && s.Region(id) != options.Region {
+		return nil, fmt.Errorf("job %s isn't an ID of region %s", id, options.Region)
+	}
+
 	// Create the job
 	now := time.Now().Unix()
-	
-	// Create a job record for Firestore (simplified)
+	// Create a job record for the store (simplified)
 	firestoreJob := FirestoreJob{
-		ID:        id,
-		Status:    string(StatusQueued),
-		Message:   "Job added to queue",
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:          id,
+		Status:      string(StatusQueued),
+		Message:     "Job added to queue",
+		MessageCode: messageQueued,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Owner:       options.Owner,
+		WorkspaceID: options.WorkspaceID,
+		Labels:      options.Labels,
 	}
 
-	// Save to Firestore
-	_, err := s.Collection().Doc(id).Set(ctx, firestoreJob)
-	if err != nil {
-		log.Printf("Failed to add job to Firestore: %v", err)
+	// Ephemeral jobs are purged after a while even if they never finish, and
+	// their result is fetched once with a token only the client gets
+	var resultToken string
+	if options.Ephemeral {
+		var err error
+		resultToken, err = newToken()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate result token: %v", err)
+		}
+		firestoreJob.Ephemeral = true
+		firestoreJob.ResultTokenHash = tokenHash(resultToken)
+		firestoreJob.ExpiresAt = time.Now().Add(ephemeralJobTTL).Unix()
+	}
+
+	// Anonymous jobs are only accessible with a claim token only the client
+	// gets, so knowing the job ID isn't enough
+	var claimToken string
+	if options.Owner == "" {
+		var err error
+		claimToken, err = newToken()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate claim token: %v", err)
+		}
+		firestoreJob.ClaimTokenHash = tokenHash(claimToken)
+	}
+
+	// Save to the store
+	if err := s.in(id).jobs.CreateJob(ctx, firestoreJob); err != nil {
+		log.Printf("Failed to add job to store: %v", err)
 		return nil, fmt.Errorf("failed to store job: %v", err)
 	}
 
-	log.Printf("Added job %s to Firestore", id)
+	log.Printf("Added job %s to store", id)
+
+	// Claim the idempotency key now that the job exists
+	if options.IdempotencyKey != "" {
+		existing, err := s.claimIdempotencyKey(ctx, id, options)
+		if err != nil || existing != nil {
+			if deleteErr := s.in(id).jobs.DeleteJob(ctx, id); deleteErr != nil {
+				log.Printf("Failed to delete duplicate job %s: %v", id, deleteErr)
+			}
+			return existing, err
+		}
+	}
 
 	// Create in-memory job object
 	job := &Job{
-		ID:        id,
-		Theme:     theme,
-		Files:     fileData,
-		Settings:  settings,
-		Status:    StatusQueued,
-		Message:   "Job added to queue",
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:             id,
+		Theme:          theme,
+		Files:          fileData,
+		Settings:       settings,
+		Options:        options,
+		Status:         StatusQueued,
+		Message:        "Job added to queue",
+		MessageCode:    messageQueued,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ResultToken:    resultToken,
+		ClaimToken:     claimToken,
+		ClaimTokenHash: firestoreJob.ClaimTokenHash,
 	}
 
-	// Upload files to GCS
+	// Upload files to the blob store, a file that can't be uploaded is left
+	// out as long as the job has other inputs
 	fileRefs := make([]FileReference, 0, len(fileData))
+	var uploadErr error
 	for _, file := range fileData {
-		// Upload the file to GCS
-		gcsPath, err := s.uploadFileToGCS(ctx, id, file)
+		// Upload the file
+		gcsPath, hash, err := s.uploadFile(ctx, id, file, options.Ephemeral)
 		if err != nil {
-			// Update job status to failed if file upload fails
-			s.updateJobStatus(job, StatusFailed, fmt.Sprintf("Failed to upload file %s: %v", file.Filename, err), "")
-			return job, fmt.Errorf("failed to upload file: %v", err)
+			log.Printf("Failed to upload file %s for job %s: %v", file.Filename, id, err)
+			uploadErr = fmt.Errorf("failed to upload file %s: %v", file.Filename, err)
+			job.Warnings = append(job.Warnings, fmt.Sprintf("File %s couldn't be uploaded and was left out: %v", file.Filename, err))
+			continue
 		}
-		
+
 		// Create a file reference
 		fileRef := FileReference{
 			Filename: file.Filename,
 			Type:     file.Type,
 			GCSPath:  gcsPath,
+			Hash:     hash,
 		}
 		fileRefs = append(fileRefs, fileRef)
 	}
+	if uploadErr != nil {
+		// Update job status to failed if no input is left
+		if len(fileRefs) == 0 && options.Drive == nil && len(options.Sources) == 0 && len(options.Documents) == 0 {
+			s.updateJobStatus(job, StatusFailed, fmt.Sprintf("Failed to upload files: %v", uploadErr), "")
+			s.releaseIdempotencyKey(ctx, options)
+			return job, uploadErr
+		}
+		if err := s.in(id).jobs.UpdateJob(ctx, id, map[string]interface{}{"warnings": job.Warnings}); err != nil {
+			log.Printf("Failed to store the warnings of job %s: %v", id, err)
+		}
+	}
+
+	// Library documents are stored by the workspace and never uploaded again
+	fileRefs = append(fileRefs, options.Documents...)
 
-	// Create a Cloud Task to process the job
-	err = s.createTask(ctx, job, fileRefs)
+	// Dispatch a task to process the job
+	err := s.in(id).tasks.Dispatch(ctx, TaskPayload{
+		JobID:          job.ID,
+		Theme:          job.Theme,
+		Files:          fileRefs,
+		Settings:       job.Settings,
+		NotifyEmail:    job.Options.NotifyEmail,
+		Webhooks:       job.Options.Webhooks,
+		Drive:          job.Options.Drive,
+		Sources:        job.Options.Sources,
+		MaxSourceBytes: job.Options.MaxSourceBytes,
+		Prompt:         job.Options.Prompt,
+		Owner:          job.Options.Owner,
+		WorkspaceID:    job.Options.WorkspaceID,
+		Ephemeral:      job.Options.Ephemeral,
+		Warnings:       job.Warnings,
+		Features:       job.Options.Features,
+		Fonts:          job.Options.Fonts,
+		TokenLimits:    job.Options.TokenLimits,
+		ClaimTokenHash: job.ClaimTokenHash,
+		Debug:          job.Options.Debug,
+	})
 	if err != nil {
 		// Update job status to failed if task creation fails
 		s.updateJobStatus(job, StatusFailed, fmt.Sprintf("Failed to queue job: %v", err), "")
+		s.releaseIdempotencyKey(ctx, options)
 		return job, fmt.Errorf("failed to create Cloud Task: %v", err)
 	}
 
+	log.Printf("Dispatched task for job %s with %d file references", job.ID, len(fileRefs))
+
 	return job, nil
 }
 
-// createTask creates a Cloud Task to process a job
-func (s *Service) createTask(ctx context.Context, job *Job, fileRefs []FileReference) error {
-	taskPayload := TaskPayload{
-		JobID: job.ID,
-		Theme: job.Theme,
-		Files: fileRefs,
-		Settings: job.Settings,
+// DispatchIndexing schedules the indexing of a document added to a workspace
+// library by the slides service of the workspace's region
+func (s *Service) DispatchIndexing(ctx context.Context, region string, payload IndexPayload) error {
+	return s.of(region).tasks.DispatchIndexing(ctx, payload)
+}
+
+// GetDeck returns the deck generated by a job, or ErrNotFound
+func (s *Service) GetDeck(ctx context.Context, id string) (*FirestoreDeck, error) {
+	deck, err := s.in(id).jobs.GetDeck(ctx, id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("error retrieving deck: %v", err)
+	}
+	return deck, err
+}
+
+// GetRevision returns a revision of a deck, or ErrRevisionNotFound
+func (s *Service) GetRevision(ctx context.Context, id string, number int) (*FirestoreRevision, error) {
+	revision, err := s.in(id).jobs.GetRevision(ctx, id, number)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrRevisionNotFound
 	}
-	
-	payloadBytes, err := json.Marshal(taskPayload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal task payload: %v", err)
-	}
-	
-	// Define the Cloud Tasks queue path
-	queuePath := fmt.Sprintf("projects/%s/locations/%s/queues/%s", s.projectID, s.region, s.queueID)
-	
-	// Define the target endpoint
-	taskURL := fmt.Sprintf("%s/tasks/process-slides", s.serviceURL)
-
-	// Create the Cloud Task with OIDC token
-	task := &taskspb.CreateTaskRequest{
-		Parent: queuePath,
-		Task: &taskspb.Task{
-			// Name is assigned by the server
-			MessageType: &taskspb.Task_HttpRequest{
-				HttpRequest: &taskspb.HttpRequest{
-					HttpMethod: taskspb.HttpMethod_POST,
-					Url:        taskURL,
-					Headers: map[string]string{
-						"Content-Type": "application/json",
-					},
-					Body: payloadBytes,
-					AuthorizationHeader: &taskspb.HttpRequest_OidcToken{
-						OidcToken: &taskspb.OidcToken{
-							ServiceAccountEmail: fmt.Sprintf("%s@%s.iam.gserviceaccount.com", "slides-service-invoker", s.projectID),
-							Audience:            taskURL,
-						},
-					},
-				},
-			},
-			ScheduleTime: timestamppb.New(time.Now()),
-		},
-	}
-	
-	// Create the task
-	_, err = s.taskClient.CreateTask(ctx, task)
+		return nil, fmt.Errorf("error retrieving revision: %v", err)
+	}
+	return revision, nil
+}
+
+// GetCapture returns the prompts and raw responses captured for a debugged
+// job, or ErrCaptureNotFound
+func (s *Service) GetCapture(ctx context.Context, id string) (*FirestoreCapture, error) {
+	capture, err := s.in(id).jobs.GetCapture(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrCaptureNotFound
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create task: %v", err)
+		return nil, fmt.Errorf("error retrieving capture: %v", err)
+	}
+	// The TTL policy deletes expired captures within a day
+	if time.Now().Unix() > capture.ExpiresAt {
+		return nil, ErrCaptureNotFound
+	}
+	return capture, nil
+}
+
+// RefineDeck queues an instruction to be applied to a revision of a deck, 0
+// for the latest. With a slide, only that slide is regenerated and the
+// instruction is the reason it was rejected. The job of the deck is reopened
+// so clients follow the refinement like a generation, and its result is
+// replaced once it completes.
+func (s *Service) RefineDeck(ctx context.Context, deck *FirestoreDeck, revision, slide int, instruction string, options JobOptions) (*Job, error) {
+	if revision == 0 {
+		revision = deck.Revision
+	}
+	if revision < 1 || revision > deck.Revision {
+		return nil, ErrRevisionNotFound
+	}
+
+	now := time.Now().Unix()
+	job := s.GetJob(deck.ID)
+	if job != nil && !job.Status.Terminal() {
+		return nil, ErrJobInProgress
+	}
+	message := fmt.Sprintf("Refinement of revision %d queued", revision)
+	code, params := messageRefinementQueued, map[string]string{"revision": strconv.Itoa(revision)}
+	if slide > 0 {
+		message = fmt.Sprintf("Regeneration of slide %d queued", slide)
+		code, params = messageRegenerationQueued, map[string]string{"slide": strconv.Itoa(slide)}
+	}
+	if job != nil {
+		err := s.in(deck.ID).jobs.TransitionJob(ctx, deck.ID, StatusQueued, map[string]interface{}{
+			"message":       message,
+			"messageCode":   code,
+			"messageParams": params,
+			"updatedAt":     now,
+			"expiresAt":     int64(0),
+		})
+		if errors.Is(err, ErrIllegalTransition) {
+			// Another refinement was queued since the job was read
+			return nil, ErrJobInProgress
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to store job: %v", err)
+		}
+	} else {
+		// Finished jobs are deleted after a while, the deck outlives them
+		err := s.in(deck.ID).jobs.CreateJob(ctx, FirestoreJob{
+			ID:             deck.ID,
+			Status:         string(StatusQueued),
+			Message:        message,
+			MessageCode:    code,
+			MessageParams:  params,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+			Owner:          deck.Owner,
+			WorkspaceID:    deck.WorkspaceID,
+			ClaimTokenHash: deck.ClaimTokenHash,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to store job: %v", err)
+		}
+	}
+	job = &Job{
+		ID:            deck.ID,
+		Options:       options,
+		Status:        StatusQueued,
+		Message:       message,
+		MessageCode:   code,
+		MessageParams: params,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	err := s.in(deck.ID).tasks.DispatchRefinement(ctx, RefinePayload{
+		JobID:       deck.ID,
+		Revision:    revision,
+		Instruction: instruction,
+		Slide:       slide,
+		NotifyEmail: options.NotifyEmail,
+		Webhooks:    options.Webhooks,
+		TokenLimits: options.TokenLimits,
+	})
+	if err != nil {
+		s.updateJobStatus(job, StatusFailed, fmt.Sprintf("Failed to queue refinement: %v", err), "")
+		return job, fmt.Errorf("failed to create Cloud Task: %v", err)
+	}
+
+	log.Printf("Dispatched refinement of revision %d for job %s", revision, deck.ID)
+	return job, nil
+}
+
+// claimIdempotencyKey assigns the idempotency key of the options to a job, or
+// returns the job already holding it
+func (s *Service) claimIdempotencyKey(ctx context.Context, id string, options JobOptions) (*Job, error) {
+	keyID := idempotencyKeyID(options.Owner, options.IdempotencyKey)
+	expiresAt := time.Now().Add(idempotencyKeyTTL).Unix()
+	for attempt := 0; attempt < 2; attempt++ {
+		holder, err := s.in(id).jobs.ClaimIdempotencyKey(ctx, keyID, id, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim idempotency key: %v", err)
+		}
+		if holder == id {
+			return nil, nil
+		}
+		if job := s.GetJob(holder); job != nil {
+			log.Printf("Idempotency key already used by job %s", holder)
+			return job, nil
+		}
+		// The holding job expired and was deleted by GetJob, so the key is free now
+	}
+	return nil, fmt.Errorf("failed to claim idempotency key")
+}
+
+// releaseIdempotencyKey frees the idempotency key of a failed job so the client can retry with it
+func (s *Service) releaseIdempotencyKey(ctx context.Context, options JobOptions) {
+	if options.IdempotencyKey == "" {
+		return
+	}
+	if err := s.of(options.Region).jobs.ReleaseIdempotencyKey(ctx, idempotencyKeyID(options.Owner, options.IdempotencyKey)); err != nil {
+		log.Printf("Failed to release idempotency key: %v", err)
 	}
-	
-	log.Printf("Created Cloud Task for job %s with %d file references", job.ID, len(fileRefs))
-	return nil
 }
 
-// GetJob retrieves a job by its ID from Firestore
+// GetJob retrieves a job by its ID from the job store
 func (s *Service) GetJob(id string) *Job {
 	ctx := context.Background()
-	doc, err := s.Collection().Doc(id).Get(ctx)
+	firestoreJob, err := s.in(id).jobs.GetJob(ctx, id)
 	if err != nil {
-		if status.Code(err) == codes.NotFound {
-			log.Printf("Job %s not found in Firestore", id)
+		if errors.Is(err, ErrNotFound) {
+			log.Printf("Job %s not found", id)
 			return nil
 		}
 		log.Printf("Error retrieving job %s: %v", id, err)
 		return nil
 	}
 
-	var firestoreJob FirestoreJob
-	if err := doc.DataTo(&firestoreJob); err != nil {
-		log.Printf("Error parsing job data: %v", err)
-		return nil
-	}
-
 	// Check if job has expired
 	now := time.Now().Unix()
 	if firestoreJob.ExpiresAt > 0 && now > firestoreJob.ExpiresAt {
 		// Job has expired, delete it
-		_, err := s.Collection().Doc(id).Delete(ctx)
-		if err != nil {
+		if err := s.in(id).jobs.DeleteJob(ctx, id); err != nil {
 			log.Printf("Failed to delete expired job %s: %v", id, err)
 		} else {
 			log.Printf("Deleted expired job %s", id)
@@ -357,27 +942,78 @@ func (s *Service) GetJob(id string) *Job {
 		return nil
 	}
 
-	// Get the result if available
-	var resultURL string
-	if firestoreJob.Status == string(StatusCompleted) {
-		resultDoc, err := s.ResultsCollection().Doc(id).Get(ctx)
-		if err == nil && resultDoc.Exists() {
-			var result FirestoreResult
-			if err := resultDoc.DataTo(&result); err == nil {
-				resultURL = result.ResultURL
+	// Convert to job object
+	return &Job{
+		ID:             firestoreJob.ID,
+		Status:         JobStatus(firestoreJob.Status),
+		Message:        firestoreJob.Message,
+		MessageCode:    firestoreJob.MessageCode,
+		MessageParams:  firestoreJob.MessageParams,
+		ResultURL:      s.resultURL(ctx, firestoreJob),
+		CreatedAt:      firestoreJob.CreatedAt,
+		UpdatedAt:      firestoreJob.UpdatedAt,
+		Warnings:       firestoreJob.Warnings,
+		ClaimTokenHash: firestoreJob.ClaimTokenHash,
+	}
+}
+
+// ListJobs returns the most recent jobs matching a query, newest first
+func (s *Service) ListJobs(ctx context.Context, query JobQuery, limit int) ([]JobSummary, error) {
+	// Workspaces that moved to another region have jobs in both
+	firestoreJobs, err := s.jobs.ListJobs(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+	for id, regional := range s.regions {
+		regionalJobs, err := regional.jobs.ListJobs(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list jobs in region %s: %v", id, err)
+		}
+		firestoreJobs = append(firestoreJobs, regionalJobs...)
+	}
+
+	sort.Slice(firestoreJobs, func(i, j int) bool {
+		return firestoreJobs[i].CreatedAt > firestoreJobs[j].CreatedAt
+	})
+	if len(firestoreJobs) > limit {
+		firestoreJobs = firestoreJobs[:limit]
+	}
+
+	summaries := make([]JobSummary, 0, len(firestoreJobs))
+	for _, job := range firestoreJobs {
+		summary := JobSummary{
+			ID:        job.ID,
+			Status:    JobStatus(job.Status),
+			Message:   job.Message,
+			Labels:    job.Labels,
+			CreatedAt: job.CreatedAt,
+			UpdatedAt: job.UpdatedAt,
+		}
+		if job.Status == string(StatusCompleted) && !job.Ephemeral {
+			summary.ThumbnailURL = "/results/" + job.ID + "/thumbnail"
+			if result, err := s.in(job.ID).jobs.GetResult(ctx, job.ID); err == nil {
+				summary.Downloads = result.Downloads
+				summary.LastAccessedAt = result.LastAccessedAt
+				summary.ResultExpiresAt = result.ExpiresAt
+			} else if !errors.Is(err, ErrNotFound) {
+				log.Printf("Failed to get the result of job %s for the job history: %v", job.ID, err)
 			}
 		}
+		summaries = append(summaries, summary)
 	}
+	return summaries, nil
+}
 
-	// Convert to job object
-	return &Job{
-		ID:        firestoreJob.ID,
-		Status:    JobStatus(firestoreJob.Status),
-		Message:   firestoreJob.Message,
-		ResultURL: resultURL,
-		CreatedAt: firestoreJob.CreatedAt,
-		UpdatedAt: firestoreJob.UpdatedAt,
+// resultURL returns the result URL of a completed job
+func (s *Service) resultURL(ctx context.Context, job *FirestoreJob) string {
+	if job.Status != string(StatusCompleted) {
+		return ""
 	}
+	result, err := s.in(job.ID).jobs.GetResult(ctx, job.ID)
+	if err != nil {
+		return ""
+	}
+	return result.ResultURL
 }
 
 // WatchJob watches a job for changes and sends updates to the provided channel
@@ -390,62 +1026,48 @@ func (s *Service) WatchJob(ctx context.Context, jobID string, updates chan<- Job
 	}
 
 	// Send initial status
-	updates <- JobUpdate{
-		ID:        job.ID,
-		Status:    job.Status,
-		Message:   job.Message,
-		ResultURL: job.ResultURL,
-		UpdatedAt: job.UpdatedAt,
+	select {
+	case updates <- JobUpdate{
+		ID:            job.ID,
+		Status:        job.Status,
+		Message:       job.Message,
+		ResultURL:     job.ResultURL,
+		UpdatedAt:     job.UpdatedAt,
+		Warnings:      job.Warnings,
+		MessageCode:   job.MessageCode,
+		MessageParams: job.MessageParams,
+	}:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
 	// If job is already in terminal state, we're done
-	if job.Status == StatusCompleted || job.Status == StatusFailed {
-		close(updates)
+	if job.Status.Terminal() {
 		return nil
 	}
 
-	// Set up Firestore snapshot listener for real-time updates
-	docRef := s.Collection().Doc(jobID)
-	snapshots := docRef.Snapshots(ctx)
+	// Set up a listener for real-time updates
+	watcher := s.in(jobID).jobs.WatchJob(ctx, jobID)
+	defer watcher.Stop()
 
 	// Watch for updates
 	for {
-		snapshot, err := snapshots.Next()
+		firestoreJob, err := watcher.Next()
 		if err != nil {
 			log.Printf("Error watching job %s: %v", jobID, err)
 			return err
 		}
 
-		if !snapshot.Exists() {
-			log.Printf("Job %s no longer exists", jobID)
-			return fmt.Errorf("job deleted")
-		}
-
-		var firestoreJob FirestoreJob
-		if err := snapshot.DataTo(&firestoreJob); err != nil {
-			log.Printf("Error parsing job data: %v", err)
-			continue
-		}
-
-		// Get result URL if job is completed
-		var resultURL string
-		if firestoreJob.Status == string(StatusCompleted) {
-			resultDoc, err := s.ResultsCollection().Doc(jobID).Get(ctx)
-			if err == nil && resultDoc.Exists() {
-				var result FirestoreResult
-				if err := resultDoc.DataTo(&result); err == nil {
-					resultURL = result.ResultURL
-				}
-			}
-		}
-
 		// Send update
 		update := JobUpdate{
-			ID:        firestoreJob.ID,
-			Status:    JobStatus(firestoreJob.Status),
-			Message:   firestoreJob.Message,
-			ResultURL: resultURL,
-			UpdatedAt: firestoreJob.UpdatedAt,
+			ID:            firestoreJob.ID,
+			Status:        JobStatus(firestoreJob.Status),
+			Message:       firestoreJob.Message,
+			ResultURL:     s.resultURL(ctx, firestoreJob),
+			UpdatedAt:     firestoreJob.UpdatedAt,
+			Warnings:      firestoreJob.Warnings,
+			MessageCode:   firestoreJob.MessageCode,
+			MessageParams: firestoreJob.MessageParams,
 		}
 
 		select {
@@ -457,31 +1079,107 @@ func (s *Service) WatchJob(ctx context.Context, jobID string, updates chan<- Job
 		}
 
 		// If job is in terminal state, we're done
-		if update.Status == StatusCompleted || update.Status == StatusFailed {
+		if update.Status.Terminal() {
 			return nil
 		}
 	}
 }
-// updateJobStatus updates a job's status in Firestore
+
+// WatchJobs watches several jobs at once, with a listener per job, and sends
+// the updates of all of them to the provided channel. It runs until every job
+// reaches a terminal state or the context is canceled. A job that can't be
+// watched doesn't stop the others.
+func (s *Service) WatchJobs(ctx context.Context, jobIDs []string, updates chan<- JobUpdate) error {
+	var wg sync.WaitGroup
+	for _, jobID := range jobIDs {
+		wg.Add(1)
+		go func(jobID string) {
+			defer wg.Done()
+			if err := s.WatchJob(ctx, jobID, updates); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Error watching job %s: %v", jobID, err)
+			}
+		}(jobID)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// WaitForJobUpdate long-polls a job: it returns the state of the job once it
+// changes, or its current state when the timeout elapses. A job updated after
+// since, a Unix time, is returned at once, so clients polling in a loop don't
+// miss changes between requests. Jobs in a terminal state are returned at once.
+func (s *Service) WaitForJobUpdate(ctx context.Context, jobID string, since int64, timeout time.Duration) (*JobUpdate, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	updates := make(chan JobUpdate, 10)
+	watchErr := make(chan error, 1)
+	go func() {
+		defer close(updates)
+		watchErr <- s.WatchJob(waitCtx, jobID, updates)
+	}()
+
+	var current *JobUpdate
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				// The watch ended, either at a terminal state or with an error
+				if current == nil {
+					return nil, <-watchErr
+				}
+				return current, nil
+			}
+			if current == nil {
+				current = &update
+				if since > 0 && update.UpdatedAt > since {
+					return current, nil
+				}
+				continue
+			}
+			// The listener starts with the state the watch began with
+			if update.Status != current.Status || update.Message != current.Message || update.UpdatedAt != current.UpdatedAt {
+				return &update, nil
+			}
+
+		case <-waitCtx.Done():
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if current == nil {
+				return nil, fmt.Errorf("timed out waiting for job %s", jobID)
+			}
+			return current, nil
+		}
+	}
+}
+
+// updateJobStatus updates a job's status in the job store
 func (s *Service) updateJobStatus(job *Job, status JobStatus, message, resultURL string) {
 	ctx := context.Background()
 	now := time.Now().Unix()
 
-	// Update job in Firestore
-	updates := []firestore.Update{
-		{Path: "status", Value: string(status)},
-		{Path: "message", Value: message},
-		{Path: "updatedAt", Value: now},
+	// Update job in the store
+	// Messages set here are errors, which aren't localized
+	err := s.in(job.ID).jobs.TransitionJob(ctx, job.ID, status, map[string]interface{}{
+		"message":       message,
+		"messageCode":   "",
+		"messageParams": map[string]string(nil),
+		"updatedAt":     now,
+	})
+	if errors.Is(err, ErrIllegalTransition) {
+		log.Printf("Not updating job %s: %v", job.ID, err)
+		return
 	}
-
-	_, err := s.Collection().Doc(job.ID).Update(ctx, updates)
 	if err != nil {
-		log.Printf("Failed to update job status in Firestore: %v", err)
+		log.Printf("Failed to update job status: %v", err)
 	}
 
 	// Update the in-memory job
 	job.Status = status
 	job.Message = message
+	job.MessageCode = ""
+	job.MessageParams = nil
 	job.UpdatedAt = now
 	if resultURL != "" {
 		job.ResultURL = resultURL
@@ -490,33 +1188,107 @@ func (s *Service) updateJobStatus(job *Job, status JobStatus, message, resultURL
 	log.Printf("Job %s updated: status=%s, message=%s", job.ID, status, message)
 }
 
-// GetResult retrieves a job result from Firestore
+// GetResult retrieves a job result from the job store. The error wraps
+// ErrNotFound when there is no result or it expired.
 func (s *Service) GetResult(ctx context.Context, jobID string) (*FirestoreResult, error) {
-	doc, err := s.ResultsCollection().Doc(jobID).Get(ctx)
+	result, err := s.in(jobID).jobs.GetResult(ctx, jobID)
 	if err != nil {
-		if status.Code(err) == codes.NotFound {
-			return nil, fmt.Errorf("result not found")
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("result %w", ErrNotFound)
 		}
 		return nil, fmt.Errorf("error retrieving result: %v", err)
 	}
-	
-	var result FirestoreResult
-	if err := doc.DataTo(&result); err != nil {
-		return nil, fmt.Errorf("error parsing result data: %v", err)
-	}
-	
+
 	// Check if result has expired
 	now := time.Now().Unix()
 	if result.ExpiresAt > 0 && now > result.ExpiresAt {
 		// Result has expired, delete it
-		_, err := s.ResultsCollection().Doc(jobID).Delete(ctx)
-		if err != nil {
+		s.deleteResultFiles(ctx, result)
+		if err := s.in(jobID).jobs.DeleteResult(ctx, jobID); err != nil {
 			log.Printf("Failed to delete expired result %s: %v", jobID, err)
 		} else {
 			log.Printf("Deleted expired result %s", jobID)
 		}
-		return nil, fmt.Errorf("result has expired")
+		return nil, fmt.Errorf("%w: result has expired", ErrNotFound)
+	}
+
+	if result.Ephemeral {
+		return nil, ErrEphemeralResult
+	}
+
+	// Results stored before they recorded who they belong to take it from
+	// their job, while it still exists
+	if result.Owner == "" && result.ClaimTokenHash == "" {
+		if job, err := s.in(jobID).jobs.GetJob(ctx, jobID); err == nil {
+			result.Owner, result.WorkspaceID = job.Owner, job.WorkspaceID
+		}
+	}
+
+	return result, nil
+}
+
+// TakeEphemeralResult returns the result of an ephemeral job and deletes it
+// along with the job, so the result can be fetched only once. It returns
+// ErrInvalidResultToken if the token isn't the result token of the job, and
+// ErrNotFound if there is no result or it was already fetched.
+func (s *Service) TakeEphemeralResult(ctx context.Context, jobID, token string) (*FirestoreResult, error) {
+	job, err := s.in(jobID).jobs.GetJob(ctx, jobID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving job: %v", err)
+	}
+	if !job.Ephemeral || subtle.ConstantTimeCompare([]byte(tokenHash(token)), []byte(job.ResultTokenHash)) != 1 {
+		return nil, ErrInvalidResultToken
+	}
+
+	// Taking the result deletes it, so a second request finds nothing
+	result, err := s.in(jobID).jobs.TakeResult(ctx, jobID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNotFound
 	}
-	
-	return &result, nil
-} 
\ No newline at end of file
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving result: %v", err)
+	}
+	if err := s.in(jobID).jobs.DeleteJob(ctx, jobID); err != nil {
+		log.Printf("Failed to delete ephemeral job %s: %v", jobID, err)
+	}
+	if result.ExpiresAt > 0 && time.Now().Unix() > result.ExpiresAt {
+		return nil, ErrNotFound
+	}
+
+	log.Printf("Delivered and deleted the result of ephemeral job %s", jobID)
+	return result, nil
+}
+
+// RecordDownload counts a download of a result, and keeps a result that is
+// downloaded for resultAccessTTL after the download, up to
+// maxAccessedResultLifetime after it was created. Failures are only logged, a
+// download isn't refused for its statistics.
+func (s *Service) RecordDownload(ctx context.Context, result *FirestoreResult, now time.Time) {
+	if result.Ephemeral {
+		return
+	}
+	var expiresAt int64
+	if extended := min(now.Add(resultAccessTTL).Unix(), result.CreatedAt+int64(maxAccessedResultLifetime.Seconds())); extended > result.ExpiresAt {
+		expiresAt = extended
+	}
+	if err := s.in(result.ID).jobs.RecordResultAccess(ctx, result.ID, now.Unix(), expiresAt); err != nil {
+		log.Printf("Failed to record a download of result %s: %v", result.ID, err)
+	}
+}
+
+// ExtendResult pushes back the expiry of a job result
+func (s *Service) ExtendResult(ctx context.Context, jobID string, expiresAt int64) error {
+	err := s.in(jobID).jobs.UpdateResult(ctx, jobID, map[string]interface{}{
+		"expiresAt": expiresAt,
+	})
+	if err != nil {
+		log.Printf("Failed to extend result %s: %v", jobID, err)
+		return fmt.Errorf("failed to extend result: %v", err)
+	}
+
+	log.Printf("Extended result %s until %s", jobID, time.Unix(expiresAt, 0).Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,231 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
+	"github.com/martin226/slideitin/backend/api/models"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// fakeCloudTasks is an in-process Cloud Tasks server that records created tasks
+type fakeCloudTasks struct {
+	taskspb.UnimplementedCloudTasksServer
+
+	mu    sync.Mutex
+	tasks []*taskspb.Task
+}
+
+func (f *fakeCloudTasks) CreateTask(ctx context.Context, req *taskspb.CreateTaskRequest) (*taskspb.Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tasks = append(f.tasks, req.Task)
+	return req.Task, nil
+}
+
+func (f *fakeCloudTasks) created() []*taskspb.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*taskspb.Task(nil), f.tasks...)
+}
+
+// testEnv holds the stores behind an integration test Service
+type testEnv struct {
+	jobs          *FirestoreJobStore
+	storageClient *storage.Client
+	bucketName    string
+	fakeTasks     *fakeCloudTasks
+}
+
+// newTestService creates a Service backed by the Firestore emulator, a fake GCS
+// server and an in-process Cloud Tasks fake. The test is skipped when the
+// emulators are not configured.
+func newTestService(t *testing.T) (*Service, *testEnv) {
+	t.Helper()
+
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" || os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST and STORAGE_EMULATOR_HOST must be set to run integration tests")
+	}
+
+	ctx := context.Background()
+
+	firestoreClient, err := firestore.NewClient(ctx, "slideitin-test")
+	if err != nil {
+		t.Fatalf("Failed to create Firestore client: %v", err)
+	}
+	t.Cleanup(func() { firestoreClient.Close() })
+
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create Cloud Storage client: %v", err)
+	}
+	t.Cleanup(func() { storageClient.Close() })
+
+	// Serve the Cloud Tasks fake on a random local port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	fakeTasks := &fakeCloudTasks{}
+	grpcServer := grpc.NewServer()
+	taskspb.RegisterCloudTasksServer(grpcServer, fakeTasks)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	taskClient, err := cloudtasks.NewClient(ctx,
+		option.WithEndpoint(listener.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create Cloud Tasks client: %v", err)
+	}
+	t.Cleanup(func() { taskClient.Close() })
+
+	env := &testEnv{
+		jobs:          NewFirestoreJobStore(firestoreClient, nil),
+		storageClient: storageClient,
+		bucketName:    "slideitin-test-files",
+		fakeTasks:     fakeTasks,
+	}
+	service := NewServiceWithStores(
+		env.jobs,
+		NewGCSBlobStore(storageClient, "slideitin-test", env.bucketName, ""),
+		NewCloudTasksDispatcher(taskClient, "slideitin-test", "us-central1", "slides-generation-queue", "http://slides-service.test", "test-secret"),
+	)
+	return service, env
+}
+
+func TestJobLifecycle(t *testing.T) {
+	service, env := newTestService(t)
+	ctx := context.Background()
+
+	jobID := uuid.New().String()
+	files := []models.File{{Filename: "notes.md", Data: []byte("# Notes\n\n- one\n- two"), Type: "text/plain"}}
+	settings := models.SlideSettings{SlideDetail: "medium", Audience: "general"}
+
+	// Adding a job stores it, uploads the files and dispatches a task
+	job, err := service.AddJob(ctx, jobID, "default", files, settings, JobOptions{})
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if job.Status != StatusQueued {
+		t.Fatalf("expected status %s, got %s", StatusQueued, job.Status)
+	}
+
+	tasks := env.fakeTasks.created()
+	if len(tasks) != 1 {
+		t.Fatalf("expected 1 task, got %d", len(tasks))
+	}
+	var payload TaskPayload
+	if err := json.Unmarshal(tasks[0].GetHttpRequest().GetBody(), &payload); err != nil {
+		t.Fatalf("Failed to parse task payload: %v", err)
+	}
+	if payload.JobID != jobID || payload.Theme != "default" || len(payload.Files) != 1 {
+		t.Fatalf("unexpected task payload: %+v", payload)
+	}
+	headers := tasks[0].GetHttpRequest().GetHeaders()
+	timestamp, _ := strconv.ParseInt(headers[TimestampHeader], 10, 64)
+	if want := SignTask("test-secret", tasks[0].GetHttpRequest().GetBody(), time.Unix(timestamp, 0)); headers[SignatureHeader] != want[SignatureHeader] {
+		t.Fatalf("expected the task to be signed, got headers %v", headers)
+	}
+
+	// The uploaded file is readable at the path in the task payload
+	reader, err := env.storageClient.Bucket(env.bucketName).Object(payload.Files[0].GCSPath).NewReader(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read uploaded file: %v", err)
+	}
+	reader.Close()
+
+	// Simulate the slides service working on the job while a client watches it
+	watchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	updates := make(chan JobUpdate, 10)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- service.WatchJob(watchCtx, jobID, updates)
+	}()
+
+	first := <-updates
+	if first.Status != StatusQueued {
+		t.Fatalf("expected first update to be %s, got %s", StatusQueued, first.Status)
+	}
+
+	_, err = env.jobs.ResultsCollection().Doc(jobID).Set(ctx, FirestoreResult{
+		ID:        jobID,
+		ResultURL: "/results/" + jobID,
+		PDFData:   []byte("%PDF-1.4"),
+		HTMLData:  []byte("<html></html>"),
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to store result: %v", err)
+	}
+	_, err = env.jobs.Collection().Doc(jobID).Update(ctx, []firestore.Update{
+		{Path: "status", Value: string(StatusCompleted)},
+		{Path: "message", Value: "Slides generated successfully"},
+		{Path: "updatedAt", Value: time.Now().Unix()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to complete job: %v", err)
+	}
+
+	var last JobUpdate
+	for update := range updates {
+		last = update
+		if update.Status == StatusCompleted {
+			break
+		}
+	}
+	if last.Status != StatusCompleted || last.ResultURL != "/results/"+jobID {
+		t.Fatalf("expected a completed update with the result URL, got %+v", last)
+	}
+	if err := <-watchErr; err != nil {
+		t.Fatalf("WatchJob failed: %v", err)
+	}
+
+	// The result can be retrieved until it expires
+	result, err := service.GetResult(ctx, jobID)
+	if err != nil {
+		t.Fatalf("GetResult failed: %v", err)
+	}
+	if string(result.PDFData) != "%PDF-1.4" {
+		t.Fatalf("unexpected PDF data: %q", result.PDFData)
+	}
+}
+
+func TestExpiredResultIsDeleted(t *testing.T) {
+	service, env := newTestService(t)
+	ctx := context.Background()
+
+	jobID := uuid.New().String()
+	_, err := env.jobs.ResultsCollection().Doc(jobID).Set(ctx, FirestoreResult{
+		ID:        jobID,
+		CreatedAt: time.Now().Add(-2 * time.Hour).Unix(),
+		ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to store result: %v", err)
+	}
+
+	if _, err := service.GetResult(ctx, jobID); err == nil {
+		t.Fatal("expected an error for an expired result")
+	}
+	if _, err := service.GetResult(ctx, jobID); err == nil || err.Error() != "result not found" {
+		t.Fatalf("expected the expired result to be deleted, got %v", err)
+	}
+}
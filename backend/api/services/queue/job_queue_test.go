@@ -0,0 +1,1401 @@
+package queue
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+// memoryJobStore is an in-memory JobStore
+type memoryJobStore struct {
+	mu        sync.Mutex
+	jobs      map[string]FirestoreJob
+	results   map[string]FirestoreResult
+	keys      map[string]string
+	decks     map[string]FirestoreDeck
+	revisions map[string][]FirestoreRevision
+	captures  map[string]FirestoreCapture
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{
+		jobs:      make(map[string]FirestoreJob),
+		results:   make(map[string]FirestoreResult),
+		keys:      make(map[string]string),
+		decks:     make(map[string]FirestoreDeck),
+		revisions: make(map[string][]FirestoreRevision),
+		captures:  make(map[string]FirestoreCapture),
+	}
+}
+
+func (m *memoryJobStore) CreateJob(ctx context.Context, job FirestoreJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *memoryJobStore) GetJob(ctx context.Context, id string) (*FirestoreJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+func (m *memoryJobStore) UpdateJob(ctx context.Context, id string, fields map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for path, value := range fields {
+		switch path {
+		case "status":
+			job.Status = value.(string)
+		case "message":
+			job.Message = value.(string)
+		case "messageCode":
+			job.MessageCode = value.(string)
+		case "messageParams":
+			job.MessageParams = value.(map[string]string)
+		case "updatedAt":
+			job.UpdatedAt = value.(int64)
+		case "expiresAt":
+			job.ExpiresAt = value.(int64)
+		case "warnings":
+			job.Warnings = value.([]string)
+		}
+	}
+	m.jobs[id] = job
+	return nil
+}
+
+func (m *memoryJobStore) TransitionJob(ctx context.Context, id string, status JobStatus, fields map[string]interface{}) error {
+	job, err := m.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if from := JobStatus(job.Status); !from.CanTransition(status) {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, from, status)
+	}
+	fields["status"] = string(status)
+	return m.UpdateJob(ctx, id, fields)
+}
+
+func (m *memoryJobStore) DeleteJob(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *memoryJobStore) ListJobs(ctx context.Context, query JobQuery) ([]FirestoreJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []FirestoreJob
+	for _, job := range m.jobs {
+		if query.WorkspaceID != "" && job.WorkspaceID != query.WorkspaceID {
+			continue
+		}
+		if query.WorkspaceID == "" && job.Owner != query.Owner {
+			continue
+		}
+		matches := true
+		for key, value := range query.Labels {
+			if job.Labels[key] != value {
+				matches = false
+			}
+		}
+		if matches {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *memoryJobStore) WatchJob(ctx context.Context, id string) JobWatcher {
+	return &pollingWatcher{ctx: ctx, store: m, id: id}
+}
+
+func (m *memoryJobStore) ClaimIdempotencyKey(ctx context.Context, key, jobID string, expiresAt int64) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if holder, ok := m.keys[key]; ok {
+		if _, exists := m.jobs[holder]; exists {
+			return holder, nil
+		}
+	}
+	m.keys[key] = jobID
+	return jobID, nil
+}
+
+func (m *memoryJobStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, key)
+	return nil
+}
+
+func (m *memoryJobStore) GetResult(ctx context.Context, id string) (*FirestoreResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.results[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &result, nil
+}
+
+func (m *memoryJobStore) UpdateResult(ctx context.Context, id string, fields map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.results[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if expiresAt, ok := fields["expiresAt"]; ok {
+		result.ExpiresAt = expiresAt.(int64)
+	}
+	m.results[id] = result
+	return nil
+}
+
+func (m *memoryJobStore) RecordResultAccess(ctx context.Context, id string, accessedAt, expiresAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.results[id]
+	if !ok {
+		return ErrNotFound
+	}
+	result.Downloads++
+	result.LastAccessedAt = accessedAt
+	if expiresAt > 0 {
+		result.ExpiresAt = expiresAt
+	}
+	m.results[id] = result
+	return nil
+}
+
+func (m *memoryJobStore) DeleteResult(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.results, id)
+	return nil
+}
+
+func (m *memoryJobStore) TakeResult(ctx context.Context, id string) (*FirestoreResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.results[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(m.results, id)
+	return &result, nil
+}
+
+func (m *memoryJobStore) GetDeck(ctx context.Context, id string) (*FirestoreDeck, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deck, ok := m.decks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &deck, nil
+}
+
+func (m *memoryJobStore) GetRevision(ctx context.Context, id string, number int) (*FirestoreRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, revision := range m.revisions[id] {
+		if revision.Number == number {
+			return &revision, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryJobStore) GetCapture(ctx context.Context, id string) (*FirestoreCapture, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	capture, ok := m.captures[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &capture, nil
+}
+
+// pollingWatcher yields the job from a memoryJobStore whenever it changes
+type pollingWatcher struct {
+	ctx   context.Context
+	store *memoryJobStore
+	id    string
+	last  *FirestoreJob
+}
+
+func (w *pollingWatcher) Next() (*FirestoreJob, error) {
+	for {
+		job, err := w.store.GetJob(w.ctx, w.id)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrJobDeleted
+		}
+		if w.last == nil || !reflect.DeepEqual(job, w.last) {
+			w.last = job
+			return job, nil
+		}
+		select {
+		case <-w.ctx.Done():
+			return nil, w.ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func (w *pollingWatcher) Stop() {}
+
+// memoryBlobStore is an in-memory BlobStore
+type memoryBlobStore struct {
+	files      map[string][]byte
+	uploadedAt map[string]time.Time
+	uploads    int
+	err        error
+	failPath   string // Uploads to this path fail with err
+}
+
+func (m *memoryBlobStore) Upload(ctx context.Context, path, contentType string, data []byte) error {
+	if m.err != nil && (m.failPath == "" || m.failPath == path) {
+		return m.err
+	}
+	if m.uploadedAt == nil {
+		m.uploadedAt = make(map[string]time.Time)
+	}
+	m.files[path] = data
+	m.uploadedAt[path] = time.Now()
+	m.uploads++
+	return nil
+}
+
+func (m *memoryBlobStore) List(ctx context.Context, prefix string) ([]BlobObject, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	var objects []BlobObject
+	for path, data := range m.files {
+		if strings.HasPrefix(path, prefix) {
+			objects = append(objects, BlobObject{Path: path, Size: int64(len(data)), UpdatedAt: m.uploadedAt[path]})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+func (m *memoryBlobStore) Delete(ctx context.Context, path string) error {
+	if _, ok := m.files[path]; !ok {
+		return ErrNotFound
+	}
+	delete(m.files, path)
+	delete(m.uploadedAt, path)
+	return nil
+}
+
+func (m *memoryBlobStore) Open(ctx context.Context, path string) (io.ReadSeekCloser, *BlobObject, error) {
+	data, ok := m.files[path]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	object := &BlobObject{Path: path, Size: int64(len(data)), UpdatedAt: m.uploadedAt[path], ETag: fmt.Sprintf("etag-%d", len(data))}
+	return nopSeekCloser{bytes.NewReader(data)}, object, nil
+}
+
+func (m *memoryBlobStore) UploadedAt(ctx context.Context, path string) (time.Time, error) {
+	uploadedAt, ok := m.uploadedAt[path]
+	if !ok {
+		return time.Time{}, ErrNotFound
+	}
+	return uploadedAt, nil
+}
+
+// recordingDispatcher is a TaskDispatcher that records dispatched payloads
+type recordingDispatcher struct {
+	payloads    []TaskPayload
+	refinements []RefinePayload
+	indexing    []IndexPayload
+	err         error
+}
+
+func (r *recordingDispatcher) Dispatch(ctx context.Context, payload TaskPayload) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.payloads = append(r.payloads, payload)
+	return nil
+}
+
+func (r *recordingDispatcher) DispatchRefinement(ctx context.Context, payload RefinePayload) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.refinements = append(r.refinements, payload)
+	return nil
+}
+
+func (r *recordingDispatcher) DispatchIndexing(ctx context.Context, payload IndexPayload) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.indexing = append(r.indexing, payload)
+	return nil
+}
+
+// notesHash is the SHA-256 of the content of testFiles
+const notesHash = "360aa5ebfa18efd19f60eb2432c11d53e21586ff3a392ca246980970de15f0c4"
+
+func testFiles() []models.File {
+	return []models.File{{Filename: "notes.md", Data: []byte("# Notes"), Type: "text/plain"}}
+}
+
+func TestAddJobDispatchesTask(t *testing.T) {
+	jobs := newMemoryJobStore()
+	blobs := &memoryBlobStore{files: make(map[string][]byte)}
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, blobs, tasks)
+
+	options := JobOptions{NotifyEmail: "user@example.com", Features: map[string]bool{"chunked_mode": false}, Debug: true}
+	job, err := service.AddJob(context.Background(), "job-1", "beam", testFiles(), models.SlideSettings{}, options)
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if job.Status != StatusQueued {
+		t.Fatalf("expected status %s, got %s", StatusQueued, job.Status)
+	}
+
+	notesPath := "content/" + notesHash
+	if string(blobs.files[notesPath]) != "# Notes" {
+		t.Fatalf("expected the file to be uploaded, got %v", blobs.files)
+	}
+	if len(tasks.payloads) != 1 {
+		t.Fatalf("expected 1 dispatched task, got %d", len(tasks.payloads))
+	}
+	payload := tasks.payloads[0]
+	if payload.JobID != "job-1" || payload.Theme != "beam" || payload.NotifyEmail != "user@example.com" || payload.Features["chunked_mode"] != false || len(payload.Features) != 1 || !payload.Debug {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+	if len(payload.Files) != 1 || payload.Files[0].GCSPath != notesPath || payload.Files[0].Hash != notesHash {
+		t.Fatalf("unexpected file references: %+v", payload.Files)
+	}
+}
+
+func TestAddJobKeepsJobsInTheirRegion(t *testing.T) {
+	jobs := newMemoryJobStore()
+	blobs := &memoryBlobStore{files: make(map[string][]byte)}
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, blobs, tasks)
+	euJobs := newMemoryJobStore()
+	euBlobs := &memoryBlobStore{files: make(map[string][]byte)}
+	euTasks := &recordingDispatcher{}
+	service.AddRegion("eu", euJobs, euBlobs, euTasks)
+
+	id := service.NewJobID("eu")
+	if !strings.HasPrefix(id, "eu-") || service.Region(id) != "eu" {
+		t.Fatalf("expected an ID in the eu region, got %s", id)
+	}
+	if _, err := service.AddJob(context.Background(), id, "beam", testFiles(), models.SlideSettings{}, JobOptions{Region: "eu"}); err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if _, ok := euJobs.jobs[id]; !ok || len(jobs.jobs) != 0 {
+		t.Fatalf("expected the job to be stored in the eu region only")
+	}
+	if euBlobs.uploads != 1 || blobs.uploads != 0 {
+		t.Fatalf("expected the file to be uploaded to the eu region only")
+	}
+	if len(euTasks.payloads) != 1 || len(tasks.payloads) != 0 {
+		t.Fatalf("expected the task to be dispatched to the eu region only")
+	}
+	if job := service.GetJob(id); job == nil || job.ID != id {
+		t.Fatalf("expected the job to be read from the eu region, got %+v", job)
+	}
+
+	if _, err := service.AddJob(context.Background(), service.NewJobID("ap"), "beam", testFiles(), models.SlideSettings{}, JobOptions{Region: "ap"}); !errors.Is(err, ErrUnknownRegion) {
+		t.Fatalf("expected ErrUnknownRegion, got %v", err)
+	}
+	if _, err := service.AddJob(context.Background(), service.NewJobID(""), "beam", testFiles(), models.SlideSettings{}, JobOptions{Region: "eu"}); err == nil {
+		t.Fatalf("expected an ID outside the region to be refused")
+	}
+}
+
+func TestAddJobReferencesLibraryDocuments(t *testing.T) {
+	jobs := newMemoryJobStore()
+	blobs := &memoryBlobStore{files: make(map[string][]byte)}
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, blobs, tasks)
+
+	document := FileReference{Filename: "handbook.pdf", Type: "application/pdf", GCSPath: "library/ws-1/abc.pdf", Hash: "abc", IndexPath: "library/ws-1/abc.index.json"}
+	options := JobOptions{WorkspaceID: "ws-1", Documents: []FileReference{document}}
+	if _, err := service.AddJob(context.Background(), "job-1", "beam", nil, models.SlideSettings{}, options); err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if blobs.uploads != 0 {
+		t.Fatalf("expected library documents not to be uploaded again, got %d uploads", blobs.uploads)
+	}
+	if files := tasks.payloads[0].Files; len(files) != 1 || files[0] != document {
+		t.Fatalf("expected the library document to be referenced, got %+v", files)
+	}
+}
+
+func TestAddJobReusesUploadedContent(t *testing.T) {
+	jobs := newMemoryJobStore()
+	blobs := &memoryBlobStore{files: make(map[string][]byte)}
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, blobs, tasks)
+
+	for _, id := range []string{"job-1", "job-2"} {
+		if _, err := service.AddJob(context.Background(), id, "beam", testFiles(), models.SlideSettings{}, JobOptions{}); err != nil {
+			t.Fatalf("AddJob failed: %v", err)
+		}
+	}
+	if blobs.uploads != 1 {
+		t.Fatalf("expected the content to be uploaded once, got %d uploads", blobs.uploads)
+	}
+	if tasks.payloads[0].Files[0].GCSPath != tasks.payloads[1].Files[0].GCSPath {
+		t.Fatalf("expected both jobs to share the file, got %+v", tasks.payloads)
+	}
+
+	// Content uploaded before the reuse window is written again
+	blobs.uploadedAt["content/"+notesHash] = time.Now().Add(-contentReuseWindow - time.Minute)
+	if _, err := service.AddJob(context.Background(), "job-3", "beam", testFiles(), models.SlideSettings{}, JobOptions{}); err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if blobs.uploads != 2 {
+		t.Fatalf("expected stale content to be uploaded again, got %d uploads", blobs.uploads)
+	}
+}
+
+func TestAddJobEphemeral(t *testing.T) {
+	jobs := newMemoryJobStore()
+	blobs := &memoryBlobStore{files: make(map[string][]byte)}
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, blobs, tasks)
+
+	job, err := service.AddJob(context.Background(), "job-1", "beam", testFiles(), models.SlideSettings{}, JobOptions{Ephemeral: true})
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if job.ResultToken == "" {
+		t.Fatal("expected a result token")
+	}
+
+	stored := jobs.jobs["job-1"]
+	if !stored.Ephemeral || stored.ResultTokenHash != tokenHash(job.ResultToken) || stored.ExpiresAt == 0 {
+		t.Fatalf("expected an expiring ephemeral job storing the token hash, got %+v", stored)
+	}
+	// Files of ephemeral jobs aren't shared with other jobs
+	payload := tasks.payloads[0]
+	if !payload.Ephemeral || payload.Files[0].GCSPath != "job-1/notes.md" || payload.Files[0].Hash != "" {
+		t.Fatalf("expected an ephemeral payload with a file of its own, got %+v", payload)
+	}
+}
+
+func TestAddJobIssuesClaimTokenToAnonymousJobs(t *testing.T) {
+	jobs := newMemoryJobStore()
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, &memoryBlobStore{files: make(map[string][]byte)}, tasks)
+
+	job, err := service.AddJob(context.Background(), "job-1", "beam", testFiles(), models.SlideSettings{}, JobOptions{})
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if job.ClaimToken == "" {
+		t.Fatal("expected a claim token")
+	}
+	hash := jobs.jobs["job-1"].ClaimTokenHash
+	if hash != tokenHash(job.ClaimToken) || tasks.payloads[0].ClaimTokenHash != hash {
+		t.Fatalf("expected the token hash stored and sent with the task, got %q and %q", hash, tasks.payloads[0].ClaimTokenHash)
+	}
+	if stored := service.GetJob("job-1"); stored.ClaimToken != "" || stored.ClaimTokenHash != hash {
+		t.Fatalf("expected only the hash of a stored job, got %+v", stored)
+	}
+
+	// Jobs of accounts are accessed with the account's credentials
+	owned, err := service.AddJob(context.Background(), "job-2", "beam", testFiles(), models.SlideSettings{}, JobOptions{Owner: "key-1"})
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if owned.ClaimToken != "" || jobs.jobs["job-2"].ClaimTokenHash != "" {
+		t.Fatalf("expected no claim token for an owned job, got %+v", owned)
+	}
+}
+
+func TestValidClaim(t *testing.T) {
+	hash := tokenHash("secret")
+	tests := []struct {
+		hash   string
+		tokens []string
+		valid  bool
+	}{
+		{hash, []string{"secret"}, true},
+		{hash, []string{"other", "secret"}, true},
+		{hash, []string{"other"}, false},
+		{hash, nil, false},
+		{"", nil, true}, // Owned jobs have no claim token
+	}
+
+	for _, test := range tests {
+		if valid := ValidClaim(test.hash, test.tokens); valid != test.valid {
+			t.Errorf("tokens %v: expected %t, got %t", test.tokens, test.valid, valid)
+		}
+	}
+}
+
+func TestAccessCheck(t *testing.T) {
+	hash := tokenHash("secret")
+	tests := []struct {
+		name        string
+		access      Access
+		owner       string
+		workspaceID string
+		claim       string
+		err         error
+	}{
+		{"claimed", Access{Claims: []string{"secret"}}, "", "", hash, nil},
+		{"unclaimed", Access{Owner: "key-1"}, "", "", hash, ErrClaimRequired},
+		{"owner", Access{Owner: "key-1"}, "key-1", "", "", nil},
+		{"workspace member", Access{Owner: "key-2", WorkspaceID: "ws-1"}, "key-1", "ws-1", "", nil},
+		{"other workspace", Access{Owner: "key-2", WorkspaceID: "ws-2"}, "key-1", "ws-1", "", ErrNotOwner},
+		{"no workspace", Access{Owner: "key-2"}, "key-1", "", "", ErrNotOwner},
+		{"anonymous", Access{Claims: []string{"secret"}}, "key-1", "", "", ErrNotOwner},
+	}
+
+	for _, test := range tests {
+		if err := test.access.Check(test.owner, test.workspaceID, test.claim); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestTakeEphemeralResultOnce(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{files: make(map[string][]byte)}, &recordingDispatcher{})
+
+	job, err := service.AddJob(context.Background(), "job-1", "beam", testFiles(), models.SlideSettings{}, JobOptions{Ephemeral: true})
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	jobs.results["job-1"] = FirestoreResult{ID: "job-1", PDFData: []byte("%PDF-1.4"), Ephemeral: true}
+
+	if _, err := service.GetResult(context.Background(), "job-1"); !errors.Is(err, ErrEphemeralResult) {
+		t.Fatalf("expected the result to need its token, got %v", err)
+	}
+	if _, err := service.TakeEphemeralResult(context.Background(), "job-1", "wrong"); !errors.Is(err, ErrInvalidResultToken) {
+		t.Fatalf("expected ErrInvalidResultToken, got %v", err)
+	}
+
+	result, err := service.TakeEphemeralResult(context.Background(), "job-1", job.ResultToken)
+	if err != nil || string(result.PDFData) != "%PDF-1.4" {
+		t.Fatalf("expected the result, got %+v, %v", result, err)
+	}
+	if _, ok := jobs.results["job-1"]; ok {
+		t.Fatal("expected the result to be deleted")
+	}
+	if _, ok := jobs.jobs["job-1"]; ok {
+		t.Fatal("expected the job to be deleted")
+	}
+	if _, err := service.TakeEphemeralResult(context.Background(), "job-1", job.ResultToken); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the second fetch to find nothing, got %v", err)
+	}
+}
+
+func TestRecordDownload(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	tests := []struct {
+		name      string
+		result    FirestoreResult
+		downloads int64
+		expiresAt int64
+	}{
+		{
+			name:      "extends expiry",
+			result:    FirestoreResult{ID: "job-1", CreatedAt: now.Unix() - 600, ExpiresAt: now.Unix() + 60},
+			downloads: 1,
+			expiresAt: now.Add(resultAccessTTL).Unix(),
+		},
+		{
+			name:      "keeps a later expiry",
+			result:    FirestoreResult{ID: "job-1", CreatedAt: now.Unix() - 600, ExpiresAt: now.Unix() + 7200},
+			downloads: 1,
+			expiresAt: now.Unix() + 7200,
+		},
+		{
+			name:      "caps expiry at the maximum lifetime",
+			result:    FirestoreResult{ID: "job-1", CreatedAt: now.Add(-maxAccessedResultLifetime).Unix() + 60, ExpiresAt: now.Unix() + 30},
+			downloads: 1,
+			expiresAt: now.Unix() + 60,
+		},
+		{
+			name:      "skips ephemeral results",
+			result:    FirestoreResult{ID: "job-1", CreatedAt: now.Unix() - 600, ExpiresAt: now.Unix() + 60, Ephemeral: true},
+			downloads: 0,
+			expiresAt: now.Unix() + 60,
+		},
+	}
+
+	for _, test := range tests {
+		jobs := newMemoryJobStore()
+		service := NewServiceWithStores(jobs, &memoryBlobStore{files: make(map[string][]byte)}, &recordingDispatcher{})
+		jobs.results["job-1"] = test.result
+
+		service.RecordDownload(context.Background(), &test.result, now)
+
+		stored := jobs.results["job-1"]
+		if stored.Downloads != test.downloads || stored.ExpiresAt != test.expiresAt {
+			t.Errorf("%s: expected %d downloads and expiry %d, got %d and %d", test.name, test.downloads, test.expiresAt, stored.Downloads, stored.ExpiresAt)
+		}
+		if test.downloads > 0 && stored.LastAccessedAt != now.Unix() {
+			t.Errorf("%s: expected the last access to be recorded, got %d", test.name, stored.LastAccessedAt)
+		}
+	}
+}
+
+func TestListJobsIncludesResultStatistics(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{files: make(map[string][]byte)}, &recordingDispatcher{})
+
+	jobs.jobs["job-1"] = FirestoreJob{ID: "job-1", Owner: "key-1", Status: string(StatusCompleted), CreatedAt: 1}
+	jobs.jobs["job-2"] = FirestoreJob{ID: "job-2", Owner: "key-1", Status: string(StatusCompleted), CreatedAt: 2}
+	jobs.results["job-1"] = FirestoreResult{ID: "job-1", Downloads: 3, LastAccessedAt: 50, ExpiresAt: 100}
+
+	summaries, err := service.ListJobs(context.Background(), JobQuery{Owner: "key-1"}, 10)
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("expected both jobs, got %+v", summaries)
+	}
+	if summary := summaries[1]; summary.Downloads != 3 || summary.LastAccessedAt != 50 || summary.ResultExpiresAt != 100 {
+		t.Fatalf("expected the statistics of the result, got %+v", summary)
+	}
+	if summary := summaries[0]; summary.Downloads != 0 || summary.ResultExpiresAt != 0 {
+		t.Fatalf("expected no statistics for an expired result, got %+v", summary)
+	}
+}
+
+func TestCleanupFilesDeletesUnusedFiles(t *testing.T) {
+	now := time.Now()
+	jobs := newMemoryJobStore()
+	jobs.jobs["running"] = FirestoreJob{ID: "running", Status: string(StatusProcessing), UpdatedAt: now.Unix()}
+	jobs.jobs["failed"] = FirestoreJob{ID: "failed", Status: string(StatusFailed), UpdatedAt: now.Unix()}
+	jobs.jobs["crashed"] = FirestoreJob{ID: "crashed", Status: string(StatusProcessing), UpdatedAt: now.Add(-abandonedJobAge).Unix()}
+	jobs.jobs["gave-up"] = FirestoreJob{ID: "gave-up", Status: string(StatusFailed), UpdatedAt: now.Add(-abandonedJobAge).Unix()}
+	blobs := &memoryBlobStore{
+		files: map[string][]byte{
+			"running/a.md":             []byte("keep"),
+			"failed/a.md":              []byte("keep"),
+			"gave-up/a.md":             []byte("1234"),
+			"crashed/a.pdf":            []byte("123"),
+			"deleted/a.md":             []byte("12"),
+			"content/new":              []byte("keep"),
+			"content/old":              []byte("1"),
+			"themes/solarized/abc.css": []byte("keep"),
+			"library/ws-1/abc":         []byte("keep"),
+		},
+		uploadedAt: map[string]time.Time{
+			"content/new": now,
+			"content/old": now.Add(-contentRetention),
+		},
+	}
+	service := NewServiceWithStores(jobs, blobs, &recordingDispatcher{})
+
+	report, err := service.CleanupFiles(context.Background(), now)
+	if err != nil {
+		t.Fatalf("CleanupFiles failed: %v", err)
+	}
+	if *report != (CleanupReport{Scanned: 9, Deleted: 4, ReclaimedBytes: 10}) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(blobs.files) != 5 || blobs.files["running/a.md"] == nil || blobs.files["failed/a.md"] == nil || blobs.files["content/new"] == nil || blobs.files["themes/solarized/abc.css"] == nil || blobs.files["library/ws-1/abc"] == nil {
+		t.Fatalf("expected only the files in use to be kept, got %v", blobs.files)
+	}
+}
+
+func TestCleanupFilesDeletesExpiredResultFiles(t *testing.T) {
+	now := time.Now()
+	jobs := newMemoryJobStore()
+	jobs.results["live"] = FirestoreResult{ID: "live", ExpiresAt: now.Add(time.Hour).Unix()}
+	jobs.results["expired"] = FirestoreResult{ID: "expired", ExpiresAt: now.Add(-time.Hour).Unix()}
+	blobs := &memoryBlobStore{
+		files: map[string][]byte{
+			"results/live/presentation.pdf":    []byte("keep"),
+			"results/expired/presentation.pdf": []byte("1"),
+			"results/storing/presentation.pdf": []byte("keep"),
+			"results/purged/presentation.pdf":  []byte("12"),
+		},
+		uploadedAt: map[string]time.Time{
+			"results/storing/presentation.pdf": now,
+			"results/purged/presentation.pdf":  now.Add(-abandonedJobAge),
+		},
+	}
+	service := NewServiceWithStores(jobs, blobs, &recordingDispatcher{})
+
+	report, err := service.CleanupFiles(context.Background(), now)
+	if err != nil {
+		t.Fatalf("CleanupFiles failed: %v", err)
+	}
+	if *report != (CleanupReport{Scanned: 4, Deleted: 2, ReclaimedBytes: 3}) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if blobs.files["results/live/presentation.pdf"] == nil || blobs.files["results/storing/presentation.pdf"] == nil {
+		t.Fatalf("expected the files of live and just stored results to be kept, got %v", blobs.files)
+	}
+}
+
+func TestCleanupFilesDeletesExpiredCaptureInputs(t *testing.T) {
+	now := time.Now()
+	jobs := newMemoryJobStore()
+	jobs.captures["live"] = FirestoreCapture{ID: "live", ExpiresAt: now.Add(time.Hour).Unix()}
+	jobs.captures["expired"] = FirestoreCapture{ID: "expired", ExpiresAt: now.Add(-time.Hour).Unix()}
+	blobs := &memoryBlobStore{
+		files: map[string][]byte{
+			"captures/live/inputs/0":    []byte("keep"),
+			"captures/expired/inputs/0": []byte("1"),
+			"captures/storing/inputs/0": []byte("keep"),
+			"captures/failed/inputs/0":  []byte("12"),
+		},
+		uploadedAt: map[string]time.Time{
+			"captures/storing/inputs/0": now,
+			"captures/failed/inputs/0":  now.Add(-abandonedJobAge),
+		},
+	}
+	service := NewServiceWithStores(jobs, blobs, &recordingDispatcher{})
+
+	report, err := service.CleanupFiles(context.Background(), now)
+	if err != nil {
+		t.Fatalf("CleanupFiles failed: %v", err)
+	}
+	if *report != (CleanupReport{Scanned: 4, Deleted: 2, ReclaimedBytes: 3}) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if blobs.files["captures/live/inputs/0"] == nil || blobs.files["captures/storing/inputs/0"] == nil {
+		t.Fatalf("expected the inputs of live and just stored captures to be kept, got %v", blobs.files)
+	}
+}
+
+func TestOpenResultFile(t *testing.T) {
+	blobs := &memoryBlobStore{files: map[string][]byte{"results/job-1/presentation.pdf": []byte("%PDF-1.4 stored")}}
+	service := NewServiceWithStores(newMemoryJobStore(), blobs, &recordingDispatcher{})
+	ctx := context.Background()
+
+	// Documents in Cloud Storage are read from where the reader seeks to
+	stored := &FirestoreResult{ID: "job-1", PDFPath: "results/job-1/presentation.pdf", HTMLData: []byte("<html></html>")}
+	file, err := service.OpenResultFile(ctx, stored, ResultPDF)
+	if err != nil {
+		t.Fatalf("OpenResultFile failed: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Seek(9, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	if data, _ := io.ReadAll(file); string(data) != "stored" || file.ContentType != "application/pdf" || file.ETag != `"etag-15"` {
+		t.Fatalf("unexpected stored file %q: %+v", data, file)
+	}
+
+	// Documents stored inline are served from memory, and results without a
+	// viewer fall back to the HTML
+	for _, format := range []ResultFormat{ResultHTML, ResultViewer} {
+		file, err = service.OpenResultFile(ctx, stored, format)
+		if err != nil {
+			t.Fatalf("OpenResultFile failed: %v", err)
+		}
+		if data, _ := io.ReadAll(file); string(data) != "<html></html>" || file.ContentType != "text/html" || file.ETag == "" {
+			t.Fatalf("unexpected inline %s file %q: %+v", format, data, file)
+		}
+	}
+
+	if _, err := service.OpenResultFile(ctx, stored, ResultThumbnail); err == nil {
+		t.Fatal("expected an error for a result without a thumbnail")
+	}
+	for _, format := range []ResultFormat{ResultFlashcards, ResultOnePager, ResultOnePagerMarkdown, ResultHandout, ResultHandoutMarkdown, ResultAlignment, ResultChunkSummaries} {
+		if _, err := service.OpenResultFile(ctx, stored, format); err == nil {
+			t.Fatalf("expected an error for a result without a %s document", format)
+		}
+	}
+
+	stored.PDFPath = "results/job-1/missing.pdf"
+	if _, err := service.OpenResultFile(ctx, stored, ResultPDF); err == nil {
+		t.Fatal("expected an error for a missing document")
+	}
+}
+
+func TestBundleWritesDeckForOfflineEditing(t *testing.T) {
+	jobs := newMemoryJobStore()
+	jobs.decks["job-1"] = FirestoreDeck{ID: "job-1", Revision: 2}
+	jobs.revisions["job-1"] = []FirestoreRevision{
+		{Number: 1, Markdown: "# First"},
+		{Number: 2, Markdown: "# Deck\n\n![bg right](https://example.com/chart.png)\n\n<!-- Start with the chart -->\n\n---\n\n![w:200](https://example.com/chart.png) ![](https://example.com/missing.jpg)"},
+	}
+	blobs := &memoryBlobStore{files: map[string][]byte{
+		"results/job-1/presentation.pdf": []byte("%PDF-1.4"),
+		"results/job-1/flashcards.csv":   []byte("Cache,Fast storage\n"),
+		"results/job-1/one-pager.md":     []byte("# Summary"),
+	}}
+	service := NewServiceWithStores(jobs, blobs, &recordingDispatcher{})
+	ctx := context.Background()
+
+	result := &FirestoreResult{ID: "job-1", PDFPath: "results/job-1/presentation.pdf", HTMLData: []byte("<html></html>"),
+		FlashcardsPath: "results/job-1/flashcards.csv", OnePagerMarkdownPath: "results/job-1/one-pager.md"}
+	bundle, err := service.OpenBundle(ctx, result)
+	if err != nil {
+		t.Fatalf("OpenBundle failed: %v", err)
+	}
+	defer bundle.Close()
+	fetched := 0
+	bundle.fetch = func(ctx context.Context, url string) ([]byte, string, error) {
+		fetched++
+		if url == "https://example.com/chart.png" {
+			return []byte("\x89PNG"), "image/png", nil
+		}
+		return nil, "", errors.New("unexpected status 404")
+	}
+
+	var buf bytes.Buffer
+	if err := bundle.Write(ctx, &buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read the bundle: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(data)
+	}
+
+	// Images are fetched once and linked from the markdown, images that
+	// can't be fetched keep their URL
+	expected := map[string]string{
+		"presentation.md":   "# Deck\n\n![bg right](images/01.png)\n\n<!-- Start with the chart -->\n\n---\n\n![w:200](images/01.png) ![](https://example.com/missing.jpg)",
+		"images/01.png":     "\x89PNG",
+		"notes.md":          "# Speaker notes\n\n## Slide 1: Deck\n\nStart with the chart\n",
+		"presentation.pdf":  "%PDF-1.4",
+		"presentation.html": "<html></html>",
+		"flashcards.csv":    "Cache,Fast storage\n",
+		"one-pager.md":      "# Summary",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %q, got %q", expected, files)
+	}
+	if fetched != 2 {
+		t.Errorf("expected 2 fetches, got %d", fetched)
+	}
+
+	// Results without a deck, such as ephemeral results, have no markdown
+	if _, err := service.OpenBundle(ctx, &FirestoreResult{ID: "job-2", Ephemeral: true}); err == nil {
+		t.Fatal("expected an error for a result without a deck")
+	}
+}
+
+func TestAddJobFailsWhenUploadFails(t *testing.T) {
+	jobs := newMemoryJobStore()
+	blobs := &memoryBlobStore{files: make(map[string][]byte), err: errors.New("bucket unavailable")}
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, blobs, tasks)
+
+	if _, err := service.AddJob(context.Background(), "job-1", "beam", testFiles(), models.SlideSettings{}, JobOptions{}); err == nil {
+		t.Fatal("expected AddJob to fail")
+	}
+	if len(tasks.payloads) != 0 {
+		t.Fatal("expected no task to be dispatched")
+	}
+	if stored := jobs.jobs["job-1"]; stored.Status != string(StatusFailed) {
+		t.Fatalf("expected the job to be marked failed, got %s", stored.Status)
+	}
+}
+
+func TestAddJobLeavesOutFilesThatFailToUpload(t *testing.T) {
+	jobs := newMemoryJobStore()
+	blobs := &memoryBlobStore{files: make(map[string][]byte), err: errors.New("bucket unavailable"), failPath: "job-1/broken.pdf"}
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, blobs, tasks)
+
+	files := append(testFiles(), models.File{Filename: "broken.pdf", Data: []byte("%PDF"), Type: "application/pdf"})
+	job, err := service.AddJob(context.Background(), "job-1", "beam", files, models.SlideSettings{}, JobOptions{Ephemeral: true})
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if job.Status != StatusQueued {
+		t.Fatalf("expected status %s, got %s", StatusQueued, job.Status)
+	}
+	if len(tasks.payloads) != 1 || len(tasks.payloads[0].Files) != 1 || tasks.payloads[0].Files[0].Filename != "notes.md" {
+		t.Fatalf("expected a task with only notes.md, got %+v", tasks.payloads)
+	}
+
+	expected := "File broken.pdf couldn't be uploaded and was left out: bucket unavailable"
+	if len(job.Warnings) != 1 || job.Warnings[0] != expected {
+		t.Errorf("expected warning %q, got %v", expected, job.Warnings)
+	}
+	if warnings := tasks.payloads[0].Warnings; len(warnings) != 1 || warnings[0] != expected {
+		t.Errorf("expected the task to carry the warning, got %v", warnings)
+	}
+	if stored := service.GetJob("job-1"); stored == nil || len(stored.Warnings) != 1 {
+		t.Errorf("expected the warning to be stored on the job, got %+v", stored)
+	}
+}
+
+func TestAddJobFailsWhenDispatchFails(t *testing.T) {
+	jobs := newMemoryJobStore()
+	blobs := &memoryBlobStore{files: make(map[string][]byte)}
+	tasks := &recordingDispatcher{err: errors.New("queue unavailable")}
+	service := NewServiceWithStores(jobs, blobs, tasks)
+
+	if _, err := service.AddJob(context.Background(), "job-1", "beam", testFiles(), models.SlideSettings{}, JobOptions{}); err == nil {
+		t.Fatal("expected AddJob to fail")
+	}
+	if stored := jobs.jobs["job-1"]; stored.Status != string(StatusFailed) {
+		t.Fatalf("expected the job to be marked failed, got %s", stored.Status)
+	}
+}
+
+func TestRefineDeckReopensJob(t *testing.T) {
+	jobs := newMemoryJobStore()
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, tasks)
+	deck := &FirestoreDeck{ID: "job-1", Owner: "key-1", Revision: 2}
+	jobs.jobs["job-1"] = FirestoreJob{ID: "job-1", Status: string(StatusCompleted), ExpiresAt: time.Now().Add(time.Minute).Unix()}
+
+	job, err := service.RefineDeck(context.Background(), deck, 0, 0, "Add a slide about pricing", JobOptions{})
+	if err != nil {
+		t.Fatalf("RefineDeck failed: %v", err)
+	}
+	if job.Status != StatusQueued {
+		t.Fatalf("expected status %s, got %s", StatusQueued, job.Status)
+	}
+	if stored := jobs.jobs["job-1"]; stored.Status != string(StatusQueued) || stored.ExpiresAt != 0 {
+		t.Fatalf("expected the job to be queued again without expiry, got %+v", stored)
+	}
+	if len(tasks.refinements) != 1 || tasks.refinements[0].Revision != 2 || tasks.refinements[0].Instruction != "Add a slide about pricing" {
+		t.Fatalf("expected the latest revision to be refined, got %+v", tasks.refinements)
+	}
+
+	// A second refinement waits for the first one
+	if _, err := service.RefineDeck(context.Background(), deck, 1, 0, "Shorter", JobOptions{}); !errors.Is(err, ErrJobInProgress) {
+		t.Fatalf("expected ErrJobInProgress, got %v", err)
+	}
+}
+
+func TestRefineDeckRecreatesDeletedJob(t *testing.T) {
+	jobs := newMemoryJobStore()
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, tasks)
+	deck := &FirestoreDeck{ID: "job-1", Owner: "key-1", WorkspaceID: "acme", Revision: 1}
+
+	if _, err := service.RefineDeck(context.Background(), deck, 3, 0, "Shorter", JobOptions{}); !errors.Is(err, ErrRevisionNotFound) {
+		t.Fatalf("expected ErrRevisionNotFound, got %v", err)
+	}
+	if _, err := service.RefineDeck(context.Background(), deck, 1, 0, "Shorter", JobOptions{}); err != nil {
+		t.Fatalf("RefineDeck failed: %v", err)
+	}
+	if stored := jobs.jobs["job-1"]; stored.Status != string(StatusQueued) || stored.Owner != "key-1" || stored.WorkspaceID != "acme" {
+		t.Fatalf("expected a queued job for the deck owner, got %+v", stored)
+	}
+}
+
+func TestRefineDeckRecreatesClaimOfAnonymousDeck(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, &recordingDispatcher{})
+	deck := &FirestoreDeck{ID: "job-1", ClaimTokenHash: tokenHash("secret"), Revision: 1}
+
+	if _, err := service.RefineDeck(context.Background(), deck, 1, 0, "Shorter", JobOptions{}); err != nil {
+		t.Fatalf("RefineDeck failed: %v", err)
+	}
+	if stored := jobs.jobs["job-1"]; stored.ClaimTokenHash != deck.ClaimTokenHash {
+		t.Fatalf("expected the job to keep the claim of the deck, got %+v", stored)
+	}
+}
+
+func TestRefineDeckRegeneratesSlide(t *testing.T) {
+	jobs := newMemoryJobStore()
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, tasks)
+	deck := &FirestoreDeck{ID: "job-1", Revision: 1}
+
+	job, err := service.RefineDeck(context.Background(), deck, 0, 4, "Too much text", JobOptions{})
+	if err != nil {
+		t.Fatalf("RefineDeck failed: %v", err)
+	}
+	if job.Message != "Regeneration of slide 4 queued" {
+		t.Fatalf("unexpected message: %s", job.Message)
+	}
+	if stored := jobs.jobs["job-1"]; stored.MessageCode != "regeneration_queued" || stored.MessageParams["slide"] != "4" {
+		t.Fatalf("expected the message code to be stored for localization, got %q %v", stored.MessageCode, stored.MessageParams)
+	}
+	if len(tasks.refinements) != 1 || tasks.refinements[0].Slide != 4 || tasks.refinements[0].Instruction != "Too much text" {
+		t.Fatalf("expected slide 4 to be regenerated, got %+v", tasks.refinements)
+	}
+}
+
+func TestListJobsFiltersByOwnerAndLabels(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{files: make(map[string][]byte)}, &recordingDispatcher{})
+
+	jobs.jobs["job-1"] = FirestoreJob{ID: "job-1", Owner: "key-1", Labels: map[string]string{"course": "CS101"}, CreatedAt: 1}
+	jobs.jobs["job-2"] = FirestoreJob{ID: "job-2", Owner: "key-1", Status: string(StatusCompleted), Labels: map[string]string{"course": "CS101", "term": "fall"}, CreatedAt: 3}
+	jobs.jobs["job-3"] = FirestoreJob{ID: "job-3", Owner: "key-1", Labels: map[string]string{"course": "CS202"}, CreatedAt: 2}
+	jobs.jobs["job-4"] = FirestoreJob{ID: "job-4", Owner: "key-2", Labels: map[string]string{"course": "CS101"}, CreatedAt: 4}
+
+	summaries, err := service.ListJobs(context.Background(), JobQuery{Owner: "key-1", Labels: map[string]string{"course": "CS101"}}, 10)
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(summaries) != 2 || summaries[0].ID != "job-2" || summaries[1].ID != "job-1" {
+		t.Fatalf("expected job-2 and job-1 newest first, got %+v", summaries)
+	}
+	if summaries[0].ThumbnailURL != "/results/job-2/thumbnail" || summaries[1].ThumbnailURL != "" {
+		t.Fatalf("expected a thumbnail for the completed job only, got %+v", summaries)
+	}
+
+	summaries, err = service.ListJobs(context.Background(), JobQuery{Owner: "key-1"}, 1)
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].ID != "job-2" {
+		t.Fatalf("expected only the newest job, got %+v", summaries)
+	}
+}
+
+func TestListJobsListsWorkspaceLibrary(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{files: make(map[string][]byte)}, &recordingDispatcher{})
+
+	jobs.jobs["job-1"] = FirestoreJob{ID: "job-1", Owner: "key-1", WorkspaceID: "acme", CreatedAt: 1}
+	jobs.jobs["job-2"] = FirestoreJob{ID: "job-2", Owner: "key-2", WorkspaceID: "acme", CreatedAt: 2}
+	jobs.jobs["job-3"] = FirestoreJob{ID: "job-3", Owner: "key-3", CreatedAt: 3}
+
+	summaries, err := service.ListJobs(context.Background(), JobQuery{WorkspaceID: "acme"}, 10)
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(summaries) != 2 || summaries[0].ID != "job-2" || summaries[1].ID != "job-1" {
+		t.Fatalf("expected the jobs of both members, got %+v", summaries)
+	}
+}
+
+func TestAddJobStoresLabels(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{files: make(map[string][]byte)}, &recordingDispatcher{})
+
+	options := JobOptions{Owner: "key-1", Labels: map[string]string{"client": "acme"}}
+	if _, err := service.AddJob(context.Background(), "job-1", "beam", testFiles(), models.SlideSettings{}, options); err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if stored := jobs.jobs["job-1"]; stored.Owner != "key-1" || stored.Labels["client"] != "acme" {
+		t.Fatalf("expected the owner and labels to be stored, got %+v", stored)
+	}
+}
+
+func TestAddJobReturnsExistingJobForIdempotencyKey(t *testing.T) {
+	jobs := newMemoryJobStore()
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, &memoryBlobStore{files: make(map[string][]byte)}, tasks)
+
+	options := JobOptions{Owner: "key-1", IdempotencyKey: "retry-1"}
+	first, err := service.AddJob(context.Background(), "job-1", "beam", testFiles(), models.SlideSettings{}, options)
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	second, err := service.AddJob(context.Background(), "job-2", "beam", testFiles(), models.SlideSettings{}, options)
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if first.ID != "job-1" || second.ID != "job-1" {
+		t.Fatalf("expected both requests to return job-1, got %s and %s", first.ID, second.ID)
+	}
+	if len(tasks.payloads) != 1 {
+		t.Fatalf("expected 1 dispatched task, got %d", len(tasks.payloads))
+	}
+	if _, ok := jobs.jobs["job-2"]; ok {
+		t.Fatal("expected the duplicate job to be deleted")
+	}
+
+	// The same key sent with another API key is a different request
+	other, err := service.AddJob(context.Background(), "job-3", "beam", testFiles(), models.SlideSettings{}, JobOptions{Owner: "key-2", IdempotencyKey: "retry-1"})
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if other.ID != "job-3" {
+		t.Fatalf("expected a new job for another API key, got %s", other.ID)
+	}
+}
+
+func TestAddJobReleasesIdempotencyKeyWhenDispatchFails(t *testing.T) {
+	jobs := newMemoryJobStore()
+	tasks := &recordingDispatcher{err: errors.New("queue unavailable")}
+	service := NewServiceWithStores(jobs, &memoryBlobStore{files: make(map[string][]byte)}, tasks)
+
+	options := JobOptions{IdempotencyKey: "retry-1"}
+	if _, err := service.AddJob(context.Background(), "job-1", "beam", testFiles(), models.SlideSettings{}, options); err == nil {
+		t.Fatal("expected AddJob to fail")
+	}
+
+	tasks.err = nil
+	job, err := service.AddJob(context.Background(), "job-2", "beam", testFiles(), models.SlideSettings{}, options)
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+	if job.ID != "job-2" {
+		t.Fatalf("expected the retry to create a new job, got %s", job.ID)
+	}
+}
+
+func TestGetJobDeletesExpiredJob(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, &recordingDispatcher{})
+	jobs.jobs["job-1"] = FirestoreJob{ID: "job-1", Status: string(StatusCompleted), ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+
+	if job := service.GetJob("job-1"); job != nil {
+		t.Fatalf("expected an expired job to be hidden, got %+v", job)
+	}
+	if _, ok := jobs.jobs["job-1"]; ok {
+		t.Fatal("expected the expired job to be deleted")
+	}
+}
+
+func TestGetCapture(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, &recordingDispatcher{})
+	jobs.captures["job-1"] = FirestoreCapture{ID: "job-1", Exchanges: []Exchange{{Stage: "slides", Prompt: "Create a presentation"}}, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	jobs.captures["job-2"] = FirestoreCapture{ID: "job-2", ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+
+	capture, err := service.GetCapture(context.Background(), "job-1")
+	if err != nil || len(capture.Exchanges) != 1 || capture.Exchanges[0].Prompt != "Create a presentation" {
+		t.Fatalf("unexpected capture: %+v, %v", capture, err)
+	}
+	for _, id := range []string{"job-2", "job-3"} {
+		if _, err := service.GetCapture(context.Background(), id); !errors.Is(err, ErrCaptureNotFound) {
+			t.Errorf("%s: expected ErrCaptureNotFound, got %v", id, err)
+		}
+	}
+}
+
+func TestReplayJob(t *testing.T) {
+	jobs := newMemoryJobStore()
+	tasks := &recordingDispatcher{}
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, tasks)
+	task, _ := json.Marshal(TaskPayload{
+		JobID:       "job-1",
+		Theme:       "rose-pine",
+		Files:       []FileReference{{Filename: "notes.md", GCSPath: "captures/job-1/inputs/0", Hash: "abc"}},
+		Owner:       "user-1",
+		WorkspaceID: "workspace-1",
+		NotifyEmail: "owner@example.com",
+		Debug:       true,
+	})
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	jobs.captures["job-1"] = FirestoreCapture{ID: "job-1", Task: string(task), ExpiresAt: expiresAt}
+	jobs.captures["job-2"] = FirestoreCapture{ID: "job-2", ExpiresAt: expiresAt}
+
+	job, err := service.ReplayJob(context.Background(), "job-1", "replay-1", "admin-1")
+	if err != nil {
+		t.Fatalf("ReplayJob failed: %v", err)
+	}
+	if job.ID != "replay-1" || job.Status != StatusQueued || job.Theme != "rose-pine" {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if stored := jobs.jobs["replay-1"]; stored.Owner != "admin-1" || stored.Labels[ReplayLabel] != "job-1" {
+		t.Errorf("expected the replay owned by the admin and labelled, got %+v", stored)
+	}
+	if len(tasks.payloads) != 1 {
+		t.Fatalf("expected one task, got %d", len(tasks.payloads))
+	}
+	payload := tasks.payloads[0]
+	if payload.JobID != "replay-1" || payload.Owner != "admin-1" || payload.WorkspaceID != "" || payload.NotifyEmail != "" || !payload.Debug {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+	if len(payload.Files) != 1 || payload.Files[0].GCSPath != "captures/job-1/inputs/0" {
+		t.Errorf("expected the captured inputs, got %+v", payload.Files)
+	}
+
+	if _, err := service.ReplayJob(context.Background(), "job-2", "replay-2", "admin-1"); !errors.Is(err, ErrNotReplayable) {
+		t.Errorf("expected ErrNotReplayable, got %v", err)
+	}
+	if _, err := service.ReplayJob(context.Background(), "job-3", "replay-3", "admin-1"); !errors.Is(err, ErrCaptureNotFound) {
+		t.Errorf("expected ErrCaptureNotFound, got %v", err)
+	}
+}
+
+func TestCompareReplay(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, &recordingDispatcher{})
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	jobs.jobs["job-1"] = FirestoreJob{ID: "job-1", Status: string(StatusCompleted)}
+	jobs.jobs["replay-1"] = FirestoreJob{ID: "replay-1", Status: string(StatusProcessing), Labels: map[string]string{ReplayLabel: "job-1"}}
+	jobs.jobs["other"] = FirestoreJob{ID: "other", Status: string(StatusCompleted)}
+	jobs.revisions["job-1"] = []FirestoreRevision{{Number: 1, Markdown: "# Intro\n\n---\n\n# Old"}}
+	jobs.revisions["replay-1"] = []FirestoreRevision{{Number: 1, Markdown: "# Intro\n\n---\n\n# New\n\n---\n\n# End"}}
+	jobs.captures["job-1"] = FirestoreCapture{ID: "job-1", ExpiresAt: expiresAt, Exchanges: []Exchange{
+		{Stage: "summary", Prompt: "Summarize", InputTokens: 100, OutputTokens: 10},
+		{Stage: "slides", Prompt: "Create a presentation", InputTokens: 200, OutputTokens: 50},
+	}}
+	jobs.captures["replay-1"] = FirestoreCapture{ID: "replay-1", ExpiresAt: expiresAt, Exchanges: []Exchange{
+		{Stage: "slides", Prompt: "Create a better presentation", InputTokens: 250, OutputTokens: 80},
+	}}
+	ctx := context.Background()
+
+	if _, err := service.CompareReplay(ctx, "job-1", "replay-1"); !errors.Is(err, ErrJobInProgress) {
+		t.Fatalf("expected ErrJobInProgress while the replay runs, got %v", err)
+	}
+	if _, err := service.CompareReplay(ctx, "job-1", "other"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a job that isn't a replay, got %v", err)
+	}
+
+	jobs.UpdateJob(ctx, "replay-1", map[string]interface{}{"status": string(StatusCompleted)})
+	comparison, err := service.CompareReplay(ctx, "job-1", "replay-1")
+	if err != nil {
+		t.Fatalf("CompareReplay failed: %v", err)
+	}
+	if original := comparison.Original; original.Slides != 2 || original.Prompt != "Create a presentation" || original.InputTokens != 300 || original.OutputTokens != 60 {
+		t.Errorf("unexpected original: %+v", original)
+	}
+	if replay := comparison.Replay; replay.Slides != 3 || replay.Prompt != "Create a better presentation" || replay.InputTokens != 250 {
+		t.Errorf("unexpected replay: %+v", replay)
+	}
+	if !comparison.PromptChanged || comparison.Diff.Summary.Unchanged != 1 {
+		t.Errorf("unexpected comparison: %+v, %+v", comparison, comparison.Diff.Summary)
+	}
+}
+
+func TestWatchJobStopsAtTerminalState(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, &recordingDispatcher{})
+	jobs.jobs["job-1"] = FirestoreJob{ID: "job-1", Status: string(StatusQueued)}
+	jobs.results["job-1"] = FirestoreResult{ID: "job-1", ResultURL: "/results/job-1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updates := make(chan JobUpdate, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- service.WatchJob(ctx, "job-1", updates)
+	}()
+
+	if update := <-updates; update.Status != StatusQueued {
+		t.Fatalf("expected the initial status, got %+v", update)
+	}
+	jobs.UpdateJob(ctx, "job-1", map[string]interface{}{"status": string(StatusCompleted), "updatedAt": int64(1)})
+
+	if err := <-done; err != nil {
+		t.Fatalf("WatchJob failed: %v", err)
+	}
+	var last JobUpdate
+	for len(updates) > 0 {
+		last = <-updates
+	}
+	if last.Status != StatusCompleted || last.ResultURL != "/results/job-1" {
+		t.Fatalf("expected a completed update with the result URL, got %+v", last)
+	}
+}
+
+func TestWatchJobsMultiplexesUpdates(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, &recordingDispatcher{})
+	jobs.jobs["job-1"] = FirestoreJob{ID: "job-1", Status: string(StatusProcessing)}
+	jobs.jobs["job-2"] = FirestoreJob{ID: "job-2", Status: string(StatusFailed)}
+	jobs.jobs["job-3"] = FirestoreJob{ID: "job-3", Status: string(StatusQueued)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updates := make(chan JobUpdate, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- service.WatchJobs(ctx, []string{"job-1", "job-2", "job-3", "missing"}, updates)
+	}()
+
+	initial := make(map[string]JobStatus)
+	for len(initial) < 3 {
+		update := <-updates
+		initial[update.ID] = update.Status
+	}
+	if initial["job-1"] != StatusProcessing || initial["job-2"] != StatusFailed || initial["job-3"] != StatusQueued {
+		t.Fatalf("expected the initial status of each job, got %v", initial)
+	}
+	jobs.UpdateJob(ctx, "job-1", map[string]interface{}{"status": string(StatusCompleted), "updatedAt": int64(1)})
+	jobs.UpdateJob(ctx, "job-3", map[string]interface{}{"status": string(StatusFailed), "updatedAt": int64(1)})
+
+	if err := <-done; err != nil {
+		t.Fatalf("WatchJobs failed: %v", err)
+	}
+	final := make(map[string]JobStatus)
+	for len(updates) > 0 {
+		update := <-updates
+		final[update.ID] = update.Status
+	}
+	if final["job-1"] != StatusCompleted || final["job-3"] != StatusFailed {
+		t.Fatalf("expected the terminal status of each job, got %v", final)
+	}
+}
+
+func TestWaitForJobUpdate(t *testing.T) {
+	jobs := newMemoryJobStore()
+	service := NewServiceWithStores(jobs, &memoryBlobStore{}, &recordingDispatcher{})
+	jobs.jobs["job-1"] = FirestoreJob{ID: "job-1", Status: string(StatusProcessing), Message: "Analyzing uploaded files", UpdatedAt: 10}
+	ctx := context.Background()
+
+	// Without a change, the current state is returned once the timeout elapses
+	start := time.Now()
+	update, err := service.WaitForJobUpdate(ctx, "job-1", 0, 50*time.Millisecond)
+	if err != nil || update.Message != "Analyzing uploaded files" {
+		t.Fatalf("expected the current state, got %+v, %v", update, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected to wait for the timeout, returned after %v", elapsed)
+	}
+
+	// A job updated since the last status is returned at once
+	if update, err := service.WaitForJobUpdate(ctx, "job-1", 9, time.Minute); err != nil || update.UpdatedAt != 10 {
+		t.Fatalf("expected the state updated since, got %+v, %v", update, err)
+	}
+
+	// A change is returned as soon as it happens
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		jobs.UpdateJob(ctx, "job-1", map[string]interface{}{"message": "Creating presentation with AI"})
+	}()
+	update, err = service.WaitForJobUpdate(ctx, "job-1", 10, time.Minute)
+	if err != nil || update.Message != "Creating presentation with AI" {
+		t.Fatalf("expected the changed state, got %+v, %v", update, err)
+	}
+
+	if _, err := service.WaitForJobUpdate(ctx, "missing", 0, time.Minute); err == nil {
+		t.Fatal("expected an error for a missing job")
+	}
+}
+
+func TestSignTask(t *testing.T) {
+	// The slides service verifies the same vector, keep the two in step
+	headers := SignTask("secret", []byte(`{"jobId":"job-1"}`), time.Unix(1700000000, 0))
+	if headers[TimestampHeader] != "1700000000" {
+		t.Fatalf("unexpected timestamp header %q", headers[TimestampHeader])
+	}
+	if want := "v1=8f4ecb2363ee9e747fbb19b545fc58009dad3bcf5551ec480eb795605620fed4"; headers[SignatureHeader] != want {
+		t.Fatalf("expected signature %q, got %q", want, headers[SignatureHeader])
+	}
+}
@@ -0,0 +1,170 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/services/revisions"
+)
+
+// ReplayLabel labels a replayed job with the ID of the job it replays
+const ReplayLabel = "replay-of"
+
+// ErrNotReplayable is returned when replaying a job whose capture doesn't
+// keep its inputs
+var ErrNotReplayable = errors.New("the capture of the job doesn't keep its inputs")
+
+// ReplayComparison sets the artifacts of a debugged job and its replay side
+// by side
+type ReplayComparison struct {
+	Original      ReplayArtifact  `json:"original"`
+	Replay        ReplayArtifact  `json:"replay"`
+	PromptChanged bool            `json:"promptChanged"` // The replay sent a different prompt for the slides
+	Diff          *revisions.Diff `json:"diff"`          // From the generated deck of the original to the one of the replay
+}
+
+// ReplayArtifact is what a job of a replay comparison produced
+type ReplayArtifact struct {
+	JobID        string    `json:"jobId"`
+	Status       JobStatus `json:"status"`
+	Markdown     string    `json:"markdown"` // Generated deck, empty if the job failed
+	Slides       int       `json:"slides"`
+	Prompt       string    `json:"prompt,omitempty"` // First prompt for the slides, empty if the capture expired
+	InputTokens  int       `json:"inputTokens"`      // Over all of the captured exchanges
+	OutputTokens int       `json:"outputTokens"`
+	Warnings     []string  `json:"warnings,omitempty"`
+}
+
+// ReplayJob runs the captured inputs of a debugged job again with the current
+// prompts and model, as a new debugged job owned by owner and labelled with
+// the ID of the original. The replay doesn't email or notify anyone.
+func (s *Service) ReplayJob(ctx context.Context, id, replayID, owner string) (*Job, error) {
+	// The captured inputs are stored in the region of the job
+	if s.Region(replayID) != s.Region(id) {
+		return nil, fmt.Errorf("replay %s isn't in the region of job %s", replayID, id)
+	}
+	capture, err := s.GetCapture(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if capture.Task == "" {
+		return nil, ErrNotReplayable
+	}
+	var payload TaskPayload
+	if err := json.Unmarshal([]byte(capture.Task), &payload); err != nil {
+		return nil, fmt.Errorf("failed to read the captured task: %v", err)
+	}
+
+	now := time.Now().Unix()
+	labels := map[string]string{ReplayLabel: id}
+	if err := s.in(replayID).jobs.CreateJob(ctx, FirestoreJob{
+		ID:          replayID,
+		Status:      string(StatusQueued),
+		Message:     "Job added to queue",
+		MessageCode: messageQueued,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Owner:       owner,
+		Labels:      labels,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store job: %v", err)
+	}
+	job := &Job{
+		ID:          replayID,
+		Theme:       payload.Theme,
+		Settings:    payload.Settings,
+		Options:     JobOptions{Owner: owner, Labels: labels, Debug: true},
+		Status:      StatusQueued,
+		Message:     "Job added to queue",
+		MessageCode: messageQueued,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	payload.JobID = replayID
+	payload.Owner = owner
+	payload.WorkspaceID = ""
+	payload.NotifyEmail = ""
+	payload.Webhooks = nil
+	payload.ClaimTokenHash = ""
+	payload.Ephemeral = false
+	payload.Debug = true
+	if err := s.in(replayID).tasks.Dispatch(ctx, payload); err != nil {
+		s.updateJobStatus(job, StatusFailed, fmt.Sprintf("Failed to queue job: %v", err), "")
+		return job, fmt.Errorf("failed to create Cloud Task: %v", err)
+	}
+	log.Printf("Dispatched replay %s of job %s", replayID, id)
+	return job, nil
+}
+
+// CompareReplay compares the generated decks and captures of a job and its
+// replay, or returns ErrJobInProgress until both have finished. It returns
+// ErrNotFound if either job is missing or the replay isn't one of the job.
+func (s *Service) CompareReplay(ctx context.Context, id, replayID string) (*ReplayComparison, error) {
+	replayJob, err := s.in(replayID).jobs.GetJob(ctx, replayID)
+	if err != nil {
+		return nil, err
+	}
+	if replayJob.Labels[ReplayLabel] != id {
+		return nil, ErrNotFound
+	}
+	originalJob, err := s.in(id).jobs.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	original, err := s.replayArtifact(ctx, originalJob)
+	if err != nil {
+		return nil, err
+	}
+	replay, err := s.replayArtifact(ctx, replayJob)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := revisions.Compare(original.Markdown, replay.Markdown)
+	original.Slides = diff.Summary.Removed + diff.Summary.Modified + diff.Summary.Unchanged
+	replay.Slides = diff.Summary.Added + diff.Summary.Modified + diff.Summary.Unchanged
+	return &ReplayComparison{
+		Original:      *original,
+		Replay:        *replay,
+		PromptChanged: original.Prompt != "" && replay.Prompt != "" && original.Prompt != replay.Prompt,
+		Diff:          diff,
+	}, nil
+}
+
+// replayArtifact returns what a finished job produced
+func (s *Service) replayArtifact(ctx context.Context, job *FirestoreJob) (*ReplayArtifact, error) {
+	if !JobStatus(job.Status).Terminal() {
+		return nil, ErrJobInProgress
+	}
+	artifact := &ReplayArtifact{JobID: job.ID, Status: JobStatus(job.Status), Warnings: job.Warnings}
+
+	// The generated deck is the first revision, later ones are refinements
+	revision, err := s.in(job.ID).jobs.GetRevision(ctx, job.ID, 1)
+	switch {
+	case err == nil:
+		artifact.Markdown = revision.Markdown
+	case !errors.Is(err, ErrNotFound):
+		return nil, fmt.Errorf("error retrieving revision: %v", err)
+	}
+
+	capture, err := s.GetCapture(ctx, job.ID)
+	if errors.Is(err, ErrCaptureNotFound) {
+		return artifact, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, exchange := range capture.Exchanges {
+		if exchange.Stage == "slides" && artifact.Prompt == "" {
+			artifact.Prompt = exchange.Prompt
+		}
+		artifact.InputTokens += exchange.InputTokens
+		artifact.OutputTokens += exchange.OutputTokens
+	}
+	return artifact, nil
+}
@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// ResultFormat selects a document of a result
+type ResultFormat string
+
+const (
+	// ResultPDF is the PDF of a result
+	ResultPDF ResultFormat = "pdf"
+	// ResultHTML is the HTML presentation of a result
+	ResultHTML ResultFormat = "html"
+	// ResultViewer is the HTML for the sandboxed viewer, with its images
+	// bundled and its external requests stripped. Results rendered without
+	// one fall back to the HTML, which the viewer's CSP keeps from loading
+	// anything external.
+	ResultViewer ResultFormat = "viewer"
+	// ResultThumbnail is a PNG of the first slide
+	ResultThumbnail ResultFormat = "thumbnail"
+	// ResultFlashcards is a CSV of the flashcards extracted from the sources,
+	// with the file headers Anki reads on import
+	ResultFlashcards ResultFormat = "flashcards"
+	// ResultOnePager is a one-page PDF of the executive summary of the sources
+	ResultOnePager ResultFormat = "one-pager"
+	// ResultOnePagerMarkdown is the markdown of the executive summary
+	ResultOnePagerMarkdown ResultFormat = "one-pager-md"
+	// ResultHandout is a PDF of the student handout of an instructor deck,
+	// with blanks to fill in and review questions
+	ResultHandout ResultFormat = "handout"
+	// ResultHandoutMarkdown is the markdown of the student handout
+	ResultHandoutMarkdown ResultFormat = "handout-md"
+	// ResultAlignment is a JSON of the times in the source transcripts each
+	// slide covers, for syncing the slides with the recordings
+	ResultAlignment ResultFormat = "alignment"
+	// ResultChunkSummaries is a JSON of the summaries the deck was written
+	// from in place of the sections of long documents that didn't fit, for
+	// tuning the focus topics
+	ResultChunkSummaries ResultFormat = "chunk-summaries"
+	// ResultGrounding is a JSON of the bullet points of the deck checked
+	// against the sources, with the ones they don't support flagged
+	ResultGrounding ResultFormat = "grounding"
+)
+
+// ResultFile is a document of a result opened for serving. It seeks so that
+// byte ranges can be served, and must be closed.
+type ResultFile struct {
+	io.ReadSeekCloser
+	ContentType string
+	ModTime     time.Time
+	ETag        string // Quoted entity tag, changes whenever the document does
+}
+
+// OpenResultFile opens a document of a result. Documents stored in Cloud
+// Storage are streamed from it, documents stored inline are served from
+// memory.
+func (s *Service) OpenResultFile(ctx context.Context, result *FirestoreResult, format ResultFormat) (*ResultFile, error) {
+	path, data, contentType := result.HTMLPath, result.HTMLData, "text/html"
+	switch {
+	case format == ResultPDF:
+		path, data, contentType = result.PDFPath, result.PDFData, "application/pdf"
+	case format == ResultViewer && result.ViewerPath != "":
+		path = result.ViewerPath
+	case format == ResultThumbnail:
+		if result.ThumbnailPath == "" {
+			return nil, fmt.Errorf("no thumbnail for this result")
+		}
+		path, contentType = result.ThumbnailPath, "image/png"
+	case format == ResultFlashcards:
+		if result.FlashcardsPath == "" {
+			return nil, fmt.Errorf("no flashcards for this result, enable flashcards in the settings to extract them")
+		}
+		path, contentType = result.FlashcardsPath, "text/csv; charset=utf-8"
+	case format == ResultOnePager || format == ResultOnePagerMarkdown:
+		path, contentType = result.OnePagerPath, "application/pdf"
+		if format == ResultOnePagerMarkdown {
+			path, contentType = result.OnePagerMarkdownPath, "text/markdown; charset=utf-8"
+		}
+		if path == "" {
+			return nil, fmt.Errorf("no executive summary for this result, enable the one-pager in the settings to write one")
+		}
+	case format == ResultHandout || format == ResultHandoutMarkdown:
+		path, contentType = result.HandoutPath, "application/pdf"
+		if format == ResultHandoutMarkdown {
+			path, contentType = result.HandoutMarkdownPath, "text/markdown; charset=utf-8"
+		}
+		if path == "" {
+			return nil, fmt.Errorf("no student handout for this result, enable instructorMode in the settings to write one")
+		}
+	case format == ResultAlignment:
+		if result.AlignmentPath == "" {
+			return nil, fmt.Errorf("no transcript alignment for this result, only decks made from transcripts have one")
+		}
+		path, contentType = result.AlignmentPath, "application/json"
+	case format == ResultChunkSummaries:
+		if result.ChunkSummariesPath == "" {
+			return nil, fmt.Errorf("no chunk summaries for this result, enable chunkSummaries in the settings to keep them, only documents too long for the token budget are summarized")
+		}
+		path, contentType = result.ChunkSummariesPath, "application/json"
+	case format == ResultGrounding:
+		if result.GroundingPath == "" {
+			return nil, fmt.Errorf("no grounding report for this result, enable factCheck in the settings to check decks made from documents")
+		}
+		path, contentType = result.GroundingPath, "application/json"
+	}
+
+	if path == "" {
+		sum := sha256.Sum256(data)
+		return &ResultFile{
+			ReadSeekCloser: nopSeekCloser{bytes.NewReader(data)},
+			ContentType:    contentType,
+			ModTime:        time.Unix(result.CreatedAt, 0),
+			ETag:           `"` + hex.EncodeToString(sum[:16]) + `"`,
+		}, nil
+	}
+
+	reader, object, err := s.in(result.ID).blobs.Open(ctx, path)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("result file not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening result file: %v", err)
+	}
+	return &ResultFile{
+		ReadSeekCloser: reader,
+		ContentType:    contentType,
+		ModTime:        object.UpdatedAt,
+		ETag:           `"` + object.ETag + `"`,
+	}, nil
+}
+
+// deleteResultFiles deletes the documents of a result stored in Cloud Storage
+func (s *Service) deleteResultFiles(ctx context.Context, result *FirestoreResult) {
+	for _, path := range []string{result.PDFPath, result.HTMLPath, result.ViewerPath, result.ThumbnailPath, result.FlashcardsPath, result.OnePagerPath, result.OnePagerMarkdownPath, result.HandoutPath, result.HandoutMarkdownPath, result.AlignmentPath, result.ChunkSummariesPath, result.GroundingPath} {
+		if path == "" {
+			continue
+		}
+		if err := s.in(result.ID).blobs.Delete(ctx, path); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Failed to delete result file %s: %v", path, err)
+		}
+	}
+}
+
+// nopSeekCloser adds a no-op Close to a reader that seeks
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+// Close does nothing
+func (nopSeekCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package queue
+
+import "errors"
+
+// JobStatus represents the current status of a job
+type JobStatus string
+
+const (
+	StatusQueued     JobStatus = "queued"     // Waiting for the slides service
+	StatusUploading  JobStatus = "uploading"  // Fetching the sources and uploading them to Gemini
+	StatusProcessing JobStatus = "processing" // Writing the slides with Gemini
+	StatusRendering  JobStatus = "rendering"  // Rendering the slides with Marp
+	StatusCompleted  JobStatus = "completed"
+	StatusFailed     JobStatus = "failed"
+)
+
+// ErrIllegalTransition is returned when a job can't move from its current
+// status to another
+var ErrIllegalTransition = errors.New("illegal job status transition")
+
+// CanTransition reports whether the API can move a job from the status to
+// another, besides keeping its status to update the message. The API only
+// queues finished jobs again for refinement and fails the jobs it couldn't
+// enqueue. The stages in between are moved through by the slides service,
+// which holds the table of the transitions.
+func (s JobStatus) CanTransition(to JobStatus) bool {
+	switch {
+	case s == to:
+		return true
+	case to == StatusQueued:
+		return s.Terminal()
+	case to == StatusFailed:
+		return !s.Terminal()
+	}
+	return false
+}
+
+// Terminal reports whether a job in the status is no longer in progress
+func (s JobStatus) Terminal() bool {
+	return s == StatusCompleted || s == StatusFailed
+}
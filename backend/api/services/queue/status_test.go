@@ -0,0 +1,28 @@
+package queue
+
+import "testing"
+
+func TestJobStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to JobStatus
+		allowed  bool
+	}{
+		{StatusCompleted, StatusQueued, true}, // Refinement
+		{StatusFailed, StatusQueued, true},    // Refinement after a failed one
+		{StatusQueued, StatusFailed, true},    // Enqueueing failed
+		{StatusProcessing, StatusFailed, true},
+		{StatusFailed, StatusFailed, true},
+		{StatusQueued, StatusQueued, true},
+		{StatusProcessing, StatusQueued, false}, // Refinement of a job in progress
+		{StatusRendering, StatusQueued, false},
+		{StatusCompleted, StatusFailed, false},
+		{StatusQueued, StatusProcessing, false}, // Only the slides service moves through the stages
+		{StatusRendering, StatusCompleted, false},
+	}
+
+	for _, test := range tests {
+		if allowed := test.from.CanTransition(test.to); allowed != test.allowed {
+			t.Errorf("%s to %s: expected allowed=%t, got %t", test.from, test.to, test.allowed, allowed)
+		}
+	}
+}
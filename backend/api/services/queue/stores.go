@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by stores when a job, result or file doesn't exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrJobDeleted is returned by a JobWatcher when the watched job is deleted
+	ErrJobDeleted = errors.New("job deleted")
+)
+
+// JobStore persists jobs and their results
+type JobStore interface {
+	// CreateJob stores a new job
+	CreateJob(ctx context.Context, job FirestoreJob) error
+	// GetJob returns a job, or ErrNotFound
+	GetJob(ctx context.Context, id string) (*FirestoreJob, error)
+	// UpdateJob sets the given fields on a job
+	UpdateJob(ctx context.Context, id string, fields map[string]interface{}) error
+	// TransitionJob atomically moves a job to a status along with the given
+	// fields, or returns ErrIllegalTransition if the job can't move to it from
+	// its current status, or ErrNotFound
+	TransitionJob(ctx context.Context, id string, status JobStatus, fields map[string]interface{}) error
+	// DeleteJob deletes a job
+	DeleteJob(ctx context.Context, id string) error
+	// ListJobs returns the jobs matching a query
+	ListJobs(ctx context.Context, query JobQuery) ([]FirestoreJob, error)
+	// WatchJob returns a watcher that yields the job every time it changes
+	WatchJob(ctx context.Context, id string) JobWatcher
+
+	// ClaimIdempotencyKey atomically assigns an idempotency key to a job when it
+	// is unclaimed, expired or held by a deleted job, and returns the ID of the
+	// job holding the key
+	ClaimIdempotencyKey(ctx context.Context, key, jobID string, expiresAt int64) (string, error)
+	// ReleaseIdempotencyKey frees an idempotency key
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+
+	// GetResult returns the result of a job, or ErrNotFound
+	GetResult(ctx context.Context, id string) (*FirestoreResult, error)
+	// UpdateResult sets the given fields on a result
+	UpdateResult(ctx context.Context, id string, fields map[string]interface{}) error
+	// RecordResultAccess atomically counts a download of a result at
+	// accessedAt, and pushes back its expiry to expiresAt unless it is 0
+	RecordResultAccess(ctx context.Context, id string, accessedAt, expiresAt int64) error
+	// DeleteResult deletes the result of a job
+	DeleteResult(ctx context.Context, id string) error
+	// TakeResult atomically returns and deletes the result of a job, or ErrNotFound
+	TakeResult(ctx context.Context, id string) (*FirestoreResult, error)
+
+	// GetDeck returns the deck generated by a job, or ErrNotFound
+	GetDeck(ctx context.Context, id string) (*FirestoreDeck, error)
+	// GetRevision returns a revision of a deck, or ErrNotFound
+	GetRevision(ctx context.Context, id string, number int) (*FirestoreRevision, error)
+
+	// GetCapture returns the capture of a debugged job, or ErrNotFound
+	GetCapture(ctx context.Context, id string) (*FirestoreCapture, error)
+}
+
+// JobQuery selects the jobs of an owner or a workspace that carry all of the given labels
+type JobQuery struct {
+	Owner       string
+	WorkspaceID string
+	Labels      map[string]string
+}
+
+// JobWatcher yields successive states of a watched job
+type JobWatcher interface {
+	// Next blocks until the job changes and returns its new state, or
+	// ErrJobDeleted if the job no longer exists
+	Next() (*FirestoreJob, error)
+	// Stop releases the resources held by the watcher
+	Stop()
+}
+
+// BlobStore stores the uploaded source files
+type BlobStore interface {
+	// Upload writes a file to the given path
+	Upload(ctx context.Context, path, contentType string, data []byte) error
+	// UploadedAt returns when the file at the given path was last written, or
+	// ErrNotFound if there is no file at the path
+	UploadedAt(ctx context.Context, path string) (time.Time, error)
+	// List returns the files whose path starts with the given prefix
+	List(ctx context.Context, prefix string) ([]BlobObject, error)
+	// Delete removes a file, or returns ErrNotFound
+	Delete(ctx context.Context, path string) error
+	// Open returns a reader for a file that reads only the parts it seeks to,
+	// or ErrNotFound
+	Open(ctx context.Context, path string) (io.ReadSeekCloser, *BlobObject, error)
+}
+
+// BlobObject describes a stored file
+type BlobObject struct {
+	Path      string
+	Size      int64
+	UpdatedAt time.Time
+	ETag      string // Changes whenever the file is written
+}
+
+// TaskDispatcher hands jobs to the slides service for processing
+type TaskDispatcher interface {
+	// Dispatch schedules a job for processing
+	Dispatch(ctx context.Context, payload TaskPayload) error
+	// DispatchRefinement schedules the refinement of a deck
+	DispatchRefinement(ctx context.Context, payload RefinePayload) error
+	// DispatchIndexing schedules the indexing of a library document
+	DispatchIndexing(ctx context.Context, payload IndexPayload) error
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// CloudTasksDispatcher is a TaskDispatcher that creates Cloud Tasks targeting the slides service
+type CloudTasksDispatcher struct {
+	client     *cloudtasks.Client
+	projectID  string
+	region     string
+	queueID    string
+	serviceURL string
+	secret     string // Optional, signs the tasks in addition to the OIDC token
+}
+
+// NewCloudTasksDispatcher creates a new Cloud Tasks dispatcher, which signs the
+// tasks with the secret when one is given
+func NewCloudTasksDispatcher(client *cloudtasks.Client, projectID, region, queueID, serviceURL, secret string) *CloudTasksDispatcher {
+	return &CloudTasksDispatcher{
+		client:     client,
+		projectID:  projectID,
+		region:     region,
+		queueID:    queueID,
+		serviceURL: serviceURL,
+		secret:     secret,
+	}
+}
+
+// Dispatch creates a Cloud Task to process a job
+func (d *CloudTasksDispatcher) Dispatch(ctx context.Context, payload TaskPayload) error {
+	return d.createTask(ctx, "/tasks/process-slides", payload)
+}
+
+// DispatchRefinement creates a Cloud Task to refine a deck
+func (d *CloudTasksDispatcher) DispatchRefinement(ctx context.Context, payload RefinePayload) error {
+	return d.createTask(ctx, "/tasks/refine-slides", payload)
+}
+
+// DispatchIndexing creates a Cloud Task to index a library document
+func (d *CloudTasksDispatcher) DispatchIndexing(ctx context.Context, payload IndexPayload) error {
+	return d.createTask(ctx, "/tasks/index-document", payload)
+}
+
+// createTask creates a Cloud Task posting a payload to a path of the slides service
+func (d *CloudTasksDispatcher) createTask(ctx context.Context, path string, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task payload: %v", err)
+	}
+
+	// Define the Cloud Tasks queue path
+	queuePath := fmt.Sprintf("projects/%s/locations/%s/queues/%s", d.projectID, d.region, d.queueID)
+
+	// Define the target endpoint
+	taskURL := d.serviceURL + path
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	if d.secret != "" {
+		for name, value := range SignTask(d.secret, payloadBytes, time.Now()) {
+			headers[name] = value
+		}
+	}
+
+	// Create the Cloud Task with OIDC token
+	task := &taskspb.CreateTaskRequest{
+		Parent: queuePath,
+		Task: &taskspb.Task{
+			// Name is assigned by the server
+			MessageType: &taskspb.Task_HttpRequest{
+				HttpRequest: &taskspb.HttpRequest{
+					HttpMethod: taskspb.HttpMethod_POST,
+					Url:        taskURL,
+					Headers:    headers,
+					Body:       payloadBytes,
+					AuthorizationHeader: &taskspb.HttpRequest_OidcToken{
+						OidcToken: &taskspb.OidcToken{
+							ServiceAccountEmail: fmt.Sprintf("%s@%s.iam.gserviceaccount.com", "slides-service-invoker", d.projectID),
+							Audience:            taskURL,
+						},
+					},
+				},
+			},
+			ScheduleTime: timestamppb.New(time.Now()),
+		},
+	}
+
+	// Create the task
+	if _, err := d.client.CreateTask(ctx, task); err != nil {
+		return fmt.Errorf("failed to create task: %v", err)
+	}
+
+	return nil
+}
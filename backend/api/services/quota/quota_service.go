@@ -0,0 +1,169 @@
+package quota
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExceededError is returned when a client has used up its daily quota
+type ExceededError struct {
+	Limit    int
+	ResetsAt time.Time
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("daily quota of %d jobs exceeded, resets at %s", e.Limit, e.ResetsAt.Format(time.RFC3339))
+}
+
+// FirestoreUsage is the Firestore representation of a client's usage on one day.
+// Documents are keyed by the client fingerprint and the day so no IP address is stored.
+type FirestoreUsage struct {
+	Day       string `firestore:"day"`
+	Count     int    `firestore:"count"`
+	ExpiresAt int64  `firestore:"expiresAt"`
+}
+
+// Usage is the quota usage of a client after a job was counted
+type Usage struct {
+	Limit     int
+	Remaining int
+	ResetsAt  time.Time
+}
+
+// Service enforces the daily job quota of anonymous clients, and the daily
+// limit of the notification emails sent to one address
+type Service struct {
+	client         *firestore.Client
+	dailyLimit     int
+	recipientLimit int
+	fingerprints   *Fingerprinter
+}
+
+// NewService creates a new quota service. A daily limit of 0 disables the quota,
+// a recipient limit of 0 the limit of emails.
+func NewService(client *firestore.Client, dailyLimit, recipientLimit int, fingerprints *Fingerprinter) *Service {
+	return &Service{
+		client:         client,
+		dailyLimit:     dailyLimit,
+		recipientLimit: recipientLimit,
+		fingerprints:   fingerprints,
+	}
+}
+
+// Collection returns the Firestore collection reference for anonymous usage
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("anonymousUsage")
+}
+
+// Consume counts a job against the daily quota of the client at an IP address.
+// It returns an *ExceededError when the quota is used up.
+func (s *Service) Consume(ctx context.Context, ip string) (*Usage, error) {
+	if s.dailyLimit <= 0 {
+		return nil, nil
+	}
+	return s.consume(ctx, s.Collection(), s.fingerprints.Fingerprint(ip), s.dailyLimit)
+}
+
+// ConsumeRecipient counts a notification email against the daily limit of the
+// address it is sent to, so the API can't be used to flood an inbox. It
+// returns an *ExceededError when the limit is reached.
+func (s *Service) ConsumeRecipient(ctx context.Context, address string) (*Usage, error) {
+	if s.recipientLimit <= 0 {
+		return nil, nil
+	}
+	key := s.fingerprints.Fingerprint("mailto:" + strings.ToLower(address))
+	return s.consume(ctx, s.client.Collection("recipientUsage"), key, s.recipientLimit)
+}
+
+// consume counts one use against the daily limit of a key in a collection
+func (s *Service) consume(ctx context.Context, collection *firestore.CollectionRef, key string, limit int) (*Usage, error) {
+	now := time.Now().UTC()
+	day := now.Format("2006-01-02")
+	resetsAt := nextReset(now)
+	ref := collection.Doc(key + "-" + day)
+
+	var count int
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		count = 0
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var usage FirestoreUsage
+			if err := doc.DataTo(&usage); err != nil {
+				return err
+			}
+			count = usage.Count
+		}
+		if count >= limit {
+			return &ExceededError{Limit: limit, ResetsAt: resetsAt}
+		}
+		count++
+		return tx.Set(ref, FirestoreUsage{
+			Day:       day,
+			Count:     count,
+			ExpiresAt: resetsAt.Unix(),
+		})
+	})
+	if err != nil {
+		var exceeded *ExceededError
+		if errors.As(err, &exceeded) {
+			return nil, exceeded
+		}
+		return nil, fmt.Errorf("failed to update quota usage: %v", err)
+	}
+
+	return &Usage{
+		Limit:     limit,
+		Remaining: limit - count,
+		ResetsAt:  resetsAt,
+	}, nil
+}
+
+// Fingerprinter identifies clients by their IP address without storing it.
+// Fingerprints are keyed with a secret, so they can't be reversed by hashing
+// every IPv4 address.
+type Fingerprinter struct {
+	key []byte
+}
+
+// NewFingerprinter creates a new fingerprinter keyed with a secret every
+// instance of the API shares
+func NewFingerprinter(secret string) *Fingerprinter {
+	return &Fingerprinter{key: []byte(secret)}
+}
+
+// Fingerprint identifies the client at an IP address. IPv6 clients are grouped
+// by their /64 prefix, which usually belongs to a single subscriber who could
+// otherwise rotate addresses to get a fresh quota.
+func (f *Fingerprinter) Fingerprint(ip string) string {
+	key := ip
+	if parsed := net.ParseIP(ip); parsed != nil {
+		if parsed.To4() != nil {
+			key = parsed.To4().String()
+		} else {
+			key = parsed.Mask(net.CIDRMask(64, 128)).String() + "/64"
+		}
+	}
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nextReset returns the next UTC midnight, when daily quotas reset
+func nextReset(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,42 @@
+package quota
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestFingerprintGroupsIPv6Prefixes(t *testing.T) {
+	f := NewFingerprinter("test-secret")
+	if f.Fingerprint("2001:db8:1:2::1") != f.Fingerprint("2001:db8:1:2:ffff::9") {
+		t.Fatal("expected addresses in the same /64 to share a fingerprint")
+	}
+	if f.Fingerprint("2001:db8:1:2::1") == f.Fingerprint("2001:db8:1:3::1") {
+		t.Fatal("expected addresses in different /64 prefixes to differ")
+	}
+	if f.Fingerprint("203.0.113.7") == f.Fingerprint("203.0.113.8") {
+		t.Fatal("expected IPv4 addresses to be fingerprinted individually")
+	}
+	if f.Fingerprint("::ffff:203.0.113.7") != f.Fingerprint("203.0.113.7") {
+		t.Fatal("expected IPv4-mapped addresses to match their IPv4 address")
+	}
+}
+
+func TestFingerprintIsKeyed(t *testing.T) {
+	sum := sha256.Sum256([]byte("203.0.113.7"))
+	fingerprint := NewFingerprinter("test-secret").Fingerprint("203.0.113.7")
+	if fingerprint == hex.EncodeToString(sum[:]) {
+		t.Fatal("expected the fingerprint not to be a plain hash of the address")
+	}
+	if fingerprint == NewFingerprinter("other-secret").Fingerprint("203.0.113.7") {
+		t.Fatal("expected fingerprints to depend on the secret")
+	}
+}
+
+func TestNextResetIsNextUTCMidnight(t *testing.T) {
+	now := time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC)
+	if reset := nextReset(now); !reset.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected reset time %s", reset)
+	}
+}
@@ -0,0 +1,234 @@
+package revisions
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Kinds of slide changes
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+var (
+	// frontmatterPattern matches the Marp frontmatter at the start of a deck
+	frontmatterPattern = regexp.MustCompile(`(?s)^\s*---\r?\n(.*?)\r?\n---`)
+
+	// headingPattern matches a heading line
+	headingPattern = regexp.MustCompile(`^#{1,6}\s+`)
+
+	// bulletPattern matches a list item such as "- point" or "2. point"
+	bulletPattern = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+`)
+)
+
+// Diff is the per-slide difference between two revisions of a deck
+type Diff struct {
+	Summary Summary       `json:"summary"`
+	Slides  []SlideChange `json:"slides"` // Changed slides, in deck order
+}
+
+// Summary counts the slides by kind of change
+type Summary struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Modified  int `json:"modified"`
+	Unchanged int `json:"unchanged"`
+}
+
+// SlideChange is a slide added, removed or modified by a revision
+type SlideChange struct {
+	Change         string   `json:"change"`                  // Values: added, removed, modified
+	Slide          int      `json:"slide,omitempty"`         // Position in the revision, absent for removed slides
+	PreviousSlide  int      `json:"previousSlide,omitempty"` // Position in the earlier revision, absent for added slides
+	Title          string   `json:"title"`
+	PreviousTitle  string   `json:"previousTitle,omitempty"` // Title in the earlier revision when it changed
+	AddedBullets   []string `json:"addedBullets,omitempty"`
+	RemovedBullets []string `json:"removedBullets,omitempty"`
+}
+
+// slide is a slide of a deck
+type slide struct {
+	position int
+	text     string
+	title    string
+	bullets  []string
+}
+
+// Compare returns the slides added, removed and modified from the previous
+// markdown of a deck to the current one. Unchanged slides are matched first,
+// then the slides between them by title, then by position.
+func Compare(previous, current string) *Diff {
+	before, after := splitSlides(previous), splitSlides(current)
+	diff := &Diff{Slides: []SlideChange{}}
+
+	// Slides between two unchanged slides are compared with each other
+	i, j := 0, 0
+	for _, match := range unchangedSlides(before, after) {
+		diff.compareGap(before[i:match[0]], after[j:match[1]])
+		diff.Summary.Unchanged++
+		i, j = match[0]+1, match[1]+1
+	}
+	diff.compareGap(before[i:], after[j:])
+	return diff
+}
+
+// compareGap adds the changes between the slides of the earlier and the
+// current revision that lie between the same unchanged slides
+func (d *Diff) compareGap(before, after []slide) {
+	if len(before) == 0 && len(after) == 0 {
+		return
+	}
+
+	// Pair slides with the same title, then the rest in order when as many
+	// slides were removed as added, since a rewritten slide may get a new title
+	paired := make(map[int]int)
+	used := make(map[int]bool)
+	for a, current := range after {
+		for b, previous := range before {
+			if !used[b] && current.title != "" && previous.title == current.title {
+				paired[a], used[b] = b, true
+				break
+			}
+		}
+	}
+	var unpairedBefore, unpairedAfter []int
+	for b := range before {
+		if !used[b] {
+			unpairedBefore = append(unpairedBefore, b)
+		}
+	}
+	for a := range after {
+		if _, ok := paired[a]; !ok {
+			unpairedAfter = append(unpairedAfter, a)
+		}
+	}
+	if len(unpairedBefore) == len(unpairedAfter) {
+		for k, a := range unpairedAfter {
+			paired[a], used[unpairedBefore[k]] = unpairedBefore[k], true
+		}
+	}
+
+	for b, previous := range before {
+		if !used[b] {
+			d.Slides = append(d.Slides, SlideChange{Change: ChangeRemoved, PreviousSlide: previous.position, Title: previous.title})
+			d.Summary.Removed++
+		}
+	}
+	for a, current := range after {
+		b, ok := paired[a]
+		if !ok {
+			d.Slides = append(d.Slides, SlideChange{Change: ChangeAdded, Slide: current.position, Title: current.title, AddedBullets: current.bullets})
+			d.Summary.Added++
+			continue
+		}
+		previous := before[b]
+		change := SlideChange{
+			Change:         ChangeModified,
+			Slide:          current.position,
+			PreviousSlide:  previous.position,
+			Title:          current.title,
+			AddedBullets:   subtract(current.bullets, previous.bullets),
+			RemovedBullets: subtract(previous.bullets, current.bullets),
+		}
+		if previous.title != current.title {
+			change.PreviousTitle = previous.title
+		}
+		d.Slides = append(d.Slides, change)
+		d.Summary.Modified++
+	}
+}
+
+// unchangedSlides returns the longest sequence of slides left unchanged, as
+// pairs of indexes into the earlier and the current slides
+func unchangedSlides(before, after []slide) [][2]int {
+	// lengths[b][a] is the longest common sequence of before[b:] and after[a:]
+	lengths := make([][]int, len(before)+1)
+	for b := range lengths {
+		lengths[b] = make([]int, len(after)+1)
+	}
+	for b := len(before) - 1; b >= 0; b-- {
+		for a := len(after) - 1; a >= 0; a-- {
+			if before[b].text == after[a].text {
+				lengths[b][a] = lengths[b+1][a+1] + 1
+			} else {
+				lengths[b][a] = max(lengths[b+1][a], lengths[b][a+1])
+			}
+		}
+	}
+
+	var matches [][2]int
+	for b, a := 0, 0; b < len(before) && a < len(after); {
+		switch {
+		case before[b].text == after[a].text:
+			matches = append(matches, [2]int{b, a})
+			b, a = b+1, a+1
+		case lengths[b+1][a] >= lengths[b][a+1]:
+			b++
+		default:
+			a++
+		}
+	}
+	return matches
+}
+
+// splitSlides splits the markdown of a deck into its slides, ignoring the
+// frontmatter and separators inside code blocks
+func splitSlides(markdown string) []slide {
+	if loc := frontmatterPattern.FindStringIndex(markdown); loc != nil {
+		markdown = markdown[loc[1]:]
+	}
+
+	var texts []string
+	current := []string{}
+	inCode := false
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		if !inCode && strings.TrimSpace(line) == "---" {
+			texts = append(texts, strings.Join(current, "\n"))
+			current = []string{}
+			continue
+		}
+		current = append(current, line)
+	}
+	texts = append(texts, strings.Join(current, "\n"))
+
+	slides := make([]slide, 0, len(texts))
+	for _, text := range texts {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		s := slide{position: len(slides) + 1, text: text}
+		for _, line := range strings.Split(text, "\n") {
+			switch {
+			case s.title == "" && headingPattern.MatchString(line):
+				s.title = strings.TrimSpace(headingPattern.ReplaceAllString(line, ""))
+			case bulletPattern.MatchString(line):
+				s.bullets = append(s.bullets, strings.TrimSpace(bulletPattern.ReplaceAllString(line, "")))
+			}
+		}
+		slides = append(slides, s)
+	}
+	return slides
+}
+
+// subtract returns the items of a that are not in b, counting repeated items
+func subtract(a, b []string) []string {
+	remaining := make(map[string]int)
+	for _, item := range b {
+		remaining[item]++
+	}
+	var result []string
+	for _, item := range a {
+		if remaining[item] > 0 {
+			remaining[item]--
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package revisions
+
+import (
+	"reflect"
+	"testing"
+)
+
+const previousDeck = `---
+marp: true
+theme: beam
+---
+
+# Quarterly review
+
+---
+
+## Revenue
+
+- Up 12%
+- Europe grew fastest
+
+---
+
+## Hiring
+
+- 4 engineers
+- 1 designer
+
+---
+
+## Risks
+
+- Supply chain`
+
+func TestCompareFindsSlideChanges(t *testing.T) {
+	current := `---
+marp: true
+theme: beam
+---
+
+# Quarterly review
+
+---
+
+## Revenue
+
+- Up 12%
+- Asia grew fastest
+
+---
+
+## Pricing
+
+- New team plan
+
+---
+
+## Hiring
+
+- 4 engineers
+- 1 designer`
+
+	diff := Compare(previousDeck, current)
+	want := []SlideChange{
+		{Change: ChangeModified, Slide: 2, PreviousSlide: 2, Title: "Revenue", AddedBullets: []string{"Asia grew fastest"}, RemovedBullets: []string{"Europe grew fastest"}},
+		{Change: ChangeAdded, Slide: 3, Title: "Pricing", AddedBullets: []string{"New team plan"}},
+		{Change: ChangeRemoved, PreviousSlide: 4, Title: "Risks"},
+	}
+	if !reflect.DeepEqual(diff.Slides, want) {
+		t.Fatalf("unexpected changes:\n got %+v\nwant %+v", diff.Slides, want)
+	}
+	if diff.Summary != (Summary{Added: 1, Removed: 1, Modified: 1, Unchanged: 2}) {
+		t.Fatalf("unexpected summary: %+v", diff.Summary)
+	}
+}
+
+func TestComparePairsRetitledSlide(t *testing.T) {
+	current := "# Quarterly review\n\n---\n\n## Revenue\n\n- Up 12%\n- Europe grew fastest\n\n---\n\n## Team\n\n- 4 engineers\n\n---\n\n## Risks\n\n- Supply chain"
+
+	diff := Compare(previousDeck, current)
+	want := []SlideChange{
+		{Change: ChangeModified, Slide: 3, PreviousSlide: 3, Title: "Team", PreviousTitle: "Hiring", RemovedBullets: []string{"1 designer"}},
+	}
+	if !reflect.DeepEqual(diff.Slides, want) {
+		t.Fatalf("unexpected changes:\n got %+v\nwant %+v", diff.Slides, want)
+	}
+}
+
+func TestCompareIdenticalDecks(t *testing.T) {
+	diff := Compare(previousDeck, previousDeck)
+	if len(diff.Slides) != 0 || diff.Summary.Unchanged != 4 {
+		t.Fatalf("expected no changes, got %+v", diff)
+	}
+}
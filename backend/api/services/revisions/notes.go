@@ -0,0 +1,42 @@
+package revisions
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// commentPattern matches an HTML comment, which Marp shows as speaker
+	// notes unless it sets directives
+	commentPattern = regexp.MustCompile(`(?s)<!--(.*?)-->`)
+
+	// directivePattern matches a comment setting a Marp directive, such as
+	// <!-- _class: lead --> or <!-- paginate: true -->
+	directivePattern = regexp.MustCompile(`^\s*_?(?:theme|style|headingDivider|lang|title|description|author|image|keywords|url|marp|size|math|paginate|header|footer|class|backgroundColor|backgroundImage|backgroundPosition|backgroundRepeat|backgroundSize|color|transition)\s*:`)
+)
+
+// SlideNotes are the speaker notes of a slide
+type SlideNotes struct {
+	Slide int // Position in the deck
+	Title string
+	Notes string
+}
+
+// SpeakerNotes returns the speaker notes of the slides of a deck, leaving out
+// slides without notes
+func SpeakerNotes(markdown string) []SlideNotes {
+	var notes []SlideNotes
+	for _, s := range splitSlides(markdown) {
+		var parts []string
+		for _, match := range commentPattern.FindAllStringSubmatch(s.text, -1) {
+			text := strings.TrimSpace(match[1])
+			if text != "" && !directivePattern.MatchString(text) {
+				parts = append(parts, text)
+			}
+		}
+		if len(parts) > 0 {
+			notes = append(notes, SlideNotes{Slide: s.position, Title: s.title, Notes: strings.Join(parts, "\n\n")})
+		}
+	}
+	return notes
+}
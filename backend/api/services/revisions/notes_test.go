@@ -0,0 +1,42 @@
+package revisions
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpeakerNotes(t *testing.T) {
+	markdown := `---
+marp: true
+---
+
+<!-- _class: title -->
+
+# Quarterly review
+
+<!-- Welcome everyone -->
+
+---
+
+## Revenue
+
+<!-- _backgroundColor: white -->
+<!--
+Mention the Europe numbers.
+-->
+<!-- Pause for questions -->
+
+- Up 12%
+
+---
+
+Closing slide`
+
+	expected := []SlideNotes{
+		{Slide: 1, Title: "Quarterly review", Notes: "Welcome everyone"},
+		{Slide: 2, Title: "Revenue", Notes: "Mention the Europe numbers.\n\nPause for questions"},
+	}
+	if notes := SpeakerNotes(markdown); !reflect.DeepEqual(notes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, notes)
+	}
+}
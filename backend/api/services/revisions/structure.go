@@ -0,0 +1,162 @@
+package revisions
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// minTopicOverlap is the share of the words of two slide titles they have to
+// have in common to be the same topic, so "Revenue growth" and "Growth of
+// revenue" match
+const minTopicOverlap = 0.5
+
+var (
+	// imagePattern matches a markdown image, including Marp backgrounds
+	imagePattern = regexp.MustCompile(`!\[[^\]]*\]\(`)
+
+	// tableSeparatorPattern matches the line under the header of a table
+	tableSeparatorPattern = regexp.MustCompile(`^\s*\|?\s*:?-{3,}:?\s*(?:\|\s*:?-{3,}:?\s*)*\|?\s*$`)
+
+	// topicStopWords are left out of slide titles when matching topics
+	topicStopWords = map[string]bool{
+		"and": true, "the": true, "for": true, "with": true, "from": true, "into": true,
+		"its": true, "our": true, "your": true, "how": true, "what": true, "why": true,
+	}
+)
+
+// DeckComparison is the structure of two decks side by side, such as decks of
+// the same document generated with different settings, and the topics one
+// covers and the other omits
+type DeckComparison struct {
+	Deck        Structure `json:"deck"`
+	Other       Structure `json:"other"`
+	Shared      []string  `json:"sharedTopics"` // Titles in the deck of the topics both decks cover
+	OnlyInDeck  []string  `json:"onlyInDeck"`   // Topics the other deck omits
+	OnlyInOther []string  `json:"onlyInOther"`  // Topics the deck omits
+}
+
+// Structure counts what a deck is made of
+type Structure struct {
+	Slides          int      `json:"slides"`
+	Bullets         int      `json:"bullets"`
+	Words           int      `json:"words"` // Outside speaker notes
+	Images          int      `json:"images"`
+	Tables          int      `json:"tables"`
+	CodeBlocks      int      `json:"codeBlocks"`
+	SlidesWithNotes int      `json:"slidesWithNotes"`
+	Topics          []string `json:"topics"` // Slide titles in deck order, without repeats
+}
+
+// topic is a slide title and the words it is matched on
+type topic struct {
+	title string
+	words map[string]bool
+}
+
+// CompareDecks compares the structure of the markdown of two decks and
+// matches their topics by slide title
+func CompareDecks(deck, other string) *DeckComparison {
+	deckStructure, deckTopics := structureOf(deck)
+	otherStructure, otherTopics := structureOf(other)
+	comparison := &DeckComparison{
+		Deck:        deckStructure,
+		Other:       otherStructure,
+		Shared:      []string{},
+		OnlyInDeck:  []string{},
+		OnlyInOther: []string{},
+	}
+	for _, t := range deckTopics {
+		if coveredBy(t, otherTopics) {
+			comparison.Shared = append(comparison.Shared, t.title)
+		} else {
+			comparison.OnlyInDeck = append(comparison.OnlyInDeck, t.title)
+		}
+	}
+	for _, t := range otherTopics {
+		if !coveredBy(t, deckTopics) {
+			comparison.OnlyInOther = append(comparison.OnlyInOther, t.title)
+		}
+	}
+	return comparison
+}
+
+// structureOf counts the slides, bullets and media of a deck and returns its
+// topics
+func structureOf(markdown string) (Structure, []topic) {
+	structure := Structure{Topics: []string{}}
+	var topics []topic
+	seen := make(map[string]bool)
+	for _, s := range splitSlides(markdown) {
+		structure.Slides++
+		structure.Bullets += len(s.bullets)
+
+		hasNotes := false
+		text := commentPattern.ReplaceAllStringFunc(s.text, func(comment string) string {
+			if body := strings.TrimSpace(commentPattern.FindStringSubmatch(comment)[1]); body != "" && !directivePattern.MatchString(body) {
+				hasNotes = true
+			}
+			return ""
+		})
+		if hasNotes {
+			structure.SlidesWithNotes++
+		}
+		structure.Words += len(strings.Fields(text))
+		structure.Images += len(imagePattern.FindAllString(text, -1))
+		for _, line := range strings.Split(text, "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "```") {
+				structure.CodeBlocks++
+			}
+			if tableSeparatorPattern.MatchString(line) && strings.Contains(line, "|") {
+				structure.Tables++
+			}
+		}
+
+		key := strings.ToLower(s.title)
+		if s.title == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		structure.Topics = append(structure.Topics, s.title)
+		topics = append(topics, topic{title: s.title, words: titleWords(s.title)})
+	}
+	// Each code block has an opening and a closing fence
+	structure.CodeBlocks /= 2
+	return structure, topics
+}
+
+// titleWords returns the words of a slide title that topics are matched on
+func titleWords(title string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) > 2 && !topicStopWords[word] {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// coveredBy reports whether a topic is one of the topics of a deck
+func coveredBy(t topic, topics []topic) bool {
+	for _, other := range topics {
+		if strings.EqualFold(t.title, other.title) {
+			return true
+		}
+		if len(t.words) == 0 || len(other.words) == 0 {
+			continue
+		}
+		common := 0
+		for word := range t.words {
+			if other.words[word] {
+				common++
+			}
+		}
+		union := len(t.words) + len(other.words) - common
+		if float64(common)/float64(union) >= minTopicOverlap {
+			return true
+		}
+	}
+	return false
+}
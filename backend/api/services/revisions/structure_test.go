@@ -0,0 +1,68 @@
+package revisions
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareDecks(t *testing.T) {
+	detailed := `---
+marp: true
+theme: beam
+---
+
+# Quarterly review
+
+---
+
+## Revenue growth
+
+- Up 12%
+- Europe grew fastest
+
+<!-- Mention the currency effect -->
+
+---
+
+## Revenue growth
+
+| Region | Growth |
+| ------ | ------ |
+| Europe | 18% |
+
+---
+
+## Hiring plan
+
+![bg right](team.png)
+
+- 4 engineers
+
+---
+
+## Code review
+
+` + "```go\nfunc main() {}\n```"
+
+	comparison := CompareDecks(previousDeck, detailed)
+
+	if comparison.Deck.Slides != 4 || comparison.Other.Slides != 5 {
+		t.Fatalf("unexpected slide counts: %d and %d", comparison.Deck.Slides, comparison.Other.Slides)
+	}
+	other := comparison.Other
+	if other.Bullets != 3 || other.Images != 1 || other.Tables != 1 || other.CodeBlocks != 1 || other.SlidesWithNotes != 1 {
+		t.Fatalf("unexpected structure: %+v", other)
+	}
+	if !reflect.DeepEqual(other.Topics, []string{"Quarterly review", "Revenue growth", "Hiring plan", "Code review"}) {
+		t.Fatalf("expected repeated titles to be one topic, got %q", other.Topics)
+	}
+	if !reflect.DeepEqual(comparison.Shared, []string{"Quarterly review", "Revenue", "Hiring"}) {
+		t.Fatalf("unexpected shared topics: %q", comparison.Shared)
+	}
+	if !reflect.DeepEqual(comparison.OnlyInDeck, []string{"Risks"}) {
+		t.Fatalf("unexpected topics only in the deck: %q", comparison.OnlyInDeck)
+	}
+	if !reflect.DeepEqual(comparison.OnlyInOther, []string{"Code review"}) {
+		t.Fatalf("unexpected topics only in the other deck: %q", comparison.OnlyInOther)
+	}
+}
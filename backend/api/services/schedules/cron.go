@@ -0,0 +1,144 @@
+package schedules
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronFields are the bounds of the five fields of a cron expression
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // Sunday is 0 or 7
+}
+
+// Cron is a parsed five-field cron expression: minute, hour, day of month,
+// month and day of week. Fields accept *, lists, ranges and steps like */15.
+type Cron struct {
+	fields [5]uint64 // Bit n is set when value n matches
+	anyDay bool      // Day of month is *
+	anyDow bool      // Day of week is *
+}
+
+// ParseCron parses a five-field cron expression such as "0 9 * * 1"
+func ParseCron(expr string) (*Cron, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(parts))
+	}
+
+	c := &Cron{anyDay: parts[2] == "*", anyDow: parts[4] == "*"}
+	for i, part := range parts {
+		set, err := parseCronField(part, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", cronFields[i].name, part, err)
+		}
+		c.fields[i] = set
+	}
+	// Fold Sunday written as 7 into 0
+	c.fields[4] = c.fields[4]&0x7f | c.fields[4]>>7
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			start, end, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(start); err != nil {
+				return 0, fmt.Errorf("invalid value %q", start)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(end); err != nil {
+					return 0, fmt.Errorf("invalid value %q", end)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("values must be between %d and %d", min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// AtMostHourly reports whether the expression runs at most once an hour
+func (c *Cron) AtMostHourly() bool {
+	return bits.OnesCount64(c.fields[0]) == 1
+}
+
+// Next returns the first time after t that matches the expression, in the
+// location of t, or the zero time if none matches within four years
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(4, 0, 0)
+	for t.Before(limit) {
+		if !c.matches(3, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matches(1, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matches(0, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matches reports whether a value is set in a field
+func (c *Cron) matches(field, value int) bool {
+	return c.fields[field]&(1<<uint(value)) != 0
+}
+
+// matchesDay applies the cron rule that when both day fields are restricted,
+// a day matching either of them matches
+func (c *Cron) matchesDay(t time.Time) bool {
+	day := c.matches(2, t.Day())
+	dow := c.matches(4, int(t.Weekday()))
+	switch {
+	case c.anyDay && c.anyDow:
+		return true
+	case c.anyDay:
+		return dow
+	case c.anyDow:
+		return day
+	default:
+		return day || dow
+	}
+}
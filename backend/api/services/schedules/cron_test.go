@@ -0,0 +1,58 @@
+package schedules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	start := time.Date(2025, 3, 14, 10, 30, 0, 0, time.UTC) // A Friday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 9 * * 1", time.Date(2025, 3, 17, 9, 0, 0, 0, time.UTC)},
+		{"45 * * * *", time.Date(2025, 3, 14, 10, 45, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)},
+		{"0 8 1 * *", time.Date(2025, 4, 1, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 7", time.Date(2025, 3, 16, 8, 0, 0, 0, time.UTC)},
+		{"0 8 1-5 * 0", time.Date(2025, 3, 16, 8, 0, 0, 0, time.UTC)},
+		{"30 10 29 2 *", time.Date(2028, 2, 29, 10, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		cron, err := ParseCron(test.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) failed: %v", test.expr, err)
+		}
+		if next := cron.Next(start); !next.Equal(test.want) {
+			t.Errorf("Next of %q = %v, want %v", test.expr, next, test.want)
+		}
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("expected ParseCron(%q) to fail", expr)
+		}
+	}
+}
+
+func TestNextRunChecksFrequencyAndTimeZone(t *testing.T) {
+	start := time.Date(2025, 3, 14, 10, 30, 0, 0, time.UTC)
+
+	if _, err := NextRun("*/15 * * * *", "UTC", start); err == nil {
+		t.Error("expected schedules running more than hourly to be rejected")
+	}
+	if _, err := NextRun("0 9 * * *", "Mars/Olympus", start); err == nil {
+		t.Error("expected an unknown time zone to be rejected")
+	}
+
+	next, err := NextRun("0 9 * * *", "America/New_York", start)
+	if err != nil {
+		t.Fatalf("NextRun failed: %v", err)
+	}
+	if want := time.Date(2025, 3, 14, 13, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("expected %v, got %v", want, next.UTC())
+	}
+}
@@ -0,0 +1,354 @@
+package schedules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"github.com/martin226/slideitin/backend/api/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxSchedules is the largest number of schedules an API key or user can have
+const maxSchedules = 25
+
+var (
+	// ErrScheduleNotFound is returned when a schedule doesn't exist or belongs to another owner
+	ErrScheduleNotFound = errors.New("schedule not found")
+
+	// ErrTooManySchedules is returned when creating more schedules than allowed
+	ErrTooManySchedules = fmt.Errorf("at most %d schedules can be created", maxSchedules)
+
+	// errNotDue aborts the claim of a schedule another call already ran
+	errNotDue = errors.New("schedule is not due")
+)
+
+// InvalidError is returned when the timing or source of a schedule is invalid
+type InvalidError struct {
+	Message string
+}
+
+func (e *InvalidError) Error() string {
+	return e.Message
+}
+
+// FirestoreSchedule is the Firestore representation of a schedule
+type FirestoreSchedule struct {
+	Owner       string                `firestore:"owner"`
+	WorkspaceID string                `firestore:"workspaceId,omitempty"`
+	Name        string                `firestore:"name"`
+	Cron        string                `firestore:"cron"`
+	TimeZone    string                `firestore:"timeZone"`
+	Source      models.ScheduleSource `firestore:"source"`
+	Theme       string                `firestore:"theme,omitempty"`
+	PresetID    string                `firestore:"presetId,omitempty"`
+	Settings    models.SlideSettings  `firestore:"settings"`
+	NotifyEmail string                `firestore:"notifyEmail,omitempty"`
+	Labels      map[string]string     `firestore:"labels,omitempty"`
+	Paused      bool                  `firestore:"paused"`
+	NextRunAt   int64                 `firestore:"nextRunAt"` // 0 while paused
+	LastRunAt   int64                 `firestore:"lastRunAt,omitempty"`
+	LastJobID   string                `firestore:"lastJobId,omitempty"`
+	LastError   string                `firestore:"lastError,omitempty"`
+	CreatedAt   int64                 `firestore:"createdAt"`
+	UpdatedAt   int64                 `firestore:"updatedAt"`
+}
+
+// Schedule is a deck regenerated from a source on a cron schedule
+type Schedule struct {
+	ID          string                `json:"id"`
+	Owner       string                `json:"-"`
+	WorkspaceID string                `json:"workspaceId,omitempty"`
+	Name        string                `json:"name"`
+	Cron        string                `json:"cron"`
+	TimeZone    string                `json:"timeZone"`
+	Source      models.ScheduleSource `json:"source"`
+	Theme       string                `json:"theme,omitempty"`
+	PresetID    string                `json:"presetId,omitempty"`
+	Settings    models.SlideSettings  `json:"settings"`
+	NotifyEmail string                `json:"notifyEmail,omitempty"`
+	Labels      map[string]string     `json:"labels,omitempty"`
+	Paused      bool                  `json:"paused"`
+	NextRunAt   int64                 `json:"nextRunAt,omitempty"`
+	LastRunAt   int64                 `json:"lastRunAt,omitempty"`
+	LastJobID   string                `json:"lastJobId,omitempty"`
+	LastError   string                `json:"lastError,omitempty"`
+	CreatedAt   int64                 `json:"createdAt"`
+	UpdatedAt   int64                 `json:"updatedAt"`
+}
+
+// Service manages schedules stored in Firestore
+type Service struct {
+	client *firestore.Client
+}
+
+// NewService creates a new schedule service
+func NewService(client *firestore.Client) *Service {
+	return &Service{
+		client: client,
+	}
+}
+
+// Collection returns the Firestore collection reference for schedules
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("schedules")
+}
+
+// NextRun returns the first run of a cron expression in a time zone after t,
+// checking that the expression runs at most once an hour
+func NextRun(expr, timeZone string, t time.Time) (time.Time, error) {
+	cron, err := ParseCron(expr)
+	if err != nil {
+		return time.Time{}, &InvalidError{Message: err.Error()}
+	}
+	if !cron.AtMostHourly() {
+		return time.Time{}, &InvalidError{Message: "schedules can run at most once an hour, set a single minute"}
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.Time{}, &InvalidError{Message: fmt.Sprintf("unknown time zone: %s", timeZone)}
+	}
+
+	next := cron.Next(t.In(location))
+	if next.IsZero() {
+		return time.Time{}, &InvalidError{Message: "cron expression never runs"}
+	}
+	return next, nil
+}
+
+// List returns the schedules of an owner sorted by name
+func (s *Service) List(ctx context.Context, owner string) ([]Schedule, error) {
+	docs, err := s.Collection().Where("owner", "==", owner).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing schedules: %v", err)
+	}
+
+	schedules := make([]Schedule, 0, len(docs))
+	for _, doc := range docs {
+		var schedule FirestoreSchedule
+		if err := doc.DataTo(&schedule); err != nil {
+			return nil, fmt.Errorf("error parsing schedule data: %v", err)
+		}
+		schedules = append(schedules, toSchedule(doc.Ref.ID, schedule))
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+	return schedules, nil
+}
+
+// Get returns a schedule of an owner
+func (s *Service) Get(ctx context.Context, owner, id string) (*Schedule, error) {
+	schedule, err := s.get(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	result := toSchedule(id, *schedule)
+	return &result, nil
+}
+
+// Create saves a new schedule, generating into the workspace when there is one
+func (s *Service) Create(ctx context.Context, owner, workspaceID string, req models.ScheduleRequest) (*Schedule, error) {
+	existing, err := s.List(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxSchedules {
+		return nil, ErrTooManySchedules
+	}
+
+	now := time.Now()
+	schedule := FirestoreSchedule{
+		Owner:       owner,
+		WorkspaceID: workspaceID,
+		CreatedAt:   now.Unix(),
+	}
+	if err := apply(&schedule, req, now); err != nil {
+		return nil, err
+	}
+
+	id := uuid.New().String()
+	if _, err := s.Collection().Doc(id).Set(ctx, schedule); err != nil {
+		return nil, fmt.Errorf("error saving schedule: %v", err)
+	}
+
+	result := toSchedule(id, schedule)
+	return &result, nil
+}
+
+// Update replaces the settings of a schedule and reschedules its next run
+func (s *Service) Update(ctx context.Context, owner, id string, req models.ScheduleRequest) (*Schedule, error) {
+	schedule, err := s.get(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := apply(schedule, req, time.Now()); err != nil {
+		return nil, err
+	}
+	if _, err := s.Collection().Doc(id).Set(ctx, schedule); err != nil {
+		return nil, fmt.Errorf("error saving schedule: %v", err)
+	}
+
+	result := toSchedule(id, *schedule)
+	return &result, nil
+}
+
+// Delete deletes a schedule of an owner
+func (s *Service) Delete(ctx context.Context, owner, id string) error {
+	if _, err := s.get(ctx, owner, id); err != nil {
+		return err
+	}
+	if _, err := s.Collection().Doc(id).Delete(ctx); err != nil {
+		return fmt.Errorf("error deleting schedule: %v", err)
+	}
+	return nil
+}
+
+// ClaimDue returns up to limit schedules due at now, advancing each to its
+// next run in a transaction so overlapping calls never run a schedule twice
+func (s *Service) ClaimDue(ctx context.Context, now time.Time, limit int) ([]Schedule, error) {
+	refs, err := s.Collection().
+		Where("nextRunAt", ">", 0).
+		Where("nextRunAt", "<=", now.Unix()).
+		OrderBy("nextRunAt", firestore.Asc).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing due schedules: %v", err)
+	}
+
+	claimed := make([]Schedule, 0, len(refs))
+	for _, ref := range refs {
+		var schedule FirestoreSchedule
+		err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			doc, err := tx.Get(ref.Ref)
+			if err != nil {
+				return err
+			}
+			if err := doc.DataTo(&schedule); err != nil {
+				return err
+			}
+			if schedule.NextRunAt == 0 || schedule.NextRunAt > now.Unix() {
+				return errNotDue
+			}
+
+			schedule.LastRunAt = now.Unix()
+			next, err := NextRun(schedule.Cron, schedule.TimeZone, now)
+			if err != nil {
+				// Stop running a schedule whose timing is no longer valid
+				schedule.NextRunAt = 0
+				schedule.LastError = err.Error()
+			} else {
+				schedule.NextRunAt = next.Unix()
+			}
+			return tx.Set(ref.Ref, schedule)
+		})
+		if errors.Is(err, errNotDue) || status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return claimed, fmt.Errorf("error claiming schedule %s: %v", ref.Ref.ID, err)
+		}
+		claimed = append(claimed, toSchedule(ref.Ref.ID, schedule))
+	}
+	return claimed, nil
+}
+
+// RecordRun stores the outcome of a run: the job it created or why it failed
+func (s *Service) RecordRun(ctx context.Context, id, jobID string, runErr error) error {
+	lastError := ""
+	if runErr != nil {
+		lastError = runErr.Error()
+	}
+	_, err := s.Collection().Doc(id).Update(ctx, []firestore.Update{
+		{Path: "lastJobId", Value: jobID},
+		{Path: "lastError", Value: lastError},
+		{Path: "updatedAt", Value: time.Now().Unix()},
+	})
+	if err != nil {
+		return fmt.Errorf("error recording schedule run: %v", err)
+	}
+	return nil
+}
+
+// get returns the stored schedule, or ErrScheduleNotFound when it belongs to another owner
+func (s *Service) get(ctx context.Context, owner, id string) (*FirestoreSchedule, error) {
+	doc, err := s.Collection().Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, fmt.Errorf("error retrieving schedule: %v", err)
+	}
+
+	var schedule FirestoreSchedule
+	if err := doc.DataTo(&schedule); err != nil {
+		return nil, fmt.Errorf("error parsing schedule data: %v", err)
+	}
+	if schedule.Owner != owner {
+		return nil, ErrScheduleNotFound
+	}
+	return &schedule, nil
+}
+
+// apply copies a request into a stored schedule and computes its next run
+func apply(schedule *FirestoreSchedule, req models.ScheduleRequest, now time.Time) error {
+	if err := ValidateSource(req.Source); err != nil {
+		return &InvalidError{Message: err.Error()}
+	}
+	timeZone := req.TimeZone
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	next, err := NextRun(req.Cron, timeZone, now)
+	if err != nil {
+		return err
+	}
+
+	schedule.Name = req.Name
+	schedule.Cron = req.Cron
+	schedule.TimeZone = timeZone
+	schedule.Source = req.Source
+	schedule.Theme = req.Theme
+	schedule.PresetID = req.PresetID
+	schedule.Settings = req.Settings
+	schedule.NotifyEmail = req.NotifyEmail
+	schedule.Labels = req.Labels
+	schedule.Paused = req.Paused
+	schedule.NextRunAt = next.Unix()
+	if req.Paused {
+		schedule.NextRunAt = 0
+	}
+	schedule.UpdatedAt = now.Unix()
+	return nil
+}
+
+// toSchedule converts a stored schedule to its API representation
+func toSchedule(id string, schedule FirestoreSchedule) Schedule {
+	return Schedule{
+		ID:          id,
+		Owner:       schedule.Owner,
+		WorkspaceID: schedule.WorkspaceID,
+		Name:        schedule.Name,
+		Cron:        schedule.Cron,
+		TimeZone:    schedule.TimeZone,
+		Source:      schedule.Source,
+		Theme:       schedule.Theme,
+		PresetID:    schedule.PresetID,
+		Settings:    schedule.Settings,
+		NotifyEmail: schedule.NotifyEmail,
+		Labels:      schedule.Labels,
+		Paused:      schedule.Paused,
+		NextRunAt:   schedule.NextRunAt,
+		LastRunAt:   schedule.LastRunAt,
+		LastJobID:   schedule.LastJobID,
+		LastError:   schedule.LastError,
+		CreatedAt:   schedule.CreatedAt,
+		UpdatedAt:   schedule.UpdatedAt,
+	}
+}
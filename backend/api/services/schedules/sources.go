@@ -0,0 +1,205 @@
+package schedules
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+// Types of schedule sources
+const (
+	SourceURL   = "url"   // Location is an http or https URL
+	SourceDrive = "drive" // Location is a Drive file ID, downloaded by the slides service with the owner's connection
+	SourceRSS   = "rss"   // Location is a feed URL, digested by the slides service
+)
+
+const (
+	// maxSourceBytes is the largest source file fetched, the same as the upload limit
+	maxSourceBytes = 10 << 20
+
+	// sourceTimeout bounds downloading a source
+	sourceTimeout = 30 * time.Second
+
+	// maxSourceRedirects bounds the redirects followed downloading a source
+	maxSourceRedirects = 3
+)
+
+// ValidateSource checks that the location of a source matches its type
+func ValidateSource(s models.ScheduleSource) error {
+	if len(s.Options) > 0 && s.Type != SourceRSS {
+		return fmt.Errorf("source options are only supported for rss sources")
+	}
+	switch s.Type {
+	case SourceURL, SourceRSS:
+		u, err := url.Parse(s.Location)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("source location must be an http or https URL")
+		}
+	case SourceDrive:
+		if s.Location == "" || strings.ContainsAny(s.Location, "/?#") {
+			return fmt.Errorf("source location must be a Google Drive file ID")
+		}
+	}
+	return nil
+}
+
+// Fetcher downloads the URL sources of schedules. Drive files and feeds are
+// downloaded by the slides service instead, with the connection of the owner.
+type Fetcher struct {
+	http *http.Client
+}
+
+// NewFetcher creates a fetcher. The URLs come from the owners of schedules, so
+// it only connects to public addresses, checked after DNS resolution and for
+// every redirect, and never through a proxy.
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		http: &http.Client{
+			Timeout: sourceTimeout,
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout: sourceTimeout,
+					Control: publicAddressOnly,
+				}).DialContext,
+				TLSHandshakeTimeout: sourceTimeout,
+				MaxIdleConns:        16,
+				IdleConnTimeout:     time.Minute,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxSourceRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxSourceRedirects)
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("redirected to unsupported scheme %q", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Fetch downloads the current version of a URL source as a file to generate from
+func (f *Fetcher) Fetch(ctx context.Context, source models.ScheduleSource) (models.File, error) {
+	if source.Type != SourceURL {
+		return models.File{}, fmt.Errorf("unsupported source type: %s", source.Type)
+	}
+	name, body, err := f.fetchURL(ctx, source.Location)
+	if err != nil {
+		return models.File{}, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxSourceBytes+1))
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to read source: %v", err)
+	}
+	if len(data) > maxSourceBytes {
+		return models.File{}, fmt.Errorf("source is larger than %d MB", maxSourceBytes>>20)
+	}
+	return sourceFile(name, data)
+}
+
+// fetchURL downloads a document over http or https
+func (f *Fetcher) fetchURL(ctx context.Context, location string) (string, io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid source URL: %v", err)
+	}
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download %s: %v", location, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return "", nil, fmt.Errorf("failed to download %s: status %d", location, resp.StatusCode)
+	}
+	return path.Base(resp.Request.URL.Path), resp.Body, nil
+}
+
+// sourceFile checks that fetched data is a PDF, Markdown or text document and
+// names it with a matching extension so it is handled like an upload
+func sourceFile(name string, data []byte) (models.File, error) {
+	mimeType := http.DetectContentType(data)
+	if i := strings.Index(mimeType, ";"); i != -1 {
+		mimeType = strings.TrimSpace(mimeType[:i])
+	}
+
+	if name == "" || name == "." || name == "/" {
+		name = "source"
+	}
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case mimeType == "application/pdf":
+		if ext != ".pdf" {
+			name += ".pdf"
+		}
+	case mimeType == "text/plain":
+		if ext != ".md" && ext != ".txt" {
+			name += ".md"
+		}
+	default:
+		return models.File{}, fmt.Errorf("unsupported source type %s, only PDF, Markdown and text documents are allowed", mimeType)
+	}
+
+	return models.File{
+		Filename: name,
+		Data:     data,
+		Type:     mimeType,
+	}, nil
+}
+
+// reservedPrefixes are ranges that aren't private, loopback or link-local but
+// still don't reach the public internet
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "This" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which can embed any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // Documentation
+}
+
+// publicAddressOnly is a dialer Control hook refusing connections to
+// addresses that aren't on the public internet, such as the metadata server
+// or other services in the VPC. It matches the one the slides service fetches
+// viewer assets with.
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !publicAddress(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", ip)
+	}
+	return nil
+}
+
+// publicAddress reports whether an IP address is on the public internet
+func publicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
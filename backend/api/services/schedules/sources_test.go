@@ -0,0 +1,96 @@
+package schedules
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+func TestValidateSource(t *testing.T) {
+	tests := []struct {
+		source models.ScheduleSource
+		valid  bool
+	}{
+		{models.ScheduleSource{Type: SourceURL, Location: "https://example.com/report.md"}, true},
+		{models.ScheduleSource{Type: SourceURL, Location: "file:///etc/passwd"}, false},
+		{models.ScheduleSource{Type: SourceDrive, Location: "1AbC_dEf-123"}, true},
+		{models.ScheduleSource{Type: SourceDrive, Location: "https://drive.google.com/file/d/1AbC"}, false},
+		{models.ScheduleSource{Type: SourceRSS, Location: "https://blog.example.com/feed.xml", Options: map[string]string{"days": "7"}}, true},
+		{models.ScheduleSource{Type: SourceURL, Location: "https://example.com/report.md", Options: map[string]string{"days": "7"}}, false},
+	}
+	for _, test := range tests {
+		if err := ValidateSource(test.source); (err == nil) != test.valid {
+			t.Errorf("ValidateSource(%+v) = %v, want valid %v", test.source, err, test.valid)
+		}
+	}
+}
+
+func TestSourceFileNamesByContent(t *testing.T) {
+	file, err := sourceFile("report", []byte("%PDF-1.7\n..."))
+	if err != nil {
+		t.Fatalf("sourceFile failed: %v", err)
+	}
+	if file.Filename != "report.pdf" || file.Type != "application/pdf" {
+		t.Errorf("unexpected PDF file: %s %s", file.Filename, file.Type)
+	}
+
+	file, err = sourceFile("/", []byte("# Weekly metrics\n"))
+	if err != nil {
+		t.Fatalf("sourceFile failed: %v", err)
+	}
+	if file.Filename != "source.md" || file.Type != "text/plain" {
+		t.Errorf("unexpected text file: %s %s", file.Filename, file.Type)
+	}
+
+	if _, err := sourceFile("page.html", []byte("<!DOCTYPE html><html></html>")); err == nil {
+		t.Error("expected HTML to be rejected")
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.0.0.1":        false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"::1":             false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+		"64:ff9b::a00:1":  false,
+	}
+	for address, want := range tests {
+		if got := publicAddress(netip.MustParseAddr(address)); got != want {
+			t.Errorf("%s: expected %v, got %v", address, want, got)
+		}
+	}
+}
+
+func TestFetchRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("# Internal\n"))
+	}))
+	defer server.Close()
+
+	_, err := NewFetcher().Fetch(context.Background(), models.ScheduleSource{Type: SourceURL, Location: server.URL + "/report.md"})
+	if err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Fatalf("expected the loopback address to be refused, got %v", err)
+	}
+}
+
+func TestFetchOnlyFetchesURLs(t *testing.T) {
+	for _, source := range []models.ScheduleSource{
+		{Type: SourceDrive, Location: "1AbC_dEf-123"},
+		{Type: "gcs", Location: "gs://other-tenant/results/job-1.pdf"},
+	} {
+		if _, err := NewFetcher().Fetch(context.Background(), source); err == nil || !strings.Contains(err.Error(), "unsupported source type") {
+			t.Errorf("%s: expected the source to be refused, got %v", source.Type, err)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxAnalyticsSlides bounds the slides a viewer beacon can report on
+	maxAnalyticsSlides = 500
+
+	// maxBeaconSeconds bounds the time a viewer beacon can add to a slide,
+	// viewers flush their times whenever the page is hidden
+	maxBeaconSeconds = 30 * 60
+
+	// analyticsRetention is how long the analytics of a deck are kept after
+	// it was last viewed
+	analyticsRetention = MaxExpiry
+)
+
+// ErrInvalidBeacon is returned when a viewer beacon doesn't describe the views of a deck
+var ErrInvalidBeacon = errors.New("invalid viewer beacon")
+
+// ViewerBeacon is sent by the viewer of a share link when the page is hidden,
+// with the views since the previous beacon of the same page load. It carries
+// no identifier of the viewer.
+type ViewerBeacon struct {
+	Slides    int   `json:"slides"`    // Slides of the deck
+	Started   bool  `json:"started"`   // First beacon of the page load
+	Completed bool  `json:"completed"` // The last slide was reached for the first time
+	Seen      []int `json:"seen"`      // Slides reached for the first time, from 1
+	Seconds   []int `json:"seconds"`   // Seconds each slide was viewed, by slide
+}
+
+// FirestoreSlideAnalytics is the Firestore representation of the views of a slide
+type FirestoreSlideAnalytics struct {
+	Views       int64 `firestore:"views"`
+	ViewSeconds int64 `firestore:"viewSeconds"`
+}
+
+// FirestoreDeckAnalytics is the Firestore representation of the views of the
+// share links of a result. Only counters are stored, so single viewers can't
+// be told apart.
+type FirestoreDeckAnalytics struct {
+	ResultID    string                             `firestore:"resultId"`
+	Sessions    int64                              `firestore:"sessions"`
+	Completions int64                              `firestore:"completions"`
+	Slides      map[string]FirestoreSlideAnalytics `firestore:"slides"` // Keyed by slide number
+	UpdatedAt   int64                              `firestore:"updatedAt"`
+	DeleteAt    time.Time                          `firestore:"deleteAt"` // For the Firestore TTL policy
+}
+
+// SlideAnalytics are the views of a slide of a shared deck
+type SlideAnalytics struct {
+	Slide          int     `json:"slide"`
+	Views          int64   `json:"views"`          // Page loads that reached the slide
+	ViewSeconds    int64   `json:"viewSeconds"`    // Time spent on the slide in all page loads
+	AverageSeconds float64 `json:"averageSeconds"` // Time spent on the slide per view
+	ReachRate      float64 `json:"reachRate"`      // Share of page loads that reached the slide
+}
+
+// DeckAnalytics are the views of the share links of a result
+type DeckAnalytics struct {
+	ResultID       string           `json:"resultId"`
+	Sessions       int64            `json:"sessions"`
+	Completions    int64            `json:"completions"`
+	CompletionRate float64          `json:"completionRate"`
+	Slides         []SlideAnalytics `json:"slides"`
+	UpdatedAt      int64            `json:"updatedAt,omitempty"`
+}
+
+// AnalyticsCollection returns the Firestore collection reference for deck analytics
+func (s *Service) AnalyticsCollection() *firestore.CollectionRef {
+	return s.client.Collection("deckAnalytics")
+}
+
+// RecordViewerBeacon adds a beacon of the viewer of a share link to the
+// analytics of its result
+func (s *Service) RecordViewerBeacon(ctx context.Context, token string, beacon ViewerBeacon) error {
+	share, err := s.getShare(ctx, token)
+	if err != nil {
+		return err
+	}
+	fields, err := beaconIncrements(beacon)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	fields["resultId"] = share.ResultID
+	fields["updatedAt"] = now.Unix()
+	fields["deleteAt"] = now.Add(analyticsRetention)
+	if _, err := s.AnalyticsCollection().Doc(share.ResultID).Set(ctx, fields, firestore.MergeAll); err != nil {
+		return fmt.Errorf("failed to record viewer analytics: %v", err)
+	}
+	return nil
+}
+
+// GetDeckAnalytics returns the analytics of the share links of a result to
+// whoever access allows reading it, see queue.Access.Check.
+func (s *Service) GetDeckAnalytics(ctx context.Context, resultID string, access queue.Access) (*DeckAnalytics, error) {
+	if _, err := s.getAccessibleResult(ctx, resultID, access); err != nil {
+		return nil, err
+	}
+
+	doc, err := s.AnalyticsCollection().Doc(resultID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return deckReport(FirestoreDeckAnalytics{ResultID: resultID}), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving analytics: %v", err)
+	}
+
+	var analytics FirestoreDeckAnalytics
+	if err := doc.DataTo(&analytics); err != nil {
+		return nil, fmt.Errorf("error parsing analytics data: %v", err)
+	}
+	return deckReport(analytics), nil
+}
+
+// beaconIncrements returns the counters a beacon adds to, as the nested
+// fields of a merge into the analytics of a deck
+func beaconIncrements(beacon ViewerBeacon) (map[string]interface{}, error) {
+	if beacon.Slides < 1 || beacon.Slides > maxAnalyticsSlides || len(beacon.Seconds) > beacon.Slides {
+		return nil, ErrInvalidBeacon
+	}
+
+	slides := make(map[string]map[string]interface{})
+	slide := func(number int) map[string]interface{} {
+		key := strconv.Itoa(number)
+		if slides[key] == nil {
+			slides[key] = make(map[string]interface{})
+		}
+		return slides[key]
+	}
+	for _, number := range beacon.Seen {
+		if number < 1 || number > beacon.Slides {
+			return nil, ErrInvalidBeacon
+		}
+		slide(number)["views"] = firestore.Increment(1)
+	}
+	for i, seconds := range beacon.Seconds {
+		if seconds < 0 {
+			return nil, ErrInvalidBeacon
+		}
+		if seconds > 0 {
+			slide(i + 1)["viewSeconds"] = firestore.Increment(min(seconds, maxBeaconSeconds))
+		}
+	}
+
+	fields := make(map[string]interface{})
+	if len(slides) > 0 {
+		nested := make(map[string]interface{}, len(slides))
+		for key, counters := range slides {
+			nested[key] = counters
+		}
+		fields["slides"] = nested
+	}
+	if beacon.Started {
+		fields["sessions"] = firestore.Increment(1)
+	}
+	if beacon.Completed {
+		fields["completions"] = firestore.Increment(1)
+	}
+	return fields, nil
+}
+
+// deckReport turns the stored counters of a deck into rates per page load,
+// ordered by slide
+func deckReport(analytics FirestoreDeckAnalytics) *DeckAnalytics {
+	report := &DeckAnalytics{
+		ResultID:    analytics.ResultID,
+		Sessions:    analytics.Sessions,
+		Completions: analytics.Completions,
+		Slides:      []SlideAnalytics{},
+		UpdatedAt:   analytics.UpdatedAt,
+	}
+	if analytics.Sessions > 0 {
+		report.CompletionRate = float64(analytics.Completions) / float64(analytics.Sessions)
+	}
+
+	for key, counters := range analytics.Slides {
+		number, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		slide := SlideAnalytics{Slide: number, Views: counters.Views, ViewSeconds: counters.ViewSeconds}
+		if counters.Views > 0 {
+			slide.AverageSeconds = float64(counters.ViewSeconds) / float64(counters.Views)
+		}
+		if analytics.Sessions > 0 {
+			slide.ReachRate = min(float64(counters.Views)/float64(analytics.Sessions), 1)
+		}
+		report.Slides = append(report.Slides, slide)
+	}
+	sort.Slice(report.Slides, func(i, j int) bool {
+		return report.Slides[i].Slide < report.Slides[j].Slide
+	})
+	return report
+}
+
+// bodyEndPattern matches the closing body tag of a page
+var bodyEndPattern = regexp.MustCompile(`(?i)</body\s*>`)
+
+// viewerScript reports the time spent on each slide of a Marp presentation.
+// It reads the slide from the location hash, adds a second to it every second
+// the page is visible, and sends what it counted since the last beacon
+// whenever the page is hidden. It stores nothing in the browser and does
+// nothing when Do Not Track or Global Privacy Control is set.
+const viewerScript = `<script>(function () {
+  if (navigator.doNotTrack === "1" || navigator.globalPrivacyControl || !navigator.sendBeacon) return;
+  var endpoint = %s;
+  var slides = document.querySelectorAll("svg[data-marpit-svg]").length || document.querySelectorAll("section").length;
+  if (!slides) return;
+  var seen = {}, fresh = [], seconds = [], started = false, completed = false, finished = false;
+  function current() {
+    var slide = parseInt(location.hash.replace(/^#/, ""), 10);
+    return slide >= 1 && slide <= slides ? slide : 1;
+  }
+  function tick() {
+    if (document.visibilityState !== "visible") return;
+    var slide = current();
+    if (!seen[slide]) { seen[slide] = true; fresh.push(slide); }
+    if (slide === slides && !completed) { completed = true; finished = true; }
+    seconds[slide - 1] = (seconds[slide - 1] || 0) + 1;
+  }
+  function flush() {
+    if (!fresh.length && !seconds.length) return;
+    for (var i = 0; i < seconds.length; i++) seconds[i] = seconds[i] || 0;
+    var beacon = {slides: slides, started: !started, completed: finished, seen: fresh, seconds: seconds};
+    navigator.sendBeacon(endpoint, new Blob([JSON.stringify(beacon)], {type: "text/plain"}));
+    started = true; finished = false; fresh = []; seconds = [];
+  }
+  tick();
+  setInterval(tick, 1000);
+  document.addEventListener("visibilitychange", function () { if (document.visibilityState === "hidden") flush(); });
+  window.addEventListener("pagehide", flush);
+})();</script>`
+
+// viewerPage is a page of a shared result served from memory
+type viewerPage struct {
+	*bytes.Reader
+}
+
+// Close implements io.Closer
+func (viewerPage) Close() error { return nil }
+
+// WithViewerAnalytics returns the HTML of a shared presentation with the
+// script that sends its views to beaconURL, and closes file
+func WithViewerAnalytics(file *queue.ResultFile, beaconURL string) (*queue.ResultFile, error) {
+	defer file.Close()
+	page, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading presentation: %v", err)
+	}
+
+	// The URL is JSON with HTML escaping, so it can't close the script
+	endpoint, err := json.Marshal(beaconURL)
+	if err != nil {
+		return nil, err
+	}
+	script := []byte(fmt.Sprintf(viewerScript, endpoint))
+	if loc := bodyEndPattern.FindAllIndex(page, -1); len(loc) > 0 {
+		end := loc[len(loc)-1][0]
+		page = append(page[:end:end], append(script, page[end:]...)...)
+	} else {
+		page = append(page, script...)
+	}
+
+	return &queue.ResultFile{
+		ReadSeekCloser: viewerPage{bytes.NewReader(page)},
+		ContentType:    file.ContentType,
+		ModTime:        file.ModTime,
+		ETag:           file.ETag[:max(len(file.ETag)-1, 0)] + `-analytics"`,
+	}, nil
+}
@@ -0,0 +1,100 @@
+package sharing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+)
+
+func TestBeaconIncrements(t *testing.T) {
+	fields, err := beaconIncrements(ViewerBeacon{Slides: 3, Started: true, Seen: []int{1, 2}, Seconds: []int{12, 0, 99999}})
+	if err != nil {
+		t.Fatalf("beaconIncrements failed: %v", err)
+	}
+	want := map[string]interface{}{
+		"sessions": firestore.Increment(1),
+		"slides": map[string]interface{}{
+			"1": map[string]interface{}{"views": firestore.Increment(1), "viewSeconds": firestore.Increment(12)},
+			"2": map[string]interface{}{"views": firestore.Increment(1)},
+			"3": map[string]interface{}{"viewSeconds": firestore.Increment(maxBeaconSeconds)},
+		},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("expected %v, got %v", want, fields)
+	}
+
+	invalid := []ViewerBeacon{
+		{Slides: 0},
+		{Slides: maxAnalyticsSlides + 1},
+		{Slides: 2, Seen: []int{3}},
+		{Slides: 2, Seen: []int{0}},
+		{Slides: 2, Seconds: []int{1, 2, 3}},
+		{Slides: 2, Seconds: []int{-1}},
+	}
+	for _, beacon := range invalid {
+		if _, err := beaconIncrements(beacon); !errors.Is(err, ErrInvalidBeacon) {
+			t.Errorf("%+v: expected ErrInvalidBeacon, got %v", beacon, err)
+		}
+	}
+}
+
+func TestDeckReport(t *testing.T) {
+	report := deckReport(FirestoreDeckAnalytics{
+		ResultID:    "job-1",
+		Sessions:    4,
+		Completions: 1,
+		Slides: map[string]FirestoreSlideAnalytics{
+			"10": {Views: 1, ViewSeconds: 5},
+			"2":  {Views: 2, ViewSeconds: 30},
+			"1":  {Views: 4, ViewSeconds: 80},
+		},
+	})
+
+	if report.CompletionRate != 0.25 {
+		t.Errorf("expected a completion rate of 0.25, got %v", report.CompletionRate)
+	}
+	want := []SlideAnalytics{
+		{Slide: 1, Views: 4, ViewSeconds: 80, AverageSeconds: 20, ReachRate: 1},
+		{Slide: 2, Views: 2, ViewSeconds: 30, AverageSeconds: 15, ReachRate: 0.5},
+		{Slide: 10, Views: 1, ViewSeconds: 5, AverageSeconds: 5, ReachRate: 0.25},
+	}
+	if !reflect.DeepEqual(report.Slides, want) {
+		t.Fatalf("expected %+v, got %+v", want, report.Slides)
+	}
+
+	if empty := deckReport(FirestoreDeckAnalytics{ResultID: "job-2"}); empty.CompletionRate != 0 || empty.Slides == nil {
+		t.Fatalf("expected an empty report without views, got %+v", empty)
+	}
+}
+
+func TestWithViewerAnalytics(t *testing.T) {
+	page := `<html><body><section>Hi</section></BODY></html>`
+	file := &queue.ResultFile{
+		ReadSeekCloser: viewerPage{bytes.NewReader([]byte(page))},
+		ContentType:    "text/html",
+		ETag:           `"abc"`,
+	}
+
+	served, err := WithViewerAnalytics(file, "https://api.example.com/v1/shared/</script>/analytics")
+	if err != nil {
+		t.Fatalf("WithViewerAnalytics failed: %v", err)
+	}
+	data, _ := io.ReadAll(served)
+	html := string(data)
+
+	if !strings.HasPrefix(html, "<html><body><section>Hi</section><script>") || !strings.HasSuffix(html, "</script></BODY></html>") {
+		t.Errorf("expected the script before the end of the body, got %s", html)
+	}
+	if strings.Contains(html, "/v1/shared/</script>") {
+		t.Errorf("expected the beacon URL to be escaped, got %s", html)
+	}
+	if served.ETag == file.ETag || !strings.HasSuffix(served.ETag, `"`) {
+		t.Errorf("expected a different entity tag, got %s", served.ETag)
+	}
+}
@@ -0,0 +1,121 @@
+package sharing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/services/queue"
+)
+
+const (
+	// DefaultDownloadExpiry is how long a download URL stays valid when no expiry is requested
+	DefaultDownloadExpiry = 15 * time.Minute
+
+	// MaxDownloadExpiry is the longest a download URL can stay valid
+	MaxDownloadExpiry = 24 * time.Hour
+)
+
+var (
+	// ErrDownloadsDisabled is returned when no secret to sign download URLs with is configured
+	ErrDownloadsDisabled = errors.New("download URLs are not available on this instance")
+
+	// ErrInvalidSignature is returned when a download URL wasn't signed by the API
+	ErrInvalidSignature = errors.New("invalid download URL signature")
+
+	// ErrDownloadExpired is returned when a download URL is used after it expired
+	ErrDownloadExpired = errors.New("download URL has expired")
+)
+
+// downloadFormats are the documents of a result a download URL can link to
+var downloadFormats = map[queue.ResultFormat]bool{
+	queue.ResultPDF:              true,
+	queue.ResultHTML:             true,
+	queue.ResultViewer:           true,
+	queue.ResultFlashcards:       true,
+	queue.ResultOnePager:         true,
+	queue.ResultOnePagerMarkdown: true,
+	queue.ResultHandout:          true,
+	queue.ResultHandoutMarkdown:  true,
+	queue.ResultAlignment:        true,
+	queue.ResultChunkSummaries:   true,
+	queue.ResultGrounding:        true,
+}
+
+// DownloadURL is a signed link to a document of a result, valid until it
+// expires for anyone holding it
+type DownloadURL struct {
+	ResultID  string
+	Format    queue.ResultFormat
+	Signature string
+	ExpiresAt int64
+}
+
+// ValidDownloadFormat reports whether download URLs can link to a document
+func ValidDownloadFormat(format queue.ResultFormat) bool {
+	return downloadFormats[format]
+}
+
+// CreateDownloadURL signs a short-lived URL for a document of a result, for
+// sharing it with someone who shouldn't get an API key or claim token. The
+// URL expires after expiry, and never after the result. Unlike share links,
+// download URLs aren't stored and can't be revoked, so they are only signed
+// for the owner of the job of the result or a member of its workspace, or the
+// holder of the claim token of an anonymous job, see queue.Access.Check.
+func (s *Service) CreateDownloadURL(ctx context.Context, resultID string, access queue.Access, format queue.ResultFormat, expiry time.Duration) (*DownloadURL, error) {
+	if len(s.downloadSecret) == 0 {
+		return nil, ErrDownloadsDisabled
+	}
+	if expiry <= 0 {
+		expiry = DefaultDownloadExpiry
+	}
+	if expiry > MaxDownloadExpiry {
+		expiry = MaxDownloadExpiry
+	}
+
+	result, err := s.getAccessibleResult(ctx, resultID, access)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(expiry).Unix()
+	if result.ExpiresAt > 0 && result.ExpiresAt < expiresAt {
+		expiresAt = result.ExpiresAt
+	}
+	log.Printf("Signed a %s download URL for result %s (expires at %s)", format, resultID, time.Unix(expiresAt, 0).Format(time.RFC3339))
+
+	return &DownloadURL{
+		ResultID:  resultID,
+		Format:    format,
+		Signature: s.signDownload(resultID, format, expiresAt),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// GetDownload resolves a download URL to its result, checking its signature
+// and expiry
+func (s *Service) GetDownload(ctx context.Context, resultID string, format queue.ResultFormat, expiresAt int64, signature string) (*queue.FirestoreResult, error) {
+	if len(s.downloadSecret) == 0 {
+		return nil, ErrDownloadsDisabled
+	}
+	if !hmac.Equal([]byte(s.signDownload(resultID, format, expiresAt)), []byte(signature)) {
+		return nil, ErrInvalidSignature
+	}
+	if time.Now().Unix() >= expiresAt {
+		return nil, ErrDownloadExpired
+	}
+	return s.results.GetResult(ctx, resultID)
+}
+
+// signDownload returns the HMAC-SHA256 of the result, document and expiry of
+// a download URL
+func (s *Service) signDownload(resultID string, format queue.ResultFormat, expiresAt int64) string {
+	mac := hmac.New(sha256.New, s.downloadSecret)
+	fmt.Fprintf(mac, "%s\n%s\n%d", resultID, format, expiresAt)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,80 @@
+package sharing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/services/queue"
+)
+
+func TestGetDownloadChecksSignatureAndExpiry(t *testing.T) {
+	service := &Service{downloadSecret: []byte("0123456789abcdef0123456789abcdef")}
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	signature := service.signDownload("job-1", queue.ResultPDF, expiresAt)
+
+	tests := []struct {
+		id        string
+		format    queue.ResultFormat
+		expiresAt int64
+		signature string
+		err       error
+	}{
+		{"job-2", queue.ResultPDF, expiresAt, signature, ErrInvalidSignature},
+		{"job-1", queue.ResultHTML, expiresAt, signature, ErrInvalidSignature},
+		{"job-1", queue.ResultPDF, expiresAt + 3600, signature, ErrInvalidSignature},
+		{"job-1", queue.ResultPDF, expiresAt, "", ErrInvalidSignature},
+	}
+	for _, test := range tests {
+		if _, err := service.GetDownload(context.Background(), test.id, test.format, test.expiresAt, test.signature); !errors.Is(err, test.err) {
+			t.Errorf("%s %s %d: expected %v, got %v", test.id, test.format, test.expiresAt, test.err, err)
+		}
+	}
+
+	expired := time.Now().Add(-time.Minute).Unix()
+	if _, err := service.GetDownload(context.Background(), "job-1", queue.ResultPDF, expired, service.signDownload("job-1", queue.ResultPDF, expired)); !errors.Is(err, ErrDownloadExpired) {
+		t.Fatalf("expected ErrDownloadExpired, got %v", err)
+	}
+
+	// URLs signed with another key aren't accepted
+	other := &Service{downloadSecret: []byte("fedcba9876543210fedcba9876543210")}
+	if _, err := other.GetDownload(context.Background(), "job-1", queue.ResultPDF, expiresAt, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestCreateDownloadURLChecksOwner(t *testing.T) {
+	service, _, _ := newTestService(
+		queue.FirestoreResult{ID: "anonymous", ClaimTokenHash: "hash"},
+		queue.FirestoreResult{ID: "owned", Owner: "key-1", WorkspaceID: "ws-1"},
+	)
+	service.downloadSecret = []byte("0123456789abcdef0123456789abcdef")
+
+	tests := []struct {
+		id     string
+		access queue.Access
+		err    error
+	}{
+		{"anonymous", queue.Access{Owner: "key-1"}, queue.ErrClaimRequired},
+		{"owned", queue.Access{}, queue.ErrNotOwner},
+		{"owned", queue.Access{Owner: "key-2", WorkspaceID: "ws-2"}, queue.ErrNotOwner},
+		{"owned", queue.Access{Owner: "key-1"}, nil},
+		{"owned", queue.Access{Owner: "key-2", WorkspaceID: "ws-1"}, nil},
+	}
+	for _, test := range tests {
+		if _, err := service.CreateDownloadURL(context.Background(), test.id, test.access, queue.ResultPDF, 0); !errors.Is(err, test.err) {
+			t.Errorf("%s %+v: expected %v, got %v", test.id, test.access, test.err, err)
+		}
+	}
+}
+
+func TestDownloadURLsDisabledWithoutSecret(t *testing.T) {
+	service := &Service{}
+	if _, err := service.CreateDownloadURL(context.Background(), "job-1", queue.Access{}, queue.ResultPDF, 0); !errors.Is(err, ErrDownloadsDisabled) {
+		t.Fatalf("expected ErrDownloadsDisabled, got %v", err)
+	}
+	if _, err := service.GetDownload(context.Background(), "job-1", queue.ResultPDF, time.Now().Unix(), "signature"); !errors.Is(err, ErrDownloadsDisabled) {
+		t.Fatalf("expected ErrDownloadsDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package sharing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultExpiry is how long a share link stays valid when no expiry is requested
+	DefaultExpiry = 7 * 24 * time.Hour
+
+	// MaxExpiry is the longest a share link can stay valid
+	MaxExpiry = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrShareNotFound is returned when a share token doesn't exist, was revoked or expired
+	ErrShareNotFound = errors.New("share link not found")
+
+	// ErrPasswordRequired is returned when a share is password protected and the password is missing or wrong
+	ErrPasswordRequired = errors.New("a valid password is required to view this presentation")
+
+	// ErrInvalidManagementKey is returned when revoking a share with the wrong management key
+	ErrInvalidManagementKey = errors.New("invalid management key")
+)
+
+// FirestoreShare is the Firestore representation of a share link
+type FirestoreShare struct {
+	Token             string `firestore:"token"`
+	ResultID          string `firestore:"resultId"`
+	PasswordHash      []byte `firestore:"passwordHash,omitempty"`
+	ManagementKeyHash []byte `firestore:"managementKeyHash"`
+	Revoked           bool   `firestore:"revoked"`
+	CreatedAt         int64  `firestore:"createdAt"`
+	ExpiresAt         int64  `firestore:"expiresAt"`
+}
+
+// Share is a newly created share link, including the secrets only shown once
+type Share struct {
+	Token         string `json:"token"`
+	ManagementKey string `json:"managementKey"`
+	ResultID      string `json:"resultId"`
+	Protected     bool   `json:"protected"`
+	CreatedAt     int64  `json:"createdAt"`
+	ExpiresAt     int64  `json:"expiresAt"`
+}
+
+// Service manages share links for generated presentations
+type Service struct {
+	client         *firestore.Client // Stores the analytics of decks
+	shares         ShareStore
+	results        Results
+	downloadSecret []byte // Key download URLs are signed with, empty when they are disabled
+}
+
+// NewService creates a new sharing service, which signs download URLs with
+// downloadSecret unless it is empty
+func NewService(client *firestore.Client, queueService *queue.Service, downloadSecret string) *Service {
+	return &Service{
+		client:         client,
+		shares:         NewFirestoreShareStore(client),
+		results:        queueService,
+		downloadSecret: []byte(downloadSecret),
+	}
+}
+
+// NewServiceWithStores creates a new sharing service on top of the given
+// stores, which lets tests replace Firestore. Deck analytics aren't available.
+func NewServiceWithStores(shares ShareStore, results Results, downloadSecret string) *Service {
+	return &Service{
+		shares:         shares,
+		results:        results,
+		downloadSecret: []byte(downloadSecret),
+	}
+}
+
+// CreateShare creates a share link for a result, optionally protected by a password.
+// The result is kept alive for as long as the share link is valid. Only the
+// owner of the job of the result or a member of its workspace shares it, or
+// the holder of the claim token of an anonymous job, see queue.Access.Check.
+func (s *Service) CreateShare(ctx context.Context, resultID string, access queue.Access, password string, expiry time.Duration) (*Share, error) {
+	if expiry <= 0 {
+		expiry = DefaultExpiry
+	}
+	if expiry > MaxExpiry {
+		expiry = MaxExpiry
+	}
+
+	result, err := s.getAccessibleResult(ctx, resultID, access)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate share token: %v", err)
+	}
+	managementKey, err := generateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate management key: %v", err)
+	}
+
+	managementKeyHash, err := bcrypt.GenerateFromPassword([]byte(managementKey), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash management key: %v", err)
+	}
+
+	now := time.Now()
+	firestoreShare := FirestoreShare{
+		Token:             token,
+		ResultID:          resultID,
+		ManagementKeyHash: managementKeyHash,
+		CreatedAt:         now.Unix(),
+		ExpiresAt:         now.Add(expiry).Unix(),
+	}
+
+	if password != "" {
+		passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %v", err)
+		}
+		firestoreShare.PasswordHash = passwordHash
+	}
+
+	// Keep the result around until the share link expires
+	if result.ExpiresAt < firestoreShare.ExpiresAt {
+		if err := s.results.ExtendResult(ctx, resultID, firestoreShare.ExpiresAt); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.shares.CreateShare(ctx, firestoreShare); err != nil {
+		log.Printf("Failed to store share for result %s: %v", resultID, err)
+		return nil, fmt.Errorf("failed to store share link: %v", err)
+	}
+
+	log.Printf("Created share link for result %s (expires at %s)", resultID, time.Unix(firestoreShare.ExpiresAt, 0).Format(time.RFC3339))
+
+	return &Share{
+		Token:         token,
+		ManagementKey: managementKey,
+		ResultID:      resultID,
+		Protected:     password != "",
+		CreatedAt:     firestoreShare.CreatedAt,
+		ExpiresAt:     firestoreShare.ExpiresAt,
+	}, nil
+}
+
+// getAccessibleResult returns a result, or the error of queue.Access.Check
+// when access doesn't allow reading it
+func (s *Service) getAccessibleResult(ctx context.Context, resultID string, access queue.Access) (*queue.FirestoreResult, error) {
+	result, err := s.results.GetResult(ctx, resultID)
+	if err != nil {
+		return nil, err
+	}
+	if err := access.Check(result.Owner, result.WorkspaceID, result.ClaimTokenHash); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetSharedResult resolves a share token to its result, checking the password if one is set
+func (s *Service) GetSharedResult(ctx context.Context, token, password string) (*queue.FirestoreResult, error) {
+	share, err := s.getShare(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(share.PasswordHash) > 0 {
+		if password == "" || bcrypt.CompareHashAndPassword(share.PasswordHash, []byte(password)) != nil {
+			return nil, ErrPasswordRequired
+		}
+	}
+
+	return s.results.GetResult(ctx, share.ResultID)
+}
+
+// RecordDownload counts a download of a shared result, see queue.Service.RecordDownload
+func (s *Service) RecordDownload(ctx context.Context, result *queue.FirestoreResult) {
+	s.results.RecordDownload(ctx, result, time.Now())
+}
+
+// OpenResultFile opens a document of a shared result
+func (s *Service) OpenResultFile(ctx context.Context, result *queue.FirestoreResult, format queue.ResultFormat) (*queue.ResultFile, error) {
+	return s.results.OpenResultFile(ctx, result, format)
+}
+
+// RevokeShare revokes a share link so it can no longer be used
+func (s *Service) RevokeShare(ctx context.Context, token, managementKey string) error {
+	share, err := s.getShare(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if bcrypt.CompareHashAndPassword(share.ManagementKeyHash, []byte(managementKey)) != nil {
+		return ErrInvalidManagementKey
+	}
+
+	if err := s.shares.RevokeShare(ctx, token); err != nil {
+		return fmt.Errorf("failed to revoke share link: %v", err)
+	}
+
+	log.Printf("Revoked share link for result %s", share.ResultID)
+	return nil
+}
+
+// getShare retrieves a share link that is neither revoked nor expired
+func (s *Service) getShare(ctx context.Context, token string) (*FirestoreShare, error) {
+	share, err := s.shares.GetShare(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if share.Revoked {
+		return nil, ErrShareNotFound
+	}
+
+	// Check if the share link has expired
+	if time.Now().Unix() > share.ExpiresAt {
+		if err := s.shares.DeleteShare(ctx, token); err != nil {
+			log.Printf("Failed to delete expired share link for result %s: %v", share.ResultID, err)
+		}
+		return nil, ErrShareNotFound
+	}
+
+	return share, nil
+}
+
+// generateSecret generates a random URL-safe token
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,192 @@
+package sharing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/martin226/slideitin/backend/api/services/queue"
+)
+
+// memoryShareStore is a ShareStore kept in memory
+type memoryShareStore struct {
+	shares map[string]FirestoreShare
+}
+
+func newMemoryShareStore() *memoryShareStore {
+	return &memoryShareStore{shares: make(map[string]FirestoreShare)}
+}
+
+func (s *memoryShareStore) GetShare(ctx context.Context, token string) (*FirestoreShare, error) {
+	share, ok := s.shares[token]
+	if !ok {
+		return nil, ErrShareNotFound
+	}
+	return &share, nil
+}
+
+func (s *memoryShareStore) CreateShare(ctx context.Context, share FirestoreShare) error {
+	s.shares[share.Token] = share
+	return nil
+}
+
+func (s *memoryShareStore) RevokeShare(ctx context.Context, token string) error {
+	share := s.shares[token]
+	share.Revoked = true
+	s.shares[token] = share
+	return nil
+}
+
+func (s *memoryShareStore) DeleteShare(ctx context.Context, token string) error {
+	delete(s.shares, token)
+	return nil
+}
+
+// memoryResults serves the results of a queue kept in memory
+type memoryResults struct {
+	results map[string]queue.FirestoreResult
+}
+
+func (r *memoryResults) GetResult(ctx context.Context, jobID string) (*queue.FirestoreResult, error) {
+	result, ok := r.results[jobID]
+	if !ok {
+		return nil, fmt.Errorf("result %w", queue.ErrNotFound)
+	}
+	return &result, nil
+}
+
+func (r *memoryResults) ExtendResult(ctx context.Context, jobID string, expiresAt int64) error {
+	result := r.results[jobID]
+	result.ExpiresAt = expiresAt
+	r.results[jobID] = result
+	return nil
+}
+
+func (r *memoryResults) RecordDownload(ctx context.Context, result *queue.FirestoreResult, now time.Time) {
+}
+
+func (r *memoryResults) OpenResultFile(ctx context.Context, result *queue.FirestoreResult, format queue.ResultFormat) (*queue.ResultFile, error) {
+	return nil, queue.ErrNotFound
+}
+
+func newTestService(results ...queue.FirestoreResult) (*Service, *memoryShareStore, *memoryResults) {
+	shares := newMemoryShareStore()
+	stored := &memoryResults{results: make(map[string]queue.FirestoreResult)}
+	for _, result := range results {
+		stored.results[result.ID] = result
+	}
+	return NewServiceWithStores(shares, stored, ""), shares, stored
+}
+
+func TestCreateShareExtendsResult(t *testing.T) {
+	service, shares, results := newTestService(queue.FirestoreResult{ID: "job-1", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	share, err := service.CreateShare(context.Background(), "job-1", queue.Access{}, "", 0)
+	if err != nil {
+		t.Fatalf("CreateShare failed: %v", err)
+	}
+	if share.Protected || share.Token == "" || share.ManagementKey == "" {
+		t.Fatalf("unexpected share: %+v", share)
+	}
+	if want := share.CreatedAt + int64(DefaultExpiry.Seconds()); share.ExpiresAt != want {
+		t.Fatalf("expected the default expiry %d, got %d", want, share.ExpiresAt)
+	}
+	if results.results["job-1"].ExpiresAt != share.ExpiresAt {
+		t.Fatal("expected the result to be kept until the share link expires")
+	}
+	if _, ok := shares.shares[share.Token]; !ok {
+		t.Fatal("expected the share link to be stored")
+	}
+
+	capped, err := service.CreateShare(context.Background(), "job-1", queue.Access{}, "", 2*MaxExpiry)
+	if err != nil {
+		t.Fatalf("CreateShare failed: %v", err)
+	}
+	if want := capped.CreatedAt + int64(MaxExpiry.Seconds()); capped.ExpiresAt != want {
+		t.Fatalf("expected the expiry to be capped at %d, got %d", want, capped.ExpiresAt)
+	}
+}
+
+func TestCreateShareChecksResult(t *testing.T) {
+	service, _, _ := newTestService(
+		queue.FirestoreResult{ID: "anonymous", ClaimTokenHash: "hash"},
+		queue.FirestoreResult{ID: "owned", Owner: "key-1", WorkspaceID: "ws-1"},
+	)
+
+	if _, err := service.CreateShare(context.Background(), "missing", queue.Access{}, "", 0); !errors.Is(err, queue.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := service.CreateShare(context.Background(), "anonymous", queue.Access{}, "", 0); !errors.Is(err, queue.ErrClaimRequired) {
+		t.Fatalf("expected ErrClaimRequired, got %v", err)
+	}
+	if _, err := service.CreateShare(context.Background(), "owned", queue.Access{Owner: "key-2"}, "", 0); !errors.Is(err, queue.ErrNotOwner) {
+		t.Fatalf("expected ErrNotOwner, got %v", err)
+	}
+	for _, access := range []queue.Access{{Owner: "key-1"}, {Owner: "key-2", WorkspaceID: "ws-1"}} {
+		if _, err := service.CreateShare(context.Background(), "owned", access, "", 0); err != nil {
+			t.Fatalf("expected %+v to share the result, got %v", access, err)
+		}
+	}
+}
+
+func TestGetSharedResultChecksPassword(t *testing.T) {
+	service, _, _ := newTestService(queue.FirestoreResult{ID: "job-1"})
+	share, err := service.CreateShare(context.Background(), "job-1", queue.Access{}, "hunter2", 0)
+	if err != nil {
+		t.Fatalf("CreateShare failed: %v", err)
+	}
+	if !share.Protected {
+		t.Fatal("expected the share to be protected")
+	}
+
+	for _, password := range []string{"", "wrong"} {
+		if _, err := service.GetSharedResult(context.Background(), share.Token, password); !errors.Is(err, ErrPasswordRequired) {
+			t.Errorf("password %q: expected ErrPasswordRequired, got %v", password, err)
+		}
+	}
+	result, err := service.GetSharedResult(context.Background(), share.Token, "hunter2")
+	if err != nil {
+		t.Fatalf("GetSharedResult failed: %v", err)
+	}
+	if result.ID != "job-1" {
+		t.Fatalf("expected result job-1, got %s", result.ID)
+	}
+}
+
+func TestGetSharedResultDeletesExpiredShare(t *testing.T) {
+	service, shares, _ := newTestService(queue.FirestoreResult{ID: "job-1"})
+	shares.shares["expired"] = FirestoreShare{Token: "expired", ResultID: "job-1", ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+
+	if _, err := service.GetSharedResult(context.Background(), "expired", ""); !errors.Is(err, ErrShareNotFound) {
+		t.Fatalf("expected ErrShareNotFound, got %v", err)
+	}
+	if _, ok := shares.shares["expired"]; ok {
+		t.Fatal("expected the expired share link to be deleted")
+	}
+	if _, err := service.GetSharedResult(context.Background(), "unknown", ""); !errors.Is(err, ErrShareNotFound) {
+		t.Fatalf("expected ErrShareNotFound, got %v", err)
+	}
+}
+
+func TestRevokeShare(t *testing.T) {
+	service, _, _ := newTestService(queue.FirestoreResult{ID: "job-1"})
+	share, err := service.CreateShare(context.Background(), "job-1", queue.Access{}, "", 0)
+	if err != nil {
+		t.Fatalf("CreateShare failed: %v", err)
+	}
+
+	if err := service.RevokeShare(context.Background(), share.Token, "wrong"); !errors.Is(err, ErrInvalidManagementKey) {
+		t.Fatalf("expected ErrInvalidManagementKey, got %v", err)
+	}
+	if _, err := service.GetSharedResult(context.Background(), share.Token, ""); err != nil {
+		t.Fatalf("expected the share link to work before it is revoked, got %v", err)
+	}
+	if err := service.RevokeShare(context.Background(), share.Token, share.ManagementKey); err != nil {
+		t.Fatalf("RevokeShare failed: %v", err)
+	}
+	if _, err := service.GetSharedResult(context.Background(), share.Token, ""); !errors.Is(err, ErrShareNotFound) {
+		t.Fatalf("expected the revoked share link not to be found, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package sharing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ShareStore persists share links
+type ShareStore interface {
+	// GetShare returns ErrShareNotFound when the share link doesn't exist
+	GetShare(ctx context.Context, token string) (*FirestoreShare, error)
+	CreateShare(ctx context.Context, share FirestoreShare) error
+	RevokeShare(ctx context.Context, token string) error
+	DeleteShare(ctx context.Context, token string) error
+}
+
+// Results reads and keeps alive the results share links point to, as
+// queue.Service does
+type Results interface {
+	GetResult(ctx context.Context, jobID string) (*queue.FirestoreResult, error)
+	ExtendResult(ctx context.Context, jobID string, expiresAt int64) error
+	RecordDownload(ctx context.Context, result *queue.FirestoreResult, now time.Time)
+	OpenResultFile(ctx context.Context, result *queue.FirestoreResult, format queue.ResultFormat) (*queue.ResultFile, error)
+}
+
+// FirestoreShareStore stores share links in Firestore
+type FirestoreShareStore struct {
+	client *firestore.Client
+}
+
+// NewFirestoreShareStore creates a share store backed by Firestore
+func NewFirestoreShareStore(client *firestore.Client) *FirestoreShareStore {
+	return &FirestoreShareStore{client: client}
+}
+
+// Collection returns the Firestore collection reference for share links
+func (s *FirestoreShareStore) Collection() *firestore.CollectionRef {
+	return s.client.Collection("shares")
+}
+
+// GetShare retrieves a share link
+func (s *FirestoreShareStore) GetShare(ctx context.Context, token string) (*FirestoreShare, error) {
+	doc, err := s.Collection().Doc(token).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrShareNotFound
+		}
+		return nil, fmt.Errorf("error retrieving share link: %v", err)
+	}
+
+	var share FirestoreShare
+	if err := doc.DataTo(&share); err != nil {
+		return nil, fmt.Errorf("error parsing share data: %v", err)
+	}
+	return &share, nil
+}
+
+// CreateShare stores a new share link
+func (s *FirestoreShareStore) CreateShare(ctx context.Context, share FirestoreShare) error {
+	_, err := s.Collection().Doc(share.Token).Set(ctx, share)
+	return err
+}
+
+// RevokeShare marks a share link as revoked
+func (s *FirestoreShareStore) RevokeShare(ctx context.Context, token string) error {
+	_, err := s.Collection().Doc(token).Update(ctx, []firestore.Update{
+		{Path: "revoked", Value: true},
+	})
+	return err
+}
+
+// DeleteShare deletes a share link
+func (s *FirestoreShareStore) DeleteShare(ctx context.Context, token string) error {
+	_, err := s.Collection().Doc(token).Delete(ctx)
+	return err
+}
@@ -0,0 +1,247 @@
+package themes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MaxCSSBytes is the largest theme stylesheet accepted
+	MaxCSSBytes = 256 << 10
+
+	// Prefix is where theme stylesheets are stored in Cloud Storage
+	Prefix = "themes/"
+
+	// namesTTL is how long the names of the contributed themes are used before
+	// they are read again, so a new theme can be used within a minute
+	namesTTL = time.Minute
+)
+
+var (
+	// ErrThemeNotFound is returned when a theme isn't in the registry
+	ErrThemeNotFound = errors.New("theme not found")
+
+	// ErrInvalidTheme is wrapped by the errors of themes that can't be registered
+	ErrInvalidTheme = errors.New("invalid theme")
+
+	// namePattern matches theme names such as solarized or night_owl
+	namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,39}$`)
+
+	// themeCommentPattern matches the comment naming a Marp theme
+	themeCommentPattern = regexp.MustCompile(`/\*[\s*!]*@theme\s+([A-Za-z0-9_-]+)`)
+)
+
+// FirestoreTheme is the Firestore representation of a contributed theme,
+// stored under its name
+type FirestoreTheme struct {
+	Name       string `firestore:"name"`
+	Author     string `firestore:"author,omitempty"`
+	CSSPath    string `firestore:"cssPath"`
+	Hash       string `firestore:"hash"` // SHA-256 of the stylesheet, names the copies cached by the slides service
+	UploadedBy string `firestore:"uploadedBy"`
+	CreatedAt  int64  `firestore:"createdAt"`
+	UpdatedAt  int64  `firestore:"updatedAt"`
+}
+
+// Theme is a theme decks can be rendered with
+type Theme struct {
+	Name        string `json:"name"`
+	Author      string `json:"author,omitempty"`
+	Contributed bool   `json:"contributed"` // Added at runtime rather than built into the slides service
+	UpdatedAt   int64  `json:"updatedAt,omitempty"`
+}
+
+// ValidateCSS checks that a stylesheet can be registered as a theme. Marp
+// finds themes by the @theme comment, so it must name the theme.
+func ValidateCSS(name string, css []byte) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("%w: the name must be 2 to 40 lowercase letters, digits and _, starting with a letter", ErrInvalidTheme)
+	}
+	if slices.Contains(models.ValidThemes, name) {
+		return fmt.Errorf("%w: %s is a built-in theme", ErrInvalidTheme, name)
+	}
+	if len(css) == 0 || len(css) > MaxCSSBytes {
+		return fmt.Errorf("%w: the stylesheet must be between 1 byte and %d KB", ErrInvalidTheme, MaxCSSBytes>>10)
+	}
+	if !utf8.Valid(css) {
+		return fmt.Errorf("%w: the stylesheet isn't UTF-8 text", ErrInvalidTheme)
+	}
+	match := themeCommentPattern.FindSubmatch(css)
+	if match == nil || string(match[1]) != name {
+		return fmt.Errorf("%w: the stylesheet must start with a /* @theme %s */ comment", ErrInvalidTheme, name)
+	}
+	return nil
+}
+
+// Service manages the registry of themes contributed at runtime, whose
+// stylesheets the slides service loads without being rebuilt
+type Service struct {
+	client *firestore.Client
+	blobs  queue.BlobStore
+
+	mu        sync.Mutex
+	names     map[string]bool
+	fetchedAt time.Time
+}
+
+// NewService creates a new theme registry service storing the stylesheets in the blob store
+func NewService(client *firestore.Client, blobs queue.BlobStore) *Service {
+	return &Service{
+		client: client,
+		blobs:  blobs,
+	}
+}
+
+// Collection returns the Firestore collection reference for contributed themes
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("themes")
+}
+
+// List returns the built-in themes followed by the contributed ones by name
+func (s *Service) List(ctx context.Context) ([]Theme, error) {
+	docs, err := s.Collection().Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list themes: %v", err)
+	}
+
+	list := make([]Theme, 0, len(models.ValidThemes)+len(docs))
+	for _, name := range models.ValidThemes {
+		list = append(list, Theme{Name: name})
+	}
+	contributed := make([]Theme, 0, len(docs))
+	for _, doc := range docs {
+		var theme FirestoreTheme
+		if err := doc.DataTo(&theme); err != nil {
+			return nil, fmt.Errorf("failed to parse theme %s: %v", doc.Ref.ID, err)
+		}
+		contributed = append(contributed, Theme{Name: theme.Name, Author: theme.Author, Contributed: true, UpdatedAt: theme.UpdatedAt})
+	}
+	sort.Slice(contributed, func(i, j int) bool { return contributed[i].Name < contributed[j].Name })
+	return append(list, contributed...), nil
+}
+
+// Upload validates a stylesheet and registers it under the theme name,
+// replacing the stylesheet of a theme with the same name
+func (s *Service) Upload(ctx context.Context, name, author string, css []byte, uploadedBy string) (*Theme, error) {
+	if err := ValidateCSS(name, css); err != nil {
+		return nil, err
+	}
+
+	// Stylesheets are stored by content, so the slides service caches each
+	// version of a theme under its own hash
+	sum := sha256.Sum256(css)
+	hash := hex.EncodeToString(sum[:])
+	cssPath := Prefix + name + "/" + hash + ".css"
+	if err := s.blobs.Upload(ctx, cssPath, "text/css", css); err != nil {
+		return nil, fmt.Errorf("failed to store stylesheet: %v", err)
+	}
+
+	ref := s.Collection().Doc(name)
+	now := time.Now().Unix()
+	var previous string
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		theme := FirestoreTheme{Name: name, Author: author, CSSPath: cssPath, Hash: hash, UploadedBy: uploadedBy, CreatedAt: now, UpdatedAt: now}
+		previous = ""
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var existing FirestoreTheme
+			if err := doc.DataTo(&existing); err != nil {
+				return err
+			}
+			theme.CreatedAt = existing.CreatedAt
+			if existing.CSSPath != cssPath {
+				previous = existing.CSSPath
+			}
+		}
+		return tx.Set(ref, theme)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store theme: %v", err)
+	}
+	if previous != "" {
+		if err := s.blobs.Delete(ctx, previous); err != nil && !errors.Is(err, queue.ErrNotFound) {
+			log.Printf("Warning: Failed to delete the previous stylesheet of theme %s: %v", name, err)
+		}
+	}
+
+	s.mu.Lock()
+	if s.names != nil {
+		s.names[name] = true
+	}
+	s.mu.Unlock()
+	return &Theme{Name: name, Author: author, Contributed: true, UpdatedAt: now}, nil
+}
+
+// Delete removes a contributed theme and its stylesheet. Decks already
+// rendered with it keep their look, refinements render them with the default
+// theme.
+func (s *Service) Delete(ctx context.Context, name string) error {
+	ref := s.Collection().Doc(name)
+	doc, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ErrThemeNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get theme: %v", err)
+	}
+	var theme FirestoreTheme
+	if err := doc.DataTo(&theme); err != nil {
+		return fmt.Errorf("failed to parse theme: %v", err)
+	}
+	if _, err := ref.Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete theme: %v", err)
+	}
+	if err := s.blobs.Delete(ctx, theme.CSSPath); err != nil && !errors.Is(err, queue.ErrNotFound) {
+		log.Printf("Warning: Failed to delete the stylesheet of theme %s: %v", name, err)
+	}
+
+	s.mu.Lock()
+	delete(s.names, name)
+	s.mu.Unlock()
+	return nil
+}
+
+// Contributed reports whether a theme is in the registry. The names are
+// cached for a minute, and the last names read are used when Firestore can't
+// be read, so request validation doesn't wait on Firestore for every request.
+func (s *Service) Contributed(name string) bool {
+	if !namePattern.MatchString(name) {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.names == nil || time.Since(s.fetchedAt) >= namesTTL {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		refs, err := s.Collection().DocumentRefs(ctx).GetAll()
+		if err != nil {
+			log.Printf("Warning: Failed to read the contributed themes, using the last ones read: %v", err)
+		} else {
+			s.names = make(map[string]bool, len(refs))
+			for _, ref := range refs {
+				s.names[ref.ID] = true
+			}
+			s.fetchedAt = time.Now()
+		}
+	}
+	return s.names[name]
+}
@@ -0,0 +1,35 @@
+package themes
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateCSS(t *testing.T) {
+	tests := []struct {
+		name  string
+		css   string
+		valid bool
+	}{
+		{"solarized", "/* @theme solarized */\nsection { background: #fdf6e3; }", true},
+		{"night_owl", "/*!\n * @theme night_owl\n * @author Ada\n */\n@import 'default';", true},
+		{"solarized", "section { background: #fdf6e3; }", false},   // No @theme comment
+		{"solarized", "/* @theme dracula */\nsection {}", false},   // Names another theme
+		{"beam", "/* @theme beam */\nsection {}", false},           // Built-in theme
+		{"Solarized", "/* @theme Solarized */\nsection {}", false}, // Uppercase name
+		{"../evil", "/* @theme ../evil */\nsection {}", false},     // Not a name
+		{"solarized", "/* @theme solarized */\n\xff\xfe", false},   // Not UTF-8
+		{"solarized", "/* @theme solarized */" + strings.Repeat(" ", MaxCSSBytes), false},
+	}
+
+	for _, test := range tests {
+		err := ValidateCSS(test.name, []byte(test.css))
+		if (err == nil) != test.valid {
+			t.Errorf("%s %q: expected valid=%t, got %v", test.name, test.css[:min(len(test.css), 40)], test.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidTheme) {
+			t.Errorf("%s: expected ErrInvalidTheme, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package workspaces
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Statuses of the documents of a workspace library
+const (
+	// DocumentIndexing is the status of a document until the slides service
+	// has split and embedded it. Decks can already be generated from it.
+	DocumentIndexing = "indexing"
+	// DocumentReady is the status of a document whose index decks reuse
+	DocumentReady = "ready"
+	// DocumentFailed is the status of a document that couldn't be read
+	DocumentFailed = "failed"
+)
+
+const (
+	// MaxDocumentBytes is the largest document accepted in a library, the
+	// plan of the API key that generates from it may allow less
+	MaxDocumentBytes = 10 << 20
+
+	// MaxDocuments is how many documents a workspace library can hold
+	MaxDocuments = 100
+
+	// LibraryPrefix is where library documents and their indexes are stored
+	// in Cloud Storage
+	LibraryPrefix = "library/"
+)
+
+var (
+	// ErrDocumentNotFound is returned when a document isn't in the library
+	ErrDocumentNotFound = errors.New("document not found")
+
+	// ErrInvalidDocument is wrapped by the errors of documents that can't be
+	// added to the library or generated from
+	ErrInvalidDocument = errors.New("invalid document")
+)
+
+// Document is a document uploaded once to the library of a workspace, which
+// its members generate decks from by ID without uploading it again
+type Document struct {
+	ID         string `json:"id" firestore:"-"` // SHA-256 of the content
+	Filename   string `json:"filename" firestore:"filename"`
+	Type       string `json:"type" firestore:"type"`
+	Size       int    `json:"size" firestore:"size"`
+	Path       string `json:"-" firestore:"path"`
+	IndexPath  string `json:"-" firestore:"indexPath"`
+	Status     string `json:"status" firestore:"status"`
+	Passages   int    `json:"passages,omitempty" firestore:"passages,omitempty"` // Passages embedded by the slides service
+	Error      string `json:"error,omitempty" firestore:"error,omitempty"`       // Why the document couldn't be indexed
+	UploadedBy string `json:"uploadedBy,omitempty" firestore:"uploadedBy,omitempty"`
+	CreatedAt  int64  `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt  int64  `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// Reference returns the file reference a job generates from, which carries
+// the index once the document is ready
+func (d *Document) Reference() queue.FileReference {
+	ref := queue.FileReference{
+		Filename: d.Filename,
+		Type:     d.Type,
+		GCSPath:  d.Path,
+		Hash:     d.ID,
+	}
+	if d.Status == DocumentReady {
+		ref.IndexPath = d.IndexPath
+	}
+	return ref
+}
+
+// documents returns the collection of the library of a workspace
+func (s *Service) documents(id string) *firestore.CollectionRef {
+	return s.Collection().Doc(id).Collection("documents")
+}
+
+// AddDocument stores a document in the library of a workspace and schedules
+// its indexing. A document already in the library is returned as it is,
+// unless it failed to index, which is retried.
+func (s *Service) AddDocument(ctx context.Context, id, uploadedBy, filename, fileType string, data []byte) (*Document, error) {
+	if len(data) > MaxDocumentBytes {
+		return nil, fmt.Errorf("%w: the file must be at most %d MB", ErrInvalidDocument, MaxDocumentBytes>>20)
+	}
+	sum := sha256.Sum256(data)
+	documentID := hex.EncodeToString(sum[:])
+	if existing, err := s.Document(ctx, id, documentID); err == nil && existing.Status != DocumentFailed {
+		return existing, nil
+	} else if err != nil && !errors.Is(err, ErrDocumentNotFound) {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	document := Document{
+		ID:         documentID,
+		Filename:   filename,
+		Type:       fileType,
+		Size:       len(data),
+		Path:       LibraryPrefix + id + "/" + documentID,
+		Status:     DocumentIndexing,
+		UploadedBy: uploadedBy,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	document.IndexPath = document.Path + ".index.json"
+	workspace, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.regions.Blobs(workspace.Region).Upload(ctx, document.Path, fileType, data); err != nil {
+		return nil, fmt.Errorf("failed to store document: %v", err)
+	}
+
+	workspaceRef := s.Collection().Doc(id)
+	ref := s.documents(id).Doc(documentID)
+	err = s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(workspaceRef)
+		if err != nil {
+			return err
+		}
+		var current FirestoreWorkspace
+		if err := doc.DataTo(&current); err != nil {
+			return err
+		}
+		if current.Region != workspace.Region {
+			return fmt.Errorf("%w: the workspace moved to another region, upload the document again", ErrInvalidDocument)
+		}
+		if _, err := tx.Get(ref); status.Code(err) == codes.NotFound {
+			docs, err := tx.Documents(s.documents(id).Limit(MaxDocuments)).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(docs) >= MaxDocuments {
+				return fmt.Errorf("%w: a workspace library can have at most %d documents", ErrInvalidDocument, MaxDocuments)
+			}
+		} else if err != nil {
+			return err
+		}
+		return tx.Set(ref, document)
+	})
+	if err != nil {
+		s.deleteDocumentFiles(ctx, workspace.Region, document)
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrWorkspaceNotFound
+		}
+		if errors.Is(err, ErrInvalidDocument) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error adding document: %v", err)
+	}
+
+	err = s.regions.DispatchIndexing(ctx, workspace.Region, queue.IndexPayload{
+		WorkspaceID: id,
+		DocumentID:  documentID,
+		File:        queue.FileReference{Filename: filename, Type: fileType, GCSPath: document.Path, Hash: documentID},
+		IndexPath:   document.IndexPath,
+	})
+	if err != nil {
+		log.Printf("Failed to queue the indexing of document %s of workspace %s: %v", documentID, id, err)
+		document.Status = DocumentFailed
+		document.Error = "Indexing couldn't be queued, upload the document again"
+		if _, updateErr := ref.Update(ctx, []firestore.Update{
+			{Path: "status", Value: document.Status},
+			{Path: "error", Value: document.Error},
+		}); updateErr != nil {
+			log.Printf("Failed to mark document %s as failed: %v", documentID, updateErr)
+		}
+	}
+	return &document, nil
+}
+
+// Document returns a document of the library of a workspace
+func (s *Service) Document(ctx context.Context, id, documentID string) (*Document, error) {
+	doc, err := s.documents(id).Doc(documentID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving document: %v", err)
+	}
+	var document Document
+	if err := doc.DataTo(&document); err != nil {
+		return nil, fmt.Errorf("error parsing document data: %v", err)
+	}
+	document.ID = doc.Ref.ID
+	return &document, nil
+}
+
+// Documents lists the library of a workspace, most recently added first
+func (s *Service) Documents(ctx context.Context, id string) ([]Document, error) {
+	iter := s.documents(id).OrderBy("createdAt", firestore.Desc).Documents(ctx)
+	defer iter.Stop()
+	documents := []Document{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing documents: %v", err)
+		}
+		var document Document
+		if err := doc.DataTo(&document); err != nil {
+			return nil, fmt.Errorf("error parsing document data: %v", err)
+		}
+		document.ID = doc.Ref.ID
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// References returns the library documents a job generates from, in the
+// order of the IDs. Documents that failed to index can't be generated from.
+func (s *Service) References(ctx context.Context, id string, documentIDs []string) ([]Document, error) {
+	documents := make([]Document, 0, len(documentIDs))
+	for _, documentID := range documentIDs {
+		document, err := s.Document(ctx, id, documentID)
+		if errors.Is(err, ErrDocumentNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, documentID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if document.Status == DocumentFailed {
+			return nil, fmt.Errorf("%w: %s couldn't be indexed: %s", ErrInvalidDocument, document.Filename, document.Error)
+		}
+		documents = append(documents, *document)
+	}
+	return documents, nil
+}
+
+// DeleteDocument removes a document from the library of a workspace. Decks
+// already generated from it are kept.
+func (s *Service) DeleteDocument(ctx context.Context, id, documentID string) error {
+	workspace, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	document, err := s.Document(ctx, id, documentID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.documents(id).Doc(documentID).Delete(ctx); err != nil {
+		return fmt.Errorf("error deleting document: %v", err)
+	}
+	s.deleteDocumentFiles(ctx, workspace.Region, *document)
+	return nil
+}
+
+// deleteDocumentFiles deletes the stored file and index of a document from the
+// blob store of the region of its workspace
+func (s *Service) deleteDocumentFiles(ctx context.Context, region string, document Document) {
+	for _, path := range []string{document.Path, document.IndexPath} {
+		if err := s.regions.Blobs(region).Delete(ctx, path); err != nil && !errors.Is(err, queue.ErrNotFound) {
+			log.Printf("Warning: Failed to delete library file %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package workspaces
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/api/models"
+	"github.com/martin226/slideitin/backend/api/services/queue"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Roles of the API keys in a workspace
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Permission is an action a workspace member can be allowed to take
+type Permission string
+
+const (
+	// PermissionView allows listing the library and reading the workspace settings
+	PermissionView Permission = "view"
+	// PermissionGenerate allows generating decks into the library
+	PermissionGenerate Permission = "generate"
+	// PermissionManage allows changing the workspace settings
+	PermissionManage Permission = "manage"
+)
+
+// rolePermissions are the permissions granted by each role
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:  {PermissionView, PermissionGenerate, PermissionManage},
+	RoleEditor: {PermissionView, PermissionGenerate},
+	RoleViewer: {PermissionView},
+}
+
+var (
+	// ErrWorkspaceNotFound is returned when a workspace doesn't exist
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+
+	// ErrFontNotFound is returned when a font isn't uploaded to the workspace
+	ErrFontNotFound = errors.New("font not found")
+
+	// ErrInvalidFont is wrapped by the errors of font files that can't be uploaded
+	ErrInvalidFont = errors.New("invalid font")
+
+	// ErrUnknownRegion is returned when a workspace is tagged with a region
+	// that isn't configured
+	ErrUnknownRegion = errors.New("unknown region")
+
+	// ErrRegionInUse is returned when a workspace whose fonts or documents are
+	// stored in its region is moved to another one
+	ErrRegionInUse = errors.New("the fonts and library documents of the workspace are stored in its region, delete them before moving it")
+)
+
+const (
+	// MaxFontBytes is the largest font file accepted
+	MaxFontBytes = 2 << 20
+
+	// MaxFonts is how many font files a workspace can upload
+	MaxFonts = 20
+
+	// FontPrefix is where workspace fonts are stored in Cloud Storage
+	FontPrefix = "fonts/"
+)
+
+// fontFormats maps the signature of each supported font format to its file
+// extension and content type
+var fontFormats = []struct {
+	signature   []byte
+	extension   string
+	contentType string
+}{
+	{[]byte("wOF2"), "woff2", "font/woff2"},
+	{[]byte("wOFF"), "woff", "font/woff"},
+	{[]byte("OTTO"), "otf", "font/otf"},
+	{[]byte{0x00, 0x01, 0x00, 0x00}, "ttf", "font/ttf"},
+	{[]byte("true"), "ttf", "font/ttf"},
+}
+
+// FirestoreWorkspace is the Firestore representation of a workspace
+type FirestoreWorkspace struct {
+	Name        string            `firestore:"name"`
+	Themes      []string          `firestore:"themes,omitempty"`
+	Footer      string            `firestore:"footer,omitempty"`
+	Watermark   string            `firestore:"watermark,omitempty"`
+	Font        string            `firestore:"font,omitempty"`
+	HeadingFont string            `firestore:"headingFont,omitempty"`
+	Fonts       []models.FontFile `firestore:"fonts,omitempty"`
+	Region      string            `firestore:"region,omitempty"` // Data residency, empty for the default region
+	CreatedAt   int64             `firestore:"createdAt"`
+	UpdatedAt   int64             `firestore:"updatedAt"`
+}
+
+// Workspace is an organization whose API keys share a deck library and branding
+type Workspace struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Themes      []string          `json:"themes,omitempty"`      // Themes members may use, empty for all
+	Footer      string            `json:"footer,omitempty"`      // Footer used when a request has none
+	Watermark   string            `json:"watermark,omitempty"`   // Watermark used when a request has none
+	Font        string            `json:"font,omitempty"`        // Text font used when a request has none
+	HeadingFont string            `json:"headingFont,omitempty"` // Heading font used when a request has none
+	Fonts       []models.FontFile `json:"fonts,omitempty"`       // Font files uploaded to the workspace
+	Region      string            `json:"region,omitempty"`      // Region the jobs, fonts and documents are stored in, empty for the default region
+	UpdatedAt   int64             `json:"updatedAt"`
+}
+
+// Settings represents the branding of a workspace set by its admins
+type Settings struct {
+	Themes      []string `json:"themes" binding:"dive,theme"`
+	Footer      string   `json:"footer" binding:"max=100"`
+	Watermark   string   `json:"watermark" binding:"max=100"`
+	Font        string   `json:"font" binding:"omitempty,fontfamily"`
+	HeadingFont string   `json:"headingFont" binding:"omitempty,fontfamily"`
+}
+
+// FontUpload describes a font file uploaded to a workspace
+type FontUpload struct {
+	Family string `json:"family" binding:"required,fontfamily"`
+	Weight int    `json:"weight" binding:"omitempty,min=100,max=900"`    // Defaults to 400
+	Style  string `json:"style" binding:"omitempty,oneof=normal italic"` // Defaults to normal
+}
+
+// Allows reports whether a role has a permission. Members without a role are editors.
+func Allows(role string, permission Permission) bool {
+	if role == "" {
+		role = RoleEditor
+	}
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply applies the branding of the workspace to a slide request
+func (w *Workspace) Apply(req *models.SlideRequest) error {
+	if len(w.Themes) > 0 {
+		allowed := false
+		for _, theme := range w.Themes {
+			if req.Theme == theme {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("Theme %s is not enabled in workspace %s. Enabled themes are: %s", req.Theme, w.Name, strings.Join(w.Themes, ", "))
+		}
+	}
+	if req.Settings.Footer == "" {
+		req.Settings.Footer = w.Footer
+	}
+	if req.Settings.Watermark == "" {
+		req.Settings.Watermark = w.Watermark
+	}
+	if req.Settings.Font == "" {
+		req.Settings.Font = w.Font
+	}
+	if req.Settings.HeadingFont == "" {
+		req.Settings.HeadingFont = w.HeadingFont
+	}
+	return nil
+}
+
+// FontFiles returns the uploaded font files of the families the settings use.
+// Families without files are loaded from Google Fonts.
+func (w *Workspace) FontFiles(settings models.SlideSettings) []models.FontFile {
+	var files []models.FontFile
+	for _, font := range w.Fonts {
+		if strings.EqualFold(font.Family, settings.Font) || strings.EqualFold(font.Family, settings.HeadingFont) {
+			files = append(files, font)
+		}
+	}
+	return files
+}
+
+// Regions stores the files of workspaces in the region they are tagged with
+type Regions interface {
+	// HasRegion reports whether a region is configured, empty for the default one
+	HasRegion(region string) bool
+	// Blobs returns the blob store of a region
+	Blobs(region string) queue.BlobStore
+	// DispatchIndexing schedules the indexing of a library document by the
+	// slides service of a region
+	DispatchIndexing(ctx context.Context, region string, payload queue.IndexPayload) error
+}
+
+// Service manages workspaces stored in Firestore
+type Service struct {
+	client  *firestore.Client
+	regions Regions // Stores the uploaded font files and library documents, and indexes the documents
+}
+
+// NewService creates a new workspace service
+func NewService(client *firestore.Client, regions Regions) *Service {
+	return &Service{
+		client:  client,
+		regions: regions,
+	}
+}
+
+// Collection returns the Firestore collection reference for workspaces
+func (s *Service) Collection() *firestore.CollectionRef {
+	return s.client.Collection("workspaces")
+}
+
+// Get returns a workspace
+func (s *Service) Get(ctx context.Context, id string) (*Workspace, error) {
+	doc, err := s.Collection().Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrWorkspaceNotFound
+		}
+		return nil, fmt.Errorf("error retrieving workspace: %v", err)
+	}
+
+	var workspace FirestoreWorkspace
+	if err := doc.DataTo(&workspace); err != nil {
+		return nil, fmt.Errorf("error parsing workspace data: %v", err)
+	}
+
+	return &Workspace{
+		ID:          id,
+		Name:        workspace.Name,
+		Themes:      workspace.Themes,
+		Footer:      workspace.Footer,
+		Watermark:   workspace.Watermark,
+		Font:        workspace.Font,
+		HeadingFont: workspace.HeadingFont,
+		Fonts:       workspace.Fonts,
+		Region:      workspace.Region,
+		UpdatedAt:   workspace.UpdatedAt,
+	}, nil
+}
+
+// Update replaces the branding of a workspace
+func (s *Service) Update(ctx context.Context, id string, settings Settings) (*Workspace, error) {
+	_, err := s.Collection().Doc(id).Update(ctx, []firestore.Update{
+		{Path: "themes", Value: settings.Themes},
+		{Path: "footer", Value: settings.Footer},
+		{Path: "watermark", Value: settings.Watermark},
+		{Path: "font", Value: settings.Font},
+		{Path: "headingFont", Value: settings.HeadingFont},
+		{Path: "updatedAt", Value: time.Now().Unix()},
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrWorkspaceNotFound
+		}
+		return nil, fmt.Errorf("error updating workspace: %v", err)
+	}
+	return s.Get(ctx, id)
+}
+
+// SetRegion tags a workspace with the region its jobs, fonts and documents
+// are stored in, empty for the default region. Jobs created before keep the
+// region they were created in. A workspace can only move while it has no
+// fonts or documents, which are stored in its region.
+func (s *Service) SetRegion(ctx context.Context, id, region string) (*Workspace, error) {
+	if !s.regions.HasRegion(region) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRegion, region)
+	}
+	ref := s.Collection().Doc(id)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var workspace FirestoreWorkspace
+		if err := doc.DataTo(&workspace); err != nil {
+			return err
+		}
+		if workspace.Region == region {
+			return nil
+		}
+		documents, err := tx.Documents(s.documents(id).Limit(1)).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(workspace.Fonts) > 0 || len(documents) > 0 {
+			return ErrRegionInUse
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "region", Value: region},
+			{Path: "updatedAt", Value: time.Now().Unix()},
+		})
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrWorkspaceNotFound
+	}
+	if errors.Is(err, ErrRegionInUse) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error setting workspace region: %v", err)
+	}
+	return s.Get(ctx, id)
+}
+
+// AddFont stores a font file and adds it to the workspace, replacing the file
+// uploaded before for the same family, weight and style
+func (s *Service) AddFont(ctx context.Context, id string, upload FontUpload, data []byte) (*models.FontFile, error) {
+	if len(data) > MaxFontBytes {
+		return nil, fmt.Errorf("%w: the file must be at most %d MB", ErrInvalidFont, MaxFontBytes>>20)
+	}
+	extension, contentType := "", ""
+	for _, format := range fontFormats {
+		if bytes.HasPrefix(data, format.signature) {
+			extension, contentType = format.extension, format.contentType
+			break
+		}
+	}
+	if extension == "" {
+		return nil, fmt.Errorf("%w: only TTF, OTF, WOFF and WOFF2 files are supported", ErrInvalidFont)
+	}
+	if upload.Weight == 0 {
+		upload.Weight = 400
+	}
+	if upload.Style == "" {
+		upload.Style = "normal"
+	}
+
+	// Files are stored by content, so the slides service caches each under its path
+	sum := sha256.Sum256(data)
+	font := models.FontFile{
+		ID:     hex.EncodeToString(sum[:]),
+		Family: upload.Family,
+		Weight: upload.Weight,
+		Style:  upload.Style,
+	}
+	font.Path = FontPrefix + id + "/" + font.ID + "." + extension
+	workspace, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.regions.Blobs(workspace.Region).Upload(ctx, font.Path, contentType, data); err != nil {
+		return nil, fmt.Errorf("failed to store font: %v", err)
+	}
+
+	var replaced []string
+	err = s.updateFonts(ctx, id, func(region string, fonts []models.FontFile) ([]models.FontFile, error) {
+		replaced = nil
+		if region != workspace.Region {
+			return nil, fmt.Errorf("%w: the workspace moved to another region, upload the font again", ErrInvalidFont)
+		}
+		kept := make([]models.FontFile, 0, len(fonts)+1)
+		for _, existing := range fonts {
+			if existing.ID == font.ID || (strings.EqualFold(existing.Family, font.Family) && existing.Weight == font.Weight && existing.Style == font.Style) {
+				if existing.Path != font.Path {
+					replaced = append(replaced, existing.Path)
+				}
+				continue
+			}
+			kept = append(kept, existing)
+		}
+		if len(kept) >= MaxFonts {
+			return nil, fmt.Errorf("%w: a workspace can have at most %d fonts", ErrInvalidFont, MaxFonts)
+		}
+		return append(kept, font), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.deleteFontFiles(ctx, workspace.Region, replaced)
+	return &font, nil
+}
+
+// DeleteFont removes a font file from the workspace. Decks already generated
+// keep their look, refinements render them with the theme's font.
+func (s *Service) DeleteFont(ctx context.Context, id, fontID string) error {
+	var removed []string
+	var region string
+	err := s.updateFonts(ctx, id, func(workspaceRegion string, fonts []models.FontFile) ([]models.FontFile, error) {
+		removed, region = nil, workspaceRegion
+		kept := make([]models.FontFile, 0, len(fonts))
+		for _, font := range fonts {
+			if font.ID == fontID {
+				removed = append(removed, font.Path)
+				continue
+			}
+			kept = append(kept, font)
+		}
+		if len(removed) == 0 {
+			return nil, ErrFontNotFound
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.deleteFontFiles(ctx, region, removed)
+	return nil
+}
+
+// updateFonts replaces the fonts of a workspace with the ones returned by
+// update in a transaction, which is given the region of the workspace
+func (s *Service) updateFonts(ctx context.Context, id string, update func(region string, fonts []models.FontFile) ([]models.FontFile, error)) error {
+	ref := s.Collection().Doc(id)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var workspace FirestoreWorkspace
+		if err := doc.DataTo(&workspace); err != nil {
+			return err
+		}
+		fonts, err := update(workspace.Region, workspace.Fonts)
+		if err != nil {
+			return err
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "fonts", Value: fonts},
+			{Path: "updatedAt", Value: time.Now().Unix()},
+		})
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrWorkspaceNotFound
+	}
+	if errors.Is(err, ErrFontNotFound) || errors.Is(err, ErrInvalidFont) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error updating workspace fonts: %v", err)
+	}
+	return nil
+}
+
+// deleteFontFiles deletes the stored font files the workspace no longer uses
+// from the blob store of its region
+func (s *Service) deleteFontFiles(ctx context.Context, region string, paths []string) {
+	for _, path := range paths {
+		if err := s.regions.Blobs(region).Delete(ctx, path); err != nil && !errors.Is(err, queue.ErrNotFound) {
+			log.Printf("Warning: Failed to delete font file %s: %v", path, err)
+		}
+	}
+}
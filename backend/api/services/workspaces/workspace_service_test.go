@@ -0,0 +1,84 @@
+package workspaces
+
+import (
+	"testing"
+
+	"github.com/martin226/slideitin/backend/api/models"
+)
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission Permission
+		allowed    bool
+	}{
+		{RoleAdmin, PermissionManage, true},
+		{RoleEditor, PermissionGenerate, true},
+		{RoleEditor, PermissionManage, false},
+		{RoleViewer, PermissionView, true},
+		{RoleViewer, PermissionGenerate, false},
+		{"", PermissionGenerate, true},
+		{"owner", PermissionView, false},
+	}
+	for _, test := range tests {
+		if allowed := Allows(test.role, test.permission); allowed != test.allowed {
+			t.Errorf("Allows(%q, %s) = %v, want %v", test.role, test.permission, allowed, test.allowed)
+		}
+	}
+}
+
+func TestApplyEnforcesThemesAndBranding(t *testing.T) {
+	workspace := &Workspace{Name: "Acme", Themes: []string{"beam", "gaia"}, Footer: "Acme Corp", Watermark: "Internal"}
+
+	req := &models.SlideRequest{Theme: "beam", Settings: models.SlideSettings{Watermark: "Draft"}}
+	if err := workspace.Apply(req); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if req.Settings.Footer != "Acme Corp" || req.Settings.Watermark != "Draft" {
+		t.Fatalf("expected the workspace footer and the request watermark, got %+v", req.Settings)
+	}
+
+	if err := workspace.Apply(&models.SlideRequest{Theme: "uncover"}); err == nil {
+		t.Fatal("expected a theme outside the workspace themes to be rejected")
+	}
+}
+
+func TestApplyFillsFontsAndSelectsFontFiles(t *testing.T) {
+	workspace := &Workspace{
+		Font: "Acme Sans",
+		Fonts: []models.FontFile{
+			{ID: "a", Family: "Acme Sans", Weight: 400, Style: "normal"},
+			{ID: "b", Family: "Acme Sans", Weight: 700, Style: "normal"},
+			{ID: "c", Family: "Acme Serif", Weight: 400, Style: "normal"},
+		},
+	}
+
+	req := &models.SlideRequest{Theme: "beam", Settings: models.SlideSettings{HeadingFont: "Inter"}}
+	if err := workspace.Apply(req); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if req.Settings.Font != "Acme Sans" || req.Settings.HeadingFont != "Inter" {
+		t.Fatalf("expected the workspace font and the request heading font, got %+v", req.Settings)
+	}
+
+	files := workspace.FontFiles(req.Settings)
+	if len(files) != 2 || files[0].ID != "a" || files[1].ID != "b" {
+		t.Fatalf("expected the files of Acme Sans, got %+v", files)
+	}
+	if files := workspace.FontFiles(models.SlideSettings{Font: "acme serif"}); len(files) != 1 || files[0].ID != "c" {
+		t.Fatalf("expected families to match regardless of case, got %+v", files)
+	}
+}
+
+func TestDocumentReferenceCarriesIndexOnceReady(t *testing.T) {
+	document := &Document{ID: "abc", Filename: "handbook.pdf", Type: "application/pdf", Path: "library/ws-1/abc", IndexPath: "library/ws-1/abc.index.json", Status: DocumentIndexing}
+
+	ref := document.Reference()
+	if ref.GCSPath != "library/ws-1/abc" || ref.Hash != "abc" || ref.IndexPath != "" {
+		t.Fatalf("expected a document being indexed to be referenced without its index, got %+v", ref)
+	}
+	document.Status = DocumentReady
+	if ref := document.Reference(); ref.IndexPath != "library/ws-1/abc.index.json" {
+		t.Fatalf("expected a ready document to be referenced with its index, got %+v", ref)
+	}
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// bigQueryProjectPattern matches a Google Cloud project ID, possibly
+	// scoped by a domain
+	bigQueryProjectPattern = regexp.MustCompile(`^[a-z0-9.:-]+$`)
+
+	// bigQueryNamePattern matches the name of a BigQuery dataset or table
+	bigQueryNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+	// firestoreDatabasePattern matches the ID of a named Firestore database
+	firestoreDatabasePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{2,61}[a-z0-9]$`)
+)
+
+// Config holds the slides service configuration read from the environment
+type Config struct {
+	GeminiAPIKey                string // GEMINI_API_KEY
+	ProjectID                   string // GOOGLE_CLOUD_PROJECT
+	BucketName                  string // GCS_BUCKET_NAME
+	ThemesBucketName            string // THEMES_BUCKET_NAME, bucket the API stores contributed themes in, GCS_BUCKET_NAME by default
+	FirestoreDatabaseID         string // FIRESTORE_DATABASE_ID, database the jobs of the region of the service are kept in
+	SettingsFirestoreDatabaseID string // SETTINGS_FIRESTORE_DATABASE_ID, database of the API's workspaces and themes, FIRESTORE_DATABASE_ID by default
+	Port                        string // PORT
+	SendGridAPIKey              string // SENDGRID_API_KEY, empty to disable email notifications
+	NotifyFromEmail             string // NOTIFY_FROM_EMAIL
+	PublicAPIURL                string // PUBLIC_API_URL, used in links to results
+	GoogleOAuthClientID         string // GOOGLE_OAUTH_CLIENT_ID, OAuth client Drive is connected with, empty to disable Drive input
+	GoogleOAuthClientSecret     string // GOOGLE_OAUTH_CLIENT_SECRET, required with GOOGLE_OAUTH_CLIENT_ID
+	ConfluenceURL               string // CONFLUENCE_URL, Confluence Cloud site such as https://acme.atlassian.net, empty to disable Confluence import
+	ConfluenceEmail             string // CONFLUENCE_EMAIL, account the pages are read as
+	ConfluenceAPIToken          string // CONFLUENCE_API_TOKEN, API token of that account
+	SharePointTenantID          string // SHAREPOINT_TENANT_ID, Entra ID tenant, empty to disable SharePoint import
+	SharePointClientID          string // SHAREPOINT_CLIENT_ID, app granted Sites.Read.All
+	SharePointClientSecret      string // SHAREPOINT_CLIENT_SECRET
+	GitHubToken                 string // GITHUB_TOKEN, optional, for private repositories and higher rate limits
+	ResultKMSKey                string // RESULT_KMS_KEY, Cloud KMS key results are encrypted with before they are stored, empty to store them unencrypted
+	TaskSigningSecret           string // TASK_SIGNING_SECRET, shared with the API to check task signatures, empty to rely on OIDC alone
+	MarpPath                    string // MARP_PATH, Marp CLI executable, empty for marp on the PATH or to render natively without it
+	SlidevDir                   string // SLIDEV_DIR, npm project with the Slidev packages, empty for the global packages
+	ChromiumPath                string // CHROMIUM_PATH, browser the Marp CLI and Slidev render with, empty for the one they find
+	SandboxPath                 string // SANDBOX_PATH, bubblewrap executable the render tools run in, empty to run them without a sandbox
+	SandboxUID                  int    // SANDBOX_UID, user and group the sandboxed tools run as, 0 to keep the service's
+	MaxConcurrentRenders        int    // MAX_CONCURRENT_RENDERS, Marp renders run at once, 0 for no limit
+	MaxConcurrentGenerations    int    // MAX_CONCURRENT_GENERATIONS, Gemini generations run at once, 0 for no limit
+	MaxInputTokens              int    // MAX_INPUT_TOKENS, most tokens sent to Gemini for a deck, plans can override it from the API
+	MaxOutputTokens             int    // MAX_OUTPUT_TOKENS, most tokens Gemini writes for a deck, plans can override it from the API
+	BigQueryJobsTable           string // BIGQUERY_JOBS_TABLE, table such as analytics.jobs or project.analytics.jobs the records of finished jobs are streamed to, empty to disable the export
+}
+
+// Load reads the configuration from the environment and validates it. The
+// error lists every missing or invalid variable so they can be fixed at once.
+func Load() (*Config, error) {
+	l := &loader{}
+
+	cfg := &Config{
+		GeminiAPIKey:             l.required("GEMINI_API_KEY"),
+		ProjectID:                l.required("GOOGLE_CLOUD_PROJECT"),
+		BucketName:               l.optional("GCS_BUCKET_NAME", "slideitin-files"),
+		Port:                     l.port(l.optional("PORT", "8080")),
+		SendGridAPIKey:           os.Getenv("SENDGRID_API_KEY"),
+		NotifyFromEmail:          l.email(l.optional("NOTIFY_FROM_EMAIL", "no-reply@justslideitin.com"), "NOTIFY_FROM_EMAIL"),
+		PublicAPIURL:             strings.TrimSuffix(l.url(l.optional("PUBLIC_API_URL", "http://localhost:8080"), "PUBLIC_API_URL"), "/"),
+		MaxConcurrentRenders:     l.count(l.optional("MAX_CONCURRENT_RENDERS", "1"), "MAX_CONCURRENT_RENDERS"),
+		MaxConcurrentGenerations: l.count(l.optional("MAX_CONCURRENT_GENERATIONS", "4"), "MAX_CONCURRENT_GENERATIONS"),
+		MaxInputTokens:           l.tokens(l.optional("MAX_INPUT_TOKENS", "16384"), "MAX_INPUT_TOKENS"),
+		MaxOutputTokens:          l.tokens(l.optional("MAX_OUTPUT_TOKENS", "4096"), "MAX_OUTPUT_TOKENS"),
+	}
+
+	if cfg.SendGridAPIKey == "" {
+		log.Println("Warning: SENDGRID_API_KEY not set, email notifications are disabled")
+	}
+
+	// Drive input refreshes the tokens of the OAuth client the API connected Drive with
+	cfg.GoogleOAuthClientID = strings.TrimSpace(os.Getenv("GOOGLE_OAUTH_CLIENT_ID"))
+	if cfg.GoogleOAuthClientID != "" {
+		cfg.GoogleOAuthClientSecret = l.required("GOOGLE_OAUTH_CLIENT_SECRET")
+	}
+
+	// Content sources are optional, but need all their credentials once enabled
+	cfg.ConfluenceURL = strings.TrimSuffix(l.url(strings.TrimSpace(os.Getenv("CONFLUENCE_URL")), "CONFLUENCE_URL"), "/")
+	if cfg.ConfluenceURL != "" {
+		cfg.ConfluenceEmail = l.required("CONFLUENCE_EMAIL")
+		cfg.ConfluenceAPIToken = l.required("CONFLUENCE_API_TOKEN")
+	}
+	cfg.SharePointTenantID = strings.TrimSpace(os.Getenv("SHAREPOINT_TENANT_ID"))
+	if cfg.SharePointTenantID != "" {
+		cfg.SharePointClientID = l.required("SHAREPOINT_CLIENT_ID")
+		cfg.SharePointClientSecret = l.required("SHAREPOINT_CLIENT_SECRET")
+	}
+	cfg.GitHubToken = strings.TrimSpace(os.Getenv("GITHUB_TOKEN"))
+
+	// Services deployed for another region keep its jobs in its own database and
+	// bucket, but read the themes and workspaces of the API's default region
+	cfg.FirestoreDatabaseID = l.firestoreDatabase(l.optional("FIRESTORE_DATABASE_ID", "(default)"), "FIRESTORE_DATABASE_ID")
+	cfg.SettingsFirestoreDatabaseID = cfg.FirestoreDatabaseID
+	if value := strings.TrimSpace(os.Getenv("SETTINGS_FIRESTORE_DATABASE_ID")); value != "" {
+		cfg.SettingsFirestoreDatabaseID = l.firestoreDatabase(value, "SETTINGS_FIRESTORE_DATABASE_ID")
+	}
+	cfg.ThemesBucketName = cfg.BucketName
+	if value := strings.TrimSpace(os.Getenv("THEMES_BUCKET_NAME")); value != "" {
+		cfg.ThemesBucketName = value
+	}
+
+	// Results are encrypted at the application layer for deployments that need customer-managed keys
+	cfg.ResultKMSKey = l.kmsKey(strings.TrimSpace(os.Getenv("RESULT_KMS_KEY")), "RESULT_KMS_KEY")
+
+	// Self-hosted deployments without Cloud Run's OIDC check authenticate tasks with a shared secret
+	cfg.TaskSigningSecret = os.Getenv("TASK_SIGNING_SECRET")
+
+	// The renderers run the CLIs installed in the image
+	cfg.MarpPath = strings.TrimSpace(os.Getenv("MARP_PATH"))
+	cfg.SlidevDir = strings.TrimSpace(os.Getenv("SLIDEV_DIR"))
+	cfg.ChromiumPath = strings.TrimSpace(os.Getenv("CHROMIUM_PATH"))
+
+	// The tools render HTML the model wrote, so they can run in a sandbox as a user of their own
+	cfg.SandboxPath = strings.TrimSpace(os.Getenv("SANDBOX_PATH"))
+	if cfg.SandboxPath != "" {
+		cfg.SandboxUID = l.count(l.optional("SANDBOX_UID", "0"), "SANDBOX_UID")
+	}
+
+	// The hosted instance exports its jobs for analytics, in the project of the service unless the table names another
+	cfg.BigQueryJobsTable = l.bigQueryTable(strings.TrimSpace(os.Getenv("BIGQUERY_JOBS_TABLE")), "BIGQUERY_JOBS_TABLE", cfg.ProjectID)
+
+	if err := l.err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loader reads environment variables and collects the problems it finds
+type loader struct {
+	missing []string
+	invalid []string
+}
+
+// required returns the value of a variable that must be set
+func (l *loader) required(key string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		l.missing = append(l.missing, key)
+	}
+	return value
+}
+
+// optional returns the value of a variable, or the default if it isn't set
+func (l *loader) optional(key, defaultValue string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		log.Printf("Warning: %s not set, using default: %s", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// url checks that a non-empty value is an absolute http or https URL
+func (l *loader) url(value, key string) string {
+	if value == "" {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be an http or https URL, got %q", key, value))
+	}
+	return value
+}
+
+// email checks that a value is a valid email address
+func (l *loader) email(value, key string) string {
+	if _, err := mail.ParseAddress(value); err != nil {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be an email address, got %q", key, value))
+	}
+	return value
+}
+
+// kmsKey checks that a non-empty value is the resource name of a Cloud KMS key
+func (l *loader) kmsKey(value, key string) string {
+	if value == "" {
+		return value
+	}
+	parts := strings.Split(value, "/")
+	if len(parts) != 8 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "keyRings" || parts[6] != "cryptoKeys" {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be a key name like projects/p/locations/l/keyRings/r/cryptoKeys/k, got %q", key, value))
+	}
+	return value
+}
+
+// firestoreDatabase checks that a value is (default) or the ID of a named
+// Firestore database
+func (l *loader) firestoreDatabase(value, key string) string {
+	if value != "(default)" && !firestoreDatabasePattern.MatchString(value) {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be (default) or a database ID, got %q", key, value))
+	}
+	return value
+}
+
+// bigQueryTable checks that a non-empty value names a BigQuery table as
+// dataset.table or project.dataset.table, and returns it with the project
+func (l *loader) bigQueryTable(value, key, projectID string) string {
+	if value == "" {
+		return value
+	}
+	parts := strings.Split(value, ".")
+	if len(parts) == 2 {
+		parts = append([]string{projectID}, parts...)
+	}
+	if len(parts) != 3 || !bigQueryProjectPattern.MatchString(parts[0]) || !bigQueryNamePattern.MatchString(parts[1]) || !bigQueryNamePattern.MatchString(parts[2]) {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be a table like dataset.table or project.dataset.table, got %q", key, value))
+		return value
+	}
+	return strings.Join(parts, ".")
+}
+
+// port checks that a value is a valid port number
+func (l *loader) port(value string) string {
+	if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+		l.invalid = append(l.invalid, fmt.Sprintf("PORT must be a port number, got %q", value))
+	}
+	return value
+}
+
+// count checks that a value is a non-negative integer
+func (l *loader) count(value, key string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be a non-negative integer, got %q", key, value))
+	}
+	return n
+}
+
+// tokens checks that a value is a positive number of tokens
+func (l *loader) tokens(value, key string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s must be a positive number of tokens, got %q", key, value))
+	}
+	return n
+}
+
+// err returns an error listing every missing and invalid variable
+func (l *loader) err() error {
+	problems := make([]string, 0, len(l.invalid)+1)
+	if len(l.missing) > 0 {
+		problems = append(problems, "missing required environment variables: "+strings.Join(l.missing, ", "))
+	}
+	problems = append(problems, l.invalid...)
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadListsAllMissingVariables(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected an error for missing variables")
+	}
+	if !strings.Contains(err.Error(), "GEMINI_API_KEY, GOOGLE_CLOUD_PROJECT") {
+		t.Fatalf("expected both missing variables in the error, got %v", err)
+	}
+}
+
+func TestLoadAppliesDefaults(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "key")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("GCS_BUCKET_NAME", "")
+	t.Setenv("PORT", "")
+	t.Setenv("NOTIFY_FROM_EMAIL", "")
+	t.Setenv("PUBLIC_API_URL", "https://api.example.com/")
+	t.Setenv("MAX_CONCURRENT_RENDERS", "")
+	t.Setenv("MAX_CONCURRENT_GENERATIONS", "")
+	t.Setenv("MAX_INPUT_TOKENS", "")
+	t.Setenv("MAX_OUTPUT_TOKENS", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.BucketName != "slideitin-files" || cfg.Port != "8080" || cfg.NotifyFromEmail != "no-reply@justslideitin.com" {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+	if cfg.MaxConcurrentRenders != 1 || cfg.MaxConcurrentGenerations != 4 {
+		t.Fatalf("unexpected concurrency defaults: %+v", cfg)
+	}
+	if cfg.MaxInputTokens != 16384 || cfg.MaxOutputTokens != 4096 {
+		t.Fatalf("unexpected token defaults: %+v", cfg)
+	}
+	if cfg.PublicAPIURL != "https://api.example.com" {
+		t.Fatalf("expected the trailing slash to be trimmed, got %q", cfg.PublicAPIURL)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "key")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("PORT", "eighty")
+	t.Setenv("PUBLIC_API_URL", "api.example.com")
+	t.Setenv("RESULT_KMS_KEY", "projects/slideitin/keyRings/results")
+	t.Setenv("MAX_CONCURRENT_RENDERS", "-1")
+	t.Setenv("MAX_INPUT_TOKENS", "0")
+	t.Setenv("BIGQUERY_JOBS_TABLE", "jobs")
+	t.Setenv("SETTINGS_FIRESTORE_DATABASE_ID", "Settings_DB")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected an error for invalid values")
+	}
+	for _, key := range []string{"PORT", "PUBLIC_API_URL", "RESULT_KMS_KEY", "MAX_CONCURRENT_RENDERS", "MAX_INPUT_TOKENS", "BIGQUERY_JOBS_TABLE", "SETTINGS_FIRESTORE_DATABASE_ID"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("expected %s in the error, got %v", key, err)
+		}
+	}
+}
+
+func TestLoadQualifiesBigQueryTable(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "key")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("BIGQUERY_JOBS_TABLE", "analytics.jobs")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.BigQueryJobsTable != "slideitin.analytics.jobs" {
+		t.Fatalf("expected the table in the project of the service, got %q", cfg.BigQueryJobsTable)
+	}
+}
+
+func TestLoadDefaultsSettingsToTheJobDatabase(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "key")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "slideitin")
+	t.Setenv("GCS_BUCKET_NAME", "slideitin-files-eu")
+	t.Setenv("FIRESTORE_DATABASE_ID", "slideitin-eu")
+	t.Setenv("SETTINGS_FIRESTORE_DATABASE_ID", "")
+	t.Setenv("THEMES_BUCKET_NAME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.SettingsFirestoreDatabaseID != "slideitin-eu" || cfg.ThemesBucketName != "slideitin-files-eu" {
+		t.Fatalf("expected the settings to default to the job database and bucket, got %+v", cfg)
+	}
+}
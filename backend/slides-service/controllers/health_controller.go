@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// warmTimeout bounds the startup checks, the first render loads Chromium
+const warmTimeout = 2 * time.Minute
+
+// Warmer is a dependency checked before the service takes tasks
+type Warmer interface {
+	Warm(ctx context.Context) error
+}
+
+// HealthController reports whether the service is alive and whether it is
+// ready to take tasks
+type HealthController struct {
+	mu      sync.RWMutex
+	checked bool  // The startup checks have finished
+	err     error // Why the startup checks failed
+}
+
+// NewHealthController creates a health controller that is ready once the
+// warmers pass, which are run in the background
+func NewHealthController(warmers ...Warmer) *HealthController {
+	c := &HealthController{}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), warmTimeout)
+		defer cancel()
+		var err error
+		for _, warmer := range warmers {
+			if err = warmer.Warm(ctx); err != nil {
+				log.Printf("Startup check failed, the service won't report ready: %v", err)
+				break
+			}
+		}
+		c.mu.Lock()
+		c.checked, c.err = true, err
+		c.mu.Unlock()
+		if err == nil {
+			log.Printf("Startup checks passed")
+		}
+	}()
+	return c
+}
+
+// Health reports that the service is running
+func (c *HealthController) Health(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Ready reports whether the startup checks passed, for the startup and
+// readiness probes
+func (c *HealthController) Ready(ctx *gin.Context) {
+	c.mu.RLock()
+	checked, err := c.checked, c.err
+	c.mu.RUnlock()
+
+	switch {
+	case !checked:
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+	case err != nil:
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": err.Error()})
+	default:
+		ctx.JSON(http.StatusOK, gin.H{"status": "ready"})
+	}
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// warmerFunc adapts a function to the Warmer interface
+type warmerFunc func(ctx context.Context) error
+
+func (f warmerFunc) Warm(ctx context.Context) error { return f(ctx) }
+
+func TestHealthControllerReady(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ready := func(c *HealthController) (int, string) {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		c.Ready(ctx)
+		var body struct {
+			Status string `json:"status"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		return recorder.Code, body.Status
+	}
+	waitFor := func(c *HealthController, code int, status string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			gotCode, gotStatus := ready(c)
+			if gotCode == code && gotStatus == status {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d %s, got %d %s", code, status, gotCode, gotStatus)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	release := make(chan struct{})
+	warming := NewHealthController(warmerFunc(func(ctx context.Context) error {
+		<-release
+		return nil
+	}))
+	if code, status := ready(warming); code != http.StatusServiceUnavailable || status != "starting" {
+		t.Fatalf("expected the service not to be ready while warming, got %d %s", code, status)
+	}
+	close(release)
+	waitFor(warming, http.StatusOK, "ready")
+
+	var warmed atomic.Bool
+	failing := NewHealthController(
+		warmerFunc(func(ctx context.Context) error { return errors.New("Marp CLI not found") }),
+		warmerFunc(func(ctx context.Context) error { warmed.Store(true); return nil }),
+	)
+	waitFor(failing, http.StatusServiceUnavailable, "unavailable")
+	if warmed.Load() {
+		t.Error("expected the checks after a failed one to be skipped")
+	}
+
+	waitFor(NewHealthController(), http.StatusOK, "ready")
+}
@@ -2,126 +2,212 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"cloud.google.com/go/firestore"
-	"cloud.google.com/go/storage"
-	"github.com/martin226/slideitin/backend/slides-service/services/slides"
 	"github.com/martin226/slideitin/backend/slides-service/models"
-	"os"
+	"github.com/martin226/slideitin/backend/slides-service/services/analytics"
+	"github.com/martin226/slideitin/backend/slides-service/services/jobs"
+	"github.com/martin226/slideitin/backend/slides-service/services/notifications"
+	"github.com/martin226/slideitin/backend/slides-service/services/slides"
+)
+
+const (
+	// downloadTimeout bounds downloading the uploaded files from GCS
+	downloadTimeout = 2 * time.Minute
+
+	// captureTTL is how long the capture of a debugged job is kept
+	captureTTL = 24 * time.Hour
+
+	// exportTimeout bounds exporting the record of a finished job
+	exportTimeout = 10 * time.Second
+
+	// resultRetention is how long a result is kept after it was generated,
+	// unless a share link extends it
+	resultRetention = time.Hour
+
+	// ephemeralRetention is how long the result of an ephemeral job is kept
+	ephemeralRetention = 5 * time.Minute
 )
 
 // FileReference represents a reference to a file stored in GCS
 type FileReference struct {
-	Filename string `json:"filename"`
-	Type     string `json:"type"`
-	GCSPath  string `json:"gcsPath"`
+	Filename  string `json:"filename"`
+	Type      string `json:"type"`
+	GCSPath   string `json:"gcsPath"`
+	Hash      string `json:"hash,omitempty"`      // SHA-256 of the content, set for files shared by the jobs that upload the same content
+	IndexPath string `json:"indexPath,omitempty"` // Passages embedded when the file was added to a workspace library
 }
 
 // TaskPayload represents the data structure received from Cloud Tasks
 type TaskPayload struct {
-	JobID     string            `json:"jobID"`
-	Theme     string            `json:"theme"`
-	Files     []FileReference   `json:"files"`
-	Settings  models.SlideSettings `json:"settings"`
+	JobID          string                  `json:"jobID"`
+	Theme          string                  `json:"theme"`
+	Files          []FileReference         `json:"files"`
+	Settings       models.SlideSettings    `json:"settings"`
+	NotifyEmail    string                  `json:"notifyEmail,omitempty"`
+	Webhooks       []notifications.Webhook `json:"webhooks,omitempty"`
+	Drive          *DriveFiles             `json:"drive,omitempty"`
+	Sources        []SourceReference       `json:"sources,omitempty"`
+	MaxSourceBytes int                     `json:"maxSourceBytes,omitempty"` // Largest document allowed from a content source
+	Prompt         string                  `json:"prompt,omitempty"`         // Topic or outline the deck is written from when there are no files
+	Owner          string                  `json:"owner,omitempty"`          // Owner allowed to refine the deck
+	WorkspaceID    string                  `json:"workspaceId,omitempty"`
+	Ephemeral      bool                    `json:"ephemeral,omitempty"`      // Keep nothing past the job, the result is fetched once and the deck can't be refined
+	Warnings       []string                `json:"warnings,omitempty"`       // Files the API couldn't upload, carried into the result
+	Features       map[string]bool         `json:"features,omitempty"`       // Feature flags evaluated by the API for the workspace of the job
+	Fonts          []models.FontFile       `json:"fonts,omitempty"`          // Uploaded font files of the families in the settings
+	TokenLimits    models.TokenLimits      `json:"tokenLimits,omitempty"`    // Token limits of the owner's plan
+	ClaimTokenHash string                  `json:"claimTokenHash,omitempty"` // Hash of the token that gives access to an anonymous job, kept with its result and deck
+	Debug          bool                    `json:"debug,omitempty"`          // Capture the prompts and raw responses of the job for the admins
+}
+
+// RefinePayload represents a refinement task received from Cloud Tasks
+type RefinePayload struct {
+	JobID       string                  `json:"jobID"`
+	Revision    int                     `json:"revision"` // Revision the instruction is applied to
+	Instruction string                  `json:"instruction"`
+	Slide       int                     `json:"slide,omitempty"` // Slide to regenerate, with the instruction as the reason it was rejected
+	NotifyEmail string                  `json:"notifyEmail,omitempty"`
+	Webhooks    []notifications.Webhook `json:"webhooks,omitempty"`
+	TokenLimits models.TokenLimits      `json:"tokenLimits,omitempty"` // Token limits of the owner's plan
+}
+
+// IndexPayload represents a document added to a workspace library, received
+// from Cloud Tasks to be indexed
+type IndexPayload struct {
+	WorkspaceID string        `json:"workspaceId"`
+	DocumentID  string        `json:"documentId"`
+	File        FileReference `json:"file"`
+	IndexPath   string        `json:"indexPath"` // Where the index is stored next to the document
+}
+
+// EstimatePayload represents a request from the API to estimate the tokens of a prospective job
+type EstimatePayload struct {
+	Theme       string               `json:"theme"`
+	Prompt      string               `json:"prompt,omitempty"` // Topic the deck would be written from when there are no files
+	Files       []models.File        `json:"files"`
+	Settings    models.SlideSettings `json:"settings"`
+	TokenLimits models.TokenLimits   `json:"tokenLimits,omitempty"` // Token limits of the owner's plan
 }
 
-// FirestoreJob is the Firestore representation of a job
-type FirestoreJob struct {
-	ID        string `firestore:"id"`
-	Status    string `firestore:"status"`
-	Message   string `firestore:"message"`
-	CreatedAt int64  `firestore:"createdAt"`
-	UpdatedAt int64  `firestore:"updatedAt"`
-	ExpiresAt int64  `firestore:"expiresAt,omitempty"`
+// SourceReference references a document in a content source such as Confluence
+type SourceReference struct {
+	Type     string            `json:"type"`
+	Location string            `json:"location"`
+	Options  map[string]string `json:"options,omitempty"`
 }
 
-// FirestoreResult is the Firestore representation of a job result
-type FirestoreResult struct {
-	ID          string `firestore:"id"`
-	ResultURL   string `firestore:"resultUrl"`
-	PDFData     []byte `firestore:"pdfData"`
-	HTMLData    []byte `firestore:"htmlData"`
-	CreatedAt   int64  `firestore:"createdAt"`
-	ExpiresAt   int64  `firestore:"expiresAt"`
+// ContentSources fetches documents from the content sources configured on this instance
+type ContentSources interface {
+	Fetch(ctx context.Context, sourceType, location string, options map[string]string, maxBytes int) ([]models.File, error)
+}
+
+// DriveFiles references Google Drive files to generate from alongside the uploads
+type DriveFiles struct {
+	Owner        string   `json:"owner"` // Owner whose Drive connection is used
+	FileIDs      []string `json:"fileIds"`
+	MaxFileBytes int      `json:"maxFileBytes"` // Largest file allowed by the owner's plan
+}
+
+// DriveFetcher downloads Google Drive files with the connection of their owner
+type DriveFetcher interface {
+	Fetch(ctx context.Context, owner string, fileIDs []string, maxBytes int) ([]models.File, error)
+}
+
+// JobExporter exports the records of finished jobs for analytics, such as to BigQuery
+type JobExporter interface {
+	Export(ctx context.Context, record analytics.JobRecord) error
+}
+
+// Generator generates a presentation from source files, or from a topic when there are none
+type Generator interface {
+	GenerateSlides(
+		ctx context.Context,
+		theme string,
+		topic string,
+		files []models.File,
+		settings models.SlideSettings,
+		checkpoint *slides.Checkpoint,
+		statusUpdateFn func(stage slides.Stage, status slides.Status) error,
+		saveCheckpointFn func(checkpoint *slides.Checkpoint) error,
+	) (*slides.Presentation, error)
+
+	RefineSlides(
+		ctx context.Context,
+		theme string,
+		markdown string,
+		instruction string,
+		settings models.SlideSettings,
+		statusUpdateFn func(stage slides.Stage, status slides.Status) error,
+	) (*slides.Presentation, error)
+
+	RegenerateSlide(
+		ctx context.Context,
+		theme string,
+		markdown string,
+		slide int,
+		reason string,
+		settings models.SlideSettings,
+		statusUpdateFn func(stage slides.Stage, status slides.Status) error,
+	) (*slides.Presentation, error)
+
+	RenderOnePager(ctx context.Context, markdown string) ([]byte, error)
+
+	EstimateTokens(ctx context.Context, theme, topic string, files []models.File, settings models.SlideSettings) (*slides.TokenEstimate, error)
+
+	DryRun(ctx context.Context, theme, topic string, files []models.File, settings models.SlideSettings) (*slides.DryRun, error)
+
+	IndexDocument(ctx context.Context, file models.File) (*models.DocumentIndex, error)
+
+	SplitChapters(ctx context.Context, files []models.File) ([]slides.Chapter, error)
 }
 
 // TaskController handles requests from Cloud Tasks
 type TaskController struct {
-	slideService *slides.SlideService
-	firestoreClient *firestore.Client
-	storageClient *storage.Client
-	bucketName string
+	slideService   Generator
+	emailService   *notifications.EmailService
+	webhookService *notifications.WebhookService
+	jobStore       jobs.JobStore
+	blobStore      jobs.BlobStore
+	driveFetcher   DriveFetcher
+	contentSources ContentSources
+	exporter       JobExporter // Nil when jobs aren't exported
 }
 
 // NewTaskController creates a new task controller
-func NewTaskController(slideService *slides.SlideService, firestoreClient *firestore.Client) *TaskController {
-	// Get bucket name from environment variables
-	bucketName := os.Getenv("GCS_BUCKET_NAME")
-	if bucketName == "" {
-		bucketName = "slideitin-files" // Default bucket name
-	}
-	
-	// Create Cloud Storage client
-	ctx := context.Background()
-	storageClient, err := storage.NewClient(ctx)
-	if err != nil {
-		log.Printf("Failed to create Cloud Storage client: %v", err)
-		// Continue without storage client, will be handled in requests
-	}
-	
+func NewTaskController(slideService Generator, emailService *notifications.EmailService, webhookService *notifications.WebhookService, jobStore jobs.JobStore, blobStore jobs.BlobStore, driveFetcher DriveFetcher, contentSources ContentSources, exporter JobExporter) *TaskController {
 	return &TaskController{
-		slideService: slideService,
-		firestoreClient: firestoreClient,
-		storageClient: storageClient,
-		bucketName: bucketName,
-	}
-}
-
-// downloadFileFromGCS downloads a file from Google Cloud Storage
-func (c *TaskController) downloadFileFromGCS(ctx context.Context, gcsPath string) ([]byte, string, error) {
-	// Get a handle to the bucket
-	bucket := c.storageClient.Bucket(c.bucketName)
-	
-	// Get a handle to the object
-	obj := bucket.Object(gcsPath)
-	
-	// Check if the object exists
-	attrs, err := obj.Attrs(ctx)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to get object attributes: %v", err)
+		slideService:   slideService,
+		emailService:   emailService,
+		webhookService: webhookService,
+		jobStore:       jobStore,
+		blobStore:      blobStore,
+		driveFetcher:   driveFetcher,
+		contentSources: contentSources,
+		exporter:       exporter,
 	}
-	
-	// Create a reader for the object
-	r, err := obj.NewReader(ctx)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to create reader: %v", err)
-	}
-	defer r.Close()
-	
-	// Read the file data
-	data, err := io.ReadAll(r)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to read file: %v", err)
-	}
-	
-	return data, attrs.ContentType, nil
 }
 
 // ProcessSlides handles slide generation requests from Cloud Tasks
 func (c *TaskController) ProcessSlides(ctx *gin.Context) {
-	// Check if storage client is available
-	if c.storageClient == nil {
-		log.Printf("Storage client not available")
+	// Check if the blob store is available
+	if c.blobStore == nil {
+		log.Printf("Blob store not available")
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Storage client not configured"})
 		return
 	}
-	
+
 	// Parse task payload from request body
 	var payload TaskPayload
 	if err := ctx.ShouldBindJSON(&payload); err != nil {
@@ -129,158 +215,966 @@ func (c *TaskController) ProcessSlides(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid payload: %v", err)})
 		return
 	}
-	
-	// Create a job status update function
-	statusUpdateFn := func(message string) error {
-		return c.updateJobStatus(payload.JobID, "processing", message, "")
+
+	// While Gemini is down the task goes back to Cloud Tasks before any work is done
+	if err := slides.GeminiAvailable(); err != nil {
+		c.deferUntilGemini(ctx, payload.JobID, err)
+		return
+	}
+
+	// Create a job status update function, the job moves to the stage of each update
+	statusUpdateFn := func(stage slides.Stage, status slides.Status) error {
+		return c.updateJobStatus(payload.JobID, jobs.JobStatus(stage), status, "")
+	}
+
+	// Create a checkpoint save function
+	saveCheckpointFn := func(checkpoint *slides.Checkpoint) error {
+		return c.jobStore.UpdateJob(context.Background(), payload.JobID, map[string]interface{}{"checkpoint": checkpoint})
 	}
-	
+
 	// Update initial job status
-	if err := statusUpdateFn("Processing slides"); err != nil {
+	if err := statusUpdateFn(slides.StageUploading, slides.NewStatus(slides.StatusProcessingSlides)); err != nil {
+		if errors.Is(err, jobs.ErrIllegalTransition) {
+			skipTask(ctx, payload.JobID, err)
+			return
+		}
 		log.Printf("Failed to update job status: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update job status: %v", err)})
 		return
 	}
-	
-	// Download files from GCS
+
+	// Export what the task did with the job once it is done
+	record := analytics.JobRecord{
+		JobID:       payload.JobID,
+		Kind:        analytics.KindGenerate,
+		Owner:       payload.Owner,
+		WorkspaceID: payload.WorkspaceID,
+		Theme:       payload.Theme,
+		Settings:    payload.Settings,
+		Inputs:      len(payload.Files) + len(payload.Sources),
+		FromPrompt:  payload.Prompt != "",
+		Ephemeral:   payload.Ephemeral,
+		Retries:     taskRetries(ctx),
+		StartedAt:   time.Now(),
+	}
+	if payload.Drive != nil {
+		record.Inputs += len(payload.Drive.FileIDs)
+	}
+	var usage *slides.Usage
+	if c.exporter != nil {
+		defer func() { c.exportJob(record, usage) }()
+	}
+
+	// Load the checkpoint left by a previous attempt of this task
+	var checkpoint *slides.Checkpoint
+	if job, err := c.jobStore.GetJob(ctx.Request.Context(), payload.JobID); err != nil {
+		log.Printf("Warning: Failed to load checkpoint for job %s: %v", payload.JobID, err)
+	} else if job.Checkpoint != nil {
+		log.Printf("Resuming job %s from checkpoint", payload.JobID)
+		checkpoint = job.Checkpoint
+	}
+
+	// Download files from GCS, a file that can't be fetched is left out with
+	// a warning as long as the job has other inputs
+	downloadCtx, cancelDownload := context.WithTimeout(ctx.Request.Context(), downloadTimeout)
+	defer cancelDownload()
 	files := make([]models.File, 0, len(payload.Files))
+	warnings := append([]string(nil), payload.Warnings...)
+	var fetchErr error
+	leaveOut := func(message string, err error) {
+		fetchErr = fmt.Errorf("%s: %v", message, err)
+		warnings = append(warnings, fmt.Sprintf("%s and was left out: %v", message, err))
+		statusUpdateFn(slides.StageUploading, slides.TextStatus(fmt.Sprintf("%s and was left out", message)))
+	}
 	for _, fileRef := range payload.Files {
 		// Download the file from GCS
-		fileData, contentType, err := c.downloadFileFromGCS(ctx.Request.Context(), fileRef.GCSPath)
+		fileData, contentType, err := c.blobStore.Download(downloadCtx, fileRef.GCSPath)
+		if errors.Is(downloadCtx.Err(), context.DeadlineExceeded) {
+			err = errors.New("download timed out")
+		}
 		if err != nil {
 			log.Printf("Failed to download file %s: %v", fileRef.Filename, err)
-			c.updateJobStatus(payload.JobID, "failed", fmt.Sprintf("Failed to download file %s: %v", fileRef.Filename, err), "")
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to download file: %v", err)})
-			return
+			leaveOut(fmt.Sprintf("File %s couldn't be downloaded", fileRef.Filename), err)
+			continue
 		}
-		
+
 		// Create a file object
 		file := models.File{
 			Filename: fileRef.Filename,
 			Data:     fileData,
 			Type:     contentType,
+			Hash:     fileRef.Hash,
+		}
+
+		// Library documents come with their passages embedded, a document
+		// whose index can't be read is split and embedded again if needed
+		if fileRef.IndexPath != "" {
+			index, err := c.loadIndex(downloadCtx, fileRef.IndexPath)
+			if err != nil {
+				log.Printf("Warning: Failed to load the index of %s: %v", fileRef.Filename, err)
+			}
+			file.Index = index
 		}
 		files = append(files, file)
 	}
-	
+
+	// Download the Drive files with the owner's connection
+	if payload.Drive != nil {
+		driveFiles, err := c.fetchDriveFiles(downloadCtx, payload.Drive)
+		if errors.Is(downloadCtx.Err(), context.DeadlineExceeded) {
+			err = errors.New("download timed out")
+		}
+		if err != nil {
+			log.Printf("Failed to download Drive files for job %s: %v", payload.JobID, err)
+			leaveOut("Google Drive files couldn't be downloaded", err)
+		}
+		files = append(files, driveFiles...)
+	}
+
+	// Import the documents of the content sources
+	for _, source := range payload.Sources {
+		sourceFiles, err := c.fetchSource(downloadCtx, source, payload.MaxSourceBytes)
+		if errors.Is(downloadCtx.Err(), context.DeadlineExceeded) {
+			err = errors.New("download timed out")
+		}
+		if err != nil {
+			log.Printf("Failed to import %s for job %s: %v", source.Location, payload.JobID, err)
+			leaveOut(fmt.Sprintf("%s couldn't be imported", source.Location), err)
+			continue
+		}
+		files = append(files, sourceFiles...)
+	}
+
+	// The job fails only when none of its inputs could be fetched
+	if fetchErr != nil && len(files) == 0 {
+		log.Printf("No input could be fetched for job %s: %v", payload.JobID, fetchErr)
+		c.updateJobStatus(payload.JobID, jobs.StatusFailed, slides.TextStatus(fmt.Sprintf("Failed to download files: %v", fetchErr)), "")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to download files: %v", fetchErr)})
+		return
+	}
+
+	// Chunked mode shipped before its flag, so tasks without the flag keep it
+	chunked, ok := payload.Features["chunked_mode"]
+	payload.Settings.ChunkedMode = chunked || !ok
+	payload.Settings.InjectionDetection = payload.Features["injection_detection"]
+	payload.Settings.FontFiles = payload.Fonts
+	payload.Settings.TokenLimits = payload.TokenLimits
+
+	// Record the exchanges with Gemini of a job an admin debugs
+	generateCtx := ctx.Request.Context()
+	var capture *slides.Capture
+	if payload.Debug {
+		generateCtx, capture = slides.WithCapture(generateCtx)
+	}
+	if c.exporter != nil {
+		generateCtx, usage = slides.WithUsage(generateCtx)
+	}
+
 	// Generate slides
-	pdfData, htmlData, err := c.slideService.GenerateSlides(
-		ctx.Request.Context(),
+	presentation, err := c.slideService.GenerateSlides(
+		generateCtx,
 		payload.Theme,
+		payload.Prompt,
 		files,
 		payload.Settings,
+		checkpoint,
 		statusUpdateFn,
+		saveCheckpointFn,
 	)
-	
+	if capture != nil {
+		c.storeCapture(ctx.Request.Context(), payload, files, capture)
+	}
+
+	// A busy instance hands the task back to Cloud Tasks, which retries it
+	// later, possibly on another instance
+	if errors.Is(err, slides.ErrBusy) {
+		log.Printf("Instance busy, deferring job %s", payload.JobID)
+		c.updateJobStatus(payload.JobID, jobs.StatusQueued, slides.NewStatus(slides.StatusWaitingForWorker), "")
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "All workers are busy"})
+		return
+	}
+	if errors.Is(err, slides.ErrGeminiUnavailable) {
+		c.deferUntilGemini(ctx, payload.JobID, err)
+		return
+	}
+	// A job finished by another delivery of the task isn't started over
+	if errors.Is(err, jobs.ErrIllegalTransition) {
+		skipTask(ctx, payload.JobID, err)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to generate slides: %v", err)
-		c.updateJobStatus(payload.JobID, "failed", fmt.Sprintf("Failed to generate slides: %v", err), "")
+		message := fmt.Sprintf("Failed to generate slides: %v", err)
+		if errors.Is(err, slides.ErrTimeout) {
+			message = "Generation timed out. Please try again."
+		}
+		c.updateJobStatus(payload.JobID, jobs.StatusFailed, slides.TextStatus(message), "")
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to generate slides: %v", err)})
 		return
 	}
-	
+
 	// Create result URL
 	resultURL := "/results/" + payload.JobID
-	
+	presentation.Warnings = append(warnings, presentation.Warnings...)
+
 	// Store result in Firestore
-	if err := c.storeResult(ctx.Request.Context(), payload.JobID, resultURL, pdfData, htmlData); err != nil {
+	if err := c.storeResult(ctx.Request.Context(), payload.JobID, resultURL, presentation, payload.Ephemeral, payload.Owner, payload.WorkspaceID, payload.ClaimTokenHash); err != nil {
 		log.Printf("Failed to store result: %v", err)
-		c.updateJobStatus(payload.JobID, "failed", fmt.Sprintf("Failed to store result: %v", err), "")
+		c.updateJobStatus(payload.JobID, jobs.StatusFailed, slides.TextStatus(fmt.Sprintf("Failed to store result: %v", err)), "")
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to store result: %v", err)})
 		return
 	}
-	
+
+	// Keep the markdown as the first revision so the deck can be refined, a
+	// deck that can't be refined is still a finished deck. Ephemeral decks
+	// aren't kept.
+	if !payload.Ephemeral {
+		deck := jobs.FirestoreDeck{
+			ID:             payload.JobID,
+			Owner:          payload.Owner,
+			WorkspaceID:    payload.WorkspaceID,
+			ClaimTokenHash: payload.ClaimTokenHash,
+			Theme:          payload.Theme,
+			Settings:       payload.Settings,
+			UpdatedAt:      time.Now().Unix(),
+			Flashcards:     presentation.Flashcards,
+			OnePager:       presentation.OnePager,
+			ChunkSummaries: presentation.ChunkSummaries,
+		}
+		revision := jobs.FirestoreRevision{Markdown: presentation.Markdown, CreatedAt: deck.UpdatedAt}
+		if _, err := c.jobStore.AddRevision(ctx.Request.Context(), deck, revision); err != nil {
+			log.Printf("Warning: Failed to store the first revision of job %s: %v", payload.JobID, err)
+		}
+	}
+
 	// Clean up files from GCS
 	for _, fileRef := range payload.Files {
+		// Files stored by content may be reused by other jobs, they are
+		// removed by the bucket lifecycle rule instead
+		if fileRef.Hash != "" {
+			continue
+		}
 		// Delete the file from GCS
-		obj := c.storageClient.Bucket(c.bucketName).Object(fileRef.GCSPath)
-		if err := obj.Delete(ctx.Request.Context()); err != nil {
+		if err := c.blobStore.Delete(ctx.Request.Context(), fileRef.GCSPath); err != nil {
 			log.Printf("Warning: Failed to delete file %s from GCS: %v", fileRef.GCSPath, err)
 			// Continue anyway, this is not a critical error
 		} else {
 			log.Printf("Deleted file %s from GCS", fileRef.GCSPath)
 		}
 	}
-	
-	// Mark job as completed
-	if err := c.setJobCompleted(payload.JobID, "Slides generated successfully", resultURL); err != nil {
+
+	// Mark job as completed, keeping any warnings visible to the user
+	message := slides.NewStatus(slides.StatusCompleted)
+	if len(presentation.Warnings) > 0 {
+		message = slides.NewStatus(slides.StatusCompletedWithWarnings, "warnings", strings.Join(presentation.Warnings, ". "))
+	}
+	if err := c.setJobCompleted(payload.JobID, message, resultURL, presentation.Warnings); err != nil {
 		log.Printf("Failed to mark job as completed: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to mark job as completed: %v", err)})
 		return
 	}
-	
+
+	c.notifyDeckReady(ctx.Request.Context(), payload.JobID, payload.NotifyEmail, payload.Webhooks, presentation.PDFData)
+
 	// Return success response
 	ctx.JSON(http.StatusOK, gin.H{"status": "success", "jobID": payload.JobID})
 }
 
-// updateJobStatus updates a job's status in Firestore
-func (c *TaskController) updateJobStatus(jobID, status, message, resultURL string) error {
+// RefineSlides handles refinement requests from Cloud Tasks, which apply an
+// instruction to a revision of a deck and store the result as a new revision
+func (c *TaskController) RefineSlides(ctx *gin.Context) {
+	var payload RefinePayload
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		log.Printf("Failed to parse refinement payload: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid payload: %v", err)})
+		return
+	}
+	if err := slides.GeminiAvailable(); err != nil {
+		c.deferUntilGemini(ctx, payload.JobID, err)
+		return
+	}
+
+	statusUpdateFn := func(stage slides.Stage, status slides.Status) error {
+		return c.updateJobStatus(payload.JobID, jobs.JobStatus(stage), status, "")
+	}
+	if err := statusUpdateFn(slides.StageProcessing, slides.NewStatus(slides.StatusProcessingRefinement)); err != nil {
+		if errors.Is(err, jobs.ErrIllegalTransition) {
+			skipTask(ctx, payload.JobID, err)
+			return
+		}
+		log.Printf("Failed to update job status: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update job status: %v", err)})
+		return
+	}
+
+	// Export what the task did with the job once it is done
+	record := analytics.JobRecord{JobID: payload.JobID, Kind: analytics.KindRefine, Retries: taskRetries(ctx), StartedAt: time.Now()}
+	refineCtx := ctx.Request.Context()
+	var usage *slides.Usage
+	if c.exporter != nil {
+		refineCtx, usage = slides.WithUsage(refineCtx)
+		defer func() { c.exportJob(record, usage) }()
+	}
+
+	// A failed refinement leaves the deck and its result as they were
+	fail := func(message string) {
+		log.Printf("Failed to refine job %s: %s", payload.JobID, message)
+		c.updateJobStatus(payload.JobID, jobs.StatusFailed, slides.TextStatus(message+". The previous revision is unchanged."), "")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+
+	deck, err := c.jobStore.GetDeck(ctx.Request.Context(), payload.JobID)
+	if err != nil {
+		fail(fmt.Sprintf("Failed to load deck: %v", err))
+		return
+	}
+	base, err := c.jobStore.GetRevision(ctx.Request.Context(), payload.JobID, payload.Revision)
+	if err != nil {
+		fail(fmt.Sprintf("Failed to load revision %d: %v", payload.Revision, err))
+		return
+	}
+
+	deck.Settings.TokenLimits = payload.TokenLimits
+	record.Owner, record.WorkspaceID, record.Theme, record.Settings = deck.Owner, deck.WorkspaceID, deck.Theme, deck.Settings
+
+	// A slide with a thumbs-down is regenerated alone, other instructions may change the whole deck
+	var presentation *slides.Presentation
+	if payload.Slide > 0 {
+		presentation, err = c.slideService.RegenerateSlide(
+			refineCtx,
+			deck.Theme,
+			base.Markdown,
+			payload.Slide,
+			payload.Instruction,
+			deck.Settings,
+			statusUpdateFn,
+		)
+	} else {
+		presentation, err = c.slideService.RefineSlides(
+			refineCtx,
+			deck.Theme,
+			base.Markdown,
+			payload.Instruction,
+			deck.Settings,
+			statusUpdateFn,
+		)
+	}
+	if errors.Is(err, slides.ErrBusy) {
+		log.Printf("Instance busy, deferring refinement of job %s", payload.JobID)
+		c.updateJobStatus(payload.JobID, jobs.StatusQueued, slides.NewStatus(slides.StatusWaitingForWorker), "")
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "All workers are busy"})
+		return
+	}
+	if errors.Is(err, slides.ErrGeminiUnavailable) {
+		c.deferUntilGemini(ctx, payload.JobID, err)
+		return
+	}
+	// A job finished by another delivery of the task isn't started over
+	if errors.Is(err, jobs.ErrIllegalTransition) {
+		skipTask(ctx, payload.JobID, err)
+		return
+	}
+	if err != nil {
+		message := fmt.Sprintf("Failed to apply your changes: %v", err)
+		if errors.Is(err, slides.ErrTimeout) {
+			message = "Refinement timed out. Please try again."
+		}
+		fail(message)
+		return
+	}
+
+	// Refinements keep the flashcards, the executive summary and the chunk
+	// summaries of the sources
+	presentation.Flashcards = deck.Flashcards
+	presentation.ChunkSummaries = deck.ChunkSummaries
+	if deck.OnePager != "" {
+		presentation.OnePager = deck.OnePager
+		if presentation.OnePagerPDF, err = c.slideService.RenderOnePager(ctx.Request.Context(), deck.OnePager); err != nil {
+			log.Printf("Warning: Failed to render the executive summary of job %s: %v", payload.JobID, err)
+		}
+	}
+
+	deck.UpdatedAt = time.Now().Unix()
+	number, err := c.jobStore.AddRevision(ctx.Request.Context(), *deck, jobs.FirestoreRevision{
+		Markdown:     presentation.Markdown,
+		Instruction:  payload.Instruction,
+		BaseRevision: base.Number,
+		Slide:        payload.Slide,
+		CreatedAt:    deck.UpdatedAt,
+	})
+	if err != nil {
+		fail(fmt.Sprintf("Failed to store revision: %v", err))
+		return
+	}
+
+	resultURL := "/results/" + payload.JobID
+	if err := c.storeResult(ctx.Request.Context(), payload.JobID, resultURL, presentation, false, deck.Owner, deck.WorkspaceID, deck.ClaimTokenHash); err != nil {
+		fail(fmt.Sprintf("Failed to store result: %v", err))
+		return
+	}
+	if err := c.setJobCompleted(payload.JobID, slides.NewStatus(slides.StatusRevisionCreated, "revision", strconv.Itoa(number)), resultURL, presentation.Warnings); err != nil {
+		log.Printf("Failed to mark job as completed: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to mark job as completed: %v", err)})
+		return
+	}
+
+	c.notifyDeckReady(ctx.Request.Context(), payload.JobID, payload.NotifyEmail, payload.Webhooks, presentation.PDFData)
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "jobID": payload.JobID, "revision": number})
+}
+
+// EstimateTokens counts the tokens a job would use with Gemini, for the API to
+// estimate its cost before it's created
+func (c *TaskController) EstimateTokens(ctx *gin.Context) {
+	var payload EstimatePayload
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		log.Printf("Failed to parse estimate payload: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid payload: %v", err)})
+		return
+	}
+	if len(payload.Files) == 0 && payload.Prompt == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Files or a prompt are required"})
+		return
+	}
+
+	payload.Settings.TokenLimits = payload.TokenLimits
+	estimate, err := c.slideService.EstimateTokens(ctx.Request.Context(), payload.Theme, payload.Prompt, payload.Files, payload.Settings)
+	if err != nil {
+		log.Printf("Failed to estimate tokens: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to count tokens: %v", err)})
+		return
+	}
+	ctx.JSON(http.StatusOK, estimate)
+}
+
+// DryRun builds the prompt of a prospective job and projects its tokens and
+// slides without calling Gemini or rendering the deck, for debugging prompt
+// changes and client integrations
+func (c *TaskController) DryRun(ctx *gin.Context) {
+	var payload EstimatePayload
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		log.Printf("Failed to parse dry run payload: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid payload: %v", err)})
+		return
+	}
+	if len(payload.Files) == 0 && payload.Prompt == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Files or a prompt are required"})
+		return
+	}
+
+	payload.Settings.TokenLimits = payload.TokenLimits
+	dryRun, err := c.slideService.DryRun(ctx.Request.Context(), payload.Theme, payload.Prompt, payload.Files, payload.Settings)
+	if err != nil {
+		log.Printf("Failed to dry run job: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to build the prompt: %v", err)})
+		return
+	}
+	ctx.JSON(http.StatusOK, dryRun)
+}
+
+// SplitChapters detects the chapters of the documents of a prospective job,
+// for the API to create a job for each chapter of a course
+func (c *TaskController) SplitChapters(ctx *gin.Context) {
+	var payload EstimatePayload
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		log.Printf("Failed to parse chapters payload: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid payload: %v", err)})
+		return
+	}
+	if len(payload.Files) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Files are required"})
+		return
+	}
+
+	chapters, err := c.slideService.SplitChapters(ctx.Request.Context(), payload.Files)
+	if err != nil {
+		log.Printf("Failed to split chapters: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to split the documents into chapters: %v", err)})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"chapters": chapters})
+}
+
+// IndexDocument splits a document added to a workspace library into passages,
+// embeds them and stores the index next to the document, so the decks
+// generated from the library don't do it again
+func (c *TaskController) IndexDocument(ctx *gin.Context) {
+	if c.blobStore == nil {
+		log.Printf("Blob store not available")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Storage client not configured"})
+		return
+	}
+	var payload IndexPayload
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		log.Printf("Failed to parse index payload: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid payload: %v", err)})
+		return
+	}
+
+	// A document deleted before it was indexed has nothing left to index
+	data, contentType, err := c.blobStore.Download(ctx.Request.Context(), payload.File.GCSPath)
+	if errors.Is(err, jobs.ErrNotFound) {
+		log.Printf("Document %s of workspace %s was deleted, skipping its index", payload.DocumentID, payload.WorkspaceID)
+		ctx.JSON(http.StatusOK, gin.H{"status": "skipped", "documentID": payload.DocumentID})
+		return
+	}
+	if err != nil {
+		c.failIndex(ctx, payload, fmt.Errorf("failed to download document: %v", err))
+		return
+	}
+
+	file := models.File{Filename: payload.File.Filename, Data: data, Type: contentType, Hash: payload.File.Hash}
+	if payload.File.Type != "" {
+		file.Type = payload.File.Type
+	}
+	index, err := c.slideService.IndexDocument(ctx.Request.Context(), file)
+	if err != nil {
+		c.failIndex(ctx, payload, fmt.Errorf("failed to index document: %v", err))
+		return
+	}
+	indexData, err := json.Marshal(index)
+	if err == nil {
+		err = c.blobStore.Upload(ctx.Request.Context(), payload.IndexPath, "application/json", indexData)
+	}
+	if err != nil {
+		c.failIndex(ctx, payload, fmt.Errorf("failed to store index: %v", err))
+		return
+	}
+
+	err = c.jobStore.UpdateLibraryDocument(ctx.Request.Context(), payload.WorkspaceID, payload.DocumentID, map[string]interface{}{
+		"status":     jobs.DocumentReady,
+		"indexPath":  payload.IndexPath,
+		"passages":   len(index.Passages),
+		"indexModel": index.Model,
+		"error":      "",
+		"updatedAt":  time.Now().Unix(),
+	})
+	if errors.Is(err, jobs.ErrNotFound) {
+		// Deleted while it was indexed, the API only deletes the files it knows of
+		if err := c.blobStore.Delete(ctx.Request.Context(), payload.IndexPath); err != nil {
+			log.Printf("Warning: Failed to delete index %s: %v", payload.IndexPath, err)
+		}
+		ctx.JSON(http.StatusOK, gin.H{"status": "skipped", "documentID": payload.DocumentID})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to mark document %s as indexed: %v", payload.DocumentID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update document: %v", err)})
+		return
+	}
+
+	log.Printf("Indexed document %s of workspace %s into %d passages", payload.DocumentID, payload.WorkspaceID, len(index.Passages))
+	ctx.JSON(http.StatusOK, gin.H{"status": "success", "documentID": payload.DocumentID})
+}
+
+// failIndex marks a library document as failed to index and responds with
+// the error, Cloud Tasks retries the task
+func (c *TaskController) failIndex(ctx *gin.Context, payload IndexPayload, err error) {
+	log.Printf("Failed to index document %s of workspace %s: %v", payload.DocumentID, payload.WorkspaceID, err)
+	fields := map[string]interface{}{
+		"status":    jobs.DocumentFailed,
+		"error":     err.Error(),
+		"updatedAt": time.Now().Unix(),
+	}
+	if updateErr := c.jobStore.UpdateLibraryDocument(context.Background(), payload.WorkspaceID, payload.DocumentID, fields); updateErr != nil && !errors.Is(updateErr, jobs.ErrNotFound) {
+		log.Printf("Failed to mark document %s as failed: %v", payload.DocumentID, updateErr)
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// loadIndex downloads the index of a library document
+func (c *TaskController) loadIndex(ctx context.Context, indexPath string) (*models.DocumentIndex, error) {
+	data, _, err := c.blobStore.Download(ctx, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	var index models.DocumentIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index: %v", err)
+	}
+	return &index, nil
+}
+
+// storeFlashcards stores the flashcards of a result as CSV
+func (c *TaskController) storeFlashcards(ctx context.Context, jobID string, flashcards []slides.Flashcard, result *jobs.FirestoreResult) error {
+	data, err := slides.FlashcardsCSV(flashcards)
+	if err != nil {
+		return err
+	}
+	flashcardsPath := path.Join("results", jobID, "flashcards.csv")
+	if err := c.blobStore.Upload(ctx, flashcardsPath, "text/csv", data); err != nil {
+		return err
+	}
+	result.FlashcardsPath = flashcardsPath
+	return nil
+}
+
+// storeAlignment stores the transcript alignment of a result as JSON
+func (c *TaskController) storeAlignment(ctx context.Context, jobID string, alignment *slides.TranscriptAlignment, result *jobs.FirestoreResult) error {
+	data, err := json.Marshal(alignment)
+	if err != nil {
+		return err
+	}
+	alignmentPath := path.Join("results", jobID, "alignment.json")
+	if err := c.blobStore.Upload(ctx, alignmentPath, "application/json", data); err != nil {
+		return err
+	}
+	result.AlignmentPath = alignmentPath
+	return nil
+}
+
+// storeChunkSummaries stores the summaries of the sections of long documents
+// of a result as JSON
+func (c *TaskController) storeChunkSummaries(ctx context.Context, jobID string, summaries []slides.ChunkSummary, result *jobs.FirestoreResult) error {
+	data, err := json.Marshal(summaries)
+	if err != nil {
+		return err
+	}
+	summariesPath := path.Join("results", jobID, "chunk-summaries.json")
+	if err := c.blobStore.Upload(ctx, summariesPath, "application/json", data); err != nil {
+		return err
+	}
+	result.ChunkSummariesPath = summariesPath
+	return nil
+}
+
+// storeGrounding stores the bullet points of a result checked against its
+// sources as JSON
+func (c *TaskController) storeGrounding(ctx context.Context, jobID string, report *slides.GroundingReport, result *jobs.FirestoreResult) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	groundingPath := path.Join("results", jobID, "grounding.json")
+	if err := c.blobStore.Upload(ctx, groundingPath, "application/json", data); err != nil {
+		return err
+	}
+	result.GroundingPath = groundingPath
+	return nil
+}
+
+// notifyDeckReady emails the deck and posts to the chat webhooks, failed
+// notifications don't fail the job
+func (c *TaskController) notifyDeckReady(ctx context.Context, jobID, notifyEmail string, webhooks []notifications.Webhook, pdfData []byte) {
+	// Email the deck if requested
+	if notifyEmail != "" {
+		if err := c.emailService.SendDeckReady(ctx, notifyEmail, jobID, pdfData, resultRetention); err != nil {
+			log.Printf("Warning: Failed to email deck for job %s: %v", jobID, err)
+		}
+	}
+
+	// Post to the chat webhooks configured for the API key
+	for _, webhook := range webhooks {
+		if err := c.webhookService.PostDeckReady(ctx, webhook, jobID, resultRetention); err != nil {
+			log.Printf("Warning: Failed to notify webhook for job %s: %v", jobID, err)
+		}
+	}
+}
+
+// fetchDriveFiles downloads the Drive files of a task
+func (c *TaskController) fetchDriveFiles(ctx context.Context, drive *DriveFiles) ([]models.File, error) {
+	if c.driveFetcher == nil {
+		return nil, errors.New("Google Drive input is not enabled")
+	}
+	return c.driveFetcher.Fetch(ctx, drive.Owner, drive.FileIDs, drive.MaxFileBytes)
+}
+
+// fetchSource imports the documents of a content source
+func (c *TaskController) fetchSource(ctx context.Context, source SourceReference, maxBytes int) ([]models.File, error) {
+	if c.contentSources == nil {
+		return nil, fmt.Errorf("the %s content source is not configured", source.Type)
+	}
+	return c.contentSources.Fetch(ctx, source.Type, source.Location, source.Options, maxBytes)
+}
+
+// skipTask acknowledges a task whose job can't move to the status of the
+// task, such as a task delivered again after the job completed, so Cloud
+// Tasks doesn't retry it
+func skipTask(ctx *gin.Context, jobID string, err error) {
+	log.Printf("Skipping task for job %s: %v", jobID, err)
+	ctx.JSON(http.StatusOK, gin.H{"status": "skipped", "jobID": jobID})
+}
+
+// deferUntilGemini hands a task back to Cloud Tasks while Gemini is down, so
+// it is retried later instead of failing the job
+func (c *TaskController) deferUntilGemini(ctx *gin.Context, jobID string, err error) {
+	if updateErr := c.updateJobStatus(jobID, jobs.StatusQueued, slides.NewStatus(slides.StatusWaitingForGemini), ""); errors.Is(updateErr, jobs.ErrIllegalTransition) {
+		skipTask(ctx, jobID, updateErr)
+		return
+	}
+	log.Printf("Deferring job %s: %v", jobID, err)
+	ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+}
+
+// taskRetries returns how many times Cloud Tasks delivered the task before
+func taskRetries(ctx *gin.Context) int {
+	retries, _ := strconv.Atoi(ctx.GetHeader("X-CloudTasks-TaskRetryCount"))
+	return retries
+}
+
+// exportJob exports the record of a task with the outcome of its job and the
+// tokens it used, unless the task handed the job back to the queue. A failed
+// export is only logged.
+func (c *TaskController) exportJob(record analytics.JobRecord, usage *slides.Usage) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	job, err := c.jobStore.GetJob(ctx, record.JobID)
+	if err != nil {
+		log.Printf("Warning: Failed to export job %s: %v", record.JobID, err)
+		return
+	}
+	if !jobs.JobStatus(job.Status).Terminal() {
+		return
+	}
+	record.Status = job.Status
+	if job.Status == string(jobs.StatusFailed) {
+		record.Error = job.Message
+	}
+	record.Warnings = len(job.Warnings)
+	if job.CreatedAt > 0 {
+		record.CreatedAt = time.Unix(job.CreatedAt, 0)
+	}
+	record.FinishedAt = time.Now()
+	if usage != nil {
+		record.GeminiRequests, record.InputTokens, record.OutputTokens = usage.Totals()
+	}
+	if err := c.exporter.Export(ctx, record); err != nil {
+		log.Printf("Warning: Failed to export job %s: %v", record.JobID, err)
+	}
+}
+
+// updateJobStatus moves a job to a status in Firestore, if the job can move
+// to it. The message is stored in English with its code, which the API
+// localizes.
+func (c *TaskController) updateJobStatus(jobID string, status jobs.JobStatus, message slides.Status, resultURL string) error {
 	ctx := context.Background()
 	now := time.Now().Unix()
-	
+
 	// Update job in Firestore
-	updates := []firestore.Update{
-		{Path: "status", Value: status},
-		{Path: "message", Value: message},
-		{Path: "updatedAt", Value: now},
-	}
-	
-	_, err := c.firestoreClient.Collection("jobs").Doc(jobID).Update(ctx, updates)
+	err := c.jobStore.TransitionJob(ctx, jobID, status, map[string]interface{}{
+		"message":       message.Text(),
+		"messageCode":   string(message.Code),
+		"messageParams": message.Params,
+		"updatedAt":     now,
+	})
 	if err != nil {
 		log.Printf("Failed to update job status in Firestore: %v", err)
 		return err
 	}
-	
-	log.Printf("Job %s updated: status=%s, message=%s", jobID, status, message)
+
+	log.Printf("Job %s updated: status=%s, message=%s", jobID, status, message.Text())
 	return nil
 }
 
-// setJobCompleted marks a job as completed and sets it to expire
-func (c *TaskController) setJobCompleted(jobID, message, resultURL string) error {
+// setJobCompleted marks a job as completed with the warnings of its result
+// and sets it to expire
+func (c *TaskController) setJobCompleted(jobID string, message slides.Status, resultURL string, warnings []string) error {
 	ctx := context.Background()
 	now := time.Now().Unix()
 	// Set job to expire in 5 minutes
 	expiresAt := now + 300 // 300 seconds = 5 minutes
-	
+
 	// Update job in Firestore
-	updates := []firestore.Update{
-		{Path: "status", Value: "completed"},
-		{Path: "message", Value: message},
-		{Path: "updatedAt", Value: now},
-		{Path: "expiresAt", Value: expiresAt},
-	}
-	
-	_, err := c.firestoreClient.Collection("jobs").Doc(jobID).Update(ctx, updates)
+	err := c.jobStore.TransitionJob(ctx, jobID, jobs.StatusCompleted, map[string]interface{}{
+		"message":       message.Text(),
+		"messageCode":   string(message.Code),
+		"messageParams": message.Params,
+		"updatedAt":     now,
+		"expiresAt":     expiresAt,
+		"warnings":      warnings,
+	})
 	if err != nil {
 		log.Printf("Failed to update job status in Firestore: %v", err)
 		return err
 	}
-	
+
 	log.Printf("Job %s completed and will expire at %s", jobID, time.Unix(expiresAt, 0).Format(time.RFC3339))
 	return nil
 }
 
-// storeResult stores a job result in Firestore
-func (c *TaskController) storeResult(ctx context.Context, jobID, resultURL string, pdfData []byte, htmlData []byte) error {
-	now := time.Now().Unix()
-	// Set expiration time to 1 hour from now
-	expiresAt := now + 3600
-	
-	result := FirestoreResult{
-		ID:          jobID,
-		ResultURL:   resultURL,
-		PDFData:     pdfData,
-		HTMLData:    htmlData,
-		CreatedAt:   now,
-		ExpiresAt:   expiresAt,
-	}
-	
-	_, err := c.firestoreClient.Collection("results").Doc(jobID).Set(ctx, result)
+// storeCapture keeps the exchanges of a debugged job for captureTTL, failed
+// jobs included, so their outputs can be reproduced. The task is kept with
+// the files as they were fetched, so the job can be replayed with the same
+// inputs. A job resumed from its markdown has no exchanges and keeps the
+// capture of the earlier attempt.
+func (c *TaskController) storeCapture(ctx context.Context, payload TaskPayload, files []models.File, capture *slides.Capture) {
+	exchanges, truncated := capture.Exchanges()
+	if len(exchanges) == 0 {
+		return
+	}
+	now := time.Now()
+	record := jobs.FirestoreCapture{
+		ID:        payload.JobID,
+		Exchanges: exchanges,
+		Truncated: truncated,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(captureTTL).Unix(),
+		DeleteAt:  now.Add(captureTTL),
+	}
+	if task, err := c.captureTask(ctx, payload, files); err != nil {
+		log.Printf("Warning: Failed to keep the inputs of job %s, it can't be replayed: %v", payload.JobID, err)
+	} else {
+		record.Task = task
+	}
+	if err := c.jobStore.StoreCapture(ctx, record); err != nil {
+		log.Printf("Warning: Failed to store the capture of job %s: %v", payload.JobID, err)
+		return
+	}
+	log.Printf("Captured %d exchanges with Gemini for job %s", len(exchanges), payload.JobID)
+}
+
+// captureTask stores the fetched files of a debugged job under captures/ and
+// returns its task as JSON with the files pointing at them. The Drive files
+// and content sources are among the files, and the delivery settings are
+// left out, so a replay neither fetches nor notifies anything. The files are
+// stored by content, so replays don't delete them and share their Gemini
+// uploads.
+func (c *TaskController) captureTask(ctx context.Context, payload TaskPayload, files []models.File) (string, error) {
+	references := make([]FileReference, 0, len(files))
+	for i, file := range files {
+		sum := sha256.Sum256(file.Data)
+		objectPath := path.Join("captures", payload.JobID, "inputs", strconv.Itoa(i))
+		if err := c.blobStore.Upload(ctx, objectPath, file.Type, file.Data); err != nil {
+			return "", err
+		}
+		references = append(references, FileReference{Filename: file.Filename, Type: file.Type, GCSPath: objectPath, Hash: hex.EncodeToString(sum[:])})
+	}
+	payload.Files = references
+	payload.Drive = nil
+	payload.Sources = nil
+	payload.NotifyEmail = ""
+	payload.Webhooks = nil
+	payload.Warnings = nil
+	task, err := json.Marshal(payload)
 	if err != nil {
+		return "", err
+	}
+	return string(task), nil
+}
+
+// storeResult stores a job result in Firestore, with its documents in Cloud
+// Storage so the API can stream them. The result of an ephemeral job is
+// stored inline, since it is fetched once right after it finishes and expires
+// with the job. The result keeps the owner and workspace of its job, or the
+// hash of the claim token of an anonymous job, which the API checks before
+// serving or sharing it.
+func (c *TaskController) storeResult(ctx context.Context, jobID, resultURL string, presentation *slides.Presentation, ephemeral bool, owner, workspaceID, claimTokenHash string) error {
+	now := time.Now().Unix()
+	expiresAt := now + int64(resultRetention.Seconds())
+	if ephemeral {
+		expiresAt = now + int64(ephemeralRetention.Seconds())
+	}
+
+	result := jobs.FirestoreResult{
+		ID:             jobID,
+		ResultURL:      resultURL,
+		PDFData:        presentation.PDFData,
+		HTMLData:       presentation.HTMLData,
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+		Ephemeral:      ephemeral,
+		ClaimTokenHash: claimTokenHash,
+		Owner:          owner,
+		WorkspaceID:    workspaceID,
+		Warnings:       presentation.Warnings,
+	}
+
+	if !ephemeral && c.blobStore != nil {
+		result.PDFPath = path.Join("results", jobID, "presentation.pdf")
+		result.HTMLPath = path.Join("results", jobID, "presentation.html")
+		if err := c.blobStore.Upload(ctx, result.PDFPath, "application/pdf", presentation.PDFData); err != nil {
+			return fmt.Errorf("failed to store PDF: %v", err)
+		}
+		if err := c.blobStore.Upload(ctx, result.HTMLPath, "text/html", presentation.HTMLData); err != nil {
+			return fmt.Errorf("failed to store HTML: %v", err)
+		}
+		// Without the viewer, the API previews the HTML with its external requests blocked
+		if presentation.ViewerHTML != nil {
+			viewerPath := path.Join("results", jobID, "viewer.html")
+			if err := c.blobStore.Upload(ctx, viewerPath, "text/html", presentation.ViewerHTML); err != nil {
+				log.Printf("Warning: Failed to store the viewer of job %s: %v", jobID, err)
+			} else {
+				result.ViewerPath = viewerPath
+			}
+		}
+		if presentation.Thumbnail != nil {
+			thumbnailPath := path.Join("results", jobID, "thumbnail.png")
+			if err := c.blobStore.Upload(ctx, thumbnailPath, "image/png", presentation.Thumbnail); err != nil {
+				log.Printf("Warning: Failed to store the thumbnail of job %s: %v", jobID, err)
+			} else {
+				result.ThumbnailPath = thumbnailPath
+			}
+		}
+		if len(presentation.Flashcards) > 0 {
+			if err := c.storeFlashcards(ctx, jobID, presentation.Flashcards, &result); err != nil {
+				log.Printf("Warning: Failed to store the flashcards of job %s: %v", jobID, err)
+			}
+		}
+		if presentation.OnePager != "" {
+			onePagerPath := path.Join("results", jobID, "one-pager.md")
+			if err := c.blobStore.Upload(ctx, onePagerPath, "text/markdown", []byte(presentation.OnePager)); err != nil {
+				log.Printf("Warning: Failed to store the executive summary of job %s: %v", jobID, err)
+			} else {
+				result.OnePagerMarkdownPath = onePagerPath
+			}
+		}
+		if presentation.OnePagerPDF != nil {
+			onePagerPath := path.Join("results", jobID, "one-pager.pdf")
+			if err := c.blobStore.Upload(ctx, onePagerPath, "application/pdf", presentation.OnePagerPDF); err != nil {
+				log.Printf("Warning: Failed to store the executive summary of job %s: %v", jobID, err)
+			} else {
+				result.OnePagerPath = onePagerPath
+			}
+		}
+		if presentation.Handout != "" {
+			handoutPath := path.Join("results", jobID, "handout.md")
+			if err := c.blobStore.Upload(ctx, handoutPath, "text/markdown", []byte(presentation.Handout)); err != nil {
+				log.Printf("Warning: Failed to store the student handout of job %s: %v", jobID, err)
+			} else {
+				result.HandoutMarkdownPath = handoutPath
+			}
+		}
+		if presentation.HandoutPDF != nil {
+			handoutPath := path.Join("results", jobID, "handout.pdf")
+			if err := c.blobStore.Upload(ctx, handoutPath, "application/pdf", presentation.HandoutPDF); err != nil {
+				log.Printf("Warning: Failed to store the student handout of job %s: %v", jobID, err)
+			} else {
+				result.HandoutPath = handoutPath
+			}
+		}
+		if presentation.Alignment != nil {
+			if err := c.storeAlignment(ctx, jobID, presentation.Alignment, &result); err != nil {
+				log.Printf("Warning: Failed to store the transcript alignment of job %s: %v", jobID, err)
+			}
+		}
+		if len(presentation.ChunkSummaries) > 0 {
+			if err := c.storeChunkSummaries(ctx, jobID, presentation.ChunkSummaries, &result); err != nil {
+				log.Printf("Warning: Failed to store the chunk summaries of job %s: %v", jobID, err)
+			}
+		}
+		if presentation.Grounding != nil {
+			if err := c.storeGrounding(ctx, jobID, presentation.Grounding, &result); err != nil {
+				log.Printf("Warning: Failed to store the grounding report of job %s: %v", jobID, err)
+			}
+		}
+		result.PDFData, result.HTMLData = nil, nil
+	}
+
+	// Store the accessibility report as JSON so the API can serve it as is
+	if presentation.AccessibilityReport != nil {
+		reportData, err := json.Marshal(presentation.AccessibilityReport)
+		if err != nil {
+			return fmt.Errorf("failed to marshal accessibility report: %v", err)
+		}
+		result.AccessibilityReport = reportData
+	}
+
+	if err := c.jobStore.StoreResult(ctx, result); err != nil {
 		log.Printf("Failed to store result for job %s: %v", jobID, err)
 		return fmt.Errorf("failed to store result: %v", err)
 	}
-	
+
 	log.Printf("Stored result for job %s (expires at %s)", jobID, time.Unix(expiresAt, 0).Format(time.RFC3339))
 	return nil
-} 
\ No newline at end of file
+}
@@ -0,0 +1,398 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/jobs"
+	"github.com/martin226/slideitin/backend/slides-service/services/notifications"
+	"github.com/martin226/slideitin/backend/slides-service/services/slides"
+)
+
+// mockGenerator returns a canned presentation instead of calling Gemini and Marp
+type mockGenerator struct {
+	err        error
+	topic      string
+	files      []models.File
+	settings   models.SlideSettings
+	checkpoint *slides.Checkpoint
+	warnings   []string
+	flashcards []slides.Flashcard
+	onePager   string
+	handout    string
+	alignment  *slides.TranscriptAlignment
+	summaries  []slides.ChunkSummary
+	grounding  *slides.GroundingReport
+	index      *models.DocumentIndex
+	chapters   []slides.Chapter
+	refined    string // Markdown the last refinement was applied to
+	slide      int    // Slide the last feedback was given on
+}
+
+func (m *mockGenerator) GenerateSlides(
+	ctx context.Context,
+	theme string,
+	topic string,
+	files []models.File,
+	settings models.SlideSettings,
+	checkpoint *slides.Checkpoint,
+	statusUpdateFn func(stage slides.Stage, status slides.Status) error,
+	saveCheckpointFn func(checkpoint *slides.Checkpoint) error,
+) (*slides.Presentation, error) {
+	m.topic = topic
+	m.files = files
+	m.settings = settings
+	m.checkpoint = checkpoint
+	for _, stage := range []slides.Stage{slides.StageUploading, slides.StageProcessing} {
+		if err := statusUpdateFn(stage, slides.TextStatus("Working")); err != nil {
+			return nil, err
+		}
+	}
+	if err := saveCheckpointFn(&slides.Checkpoint{Markdown: "# Slides"}); err != nil {
+		return nil, err
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	if err := statusUpdateFn(slides.StageRendering, slides.NewStatus(slides.StatusFinalizing)); err != nil {
+		return nil, err
+	}
+	return &slides.Presentation{
+		PDFData:        []byte("%PDF-1.4"),
+		HTMLData:       []byte("<html></html>"),
+		ViewerHTML:     []byte("<html></html>"),
+		Thumbnail:      []byte("\x89PNG"),
+		Markdown:       "# Slides",
+		Flashcards:     m.flashcards,
+		OnePager:       m.onePager,
+		Handout:        m.handout,
+		HandoutPDF:     handoutPDF(m.handout),
+		Alignment:      m.alignment,
+		ChunkSummaries: m.summaries,
+		Grounding:      m.grounding,
+		Warnings:       m.warnings,
+	}, nil
+}
+
+// handoutPDF stands in for the rendered handout of the mock generator
+func handoutPDF(handout string) []byte {
+	if handout == "" {
+		return nil
+	}
+	return []byte("%PDF " + handout)
+}
+
+func (m *mockGenerator) RefineSlides(
+	ctx context.Context,
+	theme string,
+	markdown string,
+	instruction string,
+	settings models.SlideSettings,
+	statusUpdateFn func(stage slides.Stage, status slides.Status) error,
+) (*slides.Presentation, error) {
+	m.refined = markdown
+	if err := statusUpdateFn(slides.StageProcessing, slides.NewStatus(slides.StatusApplyingChanges)); err != nil {
+		return nil, err
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	if err := statusUpdateFn(slides.StageRendering, slides.NewStatus(slides.StatusFinalizing)); err != nil {
+		return nil, err
+	}
+	return &slides.Presentation{
+		PDFData:  []byte("%PDF-1.5"),
+		HTMLData: []byte("<html></html>"),
+		Markdown: markdown + "\n\n---\n\n# " + instruction,
+	}, nil
+}
+
+func (m *mockGenerator) RegenerateSlide(
+	ctx context.Context,
+	theme string,
+	markdown string,
+	slide int,
+	reason string,
+	settings models.SlideSettings,
+	statusUpdateFn func(stage slides.Stage, status slides.Status) error,
+) (*slides.Presentation, error) {
+	m.refined = markdown
+	m.slide = slide
+	if m.err != nil {
+		return nil, m.err
+	}
+	if err := statusUpdateFn(slides.StageRendering, slides.NewStatus(slides.StatusFinalizing)); err != nil {
+		return nil, err
+	}
+	return &slides.Presentation{
+		PDFData:  []byte("%PDF-1.5"),
+		HTMLData: []byte("<html></html>"),
+		Markdown: "# " + reason,
+	}, nil
+}
+
+func (m *mockGenerator) RenderOnePager(ctx context.Context, markdown string) ([]byte, error) {
+	return []byte("%PDF " + markdown), nil
+}
+
+func (m *mockGenerator) EstimateTokens(ctx context.Context, theme, topic string, files []models.File, settings models.SlideSettings) (*slides.TokenEstimate, error) {
+	m.topic = topic
+	m.files = files
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &slides.TokenEstimate{InputTokens: 100 * len(files), OutputTokens: 2500}, nil
+}
+
+func (m *mockGenerator) DryRun(ctx context.Context, theme, topic string, files []models.File, settings models.SlideSettings) (*slides.DryRun, error) {
+	m.topic = topic
+	m.files = files
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &slides.DryRun{
+		Prompt:          "Create a presentation about " + topic,
+		Documents:       []slides.DryRunDocument{},
+		TokenEstimate:   slides.TokenEstimate{InputTokens: 100 * len(files), OutputTokens: 2500},
+		EstimatedSlides: 8,
+	}, nil
+}
+
+func (m *mockGenerator) IndexDocument(ctx context.Context, file models.File) (*models.DocumentIndex, error) {
+	m.files = []models.File{file}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.index, nil
+}
+
+func (m *mockGenerator) SplitChapters(ctx context.Context, files []models.File) ([]slides.Chapter, error) {
+	m.files = files
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.chapters, nil
+}
+
+// testHarness wires a TaskController to the Firestore emulator and a fake GCS server
+type testHarness struct {
+	controller      *TaskController
+	router          *gin.Engine
+	firestoreClient *firestore.Client
+	storageClient   *storage.Client
+	bucketName      string
+}
+
+// newTestHarness creates the harness, skipping the test when the emulators are not configured
+func newTestHarness(t *testing.T, generator Generator) *testHarness {
+	t.Helper()
+
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" || os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST and STORAGE_EMULATOR_HOST must be set to run integration tests")
+	}
+
+	ctx := context.Background()
+	firestoreClient, err := firestore.NewClient(ctx, "slideitin-test")
+	if err != nil {
+		t.Fatalf("Failed to create Firestore client: %v", err)
+	}
+	t.Cleanup(func() { firestoreClient.Close() })
+
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create Cloud Storage client: %v", err)
+	}
+	t.Cleanup(func() { storageClient.Close() })
+
+	bucketName := "slideitin-test-files"
+	bucket := storageClient.Bucket(bucketName)
+	if _, err := bucket.Attrs(ctx); errors.Is(err, storage.ErrBucketNotExist) {
+		if err := bucket.Create(ctx, "slideitin-test", nil); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+
+	controller := NewTaskController(
+		generator,
+		notifications.NewEmailService("", "", ""),
+		notifications.NewWebhookService(""),
+		jobs.NewFirestoreJobStore(firestoreClient, firestoreClient, nil),
+		jobs.NewGCSBlobStore(storageClient, bucketName, ""),
+		nil,
+		nil,
+		nil,
+	)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/tasks/process-slides", controller.ProcessSlides)
+
+	return &testHarness{
+		controller:      controller,
+		router:          router,
+		firestoreClient: firestoreClient,
+		storageClient:   storageClient,
+		bucketName:      bucketName,
+	}
+}
+
+// enqueue creates a queued job and its uploaded file, as the API does, and returns the task payload
+func (h *testHarness) enqueue(t *testing.T, jobID string) TaskPayload {
+	t.Helper()
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	_, err := h.firestoreClient.Collection("jobs").Doc(jobID).Set(ctx, jobs.FirestoreJob{
+		ID:        jobID,
+		Status:    "queued",
+		Message:   "Job added to queue",
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+
+	gcsPath := jobID + "/notes.md"
+	w := h.storageClient.Bucket(h.bucketName).Object(gcsPath).NewWriter(ctx)
+	w.ContentType = "text/plain"
+	if _, err := w.Write([]byte("# Notes\n\n- one\n- two")); err != nil {
+		t.Fatalf("Failed to upload file: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to upload file: %v", err)
+	}
+
+	return TaskPayload{
+		JobID:    jobID,
+		Theme:    "default",
+		Files:    []FileReference{{Filename: "notes.md", Type: "text/plain", GCSPath: gcsPath}},
+		Settings: models.SlideSettings{SlideDetail: "medium", Audience: "general"},
+	}
+}
+
+// process sends a task payload to the controller as Cloud Tasks would
+func (h *testHarness) process(t *testing.T, payload TaskPayload) *httptest.ResponseRecorder {
+	t.Helper()
+	return h.post(t, "/tasks/process-slides", payload)
+}
+
+// post sends a task payload to a task route
+func (h *testHarness) post(t *testing.T, path string, payload interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Failed to marshal payload: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// job reads the current state of a job
+func (h *testHarness) job(t *testing.T, jobID string) jobs.FirestoreJob {
+	t.Helper()
+	doc, err := h.firestoreClient.Collection("jobs").Doc(jobID).Get(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	var job jobs.FirestoreJob
+	if err := doc.DataTo(&job); err != nil {
+		t.Fatalf("Failed to parse job: %v", err)
+	}
+	return job
+}
+
+func TestProcessSlidesCompletesJob(t *testing.T) {
+	generator := &mockGenerator{}
+	h := newTestHarness(t, generator)
+	jobID := "job-" + time.Now().Format("20060102150405.000000000")
+	payload := h.enqueue(t, jobID)
+
+	rec := h.process(t, payload)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The generator received the downloaded file
+	if len(generator.files) != 1 || string(generator.files[0].Data) != "# Notes\n\n- one\n- two" {
+		t.Fatalf("unexpected files passed to the generator: %+v", generator.files)
+	}
+
+	// The job is completed and set to expire
+	job := h.job(t, jobID)
+	if job.Status != "completed" || job.ExpiresAt == 0 {
+		t.Fatalf("expected a completed job with an expiry, got %+v", job)
+	}
+	if job.MessageCode != "completed" || job.Message != "Slides generated successfully" {
+		t.Fatalf("expected the completion message with its code, got %q (%q)", job.Message, job.MessageCode)
+	}
+
+	// The result is stored
+	doc, err := h.firestoreClient.Collection("results").Doc(jobID).Get(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get result: %v", err)
+	}
+	var result jobs.FirestoreResult
+	if err := doc.DataTo(&result); err != nil {
+		t.Fatalf("Failed to parse result: %v", err)
+	}
+	if result.ResultURL != "/results/"+jobID || result.PDFPath != "results/"+jobID+"/presentation.pdf" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if _, err := h.storageClient.Bucket(h.bucketName).Object(result.PDFPath).Attrs(context.Background()); err != nil {
+		t.Fatalf("expected the PDF to be stored in Cloud Storage, got %v", err)
+	}
+
+	// The uploaded file is cleaned up
+	_, err = h.storageClient.Bucket(h.bucketName).Object(payload.Files[0].GCSPath).Attrs(context.Background())
+	if !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Fatalf("expected the uploaded file to be deleted, got %v", err)
+	}
+}
+
+func TestProcessSlidesFailsJob(t *testing.T) {
+	h := newTestHarness(t, &mockGenerator{err: errors.New("model unavailable")})
+	jobID := "job-" + time.Now().Format("20060102150405.000000000")
+	payload := h.enqueue(t, jobID)
+
+	rec := h.process(t, payload)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+
+	job := h.job(t, jobID)
+	if job.Status != "failed" {
+		t.Fatalf("expected a failed job, got %+v", job)
+	}
+}
+
+func TestProcessSlidesMissingFile(t *testing.T) {
+	h := newTestHarness(t, &mockGenerator{})
+	jobID := "job-" + time.Now().Format("20060102150405.000000000")
+	payload := h.enqueue(t, jobID)
+	payload.Files[0].GCSPath = jobID + "/missing.md"
+
+	rec := h.process(t, payload)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+
+	job := h.job(t, jobID)
+	if job.Status != "failed" {
+		t.Fatalf("expected a failed job, got %+v", job)
+	}
+}
@@ -0,0 +1,1016 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/analytics"
+	"github.com/martin226/slideitin/backend/slides-service/services/jobs"
+	"github.com/martin226/slideitin/backend/slides-service/services/notifications"
+	"github.com/martin226/slideitin/backend/slides-service/services/slides"
+)
+
+// memoryJobStore is an in-memory JobStore
+type memoryJobStore struct {
+	mu        sync.Mutex
+	jobs      map[string]map[string]interface{}
+	results   map[string]jobs.FirestoreResult
+	decks     map[string]jobs.FirestoreDeck
+	revisions map[string][]jobs.FirestoreRevision
+	captures  map[string]jobs.FirestoreCapture
+	documents map[string]map[string]interface{} // Library documents by workspace and ID
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{
+		jobs:      make(map[string]map[string]interface{}),
+		results:   make(map[string]jobs.FirestoreResult),
+		decks:     make(map[string]jobs.FirestoreDeck),
+		revisions: make(map[string][]jobs.FirestoreRevision),
+		captures:  make(map[string]jobs.FirestoreCapture),
+		documents: make(map[string]map[string]interface{}),
+	}
+}
+
+func (m *memoryJobStore) GetJob(ctx context.Context, id string) (*jobs.FirestoreJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fields, ok := m.jobs[id]
+	if !ok {
+		return nil, jobs.ErrNotFound
+	}
+	job := &jobs.FirestoreJob{ID: id}
+	job.Status, _ = fields["status"].(string)
+	job.Message, _ = fields["message"].(string)
+	job.Checkpoint, _ = fields["checkpoint"].(*slides.Checkpoint)
+	return job, nil
+}
+
+func (m *memoryJobStore) UpdateJob(ctx context.Context, id string, fields map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return jobs.ErrNotFound
+	}
+	for path, value := range fields {
+		job[path] = value
+	}
+	return nil
+}
+
+func (m *memoryJobStore) TransitionJob(ctx context.Context, id string, status jobs.JobStatus, fields map[string]interface{}) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return jobs.ErrNotFound
+	}
+	from, _ := job["status"].(string)
+	if !jobs.JobStatus(from).CanTransition(status) {
+		return fmt.Errorf("%w from %s to %s", jobs.ErrIllegalTransition, from, status)
+	}
+	fields["status"] = string(status)
+	return m.UpdateJob(ctx, id, fields)
+}
+
+func (m *memoryJobStore) StoreResult(ctx context.Context, result jobs.FirestoreResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.results[result.ID]; ok {
+		result.Replaces(existing)
+	}
+	m.results[result.ID] = result
+	return nil
+}
+
+func (m *memoryJobStore) AddRevision(ctx context.Context, deck jobs.FirestoreDeck, revision jobs.FirestoreRevision) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revision.Number = len(m.revisions[deck.ID]) + 1
+	deck.Revision = revision.Number
+	m.decks[deck.ID] = deck
+	m.revisions[deck.ID] = append(m.revisions[deck.ID], revision)
+	return revision.Number, nil
+}
+
+func (m *memoryJobStore) GetDeck(ctx context.Context, id string) (*jobs.FirestoreDeck, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deck, ok := m.decks[id]
+	if !ok {
+		return nil, jobs.ErrNotFound
+	}
+	return &deck, nil
+}
+
+func (m *memoryJobStore) GetRevision(ctx context.Context, id string, number int) (*jobs.FirestoreRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revisions := m.revisions[id]
+	if number < 1 || number > len(revisions) {
+		return nil, jobs.ErrNotFound
+	}
+	revision := revisions[number-1]
+	return &revision, nil
+}
+
+func (m *memoryJobStore) StoreCapture(ctx context.Context, capture jobs.FirestoreCapture) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.captures[capture.ID] = capture
+	return nil
+}
+
+func (m *memoryJobStore) UpdateLibraryDocument(ctx context.Context, workspaceID, documentID string, fields map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	document, ok := m.documents[workspaceID+"/"+documentID]
+	if !ok {
+		return jobs.ErrNotFound
+	}
+	for path, value := range fields {
+		document[path] = value
+	}
+	return nil
+}
+
+// memoryBlobStore is an in-memory BlobStore
+type memoryBlobStore struct {
+	files map[string][]byte
+}
+
+func (m *memoryBlobStore) Download(ctx context.Context, path string) ([]byte, string, error) {
+	data, ok := m.files[path]
+	if !ok {
+		return nil, "", jobs.ErrNotFound
+	}
+	return data, "text/plain", nil
+}
+
+func (m *memoryBlobStore) Upload(ctx context.Context, path, contentType string, data []byte) error {
+	m.files[path] = data
+	return nil
+}
+
+func (m *memoryBlobStore) Delete(ctx context.Context, path string) error {
+	delete(m.files, path)
+	return nil
+}
+
+// newTestController creates a harness backed by in-memory stores with one queued job
+func newTestController(generator Generator) (*testHarness, *memoryJobStore, *memoryBlobStore) {
+	jobStore := newMemoryJobStore()
+	jobStore.jobs["job-1"] = map[string]interface{}{"status": "queued"}
+	blobStore := &memoryBlobStore{files: map[string][]byte{"job-1/notes.md": []byte("# Notes")}}
+
+	controller := NewTaskController(generator, notifications.NewEmailService("", "", ""), notifications.NewWebhookService(""), jobStore, blobStore, nil, nil, nil)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/tasks/process-slides", controller.ProcessSlides)
+	router.POST("/tasks/refine-slides", controller.RefineSlides)
+	router.POST("/tasks/estimate-tokens", controller.EstimateTokens)
+	router.POST("/tasks/dry-run", controller.DryRun)
+	router.POST("/tasks/index-document", controller.IndexDocument)
+	router.POST("/tasks/split-chapters", controller.SplitChapters)
+
+	return &testHarness{controller: controller, router: router}, jobStore, blobStore
+}
+
+func testPayload() TaskPayload {
+	return TaskPayload{
+		JobID: "job-1",
+		Theme: "default",
+		Files: []FileReference{{Filename: "notes.md", Type: "text/plain", GCSPath: "job-1/notes.md"}},
+	}
+}
+
+func TestProcessSlidesStoresResult(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, blobStore := newTestController(generator)
+
+	rec := h.process(t, testPayload())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if len(generator.files) != 1 || string(generator.files[0].Data) != "# Notes" {
+		t.Fatalf("unexpected files passed to the generator: %+v", generator.files)
+	}
+	if status := jobStore.jobs["job-1"]["status"]; status != "completed" {
+		t.Fatalf("expected a completed job, got %v", status)
+	}
+	result, ok := jobStore.results["job-1"]
+	if !ok || result.ResultURL != "/results/job-1" || result.PDFPath != "results/job-1/presentation.pdf" || len(result.PDFData) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if string(blobStore.files[result.PDFPath]) != "%PDF-1.4" || string(blobStore.files[result.HTMLPath]) != "<html></html>" || string(blobStore.files[result.ViewerPath]) != "<html></html>" || string(blobStore.files[result.ThumbnailPath]) != "\x89PNG" {
+		t.Fatalf("expected the documents to be stored in Cloud Storage, got %v", blobStore.files)
+	}
+	if _, ok := blobStore.files["job-1/notes.md"]; ok {
+		t.Fatal("expected the uploaded file to be deleted")
+	}
+}
+
+func TestProcessSlidesStoresFlashcards(t *testing.T) {
+	generator := &mockGenerator{flashcards: []slides.Flashcard{{Term: "Latency", Definition: "Time taken, in ms"}}}
+	h, jobStore, blobStore := newTestController(generator)
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	result := jobStore.results["job-1"]
+	expected := "#separator:comma\n#html:false\n#columns:Term,Definition\nLatency,\"Time taken, in ms\"\n"
+	if result.FlashcardsPath != "results/job-1/flashcards.csv" || string(blobStore.files[result.FlashcardsPath]) != expected {
+		t.Fatalf("expected the flashcards to be stored as CSV, got %+v", result)
+	}
+
+	// Refinements keep the flashcards of the deck
+	delete(blobStore.files, result.FlashcardsPath)
+	jobStore.jobs["job-1"]["status"] = "queued"
+	if rec := h.post(t, "/tasks/refine-slides", RefinePayload{JobID: "job-1", Revision: 1, Instruction: "Shorten"}); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if result := jobStore.results["job-1"]; string(blobStore.files[result.FlashcardsPath]) != expected {
+		t.Fatalf("expected the refined result to keep the flashcards, got %+v", result)
+	}
+}
+
+func TestProcessSlidesStoresOnePager(t *testing.T) {
+	generator := &mockGenerator{onePager: "# Summary"}
+	h, jobStore, blobStore := newTestController(generator)
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	result := jobStore.results["job-1"]
+	if result.OnePagerMarkdownPath != "results/job-1/one-pager.md" || string(blobStore.files[result.OnePagerMarkdownPath]) != "# Summary" {
+		t.Fatalf("expected the executive summary to be stored, got %+v", result)
+	}
+	if result.OnePagerPath != "" {
+		t.Fatalf("expected no PDF for a summary that wasn't rendered, got %+v", result)
+	}
+
+	// Refinements render the executive summary of the deck again
+	jobStore.jobs["job-1"]["status"] = "queued"
+	if rec := h.post(t, "/tasks/refine-slides", RefinePayload{JobID: "job-1", Revision: 1, Instruction: "Shorten"}); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	result = jobStore.results["job-1"]
+	if string(blobStore.files[result.OnePagerPath]) != "%PDF # Summary" || string(blobStore.files[result.OnePagerMarkdownPath]) != "# Summary" {
+		t.Fatalf("expected the refined result to keep the executive summary, got %+v", result)
+	}
+}
+
+func TestProcessSlidesStoresHandout(t *testing.T) {
+	generator := &mockGenerator{handout: "# Handout"}
+	h, jobStore, blobStore := newTestController(generator)
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	result := jobStore.results["job-1"]
+	if string(blobStore.files[result.HandoutPath]) != "%PDF # Handout" || string(blobStore.files[result.HandoutMarkdownPath]) != "# Handout" {
+		t.Fatalf("expected the student handout to be stored, got %+v", result)
+	}
+}
+
+func TestProcessSlidesStoresTranscriptAlignment(t *testing.T) {
+	generator := &mockGenerator{alignment: &slides.TranscriptAlignment{Transcripts: []slides.TranscriptTimings{
+		{Filename: "talk.vtt", Duration: 90, Slides: []slides.SlideTiming{{Slide: 1, Title: "Slides", Start: 0, End: 90}}},
+	}}}
+	h, jobStore, blobStore := newTestController(generator)
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	result := jobStore.results["job-1"]
+	expected := `{"transcripts":[{"filename":"talk.vtt","duration":90,"slides":[{"slide":1,"title":"Slides","start":0,"end":90}]}]}`
+	if result.AlignmentPath != "results/job-1/alignment.json" || string(blobStore.files[result.AlignmentPath]) != expected {
+		t.Fatalf("expected the alignment to be stored as JSON, got %+v", result)
+	}
+}
+
+func TestProcessSlidesStoresChunkSummaries(t *testing.T) {
+	generator := &mockGenerator{summaries: []slides.ChunkSummary{
+		{Section: "report.pdf: Pricing", Document: "report.pdf", Characters: 5200, Relevance: 1.21, Summary: "Prices rise 5% in March."},
+	}}
+	h, jobStore, blobStore := newTestController(generator)
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	result := jobStore.results["job-1"]
+	expected := `[{"section":"report.pdf: Pricing","document":"report.pdf","characters":5200,"relevance":1.21,"summary":"Prices rise 5% in March."}]`
+	if result.ChunkSummariesPath != "results/job-1/chunk-summaries.json" || string(blobStore.files[result.ChunkSummariesPath]) != expected {
+		t.Fatalf("expected the chunk summaries to be stored as JSON, got %+v", result)
+	}
+	if deck := jobStore.decks["job-1"]; len(deck.ChunkSummaries) != 1 {
+		t.Fatalf("expected the deck to keep the chunk summaries for refinements, got %+v", deck)
+	}
+}
+
+func TestProcessSlidesStoresGroundingReport(t *testing.T) {
+	generator := &mockGenerator{grounding: &slides.GroundingReport{
+		Claims:      []slides.GroundedClaim{{Slide: 2, Text: "Prices rise 8% in March", Reason: "The report says 5%"}},
+		Unsupported: 1,
+	}}
+	h, jobStore, blobStore := newTestController(generator)
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	result := jobStore.results["job-1"]
+	expected := `{"claims":[{"slide":2,"text":"Prices rise 8% in March","supported":false,"reason":"The report says 5%"}],"unsupported":1}`
+	if result.GroundingPath != "results/job-1/grounding.json" || string(blobStore.files[result.GroundingPath]) != expected {
+		t.Fatalf("expected the grounding report to be stored as JSON, got %+v", result)
+	}
+}
+
+func TestProcessSlidesKeepsFilesStoredByContent(t *testing.T) {
+	generator := &mockGenerator{}
+	h, _, blobStore := newTestController(generator)
+	blobStore.files["content/abc123"] = []byte("# Notes")
+
+	payload := testPayload()
+	payload.Files = []FileReference{{Filename: "notes.md", Type: "text/plain", GCSPath: "content/abc123", Hash: "abc123"}}
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(generator.files) != 1 || generator.files[0].Hash != "abc123" {
+		t.Fatalf("expected the content hash to reach the generator, got %+v", generator.files)
+	}
+	if _, ok := blobStore.files["content/abc123"]; !ok {
+		t.Fatal("expected the shared file to be kept for other jobs")
+	}
+}
+
+func TestProcessSlidesLoadsLibraryIndex(t *testing.T) {
+	generator := &mockGenerator{}
+	h, _, blobStore := newTestController(generator)
+	blobStore.files["library/ws-1/abc123"] = []byte("# Handbook")
+	blobStore.files["library/ws-1/abc123.index.json"] = []byte(`{"model":"text-embedding-004","passages":[{"title":"Handbook","text":"Welcome","vector":[1,0]}]}`)
+
+	payload := testPayload()
+	payload.Files = []FileReference{
+		{Filename: "handbook.md", Type: "text/plain", GCSPath: "library/ws-1/abc123", Hash: "abc123", IndexPath: "library/ws-1/abc123.index.json"},
+		{Filename: "notes.md", Type: "text/plain", GCSPath: "job-1/notes.md"},
+	}
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(generator.files) != 2 || generator.files[1].Index != nil {
+		t.Fatalf("expected only the library document to have an index, got %+v", generator.files)
+	}
+	index := generator.files[0].Index
+	if index == nil || index.Model != "text-embedding-004" || len(index.Passages) != 1 || index.Passages[0].Text != "Welcome" {
+		t.Fatalf("expected the stored index of the library document, got %+v", index)
+	}
+	if _, ok := blobStore.files["library/ws-1/abc123"]; !ok {
+		t.Fatal("expected the library document to be kept")
+	}
+}
+
+func TestIndexDocumentStoresIndex(t *testing.T) {
+	generator := &mockGenerator{index: &models.DocumentIndex{
+		Model:    "text-embedding-004",
+		Passages: []models.IndexedPassage{{Title: "Handbook", Text: "Welcome", Vector: []float32{1, 0}}},
+	}}
+	h, jobStore, blobStore := newTestController(generator)
+	blobStore.files["library/ws-1/abc123"] = []byte("# Handbook\n\nWelcome")
+	jobStore.documents["ws-1/abc123"] = map[string]interface{}{"status": "indexing"}
+
+	payload := IndexPayload{
+		WorkspaceID: "ws-1",
+		DocumentID:  "abc123",
+		File:        FileReference{Filename: "handbook.md", Type: "text/markdown", GCSPath: "library/ws-1/abc123", Hash: "abc123"},
+		IndexPath:   "library/ws-1/abc123.index.json",
+	}
+	if rec := h.post(t, "/tasks/index-document", payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(generator.files) != 1 || generator.files[0].Type != "text/markdown" || generator.files[0].Hash != "abc123" {
+		t.Fatalf("expected the library document to be indexed, got %+v", generator.files)
+	}
+	var stored models.DocumentIndex
+	if err := json.Unmarshal(blobStore.files[payload.IndexPath], &stored); err != nil || len(stored.Passages) != 1 {
+		t.Fatalf("expected the index to be stored as JSON, got %s", blobStore.files[payload.IndexPath])
+	}
+	document := jobStore.documents["ws-1/abc123"]
+	if document["status"] != jobs.DocumentReady || document["indexPath"] != payload.IndexPath || document["passages"] != 1 {
+		t.Fatalf("expected the document to be ready, got %v", document)
+	}
+
+	// A document that can't be indexed is marked as failed for the API to report
+	generator.err = errors.New("no text found")
+	if rec := h.post(t, "/tasks/index-document", payload); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+	if document["status"] != jobs.DocumentFailed || !strings.Contains(document["error"].(string), "no text found") {
+		t.Fatalf("expected the document to be marked as failed, got %v", document)
+	}
+
+	// A document deleted before its task ran is skipped
+	delete(blobStore.files, payload.File.GCSPath)
+	if rec := h.post(t, "/tasks/index-document", payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected a deleted document to be skipped, got %d", rec.Code)
+	}
+}
+
+func TestProcessSlidesEphemeralKeepsNoDeck(t *testing.T) {
+	h, jobStore, blobStore := newTestController(&mockGenerator{})
+
+	payload := testPayload()
+	payload.Ephemeral = true
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	result := jobStore.results["job-1"]
+	if !result.Ephemeral || result.ExpiresAt-result.CreatedAt != 300 {
+		t.Fatalf("expected an ephemeral result expiring with the job, got %+v", result)
+	}
+	if _, ok := jobStore.decks["job-1"]; ok {
+		t.Fatal("expected no deck to be kept")
+	}
+	if _, ok := blobStore.files["job-1/notes.md"]; ok {
+		t.Fatal("expected the uploaded file to be deleted")
+	}
+}
+
+func TestProcessSlidesReportsWarnings(t *testing.T) {
+	h, jobStore, _ := newTestController(&mockGenerator{warnings: []string{"Documents were too long, so these sections were left out: notes.md: Appendix"}})
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	want := "Slides generated successfully. Documents were too long, so these sections were left out: notes.md: Appendix"
+	if message := jobStore.jobs["job-1"]["message"]; message != want {
+		t.Fatalf("expected the warning in the job message, got %v", message)
+	}
+}
+
+func TestProcessSlidesLeavesOutFilesThatFailToDownload(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, _ := newTestController(generator)
+
+	payload := testPayload()
+	payload.Files = append(payload.Files, FileReference{Filename: "missing.pdf", Type: "application/pdf", GCSPath: "job-1/missing.pdf"})
+	payload.Warnings = []string{"File broken.pdf couldn't be uploaded and was left out: bucket unavailable"}
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(generator.files) != 1 || generator.files[0].Filename != "notes.md" {
+		t.Fatalf("expected only notes.md to reach the generator, got %+v", generator.files)
+	}
+
+	expected := []string{
+		"File broken.pdf couldn't be uploaded and was left out: bucket unavailable",
+		"File missing.pdf couldn't be downloaded and was left out: not found",
+	}
+	if warnings := jobStore.results["job-1"].Warnings; strings.Join(warnings, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected the result warnings %v, got %v", expected, warnings)
+	}
+	if warnings, _ := jobStore.jobs["job-1"]["warnings"].([]string); strings.Join(warnings, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected the job warnings %v, got %v", expected, warnings)
+	}
+}
+
+func TestProcessSlidesFailsWhenNoFileCanBeDownloaded(t *testing.T) {
+	h, jobStore, _ := newTestController(&mockGenerator{})
+
+	payload := testPayload()
+	payload.Files[0].GCSPath = "job-1/missing.md"
+	if rec := h.process(t, payload); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+	if status := jobStore.jobs["job-1"]["status"]; status != "failed" {
+		t.Fatalf("expected a failed job, got %v", status)
+	}
+}
+
+func TestProcessSlidesMarksGenerationFailure(t *testing.T) {
+	h, jobStore, _ := newTestController(&mockGenerator{err: errors.New("model unavailable")})
+
+	rec := h.process(t, testPayload())
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+	if status := jobStore.jobs["job-1"]["status"]; status != "failed" {
+		t.Fatalf("expected a failed job, got %v", status)
+	}
+	if _, ok := jobStore.results["job-1"]; ok {
+		t.Fatal("expected no result to be stored")
+	}
+}
+
+func TestProcessSlidesReportsTimeout(t *testing.T) {
+	h, jobStore, _ := newTestController(&mockGenerator{err: slides.ErrTimeout})
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+	if message := jobStore.jobs["job-1"]["message"]; message != "Generation timed out. Please try again." {
+		t.Fatalf("expected a timeout message, got %v", message)
+	}
+}
+
+func TestProcessSlidesDefersWhenBusy(t *testing.T) {
+	h, jobStore, blobStore := newTestController(&mockGenerator{err: slides.ErrBusy})
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", rec.Code)
+	}
+	if status := jobStore.jobs["job-1"]["status"]; status != "queued" {
+		t.Fatalf("expected the job to be queued again for a retry, got %v", status)
+	}
+	if _, ok := blobStore.files["job-1/notes.md"]; !ok {
+		t.Fatal("expected the uploaded file to be kept for the retry")
+	}
+}
+
+// memoryExporter keeps the exported job records
+type memoryExporter struct {
+	records []analytics.JobRecord
+}
+
+func (m *memoryExporter) Export(ctx context.Context, record analytics.JobRecord) error {
+	m.records = append(m.records, record)
+	return nil
+}
+
+func TestProcessSlidesExportsJobRecord(t *testing.T) {
+	h, _, _ := newTestController(&mockGenerator{err: errors.New("model unavailable")})
+	exporter := &memoryExporter{}
+	h.controller.exporter = exporter
+
+	payload := testPayload()
+	payload.Settings.SlideDetail = "minimal"
+	h.process(t, payload)
+	if len(exporter.records) != 1 {
+		t.Fatalf("expected one exported record, got %d", len(exporter.records))
+	}
+	record := exporter.records[0]
+	if record.Kind != analytics.KindGenerate || record.Status != "failed" || !strings.Contains(record.Error, "model unavailable") {
+		t.Fatalf("unexpected outcome: %+v", record)
+	}
+	if record.Settings.SlideDetail != "minimal" || record.Inputs != 1 || record.FinishedAt.Before(record.StartedAt) {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+}
+
+func TestProcessSlidesDoesNotExportDeferredJob(t *testing.T) {
+	h, _, _ := newTestController(&mockGenerator{err: slides.ErrBusy})
+	exporter := &memoryExporter{}
+	h.controller.exporter = exporter
+
+	h.process(t, testPayload())
+	if len(exporter.records) != 0 {
+		t.Fatalf("expected a job handed back to the queue not to be exported, got %+v", exporter.records)
+	}
+}
+
+func TestProcessSlidesResumesFromCheckpoint(t *testing.T) {
+	generator := &mockGenerator{err: errors.New("render failed")}
+	h, jobStore, _ := newTestController(generator)
+
+	// The first attempt fails after saving a checkpoint
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+	if generator.checkpoint != nil {
+		t.Fatalf("expected no checkpoint on the first attempt, got %+v", generator.checkpoint)
+	}
+
+	// The retry receives the saved checkpoint
+	generator.err = nil
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if generator.checkpoint == nil || generator.checkpoint.Markdown != "# Slides" {
+		t.Fatalf("expected the retry to resume from the checkpoint, got %+v", generator.checkpoint)
+	}
+	if status := jobStore.jobs["job-1"]["status"]; status != "completed" {
+		t.Fatalf("expected a completed job, got %v", status)
+	}
+}
+
+func TestProcessSlidesWithoutBlobStore(t *testing.T) {
+	controller := NewTaskController(&mockGenerator{}, notifications.NewEmailService("", "", ""), notifications.NewWebhookService(""), newMemoryJobStore(), nil, nil, nil, nil)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/tasks/process-slides", controller.ProcessSlides)
+	h := &testHarness{controller: controller, router: router}
+
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+}
+
+// mockDriveFetcher returns a fixed file for every Drive file ID
+type mockDriveFetcher struct {
+	owner string
+	err   error
+}
+
+func (m *mockDriveFetcher) Fetch(ctx context.Context, owner string, fileIDs []string, maxBytes int) ([]models.File, error) {
+	m.owner = owner
+	if m.err != nil {
+		return nil, m.err
+	}
+	files := make([]models.File, 0, len(fileIDs))
+	for _, id := range fileIDs {
+		files = append(files, models.File{Filename: id + ".pdf", Data: []byte("%PDF-1.7"), Type: "application/pdf"})
+	}
+	return files, nil
+}
+
+func TestProcessSlidesDownloadsDriveFiles(t *testing.T) {
+	generator := &mockGenerator{}
+	h, _, _ := newTestController(generator)
+	fetcher := &mockDriveFetcher{}
+	h.controller.driveFetcher = fetcher
+
+	payload := testPayload()
+	payload.Drive = &DriveFiles{Owner: "firebase:user-1", FileIDs: []string{"1AbCdEfGhIj"}, MaxFileBytes: 2 << 20}
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if fetcher.owner != "firebase:user-1" {
+		t.Fatalf("expected the Drive files to be fetched for the owner, got %q", fetcher.owner)
+	}
+	if len(generator.files) != 2 || generator.files[1].Filename != "1AbCdEfGhIj.pdf" {
+		t.Fatalf("expected the upload and the Drive file, got %+v", generator.files)
+	}
+}
+
+func TestProcessSlidesFailsWithoutDriveConnection(t *testing.T) {
+	h, jobStore, _ := newTestController(&mockGenerator{})
+	h.controller.driveFetcher = &mockDriveFetcher{err: errors.New("Google Drive is not connected")}
+
+	payload := testPayload()
+	payload.Files = nil
+	payload.Drive = &DriveFiles{Owner: "key-1", FileIDs: []string{"1AbCdEfGhIj"}}
+	if rec := h.process(t, payload); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+	if status := jobStore.jobs["job-1"]["status"]; status != "failed" {
+		t.Fatalf("expected a failed job, got %v", status)
+	}
+
+	// Alongside uploads, the Drive files are left out instead
+	jobStore.jobs["job-1"] = map[string]interface{}{"status": "queued"}
+	payload.Files = testPayload().Files
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if warnings := jobStore.results["job-1"].Warnings; len(warnings) != 1 || !strings.HasPrefix(warnings[0], "Google Drive files couldn't be downloaded") {
+		t.Fatalf("expected a warning about the Drive files, got %v", warnings)
+	}
+}
+
+// mockContentSources returns a markdown page for every location
+type mockContentSources struct{}
+
+func (mockContentSources) Fetch(ctx context.Context, sourceType, location string, options map[string]string, maxBytes int) ([]models.File, error) {
+	if sourceType != "confluence" {
+		return nil, errors.New("the " + sourceType + " content source is not configured")
+	}
+	return []models.File{{Filename: "Onboarding.md", Data: []byte("# Onboarding"), Type: "text/plain"}}, nil
+}
+
+func TestProcessSlidesImportsContentSources(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, _ := newTestController(generator)
+	h.controller.contentSources = mockContentSources{}
+
+	payload := testPayload()
+	payload.Sources = []SourceReference{{Type: "confluence", Location: "https://acme.atlassian.net/wiki/spaces/ENG/pages/1"}}
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(generator.files) != 2 || generator.files[1].Filename != "Onboarding.md" {
+		t.Fatalf("expected the upload and the imported page, got %+v", generator.files)
+	}
+
+	jobStore.jobs["job-1"] = map[string]interface{}{"status": "queued"}
+	payload.Files = nil
+	payload.Sources = []SourceReference{{Type: "sharepoint", Location: "https://acme.sharepoint.com/Handbook.docx"}}
+	if rec := h.process(t, payload); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500 for an unconfigured source, got %d", rec.Code)
+	}
+	if message, _ := jobStore.jobs["job-1"]["message"].(string); !strings.Contains(message, "not configured") {
+		t.Fatalf("expected the job to fail with the source error, got %q", message)
+	}
+}
+
+func TestProcessSlidesFromPrompt(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, _ := newTestController(generator)
+
+	payload := testPayload()
+	payload.Files = nil
+	payload.Prompt = "Intro to Kubernetes for beginners, 12 slides"
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if generator.topic != payload.Prompt || len(generator.files) != 0 {
+		t.Fatalf("expected the prompt without files, got %q and %+v", generator.topic, generator.files)
+	}
+	if status := jobStore.jobs["job-1"]["status"]; status != "completed" {
+		t.Fatalf("expected a completed job, got %v", status)
+	}
+}
+
+func TestProcessSlidesAppliesFeatureFlags(t *testing.T) {
+	tests := []struct {
+		features  map[string]bool
+		chunked   bool
+		injection bool
+	}{
+		{nil, true, false}, // Tasks queued before the flag keep chunked mode
+		{map[string]bool{"chunked_mode": true}, true, false},
+		{map[string]bool{"chunked_mode": false}, false, false},
+		{map[string]bool{"injection_detection": true}, true, true},
+	}
+
+	for _, test := range tests {
+		generator := &mockGenerator{}
+		h, _, _ := newTestController(generator)
+
+		payload := testPayload()
+		payload.Features = test.features
+		if rec := h.process(t, payload); rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if generator.settings.ChunkedMode != test.chunked {
+			t.Errorf("features %v: expected chunked mode %t, got %t", test.features, test.chunked, generator.settings.ChunkedMode)
+		}
+		if generator.settings.InjectionDetection != test.injection {
+			t.Errorf("features %v: expected injection detection %t, got %t", test.features, test.injection, generator.settings.InjectionDetection)
+		}
+	}
+}
+
+func TestRefineSlidesAddsRevision(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, blobStore := newTestController(generator)
+
+	payload := testPayload()
+	payload.Owner = "firebase:user-1"
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if deck := jobStore.decks["job-1"]; deck.Revision != 1 || deck.Owner != "firebase:user-1" {
+		t.Fatalf("expected the first revision to be stored, got %+v", deck)
+	}
+
+	jobStore.jobs["job-1"]["status"] = "queued"
+	rec := h.post(t, "/tasks/refine-slides", RefinePayload{JobID: "job-1", Revision: 1, Instruction: "Add a slide on pricing"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if generator.refined != "# Slides" {
+		t.Fatalf("expected the first revision to be refined, got %q", generator.refined)
+	}
+	revision, err := jobStore.GetRevision(context.Background(), "job-1", 2)
+	if err != nil || revision.BaseRevision != 1 || revision.Instruction != "Add a slide on pricing" || !strings.Contains(revision.Markdown, "pricing") {
+		t.Fatalf("unexpected second revision: %+v, %v", revision, err)
+	}
+	if message := jobStore.jobs["job-1"]["message"]; message != "Revision 2 created" {
+		t.Fatalf("expected a completed refinement, got %v", message)
+	}
+	if result := jobStore.results["job-1"]; string(blobStore.files[result.PDFPath]) != "%PDF-1.5" {
+		t.Fatalf("expected the result to be replaced, got %+v", result)
+	}
+}
+
+func TestRefineSlidesKeepsSharedResultAlive(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, _ := newTestController(generator)
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A share link extended the result, which was then downloaded
+	sharedUntil := time.Now().Add(7 * 24 * time.Hour).Unix()
+	result := jobStore.results["job-1"]
+	result.ExpiresAt = sharedUntil
+	result.Downloads = 3
+	result.LastAccessedAt = 1700000000
+	jobStore.results["job-1"] = result
+
+	jobStore.jobs["job-1"]["status"] = "queued"
+	if rec := h.post(t, "/tasks/refine-slides", RefinePayload{JobID: "job-1", Revision: 1, Instruction: "Shorter"}); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	refined := jobStore.results["job-1"]
+	if refined.ExpiresAt != sharedUntil || refined.Downloads != 3 || refined.LastAccessedAt != 1700000000 {
+		t.Fatalf("expected the expiry and counters of the shared result kept, got %+v", refined)
+	}
+}
+
+func TestClaimOfAnonymousJobIsKeptWithResultAndDeck(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, _ := newTestController(generator)
+
+	payload := testPayload()
+	payload.ClaimTokenHash = "claim-hash"
+	if rec := h.process(t, payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if result := jobStore.results["job-1"]; result.ClaimTokenHash != "claim-hash" {
+		t.Fatalf("expected the claim kept with the result, got %q", result.ClaimTokenHash)
+	}
+	if deck := jobStore.decks["job-1"]; deck.ClaimTokenHash != "claim-hash" {
+		t.Fatalf("expected the claim kept with the deck, got %q", deck.ClaimTokenHash)
+	}
+
+	// Results of refinements keep the claim of the deck
+	jobStore.jobs["job-1"]["status"] = "queued"
+	if rec := h.post(t, "/tasks/refine-slides", RefinePayload{JobID: "job-1", Revision: 1, Instruction: "Shorter"}); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if result := jobStore.results["job-1"]; result.ClaimTokenHash != "claim-hash" {
+		t.Fatalf("expected the claim kept with the refined result, got %q", result.ClaimTokenHash)
+	}
+}
+
+func TestProcessSlidesSkipsTaskOfCompletedJob(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, _ := newTestController(generator)
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A task delivered again is acknowledged without generating the deck again
+	generator.topic = "unchanged"
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "skipped") {
+		t.Fatalf("expected the task to be skipped, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if generator.topic != "unchanged" || jobStore.jobs["job-1"]["status"] != "completed" {
+		t.Fatalf("expected the completed job to be left alone, got %v", jobStore.jobs["job-1"])
+	}
+}
+
+func TestRefineSlidesKeepsPreviousRevisionOnFailure(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, blobStore := newTestController(generator)
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	generator.err = errors.New("model unavailable")
+	jobStore.jobs["job-1"]["status"] = "queued"
+	rec := h.post(t, "/tasks/refine-slides", RefinePayload{JobID: "job-1", Revision: 1, Instruction: "Shorter"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+	if status := jobStore.jobs["job-1"]["status"]; status != "failed" {
+		t.Fatalf("expected a failed job, got %v", status)
+	}
+	if len(jobStore.revisions["job-1"]) != 1 || string(blobStore.files["results/job-1/presentation.pdf"]) != "%PDF-1.4" {
+		t.Fatal("expected the first revision and its result to be kept")
+	}
+
+	if rec := h.post(t, "/tasks/refine-slides", RefinePayload{JobID: "job-1", Revision: 5, Instruction: "Shorter"}); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500 for an unknown revision, got %d", rec.Code)
+	}
+}
+
+func TestRefineSlidesRegeneratesRejectedSlide(t *testing.T) {
+	generator := &mockGenerator{}
+	h, jobStore, _ := newTestController(generator)
+	if rec := h.process(t, testPayload()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	jobStore.jobs["job-1"]["status"] = "queued"
+	rec := h.post(t, "/tasks/refine-slides", RefinePayload{JobID: "job-1", Revision: 1, Slide: 3, Instruction: "Too much text"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if generator.slide != 3 {
+		t.Fatalf("expected slide 3 to be regenerated, got %d", generator.slide)
+	}
+	revision, err := jobStore.GetRevision(context.Background(), "job-1", 2)
+	if err != nil || revision.Slide != 3 || revision.Instruction != "Too much text" || revision.BaseRevision != 1 {
+		t.Fatalf("unexpected second revision: %+v, %v", revision, err)
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	generator := &mockGenerator{}
+	h, _, _ := newTestController(generator)
+
+	rec := h.post(t, "/tasks/estimate-tokens", EstimatePayload{
+		Theme: "default",
+		Files: []models.File{{Filename: "notes.md", Data: []byte("# Notes"), Type: "text/plain"}},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(generator.files) != 1 || string(generator.files[0].Data) != "# Notes" {
+		t.Fatalf("unexpected files passed to the generator: %+v", generator.files)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `"inputTokens":100`) || !strings.Contains(body, `"outputTokens":2500`) {
+		t.Fatalf("unexpected estimate: %s", body)
+	}
+
+	if rec := h.post(t, "/tasks/estimate-tokens", EstimatePayload{Theme: "default"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without files or a prompt, got %d", rec.Code)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	generator := &mockGenerator{}
+	h, _, _ := newTestController(generator)
+
+	rec := h.post(t, "/tasks/dry-run", EstimatePayload{Theme: "default", Prompt: "The history of tea in 8 slides"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if generator.topic != "The history of tea in 8 slides" {
+		t.Fatalf("unexpected topic passed to the generator: %q", generator.topic)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`"prompt":"Create a presentation about The history of tea in 8 slides"`, `"estimatedSlides":8`, `"outputTokens":2500`} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in the dry run, got %s", want, body)
+		}
+	}
+
+	generator.err = errors.New("none of the files could be read")
+	rec = h.post(t, "/tasks/dry-run", EstimatePayload{Theme: "default", Files: []models.File{{Filename: "scan.png", Type: "image/png"}}})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500 when the files can't be read, got %d", rec.Code)
+	}
+	if rec := h.post(t, "/tasks/dry-run", EstimatePayload{Theme: "default"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without files or a prompt, got %d", rec.Code)
+	}
+}
+
+func TestSplitChapters(t *testing.T) {
+	generator := &mockGenerator{chapters: []slides.Chapter{
+		{Title: "Week 1", Markdown: "Week 1\nBasics"},
+		{Title: "Week 2", Markdown: "Week 2\nSorting"},
+	}}
+	h, _, _ := newTestController(generator)
+
+	rec := h.post(t, "/tasks/split-chapters", EstimatePayload{Files: []models.File{{Filename: "course.md", Type: "text/markdown", Data: []byte("# Course")}}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Chapters []slides.Chapter `json:"chapters"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(body.Chapters) != 2 || body.Chapters[1].Title != "Week 2" || len(generator.files) != 1 {
+		t.Fatalf("unexpected chapters: %+v", body.Chapters)
+	}
+
+	if rec := h.post(t, "/tasks/split-chapters", EstimatePayload{Prompt: "Sorting"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without files, got %d", rec.Code)
+	}
+}
+
+func TestCaptureTaskKeepsInputs(t *testing.T) {
+	h, _, blobStore := newTestController(&mockGenerator{})
+	payload := testPayload()
+	payload.NotifyEmail = "user@example.com"
+	payload.Drive = &DriveFiles{Owner: "key-1", FileIDs: []string{"drive-file-1"}}
+	payload.Features = map[string]bool{"injection_detection": true}
+	files := []models.File{
+		{Filename: "notes.md", Type: "text/plain", Data: []byte("# Notes")},
+		{Filename: "Roadmap", Type: "text/markdown", Data: []byte("# Roadmap")},
+	}
+
+	task, err := h.controller.captureTask(context.Background(), payload, files)
+	if err != nil {
+		t.Fatalf("captureTask failed: %v", err)
+	}
+	var replay TaskPayload
+	if err := json.Unmarshal([]byte(task), &replay); err != nil {
+		t.Fatalf("invalid task: %v", err)
+	}
+	if replay.JobID != "job-1" || replay.NotifyEmail != "" || replay.Drive != nil || !replay.Features["injection_detection"] {
+		t.Errorf("unexpected task: %+v", replay)
+	}
+	if len(replay.Files) != 2 || replay.Files[1].GCSPath != "captures/job-1/inputs/1" || replay.Files[1].Hash == "" {
+		t.Fatalf("unexpected files: %+v", replay.Files)
+	}
+	if string(blobStore.files["captures/job-1/inputs/1"]) != "# Roadmap" {
+		t.Errorf("expected the fetched Drive file to be kept, got %v", blobStore.files)
+	}
+}
@@ -3,14 +3,24 @@ package main
 import (
 	"context"
 	"log"
-	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
 
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/martin226/slideitin/backend/slides-service/config"
 	"github.com/martin226/slideitin/backend/slides-service/controllers"
+	"github.com/martin226/slideitin/backend/slides-service/middleware"
+	"github.com/martin226/slideitin/backend/slides-service/services/analytics"
+	"github.com/martin226/slideitin/backend/slides-service/services/encryption"
+	"github.com/martin226/slideitin/backend/slides-service/services/jobs"
+	"github.com/martin226/slideitin/backend/slides-service/services/notifications"
 	"github.com/martin226/slideitin/backend/slides-service/services/slides"
-	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/slides-service/services/sources"
 )
 
 func main() {
@@ -19,47 +29,172 @@ func main() {
 		log.Println("Warning: .env file not found, using system environment variables")
 	}
 
+	// Load and validate the configuration before starting anything
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
 	// Set up Gin router
 	router := gin.Default()
 
-	// Get environment variables
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		log.Fatal("GEMINI_API_KEY environment variable is required")
-	}
-	
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID == "" {
-		log.Fatal("GOOGLE_CLOUD_PROJECT environment variable is required")
-	}
-
 	// Initialize Firestore client
 	ctx := context.Background()
-	fsClient, err := firestore.NewClient(ctx, projectID)
+	fsClient, err := firestore.NewClientWithDatabase(ctx, cfg.ProjectID, cfg.FirestoreDatabaseID)
 	if err != nil {
 		log.Fatalf("Failed to create Firestore client: %v", err)
 	}
 	defer fsClient.Close()
-	
+
+	// Services deployed for another region read the workspaces, themes and Drive
+	// connections from the database of the API's default region
+	settingsClient := fsClient
+	if cfg.SettingsFirestoreDatabaseID != cfg.FirestoreDatabaseID {
+		settingsClient, err = firestore.NewClientWithDatabase(ctx, cfg.ProjectID, cfg.SettingsFirestoreDatabaseID)
+		if err != nil {
+			log.Fatalf("Failed to create the settings Firestore client: %v", err)
+		}
+		defer settingsClient.Close()
+	}
+
+	// Initialize Cloud Storage client
+	var blobStore, themeBlobStore jobs.BlobStore
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Printf("Failed to create Cloud Storage client: %v", err)
+		// Continue without storage, will be handled in requests
+	} else {
+		defer storageClient.Close()
+		blobStore = jobs.NewGCSBlobStore(storageClient, cfg.BucketName, cfg.ResultKMSKey)
+		themeBlobStore = blobStore
+		if cfg.ThemesBucketName != cfg.BucketName {
+			themeBlobStore = jobs.NewGCSBlobStore(storageClient, cfg.ThemesBucketName, "")
+		}
+	}
+
 	// Initialize services
-	slideService := slides.NewSlideService(apiKey)
-	
-	// Initialize controllers
-	taskController := controllers.NewTaskController(slideService, fsClient)
-	
-	// Define routes
-	router.POST("/tasks/process-slides", taskController.ProcessSlides)
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	var resultEnvelope *encryption.Envelope
+	if cfg.ResultKMSKey != "" {
+		kms, err := encryption.NewKMS(ctx)
+		if err != nil {
+			log.Fatalf("Failed to initialize result encryption: %v", err)
+		}
+		resultEnvelope = encryption.NewEnvelope(kms, cfg.ResultKMSKey)
+	}
+	jobStore := jobs.NewFirestoreJobStore(fsClient, settingsClient, resultEnvelope)
+
+	// Share the state of the Gemini circuit breaker, so the API stops taking jobs while Gemini is down
+	slides.OnGeminiStateChange(func(openUntil time.Time) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := jobStore.SetDependencyState(ctx, "gemini", openUntil); err != nil {
+			log.Printf("Warning: Failed to share the Gemini breaker state: %v", err)
+		}
 	})
+	var themeRegistry slides.ThemeRegistry
+	if blobStore != nil {
+		themeRegistry = jobs.NewThemeStore(settingsClient, themeBlobStore, filepath.Join(os.TempDir(), "slideitin-themes"))
+	}
+	// Containers without the Marp CLI render the Marp decks natively, a Marp
+	// CLI that is installed has to work before the service reports ready
+	renderers := slides.Renderers{slides.EngineNative: slides.NewNativeRenderer()}
+	var warmers []controllers.Warmer
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// The render tools read HTML the model wrote, run them sandboxed when bubblewrap is configured
+	var sandbox *slides.Sandbox
+	if cfg.SandboxPath != "" {
+		sandbox, err = slides.NewSandbox(cfg.SandboxPath, cfg.SandboxUID)
+		if err != nil {
+			log.Fatalf("Failed to create the render sandbox: %v", err)
+		}
+		warmers = append(warmers, sandbox)
+	} else {
+		log.Printf("Warning: SANDBOX_PATH not set, render tools run without a sandbox")
+	}
+	marpPath := cfg.MarpPath
+	if _, err := exec.LookPath("marp"); marpPath == "" && err == nil {
+		marpPath = "marp"
 	}
-	
-	log.Printf("Starting slides service on port %s", port)
-	if err := router.Run(":" + port); err != nil {
+	if marpPath != "" {
+		marp := slides.NewMarpRenderer(marpPath, cfg.ChromiumPath, sandbox)
+		renderers[slides.EngineMarp] = marp
+		warmers = append(warmers, marp)
+	} else {
+		log.Printf("The Marp CLI is not installed, rendering Marp decks natively")
+		renderers[slides.EngineMarp] = renderers[slides.EngineNative]
+	}
+	if _, err := exec.LookPath("node"); err == nil {
+		renderers[slides.EngineSlidev] = slides.NewSlidevRenderer(cfg.SlidevDir, cfg.ChromiumPath, sandbox)
+	}
+	if _, err := exec.LookPath("pandoc"); err == nil {
+		renderers[slides.EngineBeamer] = slides.NewBeamerRenderer(sandbox)
+	}
+
+	// Remove the render directories of renders that crashed before cleaning up
+	renderDirs := []string{os.TempDir()}
+	if cfg.SlidevDir != "" {
+		renderDirs = append(renderDirs, cfg.SlidevDir)
+	}
+	go slides.SweepWorkDirs(ctx, 15*time.Minute, renderDirs...)
+	slideService := slides.NewSlideService(cfg.GeminiAPIKey, renderers, jobStore, themeRegistry, jobStore, slides.Limits{
+		Renders:      cfg.MaxConcurrentRenders,
+		Generations:  cfg.MaxConcurrentGenerations,
+		InputTokens:  cfg.MaxInputTokens,
+		OutputTokens: cfg.MaxOutputTokens,
+	})
+	emailService := notifications.NewEmailService(cfg.SendGridAPIKey, cfg.NotifyFromEmail, cfg.PublicAPIURL)
+	webhookService := notifications.NewWebhookService(cfg.PublicAPIURL)
+
+	// Drive input needs the OAuth client the API connects Drive with
+	var driveFetcher controllers.DriveFetcher
+	if cfg.GoogleOAuthClientID != "" {
+		driveFetcher = sources.NewDriveFetcher(settingsClient, cfg.GoogleOAuthClientID, cfg.GoogleOAuthClientSecret)
+	}
+
+	// Register the content sources configured on this instance, public GitHub
+	// repositories, papers and feeds can always be imported
+	contentSources := []sources.ContentSource{sources.NewGitHub(cfg.GitHubToken), sources.NewPapers(), sources.NewFeeds()}
+	if cfg.ConfluenceURL != "" {
+		confluence, err := sources.NewConfluence(cfg.ConfluenceURL, cfg.ConfluenceEmail, cfg.ConfluenceAPIToken)
+		if err != nil {
+			log.Fatalf("Failed to configure Confluence: %v", err)
+		}
+		contentSources = append(contentSources, confluence)
+	}
+	if cfg.SharePointTenantID != "" {
+		contentSources = append(contentSources, sources.NewSharePoint(cfg.SharePointTenantID, cfg.SharePointClientID, cfg.SharePointClientSecret))
+	}
+
+	// Export the finished jobs for analytics when a table is configured
+	var exporter controllers.JobExporter
+	if cfg.BigQueryJobsTable != "" {
+		bigQueryExporter, err := analytics.NewBigQueryExporter(ctx, cfg.BigQueryJobsTable)
+		if err != nil {
+			log.Fatalf("Failed to configure the BigQuery export: %v", err)
+		}
+		exporter = bigQueryExporter
+	}
+
+	// Initialize controllers
+	taskController := controllers.NewTaskController(slideService, emailService, webhookService, jobStore, blobStore, driveFetcher, sources.NewRegistry(contentSources...), exporter)
+
+	// Define routes
+	tasks := router.Group("/tasks")
+	if cfg.TaskSigningSecret != "" {
+		tasks.Use(middleware.RequireSignature(cfg.TaskSigningSecret))
+	}
+	tasks.POST("/process-slides", taskController.ProcessSlides)
+	tasks.POST("/refine-slides", taskController.RefineSlides)
+	tasks.POST("/estimate-tokens", taskController.EstimateTokens)
+	tasks.POST("/dry-run", taskController.DryRun)
+	tasks.POST("/index-document", taskController.IndexDocument)
+	tasks.POST("/split-chapters", taskController.SplitChapters)
+	healthController := controllers.NewHealthController(warmers...)
+	router.GET("/health", healthController.Health)
+	router.GET("/ready", healthController.Ready)
+
+	log.Printf("Starting slides service on port %s", cfg.Port)
+	if err := router.Run(":" + cfg.Port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
-} 
\ No newline at end of file
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidSignature(t *testing.T) {
+	// The API's SignTask produces the same vector, keep the two in step
+	body := []byte(`{"jobId":"job-1"}`)
+	signature := "v1=8f4ecb2363ee9e747fbb19b545fc58009dad3bcf5551ec480eb795605620fed4"
+	signedAt := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+		now       time.Time
+		valid     bool
+	}{
+		{"valid", "secret", "1700000000", signature, body, signedAt.Add(time.Minute), true},
+		{"retried", "secret", "1700000000", signature, body, signedAt.Add(23 * time.Hour), true},
+		{"wrong secret", "other", "1700000000", signature, body, signedAt, false},
+		{"tampered body", "secret", "1700000000", signature, []byte(`{"jobId":"job-2"}`), signedAt, false},
+		{"changed timestamp", "secret", "1700000001", signature, body, signedAt, false},
+		{"too old", "secret", "1700000000", signature, body, signedAt.Add(25 * time.Hour), false},
+		{"missing version", "secret", "1700000000", signature[3:], body, signedAt, false},
+		{"missing headers", "secret", "", "", body, signedAt, false},
+	}
+	for _, test := range tests {
+		if valid := validSignature(test.secret, test.timestamp, test.signature, test.body, test.now); valid != test.valid {
+			t.Errorf("%s: validSignature = %v, want %v", test.name, valid, test.valid)
+		}
+	}
+}
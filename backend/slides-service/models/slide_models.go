@@ -2,12 +2,82 @@ package models
 
 // SlideSettings represents the settings for slide generation
 type SlideSettings struct {
-	SlideDetail string `json:"slideDetail"` // Values: minimal, medium, detailed
-	Audience    string `json:"audience"`    // Values: general, academic, technical, professional, executive
-} 
+	SlideDetail        string      `json:"slideDetail"`                       // Values: minimal, medium, detailed
+	Audience           string      `json:"audience"`                          // Values: general, academic, technical, professional, executive
+	IncludeAgenda      bool        `json:"includeAgenda,omitempty"`           // Adds an agenda slide after the title slide
+	IncludeSummary     bool        `json:"includeSummary,omitempty"`          // Appends a key-takeaways summary slide
+	IncludeCitations   bool        `json:"includeCitations,omitempty"`        // Annotates bullets with PDF page numbers and adds a references slide
+	Accessibility      bool        `json:"accessibility,omitempty"`           // Enforces alt text, contrast and font size checks and emits a report
+	Flashcards         bool        `json:"flashcards,omitempty"`              // Extracts term and definition flashcards from the sources, exported as CSV
+	OnePager           bool        `json:"onePager,omitempty"`                // Also writes a one-page executive summary of the sources, as PDF and markdown
+	InstructorMode     bool        `json:"instructorMode,omitempty"`          // Adds presenter notes for the instructor and writes a student handout with blanks and questions, as PDF and markdown
+	Language           string      `json:"language,omitempty"`                // BCP 47 language tag of the deck, defaults to en
+	Footer             string      `json:"footer,omitempty"`                  // Footer stamped on every slide, {date} is replaced with the current date
+	Watermark          string      `json:"watermark,omitempty"`               // Watermark drawn across every slide, e.g. "Confidential — Draft"
+	DeckTemplate       string      `json:"deckTemplate,omitempty"`            // Values: pitch_deck, lecture, standup, research_talk
+	Font               string      `json:"font,omitempty"`                    // Google Fonts family or font uploaded to the workspace for the text
+	HeadingFont        string      `json:"headingFont,omitempty"`             // Font of the headings, defaults to the text font
+	VisualStyle        string      `json:"visualStyle,omitempty"`             // Values: standard, playful
+	LayoutStyle        string      `json:"layoutStyle,omitempty"`             // Values: minimal, balanced, bold
+	Renderer           string      `json:"renderer,omitempty"`                // Values: marp, slidev, beamer, native, defaults to marp
+	FocusTopics        []string    `json:"focusTopics,omitempty"`             // Topics the sections of long documents kept or summarized are picked for, e.g. "pricing"
+	ChunkSummaries     bool        `json:"chunkSummaries,omitempty"`          // Keeps the summaries of the sections of long documents as a JSON document of the result
+	FactCheck          bool        `json:"factCheck,omitempty"`               // Checks the bullet points against the sources and reports the unsupported ones as a JSON document of the result
+	Chapters           []string    `json:"chapters,omitempty"`                // Titles of the chapter decks of a batch, set on its overview deck so it introduces them
+	FontFiles          []FontFile  `json:"-" firestore:"fontFiles,omitempty"` // Uploaded files of the fonts, set from the task and kept with the deck for refinements
+	ChunkedMode        bool        `json:"-" firestore:"-"`                   // Summarizes the sections of long documents that don't fit the token budget, set from the chunked_mode feature flag
+	InjectionDetection bool        `json:"-" firestore:"-"`                   // Removes text that reads as instructions to the AI from documents, set from the injection_detection feature flag
+	TokenLimits        TokenLimits `json:"-" firestore:"-"`                   // Token limits of the owner's plan, set from the task
+	MaxBullets         int         `json:"-" firestore:"-"`                   // Fewer bullet points per slide than the detail level allows, set when its decks have been running off the page
+}
+
+// TokenLimits overrides the Gemini token limits of the instance for a job.
+// Zero keeps the limit the instance is configured with.
+type TokenLimits struct {
+	Input  int `json:"input,omitempty"`  // Most tokens sent to Gemini for a deck
+	Output int `json:"output,omitempty"` // Most tokens Gemini writes for a deck
+}
+
+// FontFile is a font file uploaded to a workspace and stored in Cloud Storage
+type FontFile struct {
+	Family string `json:"family" firestore:"family"`
+	Path   string `json:"path" firestore:"path"`
+	Weight int    `json:"weight" firestore:"weight"` // From 100 to 900
+	Style  string `json:"style" firestore:"style"`   // normal or italic
+}
 
 type File struct {
-	Filename string `json:"filename"`
-	Data []byte `json:"data"`
-	Type string `json:"type"`
+	Filename string         `json:"filename"`
+	Data     []byte         `json:"data"`
+	Type     string         `json:"type"`
+	Hash     string         `json:"hash,omitempty"` // SHA-256 of the content, set for uploaded files
+	Index    *DocumentIndex `json:"-"`              // Passages embedded when the file was added to a workspace library
+}
+
+// DocumentIndex is the passages of a library document with their vectors,
+// computed once when the document is added so decks generated from it don't
+// split and embed it again
+type DocumentIndex struct {
+	Model    string           `json:"model"` // Embedding model of the vectors
+	Passages []IndexedPassage `json:"passages"`
+}
+
+// IndexedPassage is a passage of a library document and its vector
+type IndexedPassage struct {
+	Title  string    `json:"title,omitempty"`
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+// DeckOutline is the plan of a deck too long to write in one pass, which is
+// written one section at a time
+type DeckOutline struct {
+	Title    string           `json:"title" firestore:"title"`
+	Sections []OutlineSection `json:"sections" firestore:"sections"`
+}
+
+// OutlineSection is a section of a deck outline with the titles of its slides
+type OutlineSection struct {
+	Title  string   `json:"title" firestore:"title"`
+	Slides []string `json:"slides" firestore:"slides"`
 }
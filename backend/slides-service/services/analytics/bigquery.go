@@ -0,0 +1,187 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// Kinds of job records
+const (
+	KindGenerate = "generate" // A deck written from its sources
+	KindRefine   = "refine"   // A revision of a deck from an instruction
+)
+
+// timestampFormat is the canonical format of BigQuery timestamps
+const timestampFormat = "2006-01-02 15:04:05.000000"
+
+// JobRecord is what a task did with a job, from its outcome to its duration
+// and the tokens it used
+type JobRecord struct {
+	JobID          string
+	Kind           string
+	Status         string // Status the task left the job in: completed, failed or cancelled
+	Error          string // Message of a failed job
+	Owner          string
+	WorkspaceID    string
+	Theme          string
+	Settings       models.SlideSettings
+	Inputs         int // Files, Drive files and content sources the job was asked to read
+	FromPrompt     bool
+	Ephemeral      bool
+	Warnings       int
+	Retries        int // Deliveries of the task by Cloud Tasks before this one
+	CreatedAt      time.Time
+	StartedAt      time.Time
+	FinishedAt     time.Time
+	GeminiRequests int
+	InputTokens    int
+	OutputTokens   int
+}
+
+// schema is the schema of the table job records are exported to, partitioned
+// by day on finished_at
+var schema = &bigquery.TableSchema{
+	Fields: []*bigquery.TableFieldSchema{
+		{Name: "job_id", Type: "STRING", Mode: "REQUIRED"},
+		{Name: "kind", Type: "STRING", Mode: "REQUIRED"},
+		{Name: "status", Type: "STRING", Mode: "REQUIRED"},
+		{Name: "error", Type: "STRING"},
+		{Name: "owner", Type: "STRING"},
+		{Name: "workspace_id", Type: "STRING"},
+		{Name: "theme", Type: "STRING"},
+		{Name: "settings", Type: "JSON"},
+		{Name: "inputs", Type: "INTEGER"},
+		{Name: "from_prompt", Type: "BOOLEAN"},
+		{Name: "ephemeral", Type: "BOOLEAN"},
+		{Name: "warnings", Type: "INTEGER"},
+		{Name: "retries", Type: "INTEGER"},
+		{Name: "created_at", Type: "TIMESTAMP"},
+		{Name: "started_at", Type: "TIMESTAMP", Mode: "REQUIRED"},
+		{Name: "finished_at", Type: "TIMESTAMP", Mode: "REQUIRED"},
+		{Name: "queue_seconds", Type: "FLOAT", Description: "From the creation of a generated job to the start of its task"},
+		{Name: "duration_seconds", Type: "FLOAT", Description: "Run time of the task"},
+		{Name: "gemini_requests", Type: "INTEGER"},
+		{Name: "input_tokens", Type: "INTEGER"},
+		{Name: "output_tokens", Type: "INTEGER"},
+	},
+}
+
+// BigQueryExporter streams job records into a BigQuery table
+type BigQueryExporter struct {
+	service   *bigquery.Service
+	projectID string
+	datasetID string
+	tableID   string
+}
+
+// NewBigQueryExporter creates an exporter to a table named like
+// project.dataset.table using the default credentials. The table is created
+// in the dataset if it doesn't exist yet.
+func NewBigQueryExporter(ctx context.Context, table string) (*BigQueryExporter, error) {
+	parts := strings.Split(table, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid BigQuery table %q, expected project.dataset.table", table)
+	}
+	service, err := bigquery.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %v", err)
+	}
+	e := &BigQueryExporter{service: service, projectID: parts[0], datasetID: parts[1], tableID: parts[2]}
+	if err := e.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ensureTable creates the table with the schema of job records if it is missing
+func (e *BigQueryExporter) ensureTable(ctx context.Context) error {
+	_, err := e.service.Tables.Get(e.projectID, e.datasetID, e.tableID).Context(ctx).Do()
+	if err == nil {
+		return nil
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return fmt.Errorf("failed to get BigQuery table: %v", err)
+	}
+
+	_, err = e.service.Tables.Insert(e.projectID, e.datasetID, &bigquery.Table{
+		TableReference:   &bigquery.TableReference{ProjectId: e.projectID, DatasetId: e.datasetID, TableId: e.tableID},
+		Schema:           schema,
+		TimePartitioning: &bigquery.TimePartitioning{Type: "DAY", Field: "finished_at"},
+		Description:      "Jobs of the slides service, one row per task that finished a job",
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to create BigQuery table: %v", err)
+	}
+	return nil
+}
+
+// Export streams a job record into the table
+func (e *BigQueryExporter) Export(ctx context.Context, record JobRecord) error {
+	row, err := record.row()
+	if err != nil {
+		return err
+	}
+	resp, err := e.service.Tabledata.InsertAll(e.projectID, e.datasetID, e.tableID, &bigquery.TableDataInsertAllRequest{
+		Rows: []*bigquery.TableDataInsertAllRequestRows{{
+			// Exports retried by the client within a minute are only kept once
+			InsertId: fmt.Sprintf("%s-%s-%d", record.JobID, record.Kind, record.StartedAt.UnixNano()),
+			Json:     row,
+		}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to export job record: %v", err)
+	}
+	if len(resp.InsertErrors) > 0 && len(resp.InsertErrors[0].Errors) > 0 {
+		return fmt.Errorf("failed to export job record: %s", resp.InsertErrors[0].Errors[0].Message)
+	}
+	return nil
+}
+
+// row returns the record as a row of the table
+func (r JobRecord) row() (map[string]bigquery.JsonValue, error) {
+	settings, err := json.Marshal(r.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode settings: %v", err)
+	}
+	row := map[string]bigquery.JsonValue{
+		"job_id":           r.JobID,
+		"kind":             r.Kind,
+		"status":           r.Status,
+		"theme":            r.Theme,
+		"settings":         string(settings),
+		"inputs":           r.Inputs,
+		"from_prompt":      r.FromPrompt,
+		"ephemeral":        r.Ephemeral,
+		"warnings":         r.Warnings,
+		"retries":          r.Retries,
+		"started_at":       r.StartedAt.UTC().Format(timestampFormat),
+		"finished_at":      r.FinishedAt.UTC().Format(timestampFormat),
+		"duration_seconds": r.FinishedAt.Sub(r.StartedAt).Seconds(),
+		"gemini_requests":  r.GeminiRequests,
+		"input_tokens":     r.InputTokens,
+		"output_tokens":    r.OutputTokens,
+	}
+	for column, value := range map[string]string{"error": r.Error, "owner": r.Owner, "workspace_id": r.WorkspaceID} {
+		if value != "" {
+			row[column] = value
+		}
+	}
+	if !r.CreatedAt.IsZero() {
+		row["created_at"] = r.CreatedAt.UTC().Format(timestampFormat)
+		// Refinements run on jobs created long before, so only generated jobs have a queue time
+		if r.Kind == KindGenerate {
+			row["queue_seconds"] = r.StartedAt.Sub(r.CreatedAt).Seconds()
+		}
+	}
+	return row, nil
+}
@@ -0,0 +1,112 @@
+package encryption
+
+// This is the sealing half of backend/api/services/encryption, which is the
+// canonical copy: the slides service only encrypts the documents of results,
+// and the API decrypts them with its Open, so the format must match it.
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// dataKeySize is the size of the AES-256 data keys
+const dataKeySize = 32
+
+// KeyWrapper wraps data keys with a key encryption key, such as a Cloud KMS
+// key, so the data keys can be stored next to the data they encrypt
+type KeyWrapper interface {
+	// Wrap encrypts a data key with the named key
+	Wrap(ctx context.Context, keyName string, key []byte) ([]byte, error)
+}
+
+// KMS is a KeyWrapper backed by Cloud KMS symmetric keys
+type KMS struct {
+	service *cloudkms.Service
+}
+
+// NewKMS creates a Cloud KMS key wrapper using the default credentials
+func NewKMS(ctx context.Context) (*KMS, error) {
+	service, err := cloudkms.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud KMS client: %v", err)
+	}
+	return &KMS{service: service}, nil
+}
+
+// Wrap encrypts a data key with a Cloud KMS key, named like
+// projects/p/locations/l/keyRings/r/cryptoKeys/k
+func (k *KMS) Wrap(ctx context.Context, keyName string, key []byte) ([]byte, error) {
+	resp, err := k.service.Projects.Locations.KeyRings.CryptoKeys.Encrypt(keyName, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(key),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %v", err)
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+// Envelope generates data keys wrapped by one key encryption key
+type Envelope struct {
+	wrapper KeyWrapper
+	keyName string
+}
+
+// NewEnvelope creates an envelope that wraps data keys with the named key
+func NewEnvelope(wrapper KeyWrapper, keyName string) *Envelope {
+	return &Envelope{
+		wrapper: wrapper,
+		keyName: keyName,
+	}
+}
+
+// KeyName returns the name of the key encryption key
+func (e *Envelope) KeyName() string {
+	return e.keyName
+}
+
+// NewDataKey generates a data key and returns it with its wrapped form, which
+// is stored with the data so it can be decrypted later
+func (e *Envelope) NewDataKey(ctx context.Context) ([]byte, []byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	wrapped, err := e.wrapper.Wrap(ctx, e.keyName, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, wrapped, nil
+}
+
+// Seal encrypts data with AES-256-GCM under a data key, prefixing the nonce.
+// The additional data, such as the field the data is stored in, must be
+// passed again to Open in the API.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// newAEAD returns AES-GCM for a data key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("data key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
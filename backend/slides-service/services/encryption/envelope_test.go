@@ -0,0 +1,61 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// staticWrapper wraps data keys with a fixed key instead of Cloud KMS
+type staticWrapper struct {
+	key []byte
+}
+
+func (w *staticWrapper) Wrap(ctx context.Context, keyName string, key []byte) ([]byte, error) {
+	return Seal(w.key, key, []byte(keyName))
+}
+
+// open decrypts sealed data the way Open in the API does
+func open(t *testing.T, key, sealed, additionalData []byte) ([]byte, error) {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("NewCipher failed: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("NewGCM failed: %v", err)
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+func TestSealedDataOpensInTheAPI(t *testing.T) {
+	wrapper := &staticWrapper{key: bytes.Repeat([]byte{7}, dataKeySize)}
+	envelope := NewEnvelope(wrapper, "projects/p/locations/global/keyRings/r/cryptoKeys/results")
+
+	key, wrapped, err := envelope.NewDataKey(context.Background())
+	if err != nil {
+		t.Fatalf("NewDataKey failed: %v", err)
+	}
+	sealed, err := Seal(key, []byte("%PDF-1.4"), []byte("pdfData"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("%PDF")) {
+		t.Fatal("expected the data to be encrypted")
+	}
+
+	unwrapped, err := open(t, wrapper.key, wrapped, []byte(envelope.KeyName()))
+	if err != nil || !bytes.Equal(unwrapped, key) {
+		t.Fatalf("expected the wrapped data key back, got %v", err)
+	}
+	plaintext, err := open(t, unwrapped, sealed, []byte("pdfData"))
+	if err != nil || string(plaintext) != "%PDF-1.4" {
+		t.Fatalf("expected the data back, got %q, %v", plaintext, err)
+	}
+	if _, err := open(t, unwrapped, sealed, []byte("htmlData")); err == nil {
+		t.Fatal("expected other additional data to fail")
+	}
+}
@@ -0,0 +1,315 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/slides-service/services/encryption"
+	"github.com/martin226/slideitin/backend/slides-service/services/slides"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreJobStore is a JobStore backed by Firestore
+type FirestoreJobStore struct {
+	client   *firestore.Client
+	settings *firestore.Client    // Database of the workspaces and dependencies, shared by every region
+	results  *encryption.Envelope // Optional, encrypts the documents of results before they are stored
+}
+
+// NewFirestoreJobStore creates a new Firestore job store. Jobs are kept in the
+// database of client, workspaces and dependencies in the one of settings.
+// Results are stored encrypted with data keys from the envelope, or as is when
+// it is nil.
+func NewFirestoreJobStore(client, settings *firestore.Client, results *encryption.Envelope) *FirestoreJobStore {
+	return &FirestoreJobStore{
+		client:   client,
+		settings: settings,
+		results:  results,
+	}
+}
+
+// GetJob returns a job, or ErrNotFound
+func (s *FirestoreJobStore) GetJob(ctx context.Context, id string) (*FirestoreJob, error) {
+	doc, err := s.client.Collection("jobs").Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var job FirestoreJob
+	if err := doc.DataTo(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// UpdateJob sets the given fields on a job
+func (s *FirestoreJobStore) UpdateJob(ctx context.Context, id string, fields map[string]interface{}) error {
+	_, err := s.client.Collection("jobs").Doc(id).Update(ctx, jobUpdates(fields))
+	return err
+}
+
+// TransitionJob atomically moves a job to a status along with the given fields
+func (s *FirestoreJobStore) TransitionJob(ctx context.Context, id string, to JobStatus, fields map[string]interface{}) error {
+	ref := s.client.Collection("jobs").Doc(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrNotFound
+			}
+			return err
+		}
+		var job FirestoreJob
+		if err := doc.DataTo(&job); err != nil {
+			return err
+		}
+		if from := JobStatus(job.Status); !from.CanTransition(to) {
+			return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, from, to)
+		}
+		return tx.Update(ref, append(jobUpdates(fields), firestore.Update{Path: "status", Value: string(to)}))
+	})
+}
+
+// jobUpdates converts the fields of a job update to Firestore updates
+func jobUpdates(fields map[string]interface{}) []firestore.Update {
+	updates := make([]firestore.Update, 0, len(fields)+1)
+	for path, value := range fields {
+		updates = append(updates, firestore.Update{Path: path, Value: value})
+		// Keep the field of the Firestore TTL policy in step with the expiry
+		if expiresAt, ok := value.(int64); ok && path == "expiresAt" && expiresAt > 0 {
+			updates = append(updates, firestore.Update{Path: "deleteAt", Value: time.Unix(expiresAt, 0)})
+		}
+	}
+	return updates
+}
+
+// StoreResult stores the result of a job, replacing the result of the
+// revision before it but keeping what the API wrote to it
+func (s *FirestoreJobStore) StoreResult(ctx context.Context, result FirestoreResult) error {
+	if s.results != nil {
+		if err := s.encryptResult(ctx, &result); err != nil {
+			return fmt.Errorf("failed to encrypt result: %v", err)
+		}
+	}
+	ref := s.client.Collection("results").Doc(result.ID)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		stored := result
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var existing FirestoreResult
+			if err := doc.DataTo(&existing); err != nil {
+				return err
+			}
+			stored.Replaces(existing)
+		}
+		stored.DeleteAt = time.Unix(stored.ExpiresAt, 0)
+		return tx.Set(ref, stored)
+	})
+}
+
+// encryptResult encrypts the documents of a result with a new data key, which
+// is stored wrapped by the key of the envelope
+func (s *FirestoreJobStore) encryptResult(ctx context.Context, result *FirestoreResult) error {
+	key, wrapped, err := s.results.NewDataKey(ctx)
+	if err != nil {
+		return err
+	}
+	fields := map[string]*[]byte{
+		"pdfData":             &result.PDFData,
+		"htmlData":            &result.HTMLData,
+		"accessibilityReport": &result.AccessibilityReport,
+	}
+	for name, data := range fields {
+		if len(*data) == 0 {
+			continue
+		}
+		sealed, err := encryption.Seal(key, *data, []byte(name))
+		if err != nil {
+			return err
+		}
+		*data = sealed
+	}
+	result.KeyName = s.results.KeyName()
+	result.WrappedKey = wrapped
+	return nil
+}
+
+// AddRevision stores a revision of a deck under the next revision number,
+// creating the deck with its first revision, and returns the number
+func (s *FirestoreJobStore) AddRevision(ctx context.Context, deck FirestoreDeck, revision FirestoreRevision) (int, error) {
+	deckRef := s.client.Collection("decks").Doc(deck.ID)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// Refinements that finish at the same time get consecutive numbers
+		doc, err := tx.Get(deckRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		deck.Revision = 1
+		if err == nil {
+			var existing FirestoreDeck
+			if err := doc.DataTo(&existing); err != nil {
+				return err
+			}
+			deck.Revision = existing.Revision + 1
+		}
+		revision.Number = deck.Revision
+
+		if err := tx.Set(deckRef, deck); err != nil {
+			return err
+		}
+		return tx.Set(deckRef.Collection("revisions").Doc(strconv.Itoa(revision.Number)), revision)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revision.Number, nil
+}
+
+// GetDeck returns a deck, or ErrNotFound
+func (s *FirestoreJobStore) GetDeck(ctx context.Context, id string) (*FirestoreDeck, error) {
+	doc, err := s.client.Collection("decks").Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var deck FirestoreDeck
+	if err := doc.DataTo(&deck); err != nil {
+		return nil, err
+	}
+	return &deck, nil
+}
+
+// GetRevision returns a revision of a deck, or ErrNotFound
+func (s *FirestoreJobStore) GetRevision(ctx context.Context, id string, number int) (*FirestoreRevision, error) {
+	doc, err := s.client.Collection("decks").Doc(id).Collection("revisions").Doc(strconv.Itoa(number)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var revision FirestoreRevision
+	if err := doc.DataTo(&revision); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// GetGeminiFile returns the Gemini file uploaded for a content hash, or nil if
+// there is none or it has expired
+func (s *FirestoreJobStore) GetGeminiFile(ctx context.Context, hash string) (*slides.GeminiFile, error) {
+	doc, err := s.client.Collection("geminiFiles").Doc(hash).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var file FirestoreGeminiFile
+	if err := doc.DataTo(&file); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= file.ExpiresAt {
+		return nil, nil
+	}
+	return &slides.GeminiFile{Name: file.Name, URI: file.URI}, nil
+}
+
+// PutGeminiFile remembers the Gemini file uploaded for a content hash until it expires
+func (s *FirestoreJobStore) PutGeminiFile(ctx context.Context, hash string, file slides.GeminiFile, expiresAt time.Time) error {
+	_, err := s.client.Collection("geminiFiles").Doc(hash).Set(ctx, FirestoreGeminiFile{
+		Name:      file.Name,
+		URI:       file.URI,
+		ExpiresAt: expiresAt.Unix(),
+		DeleteAt:  expiresAt,
+	})
+	return err
+}
+
+// SetDependencyState stores the state of the circuit breaker of a dependency,
+// with the end of its cooldown or zero once the dependency is up
+func (s *FirestoreJobStore) SetDependencyState(ctx context.Context, name string, openUntil time.Time) error {
+	state := FirestoreDependency{UpdatedAt: time.Now().Unix()}
+	if !openUntil.IsZero() {
+		state.OpenUntil = openUntil.Unix()
+	}
+	_, err := s.settings.Collection("dependencies").Doc(name).Set(ctx, state)
+	return err
+}
+
+// StoreCapture stores the capture of a job, replacing the one of an earlier attempt
+func (s *FirestoreJobStore) StoreCapture(ctx context.Context, capture FirestoreCapture) error {
+	_, err := s.client.Collection("captures").Doc(capture.ID).Set(ctx, capture)
+	return err
+}
+
+// UpdateLibraryDocument sets the given fields on a document of a workspace library
+func (s *FirestoreJobStore) UpdateLibraryDocument(ctx context.Context, workspaceID, documentID string, fields map[string]interface{}) error {
+	ref := s.settings.Collection("workspaces").Doc(workspaceID).Collection("documents").Doc(documentID)
+	_, err := ref.Update(ctx, jobUpdates(fields))
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// GetDensityStats returns the density statistics of a detail level, or nil if
+// none of its decks were measured yet
+func (s *FirestoreJobStore) GetDensityStats(ctx context.Context, detail string) (*slides.DensityStats, error) {
+	doc, err := s.client.Collection("densityStats").Doc(densityStatsID(detail)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var stats slides.DensityStats
+	if err := doc.DataTo(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// RecordDensity adds the density of a deck to the statistics of its detail
+// level. Decks finishing at the same time are all counted.
+func (s *FirestoreJobStore) RecordDensity(ctx context.Context, detail string, report slides.DensityReport) error {
+	ref := s.client.Collection("densityStats").Doc(densityStatsID(detail))
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var stats slides.DensityStats
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&stats); err != nil {
+				return err
+			}
+		}
+		return tx.Set(ref, stats.Add(report))
+	})
+}
+
+// densityStatsID returns the document of the density statistics of a detail
+// level, decks without one sharing the default
+func densityStatsID(detail string) string {
+	if detail == "" {
+		return "default"
+	}
+	return detail
+}
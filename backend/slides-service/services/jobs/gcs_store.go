@@ -0,0 +1,77 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// GCSBlobStore is a BlobStore backed by a Cloud Storage bucket
+type GCSBlobStore struct {
+	client     *storage.Client
+	bucketName string
+	kmsKeyName string // Cloud KMS key the files are encrypted with, empty for the bucket's default
+}
+
+// NewGCSBlobStore creates a new Cloud Storage blob store, which encrypts the
+// files it writes with the Cloud KMS key when one is given
+func NewGCSBlobStore(client *storage.Client, bucketName, kmsKeyName string) *GCSBlobStore {
+	return &GCSBlobStore{
+		client:     client,
+		bucketName: bucketName,
+		kmsKeyName: kmsKeyName,
+	}
+}
+
+// Download reads a file from the bucket
+func (s *GCSBlobStore) Download(ctx context.Context, path string) ([]byte, string, error) {
+	// Get a handle to the object
+	obj := s.client.Bucket(s.bucketName).Object(path)
+
+	// Check if the object exists
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", fmt.Errorf("failed to get object attributes: %v", err)
+	}
+
+	// Create a reader for the object
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create reader: %v", err)
+	}
+	defer r.Close()
+
+	// Read the file data
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read file: %v", err)
+	}
+
+	return data, attrs.ContentType, nil
+}
+
+// Upload writes a file to the bucket
+func (s *GCSBlobStore) Upload(ctx context.Context, path, contentType string, data []byte) error {
+	w := s.client.Bucket(s.bucketName).Object(path).NewWriter(ctx)
+	w.ContentType = contentType
+	w.KMSKeyName = s.kmsKeyName
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to close writer: %v", err)
+	}
+	return nil
+}
+
+// Delete removes a file from the bucket
+func (s *GCSBlobStore) Delete(ctx context.Context, path string) error {
+	return s.client.Bucket(s.bucketName).Object(path).Delete(ctx)
+}
@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"errors"
+	"slices"
+)
+
+// JobStatus represents the current status of a job
+type JobStatus string
+
+const (
+	StatusQueued     JobStatus = "queued"     // Waiting for the slides service
+	StatusUploading  JobStatus = "uploading"  // Fetching the sources and uploading them to Gemini
+	StatusProcessing JobStatus = "processing" // Writing the slides with Gemini
+	StatusRendering  JobStatus = "rendering"  // Rendering the slides with Marp
+	StatusCompleted  JobStatus = "completed"
+	StatusFailed     JobStatus = "failed"
+)
+
+// ErrIllegalTransition is returned when a job can't move from its current
+// status to another
+var ErrIllegalTransition = errors.New("illegal job status transition")
+
+// transitions lists the statuses a job can move to from each status, besides
+// keeping its status to update the message. Jobs in progress move between the
+// stages in any order, as retried tasks start over and busy instances requeue
+// them, but only complete after rendering. Refinements queue finished jobs
+// again, and retried tasks resume failed ones. This is the only table of the
+// transitions, the API only requeues finished jobs and fails the jobs it
+// couldn't enqueue, see queue.JobStatus.CanTransition.
+var transitions = map[JobStatus][]JobStatus{
+	StatusQueued:     {StatusUploading, StatusProcessing, StatusFailed},
+	StatusUploading:  {StatusQueued, StatusProcessing, StatusRendering, StatusFailed},
+	StatusProcessing: {StatusQueued, StatusUploading, StatusRendering, StatusFailed},
+	StatusRendering:  {StatusQueued, StatusUploading, StatusProcessing, StatusCompleted, StatusFailed},
+	StatusCompleted:  {StatusQueued},
+	StatusFailed:     {StatusQueued, StatusUploading, StatusProcessing},
+}
+
+// CanTransition reports whether a job can move from the status to another
+func (s JobStatus) CanTransition(to JobStatus) bool {
+	return s == to || slices.Contains(transitions[s], to)
+}
+
+// Terminal reports whether a job in the status is no longer in progress
+func (s JobStatus) Terminal() bool {
+	return s == StatusCompleted || s == StatusFailed
+}
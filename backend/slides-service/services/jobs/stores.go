@@ -0,0 +1,183 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/slides"
+)
+
+// ErrNotFound is returned by stores when a job or file doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// FirestoreJob is the Firestore representation of a job
+type FirestoreJob struct {
+	ID            string            `firestore:"id"`
+	Status        string            `firestore:"status"`
+	Message       string            `firestore:"message"`
+	MessageCode   string            `firestore:"messageCode,omitempty"`   // Code of the message, which the API localizes
+	MessageParams map[string]string `firestore:"messageParams,omitempty"` // Parameters of the message code
+	CreatedAt     int64             `firestore:"createdAt"`
+	UpdatedAt     int64             `firestore:"updatedAt"`
+	ExpiresAt     int64             `firestore:"expiresAt,omitempty"`
+	DeleteAt      time.Time         `firestore:"deleteAt,omitempty"` // Set from ExpiresAt, for the Firestore TTL policy
+	Warnings      []string          `firestore:"warnings,omitempty"` // Files left out of the job and other problems that didn't fail it
+
+	// Checkpoint holds the artifacts of the last attempt so a retry can resume
+	Checkpoint *slides.Checkpoint `firestore:"checkpoint,omitempty"`
+}
+
+// FirestoreResult is the Firestore representation of a job result
+type FirestoreResult struct {
+	ID                  string    `firestore:"id"`
+	ResultURL           string    `firestore:"resultUrl"`
+	PDFData             []byte    `firestore:"pdfData"`
+	HTMLData            []byte    `firestore:"htmlData"`
+	AccessibilityReport []byte    `firestore:"accessibilityReport,omitempty"`
+	CreatedAt           int64     `firestore:"createdAt"`
+	ExpiresAt           int64     `firestore:"expiresAt"`
+	DeleteAt            time.Time `firestore:"deleteAt,omitempty"`       // Set from ExpiresAt, for the Firestore TTL policy
+	Ephemeral           bool      `firestore:"ephemeral,omitempty"`      // Only fetched once, with the result token of the job
+	ClaimTokenHash      string    `firestore:"claimTokenHash,omitempty"` // Claim token hash of the anonymous job of the result
+	Owner               string    `firestore:"owner,omitempty"`          // Owner of the job of the result
+	WorkspaceID         string    `firestore:"workspaceId,omitempty"`    // Workspace of the job of the result
+	Downloads           int64     `firestore:"downloads,omitempty"`      // Counted by the API
+	LastAccessedAt      int64     `firestore:"lastAccessedAt,omitempty"` // Set by the API
+
+	// The documents are stored in Cloud Storage instead of inline when the
+	// paths are set, so they can be streamed
+	PDFPath              string `firestore:"pdfPath,omitempty"`
+	HTMLPath             string `firestore:"htmlPath,omitempty"`
+	ViewerPath           string `firestore:"viewerPath,omitempty"`           // Self-contained HTML for the sandboxed viewer
+	ThumbnailPath        string `firestore:"thumbnailPath,omitempty"`        // PNG of the first slide
+	FlashcardsPath       string `firestore:"flashcardsPath,omitempty"`       // CSV of the flashcards, importable into Anki
+	OnePagerPath         string `firestore:"onePagerPath,omitempty"`         // PDF of the executive summary
+	OnePagerMarkdownPath string `firestore:"onePagerMarkdownPath,omitempty"` // Markdown of the executive summary
+	HandoutPath          string `firestore:"handoutPath,omitempty"`          // PDF of the student handout of an instructor deck
+	HandoutMarkdownPath  string `firestore:"handoutMarkdownPath,omitempty"`  // Markdown of the student handout
+	AlignmentPath        string `firestore:"alignmentPath,omitempty"`        // JSON of the times in the source recordings the slides cover
+	ChunkSummariesPath   string `firestore:"chunkSummariesPath,omitempty"`   // JSON of the summaries of the sections of long documents
+	GroundingPath        string `firestore:"groundingPath,omitempty"`        // JSON of the bullet points checked against the sources
+
+	Warnings []string `firestore:"warnings,omitempty"` // Problems that didn't stop generation, such as files left out
+
+	// The documents are encrypted when a data key is set, with the data key
+	// wrapped by the named Cloud KMS key
+	KeyName    string `firestore:"keyName,omitempty"`
+	WrappedKey []byte `firestore:"wrappedKey,omitempty"`
+}
+
+// Replaces keeps what the API wrote to the result a refinement replaces: the
+// expiry a share link extended and the download counters
+func (r *FirestoreResult) Replaces(existing FirestoreResult) {
+	r.ExpiresAt = max(r.ExpiresAt, existing.ExpiresAt)
+	r.Downloads = existing.Downloads
+	r.LastAccessedAt = existing.LastAccessedAt
+}
+
+// FirestoreDeck is the Firestore representation of a generated deck, which
+// keeps the revisions of its markdown so it can be refined after generation
+type FirestoreDeck struct {
+	ID             string               `firestore:"id"`
+	Owner          string               `firestore:"owner,omitempty"`
+	WorkspaceID    string               `firestore:"workspaceId,omitempty"`
+	ClaimTokenHash string               `firestore:"claimTokenHash,omitempty"` // Claim token hash of the anonymous job that generated the deck
+	Theme          string               `firestore:"theme"`
+	Settings       models.SlideSettings `firestore:"settings"`
+	Revision       int                  `firestore:"revision"` // Number of the latest revision
+	UpdatedAt      int64                `firestore:"updatedAt"`
+
+	// Flashcards, executive summary and chunk summaries of the sources, kept
+	// for the results of refinements
+	Flashcards     []slides.Flashcard    `firestore:"flashcards,omitempty"`
+	OnePager       string                `firestore:"onePager,omitempty"`
+	ChunkSummaries []slides.ChunkSummary `firestore:"chunkSummaries,omitempty"`
+}
+
+// FirestoreRevision is the Firestore representation of a revision of a deck
+type FirestoreRevision struct {
+	Number       int    `firestore:"number"`
+	Markdown     string `firestore:"markdown"`
+	Instruction  string `firestore:"instruction,omitempty"`  // Refinement that produced the revision, empty for the generated deck
+	BaseRevision int    `firestore:"baseRevision,omitempty"` // Revision the refinement was applied to
+	Slide        int    `firestore:"slide,omitempty"`        // Slide regenerated from feedback, with the instruction as the reason
+	CreatedAt    int64  `firestore:"createdAt"`
+}
+
+// FirestoreGeminiFile is the Firestore representation of a Gemini file
+// uploaded for a content hash, shared by the jobs that submit the same content
+type FirestoreGeminiFile struct {
+	Name      string    `firestore:"name"`
+	URI       string    `firestore:"uri"`
+	ExpiresAt int64     `firestore:"expiresAt"`
+	DeleteAt  time.Time `firestore:"deleteAt"` // Same as ExpiresAt, for the Firestore TTL policy
+}
+
+// FirestoreDependency is the Firestore representation of the circuit breaker
+// of a dependency, which the API reads to stop taking jobs while it is down
+type FirestoreDependency struct {
+	OpenUntil int64 `firestore:"openUntil"` // End of the cooldown of the open breaker, 0 when the dependency is up
+	UpdatedAt int64 `firestore:"updatedAt"`
+}
+
+// FirestoreCapture is the Firestore representation of the prompts and raw
+// responses of a job captured for debugging, deleted by the Firestore TTL
+// policy once it expires
+type FirestoreCapture struct {
+	ID        string            `firestore:"id"`
+	Exchanges []slides.Exchange `firestore:"exchanges"`
+	Truncated bool              `firestore:"truncated,omitempty"` // Some prompts or responses were cut short or left out
+	Task      string            `firestore:"task,omitempty"`      // JSON task of the job with its files under captures/, which the API replays
+	CreatedAt int64             `firestore:"createdAt"`
+	ExpiresAt int64             `firestore:"expiresAt"`
+	DeleteAt  time.Time         `firestore:"deleteAt"` // Same as ExpiresAt, for the Firestore TTL policy
+}
+
+// JobStore persists job state and results
+type JobStore interface {
+	// GetJob returns a job, or ErrNotFound
+	GetJob(ctx context.Context, id string) (*FirestoreJob, error)
+	// UpdateJob sets the given fields on a job
+	UpdateJob(ctx context.Context, id string, fields map[string]interface{}) error
+	// TransitionJob atomically moves a job to a status along with the given
+	// fields, or returns ErrIllegalTransition if the job can't move to it from
+	// its current status, or ErrNotFound
+	TransitionJob(ctx context.Context, id string, status JobStatus, fields map[string]interface{}) error
+	// StoreResult stores the result of a job
+	StoreResult(ctx context.Context, result FirestoreResult) error
+
+	// AddRevision stores a revision of a deck under the next revision number,
+	// creating the deck with its first revision, and returns the number
+	AddRevision(ctx context.Context, deck FirestoreDeck, revision FirestoreRevision) (int, error)
+	// GetDeck returns a deck, or ErrNotFound
+	GetDeck(ctx context.Context, id string) (*FirestoreDeck, error)
+	// GetRevision returns a revision of a deck, or ErrNotFound
+	GetRevision(ctx context.Context, id string, number int) (*FirestoreRevision, error)
+
+	// StoreCapture stores the capture of a job, replacing the one of an
+	// earlier attempt
+	StoreCapture(ctx context.Context, capture FirestoreCapture) error
+
+	// UpdateLibraryDocument sets the given fields on a document of a
+	// workspace library, or returns ErrNotFound if it was deleted
+	UpdateLibraryDocument(ctx context.Context, workspaceID, documentID string, fields map[string]interface{}) error
+}
+
+// Statuses the slides service gives the documents of a workspace library
+// once it has indexed them
+const (
+	DocumentReady  = "ready"
+	DocumentFailed = "failed"
+)
+
+// BlobStore reads the uploaded source files and stores the generated documents
+type BlobStore interface {
+	// Download returns the contents and content type of a file, or ErrNotFound
+	Download(ctx context.Context, path string) ([]byte, string, error)
+	// Upload writes a file to the given path
+	Upload(ctx context.Context, path, contentType string, data []byte) error
+	// Delete removes a file
+	Delete(ctx context.Context, path string) error
+}
@@ -0,0 +1,17 @@
+package jobs
+
+import "testing"
+
+func TestResultReplacesKeepsLaterExpiryAndCounters(t *testing.T) {
+	result := FirestoreResult{ID: "job-1", ExpiresAt: 2000}
+	result.Replaces(FirestoreResult{ID: "job-1", ExpiresAt: 9000, Downloads: 4, LastAccessedAt: 1500})
+	if result.ExpiresAt != 9000 || result.Downloads != 4 || result.LastAccessedAt != 1500 {
+		t.Fatalf("expected the extended expiry and counters kept, got %+v", result)
+	}
+
+	result = FirestoreResult{ID: "job-1", ExpiresAt: 2000}
+	result.Replaces(FirestoreResult{ID: "job-1", ExpiresAt: 1000})
+	if result.ExpiresAt != 2000 {
+		t.Fatalf("expected the new expiry when it is later, got %d", result.ExpiresAt)
+	}
+}
@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/slides-service/services/slides"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreTheme is the Firestore representation of a contributed theme,
+// registered by the API under its name
+type FirestoreTheme struct {
+	Name    string `firestore:"name"`
+	CSSPath string `firestore:"cssPath"`
+	Hash    string `firestore:"hash"` // SHA-256 of the stylesheet
+}
+
+// ThemeStore is a slides.ThemeRegistry reading the themes the API registers.
+// Stylesheets are cached on disk by hash, so each version of a theme is
+// downloaded once per instance.
+type ThemeStore struct {
+	client   *firestore.Client
+	blobs    BlobStore
+	cacheDir string
+}
+
+// NewThemeStore creates a new theme store caching the stylesheets in cacheDir
+func NewThemeStore(client *firestore.Client, blobs BlobStore, cacheDir string) *ThemeStore {
+	return &ThemeStore{
+		client:   client,
+		blobs:    blobs,
+		cacheDir: cacheDir,
+	}
+}
+
+// ThemeCSS returns the stylesheet of a contributed theme, or slides.ErrThemeNotFound
+func (s *ThemeStore) ThemeCSS(ctx context.Context, name string) (string, error) {
+	doc, err := s.client.Collection("themes").Doc(name).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return "", slides.ErrThemeNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get theme: %v", err)
+	}
+	var theme FirestoreTheme
+	if err := doc.DataTo(&theme); err != nil {
+		return "", fmt.Errorf("failed to parse theme: %v", err)
+	}
+	return s.load(ctx, theme)
+}
+
+// FontFile returns an uploaded font file. Font files are stored by content,
+// so the cached copy of a path never changes.
+func (s *ThemeStore) FontFile(ctx context.Context, path string) ([]byte, error) {
+	cachePath := filepath.Join(s.cacheDir, "fonts", strings.ReplaceAll(path, "/", "_"))
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return data, nil
+	}
+
+	data, _, err := s.blobs.Download(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download font: %v", err)
+	}
+	s.cache(cachePath, data)
+	return data, nil
+}
+
+// load returns the stylesheet of a theme from the cache, downloading it when
+// this version isn't cached yet
+func (s *ThemeStore) load(ctx context.Context, theme FirestoreTheme) (string, error) {
+	cachePath := filepath.Join(s.cacheDir, theme.Name+"-"+theme.Hash+".css")
+	if css, err := os.ReadFile(cachePath); err == nil {
+		return string(css), nil
+	}
+
+	css, _, err := s.blobs.Download(ctx, theme.CSSPath)
+	if errors.Is(err, ErrNotFound) {
+		return "", slides.ErrThemeNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download stylesheet: %v", err)
+	}
+	sum := sha256.Sum256(css)
+	if hex.EncodeToString(sum[:]) != theme.Hash {
+		return "", fmt.Errorf("stylesheet of theme %s doesn't match its hash", theme.Name)
+	}
+
+	s.cache(cachePath, css)
+	return string(css), nil
+}
+
+// cache writes a copy of a file to the cache under a temporary name first, so
+// concurrent renders never read a partial file. Files that can't be cached are
+// downloaded again next time.
+func (s *ThemeStore) cache(cachePath string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), "*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cachePath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,148 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+const (
+	// sendGridURL is the SendGrid v3 mail send endpoint
+	sendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+	// maxAttachmentSize is the largest PDF sent as an attachment, larger decks are sent as a link
+	maxAttachmentSize = 10 << 20 // 10 MB
+)
+
+// EmailService sends emails about finished decks through SendGrid
+type EmailService struct {
+	apiURL         string
+	apiKey         string
+	fromEmail      string
+	resultsBaseURL string
+	httpClient     *http.Client
+}
+
+// NewEmailService creates a new email service. Emails are disabled when the
+// API key is empty.
+func NewEmailService(apiKey, fromEmail, resultsBaseURL string) *EmailService {
+	return &EmailService{
+		apiURL:         sendGridURL,
+		apiKey:         apiKey,
+		fromEmail:      fromEmail,
+		resultsBaseURL: resultsBaseURL,
+		httpClient:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Enabled reports whether emails can be sent
+func (s *EmailService) Enabled() bool {
+	return s.apiKey != ""
+}
+
+// sendGridAddress is an email address in a SendGrid request
+type sendGridAddress struct {
+	Email string `json:"email"`
+}
+
+// sendGridPersonalization lists the recipients of a SendGrid email
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+// sendGridAttachment is a base64 encoded attachment in a SendGrid request
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+}
+
+// sendGridRequest is the body of a SendGrid mail send request
+type sendGridRequest struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []map[string]string       `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+}
+
+// SendDeckReady emails a finished deck to the given address. The PDF is
+// attached when it is small enough, otherwise the email links to the result,
+// which is kept for retention after it was generated.
+func (s *EmailService) SendDeckReady(ctx context.Context, to, jobID string, pdfData []byte, retention time.Duration) error {
+	if !s.Enabled() {
+		return fmt.Errorf("email notifications are not configured")
+	}
+	// The API checks the address, but a bare one is all SendGrid is given
+	address, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %v", err)
+	}
+
+	resultURL := fmt.Sprintf("%s/v1/results/%s", s.resultsBaseURL, jobID)
+	body := fmt.Sprintf("Your presentation is ready.\n\nView it online: %s\nDownload the PDF: %s?download=true\n\nThe links expire %s after the presentation was generated.", resultURL, resultURL, formatRetention(retention))
+
+	req := sendGridRequest{
+		Personalizations: []sendGridPersonalization{{To: []sendGridAddress{{Email: address.Address}}}},
+		From:             sendGridAddress{Email: s.fromEmail},
+		Subject:          "Your presentation is ready",
+	}
+
+	if len(pdfData) <= maxAttachmentSize {
+		body = fmt.Sprintf("Your presentation is ready and attached to this email as a PDF.\n\nView it online: %s\n\nThe link expires %s after the presentation was generated.", resultURL, formatRetention(retention))
+		req.Attachments = []sendGridAttachment{{
+			Content:     base64.StdEncoding.EncodeToString(pdfData),
+			Type:        "application/pdf",
+			Filename:    fmt.Sprintf("presentation-%s.pdf", jobID),
+			Disposition: "attachment",
+		}}
+	}
+	req.Content = []map[string]string{{"type": "text/plain", "value": body}}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal email: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.apiURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create email request: %v", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+s.apiKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("email provider returned %s: %s", resp.Status, string(respBody))
+	}
+
+	log.Printf("Sent deck ready email for job %s", jobID)
+	return nil
+}
+
+// formatRetention spells out how long a result is kept, such as "one hour"
+// or "90 minutes"
+func formatRetention(retention time.Duration) string {
+	unit, count := "minute", int(retention.Round(time.Minute)/time.Minute)
+	if retention%time.Hour == 0 {
+		unit, count = "hour", int(retention/time.Hour)
+	}
+	if count == 1 {
+		return "one " + unit
+	}
+	return fmt.Sprintf("%d %ss", count, unit)
+}
@@ -0,0 +1,107 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sendGridServer records the requests sent to it
+func sendGridServer(t *testing.T, requests *[]sendGridRequest) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sg-key" {
+			t.Errorf("expected the API key, got %q", r.Header.Get("Authorization"))
+		}
+		var req sendGridRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		*requests = append(*requests, req)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSendDeckReadyAttachesSmallDecks(t *testing.T) {
+	var requests []sendGridRequest
+	service := NewEmailService("sg-key", "no-reply@example.com", "https://api.example.com")
+	service.apiURL = sendGridServer(t, &requests).URL
+
+	if err := service.SendDeckReady(context.Background(), "Ada <ada@example.com>", "job-1", []byte("%PDF"), time.Hour); err != nil {
+		t.Fatalf("SendDeckReady failed: %v", err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+	req := requests[0]
+	if req.Personalizations[0].To[0].Email != "ada@example.com" || req.From.Email != "no-reply@example.com" {
+		t.Fatalf("unexpected addresses: %+v", req)
+	}
+	if len(req.Attachments) != 1 || req.Attachments[0].Filename != "presentation-job-1.pdf" || req.Attachments[0].Content != base64.StdEncoding.EncodeToString([]byte("%PDF")) {
+		t.Fatalf("expected the PDF attached, got %+v", req.Attachments)
+	}
+	if !strings.Contains(req.Content[0]["value"], "https://api.example.com/v1/results/job-1") {
+		t.Fatalf("expected a link to the result, got %q", req.Content[0]["value"])
+	}
+	if !strings.Contains(req.Content[0]["value"], "expires one hour after") {
+		t.Fatalf("expected the retention of the result, got %q", req.Content[0]["value"])
+	}
+}
+
+func TestSendDeckReadyLinksLargeDecks(t *testing.T) {
+	var requests []sendGridRequest
+	service := NewEmailService("sg-key", "no-reply@example.com", "https://api.example.com")
+	service.apiURL = sendGridServer(t, &requests).URL
+
+	if err := service.SendDeckReady(context.Background(), "ada@example.com", "job-1", bytes.Repeat([]byte("x"), maxAttachmentSize+1), 90*time.Minute); err != nil {
+		t.Fatalf("SendDeckReady failed: %v", err)
+	}
+	if len(requests[0].Attachments) != 0 || !strings.Contains(requests[0].Content[0]["value"], "job-1?download=true") {
+		t.Fatalf("expected a download link instead of an attachment, got %+v", requests[0])
+	}
+	if !strings.Contains(requests[0].Content[0]["value"], "expire 90 minutes after") {
+		t.Fatalf("expected the retention of the result, got %q", requests[0].Content[0]["value"])
+	}
+}
+
+func TestFormatRetention(t *testing.T) {
+	tests := []struct {
+		retention time.Duration
+		want      string
+	}{
+		{time.Hour, "one hour"},
+		{24 * time.Hour, "24 hours"},
+		{time.Minute, "one minute"},
+		{90 * time.Minute, "90 minutes"},
+	}
+	for _, test := range tests {
+		if got := formatRetention(test.retention); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.retention, test.want, got)
+		}
+	}
+}
+
+func TestSendDeckReadyRejectsInvalidAddresses(t *testing.T) {
+	var requests []sendGridRequest
+	service := NewEmailService("sg-key", "no-reply@example.com", "https://api.example.com")
+	service.apiURL = sendGridServer(t, &requests).URL
+
+	for _, to := range []string{"not an address", "a@example.com, b@example.com"} {
+		if err := service.SendDeckReady(context.Background(), to, "job-1", nil, time.Hour); err == nil {
+			t.Fatalf("expected %q to be rejected", to)
+		}
+	}
+	if len(requests) != 0 {
+		t.Fatalf("expected nothing sent, got %d requests", len(requests))
+	}
+	if err := NewEmailService("", "no-reply@example.com", "").SendDeckReady(context.Background(), "ada@example.com", "job-1", nil, time.Hour); err == nil {
+		t.Fatal("expected an error without an API key")
+	}
+}
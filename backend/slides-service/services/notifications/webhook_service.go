@@ -0,0 +1,75 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Webhook is a chat webhook notified when a job completes
+type Webhook struct {
+	Type string `json:"type"` // Values: slack, discord
+	URL  string `json:"url"`
+}
+
+// WebhookService posts completion messages to Slack and Discord webhooks
+type WebhookService struct {
+	resultsBaseURL string
+	httpClient     *http.Client
+}
+
+// NewWebhookService creates a new webhook service
+func NewWebhookService(resultsBaseURL string) *WebhookService {
+	return &WebhookService{
+		resultsBaseURL: resultsBaseURL,
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// PostDeckReady posts a message with the deck link to a webhook. The link
+// expires retention after the deck was generated.
+func (s *WebhookService) PostDeckReady(ctx context.Context, webhook Webhook, jobID string, retention time.Duration) error {
+	resultURL := fmt.Sprintf("%s/v1/results/%s", s.resultsBaseURL, jobID)
+	message := fmt.Sprintf("A new presentation is ready: %s (PDF: %s?download=true). The link expires in %s.", resultURL, resultURL, formatRetention(retention))
+
+	// Slack and Discord use different field names for the message text
+	var body map[string]string
+	switch webhook.Type {
+	case "slack":
+		body = map[string]string{"text": message}
+	case "discord":
+		body = map[string]string{"content": message}
+	default:
+		return fmt.Errorf("unsupported webhook type: %s", webhook.Type)
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook message: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to %s webhook: %v", webhook.Type, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s webhook returned %s: %s", webhook.Type, resp.Status, string(respBody))
+	}
+
+	log.Printf("Posted deck ready message for job %s to %s", jobID, webhook.Type)
+	return nil
+}
@@ -0,0 +1,72 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookServer records the bodies posted to it and answers with a status
+func webhookServer(t *testing.T, status int, bodies *[]map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON body, got %q", r.Header.Get("Content-Type"))
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		*bodies = append(*bodies, body)
+		w.WriteHeader(status)
+		w.Write([]byte("invalid_token"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPostDeckReadyPayloads(t *testing.T) {
+	tests := []struct {
+		webhookType string
+		field       string
+	}{
+		{"slack", "text"},
+		{"discord", "content"},
+	}
+	for _, test := range tests {
+		var bodies []map[string]string
+		server := webhookServer(t, http.StatusOK, &bodies)
+		service := NewWebhookService("https://api.example.com")
+
+		if err := service.PostDeckReady(context.Background(), Webhook{Type: test.webhookType, URL: server.URL}, "job-1", 2*time.Hour); err != nil {
+			t.Fatalf("%s: PostDeckReady failed: %v", test.webhookType, err)
+		}
+		if len(bodies) != 1 || len(bodies[0]) != 1 {
+			t.Fatalf("%s: expected one message field, got %v", test.webhookType, bodies)
+		}
+		message := bodies[0][test.field]
+		if !strings.Contains(message, "https://api.example.com/v1/results/job-1 ") || !strings.Contains(message, "https://api.example.com/v1/results/job-1?download=true") {
+			t.Fatalf("%s: expected links to the deck and its PDF, got %q", test.webhookType, message)
+		}
+		if !strings.HasSuffix(message, "The link expires in 2 hours.") {
+			t.Fatalf("%s: expected the retention of the deck, got %q", test.webhookType, message)
+		}
+	}
+}
+
+func TestPostDeckReadyFailures(t *testing.T) {
+	service := NewWebhookService("https://api.example.com")
+	if err := service.PostDeckReady(context.Background(), Webhook{Type: "teams", URL: "https://example.com"}, "job-1", time.Hour); err == nil || !strings.Contains(err.Error(), "unsupported webhook type") {
+		t.Fatalf("expected an unsupported type error, got %v", err)
+	}
+
+	var bodies []map[string]string
+	server := webhookServer(t, http.StatusForbidden, &bodies)
+	err := service.PostDeckReady(context.Background(), Webhook{Type: "slack", URL: server.URL}, "job-1", time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "invalid_token") {
+		t.Fatalf("expected the rejection with its body, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package prompts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+func TestCitationsPromptRespectsAccessibility(t *testing.T) {
+	files := []models.File{{Filename: "paper.pdf", Type: "application/pdf"}}
+
+	prompt, err := GenerateSlidePrompt("default", models.SlideSettings{IncludeCitations: true}, files)
+	if err != nil {
+		t.Fatalf("GenerateSlidePrompt failed: %v", err)
+	}
+	if !strings.Contains(prompt, "in the exact form [p. N]") || !strings.Contains(prompt, "_class: tinytext") {
+		t.Fatalf("expected page citations and a tinytext references slide, got:\n%s", prompt)
+	}
+	if strings.Contains(prompt, "[filename.pdf, p. N]") {
+		t.Fatal("expected file names to be left out of the citations of a single document")
+	}
+
+	prompt, err = GenerateSlidePrompt("default", models.SlideSettings{IncludeCitations: true, Accessibility: true}, files)
+	if err != nil {
+		t.Fatalf("GenerateSlidePrompt failed: %v", err)
+	}
+	if strings.Contains(prompt, "_class: tinytext") {
+		t.Fatalf("expected accessible decks not to be told to use tinytext, got:\n%s", prompt)
+	}
+	if !strings.Contains(prompt, "Do not use the tinytext class") || !strings.Contains(prompt, "Split the references across more slides") {
+		t.Fatalf("expected the accessibility rules to apply to the references, got:\n%s", prompt)
+	}
+}
+
+func TestCitationsPromptNamesFilesOfMultipleDocuments(t *testing.T) {
+	files := []models.File{
+		{Filename: "a.pdf", Type: "application/pdf"},
+		{Filename: "b.pdf", Type: "application/pdf"},
+	}
+	prompt, err := GenerateSlidePrompt("default", models.SlideSettings{IncludeCitations: true}, files)
+	if err != nil {
+		t.Fatalf("GenerateSlidePrompt failed: %v", err)
+	}
+	if !strings.Contains(prompt, "[filename.pdf, p. N]") {
+		t.Fatalf("expected file names in the citations of multiple documents, got:\n%s", prompt)
+	}
+}
+
+func TestCitationsPromptNeedsPDF(t *testing.T) {
+	files := []models.File{{Filename: "notes.md", Type: "text/markdown"}}
+	prompt, err := GenerateSlidePrompt("default", models.SlideSettings{IncludeCitations: true}, files)
+	if err != nil {
+		t.Fatalf("GenerateSlidePrompt failed: %v", err)
+	}
+	if strings.Contains(prompt, "CITATIONS:") {
+		t.Fatal("expected no citations without a PDF to cite")
+	}
+}
@@ -0,0 +1,330 @@
+package slides
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// minContrastRatio is the WCAG AA contrast ratio for normal text
+	minContrastRatio = 4.5
+
+	// minFontSizePx is the smallest font size allowed on a 1280x720 slide
+	minFontSizePx = 18.0
+
+	// defaultFontSizePx is the base font size Marp uses for slides
+	defaultFontSizePx = 29.0
+)
+
+// AccessibilityReport describes the accessibility checks run on a presentation
+type AccessibilityReport struct {
+	Theme          string          `json:"theme"`
+	Passed         bool            `json:"passed"`
+	ImagesTotal    int             `json:"imagesTotal"`
+	AltTextAdded   []string        `json:"altTextAdded,omitempty"`
+	ContrastChecks []ContrastCheck `json:"contrastChecks,omitempty"`
+	FontSizeFixes  []FontSizeFix   `json:"fontSizeFixes,omitempty"`
+	Warnings       []string        `json:"warnings,omitempty"`
+}
+
+// ContrastCheck is the result of a color contrast check for a theme selector
+type ContrastCheck struct {
+	Selector   string  `json:"selector"`
+	Foreground string  `json:"foreground"`
+	Background string  `json:"background"`
+	Ratio      float64 `json:"ratio"`
+	Passed     bool    `json:"passed"`
+}
+
+// FontSizeFix records a font size in the theme CSS that was raised to the minimum
+type FontSizeFix struct {
+	Selector string `json:"selector"`
+	Original string `json:"original"`
+	Applied  string `json:"applied"`
+}
+
+// Foreground and background colors of Marp's built-in themes
+var builtinThemeColors = map[string][2]string{
+	"default": {"#1f2328", "#ffffff"},
+	"gaia":    {"#455a64", "#fff8e1"},
+	"uncover": {"#202228", "#fdfcff"},
+}
+
+var (
+	markdownImagePattern = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)(\s+"[^"]*")?\)`)
+	cssRulePattern       = regexp.MustCompile(`([^{}]+)\{([^{}]*)\}`)
+	cssVariablePattern   = regexp.MustCompile(`(--[\w-]+)\s*:\s*([^;]+);`)
+	cssVarRefPattern     = regexp.MustCompile(`^var\((--[\w-]+)\)$`)
+	cssFontSizePattern   = regexp.MustCompile(`font-size\s*:\s*([\d.]+)(px|pt|em|rem)`)
+	cssCommentPattern    = regexp.MustCompile(`(?s)/\*.*?\*/`)
+)
+
+// addMissingAltText gives every markdown image without alt text a description
+// derived from its file name and records the images it changed
+func addMissingAltText(markdown string, report *AccessibilityReport) string {
+	return markdownImagePattern.ReplaceAllStringFunc(markdown, func(match string) string {
+		groups := markdownImagePattern.FindStringSubmatch(match)
+		report.ImagesTotal++
+
+		alt := strings.TrimSpace(groups[1])
+		// Marp image directives (bg, w:100px, ...) are not descriptions
+		if alt != "" && !isMarpImageDirective(alt) {
+			return match
+		}
+
+		description := describeImage(groups[2])
+		report.AltTextAdded = append(report.AltTextAdded, groups[2])
+		if alt != "" {
+			description = alt + " " + description
+		}
+		return fmt.Sprintf("![%s](%s%s)", description, groups[2], groups[3])
+	})
+}
+
+// isMarpImageDirective reports whether the alt text only contains Marp image keywords
+func isMarpImageDirective(alt string) bool {
+	for _, word := range strings.Fields(alt) {
+		if word == "bg" || word == "left" || word == "right" || word == "contain" || word == "cover" || word == "fit" ||
+			strings.Contains(word, ":") || strings.HasSuffix(word, "%") {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// describeImage builds a human readable description from an image URL
+func describeImage(url string) string {
+	name := filepath.Base(strings.SplitN(url, "?", 2)[0])
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.NewReplacer("-", " ", "_", " ", ".", " ").Replace(name)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Image"
+	}
+	return "Image: " + name
+}
+
+// checkThemeAccessibility checks the contrast ratios of the theme colors and
+// returns a copy of the theme CSS with font sizes raised to the minimum. The
+// theme CSS is empty for themes built into Marp. The returned CSS is empty
+// when the theme is built into Marp or needs no changes.
+func checkThemeAccessibility(theme, themeCSS string, report *AccessibilityReport) string {
+	if themeCSS == "" {
+		// Built-in Marp theme, only the base colors can be checked
+		if colors, ok := builtinThemeColors[theme]; ok {
+			report.ContrastChecks = append(report.ContrastChecks, newContrastCheck("section", colors[0], colors[1]))
+		} else {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("Colors of theme %s could not be checked", theme))
+		}
+		return ""
+	}
+	css := cssCommentPattern.ReplaceAllString(themeCSS, "")
+
+	// Resolve CSS custom properties so var() colors can be compared
+	variables := make(map[string]string)
+	for _, match := range cssVariablePattern.FindAllStringSubmatch(css, -1) {
+		variables[match[1]] = strings.TrimSpace(match[2])
+	}
+	resolve := func(value string) string {
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+		if ref := cssVarRefPattern.FindStringSubmatch(value); ref != nil {
+			return variables[ref[1]]
+		}
+		return value
+	}
+
+	// Find the slide background and base font size
+	background := "#ffffff"
+	baseFontSize := defaultFontSizePx
+	backgroundImage := false
+	rules := cssRulePattern.FindAllStringSubmatch(css, -1)
+	for _, rule := range rules {
+		selector := strings.TrimSpace(rule[1])
+		if selector != "section" && !strings.HasSuffix(selector, ":root") {
+			continue
+		}
+		if value := cssDeclaration(rule[2], "background-color"); value != "" {
+			background = resolve(value)
+		}
+		if value := cssDeclaration(rule[2], "background-image"); value != "" && selector == "section" {
+			backgroundImage = true
+		}
+		if size := cssFontSizePattern.FindStringSubmatch(rule[2]); size != nil && size[2] != "em" && size[2] != "rem" {
+			baseFontSize = fontSizeInPixels(size[1], size[2], defaultFontSizePx)
+		}
+	}
+
+	// Compare every text color against its own background or the slide background
+	for _, rule := range rules {
+		value := cssDeclaration(rule[2], "color")
+		if value == "" {
+			continue
+		}
+		ruleBackground := background
+		if bg := cssDeclaration(rule[2], "background-color"); bg != "" {
+			ruleBackground = resolve(bg)
+		}
+		selector := strings.Join(strings.Fields(rule[1]), " ")
+		if backgroundImage && (selector == "header" || selector == "footer" || selector == "section::after") {
+			// These are drawn on top of the background image, whose colors are unknown
+			report.Warnings = append(report.Warnings, fmt.Sprintf("Contrast of %s could not be checked against the background image", selector))
+			continue
+		}
+		foreground := resolve(value)
+		if _, ok := parseColor(foreground); !ok {
+			continue
+		}
+		if _, ok := parseColor(ruleBackground); !ok {
+			continue
+		}
+		report.ContrastChecks = append(report.ContrastChecks, newContrastCheck(selector, foreground, ruleBackground))
+	}
+
+	// Raise font sizes below the minimum, keeping the comments since Marp
+	// reads the theme name from the @theme comment
+	patched := cssRulePattern.ReplaceAllStringFunc(themeCSS, func(rule string) string {
+		groups := cssRulePattern.FindStringSubmatch(rule)
+		selector := strings.Join(strings.Fields(cssCommentPattern.ReplaceAllString(groups[1], "")), " ")
+		return cssFontSizePattern.ReplaceAllStringFunc(rule, func(declaration string) string {
+			size := cssFontSizePattern.FindStringSubmatch(declaration)
+			if fontSizeInPixels(size[1], size[2], baseFontSize) >= minFontSizePx {
+				return declaration
+			}
+			applied := fmt.Sprintf("%gpx", minFontSizePx)
+			report.FontSizeFixes = append(report.FontSizeFixes, FontSizeFix{
+				Selector: selector,
+				Original: size[1] + size[2],
+				Applied:  applied,
+			})
+			return "font-size: " + applied
+		})
+	})
+
+	if len(report.FontSizeFixes) == 0 {
+		return ""
+	}
+	return patched
+}
+
+// finalize computes whether all checks passed
+func (r *AccessibilityReport) finalize() {
+	r.Passed = true
+	for _, check := range r.ContrastChecks {
+		if !check.Passed {
+			r.Passed = false
+		}
+	}
+}
+
+// newContrastCheck computes the contrast ratio between two colors
+func newContrastCheck(selector, foreground, background string) ContrastCheck {
+	ratio := contrastRatio(foreground, background)
+	return ContrastCheck{
+		Selector:   selector,
+		Foreground: foreground,
+		Background: background,
+		Ratio:      math.Round(ratio*100) / 100,
+		Passed:     ratio >= minContrastRatio,
+	}
+}
+
+// cssDeclaration returns the value of a property in a CSS declaration block
+func cssDeclaration(block, property string) string {
+	for _, declaration := range strings.Split(block, ";") {
+		parts := strings.SplitN(declaration, ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == property {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
+
+// fontSizeInPixels converts a CSS font size to pixels
+func fontSizeInPixels(value, unit string, base float64) float64 {
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return base
+	}
+	switch unit {
+	case "pt":
+		return size * 4 / 3
+	case "em", "rem":
+		return size * base
+	default:
+		return size
+	}
+}
+
+// contrastRatio computes the WCAG contrast ratio between two colors
+func contrastRatio(foreground, background string) float64 {
+	fg, _ := parseColor(foreground)
+	bg, _ := parseColor(background)
+	l1, l2 := relativeLuminance(fg), relativeLuminance(bg)
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+// relativeLuminance computes the WCAG relative luminance of an RGB color
+func relativeLuminance(rgb [3]float64) float64 {
+	channels := [3]float64{}
+	for i, c := range rgb {
+		c /= 255
+		if c <= 0.03928 {
+			channels[i] = c / 12.92
+		} else {
+			channels[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+	return 0.2126*channels[0] + 0.7152*channels[1] + 0.0722*channels[2]
+}
+
+// parseColor parses hex, rgb() and a few named CSS colors
+func parseColor(value string) ([3]float64, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "white":
+		return [3]float64{255, 255, 255}, true
+	case "black":
+		return [3]float64{0, 0, 0}, true
+	}
+
+	if strings.HasPrefix(value, "#") {
+		hex := value[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return [3]float64{}, false
+		}
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return [3]float64{}, false
+		}
+		return [3]float64{float64(n >> 16 & 0xff), float64(n >> 8 & 0xff), float64(n & 0xff)}, true
+	}
+
+	if strings.HasPrefix(value, "rgb(") && strings.HasSuffix(value, ")") {
+		parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(value, "rgb("), ")"), ",")
+		if len(parts) != 3 {
+			return [3]float64{}, false
+		}
+		var rgb [3]float64
+		for i, part := range parts {
+			c, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return [3]float64{}, false
+			}
+			rgb[i] = c
+		}
+		return rgb, true
+	}
+
+	return [3]float64{}, false
+}
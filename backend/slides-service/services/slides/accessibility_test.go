@@ -0,0 +1,77 @@
+package slides
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddMissingAltText(t *testing.T) {
+	markdown := `![](images/sales-chart_2024.png)
+![bg left:40%](https://example.com/photo.jpg "Title")
+![A diagram of the pipeline](pipeline.svg)`
+
+	report := &AccessibilityReport{}
+	got := addMissingAltText(markdown, report)
+	want := `![Image: sales chart 2024](images/sales-chart_2024.png)
+![bg left:40% Image: photo](https://example.com/photo.jpg "Title")
+![A diagram of the pipeline](pipeline.svg)`
+	if got != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, got)
+	}
+	if report.ImagesTotal != 3 {
+		t.Fatalf("expected 3 images, got %d", report.ImagesTotal)
+	}
+	if !reflect.DeepEqual(report.AltTextAdded, []string{"images/sales-chart_2024.png", "https://example.com/photo.jpg"}) {
+		t.Fatalf("unexpected images with added alt text: %v", report.AltTextAdded)
+	}
+}
+
+func TestCheckThemeAccessibility(t *testing.T) {
+	css := `/* @theme custom */
+:root { --fg: #777777; }
+section { background-color: #ffffff; color: #111111; font-size: 24px; }
+h1 { color: var(--fg); }
+footer { font-size: 12px; }`
+
+	report := &AccessibilityReport{}
+	patched := checkThemeAccessibility("custom", css, report)
+	report.finalize()
+
+	if report.Passed {
+		t.Fatal("expected the light grey headers to fail the contrast check")
+	}
+	var headers *ContrastCheck
+	for i, check := range report.ContrastChecks {
+		if check.Selector == "h1" {
+			headers = &report.ContrastChecks[i]
+		}
+	}
+	if headers == nil || headers.Foreground != "#777777" || headers.Passed {
+		t.Fatalf("expected a failed check of the resolved header color, got %+v", report.ContrastChecks)
+	}
+
+	if len(report.FontSizeFixes) != 1 || report.FontSizeFixes[0] != (FontSizeFix{Selector: "footer", Original: "12px", Applied: "18px"}) {
+		t.Fatalf("unexpected font size fixes: %+v", report.FontSizeFixes)
+	}
+	if !strings.Contains(patched, "/* @theme custom */") || !strings.Contains(patched, "footer { font-size: 18px; }") {
+		t.Fatalf("expected the theme comment kept and the footer enlarged, got:\n%s", patched)
+	}
+}
+
+func TestCheckBuiltinThemeAccessibility(t *testing.T) {
+	report := &AccessibilityReport{}
+	if patched := checkThemeAccessibility("gaia", "", report); patched != "" {
+		t.Fatalf("expected built-in themes not to be patched, got %q", patched)
+	}
+	report.finalize()
+	if !report.Passed || len(report.ContrastChecks) != 1 {
+		t.Fatalf("expected the colors of gaia to pass, got %+v", report)
+	}
+
+	report = &AccessibilityReport{}
+	checkThemeAccessibility("unknown", "", report)
+	if len(report.Warnings) != 1 {
+		t.Fatalf("expected a warning for an unknown theme, got %+v", report)
+	}
+}
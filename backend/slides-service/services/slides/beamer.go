@@ -0,0 +1,204 @@
+package slides
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+)
+
+// beamerTheme is the Beamer theme and color theme closest to a theme
+type beamerTheme struct {
+	theme      string
+	colorTheme string
+}
+
+// beamerThemes maps the themes to Beamer, themes not listed use the default theme
+var beamerThemes = map[string]beamerTheme{
+	"default":     {"metropolis", ""},
+	"beam":        {"Madrid", "beaver"},
+	"rose_pine":   {"metropolis", ""},
+	"gaia":        {"Boadilla", "seahorse"},
+	"uncover":     {"default", "dove"},
+	"graph_paper": {"Singapore", "dolphin"},
+}
+
+// latexEscaper escapes text for LaTeX
+var latexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+// BeamerRenderer renders decks with Pandoc, the PDF as LaTeX Beamer and the
+// HTML as reveal.js, from the Marp markdown converted to Pandoc markdown.
+// Theme stylesheets don't apply, each theme maps to a Beamer theme.
+type BeamerRenderer struct {
+	sandbox *Sandbox // Sandbox Pandoc runs in, nil to run it directly
+}
+
+// NewBeamerRenderer creates a new Pandoc Beamer renderer
+func NewBeamerRenderer(sandbox *Sandbox) *BeamerRenderer {
+	return &BeamerRenderer{sandbox: sandbox}
+}
+
+// Render runs Pandoc to convert the markdown to a Beamer PDF and a reveal.js page
+func (r *BeamerRenderer) Render(ctx context.Context, markdown string, options RenderOptions) (*RenderOutput, error) {
+	dir, err := newWorkDir("", r.sandbox)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.remove()
+
+	files := map[string]string{
+		"presentation.md": pandocMarkdown(dir.sandbox.offline(ctx, markdown)),
+		"header.tex":      beamerHeader(options.Footer, options.Watermark),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(dir.file(name), []byte(content), 0644); err != nil {
+			log.Printf("Failed to write %s: %v", name, err)
+			return nil, err
+		}
+	}
+
+	theme, ok := beamerThemes[options.Theme]
+	if !ok {
+		theme = beamerThemes["default"]
+	}
+	pdfArgs := []string{"presentation.md", "--to", "beamer", "--pdf-engine", "xelatex", "--slide-level", "1",
+		"--include-in-header", "header.tex", "--variable", "aspectratio=169", "--variable", "theme=" + theme.theme,
+		"--output", "presentation.pdf"}
+	if theme.colorTheme != "" {
+		pdfArgs = append(pdfArgs, "--variable", "colortheme="+theme.colorTheme)
+	}
+	if err := dir.run(ctx, "pandoc", pdfArgs...); err != nil {
+		return nil, stepError(ctx, err, "failed to generate PDF. Please try again.")
+	}
+	pdfBytes, err := os.ReadFile(dir.file("presentation.pdf"))
+	if err != nil {
+		log.Printf("Failed to read generated PDF: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Successfully generated PDF with Beamer (%d bytes)", len(pdfBytes))
+	if options.PDFOnly {
+		return &RenderOutput{PDFData: pdfBytes}, nil
+	}
+
+	// MathML needs no script, so the page stays a single file
+	if err := dir.run(ctx, "pandoc", "presentation.md", "--to", "revealjs", "--standalone", "--embed-resources",
+		"--slide-level", "1", "--mathml", "--variable", "hash=true", "--output", "presentation.html"); err != nil {
+		return nil, stepError(ctx, err, "failed to generate HTML. Please try again.")
+	}
+	htmlBytes, err := os.ReadFile(dir.file("presentation.html"))
+	if err != nil {
+		log.Printf("Failed to read generated HTML: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Successfully generated HTML with reveal.js (%d bytes)", len(htmlBytes))
+	return &RenderOutput{
+		PDFData:   pdfBytes,
+		HTMLData:  htmlBytes,
+		Thumbnail: pdfThumbnail(ctx, dir, dir.file("presentation.pdf")),
+	}, nil
+}
+
+// beamerHeader returns the LaTeX that draws the footer and watermark on every frame
+func beamerHeader(footer, watermark string) string {
+	var header strings.Builder
+	if footer != "" {
+		header.WriteString(`\setbeamertemplate{footline}{\hspace{1em}\usebeamerfont{footline}\usebeamercolor[fg]{footline}` +
+			latexEscaper.Replace(footer) + `\hfill\insertframenumber\hspace{1em}\vspace{0.5em}}` + "\n")
+	}
+	if watermark != "" {
+		header.WriteString(`\usepackage{tikz}` + "\n" +
+			`\setbeamertemplate{background}{\begin{tikzpicture}[remember picture,overlay]` +
+			`\node[rotate=30,scale=3,text=gray,opacity=0.2,font=\bfseries] at (current page.center) {` +
+			latexEscaper.Replace(watermark) + `};\end{tikzpicture}}` + "\n")
+	}
+	return header.String()
+}
+
+// pandocMarkdown converts the markdown of a Marp deck to Pandoc markdown for
+// slides at level 1. The first heading of a slide becomes the frame title and
+// the other top level headings become blocks. A title slide repeating the
+// deck title becomes the title frame, split backgrounds become an image
+// column and columns slides two columns. Full backgrounds are left out, and
+// speaker notes become notes divs.
+func pandocMarkdown(markdown string) string {
+	deck := parseMarpDeck(markdown)
+	slides := deck.Slides
+
+	metadata := []string{}
+	if deck.Title != "" {
+		metadata = append(metadata, "title: "+quoteDirective(deck.Title))
+		if len(slides) > 0 {
+			if heading, _, rest := slideHeading(slides[0].Body); stripInlineMarkdown(heading) == deck.Title {
+				if subtitle, _, _ := strings.Cut(rest, "\n"); strings.TrimSpace(subtitle) != "" {
+					metadata = append(metadata, "subtitle: "+quoteDirective(stripInlineMarkdown(strings.TrimLeft(subtitle, "# "))))
+				}
+				slides = slides[1:]
+			}
+		}
+	}
+	if deck.Language != "" {
+		metadata = append(metadata, "lang: "+deck.Language)
+	}
+
+	var out strings.Builder
+	if len(metadata) > 0 {
+		out.WriteString("---\n" + strings.Join(metadata, "\n") + "\n---\n\n")
+	}
+	for _, slide := range slides {
+		heading, level, body := slideHeading(slide.Body)
+		if level > 0 {
+			out.WriteString("# " + heading + "\n\n")
+		} else {
+			out.WriteString("---\n\n")
+		}
+
+		// Headings at the frame level would start new frames
+		lines := strings.Split(body, "\n")
+		inCode := false
+		for i, line := range lines {
+			if strings.HasPrefix(strings.TrimSpace(line), "```") {
+				inCode = !inCode
+			}
+			if !inCode && strings.HasPrefix(line, "# ") {
+				lines[i] = "#" + line
+			}
+		}
+		body = strings.Join(lines, "\n")
+
+		switch {
+		case slide.Background != "" && slide.BackgroundSide != "":
+			image := "![](" + slide.Background + "){width=100%}"
+			left, right := image, body
+			if slide.BackgroundSide == "right" {
+				left, right = body, image
+			}
+			body = pandocColumns(left, right)
+		case slide.hasClass("columns"):
+			body = pandocColumns(splitColumns(body))
+		}
+
+		out.WriteString(body + "\n\n")
+		if len(slide.Notes) > 0 {
+			out.WriteString("::: notes\n" + strings.Join(slide.Notes, "\n\n") + "\n:::\n\n")
+		}
+	}
+	return out.String()
+}
+
+// pandocColumns lays out two blocks of markdown side by side
+func pandocColumns(left, right string) string {
+	return ":::: columns\n::: column\n" + left + "\n:::\n::: column\n" + right + "\n:::\n::::"
+}
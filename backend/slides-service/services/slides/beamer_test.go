@@ -0,0 +1,24 @@
+package slides
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPandocMarkdown(t *testing.T) {
+	markdown := "---\nmarp: true\ntitle: \"Launch\"\nlang: fr\n---\n\n<!-- _class: lead -->\n\n# Launch\n\nA new product\n\n---\n\n## Roadmap\n\n![bg left](https://example.com/map.png)\n\n# Phase one\n\n- Q1\n\n<!-- Mention the beta -->\n\n---\n\n![w:200 Logo](https://example.com/logo.png)"
+
+	want := "---\ntitle: \"Launch\"\nsubtitle: \"A new product\"\nlang: fr\n---\n\n" +
+		"# Roadmap\n\n:::: columns\n::: column\n![](https://example.com/map.png){width=100%}\n:::\n::: column\n## Phase one\n\n- Q1\n:::\n::::\n\n::: notes\nMention the beta\n:::\n\n" +
+		"---\n\n![Logo](https://example.com/logo.png)\n\n"
+	if got := pandocMarkdown(markdown); got != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestBeamerHeaderEscapesText(t *testing.T) {
+	header := beamerHeader("R&D 100% {draft}", "")
+	if !strings.Contains(header, `R\&D 100\% \{draft\}`) || strings.Contains(header, "tikz") {
+		t.Fatalf("expected an escaped footer and no watermark, got:\n%s", header)
+	}
+}
@@ -0,0 +1,118 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// geminiFailureThreshold is how many Gemini calls in a row have to fail
+	// with an outage error before calls stop being made
+	geminiFailureThreshold = 5
+
+	// geminiCooldown is how long calls to Gemini stop once the breaker opens,
+	// after which one call is let through to probe whether it recovered
+	geminiCooldown = 30 * time.Second
+)
+
+// ErrGeminiUnavailable is returned instead of calling Gemini while it is
+// failing, so the task is handed back to Cloud Tasks rather than timing out
+var ErrGeminiUnavailable = errors.New("Gemini is temporarily unavailable")
+
+// geminiBreaker stops calls to Gemini while it is down
+var geminiBreaker = &breaker{threshold: geminiFailureThreshold, cooldown: geminiCooldown}
+
+// breaker is a circuit breaker. It opens after threshold failures in a row,
+// fails calls fast while open, and lets one call through once the cooldown
+// ends: the breaker closes if it succeeds and opens again if it fails.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool // A call is probing the dependency after the cooldown
+	onChange  func(openUntil time.Time)
+}
+
+// allow returns ErrGeminiUnavailable while the breaker is open, or while
+// another call is probing the dependency
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return fmt.Errorf("%w, retrying after %s", ErrGeminiUnavailable, b.openUntil.UTC().Format(time.RFC3339))
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a call let through by allow. A call cancelled
+// or timed out by the job itself says nothing about the dependency.
+func (b *breaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	b.probing = false
+	if ctx.Err() != nil {
+		b.mu.Unlock()
+		return
+	}
+	wasOpen := !b.openUntil.IsZero()
+	failed := isOutage(err)
+	opened := false
+	if failed {
+		b.failures++
+		if wasOpen || b.failures >= b.threshold {
+			b.openUntil = time.Now().Add(b.cooldown)
+			opened = true
+		}
+	} else {
+		b.failures = 0
+		b.openUntil = time.Time{}
+	}
+	openUntil, onChange := b.openUntil, b.onChange
+	b.mu.Unlock()
+
+	if onChange != nil && (opened || (wasOpen && !failed)) {
+		go onChange(openUntil)
+	}
+}
+
+// OnGeminiStateChange calls fn whenever the Gemini breaker opens or closes,
+// with the end of its cooldown or zero once Gemini recovered, so the state
+// can be shared with the API
+func OnGeminiStateChange(fn func(openUntil time.Time)) {
+	geminiBreaker.mu.Lock()
+	defer geminiBreaker.mu.Unlock()
+	geminiBreaker.onChange = fn
+}
+
+// GeminiAvailable returns ErrGeminiUnavailable while the Gemini breaker is
+// open, without using up the call that probes whether Gemini recovered
+func GeminiAvailable() error {
+	geminiBreaker.mu.Lock()
+	defer geminiBreaker.mu.Unlock()
+	if !geminiBreaker.openUntil.IsZero() && time.Now().Before(geminiBreaker.openUntil) {
+		return fmt.Errorf("%w, retrying after %s", ErrGeminiUnavailable, geminiBreaker.openUntil.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// isOutage reports whether a Gemini error means Gemini is down or overloaded,
+// rather than a problem with the request such as a blocked prompt
+func isOutage(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
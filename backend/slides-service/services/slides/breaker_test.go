@@ -0,0 +1,61 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBreakerOpensAfterOutagesAndProbes(t *testing.T) {
+	b := &breaker{threshold: 3, cooldown: time.Hour}
+	changes := make(chan time.Time, 4)
+	b.onChange = func(openUntil time.Time) { changes <- openUntil }
+	ctx := context.Background()
+	outage := status.Error(codes.Unavailable, "overloaded")
+
+	// Errors of the request don't count toward opening the breaker
+	b.record(ctx, status.Error(codes.InvalidArgument, "blocked prompt"))
+	for i := 0; i < 2; i++ {
+		b.record(ctx, outage)
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected the breaker to stay closed below the threshold, got %v", err)
+	}
+	b.record(ctx, outage)
+	if err := b.allow(); !errors.Is(err, ErrGeminiUnavailable) {
+		t.Fatalf("expected the breaker to open at the threshold, got %v", err)
+	}
+	if openUntil := <-changes; openUntil.IsZero() {
+		t.Fatal("expected the opening to be shared")
+	}
+
+	// Once the cooldown ends one call probes Gemini while the others still fail fast
+	b.mu.Lock()
+	b.openUntil = time.Now().Add(-time.Second)
+	b.mu.Unlock()
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a probe after the cooldown, got %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrGeminiUnavailable) {
+		t.Fatalf("expected other calls to fail during the probe, got %v", err)
+	}
+
+	// A probe cancelled by its job lets the next call probe instead
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	b.record(cancelled, context.Canceled)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a new probe after a cancelled one, got %v", err)
+	}
+	b.record(ctx, nil)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a successful probe to close the breaker, got %v", err)
+	}
+	if openUntil := <-changes; !openUntil.IsZero() {
+		t.Fatalf("expected the recovery to be shared, got %s", openUntil)
+	}
+}
@@ -0,0 +1,283 @@
+package slides
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+const (
+	// defaultMaxInputTokens is the most tokens sent to Gemini for one
+	// presentation when the instance doesn't set a limit
+	defaultMaxInputTokens = 16384
+
+	// defaultMaxOutputTokens is the most tokens Gemini writes for one
+	// presentation when the instance doesn't set a limit
+	defaultMaxOutputTokens = 4096
+
+	// maxSectionChars is the largest section kept whole, longer sections are
+	// split on paragraphs so they can be dropped piece by piece
+	maxSectionChars = 4000
+
+	// charsPerToken is the estimate used to size sections before counting for real
+	charsPerToken = 4
+
+	// maxSummarizedSections is the most omitted sections summarized instead of
+	// left out, each summary is a call to Gemini
+	maxSummarizedSections = 6
+
+	// summaryTokens is the budget reserved for each section summary
+	summaryTokens = 150
+
+	// focusBoost is added to the score of a section for each focus topic it
+	// mentions, enough to rank it above the sections that mention none
+	focusBoost = 1.0
+)
+
+var (
+	sectionHeaderPattern = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+	pageMarkerPattern    = regexp.MustCompile(`^\[Page (\d+)\]$`)
+)
+
+// jobTokenLimits returns the token limits of a job, the ones of the owner's
+// plan where it overrides the instance's
+func (s *SlideService) jobTokenLimits(settings models.SlideSettings) models.TokenLimits {
+	limits := s.tokenLimits
+	if settings.TokenLimits.Input > 0 {
+		limits.Input = settings.TokenLimits.Input
+	}
+	if settings.TokenLimits.Output > 0 {
+		limits.Output = settings.TokenLimits.Output
+	}
+	return limits
+}
+
+// generationModel returns the model writing the slides of a job, which
+// writes at most the output tokens of the job
+func (s *SlideService) generationModel(limits models.TokenLimits) *genai.GenerativeModel {
+	if limits.Output == s.tokenLimits.Output {
+		return s.model
+	}
+	return newGenerativeModel(s.client, limits.Output)
+}
+
+// section is a part of a source document that can be kept or omitted as a unit
+type section struct {
+	document string
+	title    string
+	text     string
+	order    int
+	score    float64
+	summary  string // Summary the text was replaced with, in chunked mode
+}
+
+// label names a section in the list of omitted content
+func (s section) label() string {
+	if s.title == "" {
+		return s.document
+	}
+	return s.document + ": " + s.title
+}
+
+// splitSections splits a document into sections at its headings and page markers
+func splitSections(document, content string) []section {
+	var sections []section
+	current := section{document: document}
+	var body strings.Builder
+
+	flush := func() {
+		current.text = strings.TrimSpace(body.String())
+		if current.text != "" {
+			sections = append(sections, splitLongSection(current)...)
+		}
+		body.Reset()
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if match := sectionHeaderPattern.FindStringSubmatch(trimmed); match != nil {
+			flush()
+			current = section{document: document, title: stripInlineMarkdown(match[2])}
+		} else if match := pageMarkerPattern.FindStringSubmatch(trimmed); match != nil {
+			flush()
+			current = section{document: document, title: "page " + match[1]}
+		}
+		body.WriteString(line + "\n")
+	}
+	flush()
+
+	return sections
+}
+
+// splitLongSection splits a section longer than maxSectionChars on blank lines
+func splitLongSection(s section) []section {
+	if len(s.text) <= maxSectionChars {
+		return []section{s}
+	}
+
+	var parts []section
+	var chunk strings.Builder
+	for _, paragraph := range strings.Split(s.text, "\n\n") {
+		if chunk.Len() > 0 && chunk.Len()+len(paragraph) > maxSectionChars {
+			parts = append(parts, section{document: s.document, text: strings.TrimSpace(chunk.String())})
+			chunk.Reset()
+		}
+		chunk.WriteString(paragraph + "\n\n")
+	}
+	if chunk.Len() > 0 {
+		parts = append(parts, section{document: s.document, text: strings.TrimSpace(chunk.String())})
+	}
+
+	for i := range parts {
+		parts[i].title = s.title
+		if len(parts) > 1 {
+			parts[i].title = strings.TrimSpace(fmt.Sprintf("%s (part %d)", s.title, i+1))
+		}
+	}
+	return parts
+}
+
+// tokenize lowercases text and splits it into words, dropping short ones
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	terms := words[:0]
+	for _, word := range words {
+		if len(word) > 2 {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// scoreSections ranks sections by how well they represent the documents as a
+// whole: the cosine similarity of their TF-IDF vector to the corpus vector,
+// with a boost for opening sections, sections whose headings use key terms
+// and sections that mention the focus topics
+func scoreSections(sections []section, focusTopics []string) {
+	termCounts := make([]map[string]float64, len(sections))
+	documentFrequency := make(map[string]float64)
+	corpus := make(map[string]float64)
+	for i, s := range sections {
+		termCounts[i] = make(map[string]float64)
+		for _, term := range tokenize(s.text) {
+			termCounts[i][term]++
+			corpus[term]++
+		}
+		for term := range termCounts[i] {
+			documentFrequency[term]++
+		}
+	}
+
+	n := float64(len(sections))
+	idf := func(term string) float64 {
+		return math.Log(1 + n/documentFrequency[term])
+	}
+
+	corpusNorm := 0.0
+	for term, count := range corpus {
+		corpus[term] = count * idf(term)
+		corpusNorm += corpus[term] * corpus[term]
+	}
+	corpusNorm = math.Sqrt(corpusNorm)
+
+	firstOfDocument := make(map[string]bool)
+	for i := range sections {
+		s := &sections[i]
+		s.order = i
+
+		dot, norm := 0.0, 0.0
+		for term, count := range termCounts[i] {
+			weight := count * idf(term)
+			dot += weight * corpus[term]
+			norm += weight * weight
+		}
+		if norm > 0 && corpusNorm > 0 {
+			s.score = dot / (math.Sqrt(norm) * corpusNorm)
+		}
+
+		// The opening of a document usually states what it is about
+		if !firstOfDocument[s.document] {
+			firstOfDocument[s.document] = true
+			s.score += 0.2
+		}
+
+		// Headings built from the documents' key terms mark central sections
+		headingTerms := tokenize(s.title)
+		for _, term := range headingTerms {
+			if documentFrequency[term] > 1 {
+				s.score += 0.05
+			}
+		}
+
+		// The topics the user asked to focus on outrank the rest, a topic
+		// counts when the section has every word of it
+		for _, topic := range focusTopics {
+			terms := tokenize(topic)
+			mentioned := len(terms) > 0
+			for _, term := range terms {
+				if termCounts[i][term] == 0 {
+					mentioned = false
+					break
+				}
+			}
+			if mentioned {
+				s.score += focusBoost
+			}
+		}
+	}
+}
+
+// selectSections keeps the highest scoring sections that fit in the token
+// budget, in their original order, and returns the omitted ones in order
+func selectSections(sections []section, budget int) ([]section, []section) {
+	ranked := append([]section(nil), sections...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+
+	kept := make([]section, 0, len(ranked))
+	omitted := make([]section, 0)
+	used := 0
+	for _, s := range ranked {
+		tokens := len(s.text)/charsPerToken + 1
+		if used+tokens > budget {
+			omitted = append(omitted, s)
+			continue
+		}
+		kept = append(kept, s)
+		used += tokens
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].order < kept[j].order })
+	sort.Slice(omitted, func(i, j int) bool { return omitted[i].order < omitted[j].order })
+	return kept, omitted
+}
+
+// sectionLabels returns the labels of sections
+func sectionLabels(sections []section) []string {
+	labels := make([]string, 0, len(sections))
+	for _, s := range sections {
+		labels = append(labels, s.label())
+	}
+	return labels
+}
+
+// joinSections rebuilds the text of each document from the kept sections
+func joinSections(sections []section) map[string]string {
+	texts := make(map[string]string)
+	for _, s := range sections {
+		if texts[s.document] != "" {
+			texts[s.document] += "\n\n"
+		}
+		texts[s.document] += s.text
+	}
+	return texts
+}
@@ -0,0 +1,135 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+func TestSplitSections(t *testing.T) {
+	content := "Intro text\n\n# Revenue\nRevenue grew.\n\n## Costs\nCosts fell.\n[Page 2]\nAppendix"
+
+	sections := splitSections("report.md", content)
+	titles := make([]string, 0, len(sections))
+	for _, s := range sections {
+		titles = append(titles, s.title)
+	}
+	if got := strings.Join(titles, "|"); got != "|Revenue|Costs|page 2" {
+		t.Fatalf("unexpected section titles: %q", got)
+	}
+	if sections[1].text != "# Revenue\nRevenue grew." {
+		t.Fatalf("expected the heading to stay with its section, got %q", sections[1].text)
+	}
+}
+
+func TestSplitLongSection(t *testing.T) {
+	paragraph := strings.Repeat("word ", maxSectionChars/10)
+	content := "# Details\n" + strings.Repeat(paragraph+"\n\n", 4)
+
+	sections := splitSections("notes.md", content)
+	if len(sections) < 2 {
+		t.Fatalf("expected the long section to be split, got %d sections", len(sections))
+	}
+	for i, s := range sections {
+		if len(s.text) > maxSectionChars {
+			t.Fatalf("section %d is longer than the maximum: %d", i, len(s.text))
+		}
+		if !strings.HasPrefix(s.title, "Details (part ") {
+			t.Fatalf("unexpected title for a split section: %q", s.title)
+		}
+	}
+}
+
+func TestSelectSectionsKeepsRelevantSectionsInOrder(t *testing.T) {
+	sections := []section{
+		{document: "report.pdf", title: "Summary", text: "solar panel revenue solar panel growth"},
+		{document: "report.pdf", title: "Office plants", text: strings.Repeat("ferns cactus watering schedule ", 20)},
+		{document: "report.pdf", title: "Solar panel sales", text: "solar panel revenue by region solar panel margins"},
+	}
+	scoreSections(sections, nil)
+
+	kept, omitted := selectSections(sections, 30)
+	if len(kept) != 2 || kept[0].title != "Summary" || kept[1].title != "Solar panel sales" {
+		t.Fatalf("unexpected kept sections: %+v", kept)
+	}
+	if len(omitted) != 1 || omitted[0].label() != "report.pdf: Office plants" {
+		t.Fatalf("unexpected omitted sections: %v", omitted)
+	}
+
+	// A focus topic keeps the sections that mention it
+	scoreSections(sections, []string{"Watering schedule", "of"})
+	_, omitted = selectSections(sections, 170)
+	if len(omitted) != 1 || omitted[0].title != "Solar panel sales" {
+		t.Fatalf("expected the office plants kept for the focus topic, got %v omitted", sectionLabels(omitted))
+	}
+}
+
+func TestJoinSections(t *testing.T) {
+	texts := joinSections([]section{
+		{document: "a.md", text: "one"},
+		{document: "b.md", text: "two"},
+		{document: "a.md", text: "three"},
+	})
+	if texts["a.md"] != "one\n\nthree" || texts["b.md"] != "two" {
+		t.Fatalf("unexpected document texts: %v", texts)
+	}
+}
+
+func TestSummarizeSectionsReportsProgress(t *testing.T) {
+	var omitted []section
+	for i := 0; i < maxSummarizedSections+2; i++ {
+		omitted = append(omitted, section{document: "report.pdf", title: fmt.Sprintf("Chapter %d", i+1), text: "text", order: i, score: float64(i)})
+	}
+
+	var statuses []string
+	statusUpdateFn := func(stage Stage, status Status) error {
+		statuses = append(statuses, status.Text())
+		return nil
+	}
+	summarize := func(ctx context.Context, s section) (string, error) {
+		if s.title == "Chapter 5" {
+			return "", errors.New("model unavailable")
+		}
+		return "short " + s.title, nil
+	}
+
+	summaries, err := summarizeSections(context.Background(), omitted, statusUpdateFn, summarize)
+	if err != nil {
+		t.Fatalf("summarizeSections failed: %v", err)
+	}
+	// The highest scoring sections are summarized in document order
+	if len(statuses) != maxSummarizedSections || statuses[0] != "Summarizing report.pdf: Chapter 3" {
+		t.Fatalf("unexpected progress: %v", statuses)
+	}
+	if len(summaries) != maxSummarizedSections-1 {
+		t.Fatalf("expected the failed summary to be left out, got %d summaries", len(summaries))
+	}
+	if got := summaries[7].text; got != "Summary of report.pdf: Chapter 8: short Chapter 8" {
+		t.Fatalf("unexpected summary: %q", got)
+	}
+	if _, ok := summaries[0]; ok {
+		t.Fatal("expected the lowest scoring sections to be left out")
+	}
+}
+
+func TestJobTokenLimits(t *testing.T) {
+	s := &SlideService{tokenLimits: models.TokenLimits{Input: defaultMaxInputTokens, Output: defaultMaxOutputTokens}}
+	tests := []struct {
+		overrides models.TokenLimits
+		expected  models.TokenLimits
+	}{
+		{models.TokenLimits{}, models.TokenLimits{Input: 16384, Output: 4096}},
+		{models.TokenLimits{Input: 1000000}, models.TokenLimits{Input: 1000000, Output: 4096}},
+		{models.TokenLimits{Input: 65536, Output: 8192}, models.TokenLimits{Input: 65536, Output: 8192}},
+	}
+
+	for _, test := range tests {
+		if limits := s.jobTokenLimits(models.SlideSettings{TokenLimits: test.overrides}); limits != test.expected {
+			t.Errorf("jobTokenLimits(%+v) = %+v, want %+v", test.overrides, limits, test.expected)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package slides
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	// maxCaptureText is the most of a prompt or response kept in a capture
+	maxCaptureText = 64 << 10
+
+	// maxCaptureBytes is the most text a capture keeps over all of its
+	// exchanges, so it fits in a Firestore document
+	maxCaptureBytes = 768 << 10
+)
+
+// Stages of the exchanges with Gemini
+const (
+	ExchangeSlides     = "slides"     // Writing the deck, a section of it or a refinement
+	ExchangeOutline    = "outline"    // Planning a deck written in sections
+	ExchangeSummary    = "summary"    // Summarizing a section of a document over the input budget
+	ExchangeFlashcards = "flashcards" // Extracting the flashcards
+	ExchangeOnePager   = "onePager"   // Writing the executive summary
+	ExchangeGrounding  = "grounding"  // Checking the bullet points against the documents
+)
+
+// secretPatterns match the personal data and credentials a capture redacts:
+// email addresses, API keys, bearer tokens and long keys and hashes, which
+// the prompts and responses can quote from the documents
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`),
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`),
+	regexp.MustCompile(`\b(?:sk|pk|rk|ghp|gho|xox[abp])[_-][A-Za-z0-9_-]{16,}\b`),
+	regexp.MustCompile(`\b[A-Za-z0-9_-]{40,}`),
+}
+
+// Exchange is a request of a job to Gemini and its raw response
+type Exchange struct {
+	Stage        string   `firestore:"stage"`
+	Documents    []string `firestore:"documents,omitempty"` // Parts sent before the prompt, described rather than copied
+	Prompt       string   `firestore:"prompt"`
+	Response     string   `firestore:"response,omitempty"` // Text of the response as Gemini returned it
+	FinishReason string   `firestore:"finishReason,omitempty"`
+	InputTokens  int      `firestore:"inputTokens,omitempty"`
+	OutputTokens int      `firestore:"outputTokens,omitempty"`
+	Error        string   `firestore:"error,omitempty"`
+	CreatedAt    int64    `firestore:"createdAt"`
+}
+
+// Capture collects the exchanges of a job with Gemini when an admin debugs
+// it, with personal data and credentials redacted. Sections are summarized
+// at once, so it records from several goroutines.
+type Capture struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	size      int
+	truncated bool
+}
+
+// captureKey is the context key of the capture of a job
+type captureKey struct{}
+
+// WithCapture returns a context whose exchanges with Gemini are recorded in
+// the returned capture
+func WithCapture(ctx context.Context) (context.Context, *Capture) {
+	capture := &Capture{}
+	return context.WithValue(ctx, captureKey{}, capture), capture
+}
+
+// Exchanges returns the recorded exchanges in order, and whether some were
+// cut short or left out to keep the capture within its size
+func (c *Capture) Exchanges() ([]Exchange, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Exchange(nil), c.exchanges...), c.truncated
+}
+
+// generateContent sends the parts to the model, recording the exchange in the
+// capture of the context and counting its tokens in the usage of the context
+// if it has them
+func generateContent(ctx context.Context, model *genai.GenerativeModel, stage string, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	if err := geminiBreaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := model.GenerateContent(ctx, parts...)
+	geminiBreaker.record(ctx, err)
+	if capture, ok := ctx.Value(captureKey{}).(*Capture); ok {
+		capture.record(stage, parts, resp, err)
+	}
+	if usage, ok := ctx.Value(usageKey{}).(*Usage); ok {
+		usage.record(resp)
+	}
+	return resp, err
+}
+
+// record adds an exchange, the last part being the prompt
+func (c *Capture) record(stage string, parts []genai.Part, resp *genai.GenerateContentResponse, err error) {
+	exchange := Exchange{Stage: stage, CreatedAt: time.Now().Unix()}
+	for i, part := range parts {
+		text, isText := part.(genai.Text)
+		if i == len(parts)-1 && isText {
+			exchange.Prompt = string(text)
+			break
+		}
+		exchange.Documents = append(exchange.Documents, describePart(part))
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	if resp != nil {
+		if resp.UsageMetadata != nil {
+			exchange.InputTokens = int(resp.UsageMetadata.PromptTokenCount)
+			exchange.OutputTokens = int(resp.UsageMetadata.CandidatesTokenCount)
+		}
+		if len(resp.Candidates) > 0 {
+			candidate := resp.Candidates[0]
+			exchange.FinishReason = candidate.FinishReason.String()
+			if candidate.Content != nil {
+				var response strings.Builder
+				for _, part := range candidate.Content.Parts {
+					if text, ok := part.(genai.Text); ok {
+						response.WriteString(string(text))
+					}
+				}
+				exchange.Response = response.String()
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var cut bool
+	exchange.Prompt, cut = redact(exchange.Prompt)
+	c.truncated = c.truncated || cut
+	exchange.Response, cut = redact(exchange.Response)
+	c.truncated = c.truncated || cut
+	size := len(exchange.Prompt) + len(exchange.Response)
+	if c.size+size > maxCaptureBytes {
+		c.truncated = true
+		return
+	}
+	c.size += size
+	c.exchanges = append(c.exchanges, exchange)
+}
+
+// describePart describes a document sent to Gemini without its content
+func describePart(part genai.Part) string {
+	switch part := part.(type) {
+	case genai.FileData:
+		return fmt.Sprintf("File %s (%s)", part.URI, part.MIMEType)
+	case genai.Blob:
+		return fmt.Sprintf("Inline %s, %d bytes", part.MIMEType, len(part.Data))
+	case genai.Text:
+		header, _, _ := strings.Cut(string(part), "\n")
+		return fmt.Sprintf("%s, %d characters", strings.Trim(header, "- "), len(part))
+	default:
+		return fmt.Sprintf("%T", part)
+	}
+}
+
+// redact masks the personal data and credentials of a text and keeps at most
+// maxCaptureText bytes of it, reporting whether it was cut
+func redact(text string) (string, bool) {
+	for _, pattern := range secretPatterns {
+		text = pattern.ReplaceAllString(text, "[redacted]")
+	}
+	if len(text) <= maxCaptureText {
+		return text, false
+	}
+	// Cut at a rune boundary
+	end := maxCaptureText
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end], true
+}
@@ -0,0 +1,185 @@
+package slides
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+const (
+	// minChapters is how many chapters a document needs to be split on them
+	minChapters = 2
+
+	// maxChapters bounds the decks a course is split into, adjacent chapters
+	// are grouped into one deck past it
+	maxChapters = 20
+
+	// minChapterChars is the shortest chapter written as a deck of its own,
+	// shorter ones such as table of contents entries or part dividers are
+	// merged into the chapter after them
+	minChapterChars = 2000
+
+	// maxChapterLineChars is the longest line read as a chapter heading, so
+	// sentences starting with "Part 2" aren't
+	maxChapterLineChars = 80
+)
+
+// chapterPattern matches the headings of numbered chapters, such as
+// "Chapter 3: Sorting" or "Lecture IV", with or without markdown heading marks
+var chapterPattern = regexp.MustCompile(`(?i)^(?:#{1,6}\s+)?(?:chapter|part|module|unit|lecture|lesson|week)\s+(?:\d+|[ivxlc]+)\b`)
+
+// Chapter is a chapter of a long document, written as a deck of its own when
+// a job is split into chapters
+type Chapter struct {
+	Title    string `json:"title"`
+	Markdown string `json:"markdown"` // Text of the chapter, starting with its heading
+}
+
+// SplitChapters detects the chapters of the documents, in order. A document
+// without chapters is one chapter named after the file.
+func (s *SlideService) SplitChapters(ctx context.Context, files []models.File) ([]Chapter, error) {
+	var chapters []Chapter
+	for _, file := range files {
+		text, err := chapterText(ctx, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file.Filename, err)
+		}
+		title := strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename))
+		chapters = append(chapters, splitChapters(title, text)...)
+	}
+	return groupChapters(chapters, maxChapters), nil
+}
+
+// chapterText returns the text of a document to split into chapters. Text
+// documents are kept as they are, so the chapter decks keep their markdown.
+func chapterText(ctx context.Context, file models.File) (string, error) {
+	if file.Type == "application/pdf" || transcriptCues(file) != nil {
+		return extractText(ctx, file)
+	}
+	return string(file.Data), nil
+}
+
+// splitChapters splits the text of a document at its numbered chapter
+// headings, or else at the highest level of markdown headings that occurs
+// more than once. Text before the first chapter goes with it.
+func splitChapters(title, text string) []Chapter {
+	lines := strings.Split(text, "\n")
+	starts := chapterStarts(lines)
+	if len(starts) < minChapters {
+		return []Chapter{{Title: title, Markdown: strings.TrimSpace(text)}}
+	}
+
+	var chapters []Chapter
+	for i, start := range starts {
+		from, to := start, len(lines)
+		if i == 0 {
+			from = 0
+		}
+		if i+1 < len(starts) {
+			to = starts[i+1]
+		}
+		chapters = append(chapters, Chapter{
+			Title:    chapterTitle(lines[start]),
+			Markdown: strings.TrimSpace(strings.Join(lines[from:to], "\n")),
+		})
+	}
+
+	chapters = mergeShortChapters(chapters)
+	if len(chapters) < minChapters {
+		return []Chapter{{Title: title, Markdown: strings.TrimSpace(text)}}
+	}
+	return chapters
+}
+
+// chapterStarts returns the lines that start chapters, outside code blocks
+func chapterStarts(lines []string) []int {
+	var named []int
+	headings := map[int][]int{}
+	inCode := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		if len(trimmed) <= maxChapterLineChars && !strings.HasSuffix(trimmed, ".") && chapterPattern.MatchString(trimmed) {
+			named = append(named, i)
+		}
+		if match := sectionHeaderPattern.FindStringSubmatch(trimmed); match != nil {
+			headings[len(match[1])] = append(headings[len(match[1])], i)
+		}
+	}
+	if len(named) >= minChapters {
+		return named
+	}
+	for level := 1; level <= 6; level++ {
+		if len(headings[level]) >= minChapters {
+			return headings[level]
+		}
+	}
+	return nil
+}
+
+// chapterTitle returns the title of a chapter from its heading line
+func chapterTitle(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if match := sectionHeaderPattern.FindStringSubmatch(trimmed); match != nil {
+		return stripInlineMarkdown(match[2])
+	}
+	return stripInlineMarkdown(trimmed)
+}
+
+// mergeShortChapters merges each chapter shorter than minChapterChars into
+// the chapter after it, or the one before it for the last chapter
+func mergeShortChapters(chapters []Chapter) []Chapter {
+	var merged []Chapter
+	var pending string
+	for _, chapter := range chapters {
+		if pending != "" {
+			chapter.Markdown = pending + "\n\n" + chapter.Markdown
+			pending = ""
+		}
+		if len(chapter.Markdown) < minChapterChars {
+			pending = chapter.Markdown
+			continue
+		}
+		merged = append(merged, chapter)
+	}
+	if pending != "" {
+		if len(merged) == 0 {
+			return []Chapter{{Markdown: pending}}
+		}
+		merged[len(merged)-1].Markdown += "\n\n" + pending
+	}
+	return merged
+}
+
+// groupChapters groups adjacent chapters so there are at most limit, named
+// after the first and last chapter of each group
+func groupChapters(chapters []Chapter, limit int) []Chapter {
+	if len(chapters) <= limit {
+		return chapters
+	}
+	size := (len(chapters) + limit - 1) / limit
+	var grouped []Chapter
+	for i := 0; i < len(chapters); i += size {
+		group := chapters[i:min(i+size, len(chapters))]
+		texts := make([]string, len(group))
+		for j, chapter := range group {
+			texts[j] = chapter.Markdown
+		}
+		title := group[0].Title
+		if len(group) > 1 {
+			title += " – " + group[len(group)-1].Title
+		}
+		grouped = append(grouped, Chapter{Title: title, Markdown: strings.Join(texts, "\n\n")})
+	}
+	return grouped
+}
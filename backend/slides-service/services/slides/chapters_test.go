@@ -0,0 +1,66 @@
+package slides
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSplitChaptersOnNumberedChapters(t *testing.T) {
+	body := strings.Repeat("Some lecture content about the topic.\n", 80)
+	text := "Course notes\n\nContents\nChapter 1 Basics\nChapter 2 Sorting\n\n" +
+		"Chapter 1 Basics\n" + body + "\n```\n# Chapter 9 not a heading\n```\n" +
+		"Chapter 2 Sorting\nChapter 2 covers sorting in more depth than the first chapter.\n" + body
+
+	chapters := splitChapters("notes", text)
+	if len(chapters) != 2 {
+		t.Fatalf("expected 2 chapters, got %d: %+v", len(chapters), chapters)
+	}
+	if chapters[0].Title != "Chapter 1 Basics" || chapters[1].Title != "Chapter 2 Sorting" {
+		t.Fatalf("unexpected chapter titles: %q, %q", chapters[0].Title, chapters[1].Title)
+	}
+	if !strings.HasPrefix(chapters[0].Markdown, "Course notes") {
+		t.Fatalf("expected the table of contents to go with the first chapter, got %q", chapters[0].Markdown[:40])
+	}
+	if !strings.Contains(chapters[0].Markdown, "Chapter 9 not a heading") {
+		t.Fatal("expected headings in code blocks to be kept in their chapter")
+	}
+}
+
+func TestSplitChaptersFallsBackToHeadings(t *testing.T) {
+	body := strings.Repeat("A paragraph of the guide.\n", 100)
+	text := "# Guide\n\n## Setup\n" + body + "## Usage\n" + body + "### Details\n" + body
+
+	chapters := splitChapters("guide", text)
+	if len(chapters) != 2 || chapters[0].Title != "Setup" || chapters[1].Title != "Usage" {
+		t.Fatalf("expected chapters at the second level headings, got %+v", chapters)
+	}
+	if !strings.HasPrefix(chapters[0].Markdown, "# Guide") || !strings.Contains(chapters[1].Markdown, "### Details") {
+		t.Fatal("expected the preamble and subsections to stay in their chapters")
+	}
+}
+
+func TestSplitChaptersKeepsDocumentsWithoutChapters(t *testing.T) {
+	chapters := splitChapters("memo", "# Memo\nShort text.\n\n# Appendix\nMore.")
+	if len(chapters) != 1 || chapters[0].Title != "memo" {
+		t.Fatalf("expected one chapter named after the document, got %+v", chapters)
+	}
+}
+
+func TestGroupChapters(t *testing.T) {
+	var chapters []Chapter
+	for i := 1; i <= 5; i++ {
+		chapters = append(chapters, Chapter{Title: fmt.Sprintf("Week %d", i), Markdown: fmt.Sprintf("text %d", i)})
+	}
+
+	grouped := groupChapters(chapters, 3)
+	if len(grouped) != 3 {
+		t.Fatalf("expected 3 groups, got %d", len(grouped))
+	}
+	if grouped[0].Title != "Week 1 – Week 2" || grouped[2].Title != "Week 5" {
+		t.Fatalf("unexpected group titles: %q, %q", grouped[0].Title, grouped[2].Title)
+	}
+	if grouped[1].Markdown != "text 3\n\ntext 4" {
+		t.Fatalf("unexpected group text: %q", grouped[1].Markdown)
+	}
+}
@@ -0,0 +1,91 @@
+package slides
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pageCitationPattern matches the page annotations the prompt asks the model
+// to attach to bullet points: [p. 3], [pp. 3-4] or [report.pdf, p. 3]. Only
+// square brackets are matched, so prose such as "(p. 3)" or "(page 3)" is
+// left alone.
+var pageCitationPattern = regexp.MustCompile(`\[(?:([^\[\]]+?\.pdf),\s*)?pp?\.\s*(\d+)(?:\s*[-–]\s*(\d+))?\]`)
+
+// referencesHeaderPattern matches a references slide header
+var referencesHeaderPattern = regexp.MustCompile(`(?mi)^#{1,3}\s*(references|bibliography|works cited|sources)\s*$`)
+
+// annotateSourcePages normalizes the page annotations in the generated markdown
+// and appends a slide listing the cited pages when the model did not produce a
+// references slide of its own
+func annotateSourcePages(markdown string) string {
+	// Pages cited per document, keyed by file name ("" when there is a single document)
+	cited := make(map[string]map[int]bool)
+
+	normalized := pageCitationPattern.ReplaceAllStringFunc(markdown, func(match string) string {
+		groups := pageCitationPattern.FindStringSubmatch(match)
+		filename := strings.TrimSpace(groups[1])
+		start, err := strconv.Atoi(groups[2])
+		if err != nil {
+			return match
+		}
+		end := start
+		if groups[3] != "" {
+			if end, err = strconv.Atoi(groups[3]); err != nil || end < start {
+				end = start
+			}
+		}
+
+		if cited[filename] == nil {
+			cited[filename] = make(map[int]bool)
+		}
+		for page := start; page <= end; page++ {
+			cited[filename][page] = true
+		}
+
+		label := fmt.Sprintf("p. %d", start)
+		if end > start {
+			label = fmt.Sprintf("pp. %d-%d", start, end)
+		}
+		if filename != "" {
+			label = filename + ", " + label
+		}
+		return "[" + label + "]"
+	})
+
+	if len(cited) == 0 || referencesHeaderPattern.MatchString(normalized) {
+		return normalized
+	}
+
+	// Build a fallback slide so claims can still be traced back to the source
+	filenames := make([]string, 0, len(cited))
+	for filename := range cited {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	var slide strings.Builder
+	slide.WriteString("\n\n---\n\n## Sources\n\n")
+	for _, filename := range filenames {
+		pages := make([]int, 0, len(cited[filename]))
+		for page := range cited[filename] {
+			pages = append(pages, page)
+		}
+		sort.Ints(pages)
+
+		pageLabels := make([]string, 0, len(pages))
+		for _, page := range pages {
+			pageLabels = append(pageLabels, strconv.Itoa(page))
+		}
+
+		name := filename
+		if name == "" {
+			name = "Source document"
+		}
+		slide.WriteString(fmt.Sprintf("- %s: pages %s\n", name, strings.Join(pageLabels, ", ")))
+	}
+
+	return strings.TrimRight(normalized, "\n") + slide.String()
+}
@@ -0,0 +1,57 @@
+package slides
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnnotateSourcePagesNormalizesCitations(t *testing.T) {
+	markdown := `# Results
+
+- Revenue grew 12% [p.3]
+- Costs fell [pp. 5 – 7]
+- Churn is flat [report.pdf,  p. 2]`
+
+	got := annotateSourcePages(markdown)
+	want := `# Results
+
+- Revenue grew 12% [p. 3]
+- Costs fell [pp. 5-7]
+- Churn is flat [report.pdf, p. 2]
+
+---
+
+## Sources
+
+- Source document: pages 3, 5, 6, 7
+- report.pdf: pages 2
+`
+	if got != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestAnnotateSourcePagesIgnoresProse(t *testing.T) {
+	markdown := `- The survey (p. 3 of the appendix) covers 200 firms
+- Turn to (page 3) for the details
+- See [page 4] and [section 2]`
+
+	if got := annotateSourcePages(markdown); got != markdown {
+		t.Fatalf("expected prose to be left alone, got:\n%s", got)
+	}
+}
+
+func TestAnnotateSourcePagesKeepsReferencesSlide(t *testing.T) {
+	markdown := `- Revenue grew [p. 3]
+
+---
+
+## References
+
+- Smith, J. (2020). Annual report.`
+
+	got := annotateSourcePages(markdown)
+	if got != markdown || strings.Contains(got, "## Sources") {
+		t.Fatalf("expected no sources slide next to the references slide, got:\n%s", got)
+	}
+}
@@ -0,0 +1,174 @@
+package slides
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// directiveCommentPattern matches the Marp local directives written as comments
+	directiveCommentPattern = regexp.MustCompile(`(?s)<!--\s*_?(?:class|paginate|header|footer|backgroundColor|backgroundImage|backgroundPosition|backgroundRepeat|backgroundSize|color|transition)\s*:.*?-->`)
+	// commentPattern matches the HTML comments left after the directives, Marp speaker notes
+	commentPattern = regexp.MustCompile(`(?s)<!--(.*?)-->`)
+	// styleBlockPattern matches the style blocks the fonts and layouts add
+	styleBlockPattern = regexp.MustCompile(`(?is)<style[^>]*>.*?</style>\s*`)
+	// backgroundImagePattern matches Marp background images such as ![bg left:40%](url)
+	backgroundImagePattern = regexp.MustCompile(`!\[([^\]]*\bbg\b[^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	// imagePattern matches the other images, whose alt text can hold Marp size keywords
+	imagePattern = regexp.MustCompile(`!\[([^\]]*)\]\(`)
+	// imageKeywordPattern matches the Marp keywords of an image alt text
+	imageKeywordPattern = regexp.MustCompile(`^(?:(?:w|h|width|height):\S+|contain|cover|fit|auto|left|right|vertical|\d+%|(?:blur|brightness|contrast|drop-shadow|grayscale|hue-rotate|invert|opacity|saturate|sepia)(?::\S+)?)$`)
+	// blankLinesPattern matches the blank lines left where directives were removed
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+	// headingPattern matches a markdown heading and its level
+	headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+)
+
+// marpSlide is a slide of a Marp deck, taken apart for the engines that don't
+// read Marp markdown
+type marpSlide struct {
+	Classes        []string // Classes set with the class directives, such as lead
+	Background     string   // URL of the background image
+	BackgroundSide string   // left or right for a split background, empty for a full one
+	Notes          []string // Speaker notes
+	Body           string   // Markdown without directives, notes, styles and background images
+}
+
+// marpDeck is a Marp deck taken apart by parseMarpDeck
+type marpDeck struct {
+	Title    string
+	Language string
+	Slides   []marpSlide
+}
+
+// parseMarpDeck takes apart the markdown of a Marp deck. The global class
+// directive applies to every slide and _class to its own slide only.
+func parseMarpDeck(markdown string) marpDeck {
+	frontmatter, texts := splitSlides(markdown)
+	deck := marpDeck{
+		Title:    frontmatterValue(frontmatter, "title"),
+		Language: frontmatterValue(frontmatter, "lang"),
+	}
+
+	var globalClasses []string
+	for _, text := range texts {
+		slide := marpSlide{Classes: globalClasses}
+		for _, match := range classDirectivePattern.FindAllStringSubmatch(text, -1) {
+			classes := strings.Fields(strings.Trim(match[2], `"'`))
+			if match[1] == "class" {
+				globalClasses = classes
+			}
+			slide.Classes = classes
+		}
+
+		text = styleBlockPattern.ReplaceAllString(text, "")
+		text = directiveCommentPattern.ReplaceAllString(text, "")
+		for _, match := range commentPattern.FindAllStringSubmatch(text, -1) {
+			if note := strings.TrimSpace(match[1]); note != "" {
+				slide.Notes = append(slide.Notes, note)
+			}
+		}
+		text = commentPattern.ReplaceAllString(text, "")
+
+		text = backgroundImagePattern.ReplaceAllStringFunc(text, func(image string) string {
+			match := backgroundImagePattern.FindStringSubmatch(image)
+			if slide.Background == "" {
+				slide.Background = match[2]
+				for _, keyword := range strings.Fields(match[1]) {
+					if side, _, _ := strings.Cut(keyword, ":"); side == "left" || side == "right" {
+						slide.BackgroundSide = side
+					}
+				}
+			}
+			return ""
+		})
+		text = imagePattern.ReplaceAllStringFunc(text, func(image string) string {
+			alt := imagePattern.FindStringSubmatch(image)[1]
+			var kept []string
+			for _, word := range strings.Fields(alt) {
+				if !imageKeywordPattern.MatchString(word) {
+					kept = append(kept, word)
+				}
+			}
+			return "![" + strings.Join(kept, " ") + "]("
+		})
+
+		slide.Body = strings.TrimSpace(blankLinesPattern.ReplaceAllString(text, "\n\n"))
+		if slide.Body == "" && slide.Background == "" && len(slide.Notes) == 0 {
+			continue
+		}
+		deck.Slides = append(deck.Slides, slide)
+	}
+	return deck
+}
+
+// hasClass reports whether a slide has a class
+func (s marpSlide) hasClass(class string) bool {
+	for _, c := range s.Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// frontmatterValue returns the value of a single line directive of the
+// frontmatter, without the quotes of quoteDirective
+func frontmatterValue(frontmatter, key string) string {
+	for _, line := range strings.Split(frontmatter, "\n") {
+		value, ok := strings.CutPrefix(line, key+":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+		}
+		return value
+	}
+	return ""
+}
+
+// slideHeading returns the first heading of a slide body, its level and the
+// body without it. The level is 0 if the slide has no heading.
+func slideHeading(body string) (string, int, string) {
+	lines := strings.Split(body, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		if match := headingPattern.FindStringSubmatch(line); match != nil && !inCode {
+			rest := append(append([]string{}, lines[:i]...), lines[i+1:]...)
+			return match[2], len(match[1]), strings.TrimSpace(strings.Join(rest, "\n"))
+		}
+	}
+	return "", 0, body
+}
+
+// splitColumns splits the blocks of a columns slide in two halves, the first
+// one getting the extra block
+func splitColumns(body string) (string, string) {
+	var blocks []string
+	current := []string{}
+	inCode := false
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		if !inCode && strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, strings.Join(current, "\n"))
+				current = []string{}
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, strings.Join(current, "\n"))
+	}
+
+	half := (len(blocks) + 1) / 2
+	return strings.Join(blocks[:half], "\n\n"), strings.Join(blocks[half:], "\n\n")
+}
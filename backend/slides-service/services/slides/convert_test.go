@@ -0,0 +1,33 @@
+package slides
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMarpDeck(t *testing.T) {
+	markdown := "---\nmarp: true\ntitle: \"Q3 \\\"Review\\\"\"\nlang: de\nstyle: |\n  section { color: red; }\n---\n\n<style>\nh1 { color: blue; }\n</style>\n\n" +
+		"<!-- _class: lead -->\n\n# Q3 Review\n\n<!-- Welcome everyone -->\n\n---\n\n<!-- class: invert -->\n<!-- _paginate: false -->\n\n## Numbers\n\n![bg left:40%](https://example.com/chart.png)\n\n![w:300 sepia Growth chart](https://example.com/growth.png)\n\n" +
+		"---\n\n## Next\n\n```\n---\n```"
+
+	deck := parseMarpDeck(markdown)
+	if deck.Title != `Q3 "Review"` || deck.Language != "de" {
+		t.Fatalf("expected the title and language of the frontmatter, got %q and %q", deck.Title, deck.Language)
+	}
+
+	want := []marpSlide{
+		{Classes: []string{"lead"}, Notes: []string{"Welcome everyone"}, Body: "# Q3 Review"},
+		{Classes: []string{"invert"}, Background: "https://example.com/chart.png", BackgroundSide: "left", Body: "## Numbers\n\n![Growth chart](https://example.com/growth.png)"},
+		{Classes: []string{"invert"}, Body: "## Next\n\n```\n---\n```"},
+	}
+	if !reflect.DeepEqual(deck.Slides, want) {
+		t.Fatalf("expected %+v, got %+v", want, deck.Slides)
+	}
+}
+
+func TestSplitColumns(t *testing.T) {
+	left, right := splitColumns("**Pros**\n\n- fast\n- cheap\n\n**Cons**\n\n- fragile")
+	if left != "**Pros**\n\n- fast\n- cheap" || right != "**Cons**\n\n- fragile" {
+		t.Fatalf("expected the blocks split in half, got %q and %q", left, right)
+	}
+}
@@ -0,0 +1,255 @@
+package slides
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+	"github.com/yuin/goldmark/text"
+)
+
+const (
+	// maxOverflowShare is the share of the slides of a deck written in one
+	// pass that may run off the page before it is written again, once, with
+	// fewer bullet points on each slide
+	maxOverflowShare = 0.2
+
+	// densityStep is the share of overflowing slides that takes a bullet
+	// point off the slides of a detail level
+	densityStep = 0.05
+
+	// minBullets is the fewest bullet points tuning leaves a slide
+	minBullets = 3
+
+	// minDensitySlides is how many slides of a detail level are measured
+	// before their statistics tune the prompt
+	minDensitySlides = 50
+
+	// densityDecay weighs down the statistics of a detail level with every
+	// deck recorded, so they follow changes to the prompts and the model
+	densityDecay = 0.99
+
+	// tinyTextScale is how much the tinytext class of the themes shrinks the text
+	tinyTextScale = 0.75
+)
+
+// detailBullets are the most bullet points the prompt of each detail level
+// allows on a slide
+var detailBullets = map[string]int{"detailed": 8, "medium": 6, "minimal": 4}
+
+// DensityReport is how full the slides of a deck are, measured by laying out
+// their text like the native renderer does. Images aren't measured.
+type DensityReport struct {
+	Slides      int     `json:"slides"`
+	Overflowing []int   `json:"overflowing,omitempty"` // Slides whose text runs off the page, counted from 1
+	MeanFill    float64 `json:"meanFill"`              // Average share of the content area the text of a slide takes
+}
+
+// overflowShare returns the share of the slides that run off the page
+func (r DensityReport) overflowShare() float64 {
+	if r.Slides == 0 {
+		return 0
+	}
+	return float64(len(r.Overflowing)) / float64(r.Slides)
+}
+
+// DensityStats aggregates the density of the decks of a detail level over
+// jobs, with older decks weighing less
+type DensityStats struct {
+	Slides      float64 `firestore:"slides"`
+	Overflowing float64 `firestore:"overflowing"`
+	MeanFill    float64 `firestore:"meanFill"`
+	UpdatedAt   int64   `firestore:"updatedAt"`
+}
+
+// Add returns the statistics with the density of another deck
+func (s DensityStats) Add(report DensityReport) DensityStats {
+	slides := s.Slides*densityDecay + float64(report.Slides)
+	if slides == 0 {
+		return s
+	}
+	return DensityStats{
+		Slides:      slides,
+		Overflowing: s.Overflowing*densityDecay + float64(len(report.Overflowing)),
+		MeanFill:    (s.MeanFill*s.Slides*densityDecay + report.MeanFill*float64(report.Slides)) / slides,
+		UpdatedAt:   time.Now().Unix(),
+	}
+}
+
+// DensityStore keeps the density statistics of each detail level, so the
+// prompts of a level tighten when its decks run off the page
+type DensityStore interface {
+	// GetDensityStats returns the statistics of a detail level, or nil if
+	// none of its decks were measured yet
+	GetDensityStats(ctx context.Context, detail string) (*DensityStats, error)
+	// RecordDensity adds the density of a deck to the statistics of its detail level
+	RecordDensity(ctx context.Context, detail string, report DensityReport) error
+}
+
+// maxBullets returns the bullet points a slide of a detail level gets, one
+// fewer than its prompt allows for each densityStep of the slides running
+// off the page, or 0 if too few ran off to change the prompt
+func maxBullets(detail string, overflowShare float64) int {
+	bullets, ok := detailBullets[detail]
+	cut := int(overflowShare / densityStep)
+	if !ok || cut == 0 {
+		return 0
+	}
+	return max(bullets-cut, minBullets)
+}
+
+// correctedBullets returns the bullet points a slide of a job gets once a
+// share of its slides ran off the page, at least one fewer than it was
+// allowed, or 0 if its detail level doesn't count bullet points
+func correctedBullets(settings models.SlideSettings, overflowShare float64) int {
+	bullets := maxBullets(settings.SlideDetail, max(overflowShare, densityStep))
+	if settings.MaxBullets > 0 {
+		bullets = min(bullets, max(settings.MaxBullets-1, minBullets))
+	}
+	return bullets
+}
+
+// tuneDensity lowers the bullet points of the slides of a job when the decks
+// of its detail level have been running off the page
+func (s *SlideService) tuneDensity(ctx context.Context, settings models.SlideSettings) models.SlideSettings {
+	if s.density == nil {
+		return settings
+	}
+	stats, err := s.density.GetDensityStats(ctx, settings.SlideDetail)
+	if err != nil {
+		log.Printf("Failed to get the density of %s decks: %v", settings.SlideDetail, err)
+		return settings
+	}
+	if stats == nil || stats.Slides < minDensitySlides {
+		return settings
+	}
+	if bullets := maxBullets(settings.SlideDetail, stats.Overflowing/stats.Slides); bullets > 0 {
+		log.Printf("%.0f%% of recent %s slides ran off the page, allowing %d bullet points", 100*stats.Overflowing/stats.Slides, settings.SlideDetail, bullets)
+		settings.MaxBullets = bullets
+	}
+	return settings
+}
+
+// recordDensity adds the density of a generated deck to the statistics of
+// its detail level, and returns a warning if some of its slides still run
+// off the page
+func (s *SlideService) recordDensity(ctx context.Context, detail string, report DensityReport) string {
+	if s.density != nil && report.Slides > 0 {
+		if err := s.density.RecordDensity(ctx, detail, report); err != nil {
+			log.Printf("Failed to record the density of the deck: %v", err)
+		}
+	}
+	if len(report.Overflowing) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Slides %s have more text than fits and may run off the page", joinNumbers(report.Overflowing))
+}
+
+// fitDensity writes a deck written in one pass again with fewer bullet
+// points on each slide when too many of its slides run off the page, keeping
+// whichever version overflows less
+func (s *SlideService) fitDensity(ctx context.Context, model *genai.GenerativeModel, documents []genai.Part, prompt, markdown string, settings models.SlideSettings) string {
+	report := measureDensity(markdown)
+	if report.overflowShare() <= maxOverflowShare {
+		return markdown
+	}
+	log.Printf("%d of %d slides ran off the page, writing the deck again", len(report.Overflowing), report.Slides)
+
+	correction, err := prompts.GenerateCorrectionPrompt(prompt, densityProblem(report, settings))
+	if err != nil {
+		log.Printf("Failed to write the density correction: %v", err)
+		return markdown
+	}
+	rewritten, err := s.generateValidMarkdown(ctx, model, documents, correction, true)
+	if err != nil {
+		log.Printf("Failed to write the deck again: %v", err)
+		return markdown
+	}
+	if overflowing := len(measureDensity(rewritten).Overflowing); overflowing >= len(report.Overflowing) {
+		log.Printf("The deck written again has %d slides running off the page, keeping the first", overflowing)
+		return markdown
+	}
+	return rewritten
+}
+
+// densityProblem describes the slides of a response that ran off the page,
+// for the correction prompt
+func densityProblem(report DensityReport, settings models.SlideSettings) string {
+	instruction := "Put less text on each slide"
+	if bullets := correctedBullets(settings, report.overflowShare()); bullets > 0 {
+		instruction = fmt.Sprintf("Put at most %d bullet points of one line each on a slide", bullets)
+	}
+	return fmt.Sprintf("had too much text on slides %s of %d, which ran off the page. %s, and split longer content over more slides",
+		joinNumbers(report.Overflowing), report.Slides, instruction)
+}
+
+// densityNote returns the instructions that keep the rest of a deck written
+// in sections from running off the page like the sections written so far,
+// or "" if they fit
+func densityNote(overflowing, slides int, settings models.SlideSettings) (string, error) {
+	if overflowing == 0 || slides == 0 {
+		return "", nil
+	}
+	return prompts.GenerateDensityPrompt(overflowing, slides, correctedBullets(settings, float64(overflowing)/float64(slides)))
+}
+
+// measureDensity lays out the text of every slide of a deck at full size and
+// measures how much of the content area it takes. Long tables are split
+// first, as they are before rendering.
+func measureDensity(markdown string) DensityReport {
+	deck := parseMarpDeck(splitLargeTables(markdown))
+	scratch := newNativePDF(nil)
+	contentHeight := nativePageHeight - 2*nativeMargin
+	report := DensityReport{Slides: len(deck.Slides)}
+	total := 0.0
+	for i, slide := range deck.Slides {
+		source := []byte(slide.Body)
+		layout := &nativeLayout{
+			pdf:     scratch,
+			source:  source,
+			palette: themePalette(""),
+			left:    nativeMargin,
+			right:   nativePageWidth - nativeMargin,
+			scale:   1,
+		}
+		if slide.hasClass("tinytext") {
+			layout.scale = tinyTextScale
+		}
+		switch {
+		case slide.Background != "" && slide.BackgroundSide == "left":
+			layout.left = nativePageWidth/2 + nativeMargin/2
+		case slide.Background != "" && slide.BackgroundSide == "right":
+			layout.right = nativePageWidth/2 - nativeMargin/2
+		}
+
+		scratch.AddPage()
+		doc := nativeMarkdown.Parser().Parse(text.NewReader(source))
+		fill := (layout.blocks(doc, nativeMargin) - nativeMargin) / contentHeight
+		total += fill
+		if fill > 1 {
+			report.Overflowing = append(report.Overflowing, i+1)
+		}
+	}
+	if report.Slides > 0 {
+		report.MeanFill = total / float64(report.Slides)
+	}
+	return report
+}
+
+// joinNumbers lists slide numbers as "2, 5 and 9"
+func joinNumbers(numbers []int) string {
+	texts := make([]string, len(numbers))
+	for i, number := range numbers {
+		texts[i] = strconv.Itoa(number)
+	}
+	if len(texts) == 1 {
+		return texts[0]
+	}
+	return strings.Join(texts[:len(texts)-1], ", ") + " and " + texts[len(texts)-1]
+}
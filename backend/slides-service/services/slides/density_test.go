@@ -0,0 +1,81 @@
+package slides
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+func TestMeasureDensity(t *testing.T) {
+	var long strings.Builder
+	for i := range 20 {
+		fmt.Fprintf(&long, "- Point %d about the quarterly results and what they mean for the team\n", i+1)
+	}
+	markdown := "---\nmarp: true\n---\n\n# Results\n\n- Revenue grew 12%\n\n---\n\n## Details\n\n" + long.String() +
+		"\n---\n\n<!-- _class: tinytext -->\n\n## Appendix\n\n- One point\n"
+
+	report := measureDensity(markdown)
+	if report.Slides != 3 {
+		t.Fatalf("expected 3 slides, got %d", report.Slides)
+	}
+	if len(report.Overflowing) != 1 || report.Overflowing[0] != 2 {
+		t.Errorf("expected slide 2 to run off the page, got %v", report.Overflowing)
+	}
+	if report.MeanFill <= 0 || report.overflowShare() != 1.0/3 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestMaxBullets(t *testing.T) {
+	tests := []struct {
+		detail        string
+		overflowShare float64
+		want          int
+	}{
+		{"medium", 0.01, 0},
+		{"medium", 0.05, 5},
+		{"medium", 0.12, 4},
+		{"detailed", 0.5, minBullets},
+		{"minimal", 0.1, minBullets},
+		{"", 0.5, 0},
+	}
+	for _, test := range tests {
+		if got := maxBullets(test.detail, test.overflowShare); got != test.want {
+			t.Errorf("maxBullets(%q, %v) = %d, want %d", test.detail, test.overflowShare, got, test.want)
+		}
+	}
+
+	// A job already tuned loses another bullet point when it still overflows
+	settings := models.SlideSettings{SlideDetail: "detailed", MaxBullets: 6}
+	if got := correctedBullets(settings, 0.01); got != 5 {
+		t.Errorf("expected 5 bullet points, got %d", got)
+	}
+}
+
+func TestDensityStatsAdd(t *testing.T) {
+	stats := DensityStats{}.Add(DensityReport{Slides: 10, Overflowing: []int{2, 5}, MeanFill: 0.5})
+	if stats.Slides != 10 || stats.Overflowing != 2 || stats.MeanFill != 0.5 || stats.UpdatedAt == 0 {
+		t.Errorf("unexpected statistics: %+v", stats)
+	}
+	stats = stats.Add(DensityReport{Slides: 10, MeanFill: 1})
+	if stats.Slides != 10*densityDecay+10 || stats.Overflowing != 2*densityDecay {
+		t.Errorf("expected older decks to weigh less, got %+v", stats)
+	}
+	if want := (0.5*10*densityDecay + 10) / stats.Slides; stats.MeanFill != want {
+		t.Errorf("expected a mean fill of %v, got %v", want, stats.MeanFill)
+	}
+	if empty := (DensityStats{}).Add(DensityReport{}); empty != (DensityStats{}) {
+		t.Errorf("expected an empty deck to change nothing, got %+v", empty)
+	}
+}
+
+func TestJoinNumbers(t *testing.T) {
+	if got := joinNumbers([]int{4}); got != "4" {
+		t.Errorf("expected 4, got %q", got)
+	}
+	if got := joinNumbers([]int{2, 5, 9}); got != "2, 5 and 9" {
+		t.Errorf("expected 2, 5 and 9, got %q", got)
+	}
+}
@@ -0,0 +1,210 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+const (
+	// flashcardOutputTokens is the projected output of extracting the flashcards
+	flashcardOutputTokens = maxFlashcards * 50
+
+	// onePagerOutputTokens is the projected output of writing the executive
+	// summary, at about 4 tokens for every 3 words
+	onePagerOutputTokens = onePagerWords * 4 / 3
+
+	// slideOutputTokens is the projected output of one slide, its markdown
+	// and directives
+	slideOutputTokens = 200
+)
+
+// slideCountPattern matches a topic asking for a number of slides
+var slideCountPattern = regexp.MustCompile(`(?i)\b(\d{1,3})\s+slides\b`)
+
+// deckOutputTokens is the projected output of writing the slides at each
+// level of detail, the medium level applies when none is set
+var deckOutputTokens = map[string]int{
+	"minimal":  1500,
+	"medium":   2500,
+	"detailed": 4096,
+}
+
+// TokenEstimate is the projected Gemini usage of generating a deck
+type TokenEstimate struct {
+	InputTokens  int  `json:"inputTokens"`
+	OutputTokens int  `json:"outputTokens"`
+	Trimmed      bool `json:"trimmed"` // The documents exceed the input budget and would be cut down to fit
+}
+
+// DryRun is what generating a deck would send to Gemini and produce, found
+// without calling Gemini or rendering it
+type DryRun struct {
+	Prompt    string           `json:"prompt"`    // Final prompt, sent after the documents
+	Documents []DryRunDocument `json:"documents"` // How each readable document would be sent
+	TokenEstimate
+	EstimatedSlides int      `json:"estimatedSlides"`
+	Warnings        []string `json:"warnings,omitempty"`
+}
+
+// DryRunDocument is a document as a dry run would send it
+type DryRunDocument struct {
+	Filename string `json:"filename"`
+	Inlined  bool   `json:"inlined"` // Sent as extracted text rather than as it was uploaded
+	Tokens   int    `json:"tokens"`  // Approximate, from the length of the text
+}
+
+// EstimateTokens projects the tokens generating a deck from the files, or from
+// the topic when there are none, would use. The input of the slides is counted
+// by Gemini as it would be sent, capped at the input budget, and the flashcards
+// and executive summary send the documents again, as do the outline and each
+// section of a deck too long for one output window. Output tokens are
+// projected from the settings and the length of the documents.
+func (s *SlideService) EstimateTokens(ctx context.Context, theme, topic string, files []models.File, settings models.SlideSettings) (*TokenEstimate, error) {
+	var prompt string
+	var err error
+	if topic != "" {
+		prompt, err = prompts.GenerateTopicPrompt(theme, settings, topic)
+	} else {
+		prompt, err = prompts.GenerateSlidePrompt(theme, settings, files)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// PDFs are counted inline, without uploading them to the File API
+	parts := make([]genai.Part, 0, len(files)+1)
+	for _, file := range files {
+		if file.Type == "application/pdf" {
+			parts = append(parts, genai.Blob{MIMEType: file.Type, Data: file.Data})
+			continue
+		}
+		text, err := extractText(ctx, file)
+		if err != nil {
+			log.Printf("Failed to extract text from %s, leaving it out of the estimate: %v", file.Filename, err)
+			continue
+		}
+		parts = append(parts, genai.Text(inlineDocument(file.Filename, text)))
+	}
+	parts = append(parts, genai.Text(prompt))
+
+	countCtx, cancel := context.WithTimeout(ctx, generationTimeout)
+	defer cancel()
+	countResp, err := s.model.CountTokens(countCtx, parts...)
+	if err != nil {
+		return nil, timeoutError(countCtx, err)
+	}
+
+	estimate, _ := s.projectTokens(int(countResp.TotalTokens), len(files) > 0, settings)
+	return estimate, nil
+}
+
+// projectTokens projects the usage of a deck whose documents and prompt
+// count inputTokens, from files when hasFiles is set and from a topic
+// otherwise. It also returns the projected output of the slides alone.
+func (s *SlideService) projectTokens(inputTokens int, hasFiles bool, settings models.SlideSettings) (*TokenEstimate, int) {
+	limits := s.jobTokenLimits(settings)
+	estimate := &TokenEstimate{
+		InputTokens:  min(inputTokens, limits.Input),
+		OutputTokens: deckOutputTokens["medium"],
+		Trimmed:      inputTokens > limits.Input,
+	}
+	if tokens, ok := deckOutputTokens[settings.SlideDetail]; ok {
+		estimate.OutputTokens = tokens
+	}
+	documentTokens := estimate.InputTokens
+
+	// Long decks send the documents again to plan them and for each section
+	if sections := deckSections(projectOutputTokens(settings, estimate.InputTokens), limits.Output); sections > 1 {
+		estimate.InputTokens += sections * documentTokens
+		estimate.OutputTokens = min(projectOutputTokens(settings, documentTokens), sections*limits.Output)
+	} else {
+		estimate.OutputTokens = min(estimate.OutputTokens, limits.Output)
+	}
+	slideTokens := estimate.OutputTokens
+	if !hasFiles {
+		// Decks written from a topic summarize the deck instead of documents
+		documentTokens = estimate.OutputTokens
+	}
+	if settings.Flashcards {
+		estimate.InputTokens += documentTokens
+		estimate.OutputTokens += flashcardOutputTokens
+	}
+	if settings.OnePager {
+		estimate.InputTokens += documentTokens
+		estimate.OutputTokens += onePagerOutputTokens
+	}
+	if settings.InstructorMode {
+		// The handout is rewritten from the deck, at about its length
+		estimate.InputTokens += slideTokens
+		estimate.OutputTokens += slideTokens
+	}
+	return estimate, slideTokens
+}
+
+// DryRun builds the prompt of a deck the way generating it would, and
+// projects its tokens and slides without calling Gemini or rendering it.
+// Tokens are approximated from the length of the text rather than counted by
+// Gemini, and documents are read as the generation would read them, so
+// unreadable files and injection warnings show up as they would in the job.
+func (s *SlideService) DryRun(ctx context.Context, theme, topic string, files []models.File, settings models.SlideSettings) (*DryRun, error) {
+	var prompt string
+	var err error
+	if topic != "" {
+		prompt, err = prompts.GenerateTopicPrompt(theme, settings, topic)
+	} else {
+		prompt, err = prompts.GenerateSlidePrompt(theme, settings, files)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun := &DryRun{Prompt: prompt, Documents: []DryRunDocument{}}
+	inputTokens := len(prompt) / charsPerToken
+	for _, file := range files {
+		text, found := "", false
+		if settings.InjectionDetection {
+			text, found = guardDocument(ctx, file)
+		}
+		if found {
+			dryRun.Warnings = append(dryRun.Warnings, injectionWarning(file.Filename))
+		} else if text, err = extractText(ctx, file); err != nil {
+			log.Printf("Failed to extract text from %s: %v", file.Filename, err)
+			dryRun.Warnings = append(dryRun.Warnings, unreadableWarning(file.Filename))
+			continue
+		}
+		// PDFs are uploaded to the File API unless text had to be removed
+		document := DryRunDocument{
+			Filename: file.Filename,
+			Inlined:  found || file.Type != "application/pdf",
+			Tokens:   len(inlineDocument(file.Filename, text)) / charsPerToken,
+		}
+		inputTokens += document.Tokens
+		dryRun.Documents = append(dryRun.Documents, document)
+	}
+	if len(files) > 0 && len(dryRun.Documents) == 0 {
+		return nil, errors.New("none of the files could be read")
+	}
+
+	estimate, slideTokens := s.projectTokens(inputTokens, len(files) > 0, settings)
+	dryRun.TokenEstimate = *estimate
+	dryRun.EstimatedSlides = estimateSlides(topic, slideTokens)
+	return dryRun, nil
+}
+
+// estimateSlides returns the number of slides a topic asks for, or the number
+// the projected output of the slides makes
+func estimateSlides(topic string, slideTokens int) int {
+	if match := slideCountPattern.FindStringSubmatch(topic); match != nil {
+		if count, err := strconv.Atoi(match[1]); err == nil && count > 0 {
+			return count
+		}
+	}
+	return max(1, (slideTokens+slideOutputTokens/2)/slideOutputTokens)
+}
@@ -0,0 +1,172 @@
+package slides
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// extractText extracts the text of a source file locally, for when the file
+// can't go through the Gemini File API
+func extractText(ctx context.Context, file models.File) (string, error) {
+	if cues := transcriptCues(file); cues != nil {
+		return formatTranscript(cues), nil
+	}
+	switch {
+	case file.Type == "application/pdf":
+		return extractPDFText(ctx, file.Data)
+	case isMarkdown(file) && !hasMath(string(file.Data)):
+		// Flattening would read math as emphasis, so markdown with math is kept as is
+		return extractMarkdownText(file.Data), nil
+	default:
+		return string(file.Data), nil
+	}
+}
+
+// isMarkdown reports whether a file is markdown, by MIME type or extension
+func isMarkdown(file models.File) bool {
+	return strings.Contains(file.Type, "markdown") || strings.EqualFold(filepath.Ext(file.Filename), ".md")
+}
+
+// extractPDFText runs pdftotext and marks the page breaks so the model can
+// still cite page numbers
+func extractPDFText(ctx context.Context, data []byte) (string, error) {
+	cmd := exec.CommandContext(ctx, "pdftotext", "-layout", "-enc", "UTF-8", "-", "-")
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("pdftotext failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// pdftotext ends every page with a form feed
+	pages := strings.Split(strings.TrimRight(stdout.String(), "\f"), "\f")
+	var sb strings.Builder
+	for i, page := range pages {
+		fmt.Fprintf(&sb, "[Page %d]\n%s\n\n", i+1, strings.TrimSpace(page))
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// extractMarkdownText flattens markdown to plain text, keeping headings and
+// list items on their own lines, keeping tables as markdown tables and
+// dropping link targets and raw HTML
+func extractMarkdownText(data []byte) string {
+	doc := goldmark.New(goldmark.WithExtensions(extension.Table)).Parser().Parse(text.NewReader(data))
+
+	var sb strings.Builder
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch node := n.(type) {
+		case *ast.Heading:
+			if entering {
+				sb.WriteString(strings.Repeat("#", node.Level) + " ")
+			} else {
+				sb.WriteString("\n\n")
+			}
+		case *ast.List:
+			if !entering {
+				sb.WriteString("\n")
+			}
+		case *ast.ListItem:
+			if entering {
+				sb.WriteString("- ")
+			}
+		case *ast.Paragraph, *ast.TextBlock:
+			if !entering {
+				sb.WriteString("\n")
+				if _, inList := n.Parent().(*ast.ListItem); !inList {
+					sb.WriteString("\n")
+				}
+			}
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			if entering {
+				lines := n.Lines()
+				for i := 0; i < lines.Len(); i++ {
+					segment := lines.At(i)
+					sb.Write(segment.Value(data))
+				}
+				sb.WriteString("\n")
+				return ast.WalkSkipChildren, nil
+			}
+		case *extast.Table:
+			if entering {
+				sb.WriteString(tableMarkdown(node, data) + "\n\n")
+				return ast.WalkSkipChildren, nil
+			}
+		case *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			if entering {
+				sb.Write(node.Segment.Value(data))
+				if node.SoftLineBreak() || node.HardLineBreak() {
+					sb.WriteString(" ")
+				}
+			}
+		case *ast.String:
+			if entering {
+				sb.Write(node.Value)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	return strings.TrimSpace(sb.String())
+}
+
+// tableMarkdown writes a parsed table back out as a markdown table
+func tableMarkdown(table *extast.Table, data []byte) string {
+	var rows []string
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, strings.ReplaceAll(nodeText(cell, data), "|", "\\|"))
+		}
+		rows = append(rows, "| "+strings.Join(cells, " | ")+" |")
+
+		// The separator goes after the header row
+		if _, isHeader := row.(*extast.TableHeader); isHeader {
+			separators := make([]string, len(cells))
+			for i := range separators {
+				separators[i] = "---"
+			}
+			rows = append(rows, "| "+strings.Join(separators, " | ")+" |")
+		}
+	}
+	return strings.Join(rows, "\n")
+}
+
+// nodeText returns the plain text inside a node
+func nodeText(n ast.Node, data []byte) string {
+	var sb strings.Builder
+	ast.Walk(n, func(child ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := child.(type) {
+		case *ast.Text:
+			sb.Write(node.Segment.Value(data))
+		case *ast.String:
+			sb.Write(node.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(sb.String())
+}
+
+// inlineDocument wraps extracted text so the model can tell the documents
+// apart, and from the prompt. Text imitating the delimiters is escaped.
+func inlineDocument(filename, content string) string {
+	filename = strings.Join(strings.Fields(escapeDelimiters(filename)), " ")
+	return fmt.Sprintf("--- BEGIN DOCUMENT: %s ---\n%s\n--- END DOCUMENT: %s ---", filename, escapeDelimiters(content), filename)
+}
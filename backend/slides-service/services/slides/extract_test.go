@@ -0,0 +1,38 @@
+package slides
+
+import (
+	"context"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+func TestExtractMarkdownText(t *testing.T) {
+	markdown := "# Quarterly Review\n\nRevenue grew **12%** in [Q3](https://example.com).\n\n- North\n- South\n\n<div>ignored</div>\n\n```\ntotal = 42\n```\n"
+
+	got := extractMarkdownText([]byte(markdown))
+	want := "# Quarterly Review\n\nRevenue grew 12% in Q3.\n\n- North\n- South\n\ntotal = 42"
+	if got != want {
+		t.Fatalf("unexpected text:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestExtractTextPlainFile(t *testing.T) {
+	file := models.File{Filename: "notes.txt", Type: "text/plain", Data: []byte("just notes")}
+
+	got, err := extractText(context.Background(), file)
+	if err != nil {
+		t.Fatalf("extractText failed: %v", err)
+	}
+	if got != "just notes" {
+		t.Fatalf("expected the file contents, got %q", got)
+	}
+}
+
+func TestInlineDocument(t *testing.T) {
+	got := inlineDocument("notes.md", "text")
+	want := "--- BEGIN DOCUMENT: notes.md ---\ntext\n--- END DOCUMENT: notes.md ---"
+	if got != want {
+		t.Fatalf("unexpected inlined document: %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+package slides
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// geminiFileTTL is how long an uploaded file is reused, Gemini deletes files
+// 48 hours after upload so this leaves time to finish the job using it
+const geminiFileTTL = 46 * time.Hour
+
+// FileCache remembers the Gemini file uploaded for each content hash, so a
+// document submitted again isn't uploaded to Gemini again
+type FileCache interface {
+	// GetGeminiFile returns the file uploaded for a content hash, or nil if
+	// there is none or it has expired
+	GetGeminiFile(ctx context.Context, hash string) (*GeminiFile, error)
+	// PutGeminiFile remembers the file uploaded for a content hash until it expires
+	PutGeminiFile(ctx context.Context, hash string, file GeminiFile, expiresAt time.Time) error
+}
+
+// uploadFile returns the Gemini file for a source file, reusing the file
+// uploaded for the same content when Gemini still has it
+func (s *SlideService) uploadFile(ctx context.Context, file models.File) (GeminiFile, error) {
+	cacheable := s.fileCache != nil && file.Hash != ""
+	if cacheable {
+		cached, err := s.fileCache.GetGeminiFile(ctx, file.Hash)
+		if err != nil {
+			log.Printf("Failed to look up the Gemini file for %s: %v", file.Filename, err)
+		} else if cached != nil {
+			info, err := s.client.GetFile(ctx, cached.Name)
+			if err == nil && info.State == genai.FileStateActive {
+				log.Printf("Reusing Gemini file %s for %s", cached.Name, file.Filename)
+				cached.Shared = true
+				return *cached, nil
+			}
+		}
+	}
+
+	uploaded, err := s.client.UploadFile(ctx, "", io.NopCloser(bytes.NewReader(file.Data)), &genai.UploadFileOptions{
+		DisplayName: file.Filename,
+		MIMEType:    file.Type,
+	})
+	if err != nil {
+		return GeminiFile{}, err
+	}
+	geminiFile := GeminiFile{Name: uploaded.Name, URI: uploaded.URI}
+
+	// Shared files are left for Gemini to delete when they expire
+	if cacheable {
+		if err := s.fileCache.PutGeminiFile(ctx, file.Hash, geminiFile, time.Now().Add(geminiFileTTL)); err != nil {
+			log.Printf("Failed to cache the Gemini file for %s: %v", file.Filename, err)
+		} else {
+			geminiFile.Shared = true
+		}
+	}
+	return geminiFile, nil
+}
+
+// deleteGeminiFiles deletes the files a job uploaded to Gemini and checks that
+// Gemini no longer has them, retrying the delete once. Shared files are left
+// for other jobs. It returns how many files couldn't be confirmed deleted.
+func (s *SlideService) deleteGeminiFiles(ctx context.Context, files []GeminiFile) int {
+	retained := 0
+	for _, file := range files {
+		if file.Name == "" || file.Shared {
+			continue
+		}
+		deleted := false
+		for attempt := 0; attempt < 2 && !deleted; attempt++ {
+			if err := s.client.DeleteFile(ctx, file.Name); err != nil && status.Code(err) != codes.NotFound {
+				log.Printf("Failed to delete file %s from Gemini: %v", file.Name, err)
+				continue
+			}
+			_, err := s.client.GetFile(ctx, file.Name)
+			deleted = status.Code(err) == codes.NotFound
+		}
+		if !deleted {
+			log.Printf("Warning: Gemini file %s couldn't be confirmed deleted", file.Name)
+			retained++
+		}
+	}
+	return retained
+}
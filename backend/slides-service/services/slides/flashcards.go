@@ -0,0 +1,107 @@
+package slides
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+// maxFlashcards bounds the flashcards extracted from the documents of a deck
+const maxFlashcards = 40
+
+// Flashcard is a term of the documents with its definition
+type Flashcard struct {
+	Term       string `firestore:"term" json:"term"`
+	Definition string `firestore:"definition" json:"definition"`
+}
+
+// flashcardSchema constrains the response of the flashcard pass to a list of
+// flashcards
+var flashcardSchema = &genai.Schema{
+	Type: genai.TypeArray,
+	Items: &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"term":       {Type: genai.TypeString},
+			"definition": {Type: genai.TypeString},
+		},
+		Required: []string{"term", "definition"},
+	},
+}
+
+// generateFlashcards extracts flashcards with a structured-output pass over
+// the documents the slides were generated from
+func (s *SlideService) generateFlashcards(ctx context.Context, documents []genai.Part, settings models.SlideSettings) ([]Flashcard, error) {
+	prompt, err := prompts.GenerateFlashcardPrompt(settings, maxFlashcards)
+	if err != nil {
+		return nil, err
+	}
+	parts := append(documents[:len(documents):len(documents)], genai.Text(prompt))
+
+	model := newGenerativeModel(s.client, 4096)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = flashcardSchema
+	resp, err := generateContent(ctx, model, ExchangeFlashcards, parts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, errors.New("empty response")
+	}
+	text, _ := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	return parseFlashcards(string(text))
+}
+
+// parseFlashcards parses the JSON flashcards returned by Gemini, dropping
+// incomplete and repeated terms
+func parseFlashcards(text string) ([]Flashcard, error) {
+	var cards []Flashcard
+	if err := json.Unmarshal([]byte(text), &cards); err != nil {
+		return nil, fmt.Errorf("invalid flashcards: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	flashcards := make([]Flashcard, 0, len(cards))
+	for _, card := range cards {
+		card.Term, card.Definition = strings.TrimSpace(card.Term), strings.TrimSpace(card.Definition)
+		key := strings.ToLower(card.Term)
+		if card.Term == "" || card.Definition == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		flashcards = append(flashcards, card)
+		if len(flashcards) == maxFlashcards {
+			break
+		}
+	}
+	if len(flashcards) == 0 {
+		return nil, errors.New("no flashcards found")
+	}
+	return flashcards, nil
+}
+
+// FlashcardsCSV encodes flashcards as CSV with the file headers Anki reads on
+// import, so each row becomes a basic note with the term on the front
+func FlashcardsCSV(flashcards []Flashcard) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("#separator:comma\n#html:false\n#columns:Term,Definition\n")
+	w := csv.NewWriter(&buf)
+	for _, card := range flashcards {
+		if err := w.Write([]string{card.Term, card.Definition}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,54 @@
+package slides
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFlashcards(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []Flashcard
+	}{
+		{
+			text:     `[{"term": " Latency ", "definition": "Time taken to respond."}, {"term": "Throughput", "definition": "Requests served per second."}]`,
+			expected: []Flashcard{{Term: "Latency", Definition: "Time taken to respond."}, {Term: "Throughput", Definition: "Requests served per second."}},
+		},
+		{
+			// Incomplete and repeated terms are dropped
+			text:     `[{"term": "Latency", "definition": ""}, {"term": "Cache", "definition": "Fast storage."}, {"term": "cache", "definition": "Again."}]`,
+			expected: []Flashcard{{Term: "Cache", Definition: "Fast storage."}},
+		},
+		{text: `[]`},
+		{text: `not json`},
+	}
+
+	for _, test := range tests {
+		flashcards, err := parseFlashcards(test.text)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("parseFlashcards(%q): expected an error, got %+v", test.text, flashcards)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(flashcards, test.expected) {
+			t.Errorf("parseFlashcards(%q): expected %+v, got %+v, %v", test.text, test.expected, flashcards, err)
+		}
+	}
+}
+
+func TestFlashcardsCSV(t *testing.T) {
+	data, err := FlashcardsCSV([]Flashcard{
+		{Term: "Latency", Definition: "Time taken, in ms"},
+		{Term: `"Quoted"`, Definition: "Line one\nline two"},
+	})
+	if err != nil {
+		t.Fatalf("FlashcardsCSV failed: %v", err)
+	}
+	expected := "#separator:comma\n#html:false\n#columns:Term,Definition\n" +
+		"Latency,\"Time taken, in ms\"\n" +
+		"\"\"\"Quoted\"\"\",\"Line one\nline two\"\n"
+	if string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
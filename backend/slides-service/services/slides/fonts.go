@@ -0,0 +1,255 @@
+package slides
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+const (
+	// googleFontsURL serves the stylesheets of Google Fonts families
+	googleFontsURL = "https://fonts.googleapis.com/css2"
+
+	// maxEmbeddedFontBytes bounds the font files embedded in a deck
+	maxEmbeddedFontBytes = 10 << 20
+
+	// fontTimeout bounds loading the fonts of a deck
+	fontTimeout = 30 * time.Second
+)
+
+var (
+	// googleFontsImportPattern matches @import rules loading a Google Fonts stylesheet
+	googleFontsImportPattern = regexp.MustCompile(`@import\s+(?:url\(\s*)?['"]?(https://fonts\.googleapis\.com/css2?\?[^'")\s;]+)['"]?\s*\)?[^;]*;`)
+
+	// stylesheetURLPattern matches the files a stylesheet references
+	stylesheetURLPattern = regexp.MustCompile(`url\(\s*['"]?([^'")\s]+)['"]?\s*\)`)
+)
+
+// fontFormats maps the extensions of uploaded font files to their CSS format
+// and content type
+var fontFormats = map[string][2]string{
+	".woff2": {"woff2", "font/woff2"},
+	".woff":  {"woff", "font/woff"},
+	".otf":   {"opentype", "font/otf"},
+	".ttf":   {"truetype", "font/ttf"},
+}
+
+// fontLoader embeds font files in CSS as data URIs, so Chromium has them
+// without loading anything while rendering and embeds them in the PDF
+type fontLoader struct {
+	fetch     assetFetcher
+	themes    ThemeRegistry // Reads the uploaded font files, nil when they can't be read
+	remaining int           // Bytes of font files that can still be embedded
+}
+
+// embedFonts embeds the fonts of the settings, the Google Fonts imported by
+// the theme stylesheet and, when the slides use icons, the Font Awesome
+// webfonts. It returns the CSS applying them to the deck, and warnings for
+// fonts that couldn't be loaded, which fall back to the theme's.
+func (s *SlideService) embedFonts(ctx context.Context, options *RenderOptions, settings models.SlideSettings, icons bool, fetch assetFetcher) (string, []string) {
+	ctx, cancel := context.WithTimeout(ctx, fontTimeout)
+	defer cancel()
+	loader := &fontLoader{fetch: fetch, themes: s.themes, remaining: maxEmbeddedFontBytes}
+	var warnings []string
+
+	if options.ThemeCSS != "" {
+		themeCSS, err := loader.inlineImports(ctx, options.ThemeCSS)
+		if err != nil {
+			log.Printf("Failed to embed the fonts of theme %s: %v", options.Theme, err)
+			warnings = append(warnings, fmt.Sprintf("The fonts of the %s theme couldn't be embedded, the PDF may use system fonts", options.Theme))
+		}
+		options.ThemeCSS = themeCSS
+	}
+
+	var style strings.Builder
+	if icons {
+		iconCSS, err := loader.stylesheet(ctx, fontAwesomeURL)
+		if err != nil {
+			log.Printf("Failed to load the icons: %v", err)
+			warnings = append(warnings, "The icons couldn't be loaded and are left out of the slides")
+		}
+		style.WriteString(iconCSS)
+	}
+
+	loaded := make(map[string]bool)
+	for _, family := range []string{settings.Font, settings.HeadingFont} {
+		if family == "" || loaded[family] {
+			continue
+		}
+		faces, err := loader.family(ctx, family, settings.FontFiles)
+		if err != nil {
+			log.Printf("Failed to load font %s: %v", family, err)
+			warnings = append(warnings, fmt.Sprintf("The %s font couldn't be loaded, the deck uses the theme's font", family))
+			continue
+		}
+		style.WriteString(faces)
+		loaded[family] = true
+	}
+
+	heading := settings.HeadingFont
+	if heading == "" {
+		heading = settings.Font
+	}
+	if loaded[settings.Font] {
+		style.WriteString("section { font-family: " + cssString(settings.Font) + ", sans-serif; }\n")
+	}
+	if loaded[heading] {
+		style.WriteString("section h1, section h2, section h3, section h4, section h5, section h6 { font-family: " + cssString(heading) + ", sans-serif; }\n")
+	}
+	return style.String(), warnings
+}
+
+// family returns the @font-face rules of a font family, from the files
+// uploaded for it or otherwise from Google Fonts
+func (l *fontLoader) family(ctx context.Context, family string, files []models.FontFile) (string, error) {
+	var uploaded []models.FontFile
+	for _, file := range files {
+		if strings.EqualFold(file.Family, family) {
+			uploaded = append(uploaded, file)
+		}
+	}
+	if len(uploaded) == 0 {
+		return l.googleFonts(ctx, family)
+	}
+	if l.themes == nil {
+		return "", errors.New("uploaded fonts can't be read on this instance")
+	}
+
+	var faces strings.Builder
+	for _, file := range uploaded {
+		format, ok := fontFormats[strings.ToLower(path.Ext(file.Path))]
+		if !ok {
+			return "", fmt.Errorf("unsupported font file %s", file.Path)
+		}
+		data, err := l.themes.FontFile(ctx, file.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %v", file.Path, err)
+		}
+		uri, err := l.dataURI(data, format[1])
+		if err != nil {
+			return "", err
+		}
+		style := file.Style
+		if style == "" {
+			style = "normal"
+		}
+		weight := file.Weight
+		if weight == 0 {
+			weight = 400
+		}
+		fmt.Fprintf(&faces, "@font-face { font-family: %s; src: url(%s) format(%q); font-weight: %d; font-style: %s; }\n",
+			cssString(family), uri, format[0], weight, style)
+	}
+	return faces.String(), nil
+}
+
+// googleFonts returns the @font-face rules of a Google Fonts family with the
+// regular and bold weights, or only the weights it has when it lacks them
+func (l *fontLoader) googleFonts(ctx context.Context, family string) (string, error) {
+	base := googleFontsURL + "?family=" + url.QueryEscape(family)
+	faces, err := l.stylesheet(ctx, base+":wght@400;700")
+	if err != nil {
+		faces, err = l.stylesheet(ctx, base)
+	}
+	return faces, err
+}
+
+// inlineImports replaces the Google Fonts stylesheets a theme imports with
+// their @font-face rules. The stylesheet is returned unchanged on error.
+func (l *fontLoader) inlineImports(ctx context.Context, css string) (string, error) {
+	var firstErr error
+	inlined := googleFontsImportPattern.ReplaceAllStringFunc(css, func(match string) string {
+		faces, err := l.stylesheet(ctx, googleFontsImportPattern.FindStringSubmatch(match)[1])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return match
+		}
+		return faces
+	})
+	if firstErr != nil {
+		return css, firstErr
+	}
+	return inlined, nil
+}
+
+// stylesheet fetches a stylesheet, such as the one of a Google Fonts family,
+// and embeds the font files it references
+func (l *fontLoader) stylesheet(ctx context.Context, stylesheetURL string) (string, error) {
+	base, err := url.Parse(stylesheetURL)
+	if err != nil {
+		return "", err
+	}
+	data, contentType, err := l.fetch(ctx, stylesheetURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %v", stylesheetURL, err)
+	}
+	if contentType != "text/css" {
+		return "", fmt.Errorf("unexpected content type %q from %s", contentType, stylesheetURL)
+	}
+
+	var firstErr error
+	css := stylesheetURLPattern.ReplaceAllStringFunc(string(data), func(match string) string {
+		reference := stylesheetURLPattern.FindStringSubmatch(match)[1]
+		if strings.HasPrefix(reference, "data:") {
+			return match
+		}
+		ref, err := url.Parse(reference)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return match
+		}
+		fileURL := base.ResolveReference(ref).String()
+		file, contentType, err := l.fetch(ctx, fileURL)
+		var uri string
+		if err == nil {
+			uri, err = l.dataURI(file, contentType)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to embed %s: %v", fileURL, err)
+			}
+			return match
+		}
+		return "url(" + uri + ")"
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return css + "\n", nil
+}
+
+// dataURI encodes a font file as a data URI if it fits in what's left of the budget
+func (l *fontLoader) dataURI(data []byte, contentType string) (string, error) {
+	if len(data) > l.remaining {
+		return "", fmt.Errorf("the fonts of a deck must be at most %d MB", maxEmbeddedFontBytes>>20)
+	}
+	l.remaining -= len(data)
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// insertStyle adds a global style element after the frontmatter, which Marp
+// applies on top of the theme
+func insertStyle(markdown, css string) string {
+	if css == "" {
+		return markdown
+	}
+	style := "<style>\n" + css + "</style>\n\n"
+	loc := frontmatterPattern.FindStringIndex(markdown)
+	if loc == nil {
+		return style + markdown
+	}
+	return markdown[:loc[1]] + "\n\n" + style + strings.TrimLeft(markdown[loc[1]:], "\r\n")
+}
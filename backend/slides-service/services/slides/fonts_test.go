@@ -0,0 +1,99 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+// googleFontsFetch serves the Inter family as Google Fonts does
+func googleFontsFetch(ctx context.Context, url string) ([]byte, string, error) {
+	switch {
+	case strings.HasPrefix(url, googleFontsURL+"?family=Inter"):
+		return []byte("@font-face {\n  font-family: 'Inter';\n  src: url(https://fonts.gstatic.com/s/inter/v1/inter.ttf) format('truetype');\n}"), "text/css", nil
+	case url == "https://fonts.gstatic.com/s/inter/v1/inter.ttf":
+		return []byte("ttf"), "font/ttf", nil
+	}
+	return nil, "", errors.New("unexpected status 400")
+}
+
+func TestEmbedFontsFromGoogleFonts(t *testing.T) {
+	s := &SlideService{}
+	options := RenderOptions{Theme: "beam", ThemeCSS: "/* @theme beam */\n@import url('https://fonts.googleapis.com/css2?family=Inter&display=swap');\nsection { color: black; }"}
+	style, warnings := s.embedFonts(context.Background(), &options, models.SlideSettings{Font: "Inter", HeadingFont: "Comic Neue"}, false, googleFontsFetch)
+
+	if strings.Contains(options.ThemeCSS, "@import") || !strings.Contains(options.ThemeCSS, "url(data:font/ttf;base64,dHRm)") {
+		t.Errorf("expected the theme's Google Fonts import to be embedded, got %q", options.ThemeCSS)
+	}
+	if !strings.Contains(style, "url(data:font/ttf;base64,dHRm)") || !strings.Contains(style, `section { font-family: "Inter", sans-serif; }`) {
+		t.Errorf("expected the text font to be embedded and applied, got %q", style)
+	}
+	if strings.Contains(style, "h1") {
+		t.Errorf("expected the heading font that couldn't be loaded to be left out, got %q", style)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "Comic Neue") {
+		t.Errorf("expected a warning for the heading font, got %v", warnings)
+	}
+}
+
+func TestEmbedFontsFromUploads(t *testing.T) {
+	s := &SlideService{themes: mapThemeRegistry{"fonts/ws-1/abc.woff2": "woff"}}
+	settings := models.SlideSettings{
+		Font:      "Acme Sans",
+		FontFiles: []models.FontFile{{Family: "Acme Sans", Path: "fonts/ws-1/abc.woff2", Weight: 700, Style: "italic"}},
+	}
+	style, warnings := s.embedFonts(context.Background(), &RenderOptions{Theme: "gaia"}, settings, false, googleFontsFetch)
+
+	expected := `@font-face { font-family: "Acme Sans"; src: url(data:font/woff2;base64,d29mZg==) format("woff2"); font-weight: 700; font-style: italic; }`
+	if !strings.Contains(style, expected) || !strings.Contains(style, `section h1, section h2`) {
+		t.Errorf("expected the uploaded font to be embedded for the text and headings, got %q", style)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestInsertStyle(t *testing.T) {
+	tests := []struct {
+		markdown string
+		expected string
+	}{
+		{"---\nmarp: true\n---\n\n# Title", "---\nmarp: true\n---\n\n<style>\nsection {}\n</style>\n\n# Title"},
+		{"# Title", "<style>\nsection {}\n</style>\n\n# Title"},
+	}
+
+	for _, test := range tests {
+		if result := insertStyle(test.markdown, "section {}\n"); result != test.expected {
+			t.Errorf("insertStyle(%q) = %q, want %q", test.markdown, result, test.expected)
+		}
+	}
+}
+
+func TestEmbedFontsWithIcons(t *testing.T) {
+	fetch := func(ctx context.Context, url string) ([]byte, string, error) {
+		switch url {
+		case fontAwesomeURL:
+			return []byte(`.fa-solid{font-family:"Font Awesome 6 Free"}@font-face{src:url(../webfonts/fa-solid-900.woff2) format("woff2"),url(data:font/woff2;base64,AA==)}`), "text/css", nil
+		case "https://cdn.jsdelivr.net/npm/@fortawesome/fontawesome-free@6.5.2/webfonts/fa-solid-900.woff2":
+			return []byte("fa"), "font/woff2", nil
+		}
+		return nil, "", errors.New("unexpected status 404")
+	}
+
+	markdown := "## Launch\n\n- <i class=\"fa-solid fa-rocket\"></i> Ship it :tada:"
+	if !usesIcons(markdown) || usesIcons("## Launch\n\n- Ship it :tada:") {
+		t.Fatal("expected only slides with Font Awesome icons to use icons")
+	}
+
+	s := &SlideService{}
+	style, warnings := s.embedFonts(context.Background(), &RenderOptions{Theme: "default"}, models.SlideSettings{}, usesIcons(markdown), fetch)
+	if !strings.Contains(style, "url(data:font/woff2;base64,ZmE=)") || !strings.Contains(style, "url(data:font/woff2;base64,AA==)") {
+		t.Errorf("expected the relative webfont to be embedded and the data URI kept, got %q", style)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
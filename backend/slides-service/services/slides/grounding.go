@@ -0,0 +1,185 @@
+package slides
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+const (
+	// maxGroundingClaims bounds the bullet points checked against the
+	// documents in one pass, later ones are counted as unchecked
+	maxGroundingClaims = 120
+
+	// minClaimWords is the fewest words a bullet point needs to be checked,
+	// shorter ones are labels rather than statements
+	minClaimWords = 3
+)
+
+var (
+	// markdownLinkPattern matches a markdown link and captures its text
+	markdownLinkPattern = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+
+	// emphasisPattern matches the emphasis and code markers of markdown text
+	emphasisPattern = regexp.MustCompile("\\*\\*|__|[*_`]")
+)
+
+// GroundingReport is the result of checking the bullet points of a deck
+// against the documents it was made from, for readers who need every
+// statement of a deck to be traceable to its sources
+type GroundingReport struct {
+	Claims      []GroundedClaim `json:"claims" firestore:"claims"`
+	Unsupported int             `json:"unsupported" firestore:"unsupported"`
+	Unchecked   int             `json:"unchecked,omitempty" firestore:"unchecked,omitempty"` // Bullet points past the limit of one pass, or that the model gave no verdict on
+}
+
+// GroundedClaim is a bullet point of a deck with the verdict on whether the
+// documents support it
+type GroundedClaim struct {
+	Slide     int    `json:"slide" firestore:"slide"` // Counted from 1 in the generated deck
+	Text      string `json:"text" firestore:"text"`
+	Supported bool   `json:"supported" firestore:"supported"`
+	Evidence  string `json:"evidence,omitempty" firestore:"evidence,omitempty"` // Quote of the documents supporting the bullet point
+	Reason    string `json:"reason,omitempty" firestore:"reason,omitempty"`     // Why the documents don't support the bullet point
+}
+
+// warning returns the warning of a report with unsupported bullet points, or
+// "" if the documents support all of them
+func (r *GroundingReport) warning() string {
+	switch r.Unsupported {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("1 of %d bullet points isn't supported by the documents, see the grounding report", len(r.Claims))
+	default:
+		return fmt.Sprintf("%d of %d bullet points aren't supported by the documents, see the grounding report", r.Unsupported, len(r.Claims))
+	}
+}
+
+// groundingVerdict is the answer of the model on one statement
+type groundingVerdict struct {
+	ID        int    `json:"id"`
+	Supported bool   `json:"supported"`
+	Evidence  string `json:"evidence"`
+	Reason    string `json:"reason"`
+}
+
+// groundingSchema constrains the response of the grounding pass to a verdict
+// per statement
+var groundingSchema = &genai.Schema{
+	Type: genai.TypeArray,
+	Items: &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"id":        {Type: genai.TypeInteger},
+			"supported": {Type: genai.TypeBoolean},
+			"evidence":  {Type: genai.TypeString},
+			"reason":    {Type: genai.TypeString},
+		},
+		Required: []string{"id", "supported"},
+	},
+}
+
+// checkGrounding asks Gemini whether the documents a deck was generated from
+// support each of its bullet points, with a structured-output pass
+func (s *SlideService) checkGrounding(ctx context.Context, documents []genai.Part, markdown string) (*GroundingReport, error) {
+	claims := extractClaims(markdown)
+	report := &GroundingReport{}
+	if len(claims) > maxGroundingClaims {
+		report.Unchecked = len(claims) - maxGroundingClaims
+		claims = claims[:maxGroundingClaims]
+	}
+	if len(claims) == 0 {
+		return report, nil
+	}
+
+	statements := make([]prompts.GroundingClaim, len(claims))
+	for i, claim := range claims {
+		statements[i] = prompts.GroundingClaim{ID: i + 1, Text: claim.Text}
+	}
+	prompt, err := prompts.GenerateGroundingPrompt(statements)
+	if err != nil {
+		return nil, err
+	}
+	parts := append(documents[:len(documents):len(documents)], genai.Text(prompt))
+
+	model := newGenerativeModel(s.client, 8192)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = groundingSchema
+	resp, err := generateContent(ctx, model, ExchangeGrounding, parts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, errors.New("empty response")
+	}
+	text, _ := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	return applyVerdicts(report, claims, string(text))
+}
+
+// applyVerdicts adds the claims the model gave a verdict on to the report
+func applyVerdicts(report *GroundingReport, claims []GroundedClaim, text string) (*GroundingReport, error) {
+	var verdicts []groundingVerdict
+	if err := json.Unmarshal([]byte(text), &verdicts); err != nil {
+		return nil, fmt.Errorf("invalid grounding verdicts: %v", err)
+	}
+
+	answered := make(map[int]groundingVerdict, len(verdicts))
+	for _, verdict := range verdicts {
+		if verdict.ID >= 1 && verdict.ID <= len(claims) {
+			answered[verdict.ID] = verdict
+		}
+	}
+	for i, claim := range claims {
+		verdict, ok := answered[i+1]
+		if !ok {
+			report.Unchecked++
+			continue
+		}
+		claim.Supported = verdict.Supported
+		if verdict.Supported {
+			claim.Evidence = strings.TrimSpace(verdict.Evidence)
+		} else {
+			claim.Reason = strings.TrimSpace(verdict.Reason)
+			report.Unsupported++
+		}
+		report.Claims = append(report.Claims, claim)
+	}
+	if len(report.Claims) == 0 {
+		return nil, errors.New("no verdicts found")
+	}
+	return report, nil
+}
+
+// extractClaims returns the bullet points of a deck as plain text, outside
+// code blocks and speaker notes, leaving out the ones too short to state
+// anything
+func extractClaims(markdown string) []GroundedClaim {
+	_, slides := splitSlides(markdown)
+	var claims []GroundedClaim
+	for i, slide := range slides {
+		inCode := false
+		for _, line := range strings.Split(htmlCommentPattern.ReplaceAllString(slide, ""), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "```") {
+				inCode = !inCode
+			}
+			match := bulletPattern.FindStringSubmatch(line)
+			if inCode || match == nil {
+				continue
+			}
+			text := markdownLinkPattern.ReplaceAllString(match[2], "$1")
+			text = strings.Join(strings.Fields(emphasisPattern.ReplaceAllString(text, "")), " ")
+			if len(strings.Fields(text)) < minClaimWords {
+				continue
+			}
+			claims = append(claims, GroundedClaim{Slide: i + 1, Text: text})
+		}
+	}
+	return claims
+}
@@ -0,0 +1,49 @@
+package slides
+
+import (
+	"testing"
+)
+
+func TestExtractClaims(t *testing.T) {
+	markdown := "---\nmarp: true\n---\n\n# Quarterly Review\n\n---\n\n## Revenue\n\n- Revenue grew **12%** in [Q3](https://example.com)\n- Growth\n  - Subscriptions drove `most` of it\n\n```\n- not a claim in code\n```\n\n<!--\n- Speaker notes aren't checked\n-->\n"
+	claims := extractClaims(markdown)
+	if len(claims) != 2 {
+		t.Fatalf("expected 2 claims, got %+v", claims)
+	}
+	if claims[0].Slide != 2 || claims[0].Text != "Revenue grew 12% in Q3" {
+		t.Errorf("unexpected claim %+v", claims[0])
+	}
+	if claims[1].Slide != 2 || claims[1].Text != "Subscriptions drove most of it" {
+		t.Errorf("unexpected claim %+v", claims[1])
+	}
+}
+
+func TestApplyVerdicts(t *testing.T) {
+	claims := []GroundedClaim{
+		{Slide: 2, Text: "Revenue grew 12% in Q3"},
+		{Slide: 2, Text: "Subscriptions drove most of it"},
+		{Slide: 3, Text: "The team doubled in size"},
+	}
+	response := `[
+		{"id": 1, "supported": true, "evidence": " Revenue rose 12% in the third quarter. "},
+		{"id": 3, "supported": false, "evidence": "", "reason": "The documents don't mention the team"},
+		{"id": 9, "supported": true}
+	]`
+	report, err := applyVerdicts(&GroundingReport{Unchecked: 4}, claims, response)
+	if err != nil {
+		t.Fatalf("applyVerdicts failed: %v", err)
+	}
+	if len(report.Claims) != 2 || report.Unsupported != 1 || report.Unchecked != 5 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.Claims[0].Evidence != "Revenue rose 12% in the third quarter." || report.Claims[1].Reason == "" || report.Claims[1].Slide != 3 {
+		t.Errorf("unexpected claims: %+v", report.Claims)
+	}
+	if warning := report.warning(); warning != "1 of 2 bullet points isn't supported by the documents, see the grounding report" {
+		t.Errorf("unexpected warning %q", warning)
+	}
+
+	if _, err := applyVerdicts(&GroundingReport{}, claims, "[]"); err == nil {
+		t.Error("expected an error without verdicts")
+	}
+}
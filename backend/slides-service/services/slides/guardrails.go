@@ -0,0 +1,80 @@
+package slides
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+// removedInjection replaces the lines of a document that read as instructions
+// to the model when injection detection is on
+const removedInjection = "[text addressed to the AI removed]"
+
+// delimiterPattern matches lines of a document that imitate the delimiters
+// inlined documents are wrapped in
+var delimiterPattern = regexp.MustCompile(`(?im)^[ \t]*-{3,}[ \t]*((?:BEGIN|END)[ \t]+DOCUMENT)`)
+
+// injectionPatterns match text in documents that tries to give the model
+// instructions instead of being presented
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override)\b.{0,40}\b(?:previous|prior|above|earlier|preceding|all|system|original)\b.{0,20}\b(?:instructions?|prompts?|rules|directions|context)\b`),
+	regexp.MustCompile(`(?i)\byou are (?:now|no longer)\b`),
+	regexp.MustCompile(`(?i)\b(?:new|updated|real|actual) (?:system )?instructions?\s*:`),
+	regexp.MustCompile(`(?i)\b(?:reveal|print|repeat|show|output)\b.{0,30}\b(?:system prompt|system instructions?|your instructions)\b`),
+	regexp.MustCompile(`(?i)^\s*(?:system|assistant)\s*:`),
+	regexp.MustCompile(`(?i)<\|?(?:im_start|im_end|system|endoftext)\|?>`),
+}
+
+// newGenerativeModel returns a Gemini model writing at most maxOutputTokens,
+// with the system instruction that keeps documents from instructing it
+func newGenerativeModel(client *genai.Client, maxOutputTokens int) *genai.GenerativeModel {
+	model := client.GenerativeModel("gemini-1.5-flash")
+	model.SetMaxOutputTokens(int32(maxOutputTokens))
+	model.SystemInstruction = &genai.Content{Parts: []genai.Part{genai.Text(prompts.SystemInstruction)}}
+	return model
+}
+
+// escapeDelimiters keeps the text of a document from closing the delimiters
+// it is inlined in and opening a section of its own
+func escapeDelimiters(text string) string {
+	return delimiterPattern.ReplaceAllString(text, "$1")
+}
+
+// removeInjections replaces the lines of a document that read as instructions
+// to the model, and returns the text and how many lines were replaced
+func removeInjections(text string) (string, int) {
+	lines := strings.Split(text, "\n")
+	removed := 0
+	for i, line := range lines {
+		for _, pattern := range injectionPatterns {
+			if pattern.MatchString(line) {
+				lines[i] = removedInjection
+				removed++
+				break
+			}
+		}
+	}
+	return strings.Join(lines, "\n"), removed
+}
+
+// guardDocument returns the text of a document without the lines that read as
+// instructions to the model, and false when it has none or can't be read, in
+// which case the document is sent as it is
+func guardDocument(ctx context.Context, file models.File) (string, bool) {
+	text, err := extractText(ctx, file)
+	if err != nil {
+		return "", false
+	}
+	text, removed := removeInjections(text)
+	return text, removed > 0
+}
+
+// injectionWarning is the warning for a document text was removed from
+func injectionWarning(filename string) string {
+	return fmt.Sprintf("Text in %s that looked like instructions to the AI was left out", filename)
+}
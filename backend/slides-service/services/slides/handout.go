@@ -0,0 +1,70 @@
+package slides
+
+import (
+	"context"
+	"strings"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+// generateHandout writes the student handout of an instructor deck: the same
+// slides without the presenter notes, with blanks to fill in and review
+// questions
+func (s *SlideService) generateHandout(ctx context.Context, markdown string, settings models.SlideSettings) (string, error) {
+	prompt, err := prompts.GenerateHandoutPrompt(settings, markdown)
+	if err != nil {
+		return "", err
+	}
+	handout, err := s.generateValidMarkdown(ctx, s.generationModel(s.jobTokenLimits(settings)), nil, prompt, true)
+	if err != nil {
+		return "", err
+	}
+	return removeNotes(handout), nil
+}
+
+// removeNotes removes the speaker notes the model left in a deck, keeping the
+// comments that hold Marp directives
+func removeNotes(markdown string) string {
+	withoutNotes := commentPattern.ReplaceAllStringFunc(markdown, func(comment string) string {
+		if directiveCommentPattern.MatchString(comment) {
+			return comment
+		}
+		return ""
+	})
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(withoutNotes, "\n\n"))
+}
+
+// renderHandout renders the student handout with the theme, fonts and
+// branding of the deck, as a PDF only
+func (s *SlideService) renderHandout(ctx context.Context, theme, markdown string, files []models.File, settings models.SlideSettings) ([]byte, error) {
+	markdown, _ = sanitizeMarkdown(markdown)
+	markdown = applyLayoutStyle(markdown, theme, settings.LayoutStyle)
+	markdown = applyLayouts(markdown, files)
+	markdown = splitLargeTables(markdown)
+	markdown = applyMath(markdown)
+	markdown = applyDocumentMetadata(markdown, settings.Language)
+	markdown = applyWatermark(markdown, settings)
+
+	renderOptions, _ := s.themeOptions(ctx, theme)
+	renderOptions.Engine = settings.Renderer
+	renderOptions.Footer = expandDatePlaceholder(settings.Footer)
+	renderOptions.Watermark = expandDatePlaceholder(settings.Watermark)
+	renderOptions.PDFOnly = true
+	fontCSS, _ := s.embedFonts(ctx, &renderOptions, settings, usesIcons(markdown), fetchAsset)
+	markdown = insertStyle(markdown, fontCSS)
+
+	release, err := s.renders.acquire(ctx, func() error { return nil })
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	renderCtx, cancelRender := context.WithTimeout(ctx, renderTimeout)
+	defer cancelRender()
+	output, err := s.renderer.Render(renderCtx, markdown, renderOptions)
+	if err != nil {
+		return nil, timeoutError(renderCtx, err)
+	}
+	return output.PDFData, nil
+}
@@ -0,0 +1,12 @@
+package slides
+
+import "testing"
+
+func TestRemoveNotes(t *testing.T) {
+	markdown := "---\nmarp: true\n---\n\n# Sorting\n\n<!-- Ask who has sorted a deck of cards -->\n\n---\n\n<!-- _class: lead -->\n## Quicksort\n\n- Picks a ________\n\n<!--\nAnswer: a pivot\n-->"
+
+	expected := "---\nmarp: true\n---\n\n# Sorting\n\n---\n\n<!-- _class: lead -->\n## Quicksort\n\n- Picks a ________"
+	if got := removeNotes(markdown); got != expected {
+		t.Fatalf("unexpected handout:\n%s", got)
+	}
+}
@@ -0,0 +1,17 @@
+package slides
+
+import "regexp"
+
+// fontAwesomeURL serves the Font Awesome Free stylesheet, which references
+// its webfonts relative to it
+const fontAwesomeURL = "https://cdn.jsdelivr.net/npm/@fortawesome/fontawesome-free@6.5.2/css/all.min.css"
+
+// iconPattern matches the Font Awesome icons the slides can use, such as
+// <i class="fa-solid fa-rocket"></i>
+var iconPattern = regexp.MustCompile(`<i\s+class="fa-(?:solid|regular|brands)\s+fa-[a-z0-9-]+"`)
+
+// usesIcons reports whether the slides use Font Awesome icons, whose
+// stylesheet is only embedded in decks that need it
+func usesIcons(markdown string) bool {
+	return iconPattern.MatchString(markdown)
+}
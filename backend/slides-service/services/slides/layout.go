@@ -0,0 +1,116 @@
+package slides
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+const (
+	// minSplitPercent and maxSplitPercent bound the width of a split background
+	minSplitPercent = 20
+	maxSplitPercent = 70
+
+	// columnsStyle lays out the blocks of a columns slide side by side under
+	// the slide title, for all themes
+	columnsStyle = `<style>
+section.columns {
+  display: grid;
+  grid-template-columns: 1fr 1fr;
+  grid-auto-rows: min-content;
+  column-gap: 48px;
+  align-content: center;
+}
+section.columns > h1,
+section.columns > h2,
+section.columns > h3 {
+  grid-column: 1 / -1;
+}
+</style>`
+)
+
+var (
+	classDirectivePattern = regexp.MustCompile(`<!--\s*(_?class)\s*:\s*(.*?)\s*-->`)
+	splitKeywordPattern   = regexp.MustCompile(`^(left|right)(?::(\d+)%)?$`)
+
+	// layoutClasses are the slide classes the prompts teach, other classes are dropped
+	layoutClasses = map[string]bool{
+		"lead":     true,
+		"invert":   true,
+		"title":    true,
+		"tinytext": true,
+		"columns":  true,
+	}
+)
+
+// applyLayouts validates the layout directives in the generated markdown:
+// unknown slide classes are dropped, split background widths are clamped and
+// images that don't come from the source documents are removed. The columns
+// stylesheet is added when a slide uses the columns class.
+func applyLayouts(markdown string, files []models.File) string {
+	usesColumns := false
+	markdown = classDirectivePattern.ReplaceAllStringFunc(markdown, func(match string) string {
+		groups := classDirectivePattern.FindStringSubmatch(match)
+		classes := make([]string, 0)
+		for _, class := range strings.Fields(groups[2]) {
+			if !layoutClasses[class] {
+				log.Printf("Dropping unknown slide class: %s", class)
+				continue
+			}
+			if class == "columns" {
+				usesColumns = true
+			}
+			classes = append(classes, class)
+		}
+		if len(classes) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("<!-- %s: %s -->", groups[1], strings.Join(classes, " "))
+	})
+
+	markdown = markdownImagePattern.ReplaceAllStringFunc(markdown, func(match string) string {
+		groups := markdownImagePattern.FindStringSubmatch(match)
+		words := strings.Fields(groups[1])
+		if len(words) == 0 || words[0] != "bg" {
+			return match
+		}
+
+		// The model has no images of its own, so a background must be one of the sources' images
+		if !appearsInSources(groups[2], files) {
+			log.Printf("Dropping background image not found in the sources: %s", groups[2])
+			return ""
+		}
+
+		for i, word := range words {
+			if split := splitKeywordPattern.FindStringSubmatch(word); split != nil && split[2] != "" {
+				percent, _ := strconv.Atoi(split[2])
+				percent = max(minSplitPercent, min(maxSplitPercent, percent))
+				words[i] = fmt.Sprintf("%s:%d%%", split[1], percent)
+			}
+		}
+		return fmt.Sprintf("![%s](%s%s)", strings.Join(words, " "), groups[2], groups[3])
+	})
+
+	if !usesColumns {
+		return markdown
+	}
+	if loc := frontmatterPattern.FindStringIndex(markdown); loc != nil {
+		return markdown[:loc[1]] + "\n\n" + columnsStyle + markdown[loc[1]:]
+	}
+	return columnsStyle + "\n\n" + markdown
+}
+
+// appearsInSources reports whether a URL occurs in any of the source files
+func appearsInSources(url string, files []models.File) bool {
+	for _, file := range files {
+		if bytes.Contains(file.Data, []byte(url)) {
+			return true
+		}
+	}
+	return false
+}
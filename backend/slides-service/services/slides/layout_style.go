@@ -0,0 +1,177 @@
+package slides
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+// Layout styles, which pick the slide classes of a deck instead of the model
+const (
+	LayoutStyleMinimal  = "minimal"  // Plain slides, only the title slide stands out
+	LayoutStyleBalanced = "balanced" // Section dividers stand out and comparisons use columns
+	LayoutStyleBold     = "bold"     // Like balanced, with inverted dividers and closing slide
+)
+
+// layoutTransitions are the transitions of the HTML decks of each layout style
+var layoutTransitions = map[string]string{
+	LayoutStyleBalanced: "fade",
+	LayoutStyleBold:     "push",
+}
+
+var (
+	listItemPattern     = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+`)
+	boldLabelPattern    = regexp.MustCompile(`^\*\*[^*]+\*\*:?$`)
+	closingTitlePattern = regexp.MustCompile(`(?i)\b(questions|q\s*&\s*a|thank you|thanks|key takeaways|conclusions?)\b`)
+)
+
+// styledClasses are the classes layout styles assign, which replace the ones
+// the model chose
+var styledClasses = map[string]bool{"lead": true, "invert": true, "columns": true}
+
+// applyLayoutStyle assigns the lead, invert and columns classes of every
+// slide from its content, so the layout of a deck follows the layout style
+// rather than the model. Other classes, such as tinytext, are kept. Decks
+// without a layout style are left as the model wrote them.
+func applyLayoutStyle(markdown, theme, style string) string {
+	if style != LayoutStyleMinimal && style != LayoutStyleBalanced && style != LayoutStyleBold {
+		return markdown
+	}
+	supported := prompts.ThemeClasses(theme)
+	supported["columns"] = true
+
+	frontmatter, slides := splitSlides(markdown)
+	for i, slide := range slides {
+		var classes []string
+		switch {
+		case i == 0:
+			// Themes without a lead class style the title slide with their title class
+			if supported["lead"] || !supported["title"] {
+				classes = append(classes, "lead")
+			} else {
+				classes = append(classes, "title")
+			}
+			if style == LayoutStyleBold {
+				classes = append(classes, "invert")
+			}
+		case style == LayoutStyleMinimal:
+		case isDividerSlide(slide), i == len(slides)-1 && closingTitlePattern.MatchString(slideTitle(strings.Split(slide, "\n"))):
+			classes = append(classes, "lead")
+			if style == LayoutStyleBold {
+				classes = append(classes, "invert")
+			}
+		case isComparisonSlide(slide):
+			classes = append(classes, "columns")
+		}
+
+		kept := make([]string, 0, len(classes))
+		for _, class := range classes {
+			if supported[class] {
+				kept = append(kept, class)
+			}
+		}
+		slides[i] = setSlideClasses(slide, kept)
+	}
+
+	markdown = joinSlides(frontmatter, slides)
+	if transition := layoutTransitions[style]; transition != "" {
+		markdown = setDirectives(markdown, []directive{{"transition", transition}})
+	}
+	return markdown
+}
+
+// setSlideClasses replaces the styled classes of a slide with the given ones,
+// keeping its other classes
+func setSlideClasses(slide string, classes []string) string {
+	lines := strings.Split(slide, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		groups := classDirectivePattern.FindStringSubmatch(line)
+		if groups == nil || groups[1] != "_class" {
+			kept = append(kept, line)
+			continue
+		}
+		for _, class := range strings.Fields(groups[2]) {
+			if !styledClasses[class] && !slices.Contains(classes, class) {
+				classes = append(classes, class)
+			}
+		}
+	}
+	if len(classes) == 0 {
+		return strings.Join(kept, "\n")
+	}
+
+	// The directive goes at the top of the slide, where the prompts put it
+	start := 0
+	for start < len(kept) && strings.TrimSpace(kept[start]) == "" {
+		start++
+	}
+	directive := "<!-- _class: " + strings.Join(classes, " ") + " -->"
+	result := append([]string{}, kept[:start]...)
+	result = append(result, directive, "")
+	return strings.Join(append(result, kept[start:]...), "\n")
+}
+
+// isDividerSlide reports whether a slide only has a header and at most one
+// short line of text, such as the start of a section
+func isDividerSlide(slide string) bool {
+	headers, text := 0, 0
+	for _, line := range contentLines(slide) {
+		switch {
+		case slideTitlePattern.MatchString(line):
+			headers++
+		case len(line) <= 80 && !listItemPattern.MatchString(line) && !strings.HasPrefix(line, "|") && !strings.HasPrefix(line, "!["):
+			text++
+		default:
+			return false
+		}
+	}
+	return headers > 0 && text <= 1
+}
+
+// isComparisonSlide reports whether a slide is two lists under its header,
+// each optionally after a bold label, which the columns class sets side by side
+func isComparisonSlide(slide string) bool {
+	lists, inList := 0, false
+	for _, line := range contentLines(slide) {
+		item := listItemPattern.MatchString(line)
+		switch {
+		case item && !inList:
+			lists++
+		case item, slideTitlePattern.MatchString(line) && lists == 0, boldLabelPattern.MatchString(line):
+		case strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "\t"):
+			// Nested items continue the list
+			continue
+		default:
+			return false
+		}
+		inList = item
+	}
+	return lists == 2
+}
+
+// contentLines returns the non-empty lines of a slide other than directives,
+// comments and code, which the slide classifications don't look at
+func contentLines(slide string) []string {
+	var lines []string
+	inCode := false
+	for _, line := range strings.Split(slide, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			lines = append(lines, "```")
+			continue
+		}
+		if inCode || trimmed == "" || (strings.HasPrefix(trimmed, "<!--") && strings.HasSuffix(trimmed, "-->")) {
+			continue
+		}
+		if strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "\t") {
+			lines = append(lines, line)
+			continue
+		}
+		lines = append(lines, trimmed)
+	}
+	return lines
+}
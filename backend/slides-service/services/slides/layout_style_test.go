@@ -0,0 +1,58 @@
+package slides
+
+import (
+	"strings"
+	"testing"
+)
+
+const layoutStyleDeck = "---\nmarp: true\ntheme: gaia\n---\n\n# Quarterly Review\n\nQ3 2026\n\n---\n\n<!-- _class: invert tinytext -->\n\n## Revenue\n\n- Up 12%\n- Churn down\n\n---\n\n## Part 2: Outlook\n\n---\n\n## Build or buy\n\n**Build**\n- Control\n- Cost\n\n**Buy**\n- Speed\n  - Weeks, not months\n\n---\n\n## Questions?"
+
+func TestApplyLayoutStyle(t *testing.T) {
+	tests := []struct {
+		style    string
+		expected []string // Class directive of each slide, empty for none
+	}{
+		{LayoutStyleMinimal, []string{"lead", "tinytext", "", "", ""}},
+		{LayoutStyleBalanced, []string{"lead", "tinytext", "lead", "columns", "lead"}},
+		{LayoutStyleBold, []string{"lead invert", "tinytext", "lead invert", "columns", "lead invert"}},
+	}
+
+	for _, test := range tests {
+		_, slides := splitSlides(applyLayoutStyle(layoutStyleDeck, "gaia", test.style))
+		if len(slides) != len(test.expected) {
+			t.Fatalf("%s: expected %d slides, got %d", test.style, len(test.expected), len(slides))
+		}
+		for i, slide := range slides {
+			classes := ""
+			if groups := classDirectivePattern.FindStringSubmatch(slide); groups != nil {
+				classes = groups[2]
+			}
+			if classes != test.expected[i] {
+				t.Errorf("%s: expected slide %d to have classes %q, got %q", test.style, i+1, test.expected[i], classes)
+			}
+		}
+	}
+}
+
+func TestApplyLayoutStyleTransitions(t *testing.T) {
+	if got := applyLayoutStyle(layoutStyleDeck, "gaia", LayoutStyleBalanced); !strings.Contains(got, "transition: fade") {
+		t.Errorf("expected the balanced style to fade between slides, got:\n%s", got)
+	}
+	if got := applyLayoutStyle(layoutStyleDeck, "gaia", LayoutStyleMinimal); strings.Contains(got, "transition") {
+		t.Errorf("expected no transition for the minimal style, got:\n%s", got)
+	}
+	if got := applyLayoutStyle(layoutStyleDeck, "gaia", ""); got != layoutStyleDeck {
+		t.Errorf("expected decks without a layout style to be unchanged, got:\n%s", got)
+	}
+}
+
+func TestApplyLayoutStyleThemeClasses(t *testing.T) {
+	// Beam has no lead or invert class, its title slide uses the title class
+	_, slides := splitSlides(applyLayoutStyle(layoutStyleDeck, "beam", LayoutStyleBold))
+	if !strings.Contains(slides[0], "<!-- _class: title -->") {
+		t.Errorf("expected the title class on the title slide, got:\n%s", slides[0])
+	}
+	if strings.Contains(slides[2], "_class") {
+		t.Errorf("expected no classes on the divider, got:\n%s", slides[2])
+	}
+}
@@ -0,0 +1,48 @@
+package slides
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+func TestApplyLayoutsDropsUnknownClasses(t *testing.T) {
+	markdown := "---\nmarp: true\n---\n\n<!-- _class: lead sparkle -->\n\n# Title\n\n---\n\n<!-- _class: fancy -->\n\n## Slide"
+
+	got := applyLayouts(markdown, nil)
+	if !strings.Contains(got, "<!-- _class: lead -->") {
+		t.Fatalf("expected the known class to be kept, got:\n%s", got)
+	}
+	if strings.Contains(got, "sparkle") || strings.Contains(got, "fancy") {
+		t.Fatalf("expected unknown classes to be dropped, got:\n%s", got)
+	}
+	if strings.Contains(got, "<style>") {
+		t.Fatalf("expected no columns style without a columns slide, got:\n%s", got)
+	}
+}
+
+func TestApplyLayoutsAddsColumnsStyle(t *testing.T) {
+	markdown := "---\nmarp: true\n---\n\n## Pros and cons\n\n---\n\n<!-- _class: columns -->\n\n## Compare"
+
+	got := applyLayouts(markdown, nil)
+	if !strings.HasPrefix(got, "---\nmarp: true\n---\n\n<style>") {
+		t.Fatalf("expected the columns style after the frontmatter, got:\n%s", got)
+	}
+}
+
+func TestApplyLayoutsValidatesBackgroundImages(t *testing.T) {
+	files := []models.File{{Filename: "notes.md", Data: []byte("![chart](https://example.com/chart.png)")}}
+	markdown := "![bg left:95%](https://example.com/chart.png)\n\n- point\n\n---\n\n![bg right](https://example.com/invented.png)\n\n![inline](https://example.com/other.png)"
+
+	got := applyLayouts(markdown, files)
+	if !strings.Contains(got, "![bg left:70%](https://example.com/chart.png)") {
+		t.Fatalf("expected the split width to be clamped, got:\n%s", got)
+	}
+	if strings.Contains(got, "invented.png") {
+		t.Fatalf("expected a background not in the sources to be dropped, got:\n%s", got)
+	}
+	if !strings.Contains(got, "![inline](https://example.com/other.png)") {
+		t.Fatalf("expected inline images to be left alone, got:\n%s", got)
+	}
+}
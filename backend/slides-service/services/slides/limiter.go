@@ -0,0 +1,69 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// queueTimeout bounds how long a job waits for a free renderer or Gemini slot
+// before it is handed back to Cloud Tasks to retry later
+const queueTimeout = 2 * time.Minute
+
+// ErrBusy is returned when an instance is at its concurrency limit for longer
+// than queueTimeout
+var ErrBusy = errors.New("all workers are busy")
+
+// Limits caps the work an instance does at once, so a burst of tasks doesn't
+// run Chromium out of memory on a small instance, and the tokens of a job.
+// Zero means no limit for the concurrency and the default for the tokens.
+type Limits struct {
+	Renders      int // Simultaneous Marp renders
+	Generations  int // Simultaneous Gemini generation calls
+	InputTokens  int // Most tokens sent to Gemini for one presentation, unless the plan of the job overrides it
+	OutputTokens int // Most tokens Gemini writes for one presentation, unless the plan of the job overrides it
+}
+
+// limiter is a semaphore that queues callers while all its slots are taken.
+// A nil limiter doesn't limit anything.
+type limiter struct {
+	slots chan struct{}
+}
+
+// newLimiter creates a limiter with n slots, or nil for no limit
+func newLimiter(n int) *limiter {
+	if n <= 0 {
+		return nil
+	}
+	return &limiter{slots: make(chan struct{}, n)}
+}
+
+// acquire takes a slot, calling onWait first if the caller has to queue for
+// one. It returns a function that releases the slot, or ErrBusy if no slot
+// frees up within queueTimeout.
+func (l *limiter) acquire(ctx context.Context, onWait func() error) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	release := func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if err := onWait(); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,72 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterQueuesCallers(t *testing.T) {
+	l := newLimiter(1)
+	ctx := context.Background()
+	noWait := func() error {
+		t.Fatal("expected a free slot")
+		return nil
+	}
+
+	release, err := l.acquire(ctx, noWait)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	// A second caller queues until the first releases its slot
+	waited := make(chan struct{})
+	acquired := make(chan error, 1)
+	go func() {
+		release, err := l.acquire(ctx, func() error {
+			close(waited)
+			return nil
+		})
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	<-waited
+	release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("expected the queued caller to get the slot, got %v", err)
+	}
+
+	// The slot is free again
+	release, err = l.acquire(ctx, noWait)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	release()
+}
+
+func TestLimiterStopsWaitingWithContext(t *testing.T) {
+	l := newLimiter(1)
+	release, err := l.acquire(context.Background(), func() error { return nil })
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, func() error { return nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to stop the wait, got %v", err)
+	}
+}
+
+func TestNilLimiterDoesNotLimit(t *testing.T) {
+	l := newLimiter(0)
+	for i := 0; i < 3; i++ {
+		if _, err := l.acquire(context.Background(), nil); err != nil {
+			t.Fatalf("acquire failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package slides
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// inlineMathPattern matches $...$ expressions, not prices like $5 and $10
+	inlineMathPattern = regexp.MustCompile(`\$[^\s$](?:[^$\n]*[^\s$])?\$`)
+	blockMathPattern  = regexp.MustCompile(`(?s)\$\$.+?\$\$`)
+
+	// LaTeX delimiters that Marp doesn't understand
+	parenMathPattern   = regexp.MustCompile(`\\\((.+?)\\\)`)
+	bracketMathPattern = regexp.MustCompile(`(?s)\\\[(.+?)\\\]`)
+)
+
+// hasMath reports whether text contains LaTeX math
+func hasMath(text string) bool {
+	return blockMathPattern.MatchString(text) || inlineMathPattern.MatchString(text) ||
+		parenMathPattern.MatchString(text) || bracketMathPattern.MatchString(text)
+}
+
+// applyMath converts LaTeX math delimiters to the dollar forms Marp renders and
+// selects MathJax, which renders to SVG so formulas look the same in the PDF
+// and the HTML without loading fonts from a CDN
+func applyMath(markdown string) string {
+	if !hasMath(markdown) {
+		return markdown
+	}
+
+	markdown = bracketMathPattern.ReplaceAllStringFunc(markdown, func(match string) string {
+		return "\n$$\n" + strings.TrimSpace(bracketMathPattern.FindStringSubmatch(match)[1]) + "\n$$\n"
+	})
+	markdown = parenMathPattern.ReplaceAllStringFunc(markdown, func(match string) string {
+		return "$" + strings.TrimSpace(parenMathPattern.FindStringSubmatch(match)[1]) + "$"
+	})
+
+	// Marp only treats $$ as a block when it's on its own line
+	markdown = blockMathPattern.ReplaceAllStringFunc(markdown, func(match string) string {
+		body := strings.TrimSpace(match[2 : len(match)-2])
+		return "$$\n" + body + "\n$$"
+	})
+
+	return setDirectives(markdown, []directive{{"math", "mathjax"}})
+}
@@ -0,0 +1,30 @@
+package slides
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyMathConvertsDelimiters(t *testing.T) {
+	markdown := "---\nmarp: true\n---\n\n## Energy\n\n- Mass-energy: \\(E = mc^2\\)\n- Gaussian: \\[ \\int e^{-x^2} dx = \\sqrt{\\pi} \\]\n- Sum: $$\\sum_{i=1}^n i$$"
+
+	got := applyMath(markdown)
+	for _, want := range []string{
+		"math: mathjax",
+		"$E = mc^2$",
+		"$$\n\\int e^{-x^2} dx = \\sqrt{\\pi}\n$$",
+		"$$\n\\sum_{i=1}^n i\n$$",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestApplyMathIgnoresPrices(t *testing.T) {
+	markdown := "## Pricing\n\n- Basic costs $5 and Pro costs $10"
+
+	if got := applyMath(markdown); got != markdown {
+		t.Fatalf("expected prices to be left alone, got:\n%s", got)
+	}
+}
@@ -0,0 +1,89 @@
+package slides
+
+import (
+	"regexp"
+	"strings"
+)
+
+// defaultLanguage is the language tag used when the settings don't specify one
+const defaultLanguage = "en"
+
+var (
+	frontmatterPattern = regexp.MustCompile(`(?s)^\s*---\r?\n(.*?)\r?\n---`)
+	titleHeaderPattern = regexp.MustCompile(`(?m)^#\s+(.+?)\s*#*\s*$`)
+)
+
+// applyDocumentMetadata sets the title and language directives in the Marp
+// frontmatter so they end up in the PDF document properties and the HTML
+// lang attribute, which screen readers need to pick the right voice
+func applyDocumentMetadata(markdown, language string) string {
+	if language == "" {
+		language = defaultLanguage
+	}
+
+	directives := []directive{{"lang", language}}
+	if match := titleHeaderPattern.FindStringSubmatch(markdown); match != nil {
+		directives = append(directives, directive{"title", quoteDirective(stripInlineMarkdown(match[1]))})
+	}
+
+	return setDirectives(markdown, directives)
+}
+
+// directive is a Marp global directive set in the frontmatter
+type directive struct {
+	key   string
+	value string
+}
+
+// setDirectives sets directives in the Marp frontmatter, replacing any the
+// model already wrote and creating the frontmatter if there is none
+func setDirectives(markdown string, directives []directive) string {
+	replaced := make(map[string]bool, len(directives))
+	for _, d := range directives {
+		replaced[d.key] = true
+	}
+
+	loc := frontmatterPattern.FindStringSubmatchIndex(markdown)
+	if loc == nil {
+		// No frontmatter, prepend one
+		var frontmatter strings.Builder
+		frontmatter.WriteString("---\nmarp: true\n")
+		for _, d := range directives {
+			frontmatter.WriteString(d.key + ": " + d.value + "\n")
+		}
+		frontmatter.WriteString("---\n\n")
+		return frontmatter.String() + markdown
+	}
+
+	// Replace existing directives and keep the rest of the frontmatter as is
+	lines := strings.Split(markdown[loc[2]:loc[3]], "\n")
+	kept := make([]string, 0, len(lines)+len(directives))
+	skipping := false
+	for _, line := range lines {
+		// Indented lines continue the value of a multi-line directive
+		if skipping && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			continue
+		}
+		key := strings.TrimSpace(strings.SplitN(line, ":", 2)[0])
+		skipping = replaced[key]
+		if skipping {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	for _, d := range directives {
+		kept = append(kept, d.key+": "+d.value)
+	}
+
+	return markdown[:loc[2]] + strings.Join(kept, "\n") + markdown[loc[3]:]
+}
+
+// stripInlineMarkdown removes emphasis and code markers from a header
+func stripInlineMarkdown(text string) string {
+	return strings.TrimSpace(strings.NewReplacer("**", "", "__", "", "*", "", "`", "", "~~", "").Replace(text))
+}
+
+// quoteDirective quotes a directive value so YAML doesn't misread colons or quotes
+func quoteDirective(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
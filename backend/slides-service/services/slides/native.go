@@ -0,0 +1,626 @@
+package slides
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const (
+	// The slides are 16:9 like Marp's, in points
+	nativePageWidth  = 960.0
+	nativePageHeight = 540.0
+	nativeMargin     = 60.0
+
+	// nativeLineHeight is the line height relative to the font size
+	nativeLineHeight = 1.35
+
+	// nativeMinScale is the smallest scale the text of a slide is shrunk to
+	// so it fits the slide, the rest is cut off
+	nativeMinScale = 0.55
+
+	// nativeImageHeight is the tallest an inline image is drawn, unscaled
+	nativeImageHeight = 260.0
+)
+
+// nativeImageTypes are the image types the native renderer can embed
+var nativeImageTypes = map[string]string{
+	"image/png":  "PNG",
+	"image/jpeg": "JPG",
+	"image/gif":  "GIF",
+}
+
+// nativeMarkdown parses the slide bodies, with the tables and strikethrough of GitHub markdown
+var nativeMarkdown = goldmark.New(goldmark.WithExtensions(extension.Table, extension.Strikethrough))
+
+// rgb is a color of the native renderer
+type rgb struct {
+	r, g, b int
+}
+
+// mix returns the color a fraction of the way from c to other
+func (c rgb) mix(other rgb, fraction float64) rgb {
+	blend := func(a, b int) int { return a + int(float64(b-a)*fraction) }
+	return rgb{blend(c.r, other.r), blend(c.g, other.g), blend(c.b, other.b)}
+}
+
+// css returns the color as a CSS hex color
+func (c rgb) css() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.r, c.g, c.b)
+}
+
+// nativePalette are the colors of a theme in the native renderer
+type nativePalette struct {
+	background rgb
+	text       rgb
+	heading    rgb
+	accent     rgb
+}
+
+// inverted returns the palette of the invert class
+func (p nativePalette) inverted() nativePalette {
+	return nativePalette{background: p.text, text: p.background, heading: p.background, accent: p.accent}
+}
+
+// nativePalettes approximate the themes, themes not listed use the default palette
+var nativePalettes = map[string]nativePalette{
+	"default":     {rgb{255, 255, 255}, rgb{36, 41, 47}, rgb{36, 41, 47}, rgb{9, 105, 218}},
+	"gaia":        {rgb{255, 248, 225}, rgb{69, 90, 100}, rgb{69, 90, 100}, rgb{2, 136, 209}},
+	"uncover":     {rgb{253, 252, 255}, rgb{32, 34, 40}, rgb{32, 34, 40}, rgb{0, 157, 213}},
+	"beam":        {rgb{255, 255, 255}, rgb{20, 20, 20}, rgb{31, 56, 197}, rgb{31, 56, 197}},
+	"rose_pine":   {rgb{25, 23, 36}, rgb{224, 222, 244}, rgb{235, 188, 186}, rgb{196, 167, 231}},
+	"graph_paper": {rgb{227, 227, 241}, rgb{18, 17, 20}, rgb{4, 0, 20}, rgb{63, 50, 175}},
+}
+
+// themePalette returns the palette of a theme
+func themePalette(theme string) nativePalette {
+	if palette, ok := nativePalettes[theme]; ok {
+		return palette
+	}
+	return nativePalettes["default"]
+}
+
+// nativeImage is an image of a deck the native renderer can embed
+type nativeImage struct {
+	data      []byte
+	imageType string
+}
+
+// NativeRenderer renders decks in Go, for containers without Node and
+// Chromium. It supports a subset of Marp: headings, paragraphs with
+// emphasis, code and links, lists, code blocks, quotes, tables, images,
+// split and full backgrounds, and the lead and invert classes. Theme
+// stylesheets and fonts don't apply, each theme maps to a palette and the
+// text is set in the Go fonts.
+type NativeRenderer struct {
+	fetch assetFetcher
+}
+
+// NewNativeRenderer creates a new native renderer
+func NewNativeRenderer() *NativeRenderer {
+	return &NativeRenderer{fetch: fetchAsset}
+}
+
+// Render lays out the slides as PDF pages and as an HTML page. Decks rendered
+// natively have no thumbnail.
+func (r *NativeRenderer) Render(ctx context.Context, markdown string, options RenderOptions) (*RenderOutput, error) {
+	deck := parseMarpDeck(markdown)
+	palette := themePalette(options.Theme)
+	images := r.loadImages(ctx, deck)
+
+	pdfBytes, err := renderNativePDF(deck, palette, images, options)
+	if err != nil {
+		log.Printf("Failed to render PDF natively: %v", err)
+		return nil, fmt.Errorf("failed to generate PDF: %v", err)
+	}
+
+	log.Printf("Successfully generated PDF natively (%d bytes)", len(pdfBytes))
+	if options.PDFOnly {
+		return &RenderOutput{PDFData: pdfBytes}, nil
+	}
+
+	htmlBytes, err := renderNativeHTML(deck, palette, options)
+	if err != nil {
+		log.Printf("Failed to render HTML natively: %v", err)
+		return nil, fmt.Errorf("failed to generate HTML: %v", err)
+	}
+
+	log.Printf("Successfully generated HTML natively (%d bytes)", len(htmlBytes))
+	return &RenderOutput{PDFData: pdfBytes, HTMLData: htmlBytes}, nil
+}
+
+// loadImages downloads the images of a deck once each, keeping the ones the
+// PDF can embed. Images that fail to load are left out of the slides.
+func (r *NativeRenderer) loadImages(ctx context.Context, deck marpDeck) map[string]nativeImage {
+	images := make(map[string]nativeImage)
+	load := func(url string) {
+		if _, ok := images[url]; ok || !strings.HasPrefix(url, "http") {
+			return
+		}
+		data, contentType, err := r.fetch(ctx, url)
+		if err != nil {
+			log.Printf("Failed to load image %s: %v", url, err)
+			images[url] = nativeImage{}
+			return
+		}
+		imageType, ok := nativeImageTypes[contentType]
+		if !ok || !embeddable(data, imageType) {
+			log.Printf("Image %s can't be embedded natively", url)
+			images[url] = nativeImage{}
+			return
+		}
+		images[url] = nativeImage{data: data, imageType: imageType}
+	}
+
+	for _, slide := range deck.Slides {
+		if slide.Background != "" {
+			load(slide.Background)
+		}
+		source := []byte(slide.Body)
+		ast.Walk(nativeMarkdown.Parser().Parse(text.NewReader(source)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if image, ok := n.(*ast.Image); ok && entering {
+				load(string(image.Destination))
+			}
+			return ast.WalkContinue, nil
+		})
+	}
+	return images
+}
+
+// embeddable reports whether the PDF library can read an image, it rejects
+// some valid images such as interlaced PNGs
+func embeddable(data []byte, imageType string) bool {
+	probe := fpdf.New("P", "pt", "A4", "")
+	probe.RegisterImageOptionsReader("probe", fpdf.ImageOptions{ImageType: imageType}, bytes.NewReader(data))
+	return !probe.Err()
+}
+
+// newNativePDF creates a PDF with the Go fonts and the images of a deck
+func newNativePDF(images map[string]nativeImage) *fpdf.Fpdf {
+	pdf := fpdf.NewCustom(&fpdf.InitType{UnitStr: "pt", Size: fpdf.SizeType{Wd: nativePageWidth, Ht: nativePageHeight}})
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.SetMargins(nativeMargin, nativeMargin, nativeMargin)
+	pdf.SetCellMargin(0)
+	pdf.AddUTF8FontFromBytes("go", "", goregular.TTF)
+	pdf.AddUTF8FontFromBytes("go", "B", gobold.TTF)
+	pdf.AddUTF8FontFromBytes("go", "I", goitalic.TTF)
+	pdf.AddUTF8FontFromBytes("go", "BI", gobolditalic.TTF)
+	pdf.AddUTF8FontFromBytes("gomono", "", gomono.TTF)
+	pdf.AddUTF8FontFromBytes("gomono", "B", gomonobold.TTF)
+	for url, image := range images {
+		if image.data != nil {
+			pdf.RegisterImageOptionsReader(url, fpdf.ImageOptions{ImageType: image.imageType}, bytes.NewReader(image.data))
+		}
+	}
+	return pdf
+}
+
+// renderNativePDF lays out each slide on a page. The text of a slide that
+// doesn't fit is shrunk, measured on a scratch document first, and lead
+// slides are centered vertically.
+func renderNativePDF(deck marpDeck, palette nativePalette, images map[string]nativeImage, options RenderOptions) ([]byte, error) {
+	pdf := newNativePDF(images)
+	scratch := newNativePDF(images)
+	if deck.Title != "" {
+		pdf.SetTitle(deck.Title, true)
+	}
+	if deck.Language != "" {
+		pdf.SetLang(deck.Language)
+	}
+	pdf.SetCreator("slideitin", true)
+
+	for _, slide := range deck.Slides {
+		source := []byte(slide.Body)
+		doc := nativeMarkdown.Parser().Parse(text.NewReader(source))
+		slidePalette := palette
+		if slide.hasClass("invert") {
+			slidePalette = palette.inverted()
+		}
+
+		layout := &nativeLayout{
+			source:  source,
+			palette: slidePalette,
+			images:  images,
+			center:  slide.hasClass("lead"),
+			left:    nativeMargin,
+			right:   nativePageWidth - nativeMargin,
+			scale:   1,
+		}
+		if images[slide.Background].data != nil {
+			switch slide.BackgroundSide {
+			case "left":
+				layout.left = nativePageWidth/2 + nativeMargin/2
+			case "right":
+				layout.right = nativePageWidth/2 - nativeMargin/2
+			}
+		}
+
+		// Measure the slide, shrinking its text until it fits
+		var height float64
+		for {
+			layout.pdf = scratch
+			scratch.AddPage()
+			height = layout.blocks(doc, nativeMargin) - nativeMargin
+			if height <= nativePageHeight-2*nativeMargin || layout.scale <= nativeMinScale {
+				break
+			}
+			layout.scale = max(layout.scale*0.9, nativeMinScale)
+		}
+
+		layout.pdf = pdf
+		pdf.AddPage()
+		drawSlideBackground(pdf, slide, slidePalette, images)
+		top := nativeMargin
+		if layout.center {
+			top = max((nativePageHeight-height)/2, nativeMargin)
+		}
+		// The outline lists the slide titles, like the Marp PDFs
+		if heading, level, _ := slideHeading(slide.Body); level > 0 {
+			pdf.Bookmark(stripInlineMarkdown(heading), 0, 0)
+		}
+		layout.blocks(doc, top)
+		drawSlideStamps(pdf, slidePalette, options)
+	}
+	if err := scratch.Error(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// drawSlideBackground fills the slide with the background color and draws its background image
+func drawSlideBackground(pdf *fpdf.Fpdf, slide marpSlide, palette nativePalette, images map[string]nativeImage) {
+	pdf.SetFillColor(palette.background.r, palette.background.g, palette.background.b)
+	pdf.Rect(0, 0, nativePageWidth, nativePageHeight, "F")
+
+	if images[slide.Background].data == nil {
+		return
+	}
+	x, width := 0.0, nativePageWidth
+	switch slide.BackgroundSide {
+	case "left":
+		width = nativePageWidth / 2
+	case "right":
+		x, width = nativePageWidth/2, nativePageWidth/2
+	}
+	info := pdf.GetImageInfo(slide.Background)
+	if info == nil || info.Width() == 0 || info.Height() == 0 {
+		return
+	}
+
+	// Cover the area like background-size: cover, clipped to it
+	scale := max(width/info.Width(), nativePageHeight/info.Height())
+	w, h := info.Width()*scale, info.Height()*scale
+	pdf.ClipRect(x, 0, width, nativePageHeight, false)
+	pdf.ImageOptions(slide.Background, x+(width-w)/2, (nativePageHeight-h)/2, w, h, false, fpdf.ImageOptions{}, 0, "")
+	pdf.ClipEnd()
+}
+
+// drawSlideStamps draws the footer and the watermark over the slide
+func drawSlideStamps(pdf *fpdf.Fpdf, palette nativePalette, options RenderOptions) {
+	if options.Footer != "" {
+		footer := palette.text.mix(palette.background, 0.4)
+		pdf.SetFont("go", "", 14)
+		pdf.SetTextColor(footer.r, footer.g, footer.b)
+		pdf.Text(nativeMargin/2, nativePageHeight-nativeMargin/3, options.Footer)
+	}
+	if options.Watermark != "" {
+		pdf.SetFont("go", "B", 72)
+		pdf.SetTextColor(128, 128, 128)
+		pdf.SetAlpha(0.2, "Normal")
+		pdf.TransformBegin()
+		pdf.TransformRotate(30, nativePageWidth/2, nativePageHeight/2)
+		width := pdf.GetStringWidth(options.Watermark)
+		pdf.Text((nativePageWidth-width)/2, nativePageHeight/2+24, options.Watermark)
+		pdf.TransformEnd()
+		pdf.SetAlpha(1, "Normal")
+	}
+}
+
+// nativeRun is a piece of text with one style
+type nativeRun struct {
+	text   string
+	bold   bool
+	italic bool
+	code   bool
+	link   string
+}
+
+// nativeLayout lays out the markdown blocks of a slide from top to bottom
+type nativeLayout struct {
+	pdf     *fpdf.Fpdf
+	source  []byte
+	palette nativePalette
+	images  map[string]nativeImage
+	center  bool    // Centers the blocks, for lead slides
+	left    float64 // Left edge of the text
+	right   float64 // Right edge of the text
+	scale   float64 // Font scale that fits the slide
+}
+
+// blocks lays out the blocks of a node from y, and returns the y below them
+func (l *nativeLayout) blocks(parent ast.Node, y float64) float64 {
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		y = l.block(n, y, 0)
+	}
+	return y
+}
+
+// block lays out a block at a list depth, and returns the y below it
+func (l *nativeLayout) block(n ast.Node, y float64, depth int) float64 {
+	size := 24 * l.scale
+	switch node := n.(type) {
+	case *ast.Heading:
+		sizes := []float64{44, 36, 30, 26, 24, 24}
+		size = sizes[node.Level-1] * l.scale
+		y = l.text(node, y, size, l.palette.heading, true, 0)
+		return y + size*0.4
+
+	case *ast.Paragraph, *ast.TextBlock:
+		if image := onlyImage(node); image != nil {
+			return l.image(string(image.Destination), y) + size*0.4
+		}
+		y = l.text(node, y, size, l.palette.text, false, 0)
+		return y + size*0.4
+
+	case *ast.List:
+		number := node.Start
+		for item := node.FirstChild(); item != nil; item = item.NextSibling() {
+			marker := "•"
+			if node.IsOrdered() {
+				marker = strconv.Itoa(number) + "."
+				number++
+			}
+			indent := float64(depth+1) * 32 * l.scale
+			l.pdf.SetFont("go", "", size)
+			l.pdf.SetTextColor(l.palette.accent.r, l.palette.accent.g, l.palette.accent.b)
+			l.pdf.Text(l.left+indent-24*l.scale, y+size, marker)
+			for child := item.FirstChild(); child != nil; child = child.NextSibling() {
+				switch child.(type) {
+				case *ast.Paragraph, *ast.TextBlock:
+					y = l.text(child, y, size, l.palette.text, false, indent)
+				default:
+					y = l.block(child, y, depth+1)
+				}
+			}
+		}
+		return y + size*0.4
+
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		codeSize := 18 * l.scale
+		lineHeight := codeSize * nativeLineHeight
+		lines := n.Lines()
+		padding := 12 * l.scale
+		height := float64(lines.Len())*lineHeight + 2*padding
+		background := l.palette.background.mix(l.palette.text, 0.08)
+		l.pdf.SetFillColor(background.r, background.g, background.b)
+		l.pdf.Rect(l.left, y, l.right-l.left, height, "F")
+		l.pdf.SetFont("gomono", "", codeSize)
+		l.pdf.SetTextColor(l.palette.text.r, l.palette.text.g, l.palette.text.b)
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			line := strings.TrimRight(string(segment.Value(l.source)), "\r\n")
+			l.pdf.Text(l.left+padding, y+padding+float64(i)*lineHeight+codeSize, strings.ReplaceAll(line, "\t", "    "))
+		}
+		return y + height + size*0.4
+
+	case *ast.Blockquote:
+		top := y
+		indent := 24 * l.scale
+		l.left += indent
+		for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+			y = l.block(child, y, depth)
+		}
+		l.left -= indent
+		l.pdf.SetFillColor(l.palette.accent.r, l.palette.accent.g, l.palette.accent.b)
+		l.pdf.Rect(l.left, top, 4*l.scale, y-top-size*0.4, "F")
+		return y
+
+	case *extast.Table:
+		return l.table(node, y, 20*l.scale) + size*0.4
+
+	case *ast.ThematicBreak:
+		l.pdf.SetDrawColor(l.palette.text.r, l.palette.text.g, l.palette.text.b)
+		l.pdf.Line(l.left, y+size/2, l.right, y+size/2)
+		return y + size
+	}
+
+	// Raw HTML isn't rendered
+	return y
+}
+
+// text writes the inline content of a node, wrapping at the right edge
+func (l *nativeLayout) text(n ast.Node, y, size float64, color rgb, heading bool, indent float64) float64 {
+	runs := inlineRuns(n, l.source, nativeRun{bold: heading})
+	if len(runs) == 0 {
+		return y
+	}
+	lineHeight := size * nativeLineHeight
+	l.pdf.SetLeftMargin(l.left + indent)
+	l.pdf.SetRightMargin(nativePageWidth - l.right)
+	l.pdf.SetXY(l.left+indent, y)
+
+	if l.center {
+		var plain strings.Builder
+		for _, run := range runs {
+			plain.WriteString(run.text)
+		}
+		l.pdf.SetFont("go", fontStyle(runs[0]), size)
+		l.pdf.SetTextColor(color.r, color.g, color.b)
+		l.pdf.MultiCell(l.right-l.left-indent, lineHeight, plain.String(), "", "C", false)
+	} else {
+		for _, run := range runs {
+			family, runColor := "go", color
+			if run.code {
+				family = "gomono"
+			}
+			if run.link != "" {
+				runColor = l.palette.accent
+			}
+			l.pdf.SetFont(family, fontStyle(run), size)
+			l.pdf.SetTextColor(runColor.r, runColor.g, runColor.b)
+			if run.link != "" {
+				l.pdf.WriteLinkString(lineHeight, run.text, run.link)
+			} else {
+				l.pdf.Write(lineHeight, run.text)
+			}
+		}
+		l.pdf.Ln(lineHeight)
+	}
+
+	l.pdf.SetMargins(nativeMargin, nativeMargin, nativeMargin)
+	return l.pdf.GetY()
+}
+
+// image draws an inline image, at most the width of the text and
+// nativeImageHeight high, and returns the y below it
+func (l *nativeLayout) image(url string, y float64) float64 {
+	if l.images[url].data == nil {
+		return y
+	}
+	info := l.pdf.GetImageInfo(url)
+	if info == nil || info.Width() == 0 || info.Height() == 0 {
+		return y
+	}
+	scale := min((l.right-l.left)/info.Width(), nativeImageHeight*l.scale/info.Height())
+	w, h := info.Width()*scale, info.Height()*scale
+	x := l.left
+	if l.center {
+		x = l.left + (l.right-l.left-w)/2
+	}
+	l.pdf.ImageOptions(url, x, y, w, h, false, fpdf.ImageOptions{}, 0, "")
+	return y + h
+}
+
+// table draws a table with equal columns, cutting off cells that are too long
+func (l *nativeLayout) table(table *extast.Table, y, size float64) float64 {
+	var rows [][]string
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			var plain strings.Builder
+			for _, run := range inlineRuns(cell, l.source, nativeRun{}) {
+				plain.WriteString(run.text)
+			}
+			cells = append(cells, plain.String())
+		}
+		rows = append(rows, cells)
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return y
+	}
+
+	width := (l.right - l.left) / float64(len(rows[0]))
+	rowHeight := size * 1.8
+	padding := 8 * l.scale
+	line := l.palette.text.mix(l.palette.background, 0.6)
+	l.pdf.SetDrawColor(line.r, line.g, line.b)
+	l.pdf.SetTextColor(l.palette.text.r, l.palette.text.g, l.palette.text.b)
+	for i, cells := range rows {
+		style := ""
+		if i == 0 {
+			style = "B"
+		}
+		l.pdf.SetFont("go", style, size)
+		for j, cell := range cells {
+			if lines := l.pdf.SplitText(cell, width-2*padding); len(lines) > 0 {
+				cell = lines[0]
+			}
+			l.pdf.SetXY(l.left+float64(j)*width+padding, y)
+			l.pdf.CellFormat(width-2*padding, rowHeight, cell, "", 0, "L", false, 0, "")
+		}
+		y += rowHeight
+		l.pdf.Line(l.left, y, l.right, y)
+	}
+	return y
+}
+
+// onlyImage returns the image of a paragraph that holds nothing else
+func onlyImage(n ast.Node) *ast.Image {
+	image, ok := n.FirstChild().(*ast.Image)
+	if !ok || image.NextSibling() != nil {
+		return nil
+	}
+	return image
+}
+
+// inlineRuns flattens the inline content of a node into styled runs
+func inlineRuns(n ast.Node, source []byte, style nativeRun) []nativeRun {
+	var runs []nativeRun
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		switch node := child.(type) {
+		case *ast.Text:
+			run := style
+			segment := node.Segment
+			run.text = string(segment.Value(source))
+			if node.HardLineBreak() {
+				run.text += "\n"
+			} else if node.SoftLineBreak() {
+				run.text += " "
+			}
+			runs = append(runs, run)
+		case *ast.String:
+			run := style
+			run.text = string(node.Value)
+			runs = append(runs, run)
+		case *ast.CodeSpan:
+			run := style
+			run.code = true
+			runs = append(runs, inlineRuns(node, source, run)...)
+		case *ast.Emphasis:
+			run := style
+			if node.Level >= 2 {
+				run.bold = true
+			} else {
+				run.italic = true
+			}
+			runs = append(runs, inlineRuns(node, source, run)...)
+		case *ast.Link:
+			run := style
+			run.link = string(node.Destination)
+			runs = append(runs, inlineRuns(node, source, run)...)
+		case *ast.AutoLink:
+			run := style
+			run.link = string(node.URL(source))
+			run.text = string(node.Label(source))
+			runs = append(runs, run)
+		case *ast.RawHTML, *ast.Image:
+			// Inline HTML and images inside text aren't rendered
+		default:
+			runs = append(runs, inlineRuns(node, source, style)...)
+		}
+	}
+	return runs
+}
+
+// fontStyle returns the font style of a run
+func fontStyle(run nativeRun) string {
+	style := ""
+	if run.bold {
+		style += "B"
+	}
+	if run.italic && !run.code {
+		style += "I"
+	}
+	return style
+}
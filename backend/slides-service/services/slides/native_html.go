@@ -0,0 +1,109 @@
+package slides
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// nativeStyle lays out the slides of a native HTML deck as 16:9 pages that
+// snap into view, colored with the palette of the theme
+const nativeStyle = `* { box-sizing: border-box; }
+html { scroll-snap-type: y mandatory; background: #000; }
+body { margin: 0; font-family: "Go", ui-sans-serif, system-ui, sans-serif; }
+section {
+  position: relative; overflow: hidden; scroll-snap-align: center;
+  width: min(100vw, 177.78vh); height: min(56.25vw, 100vh); margin: 0 auto;
+  padding: 6.25%%; font-size: min(2.5vw, 4.44vh); line-height: 1.35;
+  display: flex; flex-direction: column; justify-content: flex-start;
+  background: %[1]s; color: %[2]s;
+}
+section.lead { justify-content: center; text-align: center; }
+section.invert { background: %[2]s; color: %[1]s; }
+section h1, section h2, section h3, section h4 { color: %[3]s; margin: 0 0 0.4em; }
+section.invert h1, section.invert h2, section.invert h3, section.invert h4 { color: %[1]s; }
+section a, section li::marker { color: %[4]s; }
+section img { max-width: 100%%; max-height: 48%%; object-fit: contain; }
+section pre { background: color-mix(in srgb, currentColor 8%%, transparent); padding: 0.5em; font-size: 0.75em; }
+section blockquote { border-left: 0.2em solid %[4]s; margin: 0; padding-left: 1em; }
+section table { border-collapse: collapse; font-size: 0.8em; }
+section th, section td { border-bottom: 1px solid color-mix(in srgb, currentColor 40%%, transparent); padding: 0.3em 0.6em; }
+section .background { position: absolute; top: 0; bottom: 0; left: 0; right: 0; max-height: none; width: 100%%; height: 100%%; object-fit: cover; }
+section.split-left { padding-left: 53.125%%; }
+section.split-left .background { right: 50%%; width: 50%%; }
+section.split-right { padding-right: 53.125%%; }
+section.split-right .background { left: 50%%; width: 50%%; }
+section .content { position: relative; }
+section .notes { display: none; }
+section .footer { position: absolute; left: 3%%; bottom: 2%%; font-size: 0.6em; opacity: 0.6; }
+section .watermark {
+  position: absolute; top: 50%%; left: 50%%; transform: translate(-50%%, -50%%) rotate(-30deg);
+  font-size: 3em; font-weight: bold; white-space: nowrap; color: rgba(128, 128, 128, 0.2); pointer-events: none;
+}`
+
+// nativeScript keeps the location hash on the slide in view, like the Marp
+// HTML decks, so links and the share link analytics see the current slide
+const nativeScript = `(function () {
+  var slides = document.querySelectorAll("section");
+  if (!("IntersectionObserver" in window)) return;
+  var observer = new IntersectionObserver(function (entries) {
+    entries.forEach(function (entry) {
+      if (entry.isIntersecting) history.replaceState(null, "", "#" + entry.target.id);
+    });
+  }, {threshold: 0.6});
+  slides.forEach(function (slide) { observer.observe(slide); });
+  document.addEventListener("keydown", function (event) {
+    var current = parseInt(location.hash.slice(1), 10) || 1;
+    var next = event.key === "ArrowRight" || event.key === "PageDown" ? current + 1 : event.key === "ArrowLeft" || event.key === "PageUp" ? current - 1 : 0;
+    if (next >= 1 && next <= slides.length) { event.preventDefault(); slides[next - 1].scrollIntoView(); }
+  });
+})();`
+
+// renderNativeHTML writes the slides of a deck as sections of a single page.
+// The markdown is rendered without raw HTML.
+func renderNativeHTML(deck marpDeck, palette nativePalette, options RenderOptions) ([]byte, error) {
+	language := deck.Language
+	if language == "" {
+		language = defaultLanguage
+	}
+
+	var page strings.Builder
+	page.WriteString("<!DOCTYPE html>\n<html lang=\"" + html.EscapeString(language) + "\">\n<head>\n<meta charset=\"utf-8\">\n")
+	page.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	page.WriteString("<title>" + html.EscapeString(deck.Title) + "</title>\n")
+	page.WriteString("<style>\n" + fmt.Sprintf(nativeStyle, palette.background.css(), palette.text.css(), palette.heading.css(), palette.accent.css()) + "\n</style>\n")
+	page.WriteString("</head>\n<body>\n")
+
+	for i, slide := range deck.Slides {
+		classes := append([]string{}, slide.Classes...)
+		if slide.Background != "" && slide.BackgroundSide != "" {
+			classes = append(classes, "split-"+slide.BackgroundSide)
+		}
+		page.WriteString("<section id=\"" + strconv.Itoa(i+1) + "\" class=\"" + html.EscapeString(strings.Join(classes, " ")) + "\">\n")
+		if slide.Background != "" {
+			page.WriteString("<img class=\"background\" alt=\"\" src=\"" + html.EscapeString(slide.Background) + "\">\n")
+		}
+
+		var body bytes.Buffer
+		if err := nativeMarkdown.Convert([]byte(slide.Body), &body); err != nil {
+			return nil, err
+		}
+		page.WriteString("<div class=\"content\">\n" + body.String() + "</div>\n")
+
+		if len(slide.Notes) > 0 {
+			page.WriteString("<aside class=\"notes\">" + html.EscapeString(strings.Join(slide.Notes, "\n\n")) + "</aside>\n")
+		}
+		if options.Footer != "" {
+			page.WriteString("<div class=\"footer\">" + html.EscapeString(options.Footer) + "</div>\n")
+		}
+		if options.Watermark != "" {
+			page.WriteString("<div class=\"watermark\" aria-hidden=\"true\">" + html.EscapeString(options.Watermark) + "</div>\n")
+		}
+		page.WriteString("</section>\n")
+	}
+
+	page.WriteString("<script>\n" + nativeScript + "\n</script>\n</body>\n</html>\n")
+	return []byte(page.String()), nil
+}
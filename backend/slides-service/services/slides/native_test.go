@@ -0,0 +1,71 @@
+package slides
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark/text"
+)
+
+func TestNativeRendererRendersDeck(t *testing.T) {
+	var chart bytes.Buffer
+	if err := png.Encode(&chart, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	var fetched []string
+	renderer := &NativeRenderer{fetch: func(ctx context.Context, url string) ([]byte, string, error) {
+		fetched = append(fetched, url)
+		if strings.HasSuffix(url, ".svg") {
+			return []byte("<svg/>"), "image/svg+xml", nil
+		}
+		return chart.Bytes(), "image/png", nil
+	}}
+
+	markdown := "---\nmarp: true\ntitle: \"Launch\"\nlang: fr\n---\n\n<!-- _class: lead -->\n\n# Launch\n\n---\n\n## Roadmap\n\n![bg left](https://example.com/chart.png)\n\n- Q1 <b>beta</b>\n\n![Chart](https://example.com/chart.png)\n\n![Logo](https://example.com/logo.svg)\n\n---\n\n<!-- _class: invert -->\n\n" +
+		"## Long\n\n" + strings.Repeat("- a point that takes most of a line on the slide\n", 20)
+	output, err := renderer.Render(context.Background(), markdown, RenderOptions{Theme: "gaia", Footer: "R&D <internal>", Watermark: "Draft"})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	if !bytes.HasPrefix(output.PDFData, []byte("%PDF-")) || bytes.Count(output.PDFData, []byte("/Type /Page\n")) != 3 {
+		t.Errorf("expected a PDF with 3 pages, got %d bytes", len(output.PDFData))
+	}
+	if !reflect.DeepEqual(fetched, []string{"https://example.com/chart.png", "https://example.com/logo.svg"}) {
+		t.Errorf("expected each image to be fetched once, got %v", fetched)
+	}
+
+	page := string(output.HTMLData)
+	for _, want := range []string{`<html lang="fr">`, `<title>Launch</title>`, `<section id="2" class="split-left">`, `<section id="3" class="invert">`, `R&amp;D &lt;internal&gt;`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected the HTML to contain %s", want)
+		}
+	}
+	if strings.Contains(page, "<b>beta</b>") {
+		t.Error("expected raw HTML to be left out of the HTML")
+	}
+}
+
+func TestInlineRuns(t *testing.T) {
+	source := []byte("Plain *italic* **bold `code`** [link](https://example.com)")
+	doc := nativeMarkdown.Parser().Parse(text.NewReader(source))
+
+	got := inlineRuns(doc.FirstChild(), source, nativeRun{})
+	want := []nativeRun{
+		{text: "Plain "},
+		{text: "italic", italic: true},
+		{text: " "},
+		{text: "bold ", bold: true},
+		{text: "code", bold: true, code: true},
+		{text: " "},
+		{text: "link", link: "https://example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,91 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+// onePagerWords bounds the length of the executive summary so it fits on one page
+const onePagerWords = 400
+
+// onePagerTheme lays out the executive summary as a single portrait A4 page
+// (794x1123 pixels at 96 DPI) instead of a slide
+const onePagerTheme = `/* @theme one_pager */
+
+@import "default";
+
+section {
+  width: 794px;
+  height: 1123px;
+  padding: 64px 72px;
+  font-size: 15px;
+  line-height: 1.45;
+  justify-content: flex-start;
+}
+
+h1 {
+  font-size: 28px;
+  margin-bottom: 8px;
+}
+
+h2 {
+  font-size: 18px;
+  margin-top: 18px;
+  margin-bottom: 4px;
+}
+
+ul, ol {
+  margin-top: 4px;
+}
+`
+
+// ruleLinePattern matches a horizontal rule, which Marp would turn into a page break
+var ruleLinePattern = regexp.MustCompile(`(?m)^[ \t]*(?:-{3,}|\*{3,}|_{3,})[ \t]*$`)
+
+// generateOnePager writes a one-page executive summary of the documents the
+// slides were generated from, as markdown
+func (s *SlideService) generateOnePager(ctx context.Context, documents []genai.Part, settings models.SlideSettings) (string, error) {
+	prompt, err := prompts.GenerateOnePagerPrompt(settings, onePagerWords)
+	if err != nil {
+		return "", err
+	}
+	parts := append(documents[:len(documents):len(documents)], genai.Text(prompt))
+
+	resp, err := generateContent(ctx, s.model, ExchangeOnePager, parts...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", errors.New("empty response")
+	}
+	text, _ := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	summary := strings.TrimSpace(ruleLinePattern.ReplaceAllString(extractMarkdownContent(string(text)), ""))
+	if summary == "" {
+		return "", errors.New("empty summary")
+	}
+	return summary, nil
+}
+
+// RenderOnePager renders the markdown of an executive summary as a one-page PDF
+func (s *SlideService) RenderOnePager(ctx context.Context, markdown string) ([]byte, error) {
+	release, err := s.renders.acquire(ctx, func() error { return nil })
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	renderCtx, cancelRender := context.WithTimeout(ctx, renderTimeout)
+	defer cancelRender()
+	document := "---\nmarp: true\ntheme: one_pager\n---\n\n" + ruleLinePattern.ReplaceAllString(markdown, "")
+	output, err := s.renderer.Render(renderCtx, document, RenderOptions{Theme: "one_pager", ThemeCSS: onePagerTheme, PDFOnly: true})
+	if err != nil {
+		return nil, timeoutError(renderCtx, err)
+	}
+	return output.PDFData, nil
+}
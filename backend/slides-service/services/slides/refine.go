@@ -0,0 +1,128 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+// RefineSlides revises the markdown of a deck according to an instruction,
+// with the previous revision as context, and renders the new revision
+func (s *SlideService) RefineSlides(
+	ctx context.Context,
+	theme string,
+	markdown string,
+	instruction string,
+	settings models.SlideSettings,
+	statusUpdateFn func(stage Stage, status Status) error,
+) (*Presentation, error) {
+	if err := statusUpdateFn(StageProcessing, NewStatus(StatusApplyingChanges)); err != nil {
+		return nil, err
+	}
+
+	prompt, err := prompts.GenerateRefinePrompt(settings, markdown, instruction)
+	if err != nil {
+		return nil, err
+	}
+	revised, err := s.revise(ctx, prompt, s.jobTokenLimits(settings), true, statusUpdateFn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Images may only come from the previous revision, which took them from the sources
+	previous := []models.File{{Filename: "previous revision", Data: []byte(markdown), Type: "text/plain"}}
+	return s.renderPresentation(ctx, theme, revised, previous, settings, statusUpdateFn)
+}
+
+// directivesPattern matches text made only of frontmatter directives such as marp: true
+var directivesPattern = regexp.MustCompile(`^(?:[A-Za-z][\w-]*:.*(?:\n|$))+$`)
+
+// RegenerateSlide rewrites one slide of a deck according to the reason the
+// author rejected it, keeping every other slide as it is, and renders the deck
+func (s *SlideService) RegenerateSlide(
+	ctx context.Context,
+	theme string,
+	markdown string,
+	slide int,
+	reason string,
+	settings models.SlideSettings,
+	statusUpdateFn func(stage Stage, status Status) error,
+) (*Presentation, error) {
+	frontmatter, slides := splitSlides(markdown)
+	if slide < 1 || slide > len(slides) {
+		return nil, fmt.Errorf("slide %d not found, the deck has %d slides", slide, len(slides))
+	}
+	if err := statusUpdateFn(StageProcessing, NewStatus(StatusRegeneratingSlide, "slide", strconv.Itoa(slide))); err != nil {
+		return nil, err
+	}
+
+	prompt, err := prompts.GenerateSlideFeedbackPrompt(settings, markdown, slide, strings.TrimSpace(slides[slide-1]), reason)
+	if err != nil {
+		return nil, err
+	}
+	revised, err := s.revise(ctx, prompt, s.jobTokenLimits(settings), false, statusUpdateFn)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := singleSlide(revised)
+	if err != nil {
+		return nil, err
+	}
+	slides[slide-1] = "\n" + replacement + "\n"
+
+	previous := []models.File{{Filename: "previous revision", Data: []byte(markdown), Type: "text/plain"}}
+	return s.renderPresentation(ctx, theme, joinSlides(frontmatter, slides), previous, settings, statusUpdateFn)
+}
+
+// singleSlide returns the slide of a regenerated slide response, dropping any
+// frontmatter or further slides the model added
+func singleSlide(markdown string) (string, error) {
+	var found []string
+	for _, slide := range splitOnSeparators(markdown) {
+		text := strings.TrimSpace(slide)
+		if text == "" || directivesPattern.MatchString(text) {
+			continue
+		}
+		found = append(found, text)
+	}
+	if len(found) == 0 {
+		return "", errors.New("failed to regenerate the slide. Please try again.")
+	}
+	if len(found) > 1 {
+		log.Printf("Regenerated slide had %d slides, keeping the first", len(found))
+	}
+	return found[0], nil
+}
+
+// revise sends a revision prompt to Gemini and returns the revised markdown,
+// which starts with the frontmatter when the prompt asks for the whole deck
+func (s *SlideService) revise(ctx context.Context, prompt string, limits models.TokenLimits, frontmatter bool, statusUpdateFn func(stage Stage, status Status) error) (string, error) {
+	release, err := s.generations.acquire(ctx, func() error {
+		return statusUpdateFn(StageProcessing, NewStatus(StatusWaitingForGeneration))
+	})
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	generateCtx, cancelGenerate := context.WithTimeout(ctx, generationTimeout)
+	defer cancelGenerate()
+
+	revised, err := s.generateValidMarkdown(generateCtx, s.generationModel(limits), nil, prompt, frontmatter)
+	if errors.Is(err, errInvalidResponse) || errors.Is(err, errOutputLimit) {
+		log.Printf("Failed to generate revision: %v", err)
+		return "", errors.New("failed to revise presentation. Please try again.")
+	}
+	if err != nil {
+		log.Printf("Failed to generate revision: %v", err)
+		return "", err
+	}
+	return revised, nil
+}
@@ -0,0 +1,34 @@
+package slides
+
+import "testing"
+
+func TestSplitSlidesRoundTrip(t *testing.T) {
+	markdown := "---\nmarp: true\n---\n\n# Title\n\n---\n\n## Code\n\n```yaml\n---\nkey: value\n```\n\n---\n\n## End"
+
+	frontmatter, slides := splitSlides(markdown)
+	if frontmatter != "---\nmarp: true\n---" || len(slides) != 3 {
+		t.Fatalf("expected the frontmatter and 3 slides, got %q and %q", frontmatter, slides)
+	}
+	if got := joinSlides(frontmatter, slides); got != markdown {
+		t.Fatalf("expected joining to restore the markdown, got:\n%s", got)
+	}
+}
+
+func TestSingleSlide(t *testing.T) {
+	tests := map[string]string{
+		"## Pricing\n\n- Free tier":                         "## Pricing\n\n- Free tier",
+		"---\n## Pricing\n\n- Free tier\n---":               "## Pricing\n\n- Free tier",
+		"---\nmarp: true\n---\n\n## Pricing\n\n- Free tier": "## Pricing\n\n- Free tier",
+		"## Pricing\n\n---\n\n## Extra":                     "## Pricing",
+	}
+	for response, want := range tests {
+		got, err := singleSlide(response)
+		if err != nil || got != want {
+			t.Errorf("singleSlide(%q) = %q, %v, want %q", response, got, err, want)
+		}
+	}
+
+	if _, err := singleSlide("---\n\n---"); err == nil {
+		t.Fatal("expected an error for a response without a slide")
+	}
+}
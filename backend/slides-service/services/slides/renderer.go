@@ -0,0 +1,282 @@
+package slides
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Engines a deck can be rendered with, set by the renderer setting
+const (
+	EngineMarp   = "marp"   // Marp CLI, the default, which reads the generated markdown as it is
+	EngineSlidev = "slidev" // Slidev, from markdown converted to its layouts
+	EngineBeamer = "beamer" // Pandoc to LaTeX Beamer for the PDF and reveal.js for the HTML
+	EngineNative = "native" // A subset of Marp laid out in Go, without Node or Chromium
+)
+
+// waitDelay is how long to wait for Chromium to release the output pipes after
+// the Marp CLI is killed
+const waitDelay = 10 * time.Second
+
+// warmDeck is rendered when the service starts, so Node and Chromium are
+// loaded before the first task
+const warmDeck = "---\nmarp: true\n---\n\n# slideitin\n"
+
+// marpConfig renders emoji shortcodes and Unicode emoji with Twemoji, so they
+// look the same in every format whatever fonts the container has, and allows
+// the <i> elements of Font Awesome icons in the formats rendered without HTML
+const marpConfig = `{
+  "html": {"br": [], "i": ["class", "aria-hidden"]},
+  "options": {"emoji": {"shortcode": "twemoji", "unicode": "twemoji"}}
+}`
+
+// RenderOptions controls how a deck is rendered
+type RenderOptions struct {
+	Theme    string // Name of the theme
+	ThemeCSS string // Theme stylesheet, empty for themes built into the renderer
+	PDFOnly  bool   // Skips the HTML and the thumbnail, for documents only downloaded as PDF
+	PDFNotes bool   // Adds the speaker notes to the PDF as annotations, Marp only
+	Engine   string // Engine to render with, empty for Marp
+
+	// The engines that don't read Marp directives stamp these themselves
+	Footer    string // Footer of every slide
+	Watermark string // Watermark drawn across every slide
+}
+
+// RenderOutput holds the rendered formats of a deck
+type RenderOutput struct {
+	PDFData   []byte
+	HTMLData  []byte
+	Thumbnail []byte // PNG of the first slide, nil if it couldn't be rendered
+}
+
+// Renderer converts generated markdown into presentation files
+type Renderer interface {
+	Render(ctx context.Context, markdown string, options RenderOptions) (*RenderOutput, error)
+}
+
+// Renderers dispatches to the renderer of the engine in the render options
+type Renderers map[string]Renderer
+
+// Render renders the deck with the renderer of options.Engine
+func (r Renderers) Render(ctx context.Context, markdown string, options RenderOptions) (*RenderOutput, error) {
+	engine := options.Engine
+	if engine == "" {
+		engine = EngineMarp
+	}
+	renderer, ok := r[engine]
+	if !ok {
+		return nil, fmt.Errorf("renderer %q is not available", engine)
+	}
+	return renderer.Render(ctx, markdown, options)
+}
+
+// MarpRenderer renders decks with the Marp CLI
+type MarpRenderer struct {
+	binary       string   // Marp CLI executable, a path or a name on the PATH
+	chromiumPath string   // Chromium executable, empty for the browser the Marp CLI finds
+	sandbox      *Sandbox // Sandbox the CLI runs in, nil to run it directly
+}
+
+// NewMarpRenderer creates a new Marp CLI renderer. The CLI is run directly
+// rather than through npx, which resolves the package on every render and
+// can download it.
+func NewMarpRenderer(binary, chromiumPath string, sandbox *Sandbox) *MarpRenderer {
+	return &MarpRenderer{binary: binary, chromiumPath: chromiumPath, sandbox: sandbox}
+}
+
+// Warm checks that the Marp CLI and Chromium are installed and renders a one
+// slide deck with them. The service isn't ready to take tasks until it passes.
+func (r *MarpRenderer) Warm(ctx context.Context) error {
+	if _, err := exec.LookPath(r.binary); err != nil {
+		return fmt.Errorf("Marp CLI not found: %v", err)
+	}
+	if r.chromiumPath != "" {
+		if _, err := exec.LookPath(r.chromiumPath); err != nil {
+			return fmt.Errorf("Chromium not found: %v", err)
+		}
+	}
+	if _, err := r.Render(ctx, warmDeck, RenderOptions{Theme: "default", PDFOnly: true}); err != nil {
+		return fmt.Errorf("warm-up render failed: %v", err)
+	}
+	return nil
+}
+
+// Render runs the Marp CLI to convert the markdown to PDF and HTML
+func (r *MarpRenderer) Render(ctx context.Context, markdown string, options RenderOptions) (*RenderOutput, error) {
+	// Create a temporary directory for our files
+	dir, err := newWorkDir("", r.sandbox)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.remove() // Clean up when we're done
+
+	// Create the markdown file
+	mdFilePath := dir.file("presentation.md")
+	if err := os.WriteFile(mdFilePath, []byte(markdown), 0644); err != nil {
+		log.Printf("Failed to write markdown file: %v", err)
+		return nil, err
+	}
+
+	configPath := dir.file("marp.config.json")
+	if err := os.WriteFile(configPath, []byte(marpConfig), 0644); err != nil {
+		log.Printf("Failed to write Marp config: %v", err)
+		return nil, err
+	}
+
+	marpArgs := []string{mdFilePath, "--config-file", configPath}
+
+	// Use the theme stylesheet if there is one, otherwise a built-in theme
+	if options.ThemeCSS != "" {
+		themePath := dir.file(options.Theme + ".css")
+		if err := os.WriteFile(themePath, []byte(options.ThemeCSS), 0644); err != nil {
+			log.Printf("Failed to write theme file: %v", err)
+			return nil, err
+		}
+		marpArgs = append(marpArgs, "--theme", themePath)
+		log.Printf("Using theme: %s", options.Theme)
+	} else {
+		marpArgs = append(marpArgs, "--theme", options.Theme)
+		log.Printf("Using built-in theme: %s", options.Theme)
+	}
+
+	// Chromium has no network in the sandbox, so the PDF and thumbnail are
+	// rendered with the images downloaded beforehand. The HTML keeps linking
+	// them, the viewer embeds them.
+	offlineArgs := marpArgs
+	if offline := dir.sandbox.offline(ctx, markdown); offline != markdown {
+		offlinePath := dir.file("offline.md")
+		if err := os.WriteFile(offlinePath, []byte(offline), 0644); err != nil {
+			log.Printf("Failed to write offline markdown file: %v", err)
+			return nil, err
+		}
+		offlineArgs = append([]string{offlinePath}, marpArgs[1:]...)
+	}
+
+	// Chromium tags the PDF structure, and the outlines give it a navigable reading order
+	pdfFilePath := dir.file("presentation.pdf")
+	pdfArgs := append(offlineArgs[:len(offlineArgs):len(offlineArgs)], "--output", pdfFilePath, "--pdf", "--pdf-outlines")
+	if options.PDFNotes {
+		pdfArgs = append(pdfArgs, "--pdf-notes")
+	}
+	if err := r.run(ctx, dir, pdfArgs); err != nil {
+		return nil, stepError(ctx, err, "failed to generate PDF. Please try again.")
+	}
+
+	// Read the generated PDF
+	pdfBytes, err := os.ReadFile(pdfFilePath)
+	if err != nil {
+		log.Printf("Failed to read generated PDF: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Successfully generated PDF (%d bytes)", len(pdfBytes))
+	if options.PDFOnly {
+		return &RenderOutput{PDFData: pdfBytes}, nil
+	}
+
+	// Run Marp CLI to generate the HTML
+	htmlFilePath := dir.file("presentation.html")
+	if err := r.run(ctx, dir, append(marpArgs, "--output", htmlFilePath, "--html")); err != nil {
+		return nil, stepError(ctx, err, "failed to generate HTML. Please try again.")
+	}
+
+	// Read the generated HTML
+	htmlBytes, err := os.ReadFile(htmlFilePath)
+	if err != nil {
+		log.Printf("Failed to read generated HTML: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Successfully generated HTML (%d bytes)", len(htmlBytes))
+
+	// Render the first slide as a preview, a deck without one is still complete
+	var thumbnail []byte
+	thumbnailPath := dir.file("thumbnail.png")
+	if err := r.run(ctx, dir, append(offlineArgs, "--output", thumbnailPath, "--image", "png", "--image-scale", "0.5")); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, ErrSandboxViolation) {
+			return nil, err
+		}
+		log.Printf("Failed to generate thumbnail: %v", err)
+	} else if thumbnail, err = os.ReadFile(thumbnailPath); err != nil {
+		log.Printf("Failed to read generated thumbnail: %v", err)
+	}
+
+	return &RenderOutput{
+		PDFData:   pdfBytes,
+		HTMLData:  htmlBytes,
+		Thumbnail: thumbnail,
+	}, nil
+}
+
+// stepError returns the error of a failed render step. Timeouts, full
+// temporary directories and sandbox violations are returned as they are,
+// other failures as message.
+func stepError(ctx context.Context, err error, message string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, ErrWorkDirFull) || errors.Is(err, ErrSandboxViolation) {
+		return err
+	}
+	return errors.New(message)
+}
+
+// run runs the Marp CLI with the given arguments in dir, killing it if the
+// context is done or dir grows over its limit
+func (r *MarpRenderer) run(ctx context.Context, dir *workDir, args []string) error {
+	var env []string
+	if r.chromiumPath != "" {
+		env = append(env, "CHROME_PATH="+r.chromiumPath)
+	}
+	return dir.runEnv(ctx, env, r.binary, args...)
+}
+
+// runTool runs a command in dir with the extra environment variables, in
+// the sandbox of dir if it has one, killing it if the context is done
+func runTool(ctx context.Context, dir *workDir, env []string, name string, args ...string) error {
+	cmd, err := dir.sandbox.command(ctx, dir.path, env, name, args...)
+	if err != nil {
+		log.Printf("Failed to sandbox %s: %v", name, err)
+		return err
+	}
+	defer func() {
+		for _, file := range cmd.ExtraFiles {
+			file.Close()
+		}
+	}()
+	cmd.WaitDelay = waitDelay
+	var cmdError bytes.Buffer
+	cmd.Stderr = &cmdError
+	if err := cmd.Run(); err != nil {
+		log.Printf("Failed to run %s: %v", name, err)
+		log.Printf("%s stderr: %s", name, cmdError.String())
+		return dir.sandbox.check(name, err)
+	}
+	return nil
+}
+
+// pdfThumbnail renders the first page of a PDF as a PNG preview with
+// pdftoppm, for the engines that can't render images themselves. It returns
+// nil if the page couldn't be rendered.
+func pdfThumbnail(ctx context.Context, dir *workDir, pdfPath string) []byte {
+	prefix := dir.file("thumbnail")
+	if err := dir.run(ctx, "pdftoppm", "-png", "-f", "1", "-l", "1", "-singlefile", "-scale-to", "640", pdfPath, prefix); err != nil {
+		log.Printf("Failed to generate thumbnail: %v", err)
+		return nil
+	}
+	thumbnail, err := os.ReadFile(prefix + ".png")
+	if err != nil {
+		log.Printf("Failed to read generated thumbnail: %v", err)
+		return nil
+	}
+	return thumbnail
+}
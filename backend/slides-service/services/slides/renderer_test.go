@@ -0,0 +1,36 @@
+package slides
+
+import (
+	"context"
+	"testing"
+)
+
+// engineRenderer is a fake renderer that returns its engine as the PDF
+type engineRenderer string
+
+func (r engineRenderer) Render(ctx context.Context, markdown string, options RenderOptions) (*RenderOutput, error) {
+	return &RenderOutput{PDFData: []byte(r)}, nil
+}
+
+func TestRenderersDispatchOnEngine(t *testing.T) {
+	renderers := Renderers{EngineMarp: engineRenderer("marp"), EngineBeamer: engineRenderer("beamer")}
+
+	tests := []struct {
+		engine string
+		want   string
+	}{
+		{"", "marp"},
+		{EngineMarp, "marp"},
+		{EngineBeamer, "beamer"},
+	}
+	for _, test := range tests {
+		output, err := renderers.Render(context.Background(), "# Deck", RenderOptions{Engine: test.engine})
+		if err != nil || string(output.PDFData) != test.want {
+			t.Errorf("engine %q: expected the %s renderer, got %v, %v", test.engine, test.want, output, err)
+		}
+	}
+
+	if _, err := renderers.Render(context.Background(), "# Deck", RenderOptions{Engine: EngineSlidev}); err == nil {
+		t.Error("expected an error for an engine without a renderer")
+	}
+}
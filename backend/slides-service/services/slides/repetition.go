@@ -0,0 +1,216 @@
+package slides
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// shingleSize is the number of consecutive words compared between slides
+	shingleSize = 3
+
+	// duplicateSlideSimilarity is the share of shingles two slides have in
+	// common from which the later one repeats the earlier
+	duplicateSlideSimilarity = 0.6
+
+	// minSlideShingles is the fewest shingles a slide needs to be compared,
+	// so title and closing slides with a few words aren't taken for repeats
+	minSlideShingles = 6
+
+	// duplicateBulletSimilarity is the share of words two bullet points of a
+	// slide have in common from which the later one repeats the earlier
+	duplicateBulletSimilarity = 0.8
+)
+
+// bulletPattern matches a bullet point and captures its indentation and text
+var bulletPattern = regexp.MustCompile(`^(\s*)(?:[-*+]|\d+[.)])\s+(.+)$`)
+
+// Repetition is what removeRepetition took out of a deck
+type Repetition struct {
+	Slides  []int // Slides that repeated an earlier one, counted from 1 in the generated deck
+	Bullets int   // Bullet points that repeated another of their slide
+}
+
+// warning describes the repetition for the warnings of the job, or "" if
+// there was none
+func (r Repetition) warning() string {
+	var removed []string
+	switch len(r.Slides) {
+	case 0:
+	case 1:
+		removed = append(removed, fmt.Sprintf("slide %d, which repeated an earlier slide", r.Slides[0]))
+	default:
+		removed = append(removed, fmt.Sprintf("slides %s, which repeated earlier slides", joinNumbers(r.Slides)))
+	}
+	switch r.Bullets {
+	case 0:
+	case 1:
+		removed = append(removed, "1 repeated bullet point")
+	default:
+		removed = append(removed, fmt.Sprintf("%d repeated bullet points", r.Bullets))
+	}
+	if len(removed) == 0 {
+		return ""
+	}
+	return "Removed " + strings.Join(removed, " and ") + " from the generated deck"
+}
+
+// removeRepetition takes out the slides that repeat an earlier slide and the
+// bullet points that repeat another of their slide, which the model writes
+// when it loses track of a long deck. Slides are compared by the shingles of
+// their words. The bullet points of a repeated slide its earlier slide
+// doesn't have are merged into that slide.
+func removeRepetition(markdown string) (string, Repetition) {
+	var repetition Repetition
+	frontmatter, slides := splitSlides(markdown)
+
+	kept := make([]string, 0, len(slides))
+	keptShingles := make([]map[string]bool, 0, len(slides))
+	for i, slide := range slides {
+		slide, removed := removeRepeatedBullets(slide)
+		repetition.Bullets += removed
+
+		shingles := slideShingles(slide)
+		if len(shingles) >= minSlideShingles {
+			if j := similarSlide(shingles, keptShingles); j >= 0 {
+				kept[j] = mergeBullets(kept[j], slide)
+				keptShingles[j] = slideShingles(kept[j])
+				repetition.Slides = append(repetition.Slides, i+1)
+				continue
+			}
+		}
+		kept = append(kept, slide)
+		keptShingles = append(keptShingles, shingles)
+	}
+
+	if len(repetition.Slides) == 0 && repetition.Bullets == 0 {
+		return markdown, repetition
+	}
+	return joinSlides(frontmatter, kept), repetition
+}
+
+// similarSlide returns the index of the first slide whose shingles are
+// similar enough to be repeated, or -1
+func similarSlide(shingles map[string]bool, slides []map[string]bool) int {
+	for i, other := range slides {
+		if len(other) >= minSlideShingles && jaccard(shingles, other) >= duplicateSlideSimilarity {
+			return i
+		}
+	}
+	return -1
+}
+
+// slideShingles returns the shingles of the words of a slide, leaving out its
+// speaker notes and directives
+func slideShingles(slide string) map[string]bool {
+	words := tokenize(htmlCommentPattern.ReplaceAllString(slide, " "))
+	shingles := map[string]bool{}
+	for i := 0; i+shingleSize <= len(words); i++ {
+		shingles[strings.Join(words[i:i+shingleSize], " ")] = true
+	}
+	return shingles
+}
+
+// removeRepeatedBullets drops the bullet points of a slide that repeat an
+// earlier one at the same indentation, outside code blocks, and returns how
+// many it dropped
+func removeRepeatedBullets(slide string) (string, int) {
+	lines := strings.Split(slide, "\n")
+	kept := make([]string, 0, len(lines))
+	seen := map[string][]map[string]bool{} // Words of the bullet points so far by indentation
+	removed := 0
+	inCode := false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		match := bulletPattern.FindStringSubmatch(line)
+		if inCode || match == nil {
+			kept = append(kept, line)
+			continue
+		}
+		words := wordSet(match[2])
+		if len(words) > 0 && repeatsBullet(words, seen[match[1]]) {
+			removed++
+			continue
+		}
+		seen[match[1]] = append(seen[match[1]], words)
+		kept = append(kept, line)
+	}
+	if removed == 0 {
+		return slide, 0
+	}
+	return strings.Join(kept, "\n"), removed
+}
+
+// mergeBullets adds the top-level bullet points of a repeated slide that the
+// slide it repeats doesn't have after the last bullet point of that slide,
+// which keeps the slide unchanged if it has none
+func mergeBullets(slide, repeated string) string {
+	lines := strings.Split(slide, "\n")
+	last := -1
+	var existing []map[string]bool
+	for i, line := range lines {
+		if match := bulletPattern.FindStringSubmatch(line); match != nil {
+			last = i
+			existing = append(existing, wordSet(match[2]))
+		}
+	}
+	if last < 0 {
+		return slide
+	}
+
+	var added []string
+	for _, line := range strings.Split(repeated, "\n") {
+		match := bulletPattern.FindStringSubmatch(line)
+		if match == nil || match[1] != "" {
+			continue
+		}
+		words := wordSet(match[2])
+		if len(words) == 0 || repeatsBullet(words, existing) {
+			continue
+		}
+		existing = append(existing, words)
+		added = append(added, line)
+	}
+	if len(added) == 0 {
+		return slide
+	}
+	merged := append(append(append([]string{}, lines[:last+1]...), added...), lines[last+1:]...)
+	return strings.Join(merged, "\n")
+}
+
+// repeatsBullet reports whether the words of a bullet point are similar
+// enough to those of an earlier one
+func repeatsBullet(words map[string]bool, earlier []map[string]bool) bool {
+	for _, other := range earlier {
+		if jaccard(words, other) >= duplicateBulletSimilarity {
+			return true
+		}
+	}
+	return false
+}
+
+// wordSet returns the distinct words of a text
+func wordSet(text string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range tokenize(text) {
+		words[word] = true
+	}
+	return words
+}
+
+// jaccard returns the share of the members of two sets they have in common
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for member := range a {
+		if b[member] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
@@ -0,0 +1,56 @@
+package slides
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRemoveRepetition(t *testing.T) {
+	markdown := strings.Join([]string{
+		"---\nmarp: true\n---\n\n# Quarterly Review",
+		"\n## Revenue\n\n- Revenue grew 12% in the third quarter\n- Subscriptions drove most of the growth\n- Revenue grew 12% in the third quarter\n",
+		"\n## Hiring\n\n- The team grew from 40 to 55 engineers\n- Attrition stayed below five percent\n",
+		"\n## Revenue\n\n- Revenue grew 12% in the third quarter\n- Subscriptions drove most of the growth\n- Enterprise deals closed early\n\n<!-- Mention the enterprise pipeline -->\n",
+		"\n# Questions?\n",
+		"\n# Questions?\n",
+	}, "\n---\n")
+
+	got, repetition := removeRepetition(markdown)
+	if len(repetition.Slides) != 1 || repetition.Slides[0] != 4 || repetition.Bullets != 1 {
+		t.Fatalf("expected slide 4 and 1 bullet point removed, got %+v", repetition)
+	}
+	_, slides := splitSlides(got)
+	if len(slides) != 5 {
+		t.Fatalf("expected 5 slides, got %d:\n%s", len(slides), got)
+	}
+	want := "\n## Revenue\n\n- Revenue grew 12% in the third quarter\n- Subscriptions drove most of the growth\n- Enterprise deals closed early\n"
+	if slides[1] != want {
+		t.Errorf("expected the new bullet point merged into slide 2, got %q", slides[1])
+	}
+	if warning := repetition.warning(); warning != "Removed slide 4, which repeated an earlier slide and 1 repeated bullet point from the generated deck" {
+		t.Errorf("unexpected warning %q", warning)
+	}
+
+	unchanged := "---\nmarp: true\n---\n\n# Deck\n\n---\n\n```\n- same line here\n- same line here\n```\n"
+	if got, repetition := removeRepetition(unchanged); got != unchanged || repetition.warning() != "" {
+		t.Errorf("expected code to be left alone, got %q, %+v", got, repetition)
+	}
+}
+
+func TestRemoveRepeatedBullets(t *testing.T) {
+	tests := []struct {
+		slide   string
+		want    string
+		removed int
+	}{
+		{"- Costs fell sharply\n- Costs fell sharply.", "- Costs fell sharply", 1},
+		{"- Costs fell sharply\n  - Costs fell sharply", "- Costs fell sharply\n  - Costs fell sharply", 0},
+		{"1. Launch the pilot program\n2. Launch the pilot program", "1. Launch the pilot program", 1},
+		{"- Costs fell in Europe\n- Costs rose in Asia", "- Costs fell in Europe\n- Costs rose in Asia", 0},
+	}
+	for _, test := range tests {
+		if got, removed := removeRepeatedBullets(test.slide); got != test.want || removed != test.removed {
+			t.Errorf("removeRepeatedBullets(%q) = %q, %d, want %q, %d", test.slide, got, removed, test.want, test.removed)
+		}
+	}
+}
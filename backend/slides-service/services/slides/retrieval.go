@@ -0,0 +1,327 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+const (
+	// retrievalRatio is how many times the input budget documents must take
+	// before each section of the deck is written from the passages retrieved
+	// for it, rather than from the documents trimmed to the budget
+	retrievalRatio = 3
+
+	// maxIndexedPassages bounds the passages of the documents embedded for
+	// retrieval, about 20 MB of text
+	maxIndexedPassages = 5000
+
+	// embeddingModel is the Gemini model embedding the passages and queries
+	embeddingModel = "text-embedding-004"
+
+	// embeddingBatchSize is the most texts embedded in one call to Gemini
+	embeddingBatchSize = 100
+
+	// localDimensions is the size of the vectors of the local embedder
+	localDimensions = 1024
+
+	// localEmbeddingModel names the local embedder in stored indexes
+	localEmbeddingModel = "local"
+)
+
+// Embedder turns texts into vectors whose cosine similarity measures how
+// related the texts are. Queries and passages may be embedded differently.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string, query bool) ([][]float32, error)
+	// Model names the embeddings, vectors of different models can't be compared
+	Model() string
+}
+
+// geminiEmbedder embeds texts with the Gemini embedding model
+type geminiEmbedder struct {
+	client *genai.Client
+}
+
+// Embed embeds the texts in batches
+func (e *geminiEmbedder) Embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	model := e.client.EmbeddingModel(embeddingModel)
+	model.TaskType = genai.TaskTypeRetrievalDocument
+	if query {
+		model.TaskType = genai.TaskTypeRetrievalQuery
+	}
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		batch := model.NewBatch()
+		for _, text := range texts[start:min(start+embeddingBatchSize, len(texts))] {
+			batch.AddContent(genai.Text(text))
+		}
+		resp, err := model.BatchEmbedContents(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, embedding := range resp.Embeddings {
+			vectors = append(vectors, embedding.Values)
+		}
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(vectors), len(texts))
+	}
+	return vectors, nil
+}
+
+// Model returns the Gemini embedding model
+func (e *geminiEmbedder) Model() string {
+	return embeddingModel
+}
+
+// localEmbedder embeds texts without a model by hashing their words into a
+// fixed number of dimensions, weighted by the log of their counts. It finds
+// passages that share words with the query, where Gemini also finds the
+// ones that share meaning.
+type localEmbedder struct{}
+
+// Embed hashes the words of each text
+func (localEmbedder) Embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		counts := make(map[uint32]float64)
+		for _, term := range tokenize(text) {
+			h := fnv.New32a()
+			h.Write([]byte(term))
+			counts[h.Sum32()%localDimensions]++
+		}
+		vector := make([]float32, localDimensions)
+		for dimension, count := range counts {
+			vector[dimension] = float32(1 + math.Log(count))
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// Model returns the name of the local embedder
+func (localEmbedder) Model() string {
+	return localEmbeddingModel
+}
+
+// passageIndex holds the passages of the documents of a job with their
+// vectors, searched by brute force as a job has at most maxIndexedPassages
+type passageIndex struct {
+	embedder  Embedder // Embeds the queries like the passages were
+	passages  []section
+	vectors   [][]float32
+	documents []string // Documents in the order they were given
+}
+
+// indexPassages embeds the passages of the documents, with the local
+// embedder if Gemini fails to embed them. Vectors already computed by the
+// embedder of the service for a passage, from a library index, are kept.
+func (s *SlideService) indexPassages(ctx context.Context, passages []section, vectors [][]float32) (*passageIndex, error) {
+	if len(passages) > maxIndexedPassages {
+		return nil, fmt.Errorf("documents have %d passages, more than the %d that can be indexed", len(passages), maxIndexedPassages)
+	}
+	index := &passageIndex{embedder: s.embedder, passages: passages, vectors: make([][]float32, len(passages))}
+	texts := make([]string, len(passages))
+	var missing []int
+	seen := make(map[string]bool)
+	for i, passage := range passages {
+		passages[i].order = i
+		texts[i] = passage.text
+		if passage.title != "" {
+			texts[i] = passage.title + "\n\n" + passage.text
+		}
+		if i < len(vectors) && vectors[i] != nil {
+			index.vectors[i] = vectors[i]
+		} else {
+			missing = append(missing, i)
+		}
+		if !seen[passage.document] {
+			seen[passage.document] = true
+			index.documents = append(index.documents, passage.document)
+		}
+	}
+
+	if index.embedder != nil {
+		err := index.embed(ctx, texts, missing)
+		if err == nil {
+			return index, nil
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, err
+		}
+		log.Printf("Failed to embed the passages with Gemini, matching words instead: %v", err)
+	}
+
+	// Vectors of Gemini can't be compared with local ones, so all are redone
+	index.embedder = localEmbedder{}
+	all := make([]int, len(texts))
+	for i := range all {
+		all[i] = i
+	}
+	return index, index.embed(ctx, texts, all)
+}
+
+// embed sets the vectors of the passages at the given positions
+func (i *passageIndex) embed(ctx context.Context, texts []string, positions []int) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	batch := make([]string, len(positions))
+	for j, position := range positions {
+		batch[j] = texts[position]
+	}
+	vectors, err := i.embedder.Embed(ctx, batch, false)
+	if err != nil {
+		return err
+	}
+	for j, position := range positions {
+		i.vectors[position] = vectors[j]
+	}
+	return nil
+}
+
+// indexDocuments splits the documents into passages and indexes them. The
+// passages of library documents indexed with the same embedding model are
+// taken from their index instead of being split and embedded again.
+func (s *SlideService) indexDocuments(ctx context.Context, files []models.File, settings models.SlideSettings, statusUpdateFn func(stage Stage, status Status) error) (*passageIndex, error) {
+	var passages []section
+	var vectors [][]float32
+	for _, file := range files {
+		if file.Index != nil && s.embedder != nil && file.Index.Model == s.embedder.Model() {
+			for _, passage := range file.Index.Passages {
+				text := passage.Text
+				if settings.InjectionDetection {
+					text, _ = removeInjections(text)
+				}
+				passages = append(passages, section{document: file.Filename, title: passage.Title, text: text})
+				vectors = append(vectors, passage.Vector)
+			}
+			continue
+		}
+		sections, _, err := documentSections(ctx, []models.File{file}, settings)
+		if err != nil {
+			continue
+		}
+		passages = append(passages, sections...)
+		vectors = append(vectors, make([][]float32, len(sections))...)
+	}
+	if len(passages) == 0 {
+		return nil, errors.New("none of the files could be read")
+	}
+	if err := statusUpdateFn(StageProcessing, NewStatus(StatusIndexingDocuments, "passages", strconv.Itoa(len(passages)))); err != nil {
+		return nil, err
+	}
+	return s.indexPassages(ctx, passages, vectors)
+}
+
+// IndexDocument splits a document added to a workspace library into passages
+// and embeds them, so decks generated from the library retrieve its passages
+// without embedding it again. A PDF is also uploaded to Gemini, where the
+// file cache keeps it for the decks generated in the next two days.
+func (s *SlideService) IndexDocument(ctx context.Context, file models.File) (*models.DocumentIndex, error) {
+	passages, _, err := documentSections(ctx, []models.File{file}, models.SlideSettings{})
+	if err != nil {
+		return nil, err
+	}
+	index, err := s.indexPassages(ctx, passages, nil)
+	if err != nil {
+		return nil, err
+	}
+	document := &models.DocumentIndex{
+		Model:    index.embedder.Model(),
+		Passages: make([]models.IndexedPassage, len(index.passages)),
+	}
+	for i, passage := range index.passages {
+		document.Passages[i] = models.IndexedPassage{Title: passage.title, Text: passage.text, Vector: index.vectors[i]}
+	}
+
+	if file.Type == "application/pdf" && file.Hash != "" && s.fileCache != nil {
+		if _, err := s.uploadFile(ctx, file); err != nil {
+			log.Printf("Failed to upload %s to Gemini ahead of its decks: %v", file.Filename, err)
+		}
+	}
+	return document, nil
+}
+
+// libraryIndexed reports whether all of the files come with a library index
+func libraryIndexed(files []models.File) bool {
+	for _, file := range files {
+		if file.Index == nil {
+			return false
+		}
+	}
+	return len(files) > 0
+}
+
+// retrieve returns the documents made of the passages most similar to the
+// query that fit in the token budget, in the order of the documents, and the
+// number of passages retrieved
+func (i *passageIndex) retrieve(ctx context.Context, query string, budget int) ([]genai.Part, int, error) {
+	vectors, err := i.embedder.Embed(ctx, []string{query}, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	scored := make([]section, len(i.passages))
+	for j, passage := range i.passages {
+		passage.score = cosine(vectors[0], i.vectors[j])
+		scored[j] = passage
+	}
+	kept, _ := selectSections(scored, budget)
+
+	texts := joinSections(kept)
+	parts := make([]genai.Part, 0, len(texts))
+	for _, document := range i.documents {
+		if text := texts[document]; text != "" {
+			parts = append(parts, genai.Text(inlineDocument(document, text)))
+		}
+	}
+	return parts, len(kept), nil
+}
+
+// retrieveSection returns the documents a section of a deck is written from:
+// the passages retrieved for its titles that fit in the input budget next to
+// its prompt, or the trimmed documents if retrieval fails
+func (s *SlideService) retrieveSection(ctx context.Context, index *passageIndex, outline models.DeckOutline, i int, prompt string, limits models.TokenLimits, documents []genai.Part) []genai.Part {
+	// Leave room for the document delimiters and the error of the estimate
+	budget := (limits.Input - len(prompt)/charsPerToken - 64*len(index.documents)) * 9 / 10
+	parts, retrieved, err := index.retrieve(ctx, sectionQuery(outline, i), budget)
+	if err != nil || retrieved == 0 {
+		log.Printf("Failed to retrieve the passages of part %d, writing it from the trimmed documents: %v", i+1, err)
+		return documents
+	}
+	log.Printf("Writing part %d from %d of %d passages", i+1, retrieved, len(index.passages))
+	return parts
+}
+
+// sectionQuery returns the text passages are retrieved for to write a
+// section of a deck: the titles of the deck, the section and its slides
+func sectionQuery(outline models.DeckOutline, i int) string {
+	section := outline.Sections[i]
+	return strings.Join(append([]string{outline.Title, section.Title}, section.Slides...), "\n")
+}
+
+// cosine returns the cosine similarity of two vectors, 0 if either is empty
+// or their sizes differ
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	dot, normA, normB := 0.0, 0.0, 0.0
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
@@ -0,0 +1,126 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+// failingEmbedder stands in for Gemini when it can't embed
+type failingEmbedder struct{}
+
+func (failingEmbedder) Embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	return nil, errors.New("quota exceeded")
+}
+
+func (failingEmbedder) Model() string {
+	return embeddingModel
+}
+
+// countingEmbedder embeds texts like the local embedder, counting them
+type countingEmbedder struct {
+	localEmbedder
+	embedded int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	e.embedded += len(texts)
+	return e.localEmbedder.Embed(ctx, texts, query)
+}
+
+func TestRetrievePassages(t *testing.T) {
+	textbook := strings.Join([]string{
+		"# Photosynthesis\n\nPlants convert sunlight, water and carbon dioxide into glucose and oxygen in their chloroplasts.",
+		"# Cell division\n\nMitosis splits one cell into two identical daughter cells, meiosis produces gametes.",
+		"# Chloroplasts\n\nChloroplasts hold chlorophyll, which absorbs sunlight for photosynthesis.",
+		"# Genetics\n\nGenes are inherited from both parents and carried on chromosomes.",
+	}, "\n\n")
+	passages := splitSections("biology.md", textbook)
+	passages = append(passages, splitSections("notes.md", "# Review\n\nMitosis and meiosis are both forms of cell division.")...)
+
+	s := &SlideService{embedder: failingEmbedder{}}
+	index, err := s.indexPassages(context.Background(), passages, nil)
+	if err != nil {
+		t.Fatalf("indexPassages failed: %v", err)
+	}
+	if _, ok := index.embedder.(localEmbedder); !ok {
+		t.Fatalf("expected the local embedder after Gemini failed, got %T", index.embedder)
+	}
+
+	outline := models.DeckOutline{Title: "Biology", Sections: []models.OutlineSection{
+		{Title: "How plants use sunlight", Slides: []string{"Photosynthesis", "Chloroplasts and chlorophyll"}},
+		{Title: "Cell division", Slides: []string{"Mitosis", "Meiosis"}},
+	}}
+	parts, retrieved, err := index.retrieve(context.Background(), sectionQuery(outline, 0), 60)
+	if err != nil {
+		t.Fatalf("retrieve failed: %v", err)
+	}
+	if retrieved != 2 || len(parts) != 1 {
+		t.Fatalf("expected 2 passages of one document, got %d in %d documents", retrieved, len(parts))
+	}
+	text := string(parts[0].(genai.Text))
+	if !strings.Contains(text, "# Photosynthesis") || !strings.Contains(text, "# Chloroplasts") || strings.Index(text, "# Photosynthesis") > strings.Index(text, "# Chloroplasts") {
+		t.Errorf("expected the photosynthesis passages in document order, got %q", text)
+	}
+
+	parts, _, err = index.retrieve(context.Background(), sectionQuery(outline, 1), 50)
+	if err != nil {
+		t.Fatalf("retrieve failed: %v", err)
+	}
+	if len(parts) != 2 || !strings.Contains(string(parts[0].(genai.Text)), "Mitosis splits") || !strings.Contains(string(parts[1].(genai.Text)), "notes.md") {
+		t.Errorf("expected the cell division passages of both documents, got %v", parts)
+	}
+}
+
+func TestIndexDocumentsReusesLibraryIndex(t *testing.T) {
+	embedder := &countingEmbedder{}
+	s := &SlideService{embedder: embedder}
+	handbook := models.File{Filename: "handbook.md", Type: "text/markdown", Data: []byte("# Leave\n\nEmployees get 25 days of paid leave.\n\n# Expenses\n\nReceipts are needed for any expense.")}
+	library, err := s.IndexDocument(context.Background(), handbook)
+	if err != nil {
+		t.Fatalf("IndexDocument failed: %v", err)
+	}
+	if library.Model != localEmbeddingModel || len(library.Passages) != 2 || embedder.embedded != 2 {
+		t.Fatalf("expected 2 embedded passages, got %+v after %d embeddings", library, embedder.embedded)
+	}
+
+	handbook.Index = library
+	notes := models.File{Filename: "notes.md", Type: "text/markdown", Data: []byte("# Travel\n\nBook trains for trips under four hours.")}
+	index, err := s.indexDocuments(context.Background(), []models.File{handbook, notes}, models.SlideSettings{}, func(stage Stage, status Status) error { return nil })
+	if err != nil {
+		t.Fatalf("indexDocuments failed: %v", err)
+	}
+	if embedder.embedded != 3 {
+		t.Errorf("expected only the passage of the notes to be embedded again, got %d embeddings", embedder.embedded)
+	}
+	if len(index.passages) != 3 || index.passages[0].document != "handbook.md" || index.passages[2].document != "notes.md" {
+		t.Fatalf("expected the passages in the order of the documents, got %+v", index.passages)
+	}
+
+	parts, _, err := index.retrieve(context.Background(), "paid leave days", 20)
+	if err != nil || len(parts) != 1 || !strings.Contains(string(parts[0].(genai.Text)), "25 days") {
+		t.Errorf("expected the leave passage from the stored vectors, got %v: %v", parts, err)
+	}
+}
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		a, b []float32
+		want float64
+	}{
+		{[]float32{1, 0}, []float32{1, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 1}, 0},
+		{[]float32{1, 1}, []float32{2, 2}, 1},
+		{[]float32{1, 0}, []float32{1}, 0},
+		{[]float32{0, 0}, []float32{1, 0}, 0},
+	}
+	for _, test := range tests {
+		if got := cosine(test.a, test.b); got < test.want-1e-9 || got > test.want+1e-9 {
+			t.Errorf("cosine(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
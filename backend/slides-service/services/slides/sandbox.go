@@ -0,0 +1,277 @@
+package slides
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/bpf"
+)
+
+const (
+	// seccompKill makes the kernel kill a process making a denied system call
+	// with SIGSYS, so the violation shows in how the tool exits
+	seccompKill = 0x80000000 // SECCOMP_RET_KILL_PROCESS
+
+	// seccompAllow lets a system call through
+	seccompAllow = 0x7fff0000 // SECCOMP_RET_ALLOW
+
+	// x32SyscallBit is set on the system calls of the x32 ABI, which would
+	// otherwise get around the numbers of the x86-64 filter
+	x32SyscallBit = 0x40000000
+)
+
+// ErrSandboxViolation is returned when a render tool is killed for making a
+// system call the sandbox denies
+var ErrSandboxViolation = errors.New("render was stopped for doing something the sandbox doesn't allow")
+
+// remoteImagePattern matches the markdown images loaded from another host
+var remoteImagePattern = regexp.MustCompile(`(!\[[^\]]*\]\(\s*)((?:https?:)?//[^)\s]+)`)
+
+// offlineImageTypes are the image types markdown-it accepts as data URIs
+var offlineImageTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true}
+
+// seccompAudits are the audit architectures of the architectures the
+// service is built for
+var seccompAudits = map[string]uint32{"amd64": 0xc000003e, "arm64": 0xc00000b7}
+
+// deniedSyscalls are the system calls the sandbox kills the tools for, with
+// their numbers on amd64 and arm64. The tools have no use for them, they
+// change the kernel, the mounts or other processes.
+var deniedSyscalls = []struct {
+	name         string
+	amd64, arm64 uint32
+}{
+	{"ptrace", 101, 117},
+	{"mount", 165, 40},
+	{"umount2", 166, 39},
+	{"pivot_root", 155, 41},
+	{"open_tree", 428, 428},
+	{"move_mount", 429, 429},
+	{"fsopen", 430, 430},
+	{"fsmount", 432, 432},
+	{"setns", 308, 268},
+	{"kexec_load", 246, 104},
+	{"kexec_file_load", 320, 294},
+	{"init_module", 175, 105},
+	{"finit_module", 313, 273},
+	{"delete_module", 176, 106},
+	{"bpf", 321, 280},
+	{"perf_event_open", 298, 241},
+	{"add_key", 248, 217},
+	{"request_key", 249, 218},
+	{"keyctl", 250, 219},
+	{"process_vm_readv", 310, 270},
+	{"process_vm_writev", 311, 271},
+	{"userfaultfd", 323, 282},
+	{"name_to_handle_at", 303, 264},
+	{"open_by_handle_at", 304, 265},
+	{"reboot", 169, 142},
+	{"swapon", 167, 224},
+	{"swapoff", 168, 225},
+	{"acct", 163, 89},
+	{"quotactl", 179, 60},
+	{"settimeofday", 164, 170},
+	{"clock_settime", 227, 112},
+	{"adjtimex", 159, 171},
+	{"clock_adjtime", 305, 266},
+	{"sethostname", 170, 161},
+	{"setdomainname", 171, 162},
+}
+
+// Sandbox runs the render tools with bubblewrap, since they render HTML the
+// model wrote. The tools run without network access, as a user of their own,
+// with a seccomp filter and without the environment of the service. They
+// only see their own render directory of the ones on the instance.
+type Sandbox struct {
+	binary string       // bubblewrap executable, a path or a name on the PATH
+	uid    int          // User and group the tools run as, 0 to keep the service's
+	filter []byte       // Compiled seccomp filter
+	fetch  assetFetcher // Downloads the images the tools can't load offline
+}
+
+// NewSandbox creates a sandbox running the tools with the bubblewrap
+// executable at path as uid. The service has to run as root to switch to
+// another user.
+func NewSandbox(path string, uid int) (*Sandbox, error) {
+	program, err := seccompProgram(runtime.GOARCH)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := bpf.Assemble(program)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble the seccomp filter: %v", err)
+	}
+	var filter bytes.Buffer
+	if err := binary.Write(&filter, binary.NativeEndian, raw); err != nil {
+		return nil, err
+	}
+	return &Sandbox{binary: path, uid: uid, filter: filter.Bytes(), fetch: fetchAsset}, nil
+}
+
+// Warm checks that bubblewrap is installed and can start a tool, the service
+// isn't ready to take tasks until it can
+func (s *Sandbox) Warm(ctx context.Context) error {
+	if _, err := exec.LookPath(s.binary); err != nil {
+		return fmt.Errorf("bubblewrap not found: %v", err)
+	}
+	dir, err := newWorkDir("", s)
+	if err != nil {
+		return err
+	}
+	defer dir.remove()
+	if err := dir.run(ctx, "true"); err != nil {
+		return fmt.Errorf("sandbox can't run tools: %v", err)
+	}
+	return nil
+}
+
+// seccompProgram returns the seccomp filter of an architecture, which kills
+// the tools making a denied system call or a call of another architecture
+func seccompProgram(arch string) ([]bpf.Instruction, error) {
+	audit, ok := seccompAudits[arch]
+	if !ok {
+		return nil, fmt.Errorf("the sandbox doesn't support %s", arch)
+	}
+	// Offsets in struct seccomp_data
+	program := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 4, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: audit, SkipTrue: 1},
+		bpf.RetConstant{Val: seccompKill},
+		bpf.LoadAbsolute{Off: 0, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpLessThan, Val: x32SyscallBit, SkipTrue: 1},
+		bpf.RetConstant{Val: seccompKill},
+	}
+	for _, call := range deniedSyscalls {
+		nr := call.amd64
+		if arch == "arm64" {
+			nr = call.arm64
+		}
+		program = append(program, bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: nr, SkipTrue: 1}, bpf.RetConstant{Val: seccompKill})
+	}
+	return append(program, bpf.RetConstant{Val: seccompAllow}), nil
+}
+
+// own gives the sandbox user a render directory, so the tools can write to it
+func (s *Sandbox) own(path string) error {
+	if s == nil || s.uid == 0 {
+		return nil
+	}
+	if err := os.Chown(path, s.uid, s.uid); err != nil {
+		log.Printf("Failed to give temp directory %s to the sandbox user: %v", path, err)
+		return err
+	}
+	return nil
+}
+
+// command returns the command running a tool in dir with the extra
+// environment variables. A nil sandbox runs it directly. The caller closes
+// the extra files of the command once it has run.
+func (s *Sandbox) command(ctx context.Context, dir string, env []string, name string, args ...string) (*exec.Cmd, error) {
+	if s == nil {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Dir = dir
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		return cmd, nil
+	}
+
+	// Mount an empty temporary directory over the ones holding render
+	// directories, and put back the other files the tools need, such as the
+	// packages of the Slidev project
+	sandboxArgs := []string{"--unshare-all", "--die-with-parent", "--new-session", "--cap-drop", "ALL",
+		"--ro-bind", "/", "/", "--dev", "/dev", "--tmpfs", "/dev/shm", "--proc", "/proc", "--tmpfs", os.TempDir()}
+	if parent := filepath.Dir(dir); parent != filepath.Clean(os.TempDir()) {
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			return nil, err
+		}
+		sandboxArgs = append(sandboxArgs, "--tmpfs", parent)
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), workDirPrefix) {
+				path := filepath.Join(parent, entry.Name())
+				sandboxArgs = append(sandboxArgs, "--ro-bind", path, path)
+			}
+		}
+	}
+	sandboxArgs = append(sandboxArgs, "--bind", dir, dir, "--chdir", dir, "--seccomp", "3", "--", name)
+
+	// bubblewrap reads the filter from the first extra file, file descriptor 3
+	filter, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(s.filter)
+	writer.Close()
+	if err != nil {
+		filter.Close()
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, s.binary, append(sandboxArgs, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append([]string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir, "TMPDIR=" + dir, "LANG=C.UTF-8"}, env...)
+	cmd.ExtraFiles = []*os.File{filter}
+	if s.uid != 0 {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(s.uid), Gid: uint32(s.uid)}}
+	}
+	return cmd, nil
+}
+
+// check returns ErrSandboxViolation if a tool failed because the seccomp
+// filter killed it, bubblewrap exits with 128 plus the signal of the tool
+func (s *Sandbox) check(name string, err error) error {
+	var exitErr *exec.ExitError
+	if s == nil || !errors.As(err, &exitErr) {
+		return err
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return err
+	}
+	if status.Signaled() && status.Signal() == syscall.SIGSYS || status.ExitStatus() == 128+int(syscall.SIGSYS) {
+		log.Printf("Sandbox violation: %s made a denied system call", name)
+		return ErrSandboxViolation
+	}
+	return err
+}
+
+// offline downloads the remote images of a deck and embeds them as data
+// URIs, since the tools have no network in the sandbox. Images that can't be
+// downloaded or embedded keep their URL and are left out of the render. A
+// nil sandbox returns the markdown unchanged.
+func (s *Sandbox) offline(ctx context.Context, markdown string) string {
+	if s == nil {
+		return markdown
+	}
+	embedded := make(map[string]string)
+	return remoteImagePattern.ReplaceAllStringFunc(markdown, func(match string) string {
+		groups := remoteImagePattern.FindStringSubmatch(match)
+		url := absoluteURL(groups[2])
+		if _, ok := embedded[url]; !ok {
+			embedded[url] = groups[2]
+			data, contentType, err := s.fetch(ctx, url)
+			switch {
+			case err != nil:
+				log.Printf("Failed to download image %s for the sandbox: %v", url, err)
+			case !offlineImageTypes[contentType]:
+				log.Printf("Image %s can't be embedded, unexpected content type %q", url, contentType)
+			default:
+				embedded[url] = "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+			}
+		}
+		return groups[1] + embedded[url]
+	})
+}
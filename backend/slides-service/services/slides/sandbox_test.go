@@ -0,0 +1,137 @@
+package slides
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/bpf"
+)
+
+func TestSeccompProgram(t *testing.T) {
+	program, err := seccompProgram("amd64")
+	if err != nil {
+		t.Fatalf("seccompProgram failed: %v", err)
+	}
+	vm, err := bpf.NewVM(program)
+	if err != nil {
+		t.Fatalf("invalid filter: %v", err)
+	}
+
+	// The VM loads big-endian words, the kernel native ones
+	tests := []struct {
+		name string
+		arch uint32
+		nr   uint32
+		want int
+	}{
+		{"read", 0xc000003e, 0, seccompAllow},
+		{"execve", 0xc000003e, 59, seccompAllow},
+		{"ptrace", 0xc000003e, 101, seccompKill},
+		{"mount", 0xc000003e, 165, seccompKill},
+		{"x32 read", 0xc000003e, x32SyscallBit, seccompKill},
+		{"i386 ptrace", 0x40000003, 26, seccompKill},
+	}
+	for _, test := range tests {
+		data := make([]byte, 64)
+		binary.BigEndian.PutUint32(data[0:], test.nr)
+		binary.BigEndian.PutUint32(data[4:], test.arch)
+		got, err := vm.Run(data)
+		if err != nil || got != test.want {
+			t.Errorf("%s: expected %#x, got %#x, %v", test.name, test.want, got, err)
+		}
+	}
+
+	if _, err := seccompProgram("mips"); err == nil {
+		t.Error("expected an error for an unsupported architecture")
+	}
+}
+
+func TestSandboxCommandHidesOtherRenders(t *testing.T) {
+	sandbox, err := NewSandbox("bwrap", 0)
+	if err != nil {
+		t.Skipf("no sandbox on this architecture: %v", err)
+	}
+	project := t.TempDir()
+	for _, name := range []string{"node_modules", workDirPrefix + "other"} {
+		if err := os.Mkdir(filepath.Join(project, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir, err := newWorkDir(project, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.remove()
+
+	cmd, err := sandbox.command(context.Background(), dir.path, []string{"CHROME_PATH=/usr/bin/chromium"}, "marp", "deck.md")
+	if err != nil {
+		t.Fatalf("command failed: %v", err)
+	}
+	defer cmd.ExtraFiles[0].Close()
+
+	args := strings.Join(cmd.Args, " ")
+	for _, want := range []string{"--unshare-all", "--tmpfs " + project, "--ro-bind " + filepath.Join(project, "node_modules"), "--bind " + dir.path + " " + dir.path, "-- marp deck.md"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected %q in %s", want, args)
+		}
+	}
+	if strings.Contains(args, workDirPrefix+"other") {
+		t.Errorf("expected the other render directory to be hidden, got %s", args)
+	}
+	if !slices.Contains(cmd.Env, "CHROME_PATH=/usr/bin/chromium") || slices.ContainsFunc(cmd.Env, func(v string) bool { return strings.HasPrefix(v, "GEMINI_API_KEY=") }) {
+		t.Errorf("expected only the tool environment, got %v", cmd.Env)
+	}
+}
+
+func TestSandboxCheckReportsViolations(t *testing.T) {
+	sandbox := &Sandbox{}
+	tests := []struct {
+		script    string
+		violation bool
+	}{
+		{"kill -SYS $$", true},
+		{"exit 159", true},
+		{"exit 1", false},
+	}
+	for _, test := range tests {
+		err := sandbox.check("sh", exec.Command("sh", "-c", test.script).Run())
+		if errors.Is(err, ErrSandboxViolation) != test.violation {
+			t.Errorf("%q: expected violation=%t, got %v", test.script, test.violation, err)
+		}
+	}
+
+	var unsandboxed *Sandbox
+	if err := unsandboxed.check("sh", exec.Command("sh", "-c", "kill -SYS $$").Run()); errors.Is(err, ErrSandboxViolation) {
+		t.Error("expected tools run without a sandbox to fail as they are")
+	}
+}
+
+func TestSandboxOfflineEmbedsImages(t *testing.T) {
+	sandbox := &Sandbox{fetch: func(ctx context.Context, url string) ([]byte, string, error) {
+		switch url {
+		case "https://example.com/chart.png":
+			return []byte("png"), "image/png", nil
+		case "https://example.com/logo.svg":
+			return []byte("<svg/>"), "image/svg+xml", nil
+		}
+		return nil, "", errors.New("not found")
+	}}
+
+	markdown := "![bg left:40%](https://example.com/chart.png)\n\n![Chart](https://example.com/chart.png)\n\n![Logo](https://example.com/logo.svg)\n\n![Missing](//example.com/missing.png)\n\n![Local](chart.png)"
+	want := "![bg left:40%](data:image/png;base64,cG5n)\n\n![Chart](data:image/png;base64,cG5n)\n\n![Logo](https://example.com/logo.svg)\n\n![Missing](//example.com/missing.png)\n\n![Local](chart.png)"
+	if got := sandbox.offline(context.Background(), markdown); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	var unsandboxed *Sandbox
+	if got := unsandboxed.offline(context.Background(), markdown); got != markdown {
+		t.Errorf("expected the markdown unchanged without a sandbox, got %q", got)
+	}
+}
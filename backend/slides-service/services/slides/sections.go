@@ -0,0 +1,219 @@
+package slides
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+const (
+	// maxDeckSections bounds the sections a long deck is written in, each
+	// section is a call to Gemini with the documents
+	maxDeckSections = 8
+
+	// sectionFill is the share of the output window a section is planned to
+	// use, so sections that run long still fit
+	sectionFill = 0.75
+)
+
+// deckOutputShares is the share of the input tokens projected to be written
+// as slides at each level of detail, for documents long enough that it
+// exceeds deckOutputTokens
+var deckOutputShares = map[string]float64{
+	"minimal":  0.05,
+	"medium":   0.1,
+	"detailed": 0.25,
+}
+
+// outlineSchema constrains the response of the outline pass to a deck outline
+var outlineSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"title": {Type: genai.TypeString},
+		"sections": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"title":  {Type: genai.TypeString},
+					"slides": {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+				},
+				Required: []string{"title", "slides"},
+			},
+		},
+	},
+	Required: []string{"title", "sections"},
+}
+
+// projectOutputTokens projects the tokens of the slides written from
+// inputTokens of documents and prompt, the medium level of detail applies
+// when none is set
+func projectOutputTokens(settings models.SlideSettings, inputTokens int) int {
+	detail := settings.SlideDetail
+	if _, ok := deckOutputTokens[detail]; !ok {
+		detail = "medium"
+	}
+	return max(deckOutputTokens[detail], int(float64(inputTokens)*deckOutputShares[detail]))
+}
+
+// deckSections returns how many sections a deck projected to take
+// outputTokens is written in, 1 when it fits in one output window
+func deckSections(outputTokens, maxOutputTokens int) int {
+	if outputTokens <= maxOutputTokens {
+		return 1
+	}
+	window := max(1, int(float64(maxOutputTokens)*sectionFill))
+	return min(maxDeckSections, (outputTokens+window-1)/window)
+}
+
+// generateInSections writes a deck too long for one output window in
+// sections: an outline of the whole deck first, then the slides of each
+// section with the outline as shared context, stitched into one deck. The
+// outline and finished sections are saved in the checkpoint, so a retry
+// resumes with the next section. With an index of the documents, each section
+// is written from the passages retrieved for it rather than the documents.
+func (s *SlideService) generateInSections(
+	ctx context.Context,
+	parts []genai.Part,
+	index *passageIndex,
+	topic string,
+	settings models.SlideSettings,
+	limits models.TokenLimits,
+	sections int,
+	checkpoint *Checkpoint,
+	statusUpdateFn func(stage Stage, status Status) error,
+	saveCheckpointFn func(checkpoint *Checkpoint) error,
+) (string, error) {
+	documents, prompt := parts[:len(parts)-1], string(parts[len(parts)-1].(genai.Text))
+
+	if checkpoint.Outline == nil {
+		if err := statusUpdateFn(StageProcessing, NewStatus(StatusPlanningSections, "parts", strconv.Itoa(sections))); err != nil {
+			return "", err
+		}
+		outline, err := s.generateOutline(ctx, documents, topic, settings, sections)
+		if err != nil {
+			return "", fmt.Errorf("failed to plan presentation: %v", err)
+		}
+		checkpoint.Outline = outline
+		checkpoint.Sections = nil
+		if err := saveCheckpointFn(checkpoint); err != nil {
+			log.Printf("Warning: Failed to save checkpoint: %v", err)
+		}
+	} else {
+		log.Printf("Resuming from checkpoint with %d of %d sections written", len(checkpoint.Sections), len(checkpoint.Outline.Sections))
+	}
+
+	outline := *checkpoint.Outline
+	model := s.generationModel(limits)
+	for i := len(checkpoint.Sections); i < len(outline.Sections); i++ {
+		if err := statusUpdateFn(StageProcessing, NewStatus(StatusWritingSection, "part", strconv.Itoa(i+1), "parts", strconv.Itoa(len(outline.Sections)), "title", outline.Sections[i].Title)); err != nil {
+			return "", err
+		}
+		sectionPrompt, err := prompts.GenerateSectionPrompt(prompt, outline, i)
+		if err != nil {
+			return "", err
+		}
+		// Later sections are asked for less text when the sections written
+		// so far ran off the page
+		if i > 0 {
+			report := measureDensity(stitchSections(checkpoint.Sections))
+			note, err := densityNote(len(report.Overflowing), report.Slides, settings)
+			if err != nil {
+				return "", err
+			}
+			sectionPrompt += note
+		}
+
+		// Only the first section starts with the frontmatter
+		generateCtx, cancel := context.WithTimeout(ctx, generationTimeout)
+		sectionDocuments := documents
+		if index != nil {
+			sectionDocuments = s.retrieveSection(generateCtx, index, outline, i, sectionPrompt, limits, documents)
+		}
+		markdown, err := s.generateValidMarkdown(generateCtx, model, sectionDocuments, sectionPrompt, i == 0)
+		cancel()
+		if errors.Is(err, errInvalidResponse) || errors.Is(err, errOutputLimit) {
+			log.Printf("Failed to generate part %d: %v", i+1, err)
+			return "", errors.New("failed to generate presentation. Please try again.")
+		}
+		if err != nil {
+			log.Printf("Failed to generate part %d: %v", i+1, err)
+			return "", err
+		}
+
+		checkpoint.Sections = append(checkpoint.Sections, markdown)
+		if err := saveCheckpointFn(checkpoint); err != nil {
+			log.Printf("Warning: Failed to save checkpoint: %v", err)
+		}
+	}
+
+	return stitchSections(checkpoint.Sections), nil
+}
+
+// generateOutline plans a deck in the given number of sections with a
+// structured-output pass over the documents, or the topic when there are none
+func (s *SlideService) generateOutline(ctx context.Context, documents []genai.Part, topic string, settings models.SlideSettings, sections int) (*models.DeckOutline, error) {
+	prompt, err := prompts.GenerateOutlinePrompt(settings, topic, sections)
+	if err != nil {
+		return nil, err
+	}
+
+	generateCtx, cancel := context.WithTimeout(ctx, generationTimeout)
+	defer cancel()
+	model := newGenerativeModel(s.client, 4096)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = outlineSchema
+	resp, err := generateContent(generateCtx, model, ExchangeOutline, append(documents[:len(documents):len(documents)], genai.Text(prompt))...)
+	if err != nil {
+		return nil, timeoutError(generateCtx, err)
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, errors.New("empty response")
+	}
+	text, _ := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	return parseOutline(string(text))
+}
+
+// parseOutline parses the JSON outline returned by Gemini, dropping sections
+// without slides
+func parseOutline(text string) (*models.DeckOutline, error) {
+	var outline models.DeckOutline
+	if err := json.Unmarshal([]byte(text), &outline); err != nil {
+		return nil, fmt.Errorf("invalid outline: %v", err)
+	}
+	sections := outline.Sections[:0]
+	for _, section := range outline.Sections {
+		if strings.TrimSpace(section.Title) != "" && len(section.Slides) > 0 {
+			sections = append(sections, section)
+		}
+	}
+	outline.Sections = sections
+	if len(outline.Sections) == 0 {
+		return nil, errors.New("no sections found")
+	}
+	return &outline, nil
+}
+
+// stitchSections joins the markdown of the sections of a deck under the
+// frontmatter of the first one. Frontmatter the later sections were written
+// with anyway is dropped.
+func stitchSections(sections []string) string {
+	frontmatter, slides := splitSlides(sections[0])
+	for _, section := range sections[1:] {
+		_, sectionSlides := splitSlides(section)
+		for _, slide := range sectionSlides {
+			if strings.TrimSpace(slide) != "" {
+				slides = append(slides, slide)
+			}
+		}
+	}
+	return joinSlides(frontmatter, slides)
+}
@@ -0,0 +1,68 @@
+package slides
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+func TestDeckSections(t *testing.T) {
+	tests := []struct {
+		detail      string
+		inputTokens int
+		maxOutput   int
+		expected    int
+	}{
+		{"detailed", 16384, 4096, 1},
+		{"minimal", 200000, 4096, 4},
+		{"detailed", 65536, 4096, 6},
+		{"detailed", 65536, 16384, 1},
+		{"detailed", 70000, 16384, 2},
+		{"", 1000000, 4096, maxDeckSections},
+	}
+
+	for _, test := range tests {
+		projected := projectOutputTokens(models.SlideSettings{SlideDetail: test.detail}, test.inputTokens)
+		if sections := deckSections(projected, test.maxOutput); sections != test.expected {
+			t.Errorf("%q with %d input tokens: expected %d sections, got %d", test.detail, test.inputTokens, test.expected, sections)
+		}
+	}
+}
+
+func TestParseOutline(t *testing.T) {
+	outline, err := parseOutline(`{"title":"Annual Report","sections":[{"title":"Results","slides":["Revenue","Costs"]},{"title":"Empty","slides":[]},{"title":"Outlook","slides":["2027"]}]}`)
+	if err != nil {
+		t.Fatalf("parseOutline failed: %v", err)
+	}
+	if outline.Title != "Annual Report" || len(outline.Sections) != 2 || outline.Sections[1].Title != "Outlook" {
+		t.Errorf("expected the sections with slides, got %+v", outline)
+	}
+	if _, err := parseOutline(`{"title":"Annual Report","sections":[]}`); err == nil {
+		t.Error("expected an error for an outline without sections")
+	}
+}
+
+func TestStitchSections(t *testing.T) {
+	sections := []string{
+		"---\nmarp: true\ntheme: gaia\n---\n\n# Annual Report\n\n---\n\n## Revenue\n",
+		"## Costs\n\n- Down 4%\n\n---\n\n## Headcount\n",
+		"---\nmarp: true\n---\n\n## Outlook\n",
+	}
+
+	got := stitchSections(sections)
+	if strings.Count(got, "marp: true") != 1 {
+		t.Errorf("expected the frontmatter of the first section only, got:\n%s", got)
+	}
+	frontmatter, slides := splitSlides(got)
+	if !strings.Contains(frontmatter, "theme: gaia") {
+		t.Errorf("expected the frontmatter of the first section, got %q", frontmatter)
+	}
+	var titles []string
+	for _, slide := range slides {
+		titles = append(titles, slideTitle(strings.Split(slide, "\n")))
+	}
+	if strings.Join(titles, ",") != "# Annual Report,## Revenue,## Costs,## Headcount,## Outlook" {
+		t.Errorf("expected the slides of every section in order, got %v", titles)
+	}
+}
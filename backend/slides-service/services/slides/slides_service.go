@@ -3,232 +3,772 @@ package slides
 import (
 	"context"
 	"errors"
-	"io"
+	"fmt"
 	"log"
-	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
-	
+	"time"
+
 	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
 	"github.com/martin226/slideitin/backend/slides-service/models"
 	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
-	"bytes"
+	"google.golang.org/api/option"
+)
+
+const (
+	// uploadTimeout bounds uploading the source files to Gemini
+	uploadTimeout = 2 * time.Minute
+
+	// generationTimeout bounds counting tokens and generating the slide markdown
+	generationTimeout = 5 * time.Minute
+
+	// renderTimeout bounds rendering the PDF and HTML with Marp
+	renderTimeout = 3 * time.Minute
 )
 
+// ErrTimeout is returned when a stage of slide generation exceeds its deadline
+var ErrTimeout = errors.New("generation timed out")
+
 // SlideService handles interactions with the Gemini API
 type SlideService struct {
-	client *genai.Client
-	model *genai.GenerativeModel
+	client      *genai.Client
+	model       *genai.GenerativeModel
+	renderer    Renderer
+	fileCache   FileCache     // Optional, reuses the Gemini files of documents submitted before
+	themes      ThemeRegistry // Optional, loads the themes contributed at runtime
+	density     DensityStore  // Optional, tightens the prompts of detail levels whose slides run off the page
+	embedder    Embedder      // Embeds the passages of documents far over the input budget, words are matched without it
+	renders     *limiter
+	generations *limiter
+	tokenLimits models.TokenLimits // Token limits of jobs whose plan doesn't override them
+}
+
+// Presentation holds the rendered output of a slide generation job
+type Presentation struct {
+	PDFData             []byte
+	HTMLData            []byte
+	ViewerHTML          []byte // HTML for the sandboxed viewer, which loads nothing from outside the page
+	Thumbnail           []byte // PNG of the first slide, previewed in job lists and share links
+	Markdown            string // Slide markdown before rendering, kept as a revision of the deck
+	AccessibilityReport *AccessibilityReport
+	Flashcards          []Flashcard          // Terms of the sources, extracted when enabled in the settings
+	OnePager            string               // Markdown of the executive summary of the sources, written when enabled in the settings
+	OnePagerPDF         []byte               // The executive summary rendered on one page, nil if it couldn't be rendered
+	Handout             string               // Markdown of the student handout of an instructor deck, written in instructor mode
+	HandoutPDF          []byte               // The student handout rendered with the theme of the deck, nil if it couldn't be rendered
+	Alignment           *TranscriptAlignment // Times in the source recordings the slides cover, nil without transcripts
+	ChunkSummaries      []ChunkSummary       // Summaries of the sections of long documents, kept when enabled in the settings
+	Grounding           *GroundingReport     // Verdicts on the bullet points checked against the sources, nil unless enabled in the settings
+	Warnings            []string             // Problems that didn't stop generation, such as omitted content
 }
 
-// NewSlideService creates a new Slide service
-func NewSlideService(apiKey string) *SlideService {
+// Stage is the stage of generation a status update belongs to, which is the
+// status the job moves to
+type Stage string
+
+const (
+	StageUploading  Stage = "uploading"  // Uploading the documents to Gemini
+	StageProcessing Stage = "processing" // Writing the slides
+	StageRendering  Stage = "rendering"  // Rendering the slides
+)
+
+// Checkpoint holds the intermediate artifacts of a job so a retried task can
+// resume after the last successful stage
+type Checkpoint struct {
+	GeminiFiles    []GeminiFile        `firestore:"geminiFiles,omitempty"`
+	Markdown       string              `firestore:"markdown,omitempty"`
+	Flashcards     []Flashcard         `firestore:"flashcards,omitempty"`
+	OnePager       string              `firestore:"onePager,omitempty"`
+	Handout        string              `firestore:"handout,omitempty"` // Student handout of an instructor deck
+	Warnings       []string            `firestore:"warnings,omitempty"`
+	ChunkSummaries []ChunkSummary      `firestore:"chunkSummaries,omitempty"` // Summaries of the sections that didn't fit, kept when the settings ask to
+	Grounding      *GroundingReport    `firestore:"grounding,omitempty"`      // Bullet points checked against the documents, when the settings ask to
+	Outline        *models.DeckOutline `firestore:"outline,omitempty"`        // Plan of a deck written in sections
+	Sections       []string            `firestore:"sections,omitempty"`       // Markdown of the sections written so far
+}
+
+// GeminiFile is a source file uploaded to Gemini. The checkpoint holds one per
+// source file in order, with an empty URI for files that are inlined as text.
+type GeminiFile struct {
+	Name   string `firestore:"name"`
+	URI    string `firestore:"uri"`
+	Shared bool   `firestore:"shared,omitempty"` // Cached for other jobs, so it isn't deleted after use
+}
+
+// NewSlideService creates a new Slide service that runs at most as many renders
+// and Gemini generations at once as the limits allow, with their token limits
+// for jobs
+func NewSlideService(apiKey string, renderer Renderer, fileCache FileCache, themes ThemeRegistry, density DensityStore, limits Limits) *SlideService {
 	ctx := context.Background()
 	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
 	if err != nil {
 		log.Fatalf("Failed to create Gemini client: %v", err)
 	}
-	model := client.GenerativeModel("gemini-1.5-flash")
-	model.SetMaxOutputTokens(4096)
+	tokenLimits := models.TokenLimits{Input: limits.InputTokens, Output: limits.OutputTokens}
+	if tokenLimits.Input <= 0 {
+		tokenLimits.Input = defaultMaxInputTokens
+	}
+	if tokenLimits.Output <= 0 {
+		tokenLimits.Output = defaultMaxOutputTokens
+	}
 	return &SlideService{
-		client: client,
-		model: model,
+		client:      client,
+		model:       newGenerativeModel(client, tokenLimits.Output),
+		renderer:    renderer,
+		fileCache:   fileCache,
+		themes:      themes,
+		density:     density,
+		embedder:    &geminiEmbedder{client: client},
+		renders:     newLimiter(limits.Renders),
+		generations: newLimiter(limits.Generations),
+		tokenLimits: tokenLimits,
 	}
 }
 
-// GenerateSlides creates a presentation based on the provided theme, files, and settings
+// GenerateSlides creates a presentation based on the provided theme, files, and settings,
+// or on the topic when there are no files
 func (s *SlideService) GenerateSlides(
-	ctx context.Context, 
-	theme string, 
+	ctx context.Context,
+	theme string,
+	topic string,
 	files []models.File,
 	settings models.SlideSettings,
-	statusUpdateFn func(message string) error,
-) ([]byte, []byte, error) {
-	// Update status to show we're processing the files
-	if err := statusUpdateFn("Analyzing uploaded files"); err != nil {
-		return nil, nil, err
+	checkpoint *Checkpoint,
+	statusUpdateFn func(stage Stage, status Status) error,
+	saveCheckpointFn func(checkpoint *Checkpoint) error,
+) (*Presentation, error) {
+	if checkpoint == nil {
+		checkpoint = &Checkpoint{}
 	}
 
-	geminiFiles := make([]*genai.File, 0, len(files))
-	// Process files by creating readers from the stored data when needed
-	// This ensures the file data is available even after the HTTP request finishes
-	for _, file := range files {
-		fileReader := io.NopCloser(bytes.NewReader(file.Data))
-		
-		// Upload the file to Gemini
-		geminiFile, err := s.client.UploadFile(ctx, "", fileReader, &genai.UploadFileOptions{
-			DisplayName: file.Filename,
-			MIMEType: file.Type,
-		})
+	// Skip the Gemini stages if a previous attempt already generated the markdown
+	marpText := checkpoint.Markdown
+	if marpText != "" {
+		log.Printf("Resuming from checkpoint with generated markdown")
+	} else {
+		var err error
+		marpText, err = s.generateMarkdown(ctx, theme, topic, files, settings, checkpoint, statusUpdateFn, saveCheckpointFn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	presentation, err := s.renderPresentation(ctx, theme, marpText, files, settings, statusUpdateFn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Render the executive summary and student handout, which are still
+	// available as markdown if they fail
+	presentation.Flashcards = checkpoint.Flashcards
+	presentation.ChunkSummaries = checkpoint.ChunkSummaries
+	presentation.Grounding = checkpoint.Grounding
+	presentation.Warnings = append(append([]string{}, checkpoint.Warnings...), presentation.Warnings...)
+	if checkpoint.OnePager != "" {
+		presentation.OnePager = checkpoint.OnePager
+		presentation.OnePagerPDF, err = s.RenderOnePager(ctx, checkpoint.OnePager)
+		if err != nil {
+			log.Printf("Failed to render the executive summary: %v", err)
+			presentation.Warnings = append(presentation.Warnings, "The executive summary couldn't be rendered as PDF, it is available as markdown")
+		}
+	}
+	if checkpoint.Handout != "" {
+		presentation.Handout = checkpoint.Handout
+		presentation.HandoutPDF, err = s.renderHandout(ctx, theme, checkpoint.Handout, files, settings)
 		if err != nil {
-			log.Printf("Failed to upload file to Gemini: %v", err)
-			return nil, nil, err
+			log.Printf("Failed to render the student handout: %v", err)
+			presentation.Warnings = append(presentation.Warnings, "The student handout couldn't be rendered as PDF, it is available as markdown")
+		}
+	}
+
+	// Delete the files from Gemini
+	if retained := s.deleteGeminiFiles(ctx, checkpoint.GeminiFiles); retained > 0 {
+		presentation.Warnings = append(presentation.Warnings, fmt.Sprintf("%d uploaded files couldn't be confirmed deleted from Gemini, which deletes them within 48 hours", retained))
+	}
+	return presentation, nil
+}
+
+// renderPresentation checks and lays out the generated markdown and renders the
+// PDF and HTML. Images in the markdown must come from the source files.
+func (s *SlideService) renderPresentation(
+	ctx context.Context,
+	theme string,
+	marpText string,
+	files []models.File,
+	settings models.SlideSettings,
+	statusUpdateFn func(stage Stage, status Status) error,
+) (*Presentation, error) {
+	// Remove the HTML that could run scripts or load resources, before it reaches the renderer or the HTML
+	marpText, removedHTML := sanitizeMarkdown(marpText)
+	if removedHTML > 0 {
+		log.Printf("Removed %d unsafe HTML elements and attributes from the generated markdown", removedHTML)
+	}
+
+	// Run the accessibility checks on the generated markdown
+	var accessibilityReport *AccessibilityReport
+	if settings.Accessibility {
+		accessibilityReport = &AccessibilityReport{Theme: theme}
+		marpText = addMissingAltText(marpText, accessibilityReport)
+	}
+
+	// Normalize page annotations so claims can be traced back to the source
+	if settings.IncludeCitations {
+		marpText = annotateSourcePages(marpText)
+	}
+
+	// Pick the slide classes from the content when the deck has a layout style
+	marpText = applyLayoutStyle(marpText, theme, settings.LayoutStyle)
+
+	// Drop layout directives the themes can't render
+	marpText = applyLayouts(marpText, files)
+
+	// Continue tables that are too long for one slide on the next slides
+	marpText = splitLargeTables(marpText)
+
+	// Keep the markdown before the rendering directives are added, refinements start from it
+	markdown := marpText
+
+	// Map the slides to the recordings they were made from
+	alignment := alignTranscripts(markdown, files)
+
+	// Render LaTeX math from the sources with MathJax
+	marpText = applyMath(marpText)
+
+	log.Printf("Generated presentation: %s", marpText)
+
+	// Update status to show we're finalizing the presentation
+	if err := statusUpdateFn(StageRendering, NewStatus(StatusFinalizing)); err != nil {
+		return nil, err
+	}
+
+	// Add the title and language metadata needed for accessible PDFs
+	marpText = applyDocumentMetadata(marpText, settings.Language)
+
+	// Stamp the confidentiality footer and watermark on every slide
+	marpText = applyWatermark(marpText, settings)
+
+	// Load the theme stylesheet if it's bundled or contributed
+	var warnings []string
+	renderOptions, warning := s.themeOptions(ctx, theme)
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+	renderOptions.Engine = settings.Renderer
+	renderOptions.Footer = expandDatePlaceholder(settings.Footer)
+	renderOptions.Watermark = expandDatePlaceholder(settings.Watermark)
+	renderOptions.PDFNotes = settings.InstructorMode
+
+	if accessibilityReport != nil {
+		// Render with a copy of the theme whose font sizes meet the minimum
+		if patchedCSS := checkThemeAccessibility(theme, renderOptions.ThemeCSS, accessibilityReport); patchedCSS != "" {
+			renderOptions.ThemeCSS = patchedCSS
+		}
+		accessibilityReport.finalize()
+		log.Printf("Accessibility report: passed=%t, alt text added=%d, font sizes fixed=%d",
+			accessibilityReport.Passed, len(accessibilityReport.AltTextAdded), len(accessibilityReport.FontSizeFixes))
+	}
+
+	// Embed the fonts of the settings and theme, so the PDF doesn't fall back to system fonts
+	fontCSS, fontWarnings := s.embedFonts(ctx, &renderOptions, settings, usesIcons(marpText), fetchAsset)
+	warnings = append(warnings, fontWarnings...)
+	marpText = insertStyle(marpText, fontCSS)
+
+	// Wait for a free renderer, Chromium needs most of the instance's memory
+	release, err := s.renders.acquire(ctx, func() error {
+		return statusUpdateFn(StageRendering, NewStatus(StatusWaitingForRenderer))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Render the PDF and HTML
+	renderCtx, cancelRender := context.WithTimeout(ctx, renderTimeout)
+	defer cancelRender()
+	output, err := s.renderer.Render(renderCtx, marpText, renderOptions)
+	release()
+	if err != nil {
+		return nil, timeoutError(renderCtx, err)
+	}
+
+	// Bundle the images into a copy of the HTML that previews safely, the
+	// result is still usable without it
+	viewerHTML, err := buildViewer(ctx, output.HTMLData, fetchAsset)
+	if err != nil {
+		log.Printf("Failed to build the viewer: %v", err)
+	}
+
+	// Return the PDF and HTML bytes
+	return &Presentation{
+		PDFData:             output.PDFData,
+		HTMLData:            output.HTMLData,
+		ViewerHTML:          viewerHTML,
+		Thumbnail:           output.Thumbnail,
+		Markdown:            markdown,
+		AccessibilityReport: accessibilityReport,
+		Alignment:           alignment,
+		Warnings:            warnings,
+	}, nil
+}
+
+// generateMarkdown uploads the files to Gemini and generates the slide markdown,
+// saving a checkpoint after each stage
+func (s *SlideService) generateMarkdown(
+	ctx context.Context,
+	theme string,
+	topic string,
+	files []models.File,
+	settings models.SlideSettings,
+	checkpoint *Checkpoint,
+	statusUpdateFn func(stage Stage, status Status) error,
+	saveCheckpointFn func(checkpoint *Checkpoint) error,
+) (string, error) {
+	// Update status to show we're processing the files
+	stage, status := StageUploading, NewStatus(StatusAnalyzingFiles)
+	if len(files) == 0 {
+		stage, status = StageProcessing, NewStatus(StatusPlanning)
+	}
+	if err := statusUpdateFn(stage, status); err != nil {
+		return "", err
+	}
+
+	// Warnings from an attempt that didn't finish are raised again below
+	checkpoint.Warnings = nil
+	checkpoint.ChunkSummaries = nil
+	checkpoint.Grounding = nil
+	checkpoint.Flashcards = nil
+	checkpoint.OnePager = ""
+	checkpoint.Handout = ""
+
+	uploadCtx, cancelUpload := context.WithTimeout(ctx, uploadTimeout)
+	defer cancelUpload()
+
+	// Reuse the files uploaded by a previous attempt if Gemini still has them
+	if s.filesAvailable(uploadCtx, checkpoint.GeminiFiles, len(files)) {
+		log.Printf("Resuming from checkpoint with %d uploaded files", len(checkpoint.GeminiFiles))
+	} else {
+		geminiFiles := make([]GeminiFile, 0, len(files))
+		// Process files by creating readers from the stored data when needed
+		// This ensures the file data is available even after the HTTP request finishes
+		for _, file := range files {
+			// Plain text and markdown are inlined in the prompt, only PDFs need the File API
+			if file.Type != "application/pdf" {
+				log.Printf("Inlining file: %s (%s)", file.Filename, file.Type)
+				geminiFiles = append(geminiFiles, GeminiFile{})
+				continue
+			}
+
+			// Upload the file to Gemini
+			geminiFile, err := s.uploadFile(uploadCtx, file)
+			if err != nil {
+				if errors.Is(uploadCtx.Err(), context.DeadlineExceeded) {
+					return "", timeoutError(uploadCtx, err)
+				}
+				// Fall back to inlining the extracted text in the prompt
+				log.Printf("Failed to upload file to Gemini, falling back to local text extraction: %v", err)
+				geminiFiles = append(geminiFiles, GeminiFile{})
+				continue
+			}
+			geminiFiles = append(geminiFiles, geminiFile)
+			log.Printf("Processing file: %s (%s)", file.Filename, file.Type)
+		}
+
+		// Save the uploaded files so a retry doesn't upload them again
+		checkpoint.GeminiFiles = geminiFiles
+		if err := saveCheckpointFn(checkpoint); err != nil {
+			log.Printf("Warning: Failed to save checkpoint: %v", err)
 		}
-		geminiFiles = append(geminiFiles, geminiFile)
-		log.Printf("Processing file: %s (%s)", file.Filename, file.Type)
 	}
 
 	// Update status to show we're generating the prompt
-	if err := statusUpdateFn("Generating content for slides"); err != nil {
-		return nil, nil, err
+	if err := statusUpdateFn(StageProcessing, NewStatus(StatusGeneratingContent)); err != nil {
+		return "", err
+	}
+
+	// 2. Generate the prompt using the prompt generator, decks without files are written from the topic.
+	// Fewer bullet points are asked for when decks of the detail level have been running off the page.
+	settings = s.tuneDensity(ctx, settings)
+	var prompt string
+	var err error
+	if topic != "" {
+		prompt, err = prompts.GenerateTopicPrompt(theme, settings, topic)
+	} else {
+		prompt, err = prompts.GenerateSlidePrompt(theme, settings, files)
 	}
-	
-	// 2. Generate the prompt using the prompt generator
-	prompt, err := prompts.GenerateSlidePrompt(theme, settings)
 	if err != nil {
 		log.Printf("Error generating prompt: %v", err)
-		return nil, nil, err
+		return "", err
 	}
 	log.Printf("Prompt: %s", prompt)
-	
+
 	// Update status to show we're sending to Gemini
-	if err := statusUpdateFn("Creating presentation with AI"); err != nil {
-		return nil, nil, err
+	if err := statusUpdateFn(StageProcessing, NewStatus(StatusCreatingPresentation)); err != nil {
+		return "", err
 	}
-	
-	// 3. Send the prompt to Gemini
+
+	// 3. Send the prompt to Gemini, files that can't be read are left out as
+	// long as others can
 	parts := []genai.Part{}
-	for _, file := range geminiFiles {
-		parts = append(parts, genai.FileData{URI: file.URI})
+	readable := make([]models.File, 0, len(files))
+	for i, file := range files {
+		// Documents with text that reads as instructions to the model are
+		// inlined without it, instead of sent as they were uploaded
+		if settings.InjectionDetection {
+			if text, found := guardDocument(ctx, file); found {
+				warning := injectionWarning(file.Filename)
+				log.Printf("%s", warning)
+				checkpoint.Warnings = append(checkpoint.Warnings, warning)
+				parts = append(parts, genai.Text(inlineDocument(file.Filename, text)))
+				readable = append(readable, file)
+				continue
+			}
+		}
+		if uri := checkpoint.GeminiFiles[i].URI; uri != "" {
+			parts = append(parts, genai.FileData{URI: uri})
+			readable = append(readable, file)
+			continue
+		}
+		text, err := extractText(ctx, file)
+		if err != nil {
+			log.Printf("Failed to extract text from %s: %v", file.Filename, err)
+			checkpoint.Warnings = append(checkpoint.Warnings, unreadableWarning(file.Filename))
+			continue
+		}
+		parts = append(parts, genai.Text(inlineDocument(file.Filename, text)))
+		readable = append(readable, file)
+	}
+	if len(files) > 0 && len(readable) == 0 {
+		return "", errors.New("none of the files could be read")
 	}
 	parts = append(parts, genai.Text(prompt))
 
-	// Ensure input tokens do not exceed 16384
-	countResp, err := s.model.CountTokens(ctx, parts...)
+	// Wait for a free Gemini slot, held for the summaries and the generation
+	release, err := s.generations.acquire(ctx, func() error {
+		return statusUpdateFn(StageProcessing, NewStatus(StatusWaitingForGeneration))
+	})
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	generateCtx, cancelGenerate := context.WithTimeout(ctx, generationTimeout)
+	defer cancelGenerate()
+
+	// Ensure input tokens do not exceed the budget, dropping the least relevant sections if needed
+	limits := s.jobTokenLimits(settings)
+	countResp, err := s.model.CountTokens(generateCtx, parts...)
 	if err != nil {
 		log.Printf("Failed to count tokens: %v", err)
-		return nil, nil, err
+		return "", timeoutError(generateCtx, err)
 	}
-	if countResp.TotalTokens > 16384 {
-		log.Printf("Input tokens exceed 16384: %d", countResp.TotalTokens)
-		return nil, nil, errors.New("documents are too large to process")
+	// Documents far over the budget are indexed, so each section of the deck
+	// is written from the passages retrieved for it. The trimmed documents
+	// still plan the deck. Library documents were indexed when they were
+	// added, so they are retrieved from as soon as they are over the budget.
+	var index *passageIndex
+	overBudget := int(countResp.TotalTokens) > limits.Input
+	if len(readable) > 0 && (int(countResp.TotalTokens) > retrievalRatio*limits.Input || (overBudget && libraryIndexed(readable))) {
+		index, err = s.indexDocuments(generateCtx, readable, settings, statusUpdateFn)
+		if err != nil {
+			if errors.Is(generateCtx.Err(), context.DeadlineExceeded) {
+				return "", timeoutError(generateCtx, err)
+			}
+			log.Printf("Failed to index the documents, writing the deck from the trimmed documents: %v", err)
+			index = nil
+		}
 	}
+	if overBudget {
+		log.Printf("Input tokens exceed %d: %d", limits.Input, countResp.TotalTokens)
+		var summarized []ChunkSummary
+		var omitted, unreadable []string
+		parts, summarized, omitted, unreadable, err = s.fitTokenBudget(generateCtx, readable, prompt, limits.Input, settings, statusUpdateFn)
+		if err != nil {
+			log.Printf("Failed to fit documents in the token budget: %v", err)
+			return "", timeoutError(generateCtx, err)
+		}
 
-	resp, err := s.model.GenerateContent(ctx, parts...)
-	if err != nil {
-		log.Printf("Failed to generate content: %v", err)
-		return nil, nil, err
+		var warnings []string
+		for _, filename := range unreadable {
+			warnings = append(warnings, unreadableWarning(filename))
+		}
+		if index != nil {
+			// Nothing is left out of the slides, each part draws on all of the documents
+			warnings = append(warnings, "Documents were too long to send at once, so each part of the deck was written from the passages most relevant to it")
+		} else if len(summarized) > 0 {
+			labels := make([]string, 0, len(summarized))
+			for _, summary := range summarized {
+				labels = append(labels, summary.Section)
+			}
+			warnings = append(warnings, fmt.Sprintf("Documents were too long, so these sections were summarized: %s", strings.Join(labels, ", ")))
+			if settings.ChunkSummaries {
+				checkpoint.ChunkSummaries = summarized
+			}
+		}
+		if len(omitted) > 0 && index == nil {
+			warnings = append(warnings, fmt.Sprintf("Documents were too long, so these sections were left out: %s", strings.Join(omitted, ", ")))
+		}
+		for _, warning := range warnings {
+			log.Printf("%s", warning)
+			checkpoint.Warnings = append(checkpoint.Warnings, warning)
+			if err := statusUpdateFn(StageProcessing, TextStatus(warning)); err != nil {
+				return "", err
+			}
+		}
 	}
 
-	respText := resp.Candidates[0].Content.Parts[0].(genai.Text)
-	// Extract the markdown from the response between triple backticks
-	// Match any language specifier or none at all
-	respString := string(respText)
-	marpText := extractMarkdownContent(respString)
-	
-	if marpText == "" {
-		log.Printf("No markdown found in response: %s", respText)
-		return nil, nil, errors.New("failed to generate presentation. Please try again.")
+	// Decks projected to take more than one output window are written in
+	// sections, as are decks retrieving passages, which draw on all of the
+	// documents
+	inputTokens := min(int(countResp.TotalTokens), limits.Input)
+	if index != nil {
+		inputTokens = int(countResp.TotalTokens)
+	}
+	sections := deckSections(projectOutputTokens(settings, inputTokens), limits.Output)
+	if index != nil {
+		sections = max(sections, 2)
+	}
+	if checkpoint.Outline != nil {
+		sections = len(checkpoint.Outline.Sections)
+	}
+	var marpText string
+	if sections == 1 {
+		marpText, err = s.generateValidMarkdown(generateCtx, s.generationModel(limits), parts[:len(parts)-1], prompt, true)
+		switch {
+		case errors.Is(err, errOutputLimit):
+			// A deck cut off at the output limit is written again in sections
+			log.Printf("Response reached the output limit of %d tokens, writing the deck in sections", limits.Output)
+			sections = 2
+		case errors.Is(err, errInvalidResponse):
+			log.Printf("Failed to generate content: %v", err)
+			return "", errors.New("failed to generate presentation. Please try again.")
+		case err != nil:
+			log.Printf("Failed to generate content: %v", err)
+			return "", err
+		default:
+			marpText = s.fitDensity(generateCtx, s.generationModel(limits), parts[:len(parts)-1], prompt, marpText, settings)
+		}
+	}
+	if sections > 1 {
+		marpText, err = s.generateInSections(ctx, parts, index, topic, settings, limits, sections, checkpoint, statusUpdateFn, saveCheckpointFn)
+		if err != nil {
+			return "", err
+		}
+	}
+	// Take out the slides and bullet points the model repeated
+	marpText, repetition := removeRepetition(marpText)
+	if warning := repetition.warning(); warning != "" {
+		log.Printf("%s", warning)
+		checkpoint.Warnings = append(checkpoint.Warnings, warning)
+	}
+	if warning := s.recordDensity(ctx, settings.SlideDetail, measureDensity(marpText)); warning != "" {
+		log.Printf("%s", warning)
+		checkpoint.Warnings = append(checkpoint.Warnings, warning)
 	}
 
-	log.Printf("Generated presentation: %s", marpText)
-	
-	// Update status to show we're finalizing the presentation
-	if err := statusUpdateFn("Finalizing presentation"); err != nil {
-		return nil, nil, err
+	// Extract the flashcards and write the executive summary from the same
+	// documents, decks written from a topic have only themselves to draw on.
+	// The deck is finished without them.
+	documents := parts[:len(parts)-1]
+	if len(files) == 0 {
+		documents = []genai.Part{genai.Text(inlineDocument("presentation.md", marpText))}
+	}
+	if settings.Flashcards {
+		if err := statusUpdateFn(StageProcessing, NewStatus(StatusExtractingFlashcards)); err != nil {
+			return "", err
+		}
+		flashcards, err := s.generateFlashcards(generateCtx, documents, settings)
+		if err != nil {
+			log.Printf("Failed to extract flashcards: %v", err)
+			checkpoint.Warnings = append(checkpoint.Warnings, "Flashcards couldn't be extracted from the documents")
+		}
+		checkpoint.Flashcards = flashcards
+	}
+	if settings.OnePager {
+		if err := statusUpdateFn(StageProcessing, NewStatus(StatusWritingSummary)); err != nil {
+			return "", err
+		}
+		onePager, err := s.generateOnePager(generateCtx, documents, settings)
+		if err != nil {
+			log.Printf("Failed to write the executive summary: %v", err)
+			checkpoint.Warnings = append(checkpoint.Warnings, "The executive summary couldn't be written")
+		}
+		checkpoint.OnePager = onePager
+	}
+	if settings.InstructorMode {
+		if err := statusUpdateFn(StageProcessing, NewStatus(StatusWritingHandout)); err != nil {
+			return "", err
+		}
+		handout, err := s.generateHandout(generateCtx, marpText, settings)
+		if err != nil {
+			log.Printf("Failed to write the student handout: %v", err)
+			checkpoint.Warnings = append(checkpoint.Warnings, "The student handout couldn't be written")
+		}
+		checkpoint.Handout = handout
 	}
 
-	// Create a temporary directory for our files
-	tempDir, err := os.MkdirTemp("", "slideitin-")
-	if err != nil {
-		log.Printf("Failed to create temp directory: %v", err)
-		return nil, nil, err
-	}
-	defer os.RemoveAll(tempDir) // Clean up when we're done
-	
-	// Create the markdown file
-	mdFilePath := filepath.Join(tempDir, "presentation.md")
-	err = os.WriteFile(mdFilePath, []byte(marpText), 0644)
-	if err != nil {
-		log.Printf("Failed to write markdown file: %v", err)
-		return nil, nil, err
-	}
-	
-	// Set up PDF output path
-	pdfFilePath := filepath.Join(tempDir, "presentation.pdf")
-	
-	// Run Marp CLI to generate the PDF
-	marpArgs := []string{"@marp-team/marp-cli", mdFilePath}
-	
-	// Add theme parameter if it's in themes directory
-	themePath := filepath.Join("services", "slides", "themes", theme+".css")
-	if _, err := os.Stat(themePath); err == nil {
-		// Theme file exists, add it to the arguments
-		marpArgs = append(marpArgs, "--theme", themePath)
-		log.Printf("Using theme: %s", themePath)
-	} else {
-		marpArgs = append(marpArgs, "--theme", theme)
-		log.Printf("Using built-in theme: %s", theme)
-	}
-	
-	cmd := exec.Command("npx", append(marpArgs, "--output", pdfFilePath, "--pdf")...)
-	var cmdOutput bytes.Buffer
-	var cmdError bytes.Buffer
-	cmd.Stdout = &cmdOutput
-	cmd.Stderr = &cmdError
-	err = cmd.Run()
-	if err != nil {
-		log.Printf("Failed to run Marp CLI: %v", err)
-		log.Printf("Marp CLI stderr: %s", cmdError.String())
-		return nil, nil, errors.New("failed to generate PDF. Please try again.")
+	// Check the bullet points against the documents, decks written from a
+	// topic have none to check them against
+	if settings.FactCheck && len(files) > 0 {
+		if err := statusUpdateFn(StageProcessing, NewStatus(StatusCheckingFacts)); err != nil {
+			return "", err
+		}
+		grounding, err := s.checkGrounding(generateCtx, documents, marpText)
+		if err != nil {
+			log.Printf("Failed to check the slides against the documents: %v", err)
+			checkpoint.Warnings = append(checkpoint.Warnings, "The slides couldn't be checked against the documents")
+		} else if warning := grounding.warning(); warning != "" {
+			checkpoint.Warnings = append(checkpoint.Warnings, warning)
+		}
+		checkpoint.Grounding = grounding
 	}
-	
-	// Read the generated PDF
-	pdfBytes, err := os.ReadFile(pdfFilePath)
-	if err != nil {
-		log.Printf("Failed to read generated PDF: %v", err)
-		return nil, nil, err
-	}
-	
-	log.Printf("Successfully generated PDF (%d bytes)", len(pdfBytes))
-
-	// Create the HTML file
-	htmlFilePath := filepath.Join(tempDir, "presentation.html")
-
-	// Run Marp CLI to generate the HTML
-	cmd = exec.Command("npx", append(marpArgs, "--output", htmlFilePath, "--html")...)
-	cmdOutput.Reset()
-	cmdError.Reset()
-	cmd.Stdout = &cmdOutput
-	cmd.Stderr = &cmdError
-	err = cmd.Run()
+
+	// Save the markdown so a retry only needs to render it
+	checkpoint.Markdown = marpText
+	checkpoint.Outline = nil
+	checkpoint.Sections = nil
+	if err := saveCheckpointFn(checkpoint); err != nil {
+		log.Printf("Warning: Failed to save checkpoint: %v", err)
+	}
+
+	return marpText, nil
+}
+
+// filesAvailable reports whether all checkpointed files are still active in Gemini
+func (s *SlideService) filesAvailable(ctx context.Context, geminiFiles []GeminiFile, count int) bool {
+	if len(geminiFiles) == 0 || len(geminiFiles) != count {
+		return false
+	}
+	for _, file := range geminiFiles {
+		if file.Name == "" {
+			continue // Inlined as text
+		}
+		info, err := s.client.GetFile(ctx, file.Name)
+		if err != nil || info.State != genai.FileStateActive {
+			return false
+		}
+	}
+	return true
+}
+
+// fitTokenBudget inlines every document as text and drops the least relevant
+// sections until the request fits in maxTokens, summarizing the most
+// relevant of the dropped sections in chunked mode, without the text that
+// reads as instructions to the model with injection detection on. It returns
+// the prompt parts, the summaries of the summarized sections, the labels of
+// the omitted sections, and the names of the files left out because their
+// text couldn't be extracted.
+func (s *SlideService) fitTokenBudget(ctx context.Context, files []models.File, prompt string, maxTokens int, settings models.SlideSettings, statusUpdateFn func(stage Stage, status Status) error) ([]genai.Part, []ChunkSummary, []string, []string, error) {
+	promptCount, err := s.model.CountTokens(ctx, genai.Text(prompt))
 	if err != nil {
-		log.Printf("Failed to run Marp CLI: %v", err)
-		log.Printf("Marp CLI stderr: %s", cmdError.String())
-		return nil, nil, errors.New("failed to generate HTML. Please try again.")
+		return nil, nil, nil, nil, err
 	}
 
-	// Read the generated HTML
-	htmlBytes, err := os.ReadFile(htmlFilePath)
+	sections, unreadable, err := documentSections(ctx, files, settings)
 	if err != nil {
-		log.Printf("Failed to read generated HTML: %v", err)
-		return nil, nil, err
+		return nil, nil, nil, nil, err
 	}
+	scoreSections(sections, settings.FocusTopics)
 
-	log.Printf("Successfully generated HTML (%d bytes)", len(htmlBytes))
-	
-	// Delete the files from Gemini
-	for _, file := range geminiFiles {
-		err := s.client.DeleteFile(ctx, file.Name)
+	// Leave room for the document delimiters and the summaries
+	budget := maxTokens - int(promptCount.TotalTokens) - 64*len(files)
+	var summaries map[int]section
+	if settings.ChunkedMode {
+		budget -= maxSummarizedSections * summaryTokens
+	} else {
+		summaries = make(map[int]section)
+	}
+	for attempt := 0; attempt < 3 && budget > 0; attempt++ {
+		kept, dropped := selectSections(sections, budget)
+
+		// Summaries are made once, sections dropped by a smaller budget are left out
+		if summaries == nil {
+			summaries, err = summarizeSections(ctx, dropped, statusUpdateFn, s.summarizeSection)
+			if err != nil {
+				return nil, nil, nil, nil, err
+			}
+		}
+		var summarized []ChunkSummary
+		var omitted []section
+		for _, sec := range dropped {
+			if summary, ok := summaries[sec.order]; ok {
+				kept = append(kept, summary)
+				summarized = append(summarized, chunkSummary(sec, summary))
+			} else {
+				omitted = append(omitted, sec)
+			}
+		}
+		sort.Slice(kept, func(i, j int) bool { return kept[i].order < kept[j].order })
+		texts := joinSections(kept)
+
+		parts := make([]genai.Part, 0, len(files)+1)
+		for _, file := range files {
+			if text := texts[file.Filename]; text != "" {
+				parts = append(parts, genai.Text(inlineDocument(file.Filename, text)))
+			}
+		}
+		parts = append(parts, genai.Text(prompt))
+
+		countResp, err := s.model.CountTokens(ctx, parts...)
 		if err != nil {
-			log.Printf("Failed to delete file from Gemini: %v", err)
+			return nil, nil, nil, nil, err
+		}
+		if int(countResp.TotalTokens) <= maxTokens {
+			return parts, summarized, sectionLabels(omitted), unreadable, nil
 		}
+
+		// The character estimate was off, shrink the budget past the overshoot and try again
+		budget -= int(countResp.TotalTokens) - maxTokens + budget/10
 	}
-	
-	// Return the PDF and HTML bytes
-	return pdfBytes, htmlBytes, nil
+
+	return nil, nil, nil, nil, errors.New("documents are too large to process")
+}
+
+// documentSections extracts the text of the documents and splits it into
+// sections, returning the documents that couldn't be read
+func documentSections(ctx context.Context, files []models.File, settings models.SlideSettings) ([]section, []string, error) {
+	var sections []section
+	var unreadable []string
+	for _, file := range files {
+		text, err := extractText(ctx, file)
+		if err != nil {
+			log.Printf("Failed to extract text from %s: %v", file.Filename, err)
+			unreadable = append(unreadable, file.Filename)
+			continue
+		}
+		if settings.InjectionDetection {
+			text, _ = removeInjections(text)
+		}
+		sections = append(sections, splitSections(file.Filename, text)...)
+	}
+	if len(unreadable) == len(files) {
+		return nil, nil, errors.New("none of the files could be read")
+	}
+	return sections, unreadable, nil
+}
+
+// unreadableWarning is the warning raised for a file left out of a deck
+// because its text couldn't be extracted
+func unreadableWarning(filename string) string {
+	return fmt.Sprintf("File %s couldn't be read and was left out", filename)
+}
+
+// timeoutError returns ErrTimeout if the context of a failed stage hit its
+// deadline, since the underlying error is often just a cancelled call
+func timeoutError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("Stage timed out: %v", err)
+		return ErrTimeout
+	}
+	return err
 }
 
 // extractMarkdownContent extracts markdown content between triple backticks
 func extractMarkdownContent(text string) string {
 	lines := regexp.MustCompile(`\r?\n`).Split(text, -1)
-	
+
 	firstBacktickLine := -1
 	lastBacktickLine := -1
-	
+
 	// Find first and last lines with triple backticks
 	for i, line := range lines {
 		if strings.HasPrefix(line, "```") {
@@ -238,16 +778,16 @@ func extractMarkdownContent(text string) string {
 			lastBacktickLine = i
 		}
 	}
-	
+
 	// If we found backticks, extract the content
 	if firstBacktickLine != -1 && lastBacktickLine != -1 && lastBacktickLine > firstBacktickLine {
 		// Extract content between the backtick lines, excluding the lines with backticks themselves
 		// firstBacktickLine+1 skips the opening backtick line
 		// lastBacktickLine as the end index (exclusive in Go slices) excludes the closing backtick line
-		content := lines[firstBacktickLine+1:lastBacktickLine]
+		content := lines[firstBacktickLine+1 : lastBacktickLine]
 		return strings.Join(content, "\n")
 	}
-	
+
 	// If no backticks found, return the entire text
 	return text
-} 
\ No newline at end of file
+}
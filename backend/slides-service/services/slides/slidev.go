@@ -0,0 +1,183 @@
+package slides
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// slidevViteConfig inlines the scripts, styles and fonts of the built deck
+	// into its index.html, so the HTML is a single file like Marp's
+	slidevViteConfig = `import { viteSingleFile } from 'vite-plugin-singlefile'
+
+export default {
+  plugins: [viteSingleFile()],
+}
+`
+
+	// slidevLayer is a global layer of a Slidev deck, drawn on every slide.
+	// v-pre keeps the text from being read as a Vue template.
+	slidevLayer = `<template>
+  <div v-pre class="%s">%s</div>
+</template>
+`
+)
+
+// slidevDarkThemes are the themes whose dark colors the Slidev decks keep
+var slidevDarkThemes = map[string]bool{"rose_pine": true, "uncover": true}
+
+// SlidevRenderer renders decks with Slidev, from the Marp markdown converted
+// to Slidev layouts. Theme stylesheets don't apply, the decks use the Slidev
+// default theme in the light or dark colors of the theme.
+type SlidevRenderer struct {
+	projectDir   string   // Directory with the Slidev packages in its node_modules, empty for the global packages
+	chromiumPath string   // Chromium executable of the PDF export, empty for the one of Playwright
+	sandbox      *Sandbox // Sandbox the CLI runs in, nil to run it directly
+}
+
+// NewSlidevRenderer creates a new Slidev renderer. The decks are written to
+// temporary directories inside projectDir, so Node resolves the Slidev CLI,
+// theme and Vite plugin installed there.
+func NewSlidevRenderer(projectDir, chromiumPath string, sandbox *Sandbox) *SlidevRenderer {
+	return &SlidevRenderer{projectDir: projectDir, chromiumPath: chromiumPath, sandbox: sandbox}
+}
+
+// Render exports the PDF with Slidev and builds the HTML as a single page
+func (r *SlidevRenderer) Render(ctx context.Context, markdown string, options RenderOptions) (*RenderOutput, error) {
+	dir, err := newWorkDir(r.projectDir, r.sandbox)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.remove()
+
+	files := map[string]string{
+		"slides.md":      slidevMarkdown(dir.sandbox.offline(ctx, markdown), options.Theme),
+		"vite.config.ts": slidevViteConfig,
+	}
+	if options.Footer != "" {
+		files["global-bottom.vue"] = slidevLayerFile("absolute bottom-3 left-6 text-sm opacity-60", options.Footer)
+	}
+	if options.Watermark != "" {
+		files["global-top.vue"] = slidevLayerFile("absolute inset-0 flex items-center justify-center text-7xl font-bold opacity-20 -rotate-30 pointer-events-none whitespace-nowrap", options.Watermark)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(dir.file(name), []byte(content), 0644); err != nil {
+			log.Printf("Failed to write %s: %v", name, err)
+			return nil, err
+		}
+	}
+
+	exportArgs := []string{"export", "slides.md", "--output", "presentation.pdf", "--with-toc"}
+	if r.chromiumPath != "" {
+		exportArgs = append(exportArgs, "--executable-path", r.chromiumPath)
+	}
+	if err := dir.run(ctx, r.binary(), exportArgs...); err != nil {
+		return nil, stepError(ctx, err, "failed to generate PDF. Please try again.")
+	}
+	pdfBytes, err := os.ReadFile(dir.file("presentation.pdf"))
+	if err != nil {
+		log.Printf("Failed to read generated PDF: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Successfully generated PDF with Slidev (%d bytes)", len(pdfBytes))
+	if options.PDFOnly {
+		return &RenderOutput{PDFData: pdfBytes}, nil
+	}
+
+	// The hash router lets the single page work without a server
+	if err := dir.run(ctx, r.binary(), "build", "slides.md", "--out", "dist", "--base", "./"); err != nil {
+		return nil, stepError(ctx, err, "failed to generate HTML. Please try again.")
+	}
+	htmlBytes, err := os.ReadFile(dir.file("dist", "index.html"))
+	if err != nil {
+		log.Printf("Failed to read generated HTML: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Successfully generated HTML with Slidev (%d bytes)", len(htmlBytes))
+	return &RenderOutput{
+		PDFData:   pdfBytes,
+		HTMLData:  htmlBytes,
+		Thumbnail: pdfThumbnail(ctx, dir, dir.file("presentation.pdf")),
+	}, nil
+}
+
+// binary returns the Slidev CLI of the project, or the one on the PATH
+func (r *SlidevRenderer) binary() string {
+	if r.projectDir == "" {
+		return "slidev"
+	}
+	return filepath.Join(r.projectDir, "node_modules", ".bin", "slidev")
+}
+
+// slidevLayerFile returns a global layer component showing text
+func slidevLayerFile(class, text string) string {
+	return fmt.Sprintf(slidevLayer, class, html.EscapeString(text))
+}
+
+// slidevMarkdown converts the markdown of a Marp deck to a Slidev deck. Lead
+// slides are centered, the title slide becomes a cover, split backgrounds
+// use the image-left and image-right layouts, full backgrounds the image
+// layout and columns slides the two-cols-header layout. Speaker notes are
+// kept as the last comment of each slide, which Slidev reads as notes.
+func slidevMarkdown(markdown, theme string) string {
+	deck := parseMarpDeck(markdown)
+
+	headmatter := []string{"theme: default", "routerMode: hash"}
+	if deck.Title != "" {
+		headmatter = append(headmatter, "title: "+quoteDirective(deck.Title))
+	}
+	if slidevDarkThemes[theme] {
+		headmatter = append(headmatter, "colorSchema: dark")
+	} else {
+		headmatter = append(headmatter, "colorSchema: light")
+	}
+
+	var out strings.Builder
+	for i, slide := range deck.Slides {
+		frontmatter := []string{}
+		if i == 0 {
+			frontmatter = headmatter
+		}
+
+		body := slide.Body
+		switch {
+		case slide.Background != "" && slide.BackgroundSide != "":
+			frontmatter = append(frontmatter, "layout: image-"+slide.BackgroundSide, "image: "+quoteDirective(slide.Background))
+		case slide.Background != "":
+			frontmatter = append(frontmatter, "layout: image", "image: "+quoteDirective(slide.Background))
+		case i == 0 && slide.hasClass("lead"):
+			frontmatter = append(frontmatter, "layout: cover")
+		case slide.hasClass("lead"):
+			frontmatter = append(frontmatter, "layout: center")
+		case slide.hasClass("columns"):
+			heading, level, rest := slideHeading(body)
+			left, right := splitColumns(rest)
+			frontmatter = append(frontmatter, "layout: two-cols-header")
+			body = "::left::\n\n" + left + "\n\n::right::\n\n" + right
+			if level > 0 {
+				body = strings.Repeat("#", level) + " " + heading + "\n\n" + body
+			}
+		}
+		if slide.hasClass("invert") && !slidevDarkThemes[theme] {
+			frontmatter = append(frontmatter, "class: "+quoteDirective("dark bg-gray-900 text-white"))
+		}
+
+		if len(frontmatter) > 0 {
+			out.WriteString("---\n" + strings.Join(frontmatter, "\n") + "\n---\n\n")
+		} else {
+			out.WriteString("---\n\n")
+		}
+		out.WriteString(body + "\n\n")
+		if len(slide.Notes) > 0 {
+			out.WriteString("<!--\n" + strings.Join(slide.Notes, "\n\n") + "\n-->\n\n")
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,25 @@
+package slides
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlidevMarkdown(t *testing.T) {
+	markdown := "---\nmarp: true\ntitle: \"Launch\"\n---\n\n<!-- _class: lead -->\n\n# Launch\n\n---\n\n## Roadmap\n\n![bg right](https://example.com/map.png)\n\n- Q1\n\n<!-- Mention the beta -->\n\n---\n\n<!-- _class: columns -->\n\n## Options\n\n**Build**\n\n- control\n\n**Buy**\n\n- speed\n\n---\n\n<!-- _class: lead -->\n\n# Thanks"
+
+	want := "---\ntheme: default\nrouterMode: hash\ntitle: \"Launch\"\ncolorSchema: dark\nlayout: cover\n---\n\n# Launch\n\n" +
+		"---\nlayout: image-right\nimage: \"https://example.com/map.png\"\n---\n\n## Roadmap\n\n- Q1\n\n<!--\nMention the beta\n-->\n\n" +
+		"---\nlayout: two-cols-header\n---\n\n## Options\n\n::left::\n\n**Build**\n\n- control\n\n::right::\n\n**Buy**\n\n- speed\n\n" +
+		"---\nlayout: center\n---\n\n# Thanks\n\n"
+	if got := slidevMarkdown(markdown, "rose_pine"); got != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestSlidevLayerFileEscapesText(t *testing.T) {
+	layer := slidevLayerFile("footer", "ACME <Internal> {{ secret }}")
+	if !strings.Contains(layer, `<div v-pre class="footer">ACME &lt;Internal&gt; {{ secret }}</div>`) {
+		t.Fatalf("expected the text escaped and left uncompiled, got:\n%s", layer)
+	}
+}
@@ -0,0 +1,103 @@
+package slides
+
+import "strings"
+
+// StatusCode identifies a status message of a job, so the API can show it in
+// the language of the user. The codes and their parameters are shared with
+// the localization catalogs of the API.
+type StatusCode string
+
+const (
+	StatusProcessingSlides      StatusCode = "processing_slides"
+	StatusProcessingRefinement  StatusCode = "processing_refinement"
+	StatusWaitingForWorker      StatusCode = "waiting_for_worker"
+	StatusWaitingForGemini      StatusCode = "waiting_for_gemini"
+	StatusAnalyzingFiles        StatusCode = "analyzing_files"
+	StatusPlanning              StatusCode = "planning"
+	StatusPlanningSections      StatusCode = "planning_sections"  // parts
+	StatusWritingSection        StatusCode = "writing_section"    // part, parts, title
+	StatusSummarizing           StatusCode = "summarizing"        // section
+	StatusIndexingDocuments     StatusCode = "indexing_documents" // passages
+	StatusGeneratingContent     StatusCode = "generating_content"
+	StatusCreatingPresentation  StatusCode = "creating_presentation"
+	StatusWaitingForGeneration  StatusCode = "waiting_for_generation"
+	StatusExtractingFlashcards  StatusCode = "extracting_flashcards"
+	StatusWritingSummary        StatusCode = "writing_summary"
+	StatusWritingHandout        StatusCode = "writing_handout"
+	StatusCheckingFacts         StatusCode = "checking_facts"
+	StatusApplyingChanges       StatusCode = "applying_changes"
+	StatusRegeneratingSlide     StatusCode = "regenerating_slide" // slide
+	StatusWaitingForRenderer    StatusCode = "waiting_for_renderer"
+	StatusFinalizing            StatusCode = "finalizing"
+	StatusCompleted             StatusCode = "completed"
+	StatusCompletedWithWarnings StatusCode = "completed_with_warnings" // warnings
+	StatusRevisionCreated       StatusCode = "revision_created"        // revision
+)
+
+// statusTexts are the English texts of the status codes, {name} stands for
+// the parameter name
+var statusTexts = map[StatusCode]string{
+	StatusProcessingSlides:      "Processing slides",
+	StatusProcessingRefinement:  "Processing refinement",
+	StatusWaitingForWorker:      "Waiting for a free worker",
+	StatusWaitingForGemini:      "Waiting for Gemini to become available again",
+	StatusAnalyzingFiles:        "Analyzing uploaded files",
+	StatusPlanning:              "Planning presentation",
+	StatusPlanningSections:      "Planning a long presentation in {parts} parts",
+	StatusWritingSection:        "Writing part {part} of {parts}: {title}",
+	StatusSummarizing:           "Summarizing {section}",
+	StatusIndexingDocuments:     "Indexing {passages} passages of the documents",
+	StatusGeneratingContent:     "Generating content for slides",
+	StatusCreatingPresentation:  "Creating presentation with AI",
+	StatusWaitingForGeneration:  "Waiting for a free generation slot",
+	StatusExtractingFlashcards:  "Extracting flashcards",
+	StatusWritingSummary:        "Writing the executive summary",
+	StatusWritingHandout:        "Writing the student handout",
+	StatusCheckingFacts:         "Checking the slides against the documents",
+	StatusApplyingChanges:       "Applying your changes",
+	StatusRegeneratingSlide:     "Regenerating slide {slide}",
+	StatusWaitingForRenderer:    "Waiting for a free renderer",
+	StatusFinalizing:            "Finalizing presentation",
+	StatusCompleted:             "Slides generated successfully",
+	StatusCompletedWithWarnings: "Slides generated successfully. {warnings}",
+	StatusRevisionCreated:       "Revision {revision} created",
+}
+
+// Status is a status message of a job. Messages without a code, such as
+// warnings and errors, are only shown in English.
+type Status struct {
+	Code    StatusCode
+	Params  map[string]string
+	message string
+}
+
+// NewStatus returns the status message of a code, with its parameters given
+// as name and value pairs
+func NewStatus(code StatusCode, params ...string) Status {
+	status := Status{Code: code}
+	if len(params) > 0 {
+		status.Params = make(map[string]string, len(params)/2)
+		for i := 0; i+1 < len(params); i += 2 {
+			status.Params[params[i]] = params[i+1]
+		}
+	}
+	return status
+}
+
+// TextStatus returns a status message that is only shown in English
+func TextStatus(message string) Status {
+	return Status{message: message}
+}
+
+// Text returns the English text of a status message
+func (s Status) Text() string {
+	text, ok := statusTexts[s.Code]
+	if !ok {
+		return s.message
+	}
+	replacements := make([]string, 0, 2*len(s.Params))
+	for name, value := range s.Params {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(replacements...).Replace(text)
+}
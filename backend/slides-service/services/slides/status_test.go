@@ -0,0 +1,30 @@
+package slides
+
+import "testing"
+
+func TestStatusText(t *testing.T) {
+	tests := []struct {
+		status Status
+		text   string
+	}{
+		{NewStatus(StatusFinalizing), "Finalizing presentation"},
+		{NewStatus(StatusWritingSection, "part", "2", "parts", "3", "title", "Results {part}"), "Writing part 2 of 3: Results {part}"},
+		{NewStatus(StatusRegeneratingSlide, "slide", "4"), "Regenerating slide 4"},
+		{TextStatus("File notes.pdf couldn't be downloaded and was left out"), "File notes.pdf couldn't be downloaded and was left out"},
+	}
+	for _, test := range tests {
+		if text := test.status.Text(); text != test.text {
+			t.Errorf("%s: expected %q, got %q", test.status.Code, test.text, text)
+		}
+	}
+
+	// Every code has an English text
+	for _, code := range []StatusCode{StatusProcessingSlides, StatusProcessingRefinement, StatusWaitingForWorker, StatusWaitingForGemini, StatusAnalyzingFiles,
+		StatusPlanning, StatusPlanningSections, StatusWritingSection, StatusSummarizing, StatusGeneratingContent, StatusCreatingPresentation,
+		StatusWaitingForGeneration, StatusExtractingFlashcards, StatusWritingSummary, StatusApplyingChanges, StatusRegeneratingSlide,
+		StatusWaitingForRenderer, StatusFinalizing, StatusCompleted, StatusCompletedWithWarnings, StatusRevisionCreated} {
+		if statusTexts[code] == "" {
+			t.Errorf("%s has no English text", code)
+		}
+	}
+}
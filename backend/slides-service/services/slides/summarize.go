@@ -0,0 +1,102 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+// ChunkSummary is what the model understood from a section of a document
+// that didn't fit in the token budget, which the deck was written from in
+// place of the section. Results keep them when the settings ask to, so the
+// focus topics can be tuned.
+type ChunkSummary struct {
+	Section    string  `json:"section" firestore:"section"` // Label of the section, as named in the warnings
+	Document   string  `json:"document" firestore:"document"`
+	Characters int     `json:"characters" firestore:"characters"` // Length of the section summarized
+	Relevance  float64 `json:"relevance" firestore:"relevance"`   // Score the section was ranked by, raised by the focus topics it mentions
+	Summary    string  `json:"summary" firestore:"summary"`
+}
+
+// chunkSummary returns the chunk summary of a section summarized in its place
+func chunkSummary(original, summarized section) ChunkSummary {
+	return ChunkSummary{
+		Section:    original.label(),
+		Document:   original.document,
+		Characters: len(original.text),
+		Relevance:  math.Round(original.score*1000) / 1000,
+		Summary:    summarized.summary,
+	}
+}
+
+// summarizeSections condenses the most relevant of the sections that don't fit
+// in the token budget, reporting each one as it is summarized so the progress
+// names the part of the documents being worked on. It returns the summaries
+// keyed by section order, sections that fail to summarize are left out.
+func summarizeSections(
+	ctx context.Context,
+	omitted []section,
+	statusUpdateFn func(stage Stage, status Status) error,
+	summarize func(ctx context.Context, s section) (string, error),
+) (map[int]section, error) {
+	ranked := append([]section(nil), omitted...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+	if len(ranked) > maxSummarizedSections {
+		ranked = ranked[:maxSummarizedSections]
+	}
+	// Summarize in document order so the progress reads like the documents
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].order < ranked[j].order })
+
+	summaries := make(map[int]section)
+	for _, s := range ranked {
+		if err := statusUpdateFn(StageProcessing, NewStatus(StatusSummarizing, "section", s.label())); err != nil {
+			return nil, err
+		}
+		summary, err := summarize(ctx, s)
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, err
+			}
+			log.Printf("Failed to summarize %s, leaving it out: %v", s.label(), err)
+			continue
+		}
+		s.text = fmt.Sprintf("Summary of %s: %s", s.label(), summary)
+		s.summary = summary
+		summaries[s.order] = s
+	}
+	return summaries, nil
+}
+
+// summarizeSection asks Gemini for a short summary of a section
+func (s *SlideService) summarizeSection(ctx context.Context, sec section) (string, error) {
+	prompt, err := prompts.GenerateSectionSummaryPrompt(sec.label(), sec.text, summaryTokens*charsPerToken)
+	if err != nil {
+		return "", err
+	}
+	resp, err := generateContent(ctx, s.model, ExchangeSummary, genai.Text(prompt))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", errors.New("empty summary")
+	}
+	text, _ := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	summary := strings.TrimSpace(string(text))
+	if summary == "" {
+		return "", errors.New("empty summary")
+	}
+	// Keep the summary within its share of the budget
+	if runes := []rune(summary); len(runes) > summaryTokens*charsPerToken {
+		summary = string(runes[:summaryTokens*charsPerToken])
+	}
+	return summary, nil
+}
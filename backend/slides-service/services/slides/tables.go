@@ -0,0 +1,124 @@
+package slides
+
+import (
+	"regexp"
+	"strings"
+)
+
+// maxTableRows is the most body rows of a table shown on one slide, longer
+// tables continue on the following slides
+const maxTableRows = 8
+
+var (
+	tableRowPattern       = regexp.MustCompile(`^\s*\|.*\|\s*$`)
+	tableSeparatorPattern = regexp.MustCompile(`^\s*\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?\s*$`)
+	slideTitlePattern     = regexp.MustCompile(`^#{1,6}\s+`)
+)
+
+// splitLargeTables moves the rows of tables too long for one slide onto
+// continuation slides that repeat the slide title and the table header
+func splitLargeTables(markdown string) string {
+	frontmatter, bodies := splitSlides(markdown)
+	slides := make([][]string, 0, len(bodies))
+	for _, body := range bodies {
+		slides = append(slides, strings.Split(body, "\n"))
+	}
+
+	output := make([]string, 0, len(slides))
+	for len(slides) > 0 {
+		slide := slides[0]
+		slides = slides[1:]
+
+		first, rest := splitSlideTable(slide)
+		output = append(output, strings.Join(first, "\n"))
+		if rest != nil {
+			// The rest may hold more long tables
+			slides = append([][]string{rest}, slides...)
+		}
+	}
+
+	return joinSlides(frontmatter, output)
+}
+
+// splitSlides splits markdown into its frontmatter and the text of each slide
+func splitSlides(markdown string) (string, []string) {
+	if loc := frontmatterPattern.FindStringIndex(markdown); loc != nil {
+		return markdown[:loc[1]], splitOnSeparators(markdown[loc[1]:])
+	}
+	return "", splitOnSeparators(markdown)
+}
+
+// splitOnSeparators splits markdown at the slide separators, ignoring the ones
+// inside code blocks
+func splitOnSeparators(markdown string) []string {
+	var slides []string
+	current := []string{}
+	inCode := false
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		if !inCode && strings.TrimSpace(line) == "---" {
+			slides = append(slides, strings.Join(current, "\n"))
+			current = []string{}
+			continue
+		}
+		current = append(current, line)
+	}
+	return append(slides, strings.Join(current, "\n"))
+}
+
+// joinSlides joins the frontmatter and slides split by splitSlides
+func joinSlides(frontmatter string, slides []string) string {
+	return frontmatter + strings.Join(slides, "\n---\n")
+}
+
+// splitSlideTable splits the first table with too many rows on a slide. It
+// returns the slide cut after the first rows and a continuation slide with
+// the remaining rows and content, or nil if the slide needs no split.
+func splitSlideTable(slide []string) ([]string, []string) {
+	for i := 0; i+1 < len(slide); i++ {
+		if !tableRowPattern.MatchString(slide[i]) || !tableSeparatorPattern.MatchString(slide[i+1]) {
+			continue
+		}
+
+		// Find the body rows of the table
+		start := i + 2
+		end := start
+		for end < len(slide) && tableRowPattern.MatchString(slide[end]) {
+			end++
+		}
+		if end-start <= maxTableRows {
+			i = end
+			continue
+		}
+
+		header := slide[i : i+2]
+		first := append([]string{}, slide[:start+maxTableRows]...)
+
+		// The continuation repeats the slide classes, the slide title and the table header
+		rest := []string{""}
+		for _, line := range slide[:i] {
+			if classDirectivePattern.MatchString(line) {
+				rest = append(rest, line, "")
+			}
+		}
+		if title := slideTitle(slide[:i]); title != "" {
+			rest = append(rest, title+" (cont.)", "")
+		}
+		rest = append(rest, header...)
+		rest = append(rest, slide[start+maxTableRows:]...)
+		return first, rest
+	}
+	return slide, nil
+}
+
+// slideTitle returns the first header line of a slide, without any (cont.) suffix
+func slideTitle(lines []string) string {
+	for _, line := range lines {
+		if slideTitlePattern.MatchString(line) {
+			return strings.TrimSuffix(strings.TrimSpace(line), " (cont.)")
+		}
+	}
+	return ""
+}
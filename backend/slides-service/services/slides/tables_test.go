@@ -0,0 +1,58 @@
+package slides
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func tableSlide(rows int) string {
+	var sb strings.Builder
+	sb.WriteString("<!-- _class: tinytext -->\n\n## Results\n\n| Region | Revenue |\n| --- | --- |\n")
+	for i := 1; i <= rows; i++ {
+		fmt.Fprintf(&sb, "| R%d | %d |\n", i, i*100)
+	}
+	sb.WriteString("\nSource: annual report")
+	return sb.String()
+}
+
+func TestSplitLargeTables(t *testing.T) {
+	markdown := "---\nmarp: true\n---\n\n# Title\n\n---\n\n" + tableSlide(maxTableRows*2+1)
+
+	got := splitLargeTables(markdown)
+	slides := strings.Split(got, "\n---\n")
+	if len(slides) != 5 {
+		t.Fatalf("expected the table to continue on two more slides, got %d slides:\n%s", len(slides), got)
+	}
+	for _, slide := range slides[3:] {
+		for _, want := range []string{"<!-- _class: tinytext -->", "## Results (cont.)", "| Region | Revenue |\n| --- | --- |"} {
+			if !strings.Contains(slide, want) {
+				t.Fatalf("expected %q on the continuation slide:\n%s", want, slide)
+			}
+		}
+	}
+	if !strings.Contains(slides[2], "| R8 |") || strings.Contains(slides[2], "| R9 |") {
+		t.Fatalf("expected the first slide to end after %d rows:\n%s", maxTableRows, slides[2])
+	}
+	if !strings.HasSuffix(slides[4], "| R17 | 1700 |\n\nSource: annual report") {
+		t.Fatalf("expected the content after the table on the last slide:\n%s", slides[4])
+	}
+}
+
+func TestSplitLargeTablesLeavesShortTables(t *testing.T) {
+	markdown := "---\nmarp: true\n---\n\n" + tableSlide(maxTableRows) + "\n\n---\n\n```\n---\n```"
+
+	if got := splitLargeTables(markdown); got != markdown {
+		t.Fatalf("expected the markdown to be unchanged, got:\n%s", got)
+	}
+}
+
+func TestExtractMarkdownTextKeepsTables(t *testing.T) {
+	markdown := "# Prices\n\n| Plan | Price |\n| :--- | ---: |\n| Basic | 5 |\n| Pro | 10 |\n"
+
+	got := extractMarkdownText([]byte(markdown))
+	want := "# Prices\n\n| Plan | Price |\n| --- | --- |\n| Basic | 5 |\n| Pro | 10 |"
+	if got != want {
+		t.Fatalf("unexpected text:\n%q\nwant:\n%q", got, want)
+	}
+}
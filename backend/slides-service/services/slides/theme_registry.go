@@ -0,0 +1,51 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ErrThemeNotFound is returned by theme registries for themes they don't have
+var ErrThemeNotFound = errors.New("theme not found")
+
+// marpThemes are the themes built into the Marp CLI, which need no stylesheet
+var marpThemes = []string{"default", "gaia", "uncover"}
+
+// ThemeRegistry loads the stylesheets of themes contributed at runtime and the
+// fonts uploaded to workspaces, so they can be added without rebuilding the service
+type ThemeRegistry interface {
+	// ThemeCSS returns the stylesheet of a contributed theme, or ErrThemeNotFound
+	ThemeCSS(ctx context.Context, name string) (string, error)
+	// FontFile returns an uploaded font file
+	FontFile(ctx context.Context, path string) ([]byte, error)
+}
+
+// themeOptions returns the render options of a theme. Bundled stylesheets are
+// used first, then the registry. Themes that can't be loaded render with the
+// default theme and a warning, so a deleted theme doesn't fail the deck.
+func (s *SlideService) themeOptions(ctx context.Context, theme string) (RenderOptions, string) {
+	themePath := filepath.Join("services", "slides", "themes", theme+".css")
+	if themeCSS, err := os.ReadFile(themePath); err == nil {
+		return RenderOptions{Theme: theme, ThemeCSS: string(themeCSS)}, ""
+	}
+	if slices.Contains(marpThemes, theme) {
+		return RenderOptions{Theme: theme}, ""
+	}
+
+	if s.themes != nil {
+		themeCSS, err := s.themes.ThemeCSS(ctx, theme)
+		if err == nil {
+			return RenderOptions{Theme: theme, ThemeCSS: themeCSS}, ""
+		}
+		if !errors.Is(err, ErrThemeNotFound) {
+			log.Printf("Failed to load theme %s: %v", theme, err)
+		}
+	}
+	log.Printf("Theme %s is not available, rendering with the default theme", theme)
+	return RenderOptions{Theme: "default"}, fmt.Sprintf("The %s theme isn't available, the deck was rendered with the default theme", theme)
+}
@@ -0,0 +1,57 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type mapThemeRegistry map[string]string
+
+func (r mapThemeRegistry) ThemeCSS(ctx context.Context, name string) (string, error) {
+	if name == "broken" {
+		return "", errors.New("firestore unavailable")
+	}
+	css, ok := r[name]
+	if !ok {
+		return "", ErrThemeNotFound
+	}
+	return css, nil
+}
+
+func (r mapThemeRegistry) FontFile(ctx context.Context, path string) ([]byte, error) {
+	data, ok := r[path]
+	if !ok {
+		return nil, errors.New("font not found")
+	}
+	return []byte(data), nil
+}
+
+func TestThemeOptions(t *testing.T) {
+	s := &SlideService{themes: mapThemeRegistry{"solarized": "/* @theme solarized */"}}
+	tests := []struct {
+		theme    string
+		expected RenderOptions
+		warned   bool
+	}{
+		{"gaia", RenderOptions{Theme: "gaia"}, false},
+		{"solarized", RenderOptions{Theme: "solarized", ThemeCSS: "/* @theme solarized */"}, false},
+		{"deleted", RenderOptions{Theme: "default"}, true},
+		{"broken", RenderOptions{Theme: "default"}, true},
+	}
+
+	for _, test := range tests {
+		options, warning := s.themeOptions(context.Background(), test.theme)
+		if options != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.theme, test.expected, options)
+		}
+		if (warning != "") != test.warned {
+			t.Errorf("%s: expected warned=%t, got %q", test.theme, test.warned, warning)
+		}
+	}
+
+	// Without a registry, contributed themes render with the default theme
+	if options, warning := (&SlideService{}).themeOptions(context.Background(), "solarized"); options.Theme != "default" || warning == "" {
+		t.Errorf("expected the default theme without a registry, got %+v", options)
+	}
+}
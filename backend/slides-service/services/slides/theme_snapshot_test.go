@@ -0,0 +1,175 @@
+package slides
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// updateSnapshots rewrites the theme snapshots with the current output
+var updateSnapshots = flag.Bool("update", false, "rewrite the theme snapshots in testdata/snapshots")
+
+// TestThemeSnapshots renders the fixture deck with every bundled theme and
+// compares the styles and slides of the HTML with the snapshots in
+// testdata/snapshots, so changes to the themes, the rendering steps or the
+// Marp CLI show up as a diff. It needs the Marp CLI and Chromium, and is
+// skipped unless MARP_PATH is set. Run it with make snapshots, and with make
+// update-snapshots after reviewing an intended change. Missing snapshots are
+// recorded.
+func TestThemeSnapshots(t *testing.T) {
+	marpPath := os.Getenv("MARP_PATH")
+	if marpPath == "" {
+		t.Skip("MARP_PATH must be set to render the theme snapshots")
+	}
+	fixture, err := os.ReadFile(filepath.Join("testdata", "snapshot_deck.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run the fixture through the rendering steps that don't need sources or settings
+	markdown, _ := sanitizeMarkdown(string(fixture))
+	markdown = applyLayouts(markdown, nil)
+	markdown = splitLargeTables(markdown)
+	markdown = applyMath(markdown)
+	markdown = applyDocumentMetadata(markdown, "en")
+
+	themes := map[string]string{}
+	for _, theme := range marpThemes {
+		themes[theme] = ""
+	}
+	stylesheets, err := filepath.Glob(filepath.Join("themes", "*.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range stylesheets {
+		css, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		themes[strings.TrimSuffix(filepath.Base(path), ".css")] = string(css)
+	}
+
+	renderer := NewMarpRenderer(marpPath, os.Getenv("CHROMIUM_PATH"), nil)
+	for theme, css := range themes {
+		t.Run(theme, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), renderTimeout)
+			defer cancel()
+			output, err := renderer.Render(ctx, markdown, RenderOptions{Theme: theme, ThemeCSS: css})
+			if err != nil {
+				t.Fatalf("render failed: %v", err)
+			}
+			if !bytes.HasPrefix(output.PDFData, []byte("%PDF-")) {
+				t.Errorf("expected a PDF, got %d bytes starting %q", len(output.PDFData), output.PDFData[:min(len(output.PDFData), 8)])
+			}
+			if len(output.Thumbnail) == 0 {
+				t.Error("expected a thumbnail")
+			}
+
+			got, err := snapshotHTML(output.HTMLData)
+			if err != nil {
+				t.Fatalf("failed to read the HTML: %v", err)
+			}
+			path := filepath.Join("testdata", "snapshots", theme+".html")
+			want, err := os.ReadFile(path)
+			if *updateSnapshots || os.IsNotExist(err) {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				t.Logf("Recorded snapshot %s", path)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line, wantLine, gotLine := firstDifference(string(want), got); line > 0 {
+				t.Errorf("%s differs at line %d:\nwant: %s\ngot:  %s\nrun make update-snapshots if the change is intended", path, line, wantLine, gotLine)
+			}
+		})
+	}
+}
+
+// snapshotHTML returns the styles and slides of a Marp HTML deck one tag or
+// text per line, without the scripts of the HTML template, so a snapshot
+// diffs by line and doesn't change with the template's scripts
+func snapshotHTML(rendered []byte) (string, error) {
+	var out strings.Builder
+	tokenizer := html.NewTokenizer(bytes.NewReader(rendered))
+	inScript := false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return "", err
+			}
+			return out.String(), nil
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			inScript = token.Data == "script"
+			if !inScript {
+				out.WriteString(token.String() + "\n")
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if token.Data == "script" {
+				inScript = false
+			} else {
+				out.WriteString(token.String() + "\n")
+			}
+		case html.TextToken:
+			text := strings.TrimSpace(string(tokenizer.Text()))
+			if inScript || text == "" {
+				continue
+			}
+			// One CSS rule per line
+			text = strings.ReplaceAll(text, "}", "}\n")
+			out.WriteString(strings.TrimSpace(text) + "\n")
+		default:
+			out.WriteString(tokenizer.Token().String() + "\n")
+		}
+	}
+}
+
+// firstDifference returns the first line two snapshots differ at, counted
+// from 1, and the line of each, or 0 if they are the same
+func firstDifference(want, got string) (int, string, string) {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		var wantLine, gotLine string
+		if i < len(wantLines) {
+			wantLine = wantLines[i]
+		}
+		if i < len(gotLines) {
+			gotLine = gotLines[i]
+		}
+		if wantLine != gotLine {
+			return i + 1, wantLine, gotLine
+		}
+	}
+	return 0, "", ""
+}
+
+func TestSnapshotHTMLDropsScripts(t *testing.T) {
+	rendered := `<!DOCTYPE html><html><head><style>section{color:red}h1{color:blue}</style><script>var x = "<svg>";</script></head><body><svg><section id="1"><h1>Title</h1></section></svg><script src="bespoke.js"></script></body></html>`
+	want := "<!DOCTYPE html>\n<html>\n<head>\n<style>\nsection{color:red}\nh1{color:blue}\n</style>\n</head>\n<body>\n<svg>\n<section id=\"1\">\n<h1>\nTitle\n</h1>\n</section>\n</svg>\n</body>\n</html>\n"
+	got, err := snapshotHTML([]byte(rendered))
+	if err != nil || got != want {
+		t.Errorf("expected %q, got %q, %v", want, got, err)
+	}
+
+	if line, _, _ := firstDifference(want, want); line != 0 {
+		t.Errorf("expected no difference, got line %d", line)
+	}
+	if line, wantLine, gotLine := firstDifference("a\nb\nc", "a\nx\nc"); line != 2 || wantLine != "b" || gotLine != "x" {
+		t.Errorf("expected line 2 to differ, got %d %q %q", line, wantLine, gotLine)
+	}
+}
@@ -0,0 +1,308 @@
+package slides
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+const (
+	// alignmentWindow is the length of the parts of a recording slides are
+	// matched against, in seconds
+	alignmentWindow = 60.0
+
+	// minSharedTerms is how many terms a slide must share with a part of a
+	// recording to be aligned with it
+	minSharedTerms = 2
+
+	// lastCueLength is the assumed length of a final cue without an end time,
+	// in seconds
+	lastCueLength = 5.0
+)
+
+var (
+	// cueTimingPattern matches the timing line of a WebVTT or SRT cue, such
+	// as 00:01:02.500 --> 00:01:05.000
+	cueTimingPattern = regexp.MustCompile(`^((?:\d+:)?\d{1,2}:\d{2}(?:[.,]\d+)?)\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}(?:[.,]\d+)?)`)
+
+	// timestampLinePattern matches a line starting with a timestamp, as in
+	// transcripts copied from YouTube or exported by meeting recorders
+	timestampLinePattern = regexp.MustCompile(`^\[?((?:\d+:)?\d{1,2}:\d{2}(?:[.,]\d+)?)\]?(?:\s+(.*))?$`)
+
+	// cueTagPattern matches the WebVTT tags in cue text, such as <v Speaker>
+	cueTagPattern = regexp.MustCompile(`<[^>]*>`)
+)
+
+// cue is a timed line of a transcript
+type cue struct {
+	start float64 // Seconds into the recording
+	end   float64
+	text  string
+}
+
+// TranscriptAlignment maps the slides of a deck to the parts of the
+// recordings it was made from, so tools can sync the slides with playback
+type TranscriptAlignment struct {
+	Transcripts []TranscriptTimings `json:"transcripts"`
+}
+
+// TranscriptTimings are the times in a recording where the slides are discussed
+type TranscriptTimings struct {
+	Filename string        `json:"filename"`
+	Duration float64       `json:"duration"` // Seconds
+	Slides   []SlideTiming `json:"slides"`   // Slides in deck order, leaving out slides not found in the recording
+}
+
+// SlideTiming is the part of a recording a slide covers
+type SlideTiming struct {
+	Slide int     `json:"slide"` // Position in the deck
+	Title string  `json:"title,omitempty"`
+	Start float64 `json:"start"` // Seconds into the recording
+	End   float64 `json:"end"`
+}
+
+// transcriptCues parses a source file as a transcript, returning nil if it
+// isn't one. WebVTT and SRT files are transcripts, as are text files where
+// most lines start with a timestamp.
+func transcriptCues(file models.File) []cue {
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if file.Type == "application/pdf" || ext == ".md" {
+		return nil
+	}
+
+	var cues []cue
+	lines, timed := 0, 0
+	for _, line := range strings.Split(string(file.Data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lines++
+		if m := cueTimingPattern.FindStringSubmatch(line); m != nil {
+			start, _ := parseTimestamp(m[1])
+			end, _ := parseTimestamp(m[2])
+			cues = append(cues, cue{start: start, end: end})
+			timed++
+			continue
+		}
+		if m := timestampLinePattern.FindStringSubmatch(line); m != nil {
+			start, _ := parseTimestamp(m[1])
+			cues = append(cues, cue{start: start, end: -1, text: m[2]})
+			timed++
+			continue
+		}
+		if len(cues) == 0 || isCueIdentifier(line) {
+			continue // WebVTT header or SRT cue number
+		}
+		last := &cues[len(cues)-1]
+		last.text = strings.TrimSpace(last.text + " " + cueTagPattern.ReplaceAllString(line, ""))
+	}
+
+	if ext != ".vtt" && ext != ".srt" && (timed < 3 || timed*5 < lines) {
+		return nil
+	}
+	transcript := cues[:0]
+	for i, c := range cues {
+		if c.end < c.start {
+			c.end = c.start + lastCueLength
+			if i+1 < len(cues) && cues[i+1].start > c.start {
+				c.end = cues[i+1].start
+			}
+		}
+		if c.text != "" {
+			transcript = append(transcript, c)
+		}
+	}
+	if len(transcript) == 0 {
+		return nil
+	}
+	return transcript
+}
+
+// isCueIdentifier reports whether a line is a WebVTT header or an SRT cue number
+func isCueIdentifier(line string) bool {
+	if strings.HasPrefix(line, "WEBVTT") {
+		return true
+	}
+	_, err := strconv.Atoi(line)
+	return err == nil
+}
+
+// parseTimestamp parses a timestamp such as 1:02, 01:02:03 or 00:01:02,500
+// into seconds
+func parseTimestamp(timestamp string) (float64, error) {
+	seconds := 0.0
+	for _, part := range strings.Split(strings.Replace(timestamp, ",", ".", 1), ":") {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, err
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
+
+// formatTranscript writes the cues of a transcript as timestamped lines, which
+// read more compactly to the model than WebVTT or SRT
+func formatTranscript(cues []cue) string {
+	var sb strings.Builder
+	for _, c := range cues {
+		fmt.Fprintf(&sb, "[%s] %s\n", formatTimestamp(c.start), c.text)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// formatTimestamp formats seconds as HH:MM:SS
+func formatTimestamp(seconds float64) string {
+	total := int(seconds)
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, total/60%60, total%60)
+}
+
+// alignTranscripts maps the slides of the markdown to the transcripts among
+// the source files, or returns nil if there are none
+func alignTranscripts(markdown string, files []models.File) *TranscriptAlignment {
+	var alignment *TranscriptAlignment
+	for _, file := range files {
+		cues := transcriptCues(file)
+		if cues == nil {
+			continue
+		}
+		if alignment == nil {
+			alignment = &TranscriptAlignment{}
+		}
+		alignment.Transcripts = append(alignment.Transcripts, alignSlides(markdown, file.Filename, cues))
+	}
+	return alignment
+}
+
+// window is a part of a recording slides are matched against
+type window struct {
+	start float64
+	end   float64
+	terms map[string]bool
+}
+
+// alignSlides matches each slide to the part of the recording sharing the most
+// distinctive terms with it, keeping the slides in the order of the recording
+func alignSlides(markdown, filename string, cues []cue) TranscriptTimings {
+	timings := TranscriptTimings{Filename: filename, Duration: cues[len(cues)-1].end, Slides: []SlideTiming{}}
+
+	// Split the recording into windows and weigh terms by how few windows use them
+	var windows []window
+	for _, c := range cues {
+		if len(windows) == 0 || c.start-windows[len(windows)-1].start >= alignmentWindow {
+			windows = append(windows, window{start: c.start, terms: make(map[string]bool)})
+		}
+		w := &windows[len(windows)-1]
+		w.end = math.Max(w.end, c.end)
+		for _, term := range tokenize(c.text) {
+			w.terms[term] = true
+		}
+	}
+	frequency := make(map[string]int)
+	for _, w := range windows {
+		for term := range w.terms {
+			frequency[term]++
+		}
+	}
+
+	_, slides := splitSlides(markdown)
+	scores := make([][]float64, len(slides))
+	for s, slide := range slides {
+		terms := make(map[string]bool)
+		for _, term := range tokenize(slide) {
+			terms[term] = true
+		}
+		scores[s] = make([]float64, len(windows))
+		for w, win := range windows {
+			shared, score := 0, 0.0
+			for term := range terms {
+				if win.terms[term] {
+					shared++
+					score += math.Log(1 + float64(len(windows))/float64(frequency[term]))
+				}
+			}
+			if shared >= minSharedTerms {
+				scores[s][w] = score
+			}
+		}
+	}
+
+	assigned := orderedAssignment(scores, len(windows))
+	for s, w := range assigned {
+		if w < 0 {
+			continue
+		}
+		timing := SlideTiming{
+			Slide: s + 1,
+			Title: strings.TrimSpace(slideTitlePattern.ReplaceAllString(slideTitle(strings.Split(slides[s], "\n")), "")),
+			Start: windows[w].start,
+			End:   windows[w].end,
+		}
+		// A slide lasts until the next slide starts
+		for _, next := range assigned[s+1:] {
+			if next >= 0 && windows[next].start > timing.Start {
+				timing.End = windows[next].start
+				break
+			}
+		}
+		timings.Slides = append(timings.Slides, timing)
+	}
+	return timings
+}
+
+// orderedAssignment assigns each slide to a window, or -1 for none, so that
+// the total score is highest and slides never go back in the recording
+func orderedAssignment(scores [][]float64, windows int) []int {
+	// best[s][l] is the highest score of the first s slides with the last
+	// assigned window l-1, or none for l = 0
+	best := make([][]float64, len(scores)+1)
+	from := make([][]int, len(scores)+1)
+	took := make([][]bool, len(scores)+1) // Whether the slide was assigned
+	for s := range best {
+		best[s] = make([]float64, windows+1)
+		from[s] = make([]int, windows+1)
+		took[s] = make([]bool, windows+1)
+		for l := range best[s] {
+			best[s][l] = math.Inf(-1)
+		}
+	}
+	best[0][0] = 0
+
+	for s := range scores {
+		for l, score := range best[s] {
+			if math.IsInf(score, -1) {
+				continue
+			}
+			if score > best[s+1][l] {
+				best[s+1][l], from[s+1][l], took[s+1][l] = score, l, false
+			}
+			for w := max(l-1, 0); w < windows; w++ {
+				if scores[s][w] > 0 && score+scores[s][w] > best[s+1][w+1] {
+					best[s+1][w+1], from[s+1][w+1], took[s+1][w+1] = score+scores[s][w], l, true
+				}
+			}
+		}
+	}
+
+	last := 0
+	for l := range best[len(scores)] {
+		if best[len(scores)][l] > best[len(scores)][last] {
+			last = l
+		}
+	}
+	assigned := make([]int, len(scores))
+	for s := len(scores); s > 0; s-- {
+		assigned[s-1] = -1
+		if took[s][last] {
+			assigned[s-1] = last - 1
+		}
+		last = from[s][last]
+	}
+	return assigned
+}
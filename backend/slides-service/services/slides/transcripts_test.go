@@ -0,0 +1,86 @@
+package slides
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+func TestTranscriptCues(t *testing.T) {
+	tests := []struct {
+		file     models.File
+		expected []cue
+	}{
+		{
+			file: models.File{Filename: "talk.vtt", Type: "text/vtt", Data: []byte("WEBVTT\n\n00:00:01.000 --> 00:00:04.500\n<v Ana>Welcome to the talk\n\n00:01:02.000 --> 00:01:05.000\nLet's start with caching\nand latency\n")},
+			expected: []cue{
+				{start: 1, end: 4.5, text: "Welcome to the talk"},
+				{start: 62, end: 65, text: "Let's start with caching and latency"},
+			},
+		},
+		{
+			file: models.File{Filename: "talk.srt", Data: []byte("1\n00:00:01,000 --> 00:00:04,000\nHello\n\n2\n00:00:04,000 --> 00:00:06,250\nWorld\n")},
+			expected: []cue{
+				{start: 1, end: 4, text: "Hello"},
+				{start: 4, end: 6.25, text: "World"},
+			},
+		},
+		{
+			// Copied from YouTube, each line lasts until the next one
+			file: models.File{Filename: "transcript.txt", Type: "text/plain", Data: []byte("0:00 intro to the course\n0:12 what is a cache\n1:05:10 wrap up\n")},
+			expected: []cue{
+				{start: 0, end: 12, text: "intro to the course"},
+				{start: 12, end: 3910, text: "what is a cache"},
+				{start: 3910, end: 3915, text: "wrap up"},
+			},
+		},
+		{file: models.File{Filename: "notes.txt", Type: "text/plain", Data: []byte("Meeting notes\nWe met at 10:30 to discuss the roadmap\n")}},
+		{file: models.File{Filename: "slides.md", Data: []byte("0:00 a\n0:01 b\n0:02 c\n")}},
+	}
+
+	for _, test := range tests {
+		cues := transcriptCues(test.file)
+		if !reflect.DeepEqual(cues, test.expected) {
+			t.Errorf("transcriptCues(%s): expected %+v, got %+v", test.file.Filename, test.expected, cues)
+		}
+	}
+}
+
+func TestFormatTranscript(t *testing.T) {
+	text := formatTranscript([]cue{{start: 1.5, text: "Hello"}, {start: 3725, text: "Goodbye"}})
+	expected := "[00:00:01] Hello\n[01:02:05] Goodbye"
+	if text != expected {
+		t.Errorf("expected %q, got %q", expected, text)
+	}
+}
+
+func TestAlignTranscripts(t *testing.T) {
+	transcript := models.File{Filename: "lecture.vtt", Data: []byte("WEBVTT\n\n" +
+		"00:00:00.000 --> 00:00:30.000\nToday we cover caching strategies and eviction policies\n\n" +
+		"00:01:00.000 --> 00:01:30.000\nDatabase replication keeps followers consistent with leaders\n\n" +
+		"00:02:00.000 --> 00:02:30.000\nLoad balancers spread requests across healthy servers\n")}
+	markdown := "---\nmarp: true\n---\n\n# Caching\n\n- Caching strategies\n- Eviction policies\n\n---\n\n# Thanks\n\n---\n\n# Load balancing\n\n- Balancers spread requests\n- Healthy servers\n\n---\n\n# Replication\n\n- Followers stay consistent\n"
+
+	alignment := alignTranscripts(markdown, []models.File{{Filename: "paper.pdf", Type: "application/pdf"}, transcript})
+	if alignment == nil || len(alignment.Transcripts) != 1 {
+		t.Fatalf("expected one aligned transcript, got %+v", alignment)
+	}
+	timings := alignment.Transcripts[0]
+	if timings.Filename != "lecture.vtt" || timings.Duration != 150 {
+		t.Errorf("expected lecture.vtt lasting 150s, got %s lasting %vs", timings.Filename, timings.Duration)
+	}
+	// The replication slide comes after the load balancing slide in the deck
+	// but before it in the recording, so only one of them can be aligned
+	expected := []SlideTiming{
+		{Slide: 1, Title: "Caching", Start: 0, End: 120},
+		{Slide: 3, Title: "Load balancing", Start: 120, End: 150},
+	}
+	if !reflect.DeepEqual(timings.Slides, expected) {
+		t.Errorf("expected %+v, got %+v", expected, timings.Slides)
+	}
+
+	if alignment := alignTranscripts(markdown, []models.File{{Filename: "paper.pdf", Type: "application/pdf"}}); alignment != nil {
+		t.Errorf("expected no alignment without transcripts, got %+v", alignment)
+	}
+}
@@ -0,0 +1,46 @@
+package slides
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Usage counts the requests a job sends to Gemini and the tokens they use.
+// Sections are summarized at once, so it counts from several goroutines.
+type Usage struct {
+	mu           sync.Mutex
+	requests     int
+	inputTokens  int
+	outputTokens int
+}
+
+// usageKey is the context key of the usage of a job
+type usageKey struct{}
+
+// WithUsage returns a context whose requests to Gemini are counted in the
+// returned usage
+func WithUsage(ctx context.Context) (context.Context, *Usage) {
+	usage := &Usage{}
+	return context.WithValue(ctx, usageKey{}, usage), usage
+}
+
+// Totals returns the requests counted so far and the input and output tokens
+// they used
+func (u *Usage) Totals() (requests, inputTokens, outputTokens int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.requests, u.inputTokens, u.outputTokens
+}
+
+// record counts a request and the tokens of its response, if it got one
+func (u *Usage) record(resp *genai.GenerateContentResponse) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.requests++
+	if resp != nil && resp.UsageMetadata != nil {
+		u.inputTokens += int(resp.UsageMetadata.PromptTokenCount)
+		u.outputTokens += int(resp.UsageMetadata.CandidatesTokenCount)
+	}
+}
@@ -0,0 +1,99 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/martin226/slideitin/backend/slides-service/services/prompts"
+)
+
+// maxGenerationAttempts bounds the calls to Gemini for one response, which is
+// asked again with the problem when its markdown can't be used
+const maxGenerationAttempts = 3
+
+var (
+	// errOutputLimit is returned when a response is cut off at the output
+	// limit, which asking again with the same limit doesn't fix
+	errOutputLimit = errors.New("response reached the output limit")
+
+	// errInvalidResponse is returned when no response had valid markdown
+	errInvalidResponse = errors.New("invalid response")
+)
+
+// marpDirectivePattern matches the directive that turns on Marp in the frontmatter
+var marpDirectivePattern = regexp.MustCompile(`(?m)^marp:\s*true\s*$`)
+
+// validateMarkdown checks the markdown extracted from a response before it's
+// used. Problems are described for Gemini, to be appended to the prompt.
+func validateMarkdown(markdown string, frontmatter bool) error {
+	if strings.TrimSpace(markdown) == "" {
+		return errors.New("had no markdown enclosed in triple backticks")
+	}
+	if frontmatter {
+		match := frontmatterPattern.FindStringSubmatch(markdown)
+		if match == nil {
+			return errors.New("was missing the Marp frontmatter, which must start the markdown between two --- lines")
+		}
+		if !marpDirectivePattern.MatchString(match[1]) {
+			return errors.New("was missing the marp: true directive in the frontmatter")
+		}
+	}
+
+	fences := 0
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fences++
+		}
+	}
+	if fences%2 != 0 {
+		return errors.New("had a code block that was never closed")
+	}
+
+	_, slides := splitSlides(markdown)
+	for _, slide := range slides {
+		if len(contentLines(slide)) > 0 {
+			return nil
+		}
+	}
+	return errors.New("had no slides")
+}
+
+// generateValidMarkdown sends a prompt to Gemini after the parts before it and
+// returns the markdown of the response. A response whose markdown isn't valid
+// is asked for again with the problem appended to the prompt, up to
+// maxGenerationAttempts times before errInvalidResponse is returned. Responses
+// cut off at the output limit return errOutputLimit.
+func (s *SlideService) generateValidMarkdown(ctx context.Context, model *genai.GenerativeModel, parts []genai.Part, prompt string, frontmatter bool) (string, error) {
+	attemptPrompt := prompt
+	var problem error
+	for attempt := 1; attempt <= maxGenerationAttempts; attempt++ {
+		resp, err := generateContent(ctx, model, ExchangeSlides, append(parts[:len(parts):len(parts)], genai.Text(attemptPrompt))...)
+		if err != nil {
+			return "", timeoutError(ctx, err)
+		}
+		if len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason == genai.FinishReasonMaxTokens {
+			return "", errOutputLimit
+		}
+
+		var text genai.Text
+		if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil && len(resp.Candidates[0].Content.Parts) > 0 {
+			text, _ = resp.Candidates[0].Content.Parts[0].(genai.Text)
+		}
+		markdown := extractMarkdownContent(string(text))
+		if problem = validateMarkdown(markdown, frontmatter); problem == nil {
+			return markdown, nil
+		}
+
+		log.Printf("Response %d of %d %v: %s", attempt, maxGenerationAttempts, problem, text)
+		attemptPrompt, err = prompts.GenerateCorrectionPrompt(prompt, problem.Error())
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: the response %v after %d attempts", errInvalidResponse, problem, maxGenerationAttempts)
+}
@@ -0,0 +1,32 @@
+package slides
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateMarkdown(t *testing.T) {
+	tests := []struct {
+		markdown    string
+		frontmatter bool
+		problem     string // Part of the problem, empty for valid markdown
+	}{
+		{"---\nmarp: true\ntheme: gaia\n---\n\n# Title\n\n---\n\n## Slide", true, ""},
+		{"", true, "no markdown"},
+		{"# Title\n\n---\n\n## Slide", true, "missing the Marp frontmatter"},
+		{"---\ntheme: gaia\n---\n\n# Title", true, "marp: true"},
+		{"---\nmarp: true\n---\n\n## Code\n\n```go\nfunc main() {}", true, "never closed"},
+		{"---\nmarp: true\n---\n\n<!-- _class: lead -->\n", true, "no slides"},
+		{"## Costs\n\n- Down 4%", false, ""},
+	}
+
+	for _, test := range tests {
+		err := validateMarkdown(test.markdown, test.frontmatter)
+		switch {
+		case test.problem == "" && err != nil:
+			t.Errorf("validateMarkdown(%q) = %v, want no problem", test.markdown, err)
+		case test.problem != "" && (err == nil || !strings.Contains(err.Error(), test.problem)):
+			t.Errorf("validateMarkdown(%q) = %v, want a problem with %q", test.markdown, err, test.problem)
+		}
+	}
+}
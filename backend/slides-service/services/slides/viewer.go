@@ -0,0 +1,189 @@
+package slides
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/services/sources"
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxViewerAssetBytes bounds each image inlined in the viewer
+	maxViewerAssetBytes = 5 << 20
+
+	// viewerAssetTimeout bounds fetching each image inlined in the viewer
+	viewerAssetTimeout = 15 * time.Second
+
+	// maxViewerAssetRedirects bounds the redirects followed fetching an image
+	maxViewerAssetRedirects = 3
+)
+
+var (
+	// cssImportPattern matches @import rules that load an external stylesheet
+	cssImportPattern = regexp.MustCompile(`@import\s+(?:url\(\s*)?['"]?(?:https?:)?//[^;]*;`)
+
+	// cssURLPattern matches url() references to external resources
+	cssURLPattern = regexp.MustCompile(`url\(\s*['"]?((?:https?:)?//[^'")\s]+)['"]?\s*\)`)
+)
+
+// assetFetcher returns the contents and content type of an external resource
+type assetFetcher func(ctx context.Context, url string) ([]byte, string, error)
+
+// buildViewer turns the rendered HTML into a self-contained page for the
+// sandboxed viewer. External images are inlined as data URIs, and every other
+// external request, such as web fonts and remote scripts, is stripped, so the
+// page loads nothing from outside when it is previewed.
+func buildViewer(ctx context.Context, rendered []byte, fetch assetFetcher) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(rendered))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %v", err)
+	}
+
+	// Fetch each image once, however many slides use it
+	inlined := make(map[string]string)
+	inline := func(url string) string {
+		url = absoluteURL(url)
+		if data, ok := inlined[url]; ok {
+			return data
+		}
+		data, contentType, err := fetch(ctx, url)
+		if err == nil && !strings.HasPrefix(contentType, "image/") {
+			err = fmt.Errorf("unexpected content type %q", contentType)
+		}
+		if err != nil {
+			log.Printf("Leaving %s out of the viewer: %v", url, err)
+			inlined[url] = ""
+			return ""
+		}
+		inlined[url] = "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+		return inlined[url]
+	}
+	inlineCSS := func(css string) string {
+		css = cssImportPattern.ReplaceAllString(css, "")
+		return cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+			return "url(" + inline(cssURLPattern.FindStringSubmatch(match)[1]) + ")"
+		})
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if isExternalResource(c) {
+				n.RemoveChild(c)
+			} else {
+				walk(c)
+			}
+			c = next
+		}
+		if n.Type == html.TextNode && n.Parent != nil && n.Parent.Data == "style" {
+			n.Data = inlineCSS(n.Data)
+		}
+		if n.Type != html.ElementNode {
+			return
+		}
+		for i, attr := range n.Attr {
+			switch {
+			case attr.Key == "style":
+				n.Attr[i].Val = inlineCSS(attr.Val)
+			case (attr.Key == "src" || attr.Key == "href" && n.Data == "image") && isExternalURL(attr.Val):
+				n.Attr[i].Val = inline(attr.Val)
+			case attr.Key == "srcset":
+				n.Attr[i].Val = ""
+			}
+		}
+	}
+	walk(doc)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, fmt.Errorf("failed to render HTML: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// isExternalResource reports whether a node loads something other than an
+// image from outside the page, such as a remote script, stylesheet or frame
+func isExternalResource(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch n.Data {
+	case "script", "iframe", "frame", "object", "embed", "video", "audio", "source", "track":
+		return isExternalURL(attribute(n, "src")) || isExternalURL(attribute(n, "data"))
+	case "link":
+		return isExternalURL(attribute(n, "href"))
+	case "base":
+		return true
+	case "meta":
+		return strings.EqualFold(attribute(n, "http-equiv"), "refresh")
+	}
+	return false
+}
+
+// attribute returns the value of an attribute of a node
+func attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// isExternalURL reports whether a URL loads a resource from another host
+func isExternalURL(url string) bool {
+	url = strings.ToLower(strings.TrimSpace(url))
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "//")
+}
+
+// absoluteURL gives protocol-relative URLs the https scheme
+func absoluteURL(url string) string {
+	if strings.HasPrefix(url, "//") {
+		return "https:" + url
+	}
+	return url
+}
+
+// viewerHTTPClient fetches the images inlined in the viewer. The URLs come
+// from the decks of clients, so it only connects to public addresses.
+var viewerHTTPClient = sources.NewPublicClient(viewerAssetTimeout, maxViewerAssetRedirects)
+
+// fetchAsset downloads an image to inline in the viewer
+func fetchAsset(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := viewerHTTPClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxViewerAssetBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxViewerAssetBytes {
+		return nil, "", fmt.Errorf("larger than %d bytes", maxViewerAssetBytes)
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return data, contentType, nil
+}
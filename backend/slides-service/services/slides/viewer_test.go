@@ -0,0 +1,66 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildViewerInlinesImagesAndStripsExternalRequests(t *testing.T) {
+	rendered := `<!DOCTYPE html><html><head>
+<base href="https://evil.example.com/">
+<link rel="stylesheet" href="https://fonts.googleapis.com/css2?family=Work+Sans">
+<script src="https://cdn.example.com/tracker.js"></script>
+<script>window.bespoke = true;</script>
+<style>@import url('https://fonts.googleapis.com/css2?family=Work+Sans&display=swap');
+section { background: url("https://example.com/bg.png"); }</style>
+</head><body>
+<section><img src="https://example.com/chart.png" alt="Chart"><img src="//example.com/chart.png" srcset="https://example.com/chart@2x.png 2x"></section>
+<section style="background-image:url(https://example.com/missing.png)"><iframe src="https://example.com/embed"></iframe></section>
+</body></html>`
+
+	fetched := make(map[string]int)
+	fetch := func(ctx context.Context, url string) ([]byte, string, error) {
+		fetched[url]++
+		switch url {
+		case "https://example.com/chart.png", "https://example.com/bg.png":
+			return []byte("png"), "image/png", nil
+		}
+		return nil, "", errors.New("not found")
+	}
+
+	viewer, err := buildViewer(context.Background(), []byte(rendered), fetch)
+	if err != nil {
+		t.Fatalf("buildViewer failed: %v", err)
+	}
+	page := string(viewer)
+
+	for _, external := range []string{"https://", "//example.com", "<base", "<iframe", "tracker.js", "@import"} {
+		if strings.Contains(page, external) {
+			t.Errorf("expected %q to be stripped from the viewer:\n%s", external, page)
+		}
+	}
+	if strings.Count(page, "data:image/png;base64,cG5n") != 3 {
+		t.Errorf("expected both images and the background to be inlined:\n%s", page)
+	}
+	if !strings.Contains(page, "window.bespoke = true;") || !strings.Contains(page, `alt="Chart"`) {
+		t.Errorf("expected the inline script and the alt text to be kept:\n%s", page)
+	}
+	if fetched["https://example.com/chart.png"] != 1 {
+		t.Errorf("expected an image used twice to be fetched once, got %v", fetched)
+	}
+}
+
+func TestFetchAssetRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+
+	if _, _, err := fetchAsset(context.Background(), server.URL); err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Fatalf("expected the loopback address to be refused, got %v", err)
+	}
+}
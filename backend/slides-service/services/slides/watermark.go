@@ -0,0 +1,57 @@
+package slides
+
+import (
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+// datePlaceholder is replaced with the generation date in footer and watermark text
+const datePlaceholder = "{date}"
+
+// applyWatermark stamps every slide with the footer and watermark text from the settings
+func applyWatermark(markdown string, settings models.SlideSettings) string {
+	directives := []directive{}
+
+	if settings.Footer != "" {
+		footer := expandDatePlaceholder(settings.Footer)
+		directives = append(directives, directive{"footer", quoteDirective(footer)})
+	}
+
+	if settings.Watermark != "" {
+		watermark := expandDatePlaceholder(settings.Watermark)
+		// Draw the watermark diagonally across the slide, behind the content
+		// and without blocking text selection
+		style := "|\n" +
+			"  section::before {\n" +
+			"    content: " + cssString(watermark) + ";\n" +
+			"    position: absolute;\n" +
+			"    top: 50%;\n" +
+			"    left: 50%;\n" +
+			"    transform: translate(-50%, -50%) rotate(-30deg);\n" +
+			"    font-size: 72px;\n" +
+			"    font-weight: bold;\n" +
+			"    white-space: nowrap;\n" +
+			"    color: rgba(128, 128, 128, 0.2);\n" +
+			"    pointer-events: none;\n" +
+			"    z-index: 0;\n" +
+			"  }"
+		directives = append(directives, directive{"style", style})
+	}
+
+	if len(directives) == 0 {
+		return markdown
+	}
+	return setDirectives(markdown, directives)
+}
+
+// expandDatePlaceholder replaces the date placeholder with today's date
+func expandDatePlaceholder(text string) string {
+	return strings.ReplaceAll(text, datePlaceholder, time.Now().Format("January 2, 2006"))
+}
+
+// cssString quotes text for use as a CSS content value
+func cssString(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace(text) + `"`
+}
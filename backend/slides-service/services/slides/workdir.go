@@ -0,0 +1,181 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// workDirPrefix names the render directories, so sweeps only remove those
+	workDirPrefix = "slideitin-render-"
+
+	// maxWorkDirBytes caps the files a render writes, renders over it are
+	// stopped so a runaway tool can't fill the disk of the instance
+	maxWorkDirBytes = 512 << 20
+
+	// workDirPollInterval is how often the size of a render directory is
+	// checked while a tool writes to it
+	workDirPollInterval = 500 * time.Millisecond
+
+	// orphanAge is how long a render directory is left before a sweep removes
+	// it. Renders are bounded by renderTimeout, so older directories belong to
+	// renders that crashed before cleaning up.
+	orphanAge = 2 * renderTimeout
+)
+
+// ErrWorkDirFull is returned when a render writes more than maxWorkDirBytes
+var ErrWorkDirFull = errors.New("render wrote too many temporary files")
+
+// workDir is the directory a single render writes its files to. Each one
+// has a random name and is only readable by the service, or by the sandbox
+// user when the tools run in a sandbox.
+type workDir struct {
+	path    string
+	limit   int64
+	sandbox *Sandbox // Sandbox the tools run in, nil to run them directly
+}
+
+// newWorkDir creates a render directory in parent, the system temporary
+// directory if it is empty, for tools run in sandbox
+func newWorkDir(parent string, sandbox *Sandbox) (*workDir, error) {
+	path, err := os.MkdirTemp(parent, workDirPrefix+"*")
+	if err != nil {
+		log.Printf("Failed to create temp directory: %v", err)
+		return nil, err
+	}
+	dir := &workDir{path: path, limit: maxWorkDirBytes, sandbox: sandbox}
+	if err := sandbox.own(path); err != nil {
+		dir.remove()
+		return nil, err
+	}
+	return dir, nil
+}
+
+// file returns the path of a file in the directory
+func (d *workDir) file(name ...string) string {
+	return filepath.Join(append([]string{d.path}, name...)...)
+}
+
+// remove deletes the directory and everything in it. It is deferred right
+// after the directory is created, so it also runs when a render panics or
+// times out.
+func (d *workDir) remove() {
+	if err := os.RemoveAll(d.path); err != nil {
+		log.Printf("Failed to remove temp directory %s: %v", d.path, err)
+	}
+}
+
+// size returns the bytes of the files in the directory
+func (d *workDir) size() int64 {
+	var total int64
+	filepath.WalkDir(d.path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// watch returns a context that is canceled when the directory grows over
+// its limit, so the tool writing to it is killed, and a function that stops
+// watching and returns ErrWorkDirFull if it did
+func (d *workDir) watch(ctx context.Context) (context.Context, func() error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(workDirPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if d.size() > d.limit {
+					log.Printf("Temp directory %s is over %d bytes, stopping the render", d.path, d.limit)
+					cancel(ErrWorkDirFull)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() error {
+		close(done)
+		err := context.Cause(ctx)
+		cancel(nil)
+		if errors.Is(err, ErrWorkDirFull) {
+			return ErrWorkDirFull
+		}
+		return nil
+	}
+}
+
+// run runs a tool in the directory, stopping it if the directory grows over
+// its limit or the context is done
+func (d *workDir) run(ctx context.Context, name string, args ...string) error {
+	return d.runEnv(ctx, nil, name, args...)
+}
+
+// runEnv runs a tool like run, with extra environment variables
+func (d *workDir) runEnv(ctx context.Context, env []string, name string, args ...string) error {
+	watched, stop := d.watch(ctx)
+	err := runTool(watched, d, env, name, args...)
+	if full := stop(); full != nil {
+		return full
+	}
+	return err
+}
+
+// SweepWorkDirs removes the render directories left in the parent
+// directories by renders that crashed, when it is called and then every
+// interval until the context is done
+func SweepWorkDirs(ctx context.Context, interval time.Duration, parents ...string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, parent := range parents {
+			sweepWorkDirs(parent, time.Now().Add(-orphanAge))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepWorkDirs removes the render directories in parent last modified before cutoff
+func sweepWorkDirs(parent string, cutoff time.Time) {
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		log.Printf("Failed to sweep temp directories in %s: %v", parent, err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workDirPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		log.Printf("Removing orphaned temp directory %s", entry.Name())
+		if err := os.RemoveAll(filepath.Join(parent, entry.Name())); err != nil {
+			log.Printf("Failed to remove orphaned temp directory %s: %v", entry.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package slides
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWorkDirStopsToolsOverTheLimit(t *testing.T) {
+	dir, err := newWorkDir(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("newWorkDir failed: %v", err)
+	}
+	defer dir.remove()
+	dir.limit = 4096
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = dir.run(ctx, "sh", "-c", "while :; do echo 0123456789abcdef >> output; done")
+	if !errors.Is(err, ErrWorkDirFull) {
+		t.Fatalf("expected ErrWorkDirFull, got %v", err)
+	}
+	if err := stepError(ctx, err, "failed"); !errors.Is(err, ErrWorkDirFull) {
+		t.Errorf("expected the step error to be ErrWorkDirFull, got %v", err)
+	}
+
+	if err := dir.run(ctx, "sh", "-c", "echo small > output"); err != nil {
+		t.Errorf("expected a tool under the limit to succeed, got %v", err)
+	}
+}
+
+func TestSweepWorkDirs(t *testing.T) {
+	parent := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{workDirPrefix + "old", workDirPrefix + "new", "slideitin-themes"} {
+		if err := os.Mkdir(filepath.Join(parent, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if name != workDirPrefix+"new" {
+			os.Chtimes(filepath.Join(parent, name), old, old)
+		}
+	}
+
+	sweepWorkDirs(parent, time.Now().Add(-time.Minute))
+
+	for name, kept := range map[string]bool{workDirPrefix + "old": false, workDirPrefix + "new": true, "slideitin-themes": true} {
+		if _, err := os.Stat(filepath.Join(parent, name)); (err == nil) != kept {
+			t.Errorf("%s: expected kept=%t, got err=%v", name, kept, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+// maxSpacePages is the largest number of pages imported from a Confluence space
+const maxSpacePages = 25
+
+var (
+	// confluencePagePattern matches the page ID in Confluence page URLs
+	confluencePagePattern = regexp.MustCompile(`/pages/(\d+)`)
+
+	// confluenceSpacePattern matches the space key in Confluence space URLs
+	confluenceSpacePattern = regexp.MustCompile(`/spaces/([^/]+)`)
+)
+
+// confluencePage is a page returned by the Confluence REST API
+type confluencePage struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Body  struct {
+		View struct {
+			Value string `json:"value"`
+		} `json:"view"`
+	} `json:"body"`
+}
+
+// Confluence imports Confluence Cloud pages and spaces, authenticated with
+// the API token of an account that can read them
+type Confluence struct {
+	baseURL    *url.URL
+	email      string
+	apiToken   string
+	httpClient *http.Client
+}
+
+// NewConfluence creates a Confluence source for a site such as https://acme.atlassian.net
+func NewConfluence(baseURL, email, apiToken string) (*Confluence, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Confluence URL: %s", baseURL)
+	}
+	return &Confluence{
+		baseURL:    u,
+		email:      email,
+		apiToken:   apiToken,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Type returns the name requests use for Confluence
+func (c *Confluence) Type() string {
+	return "confluence"
+}
+
+// Fetch imports the page, or the first pages of the space, at a Confluence URL
+// as one markdown document
+func (c *Confluence) Fetch(ctx context.Context, location string, options map[string]string, maxBytes int) ([]models.File, error) {
+	u, err := url.Parse(location)
+	if err != nil || !strings.EqualFold(u.Host, c.baseURL.Host) {
+		// The API token must only be sent to the configured site
+		return nil, fmt.Errorf("Confluence URL must be on %s", c.baseURL.Host)
+	}
+
+	var pages []confluencePage
+	var name string
+	pageID := u.Query().Get("pageId")
+	if match := confluencePagePattern.FindStringSubmatch(u.Path); match != nil {
+		pageID = match[1]
+	}
+	switch {
+	case pageID != "":
+		var page confluencePage
+		if err := c.get(ctx, "/wiki/rest/api/content/"+pageID, url.Values{"expand": {"body.view"}}, &page); err != nil {
+			return nil, err
+		}
+		pages, name = []confluencePage{page}, page.Title
+	case confluenceSpacePattern.MatchString(u.Path):
+		spaceKey := confluenceSpacePattern.FindStringSubmatch(u.Path)[1]
+		var result struct {
+			Results []confluencePage `json:"results"`
+		}
+		query := url.Values{
+			"spaceKey": {spaceKey},
+			"type":     {"page"},
+			"expand":   {"body.view"},
+			"limit":    {fmt.Sprint(maxSpacePages)},
+		}
+		if err := c.get(ctx, "/wiki/rest/api/content", query, &result); err != nil {
+			return nil, err
+		}
+		if len(result.Results) == 0 {
+			return nil, fmt.Errorf("Confluence space %s has no pages", spaceKey)
+		}
+		pages, name = result.Results, spaceKey
+	default:
+		return nil, fmt.Errorf("not a Confluence page or space URL: %s", location)
+	}
+
+	var sb strings.Builder
+	for _, page := range pages {
+		fmt.Fprintf(&sb, "# %s\n\n%s\n\n", page.Title, htmlToMarkdown(page.Body.View.Value))
+	}
+	if sb.Len() > maxBytes {
+		return nil, fmt.Errorf("Confluence content is larger than the %d MB allowed", maxBytes>>20)
+	}
+
+	return []models.File{{
+		Filename: fileName(name) + ".md",
+		Data:     []byte(sb.String()),
+		Type:     "text/plain",
+	}}, nil
+}
+
+// get calls the Confluence REST API and decodes the JSON response
+func (c *Confluence) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.email, c.apiToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Confluence: %v", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden:
+		return fmt.Errorf("Confluence content not found or not shared with the import account")
+	default:
+		return fmt.Errorf("Confluence returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 50<<20)).Decode(v)
+}
+
+// fileName turns a page title into a file name
+func fileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" {
+		return "document"
+	}
+	return name
+}
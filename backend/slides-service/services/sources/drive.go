@@ -0,0 +1,141 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrDriveNotConnected is returned when the owner of a job has no Drive connection
+var ErrDriveNotConnected = errors.New("Google Drive is not connected")
+
+// exportFormats maps the Google Workspace documents that can be used as
+// sources to the format they are exported in
+var exportFormats = map[string]string{
+	"application/vnd.google-apps.document":     "application/pdf",
+	"application/vnd.google-apps.presentation": "application/pdf",
+}
+
+// FirestoreDriveConnection is the Firestore representation of a Drive
+// connection, written by the API when a user connects Drive
+type FirestoreDriveConnection struct {
+	RefreshToken string `firestore:"refreshToken"`
+}
+
+// DriveFetcher downloads Google Drive files with the OAuth connection of their owner
+type DriveFetcher struct {
+	client *firestore.Client
+	oauth  *oauth2.Config
+}
+
+// NewDriveFetcher creates a fetcher using the OAuth client Drive was connected with
+func NewDriveFetcher(client *firestore.Client, clientID, clientSecret string) *DriveFetcher {
+	return &DriveFetcher{
+		client: client,
+		oauth: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{drive.DriveReadonlyScope},
+		},
+	}
+}
+
+// Fetch downloads Drive files, exporting Google Docs and Slides as PDF. Files
+// larger than maxBytes are rejected.
+func (f *DriveFetcher) Fetch(ctx context.Context, owner string, fileIDs []string, maxBytes int) ([]models.File, error) {
+	doc, err := f.client.Collection("driveConnections").Doc(owner).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrDriveNotConnected
+		}
+		return nil, fmt.Errorf("failed to get Drive connection: %v", err)
+	}
+	var connection FirestoreDriveConnection
+	if err := doc.DataTo(&connection); err != nil {
+		return nil, fmt.Errorf("failed to parse Drive connection: %v", err)
+	}
+
+	tokens := f.oauth.TokenSource(ctx, &oauth2.Token{RefreshToken: connection.RefreshToken})
+	service, err := drive.NewService(ctx, option.WithTokenSource(tokens))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Drive client: %v", err)
+	}
+
+	files := make([]models.File, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		file, err := f.fetchFile(ctx, service, fileID, maxBytes)
+		if err != nil {
+			var retrieveErr *oauth2.RetrieveError
+			if errors.As(err, &retrieveErr) {
+				return nil, fmt.Errorf("Drive access was revoked, reconnect Google Drive")
+			}
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// fetchFile downloads a single Drive file
+func (f *DriveFetcher) fetchFile(ctx context.Context, service *drive.Service, fileID string, maxBytes int) (models.File, error) {
+	meta, err := service.Files.Get(fileID).Fields("name", "mimeType", "size").SupportsAllDrives(true).Context(ctx).Do()
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to get Drive file %s: %w", fileID, err)
+	}
+
+	name, mimeType := meta.Name, meta.MimeType
+	var body io.ReadCloser
+	if format, ok := exportFormats[mimeType]; ok {
+		resp, err := service.Files.Export(fileID, format).Context(ctx).Download()
+		if err != nil {
+			return models.File{}, fmt.Errorf("failed to export Drive file %s: %w", name, err)
+		}
+		body, mimeType = resp.Body, format
+		name = strings.TrimSuffix(name, path.Ext(name)) + ".pdf"
+	} else {
+		switch {
+		case mimeType == "application/pdf":
+		case mimeType == "text/plain" || mimeType == "text/markdown":
+			mimeType = "text/plain"
+		default:
+			return models.File{}, fmt.Errorf("unsupported Drive file %s of type %s, only PDF, Markdown, text, Google Docs and Google Slides are allowed", name, meta.MimeType)
+		}
+		if meta.Size > int64(maxBytes) {
+			return models.File{}, fmt.Errorf("Drive file %s is larger than the %d MB allowed", name, maxBytes>>20)
+		}
+		resp, err := service.Files.Get(fileID).SupportsAllDrives(true).Context(ctx).Download()
+		if err != nil {
+			return models.File{}, fmt.Errorf("failed to download Drive file %s: %w", name, err)
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	// Exports have no size until downloaded
+	data, err := io.ReadAll(io.LimitReader(body, int64(maxBytes)+1))
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to read Drive file %s: %v", name, err)
+	}
+	if len(data) > maxBytes {
+		return models.File{}, fmt.Errorf("Drive file %s is larger than the %d MB allowed", name, maxBytes>>20)
+	}
+
+	return models.File{
+		Filename: name,
+		Data:     data,
+		Type:     mimeType,
+	}, nil
+}
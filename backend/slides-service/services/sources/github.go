@@ -0,0 +1,306 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+const (
+	// maxRepoDocs is the largest number of files imported from a docs folder
+	maxRepoDocs = 20
+
+	// maxTreeEntries is the largest number of paths listed in the code structure
+	maxTreeEntries = 300
+
+	// maxTreeDepth is how many directory levels the code structure lists
+	maxTreeDepth = 3
+)
+
+var (
+	// errGitHubNotFound is returned when a repository or file does not exist
+	errGitHubNotFound = errors.New("GitHub repository not found or not accessible")
+
+	// docsFolders are the folders documentation is imported from
+	docsFolders = []string{"docs/", "doc/", "documentation/"}
+
+	// docExtensions are the documentation files imported from docs folders
+	docExtensions = map[string]bool{".md": true, ".markdown": true, ".mdx": true, ".rst": true, ".txt": true}
+
+	// skippedDirs are dependency and build folders left out of the code structure
+	skippedDirs = map[string]bool{"node_modules": true, "vendor": true, "dist": true, "build": true, ".git": true, "__pycache__": true, "target": true}
+)
+
+// githubRepo is a repository returned by the GitHub API
+type githubRepo struct {
+	FullName      string   `json:"full_name"`
+	Description   string   `json:"description"`
+	DefaultBranch string   `json:"default_branch"`
+	Language      string   `json:"language"`
+	Topics        []string `json:"topics"`
+}
+
+// githubTreeEntry is a file or directory of a repository tree
+type githubTreeEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"` // blob or tree
+	Size int    `json:"size"`
+}
+
+// GitHub imports the README, docs folder and optionally the code structure of
+// a GitHub repository, so a technical overview deck can be generated from it
+type GitHub struct {
+	token      string
+	httpClient *http.Client
+	apiURL     string
+}
+
+// NewGitHub creates a GitHub source. The token is optional and is needed for
+// private repositories and higher rate limits.
+func NewGitHub(token string) *GitHub {
+	return &GitHub{
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		apiURL:     "https://api.github.com",
+	}
+}
+
+// Type returns the name requests use for GitHub
+func (g *GitHub) Type() string {
+	return "github"
+}
+
+// Fetch imports the repository at a URL such as https://github.com/owner/repo or
+// https://github.com/owner/repo/tree/branch as one markdown document. The
+// structure option set to true adds a listing of the repository's files.
+func (g *GitHub) Fetch(ctx context.Context, location string, options map[string]string, maxBytes int) ([]models.File, error) {
+	owner, name, ref, err := parseRepoURL(location)
+	if err != nil {
+		return nil, err
+	}
+	for key := range options {
+		if key != "structure" {
+			return nil, fmt.Errorf("unknown GitHub option: %s", key)
+		}
+	}
+	repoPath := "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name)
+
+	var repo githubRepo
+	if err := g.getJSON(ctx, repoPath, nil, &repo); err != nil {
+		return nil, err
+	}
+	if ref == "" {
+		ref = repo.DefaultBranch
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n\n", repo.FullName)
+	if repo.Description != "" {
+		fmt.Fprintf(&sb, "%s\n\n", repo.Description)
+	}
+	if repo.Language != "" {
+		fmt.Fprintf(&sb, "Primary language: %s\n\n", repo.Language)
+	}
+	if len(repo.Topics) > 0 {
+		fmt.Fprintf(&sb, "Topics: %s\n\n", strings.Join(repo.Topics, ", "))
+	}
+
+	readme, err := g.getRaw(ctx, repoPath+"/readme", url.Values{"ref": {ref}})
+	if err != nil && err != errGitHubNotFound {
+		return nil, err
+	}
+	if len(readme) > 0 {
+		fmt.Fprintf(&sb, "## README\n\n%s\n\n", strings.TrimSpace(string(readme)))
+	}
+	if sb.Len() > maxBytes {
+		return nil, fmt.Errorf("GitHub README is larger than the %d MB allowed", maxBytes>>20)
+	}
+
+	var tree struct {
+		Tree []githubTreeEntry `json:"tree"`
+	}
+	if err := g.getJSON(ctx, repoPath+"/git/trees/"+url.PathEscape(ref), url.Values{"recursive": {"1"}}, &tree); err != nil {
+		return nil, err
+	}
+
+	// Import the docs that fit, in path order, leaving room for the rest
+	imported := 0
+	for _, entry := range docFiles(tree.Tree) {
+		if imported == maxRepoDocs {
+			break
+		}
+		if sb.Len()+entry.Size > maxBytes {
+			continue
+		}
+		data, err := g.getRaw(ctx, repoPath+"/contents/"+escapePath(entry.Path), url.Values{"ref": {ref}})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&sb, "## %s\n\n%s\n\n", entry.Path, strings.TrimSpace(string(data)))
+		imported++
+	}
+
+	if options["structure"] == "true" {
+		fmt.Fprintf(&sb, "## Code structure\n\n```\n%s```\n", codeStructure(tree.Tree))
+	}
+
+	if len(readme) == 0 && imported == 0 && options["structure"] != "true" {
+		return nil, fmt.Errorf("GitHub repository %s has no README or docs", repo.FullName)
+	}
+	if sb.Len() > maxBytes {
+		return nil, fmt.Errorf("GitHub content is larger than the %d MB allowed", maxBytes>>20)
+	}
+
+	return []models.File{{
+		Filename: fileName(owner+"-"+name) + ".md",
+		Data:     []byte(sb.String()),
+		Type:     "text/plain",
+	}}, nil
+}
+
+// parseRepoURL returns the owner, name and optional branch of a repository URL
+func parseRepoURL(location string) (owner, name, ref string, err error) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Host != "github.com" && u.Host != "www.github.com") {
+		return "", "", "", fmt.Errorf("not a GitHub repository URL: %s", location)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("not a GitHub repository URL: %s", location)
+	}
+	owner, name = parts[0], strings.TrimSuffix(parts[1], ".git")
+	if len(parts) >= 4 && parts[2] == "tree" {
+		ref = parts[3]
+	}
+	return owner, name, ref, nil
+}
+
+// docFiles returns the documentation files of a tree sorted by path
+func docFiles(tree []githubTreeEntry) []githubTreeEntry {
+	var docs []githubTreeEntry
+	for _, entry := range tree {
+		if entry.Type != "blob" || !docExtensions[strings.ToLower(path.Ext(entry.Path))] {
+			continue
+		}
+		for _, folder := range docsFolders {
+			if strings.HasPrefix(strings.ToLower(entry.Path), folder) {
+				docs = append(docs, entry)
+				break
+			}
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Path < docs[j].Path })
+	return docs
+}
+
+// codeStructure lists the first levels of a tree as an indented outline,
+// leaving out dependency and build folders
+func codeStructure(tree []githubTreeEntry) string {
+	entries := make([]githubTreeEntry, len(tree))
+	copy(entries, tree)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	var sb strings.Builder
+	listed := 0
+	for _, entry := range entries {
+		parts := strings.Split(entry.Path, "/")
+		if len(parts) > maxTreeDepth || hasSkippedDir(parts) {
+			continue
+		}
+		if listed == maxTreeEntries {
+			sb.WriteString("...\n")
+			break
+		}
+		suffix := ""
+		if entry.Type == "tree" {
+			suffix = "/"
+		}
+		fmt.Fprintf(&sb, "%s%s%s\n", strings.Repeat("  ", len(parts)-1), parts[len(parts)-1], suffix)
+		listed++
+	}
+	return sb.String()
+}
+
+// hasSkippedDir reports whether a path is inside a dependency or build folder
+func hasSkippedDir(parts []string) bool {
+	for _, part := range parts {
+		if skippedDirs[part] {
+			return true
+		}
+	}
+	return false
+}
+
+// escapePath escapes each segment of a repository path
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// getJSON calls the GitHub API and decodes the JSON response
+func (g *GitHub) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	body, err := g.get(ctx, path, query, "application/vnd.github+json")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(io.LimitReader(body, 50<<20)).Decode(v)
+}
+
+// getRaw calls the GitHub API for the raw content of a file
+func (g *GitHub) getRaw(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	body, err := g.get(ctx, path, query, "application/vnd.github.raw")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(io.LimitReader(body, 50<<20))
+}
+
+// get calls the GitHub API and returns the body of a successful response
+func (g *GitHub) get(ctx context.Context, path string, query url.Values, accept string) (io.ReadCloser, error) {
+	u := g.apiURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call GitHub: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errGitHubNotFound
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		resp.Body.Close()
+		return nil, fmt.Errorf("GitHub rate limit exceeded, please try again later")
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("GitHub returned status %d", resp.StatusCode)
+	}
+}
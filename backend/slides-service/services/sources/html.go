@@ -0,0 +1,142 @@
+package sources
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blankLines matches runs of blank lines left by nested block elements
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// htmlToMarkdown converts the HTML of a wiki page to markdown, keeping the
+// headings, lists, tables and emphasis the slides are structured from
+func htmlToMarkdown(content string) string {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+	var sb strings.Builder
+	writeMarkdown(&sb, doc, 0)
+	return strings.TrimSpace(blankLines.ReplaceAllString(sb.String(), "\n\n"))
+}
+
+// writeMarkdown writes a node and its children as markdown
+func writeMarkdown(sb *strings.Builder, n *html.Node, listDepth int) {
+	if n.Type == html.TextNode {
+		text := strings.Join(strings.Fields(n.Data), " ")
+		// Keep the spaces around inline elements, without doubling them
+		if n.Data != "" && strings.TrimLeft(n.Data, " \n\t") != n.Data && !endsWithSpace(sb) {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(text)
+		if text != "" && strings.TrimRight(n.Data, " \n\t") != n.Data {
+			sb.WriteString(" ")
+		}
+		return
+	}
+	if n.Type != html.ElementNode && n.Type != html.DocumentNode {
+		return
+	}
+
+	children := func(depth int) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeMarkdown(sb, c, depth)
+		}
+	}
+
+	switch n.Data {
+	case "script", "style", "head":
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		sb.WriteString("\n\n" + strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+		children(listDepth)
+		sb.WriteString("\n\n")
+	case "p", "div", "section", "blockquote":
+		sb.WriteString("\n\n")
+		children(listDepth)
+		sb.WriteString("\n\n")
+	case "br":
+		sb.WriteString("\n")
+	case "ul", "ol":
+		// Nested lists continue the list of their parent
+		if listDepth > 0 {
+			children(listDepth + 1)
+			break
+		}
+		sb.WriteString("\n")
+		children(listDepth + 1)
+		sb.WriteString("\n\n")
+	case "li":
+		sb.WriteString("\n" + strings.Repeat("  ", max(listDepth-1, 0)) + "- ")
+		children(listDepth)
+	case "strong", "b":
+		sb.WriteString("**")
+		children(listDepth)
+		sb.WriteString("**")
+	case "em", "i":
+		sb.WriteString("*")
+		children(listDepth)
+		sb.WriteString("*")
+	case "code":
+		sb.WriteString("`")
+		children(listDepth)
+		sb.WriteString("`")
+	case "pre":
+		sb.WriteString("\n\n```\n" + textContent(n) + "\n```\n\n")
+	case "tr":
+		sb.WriteString("\n|")
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "td" || c.Data == "th") {
+				sb.WriteString(" " + strings.Join(strings.Fields(textContent(c)), " ") + " |")
+			}
+		}
+		// Mark the first row as the header so the table parses as markdown
+		if n.Parent != nil && firstElement(n.Parent) == n && (n.Parent.Data != "tbody" || firstElement(n.Parent.Parent) == n.Parent) {
+			sb.WriteString("\n|")
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode && (c.Data == "td" || c.Data == "th") {
+					sb.WriteString(" --- |")
+				}
+			}
+		}
+	case "table":
+		sb.WriteString("\n\n")
+		children(listDepth)
+		sb.WriteString("\n\n")
+	default:
+		children(listDepth)
+	}
+}
+
+// endsWithSpace reports whether the markdown written so far is empty or ends
+// with a space or line break
+func endsWithSpace(sb *strings.Builder) bool {
+	s := sb.String()
+	return s == "" || strings.HasSuffix(s, " ") || strings.HasSuffix(s, "\n")
+}
+
+// firstElement returns the first element child of a node
+func firstElement(n *html.Node) *html.Node {
+	if n == nil {
+		return nil
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			return c
+		}
+	}
+	return nil
+}
+
+// textContent returns the text of a node and its children as is
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
@@ -0,0 +1,15 @@
+package sources
+
+import "testing"
+
+func TestHTMLToMarkdown(t *testing.T) {
+	content := `<h1>Onboarding</h1><p>Welcome to <strong>Acme</strong> and <em>enjoy</em> it.</p>` +
+		`<ul><li>Laptop setup</li><li>Accounts<ul><li>Email</li></ul></li></ul>` +
+		`<table><tbody><tr><th>Week</th><th>Goal</th></tr><tr><td>1</td><td>Ship a fix</td></tr></tbody></table>` +
+		`<script>alert(1)</script>`
+
+	want := "# Onboarding\n\nWelcome to **Acme** and *enjoy* it.\n\n- Laptop setup\n- Accounts\n  - Email\n\n| Week | Goal |\n| --- | --- |\n| 1 | Ship a fix |"
+	if got := htmlToMarkdown(content); got != want {
+		t.Fatalf("unexpected markdown:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,314 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+var (
+	// arxivIDPattern matches new style arXiv IDs such as 2401.12345v2 and old
+	// style ones such as hep-th/9901001
+	arxivIDPattern = regexp.MustCompile(`^(\d{4}\.\d{4,5}|[a-z-]+(\.[A-Z]{2})?/\d{7})(v\d+)?$`)
+
+	// doiPattern matches DOIs such as 10.1145/3368089.3409741
+	doiPattern = regexp.MustCompile(`^10\.\d{4,9}/\S+$`)
+)
+
+// arxivFeed is the Atom feed returned by the arXiv API
+type arxivFeed struct {
+	Entries []struct {
+		Title     string `xml:"title"`
+		Summary   string `xml:"summary"`
+		Published string `xml:"published"`
+		Authors   []struct {
+			Name string `xml:"name"`
+		} `xml:"author"`
+	} `xml:"entry"`
+}
+
+// crossrefWork is a work returned by the Crossref API
+type crossrefWork struct {
+	Title          []string `json:"title"`
+	Abstract       string   `json:"abstract"`
+	ContainerTitle []string `json:"container-title"`
+	Author         []struct {
+		Given  string `json:"given"`
+		Family string `json:"family"`
+	} `json:"author"`
+	Issued struct {
+		DateParts [][]int `json:"date-parts"`
+	} `json:"issued"`
+	Link []struct {
+		URL         string `json:"URL"`
+		ContentType string `json:"content-type"`
+	} `json:"link"`
+}
+
+// paper is the metadata of a paper and its PDF, when openly available
+type paper struct {
+	Title     string
+	Authors   []string
+	Venue     string
+	Published string
+	Abstract  string
+	PDFURL    string
+}
+
+// Papers imports research papers by arXiv ID or DOI, using the public arXiv
+// and Crossref APIs, so a paper can be turned into a talk in one request
+type Papers struct {
+	httpClient  *http.Client
+	arxivURL    string
+	arxivAPIURL string
+	crossrefURL string
+}
+
+// NewPapers creates a paper source, which only follows links to public addresses
+func NewPapers() *Papers {
+	return &Papers{
+		httpClient:  NewPublicClient(60*time.Second, maxRedirects),
+		arxivURL:    "https://arxiv.org",
+		arxivAPIURL: "https://export.arxiv.org/api/query",
+		crossrefURL: "https://api.crossref.org",
+	}
+}
+
+// Type returns the name requests use for papers
+func (p *Papers) Type() string {
+	return "paper"
+}
+
+// Fetch imports the paper with an arXiv ID or DOI, given as is, with an arXiv:
+// or doi: prefix, or as an arxiv.org or doi.org URL. It returns the abstract
+// and metadata as markdown, and the PDF when it is openly available and fits
+// in maxBytes.
+func (p *Papers) Fetch(ctx context.Context, location string, options map[string]string, maxBytes int) ([]models.File, error) {
+	arxivID, doi := parsePaperID(location)
+
+	var meta *paper
+	var err error
+	switch {
+	case arxivID != "":
+		meta, err = p.arxivPaper(ctx, arxivID)
+	case doi != "":
+		meta, err = p.crossrefPaper(ctx, doi)
+	default:
+		return nil, fmt.Errorf("not an arXiv ID or DOI: %s", location)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n\n", meta.Title)
+	if len(meta.Authors) > 0 {
+		fmt.Fprintf(&sb, "Authors: %s\n\n", strings.Join(meta.Authors, ", "))
+	}
+	if meta.Venue != "" {
+		fmt.Fprintf(&sb, "Published in: %s\n\n", meta.Venue)
+	}
+	if meta.Published != "" {
+		fmt.Fprintf(&sb, "Published: %s\n\n", meta.Published)
+	}
+	if meta.Abstract != "" {
+		fmt.Fprintf(&sb, "## Abstract\n\n%s\n", meta.Abstract)
+	}
+	files := []models.File{{
+		Filename: fileName(meta.Title) + ".md",
+		Data:     []byte(sb.String()),
+		Type:     "text/plain",
+	}}
+
+	// The PDF is optional, the abstract alone still makes a short talk
+	if meta.PDFURL != "" {
+		data, err := p.downloadPDF(ctx, meta.PDFURL, maxBytes)
+		if err == nil {
+			files = append(files, models.File{Filename: fileName(meta.Title) + ".pdf", Data: data, Type: "application/pdf"})
+		}
+	}
+	if len(files) == 1 && meta.Abstract == "" {
+		return nil, fmt.Errorf("no abstract or open access PDF found for %s", location)
+	}
+	return files, nil
+}
+
+// parsePaperID returns the arXiv ID or the DOI of a paper reference
+func parsePaperID(location string) (arxivID, doi string) {
+	ref := strings.TrimSpace(location)
+	if u, err := url.Parse(ref); err == nil && u.Host != "" {
+		switch strings.TrimPrefix(strings.ToLower(u.Host), "www.") {
+		case "arxiv.org", "export.arxiv.org":
+			for _, prefix := range []string{"/abs/", "/pdf/"} {
+				if id, ok := strings.CutPrefix(u.Path, prefix); ok {
+					ref = "arXiv:" + strings.TrimSuffix(id, ".pdf")
+				}
+			}
+		case "doi.org", "dx.doi.org":
+			ref = "doi:" + strings.TrimPrefix(u.Path, "/")
+		default:
+			return "", ""
+		}
+	}
+
+	if id, ok := cutPrefixFold(ref, "arxiv:"); ok {
+		ref = id
+	} else if id, ok := cutPrefixFold(ref, "doi:"); ok {
+		ref = id
+	}
+	// arXiv registers DOIs for its papers, which are read from arXiv directly
+	if id, ok := cutPrefixFold(ref, "10.48550/arxiv."); ok {
+		ref = id
+	}
+
+	switch {
+	case arxivIDPattern.MatchString(ref):
+		return ref, ""
+	case doiPattern.MatchString(ref):
+		return "", ref
+	}
+	return "", ""
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring case
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// arxivPaper reads the metadata of an arXiv paper
+func (p *Papers) arxivPaper(ctx context.Context, id string) (*paper, error) {
+	body, err := p.get(ctx, p.arxivAPIURL+"?"+url.Values{"id_list": {id}}.Encode(), "application/atom+xml")
+	if err != nil {
+		return nil, err
+	}
+	var feed arxivFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("invalid arXiv response: %v", err)
+	}
+	// Unknown IDs return an entry without a title
+	if len(feed.Entries) == 0 || strings.TrimSpace(feed.Entries[0].Title) == "" {
+		return nil, fmt.Errorf("arXiv paper %s not found", id)
+	}
+
+	entry := feed.Entries[0]
+	meta := &paper{
+		Title:    collapseSpace(entry.Title),
+		Abstract: collapseSpace(entry.Summary),
+		PDFURL:   p.arxivURL + "/pdf/" + id,
+	}
+	for _, author := range entry.Authors {
+		meta.Authors = append(meta.Authors, collapseSpace(author.Name))
+	}
+	if published, err := time.Parse(time.RFC3339, entry.Published); err == nil {
+		meta.Published = published.Format("2006-01-02")
+	}
+	return meta, nil
+}
+
+// crossrefPaper reads the metadata of a paper with a DOI
+func (p *Papers) crossrefPaper(ctx context.Context, doi string) (*paper, error) {
+	body, err := p.get(ctx, p.crossrefURL+"/works/"+url.PathEscape(doi), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Message crossrefWork `json:"message"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("invalid Crossref response: %v", err)
+	}
+
+	work := result.Message
+	if len(work.Title) == 0 {
+		return nil, fmt.Errorf("DOI %s has no title", doi)
+	}
+	meta := &paper{
+		Title:    collapseSpace(work.Title[0]),
+		Abstract: htmlToMarkdown(work.Abstract),
+	}
+	for _, author := range work.Author {
+		meta.Authors = append(meta.Authors, strings.TrimSpace(author.Given+" "+author.Family))
+	}
+	if len(work.ContainerTitle) > 0 {
+		meta.Venue = work.ContainerTitle[0]
+	}
+	if parts := work.Issued.DateParts; len(parts) > 0 && len(parts[0]) > 0 {
+		meta.Published = fmt.Sprint(parts[0][0])
+	}
+	for _, link := range work.Link {
+		if link.ContentType == "application/pdf" {
+			meta.PDFURL = link.URL
+			break
+		}
+	}
+	return meta, nil
+}
+
+// downloadPDF downloads a PDF, failing when it is larger than maxBytes or is
+// not a PDF, e.g. a publisher's login page
+func (p *Papers) downloadPDF(ctx context.Context, pdfURL string, maxBytes int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pdfURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBytes {
+		return nil, fmt.Errorf("PDF is larger than the %d MB allowed", maxBytes>>20)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return nil, fmt.Errorf("not a PDF")
+	}
+	return data, nil
+}
+
+// get calls a paper API and returns the response body
+func (p *Papers) get(ctx context.Context, u, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch paper: %v", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("paper not found")
+	default:
+		return nil, fmt.Errorf("paper lookup returned status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+}
+
+// collapseSpace joins the lines of a text into one, as arXiv wraps long titles
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
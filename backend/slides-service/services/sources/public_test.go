@@ -0,0 +1,49 @@
+package sources
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.0.0.1":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+		"64:ff9b::a00:1":  false,
+		"255.255.255.255": false,
+		"ff02::1":         false,
+	}
+	for address, want := range tests {
+		if got := publicAddress(netip.MustParseAddr(address)); got != want {
+			t.Errorf("%s: expected %v, got %v", address, want, got)
+		}
+	}
+}
+func TestPublicClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("%PDF-1.5"))
+	}))
+	defer server.Close()
+
+	if _, err := NewFeeds().Fetch(context.Background(), server.URL+"/feed.xml", nil, 1<<20); err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Fatalf("expected the feed on a loopback address to be refused, got %v", err)
+	}
+	if _, err := NewPapers().downloadPDF(context.Background(), server.URL+"/paper.pdf", 1<<20); err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Fatalf("expected the PDF on a loopback address to be refused, got %v", err)
+	}
+}
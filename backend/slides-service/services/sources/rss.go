@@ -0,0 +1,264 @@
+package sources
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+const (
+	// maxDigestItems is the largest number of feed items in a digest
+	maxDigestItems = 50
+
+	// defaultDigestDays is the period a digest covers when no dates are given
+	defaultDigestDays = 7
+)
+
+// feedTimeLayouts are the date formats used by RSS and Atom feeds
+var feedTimeLayouts = []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2 Jan 2006 15:04:05 -0700"}
+
+// feed is an RSS 2.0 or Atom feed
+type feed struct {
+	// RSS 2.0
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			PubDate     string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+
+	// Atom
+	Title   string `xml:"title"`
+	Entries []struct {
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+// feedItem is an item of a feed in either format
+type feedItem struct {
+	Title     string
+	Link      string
+	Summary   string
+	Published time.Time
+}
+
+// Feeds imports the items an RSS or Atom feed published in a date range as a
+// digest, such as the week's posts of a newsletter
+type Feeds struct {
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// NewFeeds creates a feed source, which only fetches feeds on public addresses
+func NewFeeds() *Feeds {
+	return &Feeds{
+		httpClient: NewPublicClient(30*time.Second, maxRedirects),
+		now:        time.Now,
+	}
+}
+
+// Type returns the name requests use for feeds
+func (f *Feeds) Type() string {
+	return "rss"
+}
+
+// Fetch imports the items of the feed at a URL as one markdown digest. The
+// since and until options are dates such as 2024-05-01 or RFC 3339 times, and
+// the days option sets since relative to until, which suits scheduled
+// digests. Without options the digest covers the last 7 days.
+func (f *Feeds) Fetch(ctx context.Context, location string, options map[string]string, maxBytes int) ([]models.File, error) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("feed location must be an http or https URL")
+	}
+	since, until, err := f.dateRange(options)
+	if err != nil {
+		return nil, err
+	}
+
+	title, items, err := f.fetchFeed(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	if title == "" {
+		title = u.Host
+	}
+	var selected []feedItem
+	for _, item := range items {
+		if !item.Published.Before(since) && item.Published.Before(until) {
+			selected = append(selected, item)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("the feed has no items between %s and %s", since.Format("2006-01-02"), until.Format("2006-01-02"))
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Published.After(selected[j].Published) })
+	if len(selected) > maxDigestItems {
+		selected = selected[:maxDigestItems]
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Digest: %s\n\n", title)
+	fmt.Fprintf(&sb, "%d new items from %s to %s\n\n", len(selected), since.Format("2 January 2006"), until.Add(-time.Second).Format("2 January 2006"))
+	for _, item := range selected {
+		fmt.Fprintf(&sb, "## %s\n\n", item.Title)
+		fmt.Fprintf(&sb, "Published: %s\n\n", item.Published.Format("2006-01-02"))
+		if item.Link != "" {
+			fmt.Fprintf(&sb, "Link: %s\n\n", item.Link)
+		}
+		if item.Summary != "" {
+			fmt.Fprintf(&sb, "%s\n\n", item.Summary)
+		}
+	}
+	if sb.Len() > maxBytes {
+		return nil, fmt.Errorf("feed digest is larger than the %d MB allowed", maxBytes>>20)
+	}
+
+	return []models.File{{
+		Filename: fileName(title+" digest") + ".md",
+		Data:     []byte(sb.String()),
+		Type:     "text/plain",
+	}}, nil
+}
+
+// dateRange returns the start and exclusive end of the digest period
+func (f *Feeds) dateRange(options map[string]string) (time.Time, time.Time, error) {
+	for key := range options {
+		if key != "since" && key != "until" && key != "days" {
+			return time.Time{}, time.Time{}, fmt.Errorf("unknown feed option: %s", key)
+		}
+	}
+
+	until := f.now()
+	if value := options["until"]; value != "" {
+		t, err := parseDate(value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid until: %v", err)
+		}
+		// A date includes the whole day
+		if len(value) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1)
+		}
+		until = t
+	}
+
+	days := defaultDigestDays
+	if value := options["days"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 366 {
+			return time.Time{}, time.Time{}, fmt.Errorf("days must be between 1 and 366")
+		}
+		days = n
+	}
+	since := until.AddDate(0, 0, -days)
+	if value := options["since"]; value != "" {
+		t, err := parseDate(value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid since: %v", err)
+		}
+		since = t
+	}
+
+	if !since.Before(until) {
+		return time.Time{}, time.Time{}, fmt.Errorf("since must be before until")
+	}
+	return since, until, nil
+}
+
+// parseDate parses a date such as 2024-05-01 or an RFC 3339 time
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// fetchFeed downloads and parses a feed
+func (f *Feeds) fetchFeed(ctx context.Context, location string) (string, []feedItem, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml, text/xml")
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch feed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("feed returned status %d", resp.StatusCode)
+	}
+
+	var doc feed
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&doc); err != nil {
+		return "", nil, fmt.Errorf("not an RSS or Atom feed: %v", err)
+	}
+
+	var items []feedItem
+	title := doc.Channel.Title
+	for _, item := range doc.Channel.Items {
+		items = append(items, feedItem{
+			Title:     collapseSpace(item.Title),
+			Link:      strings.TrimSpace(item.Link),
+			Summary:   htmlToMarkdown(item.Description),
+			Published: parseFeedTime(item.PubDate),
+		})
+	}
+	if title == "" {
+		title = doc.Title
+	}
+	for _, entry := range doc.Entries {
+		item := feedItem{
+			Title:     collapseSpace(entry.Title),
+			Summary:   htmlToMarkdown(entry.Summary),
+			Published: parseFeedTime(entry.Published),
+		}
+		if item.Summary == "" {
+			item.Summary = htmlToMarkdown(entry.Content)
+		}
+		if item.Published.IsZero() {
+			item.Published = parseFeedTime(entry.Updated)
+		}
+		for _, link := range entry.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				item.Link = link.Href
+				break
+			}
+		}
+		items = append(items, item)
+	}
+	return collapseSpace(title), items, nil
+}
+
+// parseFeedTime parses the date of a feed item, returning the zero time, which
+// is outside every digest, when it has none
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
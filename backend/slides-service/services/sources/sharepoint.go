@@ -0,0 +1,158 @@
+package sources
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// graphURL is the Microsoft Graph API
+const graphURL = "https://graph.microsoft.com/v1.0"
+
+// convertibleExtensions are the Office documents Graph converts to PDF
+var convertibleExtensions = map[string]bool{
+	".doc": true, ".docx": true, ".ppt": true, ".pptx": true, ".odt": true, ".odp": true, ".rtf": true,
+}
+
+// driveItem is a SharePoint file returned by Microsoft Graph
+type driveItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	File *struct {
+		MimeType string `json:"mimeType"`
+	} `json:"file"`
+	ParentReference struct {
+		DriveID string `json:"driveId"`
+	} `json:"parentReference"`
+}
+
+// SharePoint imports SharePoint documents through Microsoft Graph, as an Entra
+// ID app granted Sites.Read.All
+type SharePoint struct {
+	httpClient *http.Client
+	graphURL   string
+}
+
+// NewSharePoint creates a SharePoint source authenticated with the client
+// credentials of an app registered in the tenant
+func NewSharePoint(tenantID, clientID, clientSecret string) *SharePoint {
+	config := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", url.PathEscape(tenantID)),
+		Scopes:       []string{"https://graph.microsoft.com/.default"},
+	}
+	client := config.Client(context.Background())
+	client.Timeout = 60 * time.Second
+	return &SharePoint{
+		httpClient: client,
+		graphURL:   graphURL,
+	}
+}
+
+// Type returns the name requests use for SharePoint
+func (s *SharePoint) Type() string {
+	return "sharepoint"
+}
+
+// Fetch downloads the SharePoint document at a URL, converting Word and
+// PowerPoint documents to PDF
+func (s *SharePoint) Fetch(ctx context.Context, location string, options map[string]string, maxBytes int) ([]models.File, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "https" || !strings.HasSuffix(strings.ToLower(u.Hostname()), ".sharepoint.com") {
+		return nil, fmt.Errorf("not a SharePoint document URL: %s", location)
+	}
+
+	var item driveItem
+	if err := s.getJSON(ctx, s.graphURL+"/shares/"+shareID(location)+"/driveItem", &item); err != nil {
+		return nil, err
+	}
+	if item.File == nil {
+		return nil, fmt.Errorf("SharePoint item %s is not a document", item.Name)
+	}
+
+	content := fmt.Sprintf("%s/drives/%s/items/%s/content", s.graphURL, url.PathEscape(item.ParentReference.DriveID), url.PathEscape(item.ID))
+	name, mimeType := item.Name, item.File.MimeType
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case convertibleExtensions[ext]:
+		content += "?format=pdf"
+		name, mimeType = strings.TrimSuffix(name, path.Ext(name))+".pdf", "application/pdf"
+	case ext == ".pdf":
+		mimeType = "application/pdf"
+	case ext == ".md" || ext == ".txt":
+		mimeType = "text/plain"
+	default:
+		return nil, fmt.Errorf("unsupported SharePoint document %s, only PDF, Markdown, text, Word and PowerPoint documents are allowed", name)
+	}
+	if item.Size > int64(maxBytes) && !convertibleExtensions[ext] {
+		return nil, fmt.Errorf("SharePoint document %s is larger than the %d MB allowed", name, maxBytes>>20)
+	}
+
+	resp, err := s.get(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SharePoint document %s: %v", name, err)
+	}
+	if len(data) > maxBytes {
+		return nil, fmt.Errorf("SharePoint document %s is larger than the %d MB allowed", name, maxBytes>>20)
+	}
+
+	return []models.File{{
+		Filename: name,
+		Data:     data,
+		Type:     mimeType,
+	}}, nil
+}
+
+// getJSON calls Microsoft Graph and decodes the JSON response
+func (s *SharePoint) getJSON(ctx context.Context, url string, v interface{}) error {
+	resp, err := s.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// get calls Microsoft Graph, returning the response when it succeeds
+func (s *SharePoint) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Microsoft Graph: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		resp.Body.Close()
+		return nil, fmt.Errorf("SharePoint document not found or not readable by the import app")
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("Microsoft Graph returned status %d", resp.StatusCode)
+	}
+}
+
+// shareID encodes a sharing or document URL as a Graph share ID
+func shareID(location string) string {
+	return "u!" + base64.RawURLEncoding.EncodeToString([]byte(location))
+}
@@ -0,0 +1,42 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/martin226/slideitin/backend/slides-service/models"
+)
+
+// ContentSource fetches documents from an external system, such as a wiki,
+// so they can be turned into a deck without a manual export
+type ContentSource interface {
+	// Type is the name requests refer to the source by, e.g. confluence
+	Type() string
+
+	// Fetch returns the documents at a location, such as the URL of a page,
+	// with source specific options. Documents larger than maxBytes are rejected.
+	Fetch(ctx context.Context, location string, options map[string]string, maxBytes int) ([]models.File, error)
+}
+
+// Registry holds the content sources configured on this instance
+type Registry struct {
+	sources map[string]ContentSource
+}
+
+// NewRegistry creates a registry of content sources
+func NewRegistry(sources ...ContentSource) *Registry {
+	r := &Registry{sources: make(map[string]ContentSource, len(sources))}
+	for _, source := range sources {
+		r.sources[source.Type()] = source
+	}
+	return r
+}
+
+// Fetch returns the documents at a location of the source of the given type
+func (r *Registry) Fetch(ctx context.Context, sourceType, location string, options map[string]string, maxBytes int) ([]models.File, error) {
+	source, ok := r.sources[sourceType]
+	if !ok {
+		return nil, fmt.Errorf("the %s content source is not configured", sourceType)
+	}
+	return source.Fetch(ctx, location, options, maxBytes)
+}
@@ -0,0 +1,246 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfluenceFetchesPageAsMarkdown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, token, _ := r.BasicAuth(); user != "bot@acme.com" || token != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/wiki/rest/api/content/12345" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":    "12345",
+			"title": "Onboarding/Week 1",
+			"body":  map[string]interface{}{"view": map[string]string{"value": "<p>Set up your <strong>laptop</strong>.</p>"}},
+		})
+	}))
+	defer server.Close()
+
+	source, err := NewConfluence(server.URL, "bot@acme.com", "token")
+	if err != nil {
+		t.Fatalf("NewConfluence failed: %v", err)
+	}
+	files, err := source.Fetch(context.Background(), server.URL+"/wiki/spaces/ENG/pages/12345/Onboarding", nil, 1<<20)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Filename != "Onboarding-Week 1.md" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	if got := string(files[0].Data); got != "# Onboarding/Week 1\n\nSet up your **laptop**.\n\n" {
+		t.Fatalf("unexpected content: %q", got)
+	}
+
+	if _, err := source.Fetch(context.Background(), "https://evil.example.com/wiki/spaces/ENG/pages/12345", nil, 1<<20); err == nil {
+		t.Fatal("expected URLs on other hosts to be rejected")
+	}
+}
+
+func TestSharePointConvertsOfficeDocumentsToPDF(t *testing.T) {
+	location := "https://acme.sharepoint.com/sites/hr/Shared%20Documents/Handbook.docx"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/shares/"+shareID(location)+"/driveItem":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":              "item-1",
+				"name":            "Handbook.docx",
+				"size":            2048,
+				"file":            map[string]string{"mimeType": "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+				"parentReference": map[string]string{"driveId": "drive-1"},
+			})
+		case r.URL.Path == "/drives/drive-1/items/item-1/content" && r.URL.Query().Get("format") == "pdf":
+			w.Write([]byte("%PDF-1.7"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	source := &SharePoint{httpClient: server.Client(), graphURL: server.URL}
+	files, err := source.Fetch(context.Background(), location, nil, 1<<20)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Filename != "Handbook.pdf" || files[0].Type != "application/pdf" || string(files[0].Data) != "%PDF-1.7" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	if _, err := source.Fetch(context.Background(), "https://example.com/Handbook.docx", nil, 1<<20); err == nil {
+		t.Fatal("expected non-SharePoint URLs to be rejected")
+	}
+}
+
+func TestRegistryRejectsUnconfiguredSources(t *testing.T) {
+	_, err := NewRegistry().Fetch(context.Background(), "confluence", "https://acme.atlassian.net/wiki/spaces/ENG", nil, 1<<20)
+	if err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Fatalf("expected an unconfigured source error, got %v", err)
+	}
+}
+
+func TestGitHubImportsReadmeDocsAndStructure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/acme/widget":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"full_name":      "acme/widget",
+				"description":    "Widgets as a service",
+				"default_branch": "main",
+				"language":       "Go",
+			})
+		case r.URL.Path == "/repos/acme/widget/readme" && r.URL.Query().Get("ref") == "main":
+			w.Write([]byte("# Widget\n\nMakes widgets."))
+		case r.URL.Path == "/repos/acme/widget/git/trees/main":
+			json.NewEncoder(w).Encode(map[string]interface{}{"tree": []map[string]interface{}{
+				{"path": "README.md", "type": "blob", "size": 24},
+				{"path": "cmd", "type": "tree"},
+				{"path": "cmd/main.go", "type": "blob", "size": 100},
+				{"path": "docs", "type": "tree"},
+				{"path": "docs/architecture.md", "type": "blob", "size": 30},
+				{"path": "docs/logo.png", "type": "blob", "size": 5000},
+				{"path": "vendor", "type": "tree"},
+				{"path": "vendor/lib.go", "type": "blob", "size": 100},
+			}})
+		case r.URL.Path == "/repos/acme/widget/contents/docs/architecture.md":
+			w.Write([]byte("Three services talk over gRPC."))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	source := &GitHub{httpClient: server.Client(), apiURL: server.URL}
+	files, err := source.Fetch(context.Background(), "https://github.com/acme/widget", map[string]string{"structure": "true"}, 1<<20)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Filename != "acme-widget.md" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	want := "# acme/widget\n\nWidgets as a service\n\nPrimary language: Go\n\n" +
+		"## README\n\n# Widget\n\nMakes widgets.\n\n" +
+		"## docs/architecture.md\n\nThree services talk over gRPC.\n\n" +
+		"## Code structure\n\n```\nREADME.md\ncmd/\n  main.go\ndocs/\n  architecture.md\n  logo.png\n```\n"
+	if got := string(files[0].Data); got != want {
+		t.Fatalf("unexpected content:\n%s", got)
+	}
+
+	if _, err := source.Fetch(context.Background(), "https://gitlab.com/acme/widget", nil, 1<<20); err == nil {
+		t.Fatal("expected non-GitHub URLs to be rejected")
+	}
+	if _, err := source.Fetch(context.Background(), "https://github.com/acme/missing", nil, 1<<20); err == nil {
+		t.Fatal("expected a missing repository to fail")
+	}
+}
+
+func TestParsePaperID(t *testing.T) {
+	tests := []struct {
+		location, arxivID, doi string
+	}{
+		{"2401.12345", "2401.12345", ""},
+		{"arXiv:2401.12345v2", "2401.12345v2", ""},
+		{"https://arxiv.org/abs/hep-th/9901001", "hep-th/9901001", ""},
+		{"https://arxiv.org/pdf/2401.12345.pdf", "2401.12345", ""},
+		{"10.48550/arXiv.2401.12345", "2401.12345", ""},
+		{"doi:10.1145/3368089.3409741", "", "10.1145/3368089.3409741"},
+		{"https://doi.org/10.1038/nature14539", "", "10.1038/nature14539"},
+		{"https://example.com/paper.pdf", "", ""},
+		{"not a paper", "", ""},
+	}
+	for _, tt := range tests {
+		arxivID, doi := parsePaperID(tt.location)
+		if arxivID != tt.arxivID || doi != tt.doi {
+			t.Errorf("parsePaperID(%q) = %q, %q, want %q, %q", tt.location, arxivID, doi, tt.arxivID, tt.doi)
+		}
+	}
+}
+
+func TestPapersFetchesArxivAbstractAndPDF(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/query" && r.URL.Query().Get("id_list") == "2401.12345":
+			w.Write([]byte(`<feed xmlns="http://www.w3.org/2005/Atom"><entry>
+				<title>Attention Is
+				  Still All You Need</title>
+				<summary>We revisit attention.</summary>
+				<published>2024-01-22T18:00:00Z</published>
+				<author><name>Ada Lovelace</name></author>
+				<author><name>Alan Turing</name></author>
+			</entry></feed>`))
+		case r.URL.Path == "/pdf/2401.12345":
+			w.Write([]byte("%PDF-1.5"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	source := &Papers{httpClient: server.Client(), arxivURL: server.URL, arxivAPIURL: server.URL + "/api/query"}
+	files, err := source.Fetch(context.Background(), "arXiv:2401.12345", nil, 1<<20)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(files) != 2 || files[1].Filename != "Attention Is Still All You Need.pdf" || string(files[1].Data) != "%PDF-1.5" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	want := "# Attention Is Still All You Need\n\nAuthors: Ada Lovelace, Alan Turing\n\nPublished: 2024-01-22\n\n## Abstract\n\nWe revisit attention.\n"
+	if got := string(files[0].Data); got != want {
+		t.Fatalf("unexpected abstract:\n%s", got)
+	}
+
+	// A PDF over the limit is left out, the abstract is still imported
+	files, err = source.Fetch(context.Background(), "2401.12345", nil, 4)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected only the abstract, got %+v, %v", files, err)
+	}
+}
+
+func TestFeedsDigestsItemsInRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel>
+			<title>Platform Weekly</title>
+			<item><title>Old news</title><pubDate>Mon, 29 Apr 2024 09:00:00 +0000</pubDate></item>
+			<item><title>Postgres 16 upgrade</title><link>https://example.com/pg16</link>
+				<description>&lt;p&gt;We upgraded &lt;strong&gt;all&lt;/strong&gt; clusters.&lt;/p&gt;</description>
+				<pubDate>Thu, 09 May 2024 12:00:00 +0000</pubDate></item>
+			<item><title>On-call changes</title><pubDate>Mon, 06 May 2024 08:00:00 GMT</pubDate></item>
+		</channel></rss>`))
+	}))
+	defer server.Close()
+
+	source := &Feeds{httpClient: server.Client(), now: func() time.Time { return time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC) }}
+	files, err := source.Fetch(context.Background(), server.URL+"/feed.xml", nil, 1<<20)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	want := "# Digest: Platform Weekly\n\n2 new items from 3 May 2024 to 9 May 2024\n\n" +
+		"## Postgres 16 upgrade\n\nPublished: 2024-05-09\n\nLink: https://example.com/pg16\n\nWe upgraded **all** clusters.\n\n" +
+		"## On-call changes\n\nPublished: 2024-05-06\n\n"
+	if len(files) != 1 || files[0].Filename != "Platform Weekly digest.md" || string(files[0].Data) != want {
+		t.Fatalf("unexpected files: %+v\n%s", files, files[0].Data)
+	}
+
+	// An explicit date range includes both days
+	files, err = source.Fetch(context.Background(), server.URL+"/feed.xml", map[string]string{"since": "2024-04-29", "until": "2024-04-29"}, 1<<20)
+	if err != nil || !strings.Contains(string(files[0].Data), "Old news") || strings.Contains(string(files[0].Data), "On-call") {
+		t.Fatalf("expected only the old item, got %v", err)
+	}
+
+	if _, err := source.Fetch(context.Background(), server.URL+"/feed.xml", map[string]string{"since": "2023-01-01", "until": "2023-01-31"}, 1<<20); err == nil {
+		t.Fatal("expected a range without items to fail")
+	}
+	if _, err := source.Fetch(context.Background(), server.URL+"/feed.xml", map[string]string{"days": "0"}, 1<<20); err == nil {
+		t.Fatal("expected an invalid days option to fail")
+	}
+}
@@ -27,13 +27,15 @@ const FileUpload = ({ onNext, onBack, initialFiles }: {
     e.preventDefault()
     setIsDragging(false)
     
-    // allow PDF, MD, TXT - check both MIME type and file extension for .md files
+    // allow PDF, MD, TXT, VTT, SRT - check both MIME type and file extension for .md and transcript files
     const droppedFiles = Array.from(e.dataTransfer.files).filter(
       (file) => 
         file.type === "application/pdf" || 
         file.type === "text/markdown" || 
         file.type === "text/plain" ||
-        file.name.toLowerCase().endsWith('.md')
+        file.name.toLowerCase().endsWith('.md') ||
+        file.name.toLowerCase().endsWith('.vtt') ||
+        file.name.toLowerCase().endsWith('.srt')
     )
     
     if (droppedFiles.length > 0) {
@@ -48,7 +50,9 @@ const FileUpload = ({ onNext, onBack, initialFiles }: {
           file.type === "application/pdf" || 
           file.type === "text/markdown" || 
           file.type === "text/plain" ||
-          file.name.toLowerCase().endsWith('.md')
+          file.name.toLowerCase().endsWith('.md') ||
+          file.name.toLowerCase().endsWith('.vtt') ||
+          file.name.toLowerCase().endsWith('.srt')
       )
       setFiles((prev) => [...prev, ...selectedFiles])
     }
@@ -117,7 +121,7 @@ const FileUpload = ({ onNext, onBack, initialFiles }: {
                 id="file-upload"
                 className="hidden"
                 multiple
-                accept=".pdf, .md, .txt"
+                accept=".pdf, .md, .txt, .vtt, .srt"
                 onChange={handleFileInput}
               />
               <label
@@ -126,7 +130,7 @@ const FileUpload = ({ onNext, onBack, initialFiles }: {
               >
                 <span>Browse Files</span>
               </label>
-              <p className="text-xs md:text-sm text-gray-500 mt-4 md:mt-6">Supports PDF, MD, and TXT files</p>
+              <p className="text-xs md:text-sm text-gray-500 mt-4 md:mt-6">Supports PDF, MD, TXT, and VTT or SRT transcripts</p>
             </motion.div>
           </motion.div>
         </div>
@@ -23,6 +23,7 @@ export default function UploadFlow({ onBack }: { onBack?: () => void }) {
     }
   })
   const [resultUrl, setResultUrl] = useState<string | null>(null)
+  const [claimToken, setClaimToken] = useState<string | undefined>(undefined)
 
   const nextStep = () => {
     setStep((prev) => prev + 1)
@@ -43,6 +44,7 @@ export default function UploadFlow({ onBack }: { onBack?: () => void }) {
       }
     })
     setResultUrl(null)
+    setClaimToken(undefined)
     setStep(1)
   }
 
@@ -68,10 +70,11 @@ export default function UploadFlow({ onBack }: { onBack?: () => void }) {
   }
 
   // Handle job completion - called when job status is "completed"
-  const handleJobCompletion = (jobData: { resultUrl: string }) => {
+  const handleJobCompletion = (jobData: { resultUrl: string; claimToken?: string }) => {
     console.log("Job completion handler called with:", jobData);
     console.log("Setting resultUrl to:", jobData.resultUrl);
     setResultUrl(jobData.resultUrl)
+    setClaimToken(jobData.claimToken)
     console.log("Transitioning to result step");
     nextStep()
   }
@@ -161,11 +164,12 @@ export default function UploadFlow({ onBack }: { onBack?: () => void }) {
               />}
               {step === 4 && <Success 
                 data={data}
-                onComplete={(jobData: { resultUrl: string }) => handleJobCompletion(jobData)}
+                onComplete={(jobData: { resultUrl: string; claimToken?: string }) => handleJobCompletion(jobData)}
               />}
               {step === 5 && <Result 
                 onRestart={restartFlow} 
                 resultUrl={resultUrl!} 
+                claimToken={claimToken}
               />}
             </motion.div>
           </AnimatePresence>
@@ -1,15 +1,16 @@
 "use client"
 
-import { RefreshCw, X, Download, Edit } from "lucide-react"
+import { RefreshCw, X, Download, Edit, FileArchive } from "lucide-react"
 import { useState } from "react"
-import { API_BASE_URL } from "@/lib/api"
+import { resultLink } from "@/lib/api"
 
 interface ResultProps {
   onRestart?: () => void;
   resultUrl: string;
+  claimToken?: string;
 }
 
-const Result = ({ onRestart, resultUrl }: ResultProps) => {
+const Result = ({ onRestart, resultUrl, claimToken }: ResultProps) => {
   const [tutorialOpen, setTutorialOpen] = useState(false)
   
   console.log("Result component rendered with resultUrl:", resultUrl);
@@ -65,7 +66,11 @@ const Result = ({ onRestart, resultUrl }: ResultProps) => {
   };
 
   const handleDownload = () => {
-    window.open(API_BASE_URL + resultUrl + "?download=true", '_blank');
+    window.open(resultLink(resultUrl, "download=true", claimToken), '_blank');
+  };
+
+  const handleDownloadBundle = () => {
+    window.open(resultLink(resultUrl, "format=zip", claimToken), '_blank');
   };
 
   const handleEdit = () => {
@@ -91,7 +96,8 @@ const Result = ({ onRestart, resultUrl }: ResultProps) => {
           {/* Responsive iframe container with 16:9 aspect ratio */}
           <div className="w-full relative shadow-lg" style={{ paddingBottom: "56.25%" }}>
             <iframe 
-              src={API_BASE_URL + resultUrl}
+              src={resultLink(resultUrl, "view=sandbox", claimToken)}
+              sandbox="allow-scripts"
               className="absolute top-0 left-0 w-full h-full rounded-lg"
               title="Slides viewer"
             />
@@ -106,6 +112,14 @@ const Result = ({ onRestart, resultUrl }: ResultProps) => {
               <Download size={16} />
               Download as PDF
             </button>
+
+            <button 
+              className="py-2 px-4 rounded-lg bg-amber-500 hover:bg-amber-600 transition-colors flex items-center justify-center gap-2 text-white font-medium"
+              onClick={handleDownloadBundle}
+            >
+              <FileArchive size={16} />
+              Download everything (ZIP)
+            </button>
             
             <button 
               onClick={handleEdit}
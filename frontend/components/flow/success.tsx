@@ -6,7 +6,7 @@ import { useState, useEffect, useRef, useCallback } from "react"
 import { generateSlides, subscribeToSlideUpdates, SlideRequest, SlideUpdate } from "../../lib/api"
 
 interface SuccessProps {
-  onComplete: (jobData: { resultUrl: string }) => void;
+  onComplete: (jobData: { resultUrl: string; claimToken?: string }) => void;
   data: {
     theme: string;
     files: File[];
@@ -23,6 +23,7 @@ const Success = ({ onComplete, data }: SuccessProps) => {
   const [error, setError] = useState<string | null>(null)
   const generationStarted = useRef(false)
   const jobCompleted = useRef(false)
+  const claimToken = useRef<string | undefined>(undefined)
 
   const handleStatusUpdate = useCallback((update: SlideUpdate) => {
     // Update status message
@@ -32,19 +33,13 @@ const Success = ({ onComplete, data }: SuccessProps) => {
     // Update progress based on status
     if (update.status === "queued") {
       setProgress(10);
+    } else if (update.status === "uploading") {
+      setProgress(30);
     } else if (update.status === "processing") {
-      // Determine progress based on specific status messages
-      if (update.message.includes("Analyzing")) {
-        setProgress(30);
-      } else if (update.message.includes("Generating content")) {
-        setProgress(50);
-      } else if (update.message.includes("Creating presentation")) {
-        setProgress(70);
-      } else if (update.message.includes("Finalizing")) {
-        setProgress(90);
-      } else {
-        setProgress(50); // Default for processing
-      }
+      // Writing the slides is the longest stage, move on once Gemini is called
+      setProgress(update.message.includes("Creating presentation") ? 70 : 50);
+    } else if (update.status === "rendering") {
+      setProgress(90);
     } else if (update.status === "completed") {
       setProgress(100);
       console.log("Job completed with resultUrl:", update.resultUrl);
@@ -58,10 +53,10 @@ const Success = ({ onComplete, data }: SuccessProps) => {
       // Move to next step after a brief delay to show completion
       setTimeout(() => {
         console.log("Calling onComplete with:", { resultUrl: jobResultUrl });
-        onComplete({ resultUrl: jobResultUrl });
+        onComplete({ resultUrl: jobResultUrl, claimToken: claimToken.current });
       }, 1000);
     } else if (update.status === "failed") {
-      setError(update.message || "Job failed");
+      setError(update.message || `Job ${update.status}`);
       // Mark failed jobs as completed too
       jobCompleted.current = true;
     }
@@ -91,9 +86,13 @@ const Success = ({ onComplete, data }: SuccessProps) => {
         // No need to store job ID as state since it's never used
         setStatus(response.message || "Job submitted successfully");
         
+        // Anonymous jobs are only accessible with their claim token
+        claimToken.current = response.claimToken;
+        
         // Subscribe to status updates via SSE using the response ID directly
         cleanup = subscribeToSlideUpdates(
           response.id,
+          response.claimToken,
           handleStatusUpdate,
           (error) => {
             // Only set error if we haven't completed the job yet
@@ -19,20 +19,33 @@ export interface SlideResponse {
   message: string;
   createdAt: number;
   updatedAt: number;
+  // Gives access to the job and its result when it was created anonymously
+  claimToken?: string;
 }
 
+// Stages a job goes through, ending in completed or failed
+export type JobStatus =
+  | 'queued'
+  | 'uploading'
+  | 'processing'
+  | 'rendering'
+  | 'completed'
+  | 'failed';
+
 export interface SlideUpdate {
   id: string;
-  status: string;
+  status: JobStatus;
   message: string;
   resultUrl: string;
   updatedAt: number;
 }
 
-// Generate slides by sending data and files to the backend
+// Generate slides by sending data and files to the backend, with the token of
+// the CAPTCHA the user solved when the API requires one for anonymous jobs
 export async function generateSlides(
   data: SlideRequest,
-  files: File[]
+  files: File[],
+  captchaToken?: string
 ): Promise<SlideResponse> {
   const formData = new FormData();
   
@@ -47,6 +60,7 @@ export async function generateSlides(
   try {
     const response = await fetch(`${API_BASE_URL}/generate`, {
       method: 'POST',
+      headers: captchaToken ? { 'X-Captcha-Token': captchaToken } : undefined,
       body: formData,
     });
     
@@ -62,14 +76,23 @@ export async function generateSlides(
   }
 }
 
+// Build the URL of a result document, with the claim token of an anonymous
+// job, since links and iframes can't send it as a header
+export function resultLink(resultUrl: string, query: string, claimToken?: string): string {
+  const claim = claimToken ? `&claim=${encodeURIComponent(claimToken)}` : '';
+  return `${API_BASE_URL}${resultUrl}?${query}${claim}`;
+}
+
 // Create an EventSource for server-sent events to get status updates
 export function subscribeToSlideUpdates(
   slideId: string,
+  claimToken: string | undefined,
   onUpdate: (update: SlideUpdate) => void,
   onError: (error: Error) => void
 ): () => void {
-  // Create EventSource for SSE connection
-  const eventSource = new EventSource(`${API_BASE_URL}/slides/${slideId}`);
+  // Create EventSource for SSE connection, which can't send the claim token as a header
+  const claim = claimToken ? `?claim=${encodeURIComponent(claimToken)}` : '';
+  const eventSource = new EventSource(`${API_BASE_URL}/slides/${slideId}${claim}`);
   
   // Handle normal update events
   eventSource.addEventListener('update', (event) => {
@@ -77,7 +100,7 @@ export function subscribeToSlideUpdates(
       const data = JSON.parse(event.data);
       onUpdate(data);
       
-      // If the status is completed or failed, prepare for stream to end
+      // If the job is finished, prepare for stream to end
       if (data.status === 'completed' || data.status === 'failed') {
         console.log(`Job ${data.status}. Stream will close soon.`);
       }